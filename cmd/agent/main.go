@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"math/rand"
+	"net/http"
 	"os"
 	"os/signal"
 	"runtime"
@@ -15,12 +16,17 @@ import (
 
 	"github.com/shirou/gopsutil/v3/cpu"
 	"github.com/shirou/gopsutil/v3/mem"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 
 	"github.com/mutualEvg/metrics-server/internal/agent"
 	"github.com/mutualEvg/metrics-server/internal/collector"
 	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/deadletter"
 	"github.com/mutualEvg/metrics-server/internal/grpcclient"
 	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/natsclient"
+	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/mutualEvg/metrics-server/internal/worker"
 )
 
@@ -44,10 +50,23 @@ func main() {
 	// Parse configuration
 	config := agent.ParseConfig()
 
-	// Determine if we should use gRPC or HTTP
+	// Only check the config and connectivity, e.g. in a pre-deploy gate
+	if config.ValidateOnly {
+		if err := validateAgent(os.Stdout, config); err != nil {
+			fmt.Fprintf(os.Stderr, "Validation failed: %v\n", err)
+			os.Exit(1)
+		}
+		fmt.Println("Validation passed")
+		return
+	}
+
+	// Determine if we should use gRPC, NATS or HTTP
 	if config.GRPCAddress != "" {
 		// Run gRPC-based agent
 		runGRPCAgent(config)
+	} else if config.NATSAddress != "" {
+		// Run NATS-based agent
+		runNATSAgent(config)
 	} else {
 		// Run HTTP-based agent (original behavior)
 		runHTTPAgent(config)
@@ -57,13 +76,30 @@ func main() {
 func runGRPCAgent(config *agent.Config) {
 	log.Println("Starting agent with gRPC protocol")
 
-	// Create gRPC client
-	grpcClient, err := grpcclient.NewMetricsClient(config.GRPCAddress)
+	// Create gRPC client, using TLS if a CA certificate is configured
+	var grpcClient *grpcclient.MetricsClient
+	var err error
+	if config.GRPCCA != "" {
+		grpcClient, err = grpcclient.NewMetricsClientTLS(config.GRPCAddress, config.GRPCCA)
+		log.Printf("gRPC TLS enabled with CA: %s", config.GRPCCA)
+	} else {
+		grpcClient, err = grpcclient.NewMetricsClient(config.GRPCAddress)
+	}
 	if err != nil {
 		log.Fatalf("Failed to create gRPC client: %v", err)
 	}
 	defer grpcClient.Close()
 
+	// Encrypt metric batches if a public key is configured
+	if config.CryptoKey != "" {
+		publicKey, err := crypto.LoadPublicKeyFromFile(config.CryptoKey)
+		if err != nil {
+			log.Fatalf("Failed to load public key from %s: %v", config.CryptoKey, err)
+		}
+		grpcClient.SetPublicKey(publicKey)
+		log.Printf("gRPC payload encryption enabled with public key: %s", config.CryptoKey)
+	}
+
 	// Setup graceful shutdown
 	signalChan := make(chan os.Signal, 1)
 	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
@@ -72,8 +108,14 @@ func runGRPCAgent(config *agent.Config) {
 	ctx, cancel := context.WithCancel(context.Background())
 	defer cancel()
 
+	// Restore the agent's own counters from the previous run
+	state := startAgentState(ctx, config, nil)
+
 	// Start a goroutine to collect and send metrics
-	go collectAndSendGRPC(ctx, grpcClient, config)
+	send := func(ctx context.Context, metrics []models.Metrics) error {
+		return streamOrSend(ctx, grpcClient, metrics)
+	}
+	go collectAndSend(ctx, config, "gRPC", send)
 
 	// Wait for shutdown signal
 	sig := <-signalChan
@@ -87,9 +129,49 @@ func runGRPCAgent(config *agent.Config) {
 	log.Println("Flushing final metrics...")
 	time.Sleep(2 * time.Second)
 
+	saveAgentState(state)
+
 	log.Println("gRPC agent shutdown complete")
 }
 
+func runNATSAgent(config *agent.Config) {
+	log.Println("Starting agent with NATS transport")
+
+	natsClient, err := natsclient.NewMetricsClient(config.NATSAddress, config.NATSSubject)
+	if err != nil {
+		log.Fatalf("Failed to create NATS client: %v", err)
+	}
+	defer natsClient.Close()
+
+	// Setup graceful shutdown
+	signalChan := make(chan os.Signal, 1)
+	signal.Notify(signalChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Restore the agent's own counters from the previous run
+	state := startAgentState(ctx, config, nil)
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		collectAndSend(ctx, config, "NATS", natsClient.SendMetrics)
+	}()
+
+	sig := <-signalChan
+	log.Printf("Shutdown signal received: %v", sig)
+	log.Println("Stopping NATS agent gracefully...")
+
+	// Wait for the final metrics to be published
+	cancel()
+	<-done
+
+	saveAgentState(state)
+
+	log.Println("NATS agent shutdown complete")
+}
+
 func runHTTPAgent(config *agent.Config) {
 	log.Println("Starting agent with HTTP protocol")
 
@@ -104,9 +186,29 @@ func runHTTPAgent(config *agent.Config) {
 		log.Printf("Public key loaded from %s", config.CryptoKey)
 	}
 
+	// Count send attempts of the worker pool and batches
+	var retryStats *retry.Stats
+	if config.RetryMetrics {
+		retryStats = &retry.Stats{}
+		config.RetryConfig.OnAttempt = retryStats.Record
+	}
+
 	// Initialize worker pool
 	workerPool := worker.NewPool(config.RateLimit, config.ServerAddress, config.Key, config.RetryConfig)
 	workerPool.SetPublicKey(publicKey)
+	workerPool.SetHashAlgo(config.HashAlgo)
+
+	// Keep metrics that could not be sent after all retries
+	if config.DeadLetter.Path != "" {
+		deadLetter, err := deadletter.NewWriter(config.DeadLetter)
+		if err != nil {
+			log.Fatalf("Failed to open dead-letter log: %v", err)
+		}
+		defer deadLetter.Close()
+		workerPool.SetDeadLetter(deadLetter)
+		log.Printf("Writing undeliverable metrics to %s", config.DeadLetter.Path)
+	}
+
 	workerPool.Start()
 
 	// Setup graceful shutdown - handle SIGTERM, SIGINT, SIGQUIT
@@ -118,9 +220,10 @@ func runHTTPAgent(config *agent.Config) {
 	defer cancel()
 
 	// Initialize metric collector with channel-based communication
-	metricCollector := collector.New(
+	metricCollector := collector.NewWithIntervals(
 		workerPool,
 		config.PollInterval,
+		config.SystemPoll,
 		config.ReportInterval,
 		config.BatchSize,
 		config.ServerAddress,
@@ -129,9 +232,56 @@ func runHTTPAgent(config *agent.Config) {
 		&pollCount,
 	)
 	metricCollector.SetPublicKey(publicKey)
+	metricCollector.SetHashAlgo(config.HashAlgo)
+	metricCollector.SetCommonTimestamp(config.CommonTS)
+	metricCollector.SetDeadBand(config.DeadBand)
+	metricCollector.SetChannelMetrics(config.ChanMetrics)
+	metricCollector.SetInstanceID(config.InstanceID)
+	metricCollector.SetCollectDisk(config.CollectDisk)
+	metricCollector.SetCollectNet(config.CollectNet)
+	metricCollector.SetCPUPriming(config.CPUPrime)
+	metricCollector.SetMaxBatchSize(config.MaxBatchSize)
+	if retryStats != nil {
+		metricCollector.SetRetryStats(retryStats)
+	}
+	metricCollector.SetLastErrorMetric(config.LastErrorAge)
+	if config.TraceMetric != "" {
+		metricCollector.SetTracer(worker.NewTracer(config.MetricPrefix() + config.TraceMetric))
+		log.Printf("Tracing metric %s", config.MetricPrefix()+config.TraceMetric)
+	}
+	if config.LogTailFile != "" {
+		logTail, err := collector.NewLogTailSource(config.LogTailFile, config.LogTailPattern)
+		if err != nil {
+			log.Fatalf("Failed to tail %s: %v", config.LogTailFile, err)
+		}
+		defer logTail.Close()
+		metricCollector.RegisterSource(logTail)
+		log.Printf("Counting lines of %s matching %q as %s", config.LogTailFile, config.LogTailPattern, collector.LogTailMetric)
+	}
+
+	// Restore the agent's own counters from the previous run
+	state := startAgentState(ctx, config, metricCollector)
+	if config.ConfigHash {
+		metricCollector.SetConfigHash(config.FingerprintValue())
+		log.Printf("Reporting config fingerprint %s", config.Fingerprint)
+	}
 
 	metricCollector.Start(ctx)
 
+	// Serve the send status for monitoring
+	var statusServer *http.Server
+	if config.StatusAddress != "" {
+		mux := http.NewServeMux()
+		mux.HandleFunc("GET /status", metricCollector.StatusHandler())
+		statusServer = &http.Server{Addr: config.StatusAddress, Handler: mux}
+		go func() {
+			if err := statusServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+				log.Printf("Status server failed: %v", err)
+			}
+		}()
+		log.Printf("Serving agent status at http://%s/status", config.StatusAddress)
+	}
+
 	// Wait for shutdown signal
 	sig := <-signalChan
 	log.Printf("Shutdown signal received: %v", sig)
@@ -140,48 +290,98 @@ func runHTTPAgent(config *agent.Config) {
 	// Cancel metric collection
 	cancel()
 
-	// Give collector time to send final batch of metrics
+	if statusServer != nil {
+		statusServer.Close()
+	}
+
+	// Send the metrics collected since the last report
 	log.Println("Flushing final metrics...")
-	time.Sleep(2 * time.Second)
+	flushCtx, flushCancel := context.WithTimeout(context.Background(), 10*time.Second)
+	if err := metricCollector.Flush(flushCtx); err != nil {
+		log.Printf("Failed to flush final metrics: %v", err)
+	}
+	flushCancel()
 
 	// Stop worker pool (waits for in-flight requests)
 	log.Println("Stopping worker pool...")
 	workerPool.Stop()
 
+	saveAgentState(state)
+
 	log.Println("HTTP agent shutdown complete")
 }
 
-// collectAndSendGRPC collects metrics and sends them via gRPC
-func collectAndSendGRPC(ctx context.Context, grpcClient *grpcclient.MetricsClient, config *agent.Config) {
+// collectAndSend collects metrics every poll interval and sends them with send every
+// report interval, naming transport in its logs. It is used by the gRPC and NATS agents.
+func collectAndSend(ctx context.Context, config *agent.Config, transport string, send sendFunc) {
 	pollTicker := time.NewTicker(config.PollInterval)
 	reportTicker := time.NewTicker(config.ReportInterval)
 	defer pollTicker.Stop()
 	defer reportTicker.Stop()
 
 	var metrics []models.Metrics
-	var pollCounter int64
 
 	for {
 		select {
 		case <-ctx.Done():
-			sendFinalMetrics(grpcClient, metrics)
+			sendFinalMetrics(send, metrics)
 			return
 
 		case <-pollTicker.C:
 			// Collect all metrics
-			metrics = append(metrics, collectRuntimeMetrics()...)
-			metrics = append(metrics, collectSystemMetrics()...)
-			atomic.AddInt64(&pollCounter, 1)
+			metrics = append(metrics, collectRuntimeMetrics(config.MetricPrefix())...)
+			metrics = append(metrics, collectSystemMetrics(config.MetricPrefix(), config.CollectDisk, config.CollectNet)...)
+			atomic.AddInt64(&pollCount, 1)
 
 		case <-reportTicker.C:
-			metrics = appendPollCount(metrics, &pollCounter)
-			sendMetricsBatch(ctx, grpcClient, &metrics)
+			metrics = appendPollCount(metrics, config.MetricPrefix(), &pollCount)
+			if config.ConfigHash {
+				hash := config.FingerprintValue()
+				metrics = append(metrics, models.Metrics{ID: config.MetricPrefix() + "AgentConfigHash", MType: "gauge", Value: &hash})
+			}
+			sendMetricsBatch(ctx, transport, send, &metrics)
 		}
 	}
 }
 
-// collectRuntimeMetrics collects Go runtime and random metrics
-func collectRuntimeMetrics() []models.Metrics {
+// startAgentState restores PollCount and the collector's self-counters from the
+// configured state file and saves them every report interval. It returns nil if
+// no state file is configured.
+func startAgentState(ctx context.Context, config *agent.Config, metricCollector *collector.Collector) *agent.State {
+	if config.StateFile == "" {
+		return nil
+	}
+
+	state := agent.NewState(config.StateFile)
+	state.Register("PollCount", agent.Int64Counter(&pollCount))
+	if metricCollector != nil {
+		for name, counter := range metricCollector.SelfCounters() {
+			state.Register(name, counter)
+		}
+	}
+
+	if err := state.Load(); err != nil {
+		log.Printf("Failed to restore agent state: %v", err)
+	} else {
+		log.Printf("Agent state restored from %s, PollCount=%d", config.StateFile, atomic.LoadInt64(&pollCount))
+	}
+
+	go state.Run(ctx, config.ReportInterval)
+	return state
+}
+
+// saveAgentState saves the agent's own counters on shutdown
+func saveAgentState(state *agent.State) {
+	if state == nil {
+		return
+	}
+	if err := state.Save(); err != nil {
+		log.Printf("Failed to save agent state: %v", err)
+	}
+}
+
+// collectRuntimeMetrics collects Go runtime and random metrics, prefixing their IDs with prefix
+func collectRuntimeMetrics(prefix string) []models.Metrics {
 	var memStats runtime.MemStats
 	runtime.ReadMemStats(&memStats)
 
@@ -221,7 +421,7 @@ func collectRuntimeMetrics() []models.Metrics {
 	for name, value := range metricValues {
 		v := value
 		metrics = append(metrics, models.Metrics{
-			ID:    name,
+			ID:    prefix + name,
 			MType: "gauge",
 			Value: &v,
 		})
@@ -230,7 +430,7 @@ func collectRuntimeMetrics() []models.Metrics {
 	// Add RandomValue
 	randomValue := rand.Float64()
 	metrics = append(metrics, models.Metrics{
-		ID:    "RandomValue",
+		ID:    prefix + "RandomValue",
 		MType: "gauge",
 		Value: &randomValue,
 	})
@@ -238,8 +438,9 @@ func collectRuntimeMetrics() []models.Metrics {
 	return metrics
 }
 
-// collectSystemMetrics collects memory and CPU metrics
-func collectSystemMetrics() []models.Metrics {
+// collectSystemMetrics collects memory and CPU metrics and, if enabled, disk and network
+// metrics, prefixing their IDs with prefix
+func collectSystemMetrics(prefix string, collectDisk, collectNet bool) []models.Metrics {
 	metrics := make([]models.Metrics, 0, 10)
 
 	// Collect memory metrics
@@ -248,13 +449,13 @@ func collectSystemMetrics() []models.Metrics {
 		freeMem := float64(memInfo.Free)
 
 		metrics = append(metrics, models.Metrics{
-			ID:    "TotalMemory",
+			ID:    prefix + "TotalMemory",
 			MType: "gauge",
 			Value: &totalMem,
 		})
 
 		metrics = append(metrics, models.Metrics{
-			ID:    "FreeMemory",
+			ID:    prefix + "FreeMemory",
 			MType: "gauge",
 			Value: &freeMem,
 		})
@@ -265,41 +466,68 @@ func collectSystemMetrics() []models.Metrics {
 		for i, percent := range cpuPercents {
 			cpuValue := percent
 			metrics = append(metrics, models.Metrics{
-				ID:    fmt.Sprintf("CPUutilization%d", i+1),
+				ID:    fmt.Sprintf("%sCPUutilization%d", prefix, i+1),
 				MType: "gauge",
 				Value: &cpuValue,
 			})
 		}
 	}
 
+	// Collect disk and network counters
+	var ioMetrics []models.Metrics
+	if collectDisk {
+		ioMetrics = append(ioMetrics, collector.DiskMetrics()...)
+	}
+	if collectNet {
+		ioMetrics = append(ioMetrics, collector.NetMetrics()...)
+	}
+	for _, metric := range ioMetrics {
+		metric.ID = prefix + metric.ID
+		metrics = append(metrics, metric)
+	}
+
 	return metrics
 }
 
-// appendPollCount adds the poll counter metric to the metrics slice
-func appendPollCount(metrics []models.Metrics, pollCounter *int64) []models.Metrics {
+// appendPollCount adds the poll counter metric, with its ID prefixed, to the metrics slice
+func appendPollCount(metrics []models.Metrics, prefix string, pollCounter *int64) []models.Metrics {
 	currentCount := atomic.LoadInt64(pollCounter)
 	return append(metrics, models.Metrics{
-		ID:    "PollCount",
+		ID:    prefix + "PollCount",
 		MType: "counter",
 		Delta: &currentCount,
 	})
 }
 
-// sendMetricsBatch sends metrics via gRPC and clears the slice
-func sendMetricsBatch(ctx context.Context, grpcClient *grpcclient.MetricsClient, metrics *[]models.Metrics) {
+// sendFunc sends a batch of metrics over the gRPC or NATS transport
+type sendFunc func(ctx context.Context, metrics []models.Metrics) error
+
+// sendMetricsBatch sends metrics via the named transport and clears the slice
+func sendMetricsBatch(ctx context.Context, transport string, send sendFunc, metrics *[]models.Metrics) {
 	if len(*metrics) > 0 {
-		log.Printf("Sending %d metrics via gRPC", len(*metrics))
-		if err := grpcClient.SendMetrics(ctx, *metrics); err != nil {
-			log.Printf("Failed to send metrics via gRPC: %v", err)
+		log.Printf("Sending %d metrics via %s", len(*metrics), transport)
+		if err := send(ctx, *metrics); err != nil {
+			log.Printf("Failed to send metrics via %s: %v", transport, err)
 		}
 		*metrics = (*metrics)[:0]
 	}
 }
 
+// streamOrSend streams metrics to the server, falling back to the unary
+// UpdateMetrics call when the server does not implement StreamMetrics
+func streamOrSend(ctx context.Context, grpcClient *grpcclient.MetricsClient, metrics []models.Metrics) error {
+	_, err := grpcClient.StreamMetrics(ctx, metrics)
+	if status.Code(err) == codes.Unimplemented {
+		log.Printf("Server does not support metric streaming, using UpdateMetrics")
+		return grpcClient.SendMetrics(ctx, metrics)
+	}
+	return err
+}
+
 // sendFinalMetrics sends remaining metrics before shutdown
-func sendFinalMetrics(grpcClient *grpcclient.MetricsClient, metrics []models.Metrics) {
+func sendFinalMetrics(send sendFunc, metrics []models.Metrics) {
 	if len(metrics) > 0 {
-		if err := grpcClient.SendMetrics(context.Background(), metrics); err != nil {
+		if err := send(context.Background(), metrics); err != nil {
 			log.Printf("Failed to send final metrics: %v", err)
 		}
 	}
@@ -8,6 +8,7 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"strings"
 	"sync"
 	"testing"
 	"time"
@@ -460,3 +461,18 @@ func TestCollector_Integration(t *testing.T) {
 	// The test passes if no panics occur and goroutines start/stop cleanly
 	t.Log("Channel-based metric collection completed successfully")
 }
+
+func TestCollectRuntimeMetrics_Prefix(t *testing.T) {
+	for _, m := range collectRuntimeMetrics("") {
+		if strings.Contains(m.ID, ".") {
+			t.Errorf("Expected unprefixed metric ID by default, got %s", m.ID)
+		}
+	}
+
+	metrics := appendPollCount(collectRuntimeMetrics("web-01."), "web-01.", new(int64))
+	for _, m := range metrics {
+		if !strings.HasPrefix(m.ID, "web-01.") {
+			t.Errorf("Expected metric ID with prefix web-01., got %s", m.ID)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mutualEvg/metrics-server/internal/agent"
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
+	"github.com/mutualEvg/metrics-server/internal/natsclient"
+	"github.com/mutualEvg/metrics-server/internal/utils"
+)
+
+// validateTimeout bounds the connectivity check of -validate
+const validateTimeout = 5 * time.Second
+
+// validateAgent checks what the agent needs to start without collecting any metric:
+// the crypto key loads and the server is reachable. The config itself was already
+// validated by ParseConfig. A summary of each check is written to w.
+func validateAgent(w io.Writer, config *agent.Config) error {
+	transport := "HTTP"
+	address := config.ServerAddress
+	if config.GRPCAddress != "" {
+		transport = "gRPC"
+		address = config.GRPCAddress
+	} else if config.NATSAddress != "" {
+		transport = "NATS"
+		address = config.NATSAddress
+	}
+	fmt.Fprintf(w, "config: OK (%s to %s, poll %v, report %v, fingerprint %s)\n",
+		transport, address, config.PollInterval, config.ReportInterval, config.Fingerprint)
+
+	if config.CryptoKey != "" {
+		if _, err := crypto.LoadPublicKeyFromFile(config.CryptoKey); err != nil {
+			fmt.Fprintf(w, "crypto key: FAILED\n")
+			return fmt.Errorf("failed to load public key from %s: %w", config.CryptoKey, err)
+		}
+		fmt.Fprintf(w, "crypto key: OK (%s)\n", config.CryptoKey)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), validateTimeout)
+	defer cancel()
+
+	var err error
+	if config.GRPCAddress != "" {
+		err = checkGRPCServer(ctx, config)
+	} else if config.NATSAddress != "" {
+		err = checkNATSServer(ctx, config.NATSAddress)
+	} else {
+		err = checkHTTPServer(ctx, config.ServerAddress)
+	}
+	if err != nil {
+		fmt.Fprintf(w, "server: FAILED\n")
+		return fmt.Errorf("server %s not reachable: %w", address, err)
+	}
+	fmt.Fprintf(w, "server: OK (%s)\n", address)
+	return nil
+}
+
+// checkHTTPServer sends a HEAD request to the server root. Any response below 500
+// counts as reachable, since the server need not serve HEAD requests.
+func checkHTTPServer(ctx context.Context, serverAddress string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodHead, utils.HTTPBaseURL(serverAddress)+"/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := utils.NewHTTPClient(serverAddress, 0).Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusInternalServerError {
+		return fmt.Errorf("unexpected status %s", resp.Status)
+	}
+	return nil
+}
+
+// checkGRPCServer connects to the gRPC server, using TLS if a CA certificate is
+// configured, and waits until the connection is ready
+func checkGRPCServer(ctx context.Context, config *agent.Config) error {
+	var client *grpcclient.MetricsClient
+	var err error
+	if config.GRPCCA != "" {
+		client, err = grpcclient.NewMetricsClientTLS(config.GRPCAddress, config.GRPCCA)
+	} else {
+		client, err = grpcclient.NewMetricsClient(config.GRPCAddress)
+	}
+	if err != nil {
+		return err
+	}
+	defer client.Close()
+
+	return client.WaitReady(ctx)
+}
+
+// checkNATSServer connects to the NATS server
+func checkNATSServer(ctx context.Context, url string) error {
+	deadline, _ := ctx.Deadline()
+	client, err := natsclient.NewMetricsClient(url, natsclient.DefaultSubject, nats.Timeout(time.Until(deadline)))
+	if err != nil {
+		return err
+	}
+	return client.Close()
+}
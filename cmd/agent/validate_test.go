@@ -0,0 +1,103 @@
+package main
+
+import (
+	"bytes"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+
+	"github.com/mutualEvg/metrics-server/internal/agent"
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+)
+
+func TestValidateAgentHTTP(t *testing.T) {
+	// The server does not serve HEAD on /, which still proves it is reachable
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+	}))
+	defer server.Close()
+
+	_, publicKey, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+	keyPath := filepath.Join(t.TempDir(), "public.pem")
+	if err := crypto.SavePublicKeyToFile(keyPath, publicKey); err != nil {
+		t.Fatalf("Failed to save public key: %v", err)
+	}
+
+	var out bytes.Buffer
+	config := &agent.Config{ServerAddress: server.URL, CryptoKey: keyPath}
+	if err := validateAgent(&out, config); err != nil {
+		t.Fatalf("Expected validation to pass, got %v", err)
+	}
+	for _, want := range []string{"config: OK", "crypto key: OK", "server: OK"} {
+		if !strings.Contains(out.String(), want) {
+			t.Errorf("Expected summary to contain %q, got:\n%s", want, out.String())
+		}
+	}
+}
+
+func TestValidateAgentFailures(t *testing.T) {
+	reachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer reachable.Close()
+
+	unreachable := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	unreachable.Close()
+
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+
+	tests := []struct {
+		name        string
+		config      *agent.Config
+		errContains string
+	}{
+		{"missing crypto key", &agent.Config{ServerAddress: reachable.URL, CryptoKey: filepath.Join(t.TempDir(), "missing.pem")},
+			"failed to load public key"},
+		{"server down", &agent.Config{ServerAddress: unreachable.URL}, "not reachable"},
+		{"server error", &agent.Config{ServerAddress: failing.URL}, "unexpected status"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := validateAgent(&out, tt.config)
+			if err == nil {
+				t.Fatalf("Expected validation to fail, summary:\n%s", out.String())
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Expected error to contain %q, got %v", tt.errContains, err)
+			}
+			if !strings.Contains(out.String(), "FAILED") {
+				t.Errorf("Expected summary to report the failed check, got:\n%s", out.String())
+			}
+		})
+	}
+}
+
+func TestValidateAgentGRPC(t *testing.T) {
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	server := grpc.NewServer()
+	go server.Serve(lis)
+	defer server.Stop()
+
+	var out bytes.Buffer
+	config := &agent.Config{ServerAddress: agent.DefaultServerAddress, GRPCAddress: lis.Addr().String()}
+	if err := validateAgent(&out, config); err != nil {
+		t.Fatalf("Expected validation to pass, got %v", err)
+	}
+	if !strings.Contains(out.String(), "gRPC to "+lis.Addr().String()) {
+		t.Errorf("Expected summary to name the gRPC transport, got:\n%s", out.String())
+	}
+}
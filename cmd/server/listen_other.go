@@ -0,0 +1,22 @@
+//go:build !unix
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"os"
+)
+
+// listenUnix listens on the Unix socket path and sets its permissions to socketMode
+func listenUnix(path string) (net.Listener, error) {
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.Chmod(path, socketMode); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return listener, nil
+}
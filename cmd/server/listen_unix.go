@@ -0,0 +1,17 @@
+//go:build unix
+
+package main
+
+import (
+	"net"
+	"syscall"
+)
+
+// listenUnix listens on the Unix socket path, which is created with socketMode rather
+// than the default permissions, so that it is never reachable by other users. The umask
+// is process-wide and is therefore changed only for the duration of the call.
+func listenUnix(path string) (net.Listener, error) {
+	old := syscall.Umask(0o777 &^ socketMode)
+	defer syscall.Umask(old)
+	return net.Listen("unix", path)
+}
@@ -0,0 +1,34 @@
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+)
+
+func TestListenUnixSocketMode(t *testing.T) {
+	old := syscall.Umask(0o022)
+	defer syscall.Umask(old)
+
+	path := filepath.Join(t.TempDir(), "server.sock")
+	listener, err := listenUnix(path)
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer listener.Close()
+
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatalf("Failed to stat socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != socketMode {
+		t.Errorf("Expected socket permissions %o, got %o", socketMode, perm)
+	}
+	// The umask of the process is restored afterwards
+	if mask := syscall.Umask(0o022); mask != 0o022 {
+		t.Errorf("Expected umask 022 to be restored, got %03o", mask)
+	}
+}
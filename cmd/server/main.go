@@ -4,12 +4,14 @@ package main
 import (
 	"context"
 	"crypto/rsa"
+	"crypto/tls"
 	"fmt"
+	"io/fs"
 	"net"
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"runtime"
 	"syscall"
 	"time"
 
@@ -18,14 +20,20 @@ import (
 	"github.com/mutualEvg/metrics-server/config"
 	"github.com/mutualEvg/metrics-server/internal/audit"
 	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/exporter"
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
 	"github.com/mutualEvg/metrics-server/internal/grpcserver"
 	"github.com/mutualEvg/metrics-server/internal/handlers"
 	gzipmw "github.com/mutualEvg/metrics-server/internal/middleware"
+	"github.com/mutualEvg/metrics-server/internal/natsclient"
 	pb "github.com/mutualEvg/metrics-server/internal/proto"
+	"github.com/mutualEvg/metrics-server/internal/stats"
 	"github.com/mutualEvg/metrics-server/storage"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+	"google.golang.org/grpc/reflection"
 )
 
 var (
@@ -34,6 +42,14 @@ var (
 	buildCommit  string = "N/A"
 )
 
+// socketMode is the permission of the HTTP Unix socket, so that an agent running as
+// the server's group can connect
+const socketMode = 0o660
+
+// grpcKeepaliveTimeout is how long the gRPC server waits for the answer to a keepalive
+// ping before closing the connection
+const grpcKeepaliveTimeout = 20 * time.Second
+
 func printBuildInfo() {
 	fmt.Printf("Build version: %s\n", buildVersion)
 	fmt.Printf("Build date: %s\n", buildDate)
@@ -48,6 +64,10 @@ func main() {
 	// Setup zerolog
 	log.Logger = log.Output(zerolog.ConsoleWriter{Out: os.Stderr})
 
+	if err := cfg.Validate(); err != nil {
+		log.Fatal().Err(err).Msg("Invalid configuration")
+	}
+
 	// Initialize storage based on configuration priority:
 	// 1. Database storage (if DATABASE_DSN is provided)
 	// 2. File storage (if file storage is explicitly configured)
@@ -58,9 +78,39 @@ func main() {
 	var fileManager *storage.FileManager
 	var err error
 
-	if cfg.DatabaseDSN != "" {
-		// Priority 1: Use database storage
-		dbStorage, err = storage.NewDBStorage(cfg.DatabaseDSN)
+	var replicaClient *grpcclient.MetricsClient
+	replicaCtx, stopReplica := context.WithCancel(context.Background())
+	defer stopReplica()
+
+	if cfg.ReplicaOf != "" {
+		// Read replica: state is pulled from the primary over gRPC
+		replicaStorage := storage.NewMemStorage()
+		mainStorage = replicaStorage
+
+		if cfg.GRPCCA != "" {
+			replicaClient, err = grpcclient.NewMetricsClientTLS(cfg.ReplicaOf, cfg.GRPCCA, grpcclient.WithMaxMsgSize(cfg.GRPCMaxMsgSize))
+		} else {
+			replicaClient, err = grpcclient.NewMetricsClient(cfg.ReplicaOf, grpcclient.WithMaxMsgSize(cfg.GRPCMaxMsgSize))
+		}
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to create replica client")
+		}
+		go replicaClient.RunReplicaSync(replicaCtx, cfg.ReplicaInterval, replicaStorage)
+		log.Info().Str("primary", cfg.ReplicaOf).Dur("interval", cfg.ReplicaInterval).Msg("Running as read replica")
+	} else if cfg.DatabaseDSN != "" {
+		// Priority 1: Use database storage. A shutdown signal aborts connecting.
+		dbOpts := []storage.DBStorageOption{
+			storage.WithSchema(cfg.DBSchema),
+			storage.WithHashPartitions(cfg.DBPartitions),
+			storage.WithPoolSettings(storage.PoolSettings{
+				MaxOpenConns:    cfg.DBMaxOpen,
+				MaxIdleConns:    cfg.DBMaxIdle,
+				ConnMaxLifetime: cfg.DBConnLifetime,
+			}),
+		}
+		connectCtx, stopConnect := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
+		dbStorage, err = storage.NewDBStorageContext(connectCtx, cfg.DatabaseDSN, dbOpts...)
+		stopConnect()
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to initialize database storage")
 		}
@@ -68,11 +118,12 @@ func main() {
 		log.Info().Msg("Using PostgreSQL database storage")
 	} else if cfg.UseFileStorage {
 		// Priority 2: Use file storage
-		memStorage := storage.NewMemStorage()
+		memStorage := storage.NewMemStorage(storage.WithMaxMetrics(cfg.MaxMetrics))
 		mainStorage = memStorage
 
 		// Setup file storage
 		fileManager = storage.NewFileManager(cfg.FileStoragePath, memStorage)
+		fileManager.SetSplit(cfg.SplitFiles)
 
 		// Configure synchronous saving if store interval is 0
 		syncSave := cfg.StoreInterval == 0
@@ -99,16 +150,31 @@ func main() {
 		log.Info().Str("file", cfg.FileStoragePath).Msg("Using file storage")
 	} else {
 		// Priority 3: Use pure memory storage
-		mainStorage = storage.NewMemStorage()
+		mainStorage = storage.NewMemStorage(storage.WithMaxMetrics(cfg.MaxMetrics))
 		log.Info().Msg("Using in-memory storage (no persistence)")
 	}
+	if cfg.MaxMetrics > 0 {
+		if cfg.ReplicaOf != "" || dbStorage != nil {
+			log.Warn().Msg("-max-metrics only applies to in-memory and file storage and is ignored")
+		} else {
+			log.Info().Int("max_metrics", cfg.MaxMetrics).Msg("Distinct metrics are capped")
+		}
+	}
 
 	// Initialize audit system
 	auditSubject := audit.NewSubject()
 
-	// Configure file auditor if specified
-	if cfg.AuditFile != "" {
-		fileAuditor, err := audit.NewFileAuditor(cfg.AuditFile)
+	// Configure file auditor if specified, buffering writes if a flush interval is set
+	if cfg.AuditFile != "" && cfg.AuditFlush > 0 {
+		bufferedAuditor, err := audit.NewBufferedFileAuditorWithRotation(cfg.AuditFile, cfg.AuditFlush, 0, cfg.AuditMaxSize, cfg.AuditBackups)
+		if err != nil {
+			log.Error().Err(err).Str("file", cfg.AuditFile).Msg("Failed to initialize file auditor")
+		} else {
+			auditSubject.Attach(bufferedAuditor)
+			log.Info().Str("file", cfg.AuditFile).Dur("flush_interval", cfg.AuditFlush).Msg("Buffered file audit logging enabled")
+		}
+	} else if cfg.AuditFile != "" {
+		fileAuditor, err := audit.NewFileAuditorWithRotation(cfg.AuditFile, cfg.AuditMaxSize, cfg.AuditBackups)
 		if err != nil {
 			log.Error().Err(err).Str("file", cfg.AuditFile).Msg("Failed to initialize file auditor")
 		} else {
@@ -119,23 +185,101 @@ func main() {
 
 	// Configure remote auditor if specified
 	if cfg.AuditURL != "" {
-		remoteAuditor, err := audit.NewRemoteAuditor(cfg.AuditURL)
+		remoteAuditor, err := audit.NewRemoteAuditor(cfg.AuditURL, audit.WithBatching(cfg.AuditBatch, cfg.AuditFlush))
 		if err != nil {
 			log.Error().Err(err).Str("url", cfg.AuditURL).Msg("Failed to initialize remote auditor")
 		} else {
 			auditSubject.Attach(remoteAuditor)
-			log.Info().Str("url", cfg.AuditURL).Msg("Remote audit logging enabled")
+			log.Info().Str("url", cfg.AuditURL).Int("batch_size", cfg.AuditBatch).Msg("Remote audit logging enabled")
+		}
+	}
+
+	// Configure syslog auditor if specified
+	if cfg.AuditSyslog != "" {
+		syslogAuditor, err := audit.NewSyslogAuditor(cfg.AuditSyslog)
+		if err != nil {
+			log.Error().Err(err).Str("syslog", cfg.AuditSyslog).Msg("Failed to initialize syslog auditor")
+		} else {
+			auditSubject.Attach(syslogAuditor)
+			log.Info().Str("syslog", cfg.AuditSyslog).Msg("Syslog audit logging enabled")
 		}
 	}
 
 	if !auditSubject.HasObservers() {
-		log.Info().Msg("Audit logging is disabled (no audit-file or audit-url configured)")
+		log.Info().Msg("Audit logging is disabled (no audit-file, audit-url or audit-syslog configured)")
+	}
+
+	// Write policies and optional features of the HTTP handlers and the gRPC service
+	handlerCfg := &handlers.Config{
+		MaxMetricSize:      cfg.MaxMetricSize,
+		ReservedPrefix:     cfg.ReservedPrefix,
+		LenientFloats:      cfg.LenientFloats,
+		CounterDeltaHeader: cfg.CounterDelta,
+		RootCacheTTL:       cfg.RootCacheTTL,
+	}
+	grpcCfg := &grpcserver.Config{
+		MaxMetricSize:  cfg.MaxMetricSize,
+		ReservedPrefix: cfg.ReservedPrefix,
+		LenientFloats:  cfg.LenientFloats,
+		ReadOnly:       cfg.ReplicaOf != "",
+		AuditSubject:   auditSubject,
+		BuildInfo: &pb.BuildInfo{
+			Version:   buildVersion,
+			Date:      buildDate,
+			Commit:    buildCommit,
+			GoVersion: runtime.Version(),
+		},
+	}
+
+	// Accept only metric names satisfying the configured allow and deny expressions
+	namePolicy, err := storage.NewNamePolicy(cfg.MetricAllow, cfg.MetricDeny)
+	if err != nil {
+		log.Fatal().Err(err).Msg("Invalid metric name policy")
+	}
+	handlerCfg.NamePolicy = namePolicy
+	grpcCfg.NamePolicy = namePolicy
+	if namePolicy != nil {
+		log.Info().Str("allow", cfg.MetricAllow).Str("deny", cfg.MetricDeny).Msg("Metric name policy enabled")
+	}
+
+	// Apply retried batch updates carrying the same Idempotency-Key only once
+	trustedProxies := gzipmw.ParseTrustedSubnets(cfg.TrustedProxies)
+	if cfg.IdempotencyTTL > 0 {
+		handlerCfg.Idempotency = handlers.NewIdempotencyStore(cfg.IdempotencyKeys, cfg.IdempotencyTTL)
+		handlerCfg.TrustedProxies = trustedProxies
+	}
+
+	// Track recent counter deltas for GET /rate/{name}
+	var counterRates *storage.CounterRates
+	if cfg.RateWindow > 0 {
+		counterRates = storage.NewCounterRates(cfg.RateWindow, cfg.RateResolution)
+		handlerCfg.CounterRates = counterRates
+		grpcCfg.CounterRates = counterRates
+		log.Info().Dur("window", counterRates.Window()).Dur("resolution", counterRates.Resolution()).Msg("Counter rate tracking enabled")
+	}
+
+	// Reject writes changing the type a metric was first seen with
+	var metricTypes *storage.TypeRegistry
+	if cfg.EnforceTypes {
+		metricTypes = storage.NewTypeRegistry()
+		metricTypes.Seed(mainStorage)
+		handlerCfg.MetricTypes = metricTypes
+		grpcCfg.MetricTypes = metricTypes
+		log.Info().Msg("Metric type enforcement enabled")
+	}
+
+	// Trace exemplars of counter updates for the OpenMetrics exporter
+	exemplars := exporter.NewExemplarStore()
+	if cfg.OpenMetrics {
+		handlerCfg.Exemplars = exemplars
 	}
 
 	r := chi.NewRouter()
 
 	// Add middleware
-	r.Use(loggingMiddleware)
+	serverStats := stats.New()
+	requestLog := stats.NewRequestLog(cfg.DebugRequests)
+	r.Use(loggingMiddleware(serverStats, requestLog))
 
 	// Add trusted subnet middleware if configured
 	if cfg.TrustedSubnet != "" {
@@ -145,55 +289,151 @@ func main() {
 		log.Info().Msg("Trusted subnet validation disabled (all IPs allowed)")
 	}
 
-	// Add decryption middleware if crypto key is configured
-	if cfg.CryptoKey != "" {
-		privateKey, err := loadPrivateKey(cfg.CryptoKey)
+	// Add decryption middleware if crypto keys are configured; several keys are tried
+	// in order so that payloads for the old and the new key are accepted during rotation
+	var privateKeys []*rsa.PrivateKey
+	for _, path := range cfg.CryptoKeys {
+		privateKey, err := loadPrivateKey(path)
 		if err != nil {
 			log.Fatal().Err(err).Msg("Failed to load private key for decryption")
 		}
-		r.Use(gzipmw.DecryptionMiddleware(privateKey))
-		log.Info().Str("key_path", cfg.CryptoKey).Msg("Asymmetric decryption enabled")
+		privateKeys = append(privateKeys, privateKey)
+	}
+	if len(privateKeys) > 0 {
+		r.Use(gzipmw.DecryptionMiddlewareMulti(privateKeys))
+		log.Info().Strs("key_paths", cfg.CryptoKeys).Msg("Asymmetric decryption enabled")
 	}
 
 	// Add hash middleware BEFORE gzip middleware so it can verify compressed data
 	if cfg.Key != "" {
-		log.Info().Msg("SHA256 hash verification enabled")
-		r.Use(gzipmw.HashVerification(cfg.Key))
-		r.Use(gzipmw.ResponseHash(cfg.Key))
+		log.Info().Str("algo", cfg.HashAlgo).Bool("strict", cfg.HashStrict).Msg("Hash verification enabled")
+		r.Use(gzipmw.HashVerificationAlgo(cfg.Key, cfg.HashAlgo, cfg.HashStrict))
+		r.Use(gzipmw.ResponseHashAlgo(cfg.Key, cfg.HashAlgo))
 	}
 
-	r.Use(gzipmw.GzipMiddleware)
+	r.Use(gzipmw.GzipMiddlewareWithMinSize(cfg.GzipLevel, cfg.MaxBodySize, cfg.GzipMinSize))
 
 	// Database ping handler
 	r.Get("/ping", handlers.PingHandler(dbStorage))
 
+	// Readiness probe for the configured storage backend
+	r.Get("/healthz", handlers.HealthHandler(mainStorage))
+
+	// Debug endpoints are only served with a valid admin token
+	debug := r.With(gzipmw.AdminAuth(cfg.AdminToken))
+
+	// Request counts, error counts and latencies of the server itself
+	debug.Get("/debug/stats", serverStats.Handler())
+
+	// Version, date and commit of the running binary
+	debug.Get("/debug/build", handlers.BuildInfoHandler(handlers.BuildInfo{
+		Version:   buildVersion,
+		Date:      buildDate,
+		Commit:    buildCommit,
+		GoVersion: runtime.Version(),
+	}))
+
+	// Attempt, retry and failure counts of database operations
+	if dbStorage != nil {
+		debug.Get("/debug/retries", handlers.RetryStatsHandler(dbStorage))
+	}
+
+	// Most recent requests
+	debug.Get("/debug/requests", requestLog.Handler())
+
+	// Deletes all stored metrics; only served when explicitly enabled, and only with
+	// a valid admin token if one is configured
+	if cfg.EnableAdmin {
+		admin := r.With(gzipmw.ReadOnly(cfg.ReplicaOf != ""))
+		if cfg.AdminToken != "" {
+			admin = admin.With(gzipmw.AdminAuth(cfg.AdminToken))
+		}
+		admin.Post("/admin/reset", handlers.ResetHandler(mainStorage, handlerCfg))
+		log.Warn().Msg("Admin endpoints enabled: POST /admin/reset deletes all metrics")
+	}
+
+	// Update endpoints are rejected on a read replica, whose state is replaced by the primary's.
+	// They reject requests over the concurrency limit, and while the database is failing,
+	// with a Retry-After hint, so that agents slow down; other endpoints such as probes
+	// stay available. Each limit is a no-op when disabled.
+	updates := r.With(gzipmw.ReadOnly(cfg.ReplicaOf != ""), gzipmw.Backpressure(cfg.MaxInFlight, cfg.RetryAfter))
+	if cfg.MaxInFlight > 0 {
+		log.Info().Int("max_inflight", cfg.MaxInFlight).Dur("retry_after", cfg.RetryAfter).Msg("Backpressure enabled")
+	}
+	if dbStorage != nil && cfg.DBErrorPercent > 0 {
+		updates = updates.With(gzipmw.ErrorRateBackpressure(dbStorage.RetryStats, cfg.DBErrorPercent, cfg.RetryAfter))
+		log.Info().Int("db_error_percent", cfg.DBErrorPercent).Msg("Database error backpressure enabled")
+	}
+
+	// Per-IP rate limiting for update endpoints
+	updates = updates.With(gzipmw.RateLimitMiddleware(cfg.RateLimit, cfg.RateBurst, trustedProxies))
+	if cfg.RateLimit > 0 {
+		log.Info().Int("rps", cfg.RateLimit).Int("burst", cfg.RateBurst).Msg("Update rate limiting enabled")
+	}
+
 	// Legacy URL-based API
-	r.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(mainStorage))
+	updates.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(mainStorage, auditSubject, handlerCfg))
 	r.Get("/value/{type}/{name}", handlers.ValueHandler(mainStorage))
+	r.Get("/value/{type}/{name}/meta", handlers.MetaHandler(mainStorage))
+	if counterRates != nil {
+		r.Get("/rate/{name}", handlers.RateHandler(mainStorage, counterRates))
+	}
 
 	// New JSON API with Content-Type middleware - use exact paths to avoid conflicts
-	r.With(gzipmw.RequireContentType("application/json")).Post("/update/", handlers.UpdateJSONHandler(mainStorage, auditSubject))
+	updates.With(gzipmw.RequireContentType("application/json", handlers.ProtobufContentType)).Post("/update/", handlers.UpdateJSONHandler(mainStorage, auditSubject, handlerCfg))
 	r.With(gzipmw.RequireContentType("application/json")).Post("/value/", handlers.ValueJSONHandler(mainStorage, auditSubject))
-	r.With(gzipmw.RequireContentType("application/json")).Post("/updates/", handlers.UpdateBatchHandler(mainStorage, auditSubject))
+	updates.With(gzipmw.RequireContentType("application/json", handlers.ProtobufContentType)).Post("/updates/", handlers.UpdateBatchHandler(mainStorage, auditSubject, handlerCfg))
+	r.With(gzipmw.RequireContentType("application/json")).Post("/values/", handlers.ValuesBatchHandler(mainStorage, auditSubject))
 
-	r.Get("/", handlers.RootHandler(mainStorage))
+	r.Get("/", handlers.RootHandler(mainStorage, handlerCfg))
 
-	addr := strings.TrimPrefix(cfg.ServerAddress, "http://")
-	addr = strings.TrimPrefix(addr, "https://")
+	// Prometheus scrape endpoint, negotiating OpenMetrics when enabled
+	r.Get("/metrics", exporter.Handler(mainStorage, exemplars, cfg.OpenMetrics))
+
+	addr := cfg.HTTPListenAddress()
+	httpListener, err := listenHTTP(cfg)
+	if err != nil {
+		log.Fatal().Err(err).Str("address", cfg.ServerAddress).Msg("Failed to create HTTP listener")
+	}
 
 	// Setup graceful shutdown - handle SIGTERM, SIGINT, SIGQUIT
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM, syscall.SIGQUIT)
 
+	// Bounded timeouts keep slow or idle clients from holding connections forever
 	server := &http.Server{
-		Addr:    addr,
-		Handler: r,
+		Addr:         addr,
+		Handler:      r,
+		ReadTimeout:  cfg.ReadTimeout,
+		WriteTimeout: cfg.WriteTimeout,
+		IdleTimeout:  cfg.IdleTimeout,
+	}
+
+	// Serve HTTPS if a certificate is configured; Validate ensures the key is set too.
+	// The pair is loaded here so that a bad certificate fails the startup.
+	if cfg.TLSCert != "" {
+		cert, err := tls.LoadX509KeyPair(cfg.TLSCert, cfg.TLSKey)
+		if err != nil {
+			log.Fatal().Err(err).Str("cert", cfg.TLSCert).Msg("Failed to load TLS certificate")
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{cert},
+			MinVersion:   tls.VersionTLS12,
+		}
+		log.Info().Str("cert", cfg.TLSCert).Msg("HTTPS enabled")
 	}
 
 	// Start HTTP server in a goroutine
 	go func() {
-		fmt.Printf("HTTP server running at %s\n", cfg.ServerAddress)
-		if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if server.TLSConfig != nil {
+			fmt.Printf("HTTPS server running at %s\n", cfg.ServerAddress)
+			err = server.ServeTLS(httpListener, "", "")
+		} else {
+			fmt.Printf("HTTP server running at %s\n", cfg.ServerAddress)
+			err = server.Serve(httpListener)
+		}
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal().Err(err).Msg("HTTP server failed")
 		}
 	}()
@@ -201,6 +441,8 @@ func main() {
 	// Start gRPC server if configured
 	var grpcServer *grpc.Server
 	var grpcListener net.Listener
+	healthCtx, stopHealth := context.WithCancel(context.Background())
+	defer stopHealth()
 	if cfg.GRPCAddress != "" {
 		log.Info().Str("address", cfg.GRPCAddress).Msg("Starting gRPC server")
 
@@ -210,17 +452,39 @@ func main() {
 			log.Fatal().Err(err).Msg("Failed to create gRPC listener")
 		}
 
-		// Create gRPC server with interceptor
-		var opts []grpc.ServerOption
-		if cfg.TrustedSubnet != "" {
-			opts = append(opts, grpc.UnaryInterceptor(grpcserver.TrustedSubnetInterceptor(cfg.TrustedSubnet)))
+		// The metrics service carries the message size limits and keepalive settings
+		metricsServer := grpcserver.NewMetricsServer(mainStorage, grpcCfg,
+			grpcserver.WithMaxRecvMsgSize(cfg.GRPCMaxMsgSize),
+			grpcserver.WithMaxSendMsgSize(cfg.GRPCMaxMsgSize),
+			grpcserver.WithKeepalive(keepalive.ServerParameters{Time: cfg.GRPCKeepalive, Timeout: grpcKeepaliveTimeout}),
+			grpcserver.WithKeepaliveEnforcement(keepalive.EnforcementPolicy{MinTime: cfg.GRPCMinPing, PermitWithoutStream: true}),
+		)
+		grpcServer, err = newGRPCServer(cfg, privateKeys, metricsServer.ServerOptions()...)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to configure gRPC TLS")
+		}
+		if cfg.GRPCCert != "" && cfg.GRPCKey != "" {
+			log.Info().Str("cert", cfg.GRPCCert).Msg("gRPC TLS enabled")
+		}
+		if len(privateKeys) > 0 {
+			log.Info().Msg("gRPC payload decryption enabled")
+		}
+		if cfg.GRPCReflection {
+			log.Warn().Msg("gRPC server reflection enabled; do not enable it in production")
 		}
-		grpcServer = grpc.NewServer(opts...)
 
 		// Register metrics service
-		metricsServer := grpcserver.NewMetricsServer(mainStorage)
 		pb.RegisterMetricsServer(grpcServer, metricsServer)
 
+		// Report storage reachability over the standard health checking protocol
+		var pinger grpcserver.Pinger
+		if dbStorage != nil {
+			pinger = dbStorage
+		}
+		healthServer := grpcserver.NewHealthServer(pinger)
+		grpcserver.RegisterHealthServer(grpcServer, healthServer)
+		go healthServer.Run(healthCtx, 5*time.Second)
+
 		// Start gRPC server in a goroutine
 		go func() {
 			fmt.Printf("gRPC server running at %s\n", cfg.GRPCAddress)
@@ -232,6 +496,26 @@ func main() {
 		log.Info().Msg("gRPC server disabled (no grpc_address configured)")
 	}
 
+	// Consume metric batches published by agents over NATS if configured
+	var natsSubscriber *natsclient.Subscriber
+	if cfg.NATSAddress != "" {
+		natsSubscriber, err = natsclient.NewSubscriber(cfg.NATSAddress, cfg.NATSSubject, cfg.NATSQueue, mainStorage)
+		if err != nil {
+			log.Fatal().Err(err).Msg("Failed to connect to NATS")
+		}
+		natsSubscriber.SetMaxMetricSize(cfg.MaxMetricSize)
+		natsSubscriber.SetReservedPrefix(cfg.ReservedPrefix)
+		natsSubscriber.SetNamePolicy(namePolicy)
+		natsSubscriber.SetLenientFloats(cfg.LenientFloats)
+		natsSubscriber.SetTypeRegistry(metricTypes)
+		natsSubscriber.SetCounterRates(counterRates)
+		if err := natsSubscriber.Start(); err != nil {
+			natsSubscriber.Close()
+			log.Fatal().Err(err).Msg("Failed to subscribe to NATS")
+		}
+		log.Info().Str("address", cfg.NATSAddress).Str("subject", cfg.NATSSubject).Msg("Receiving metrics over NATS")
+	}
+
 	// Wait for shutdown signal
 	sig := <-sigChan
 	log.Info().Msgf("Shutdown signal received: %v", sig)
@@ -243,6 +527,8 @@ func main() {
 	// Shutdown gRPC server gracefully if running
 	if grpcServer != nil {
 		log.Info().Msg("Shutting down gRPC server...")
+		// Report NOT_SERVING so that load balancers stop routing here while draining
+		stopHealth()
 		grpcServer.GracefulStop()
 		if grpcListener != nil {
 			grpcListener.Close()
@@ -250,6 +536,19 @@ func main() {
 		log.Info().Msg("gRPC server stopped gracefully")
 	}
 
+	// Stop consuming NATS batches once the received ones are stored
+	if natsSubscriber != nil {
+		if err := natsSubscriber.Close(); err != nil {
+			log.Error().Err(err).Msg("NATS subscriber close error")
+		}
+	}
+
+	// Stop replica sync if running
+	if replicaClient != nil {
+		stopReplica()
+		replicaClient.Close()
+	}
+
 	// Shutdown HTTP server gracefully (waits for in-flight requests to complete)
 	log.Info().Msg("Shutting down HTTP server...")
 	if err := server.Shutdown(ctx); err != nil {
@@ -258,6 +557,12 @@ func main() {
 		log.Info().Msg("HTTP server stopped gracefully")
 	}
 
+	// Write out buffered and queued audit events once no more requests are served
+	log.Info().Msg("Flushing audit events...")
+	if err := auditSubject.Close(ctx); err != nil {
+		log.Error().Err(err).Msg("Failed to flush audit events")
+	}
+
 	// Save final state if using file storage with periodic saver
 	if periodicSaver != nil {
 		log.Info().Msg("Stopping periodic saver...")
@@ -283,25 +588,72 @@ func main() {
 	log.Info().Msg("Server shutdown complete")
 }
 
-func loggingMiddleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		start := time.Now()
+// loggingMiddleware logs every request, records it in serverStats keyed by route template
+// and keeps it in requestLog
+func loggingMiddleware(serverStats *stats.Collector, requestLog *stats.RequestLog) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			start := time.Now()
+
+			// Wrap the ResponseWriter to capture status and size
+			ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
 
-		// Wrap the ResponseWriter to capture status and size
-		ww := middleware.NewWrapResponseWriter(w, r.ProtoMajor)
+			next.ServeHTTP(ww, r)
 
-		next.ServeHTTP(ww, r)
+			duration := time.Since(start)
 
-		duration := time.Since(start)
+			// The route pattern is known once the router has matched the request
+			route := "unmatched"
+			if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" {
+				route = r.Method + " " + rctx.RoutePattern()
+			}
+			serverStats.Record(route, ww.Status(), duration)
+			requestLog.Add(stats.RequestRecord{
+				Time:     start,
+				Method:   r.Method,
+				Path:     r.URL.Path,
+				Status:   ww.Status(),
+				ClientIP: gzipmw.ClientIP(r),
+			})
+
+			log.Info().
+				Str("method", r.Method).
+				Str("uri", r.RequestURI).
+				Int("status", ww.Status()).
+				Int("size", ww.BytesWritten()).
+				Dur("duration", duration).
+				Msg("handled request")
+		})
+	}
+}
+
+// newGRPCServer creates the gRPC server with the given options plus the interceptors,
+// TLS credentials and, when enabled, the reflection service the configuration asks for
+func newGRPCServer(cfg *config.Config, privateKeys []*rsa.PrivateKey, opts ...grpc.ServerOption) (*grpc.Server, error) {
+	var unaryInterceptors []grpc.UnaryServerInterceptor
+	if cfg.TrustedSubnet != "" {
+		unaryInterceptors = append(unaryInterceptors, grpcserver.TrustedSubnetInterceptor(cfg.TrustedSubnet))
+		opts = append(opts, grpc.StreamInterceptor(grpcserver.TrustedSubnetStreamInterceptor(cfg.TrustedSubnet)))
+	}
+	if len(privateKeys) > 0 {
+		unaryInterceptors = append(unaryInterceptors, grpcserver.DecryptionInterceptor(privateKeys...))
+	}
+	if len(unaryInterceptors) > 0 {
+		opts = append(opts, grpc.ChainUnaryInterceptor(unaryInterceptors...))
+	}
+	if cfg.GRPCCert != "" && cfg.GRPCKey != "" {
+		tlsOpt, err := grpcserver.TLSServerOption(cfg.GRPCCert, cfg.GRPCKey)
+		if err != nil {
+			return nil, err
+		}
+		opts = append(opts, tlsOpt)
+	}
 
-		log.Info().
-			Str("method", r.Method).
-			Str("uri", r.RequestURI).
-			Int("status", ww.Status()).
-			Int("size", ww.BytesWritten()).
-			Dur("duration", duration).
-			Msg("handled request")
-	})
+	s := grpc.NewServer(opts...)
+	if cfg.GRPCReflection {
+		reflection.Register(s)
+	}
+	return s, nil
 }
 
 func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
@@ -311,3 +663,24 @@ func loadPrivateKey(path string) (*rsa.PrivateKey, error) {
 	}
 	return privateKey, nil
 }
+
+// listenHTTP opens the HTTP listener: a Unix socket readable and writable by the
+// server's user and group for unix:// addresses, a TCP port otherwise. A socket left
+// behind by a server that did not shut down cleanly is removed first.
+func listenHTTP(cfg *config.Config) (net.Listener, error) {
+	path, ok := cfg.HTTPSocketPath()
+	if !ok {
+		return net.Listen("tcp", cfg.HTTPListenAddress())
+	}
+
+	if info, err := os.Lstat(path); err == nil {
+		if info.Mode().Type() != fs.ModeSocket {
+			return nil, fmt.Errorf("%s exists and is not a socket", path)
+		}
+		if err := os.Remove(path); err != nil {
+			return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+		}
+	}
+
+	return listenUnix(path)
+}
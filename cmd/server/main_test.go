@@ -3,23 +3,35 @@ package main
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
 	"encoding/json"
 	"io"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"slices"
 	"testing"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mutualEvg/metrics-server/config"
+	"github.com/mutualEvg/metrics-server/internal/grpcserver"
 	"github.com/mutualEvg/metrics-server/internal/handlers"
 	gzipmw "github.com/mutualEvg/metrics-server/internal/middleware"
 	"github.com/mutualEvg/metrics-server/internal/models"
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
 	"github.com/mutualEvg/metrics-server/storage"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	reflectionpb "google.golang.org/grpc/reflection/grpc_reflection_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
 )
 
 func TestUpdateHandler(t *testing.T) {
 	storage := storage.NewMemStorage()
 	router := chi.NewRouter()
-	router.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(storage))
+	router.Post("/update/{type}/{name}/{value}", handlers.UpdateHandler(storage, nil, handlers.DefaultConfig()))
 
 	tests := []struct {
 		name       string
@@ -85,7 +97,7 @@ func TestUpdateHandler(t *testing.T) {
 func TestUpdateJSONHandler(t *testing.T) {
 	storage := storage.NewMemStorage()
 	router := chi.NewRouter()
-	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil))
+	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil, handlers.DefaultConfig()))
 
 	tests := []struct {
 		name       string
@@ -219,7 +231,7 @@ func TestGzipCompression(t *testing.T) {
 	storage := storage.NewMemStorage()
 	router := chi.NewRouter()
 	router.Use(gzipmw.GzipMiddleware)
-	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil))
+	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil, handlers.DefaultConfig()))
 
 	metric := models.Metrics{
 		ID:    "testGauge",
@@ -266,7 +278,7 @@ func TestGzipDecompression(t *testing.T) {
 	storage := storage.NewMemStorage()
 	router := chi.NewRouter()
 	router.Use(gzipmw.GzipMiddleware)
-	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil))
+	router.Post("/update/", handlers.UpdateJSONHandler(storage, nil, handlers.DefaultConfig()))
 
 	metric := models.Metrics{
 		ID:    "testGauge",
@@ -298,3 +310,68 @@ func TestGzipDecompression(t *testing.T) {
 		t.Errorf("Expected gauge value 123.45, got %f", value)
 	}
 }
+
+// listGRPCServices lists the services of a server built by newGRPCServer through the
+// reflection API
+func listGRPCServices(t *testing.T, cfg *config.Config) ([]string, error) {
+	t.Helper()
+
+	s, err := newGRPCServer(cfg, nil)
+	if err != nil {
+		t.Fatalf("Failed to create gRPC server: %v", err)
+	}
+	pb.RegisterMetricsServer(s, grpcserver.NewMetricsServer(storage.NewMemStorage(), grpcserver.DefaultConfig()))
+
+	lis := bufconn.Listen(1024 * 1024)
+	go s.Serve(lis)
+	t.Cleanup(s.Stop)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	stream, err := reflectionpb.NewServerReflectionClient(conn).ServerReflectionInfo(context.Background())
+	if err != nil {
+		return nil, err
+	}
+	req := &reflectionpb.ServerReflectionRequest{
+		MessageRequest: &reflectionpb.ServerReflectionRequest_ListServices{},
+	}
+	if err := stream.Send(req); err != nil {
+		return nil, err
+	}
+	resp, err := stream.Recv()
+	if err != nil {
+		return nil, err
+	}
+
+	var services []string
+	for _, service := range resp.GetListServicesResponse().GetService() {
+		services = append(services, service.Name)
+	}
+	return services, nil
+}
+
+func TestGRPCReflection(t *testing.T) {
+	t.Run("enabled", func(t *testing.T) {
+		services, err := listGRPCServices(t, &config.Config{GRPCReflection: true})
+		if err != nil {
+			t.Fatalf("Failed to list services: %v", err)
+		}
+		if !slices.Contains(services, pb.Metrics_ServiceDesc.ServiceName) {
+			t.Errorf("Expected %s among reflected services, got %v", pb.Metrics_ServiceDesc.ServiceName, services)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		_, err := listGRPCServices(t, &config.Config{})
+		if status.Code(err) != codes.Unimplemented {
+			t.Errorf("Expected Unimplemented without reflection, got %v", err)
+		}
+	})
+}
@@ -0,0 +1,124 @@
+//go:build integration
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// writeSelfSignedCert generates a self-signed certificate for 127.0.0.1/localhost,
+// writes the PEM-encoded certificate and key into dir and returns a pool trusting it
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string, roots *x509.CertPool) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatalf("Failed to parse certificate: %v", err)
+	}
+	roots = x509.NewCertPool()
+	roots.AddCert(cert)
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile, roots
+}
+
+func TestHTTPS(t *testing.T) {
+	port := 18096
+	certFile, keyFile, roots := writeSelfSignedCert(t, t.TempDir())
+
+	server := exec.Command("../../server", "-a", "localhost:"+strconv.Itoa(port), "-tls-cert", certFile, "-tls-key", keyFile)
+	server.Stdout = os.Stdout
+	server.Stderr = os.Stderr
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		_ = server.Process.Kill()
+		_ = server.Wait()
+	}()
+	time.Sleep(2 * time.Second)
+
+	baseURL := "https://localhost:" + strconv.Itoa(port)
+	client := &http.Client{
+		Timeout:   5 * time.Second,
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: roots}},
+	}
+
+	resp, err := client.Post(baseURL+"/update/gauge/TLSGauge/12.5", "text/plain", nil)
+	if err != nil {
+		t.Fatalf("Failed to update the metric over HTTPS: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200 for the update, got %d", resp.StatusCode)
+	}
+
+	resp, err = client.Get(baseURL + "/value/gauge/TLSGauge")
+	if err != nil {
+		t.Fatalf("Failed to read the metric over HTTPS: %v", err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "12.5" {
+		t.Errorf("Expected value 12.5, got %q", body)
+	}
+
+	// Plain HTTP is rejected by the TLS listener
+	resp, err = http.Get("http://localhost:" + strconv.Itoa(port) + "/value/gauge/TLSGauge")
+	if err == nil {
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("Expected plain HTTP to be rejected, got status %d", resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,64 @@
+//go:build integration
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/batch"
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/retry"
+	"github.com/mutualEvg/metrics-server/internal/utils"
+)
+
+func TestUnixSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	addr := utils.UnixScheme + socket
+
+	server := exec.Command("../../server", "-a", addr)
+	server.Stdout = os.Stdout
+	server.Stderr = os.Stderr
+	if err := server.Start(); err != nil {
+		t.Fatalf("Failed to start server: %v", err)
+	}
+	defer func() {
+		_ = server.Process.Kill()
+		_ = server.Wait()
+	}()
+	time.Sleep(2 * time.Second)
+
+	info, err := os.Stat(socket)
+	if err != nil {
+		t.Fatalf("Server did not create the socket: %v", err)
+	}
+	if perm := info.Mode().Perm(); perm != socketMode {
+		t.Errorf("Expected socket permissions %o, got %o", socketMode, perm)
+	}
+
+	// Send the metric the way the agent does
+	value := 42.5
+	metrics := []models.Metrics{{ID: "SocketGauge", MType: "gauge", Value: &value}}
+	if err := batch.Send(metrics, addr, "", retry.DefaultConfig()); err != nil {
+		t.Fatalf("Failed to send batch over the socket: %v", err)
+	}
+
+	client := utils.NewHTTPClient(addr, 5*time.Second)
+	resp, err := client.Get(utils.HTTPBaseURL(addr) + "/value/gauge/SocketGauge")
+	if err != nil {
+		t.Fatalf("Failed to read the metric over the socket: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", resp.StatusCode, body)
+	}
+	if string(body) != "42.5" {
+		t.Errorf("Expected value 42.5, got %q", body)
+	}
+}
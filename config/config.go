@@ -2,69 +2,254 @@
 package config
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"flag"
 	"log"
 	"os"
 	"strconv"
+	"strings"
 	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/hash"
+	"github.com/mutualEvg/metrics-server/internal/models"
 )
 
 type Config struct {
 	ServerAddress   string
-	PollInterval    time.Duration
-	ReportInterval  time.Duration
 	StoreInterval   time.Duration
 	FileStoragePath string
 	Restore         bool
+	SplitFiles      bool // Persist gauges and counters in separate files
 	DatabaseDSN     string
 	UseFileStorage  bool   // Indicates if file storage was explicitly configured
 	Key             string // Key for SHA256 signature verification
-	CryptoKey       string // Path to private key file for decryption
+	HashStrict      bool   // Reject unsigned requests when a key is configured
+	HashAlgo        string // Signing algorithm, "sha256" or "sha512"
 	AuditFile       string // Path to audit log file (optional)
 	AuditURL        string // URL for remote audit server (optional)
-	TrustedSubnet   string // Trusted subnet in CIDR notation (optional)
+	TrustedSubnet   string // Trusted subnets as comma-separated CIDRs (optional)
 	GRPCAddress     string // gRPC server address (optional)
+	GRPCCert        string // Path to TLS certificate for the gRPC server (optional)
+	GRPCKey         string // Path to TLS private key for the gRPC server (optional)
+	GRPCReflection  bool   // Register the gRPC server reflection service for tools such as grpcurl
+	ReplicaOf       string // gRPC address of a primary server to replicate from (optional)
+	GRPCCA          string // Path to CA certificate verifying the primary's gRPC TLS (optional)
+	ReplicaInterval time.Duration
+	GRPCMaxMsgSize  int           // Largest gRPC message received or sent in bytes
+	GRPCKeepalive   time.Duration // Idle time after which the gRPC server pings a connection
+	GRPCMinPing     time.Duration // Shortest interval at which gRPC clients may ping the server
+	MaxInFlight     int           // Maximum concurrent HTTP requests before responding 429 (0 disables)
+	DBErrorPercent  int           // Percentage of failed database operations above which updates get 503 (0 disables)
+	RetryAfter      time.Duration // Retry-After hint sent with 429 responses
+	RateLimit       int           // Per-IP requests per second on update endpoints (0 disables)
+	RateBurst       int           // Per-IP burst size for the rate limiter
+	TrustedProxies  string        // Comma-separated CIDRs of proxies whose X-Real-IP identifies the client for rate limiting (optional)
+	MaxMetricSize   int           // Largest accepted size of a single metric in bytes (0 disables)
+	GzipLevel       int           // Compression level for gzip responses
+	GzipMinSize     int           // Responses smaller than this many bytes are sent uncompressed (0 compresses all)
+	AdminToken      string        // Bearer token for administrative endpoints (optional)
+	EnableAdmin     bool          // Serve destructive admin endpoints such as POST /admin/reset
+	DebugRequests   int           // Number of recent requests kept for /debug/requests
+	ReservedPrefix  string        // Metric name prefix clients may not write to (optional)
+	MetricAllow     string        // Regular expression metric names must match to be written (optional)
+	MetricDeny      string        // Regular expression metric names must not match to be written (optional)
+	MaxBodySize     int64         // Largest accepted decompressed request body in bytes
+	OpenMetrics     bool          // Serve /metrics as OpenMetrics with exemplars to clients accepting it
+	DBSchema        string        // Database schema qualifying the metric tables (optional)
+	EnforceTypes    bool          // Reject writes changing the type a metric was first seen with
+	CounterDelta    bool          // Send the applied delta in X-Counter-Delta on counter updates
+	LenientFloats   bool          // Coerce NaN and infinite gauge values to 0 instead of rejecting them
+	DBPartitions    int           // Hash partitions per metric table when creating them (0 disables)
+	DBMaxOpen       int           // Maximum open database connections (0 for unlimited)
+	DBMaxIdle       int           // Maximum idle database connections (0 for the database/sql default of 2)
+	DBConnLifetime  time.Duration // Maximum age of a database connection (0 for no limit)
+	NATSAddress     string        // NATS server URL to receive agent metrics from (optional)
+	NATSSubject     string        // NATS subject agents publish metric batches to
+	NATSQueue       string        // NATS queue group shared by server instances (optional)
+	RootCacheTTL    time.Duration // How long the metrics page is served from cache (0 disables)
+	AuditFlush      time.Duration // Buffer audit file writes and flush at this interval (0 writes each event)
+	AuditMaxSize    int64         // Rotate the audit file before it exceeds this size in bytes (0 disables)
+	AuditBackups    int           // Number of rotated audit files kept
+	AuditBatch      int           // Events posted per request to the audit URL (1 posts single events)
+	AuditSyslog     string        // Syslog to write audit events to: local, udp://host:port or tcp://host:port (optional)
+	TLSCert         string        // Path to TLS certificate for the HTTP server; HTTPS is served when set with TLSKey (optional)
+	TLSKey          string        // Path to TLS private key for the HTTP server (optional)
+	CryptoKeys      []string      // Paths to private key files for decryption, tried in order (optional)
+	ReadTimeout     time.Duration // Maximum time to read a whole HTTP request
+	WriteTimeout    time.Duration // Maximum time to write an HTTP response
+	IdleTimeout     time.Duration // How long an idle keep-alive connection is kept open
+	IdempotencyTTL  time.Duration // How long batch Idempotency-Keys are remembered (0 disables)
+	IdempotencyKeys int           // Most batch Idempotency-Keys remembered at once
+	RateWindow      time.Duration // Window of counter deltas kept for /rate/{name} (0 disables)
+	RateResolution  time.Duration // Granularity of the counter deltas kept for /rate/{name}
+	MaxMetrics      int           // Most distinct metrics held in memory storage (0 disables the cap)
 }
 
 // JSONConfig represents the JSON configuration file structure for server
 type JSONConfig struct {
-	Address       string `json:"address"`
-	Restore       *bool  `json:"restore"` // pointer to distinguish between false and not set
-	StoreInterval string `json:"store_interval"`
-	StoreFile     string `json:"store_file"`
-	DatabaseDSN   string `json:"database_dsn"`
-	CryptoKey     string `json:"crypto_key"`
-	TrustedSubnet string `json:"trusted_subnet"`
-	GRPCAddress   string `json:"grpc_address"`
+	Address         string `json:"address"`
+	Restore         *bool  `json:"restore"` // pointer to distinguish between false and not set
+	StoreInterval   string `json:"store_interval"`
+	StoreFile       string `json:"store_file"`
+	SplitFiles      *bool  `json:"split_files"`
+	DatabaseDSN     string `json:"database_dsn"`
+	CryptoKey       string `json:"crypto_key"`
+	TrustedSubnet   string `json:"trusted_subnet"`
+	GRPCAddress     string `json:"grpc_address"`
+	GRPCCert        string `json:"grpc_cert"`
+	GRPCKey         string `json:"grpc_key"`
+	GRPCReflection  *bool  `json:"grpc_reflection"`
+	GRPCMaxMsgSize  int    `json:"grpc_max_msg_size"`
+	GRPCKeepalive   string `json:"grpc_keepalive"`
+	GRPCMinPing     string `json:"grpc_keepalive_min_time"`
+	ReplicaOf       string `json:"replica_of"`
+	GRPCCA          string `json:"grpc_ca"`
+	ReplicaInterval string `json:"replica_interval"`
+	MaxInFlight     int    `json:"max_inflight"`
+	DBErrorPercent  int    `json:"db_error_percent"`
+	RetryAfter      string `json:"retry_after"`
+	RateLimit       int    `json:"rate_limit"`
+	RateBurst       int    `json:"rate_burst"`
+	TrustedProxies  string `json:"trusted_proxies"`
+	MaxMetricSize   *int   `json:"max_metric_size"`
+	GzipLevel       *int   `json:"gzip_level"`
+	GzipMinSize     *int   `json:"gzip_min_size"`
+	AdminToken      string `json:"admin_token"`
+	DebugRequests   int    `json:"debug_requests"`
+	ReservedPrefix  string `json:"reserved_prefix"`
+	MetricAllow     string `json:"metric_allow_regex"`
+	MetricDeny      string `json:"metric_deny_regex"`
+	MaxBodySize     int    `json:"max_body_size"`
+	HashStrict      *bool  `json:"hash_strict"`
+	HashAlgo        string `json:"hash_algo"`
+	OpenMetrics     *bool  `json:"openmetrics"`
+	DBSchema        string `json:"db_schema"`
+	EnforceTypes    *bool  `json:"enforce_metric_types"`
+	CounterDelta    *bool  `json:"counter_delta_header"`
+	LenientFloats   *bool  `json:"lenient_floats"`
+	EnableAdmin     *bool  `json:"enable_admin"`
+	DBPartitions    int    `json:"db_partitions"`
+	DBMaxOpen       int    `json:"db_max_open_conns"`
+	DBMaxIdle       int    `json:"db_max_idle_conns"`
+	DBConnLifetime  string `json:"db_conn_max_lifetime"`
+	NATSAddress     string `json:"nats_address"`
+	NATSSubject     string `json:"nats_subject"`
+	NATSQueue       string `json:"nats_queue"`
+	RootCacheTTL    string `json:"root_cache_ttl"`
+	AuditFlush      string `json:"audit_flush_interval"`
+	AuditMaxSize    int    `json:"audit_max_size"`
+	AuditBackups    int    `json:"audit_max_backups"`
+	AuditBatch      int    `json:"audit_batch_size"`
+	AuditSyslog     string `json:"audit_syslog"`
+	TLSCert         string `json:"tls_cert"`
+	TLSKey          string `json:"tls_key"`
+	ReadTimeout     string `json:"read_timeout"`
+	WriteTimeout    string `json:"write_timeout"`
+	IdleTimeout     string `json:"idle_timeout"`
+	IdempotencyTTL  string `json:"idempotency_ttl"`
+	IdempotencyKeys int    `json:"idempotency_keys"`
+	RateWindow      string `json:"rate_window"`
+	RateResolution  string `json:"rate_resolution"`
+	MaxMetrics      int    `json:"max_metrics"`
 }
 
 // configFlags holds all command-line flag values
 type configFlags struct {
 	address         *string
-	pollInterval    *int
-	storeInterval   *int
+	storeInterval   *time.Duration
 	fileStoragePath *string
 	restore         *bool
+	splitFiles      *bool
 	databaseDSN     *string
 	key             *string
-	cryptoKey       *string
+	cryptoKeys      *stringList
 	auditFile       *string
 	auditURL        *string
 	trustedSubnet   *string
 	grpcAddress     *string
+	grpcCert        *string
+	grpcKey         *string
+	grpcReflection  *bool
+	grpcMaxMsgSize  *int
+	grpcKeepalive   *time.Duration
+	grpcMinPing     *time.Duration
+	replicaOf       *string
+	grpcCA          *string
+	replicaInterval *time.Duration
+	maxInFlight     *int
+	dbErrorPercent  *int
+	retryAfter      *time.Duration
+	rateLimit       *int
+	rateBurst       *int
+	trustedProxies  *string
+	maxMetricSize   *int
+	gzipLevel       *optionalInt
+	gzipMinSize     *int
+	adminToken      *string
+	debugRequests   *int
+	reservedPrefix  *string
+	metricAllow     *string
+	metricDeny      *string
+	maxBodySize     *int
+	hashStrict      *bool
+	hashAlgo        *string
+	openMetrics     *bool
+	dbSchema        *string
+	enforceTypes    *bool
+	counterDelta    *bool
+	lenientFloats   *bool
+	enableAdmin     *bool
+	dbPartitions    *int
+	dbMaxOpen       *int
+	dbMaxIdle       *int
+	dbConnLifetime  *time.Duration
+	natsAddress     *string
+	natsSubject     *string
+	natsQueue       *string
+	rootCacheTTL    *time.Duration
+	auditFlush      *time.Duration
+	auditMaxSize    *int
+	auditBackups    *int
+	auditBatch      *int
+	auditSyslog     *string
+	tlsCert         *string
+	tlsKey          *string
+	readTimeout     *time.Duration
+	writeTimeout    *time.Duration
+	idleTimeout     *time.Duration
+	idempotencyTTL  *time.Duration
+	idempotencyKeys *int
+	rateWindow      *time.Duration
+	rateResolution  *time.Duration
+	maxMetrics      *int
 	configPath      *string
 	configPathLong  *string
 }
 
 const (
 	defaultServerAddress   = "http://localhost:8080"
-	defaultPollSeconds     = 2
-	defaultReportSeconds   = 10
-	defaultStoreSeconds    = 300
+	defaultStoreInterval   = 300 * time.Second
 	defaultFileStoragePath = "/tmp/metrics-db.json"
 	defaultRestore         = true
 	defaultDatabaseDSN     = ""
+	defaultReplicaInterval = 10 * time.Second
+	defaultRetryAfter      = 5 * time.Second
+	defaultDebugRequests   = 100
+	defaultMaxBodySize     = 10 << 20
+	defaultGzipMinSize     = 1400
+	defaultNATSSubject     = "metrics.updates"
+	defaultRootCacheTTL    = time.Second
+	defaultAuditBackups    = 5
+	defaultReadTimeout     = 10 * time.Second
+	defaultWriteTimeout    = 30 * time.Second
+	defaultIdleTimeout     = 120 * time.Second
+	defaultIdempotencyTTL  = 5 * time.Minute
+	defaultIdempotencyKeys = 10000
+	defaultRateResolution  = 10 * time.Second
+	defaultGRPCMaxMsgSize  = 16 << 20
+	defaultGRPCKeepalive   = 2 * time.Minute
+	defaultGRPCMinPing     = 30 * time.Second
 )
 
 // Load loads configuration from flags, environment variables, and JSON file
@@ -74,40 +259,151 @@ func Load() *Config {
 
 	return &Config{
 		ServerAddress:   resolveServerAddress(flags, jsonConfig),
-		PollInterval:    resolvePollInterval(flags),
-		ReportInterval:  resolveReportInterval(),
 		StoreInterval:   resolveStoreInterval(flags, jsonConfig),
 		FileStoragePath: resolveFileStoragePath(flags, jsonConfig),
 		Restore:         resolveRestore(flags, jsonConfig),
+		SplitFiles:      resolveSplitFiles(flags, jsonConfig),
 		DatabaseDSN:     resolveDatabaseDSN(flags, jsonConfig),
 		UseFileStorage:  shouldUseFileStorage(flags, jsonConfig),
 		Key:             resolveKey(flags),
-		CryptoKey:       resolveCryptoKey(flags, jsonConfig),
+		HashStrict:      resolveHashStrict(flags, jsonConfig),
+		HashAlgo:        resolveHashAlgo(flags, jsonConfig),
+		CryptoKeys:      resolveCryptoKeys(flags, jsonConfig),
 		AuditFile:       resolveAuditFile(flags),
 		AuditURL:        resolveAuditURL(flags),
 		TrustedSubnet:   resolveTrustedSubnet(flags, jsonConfig),
 		GRPCAddress:     resolveGRPCAddress(flags, jsonConfig),
+		GRPCCert:        resolveGRPCCert(flags, jsonConfig),
+		GRPCKey:         resolveGRPCKey(flags, jsonConfig),
+		GRPCReflection:  resolveGRPCReflection(flags, jsonConfig),
+		GRPCMaxMsgSize:  resolveGRPCMaxMsgSize(flags, jsonConfig),
+		GRPCKeepalive:   resolveDuration("GRPC_KEEPALIVE", *flags.grpcKeepalive, jsonConfig.grpcKeepalive(), defaultGRPCKeepalive),
+		GRPCMinPing:     resolveDuration("GRPC_KEEPALIVE_MIN_TIME", *flags.grpcMinPing, jsonConfig.grpcMinPing(), defaultGRPCMinPing),
+		ReplicaOf:       resolveReplicaOf(flags, jsonConfig),
+		GRPCCA:          resolveGRPCCA(flags, jsonConfig),
+		ReplicaInterval: resolveReplicaInterval(flags, jsonConfig),
+		MaxInFlight:     resolveMaxInFlight(flags, jsonConfig),
+		DBErrorPercent:  resolveDBErrorPercent(flags, jsonConfig),
+		RetryAfter:      resolveRetryAfter(flags, jsonConfig),
+		RateLimit:       resolveRateLimit(flags, jsonConfig),
+		RateBurst:       resolveRateBurst(flags, jsonConfig),
+		TrustedProxies:  resolveTrustedProxies(flags, jsonConfig),
+		MaxMetricSize:   resolveMaxMetricSize(flags, jsonConfig),
+		GzipLevel:       resolveGzipLevel(flags, jsonConfig),
+		GzipMinSize:     resolveGzipMinSize(flags, jsonConfig),
+		AdminToken:      resolveAdminToken(flags, jsonConfig),
+		EnableAdmin:     resolveEnableAdmin(flags, jsonConfig),
+		DebugRequests:   resolveDebugRequests(flags, jsonConfig),
+		ReservedPrefix:  resolveReservedPrefix(flags, jsonConfig),
+		MetricAllow:     resolveMetricAllow(flags, jsonConfig),
+		MetricDeny:      resolveMetricDeny(flags, jsonConfig),
+		MaxBodySize:     resolveMaxBodySize(flags, jsonConfig),
+		OpenMetrics:     resolveOpenMetrics(flags, jsonConfig),
+		DBSchema:        resolveDBSchema(flags, jsonConfig),
+		EnforceTypes:    resolveEnforceTypes(flags, jsonConfig),
+		CounterDelta:    resolveCounterDelta(flags, jsonConfig),
+		LenientFloats:   resolveLenientFloats(flags, jsonConfig),
+		DBPartitions:    resolveDBPartitions(flags, jsonConfig),
+		DBMaxOpen:       resolveDBMaxOpen(flags, jsonConfig),
+		DBMaxIdle:       resolveDBMaxIdle(flags, jsonConfig),
+		DBConnLifetime:  resolveDBConnLifetime(flags, jsonConfig),
+		NATSAddress:     resolveNATSAddress(flags, jsonConfig),
+		NATSSubject:     resolveNATSSubject(flags, jsonConfig),
+		NATSQueue:       resolveNATSQueue(flags, jsonConfig),
+		RootCacheTTL:    resolveRootCacheTTL(flags, jsonConfig),
+		AuditFlush:      resolveAuditFlush(flags, jsonConfig),
+		AuditMaxSize:    resolveAuditMaxSize(flags, jsonConfig),
+		AuditBackups:    resolveAuditBackups(flags, jsonConfig),
+		AuditBatch:      resolveAuditBatch(flags, jsonConfig),
+		AuditSyslog:     resolveAuditSyslog(flags, jsonConfig),
+		TLSCert:         resolveTLSCert(flags, jsonConfig),
+		TLSKey:          resolveTLSKey(flags, jsonConfig),
+		ReadTimeout:     resolveReadTimeout(flags, jsonConfig),
+		WriteTimeout:    resolveWriteTimeout(flags, jsonConfig),
+		IdleTimeout:     resolveIdleTimeout(flags, jsonConfig),
+		IdempotencyTTL:  resolveIdempotencyTTL(flags, jsonConfig),
+		IdempotencyKeys: resolveIdempotencyKeys(flags, jsonConfig),
+		RateWindow:      resolveDuration("RATE_WINDOW", *flags.rateWindow, jsonConfig.rateWindow(), 0),
+		RateResolution:  resolveDuration("RATE_RESOLUTION", *flags.rateResolution, jsonConfig.rateResolution(), defaultRateResolution),
+		MaxMetrics:      resolveMaxMetrics(flags, jsonConfig),
 	}
 }
 
 // parseFlags parses all command-line flags
 func parseFlags() *configFlags {
 	flags := &configFlags{
-		address:         flag.String("a", "", "HTTP server address"),
-		pollInterval:    flag.Int("p", 0, "Poll interval in seconds"),
-		storeInterval:   flag.Int("i", 0, "Store interval in seconds (0 for synchronous)"),
+		address:         flag.String("a", "", "HTTP server address or unix:///path/to/socket"),
+		storeInterval:   durationFlag("i", "Store interval, e.g. 5m or 300 seconds (0 for synchronous, default 5m)"),
 		fileStoragePath: flag.String("f", "", "File storage path"),
 		restore:         flag.Bool("r", false, "Restore previously stored values"),
+		splitFiles:      flag.Bool("split-files", false, "Store gauges and counters in separate files"),
 		databaseDSN:     flag.String("d", "", "Database connection string"),
 		key:             flag.String("k", "", "Key for SHA256 signature"),
-		cryptoKey:       flag.String("crypto-key", "", "Path to private key file for decryption"),
+		cryptoKeys:      &stringList{},
 		auditFile:       flag.String("audit-file", "", "Path to audit log file"),
 		auditURL:        flag.String("audit-url", "", "URL for remote audit server"),
-		trustedSubnet:   flag.String("t", "", "Trusted subnet in CIDR notation"),
+		trustedSubnet:   flag.String("t", "", "Trusted subnets in CIDR notation (comma-separated)"),
 		grpcAddress:     flag.String("g", "", "gRPC server address"),
+		grpcCert:        flag.String("grpc-cert", "", "Path to TLS certificate for the gRPC server"),
+		grpcKey:         flag.String("grpc-key", "", "Path to TLS private key for the gRPC server"),
+		grpcMaxMsgSize:  flag.Int("grpc-max-msg-size", 0, "Largest gRPC message received or sent in bytes (default 16MB)"),
+		grpcKeepalive:   durationFlag("grpc-keepalive", "Ping gRPC connections idle for this long, e.g. 2m (0 for the gRPC default of 2h, default 2m)"),
+		grpcMinPing:     durationFlag("grpc-keepalive-min-time", "Disconnect gRPC clients pinging more often than this (default 30s)"),
+		grpcReflection:  flag.Bool("grpc-reflection", false, "Register the gRPC server reflection service for debugging with grpcurl (not for production)"),
+		replicaOf:       flag.String("replica-of", "", "gRPC address of a primary server to replicate from"),
+		grpcCA:          flag.String("grpc-ca", "", "Path to CA certificate verifying the primary's gRPC TLS"),
+		replicaInterval: durationFlag("replica-interval", "Replica sync interval, e.g. 30s (default 10s)"),
+		maxInFlight:     flag.Int("max-inflight", 0, "Maximum concurrent HTTP requests before responding 429 (0 disables)"),
+		dbErrorPercent:  flag.Int("db-error-percent", 0, "Percentage of failed database operations above which updates are rejected with 503 (0 disables)"),
+		retryAfter:      durationFlag("retry-after", "Retry-After hint sent with rejected updates, e.g. 10s (default 5s)"),
+		rateLimit:       flag.Int("rate-limit", 0, "Per-IP requests per second on update endpoints (0 disables)"),
+		rateBurst:       flag.Int("rate-burst", 0, "Per-IP burst size for the rate limiter (default: rate limit)"),
+		trustedProxies:  flag.String("trusted-proxies", "", "Comma-separated CIDRs of reverse proxies whose X-Real-IP header identifies the client for rate limiting and idempotency keys"),
+		maxMetricSize:   flag.Int("max-metric-size", -1, "Largest accepted size of a single metric in bytes (0 disables)"),
+		gzipLevel:       &optionalInt{},
+		gzipMinSize:     flag.Int("gzip-min-size", -1, "Send responses smaller than this many bytes uncompressed (0 compresses all, default 1400)"),
+		adminToken:      flag.String("admin-token", "", "Bearer token for administrative endpoints"),
+		debugRequests:   flag.Int("debug-requests", 0, "Number of recent requests kept for /debug/requests"),
+		reservedPrefix:  flag.String("reserved-prefix", "", "Metric name prefix clients may not write to, e.g. _internal_"),
+		metricAllow:     flag.String("metric-allow-regex", "", "Accept only metric names matching this regular expression, e.g. ^[a-z][a-z0-9_]*$"),
+		metricDeny:      flag.String("metric-deny-regex", "", "Reject metric names matching this regular expression"),
+		maxBodySize:     flag.Int("max-body-size", 0, "Largest accepted decompressed request body in bytes (default 10MB)"),
+		hashStrict:      flag.Bool("hash-strict", false, "Reject requests without a HashSHA256 header when a key is configured"),
+		hashAlgo:        flag.String("hash-algo", "", "Signing algorithm: sha256 or sha512 (default: sha256)"),
+		openMetrics:     flag.Bool("openmetrics", false, "Serve /metrics as OpenMetrics with exemplars when the client accepts it"),
+		dbSchema:        flag.String("db-schema", "", "Database schema for the metric tables (default: search path)"),
+		enforceTypes:    flag.Bool("enforce-metric-types", false, "Reject writes whose type differs from the metric's first-seen type"),
+		counterDelta:    flag.Bool("counter-delta-header", false, "Send the applied delta in an X-Counter-Delta header on counter updates"),
+		lenientFloats:   flag.Bool("lenient-floats", false, "Coerce NaN and infinite gauge values to 0 instead of rejecting them"),
+		enableAdmin:     flag.Bool("enable-admin", false, "Serve destructive admin endpoints such as POST /admin/reset (test and staging only)"),
+		dbPartitions:    flag.Int("db-partitions", 0, "Hash partitions per metric table, applied when the tables are created (0 disables)"),
+		dbMaxOpen:       flag.Int("db-max-open-conns", 0, "Maximum open database connections (0 for unlimited)"),
+		dbMaxIdle:       flag.Int("db-max-idle-conns", 0, "Maximum idle database connections (default: 2)"),
+		dbConnLifetime:  durationFlag("db-conn-max-lifetime", "Maximum age of a database connection, e.g. 30m (0 for no limit)"),
+		natsAddress:     flag.String("nats", "", "NATS server URL to receive agent metrics from"),
+		natsSubject:     flag.String("nats-subject", "", "NATS subject agents publish metric batches to (default: metrics.updates)"),
+		natsQueue:       flag.String("nats-queue", "", "NATS queue group shared by server instances"),
+		rootCacheTTL:    durationFlag("root-cache-ttl", "How long the metrics page is served from cache, e.g. 500ms (0 disables, default 1s)"),
+		auditFlush:      durationFlag("audit-flush-interval", "Buffer audit file writes and flush at this interval, e.g. 5s (0 writes each event)"),
+		auditMaxSize:    flag.Int("audit-max-size", 0, "Rotate the audit file before it exceeds this size in bytes (0 disables)"),
+		auditBackups:    flag.Int("audit-max-backups", 0, "Number of rotated audit files kept as <file>.1 to <file>.N (default 5)"),
+		auditBatch:      flag.Int("audit-batch-size", 0, "Post up to N audit events per request to the audit URL as a JSON array, at least every audit flush interval (default 1, single events)"),
+		auditSyslog:     flag.String("audit-syslog", "", "Write audit events to syslog: local, udp://host:port or tcp://host:port"),
+		tlsCert:         flag.String("tls-cert", "", "Path to TLS certificate for serving HTTPS"),
+		tlsKey:          flag.String("tls-key", "", "Path to TLS private key for serving HTTPS"),
+		readTimeout:     durationFlag("read-timeout", "Maximum time to read an HTTP request (default 10s)"),
+		writeTimeout:    durationFlag("write-timeout", "Maximum time to write an HTTP response (default 30s)"),
+		idleTimeout:     durationFlag("idle-timeout", "How long an idle keep-alive connection is kept open (default 2m)"),
+		idempotencyTTL:  durationFlag("idempotency-ttl", "How long batch Idempotency-Keys are remembered, e.g. 10m (0 disables, default 5m)"),
+		idempotencyKeys: flag.Int("idempotency-keys", 0, "Most batch Idempotency-Keys remembered at once (default 10000)"),
+		rateWindow:      durationFlag("rate-window", "Track counter deltas over this window for /rate/{name}, e.g. 5m (default 0, disabled)"),
+		rateResolution:  durationFlag("rate-resolution", "Granularity of the counter deltas tracked for /rate/{name} (default 10s)"),
+		maxMetrics:      flag.Int("max-metrics", 0, "Most distinct gauges plus counters kept in memory storage; new metrics beyond it are rejected (default 0, unlimited)"),
 		configPath:      flag.String("c", "", "Path to JSON configuration file"),
 		configPathLong:  flag.String("config", "", "Path to JSON configuration file"),
 	}
+	flag.Var(flags.gzipLevel, "gzip-level", "Gzip compression level for responses (-2 to 9, -1 = default)")
+	flag.Var(flags.cryptoKeys, "crypto-key", "Path to private key file for decryption; repeat to accept payloads for several keys during key rotation")
 	flag.Parse()
 	return flags
 }
@@ -164,47 +460,151 @@ func resolveServerAddress(flags *configFlags, jsonConfig *JSONConfig) string {
 	}, defaultServerAddress)
 }
 
-// resolvePollInterval resolves the poll interval
-func resolvePollInterval(flags *configFlags) time.Duration {
-	seconds := resolveInt("POLL_INTERVAL", *flags.pollInterval, defaultPollSeconds)
-	return time.Duration(seconds) * time.Second
+// resolveStoreInterval resolves the store interval
+func resolveStoreInterval(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.StoreInterval
+	}
+	return resolveDuration("STORE_INTERVAL", *flags.storeInterval, jsonVal, defaultStoreInterval)
+}
+
+// resolveDatabaseDSN resolves the database DSN
+func resolveDatabaseDSN(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("DATABASE_DSN", *flags.databaseDSN, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.DatabaseDSN
+		}
+		return ""
+	}, defaultDatabaseDSN)
 }
 
-// resolveReportInterval resolves the report interval
-func resolveReportInterval() time.Duration {
-	seconds := resolveInt("REPORT_INTERVAL", 0, defaultReportSeconds)
-	return time.Duration(seconds) * time.Second
+// resolveDBSchema resolves the database schema qualifying the metric tables
+func resolveDBSchema(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("DB_SCHEMA", *flags.dbSchema, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.DBSchema
+		}
+		return ""
+	}, "")
 }
 
-// resolveStoreInterval resolves the store interval
-func resolveStoreInterval(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
-	seconds := resolveIntWithJSON("STORE_INTERVAL", *flags.storeInterval, func() int {
-		if jsonConfig != nil && jsonConfig.StoreInterval != "" {
-			return parseStoreIntervalFromJSON(jsonConfig.StoreInterval)
+// resolveDBPartitions resolves the number of hash partitions of the metric tables
+func resolveDBPartitions(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("DB_PARTITIONS", *flags.dbPartitions, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.DBPartitions
 		}
 		return 0
-	}, defaultStoreSeconds)
-	return time.Duration(seconds) * time.Second
+	}, 0)
 }
 
-// parseStoreIntervalFromJSON parses the store interval from JSON string
-func parseStoreIntervalFromJSON(interval string) int {
-	duration, err := time.ParseDuration(interval)
-	if err != nil {
-		log.Printf("Warning: Invalid store_interval in config file: %v", err)
+// resolveDBMaxOpen resolves the maximum number of open database connections
+func resolveDBMaxOpen(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("DB_MAX_OPEN_CONNS", *flags.dbMaxOpen, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.DBMaxOpen
+		}
 		return 0
+	}, 0)
+}
+
+// resolveDBMaxIdle resolves the maximum number of idle database connections
+func resolveDBMaxIdle(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("DB_MAX_IDLE_CONNS", *flags.dbMaxIdle, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.DBMaxIdle
+		}
+		return 0
+	}, 0)
+}
+
+// resolveDBConnLifetime resolves the maximum age of a database connection
+func resolveDBConnLifetime(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.DBConnLifetime
 	}
-	return int(duration.Seconds())
+	return resolveDuration("DB_CONN_MAX_LIFETIME", *flags.dbConnLifetime, jsonVal, 0)
 }
 
-// resolveDatabaseDSN resolves the database DSN
-func resolveDatabaseDSN(flags *configFlags, jsonConfig *JSONConfig) string {
-	return resolveStringWithJSON("DATABASE_DSN", *flags.databaseDSN, func() string {
+// resolveNATSAddress resolves the NATS server URL
+func resolveNATSAddress(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("NATS_ADDRESS", *flags.natsAddress, func() string {
 		if jsonConfig != nil {
-			return jsonConfig.DatabaseDSN
+			return jsonConfig.NATSAddress
 		}
 		return ""
-	}, defaultDatabaseDSN)
+	}, "")
+}
+
+// resolveNATSSubject resolves the NATS subject metric batches are received on
+func resolveNATSSubject(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("NATS_SUBJECT", *flags.natsSubject, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.NATSSubject
+		}
+		return ""
+	}, defaultNATSSubject)
+}
+
+// resolveNATSQueue resolves the NATS queue group
+func resolveNATSQueue(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("NATS_QUEUE", *flags.natsQueue, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.NATSQueue
+		}
+		return ""
+	}, "")
+}
+
+// resolveRootCacheTTL resolves how long the metrics page is cached
+func resolveRootCacheTTL(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.RootCacheTTL
+	}
+	return resolveDuration("ROOT_CACHE_TTL", *flags.rootCacheTTL, jsonVal, defaultRootCacheTTL)
+}
+
+// resolveEnforceTypes resolves whether metric types must stay stable over time
+func resolveEnforceTypes(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("ENFORCE_METRIC_TYPES", *flags.enforceTypes, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.EnforceTypes
+		}
+		return nil
+	}, false)
+}
+
+// resolveCounterDelta resolves whether counter updates report the applied delta in a header
+func resolveCounterDelta(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("COUNTER_DELTA_HEADER", *flags.counterDelta, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.CounterDelta
+		}
+		return nil
+	}, false)
+}
+
+// resolveLenientFloats resolves whether non-finite gauge values are coerced to 0
+func resolveLenientFloats(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("LENIENT_FLOATS", *flags.lenientFloats, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.LenientFloats
+		}
+		return nil
+	}, false)
+}
+
+// resolveGRPCReflection resolves whether the gRPC reflection service is registered
+func resolveGRPCReflection(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("GRPC_REFLECTION", *flags.grpcReflection, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.GRPCReflection
+		}
+		return nil
+	}, false)
 }
 
 // resolveRestore resolves the restore flag
@@ -217,19 +617,105 @@ func resolveRestore(flags *configFlags, jsonConfig *JSONConfig) bool {
 	}, defaultRestore)
 }
 
+// resolveSplitFiles resolves whether file storage is split into gauge and counter files
+func resolveSplitFiles(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("SPLIT_FILES", *flags.splitFiles, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.SplitFiles
+		}
+		return nil
+	}, false)
+}
+
 // resolveKey resolves the signature key
 func resolveKey(flags *configFlags) string {
 	return resolveString("KEY", *flags.key, "")
 }
 
-// resolveCryptoKey resolves the crypto key path
-func resolveCryptoKey(flags *configFlags, jsonConfig *JSONConfig) string {
-	return resolveStringWithJSON("CRYPTO_KEY", *flags.cryptoKey, func() string {
+// resolveHashStrict resolves whether unsigned requests are rejected
+func resolveHashStrict(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("HASH_STRICT", *flags.hashStrict, func() *bool {
 		if jsonConfig != nil {
-			return jsonConfig.CryptoKey
+			return jsonConfig.HashStrict
+		}
+		return nil
+	}, false)
+}
+
+// resolveHashAlgo resolves the signing algorithm and exits if it is not supported
+func resolveHashAlgo(flags *configFlags, jsonConfig *JSONConfig) string {
+	algo := resolveStringWithJSON("HASH_ALGO", *flags.hashAlgo, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.HashAlgo
 		}
 		return ""
-	}, "")
+	}, hash.AlgoSHA256)
+
+	if !hash.Supported(algo) {
+		log.Fatalf("Invalid hash algorithm %q: must be %s or %s", algo, hash.AlgoSHA256, hash.AlgoSHA512)
+	}
+	return algo
+}
+
+// resolveCryptoKeys resolves the private key paths. CRYPTO_KEY and crypto_key hold
+// comma-separated paths, while -crypto-key may be given multiple times.
+func resolveCryptoKeys(flags *configFlags, jsonConfig *JSONConfig) []string {
+	if val := os.Getenv("CRYPTO_KEY"); val != "" {
+		return splitList(val)
+	}
+	if len(*flags.cryptoKeys) > 0 {
+		return *flags.cryptoKeys
+	}
+	if jsonConfig != nil && jsonConfig.CryptoKey != "" {
+		return splitList(jsonConfig.CryptoKey)
+	}
+	return nil
+}
+
+// stringList is a flag.Value collecting the values of a flag given multiple times
+type stringList []string
+
+func (l *stringList) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *stringList) Set(value string) error {
+	*l = append(*l, value)
+	return nil
+}
+
+// optionalInt is a flag.Value for an int flag without an unused value to mark it unset,
+// recording whether the flag was given at all
+type optionalInt struct {
+	value int
+	set   bool
+}
+
+func (o *optionalInt) String() string {
+	if o == nil || !o.set {
+		return ""
+	}
+	return strconv.Itoa(o.value)
+}
+
+func (o *optionalInt) Set(value string) error {
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		return err
+	}
+	o.value, o.set = n, true
+	return nil
+}
+
+// splitList splits a comma-separated list, dropping empty entries
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
 
 // resolveAuditFile resolves the audit file path
@@ -242,6 +728,232 @@ func resolveAuditURL(flags *configFlags) string {
 	return resolveString("AUDIT_URL", *flags.auditURL, "")
 }
 
+// resolveAuditFlush resolves the flush interval of the buffered audit file
+func resolveAuditFlush(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.AuditFlush
+	}
+	return resolveDuration("AUDIT_FLUSH_INTERVAL", *flags.auditFlush, jsonVal, 0)
+}
+
+// resolveAuditMaxSize resolves the size at which the audit file is rotated
+func resolveAuditMaxSize(flags *configFlags, jsonConfig *JSONConfig) int64 {
+	return int64(resolveIntWithJSON("AUDIT_MAX_SIZE", *flags.auditMaxSize, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.AuditMaxSize
+		}
+		return 0
+	}, 0))
+}
+
+// resolveAuditBackups resolves the number of rotated audit files kept
+func resolveAuditBackups(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("AUDIT_MAX_BACKUPS", *flags.auditBackups, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.AuditBackups
+		}
+		return 0
+	}, defaultAuditBackups)
+}
+
+// resolveAuditBatch resolves the number of audit events posted per request to the audit URL
+func resolveAuditBatch(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("AUDIT_BATCH_SIZE", *flags.auditBatch, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.AuditBatch
+		}
+		return 0
+	}, 1)
+}
+
+// resolveAuditSyslog resolves the syslog audit events are written to
+func resolveAuditSyslog(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("AUDIT_SYSLOG", *flags.auditSyslog, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.AuditSyslog
+		}
+		return ""
+	}, "")
+}
+
+// resolveTLSCert resolves the HTTP server TLS certificate path
+func resolveTLSCert(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("TLS_CERT", *flags.tlsCert, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.TLSCert
+		}
+		return ""
+	}, "")
+}
+
+// resolveTLSKey resolves the HTTP server TLS private key path
+func resolveTLSKey(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("TLS_KEY", *flags.tlsKey, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.TLSKey
+		}
+		return ""
+	}, "")
+}
+
+// resolveReadTimeout resolves the HTTP server read timeout
+func resolveReadTimeout(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.ReadTimeout
+	}
+	return resolveDuration("READ_TIMEOUT", *flags.readTimeout, jsonVal, defaultReadTimeout)
+}
+
+// resolveWriteTimeout resolves the HTTP server write timeout
+func resolveWriteTimeout(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.WriteTimeout
+	}
+	return resolveDuration("WRITE_TIMEOUT", *flags.writeTimeout, jsonVal, defaultWriteTimeout)
+}
+
+// resolveIdleTimeout resolves the HTTP server keep-alive idle timeout
+func resolveIdleTimeout(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.IdleTimeout
+	}
+	return resolveDuration("IDLE_TIMEOUT", *flags.idleTimeout, jsonVal, defaultIdleTimeout)
+}
+
+// resolveIdempotencyTTL resolves how long batch Idempotency-Keys are remembered
+func resolveIdempotencyTTL(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.IdempotencyTTL
+	}
+	return resolveDuration("IDEMPOTENCY_TTL", *flags.idempotencyTTL, jsonVal, defaultIdempotencyTTL)
+}
+
+// rateWindow returns the rate_window of the config file, if any
+func (c *JSONConfig) rateWindow() string {
+	if c == nil {
+		return ""
+	}
+	return c.RateWindow
+}
+
+// rateResolution returns the rate_resolution of the config file, if any
+func (c *JSONConfig) rateResolution() string {
+	if c == nil {
+		return ""
+	}
+	return c.RateResolution
+}
+
+// resolveDuration resolves a duration from the environment variable, the flag
+// (negative when unset) or the config file value, each parsed by parseDuration
+func resolveDuration(envVar string, flagVal time.Duration, jsonVal string, def time.Duration) time.Duration {
+	if val := os.Getenv(envVar); val != "" {
+		d, err := parseDuration(val)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envVar, err)
+		}
+		return d
+	}
+	if flagVal >= 0 {
+		return flagVal
+	}
+	if jsonVal != "" {
+		d, err := parseDuration(jsonVal)
+		if err == nil {
+			return d
+		}
+		log.Printf("Warning: Invalid %s in config file: %v", strings.ToLower(envVar), err)
+	}
+	return def
+}
+
+// parseDuration parses a duration such as "10s", or a bare number of seconds as
+// accepted by the flags and variables that used to take seconds, e.g. STORE_INTERVAL=300
+func parseDuration(s string) (time.Duration, error) {
+	if seconds, err := strconv.Atoi(s); err == nil {
+		return time.Duration(seconds) * time.Second, nil
+	}
+	return time.ParseDuration(s)
+}
+
+// durationValue is a flag.Value parsing durations with parseDuration
+type durationValue time.Duration
+
+// String returns "" while unset or 0, so that usage does not print the -1 marker as a default
+func (d *durationValue) String() string {
+	if d == nil || *d <= 0 {
+		return ""
+	}
+	return time.Duration(*d).String()
+}
+
+func (d *durationValue) Set(value string) error {
+	v, err := parseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = durationValue(v)
+	return nil
+}
+
+// durationFlag defines a duration flag parsed with parseDuration, negative while unset
+func durationFlag(name, usage string) *time.Duration {
+	d := time.Duration(-1)
+	flag.Var((*durationValue)(&d), name, usage)
+	return &d
+}
+
+// grpcKeepalive returns the grpc_keepalive of the config file, if any
+func (c *JSONConfig) grpcKeepalive() string {
+	if c == nil {
+		return ""
+	}
+	return c.GRPCKeepalive
+}
+
+// grpcMinPing returns the grpc_keepalive_min_time of the config file, if any
+func (c *JSONConfig) grpcMinPing() string {
+	if c == nil {
+		return ""
+	}
+	return c.GRPCMinPing
+}
+
+// resolveGRPCMaxMsgSize resolves the largest gRPC message received or sent
+func resolveGRPCMaxMsgSize(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("GRPC_MAX_MSG_SIZE", *flags.grpcMaxMsgSize, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.GRPCMaxMsgSize
+		}
+		return 0
+	}, defaultGRPCMaxMsgSize)
+}
+
+// resolveMaxMetrics resolves the cap on distinct metrics in memory storage
+func resolveMaxMetrics(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("MAX_METRICS", *flags.maxMetrics, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.MaxMetrics
+		}
+		return 0
+	}, 0)
+}
+
+// resolveIdempotencyKeys resolves how many batch Idempotency-Keys are remembered at once
+func resolveIdempotencyKeys(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("IDEMPOTENCY_KEYS", *flags.idempotencyKeys, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.IdempotencyKeys
+		}
+		return 0
+	}, defaultIdempotencyKeys)
+}
+
 // resolveTrustedSubnet resolves the trusted subnet
 func resolveTrustedSubnet(flags *configFlags, jsonConfig *JSONConfig) string {
 	return resolveStringWithJSON("TRUSTED_SUBNET", *flags.trustedSubnet, func() string {
@@ -262,6 +974,253 @@ func resolveGRPCAddress(flags *configFlags, jsonConfig *JSONConfig) string {
 	}, "")
 }
 
+// resolveGRPCCert resolves the gRPC TLS certificate path
+func resolveGRPCCert(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("GRPC_CERT", *flags.grpcCert, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.GRPCCert
+		}
+		return ""
+	}, "")
+}
+
+// resolveGRPCKey resolves the gRPC TLS private key path
+func resolveGRPCKey(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("GRPC_KEY", *flags.grpcKey, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.GRPCKey
+		}
+		return ""
+	}, "")
+}
+
+// resolveReplicaOf resolves the primary server address for read-replica mode
+func resolveReplicaOf(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("REPLICA_OF", *flags.replicaOf, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.ReplicaOf
+		}
+		return ""
+	}, "")
+}
+
+// resolveGRPCCA resolves the CA certificate path for dialing the primary over TLS
+func resolveGRPCCA(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("GRPC_CA", *flags.grpcCA, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.GRPCCA
+		}
+		return ""
+	}, "")
+}
+
+// resolveReplicaInterval resolves the replica sync interval
+func resolveReplicaInterval(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.ReplicaInterval
+	}
+	return resolveDuration("REPLICA_INTERVAL", *flags.replicaInterval, jsonVal, defaultReplicaInterval)
+}
+
+// resolveMaxInFlight resolves the HTTP concurrency limit
+func resolveMaxInFlight(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("MAX_INFLIGHT", *flags.maxInFlight, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.MaxInFlight
+		}
+		return 0
+	}, 0)
+}
+
+// resolveDBErrorPercent resolves the database failure percentage triggering backpressure
+func resolveDBErrorPercent(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("DB_ERROR_PERCENT", *flags.dbErrorPercent, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.DBErrorPercent
+		}
+		return 0
+	}, 0)
+}
+
+// resolveRetryAfter resolves the Retry-After hint for rejected requests
+func resolveRetryAfter(flags *configFlags, jsonConfig *JSONConfig) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.RetryAfter
+	}
+	return resolveDuration("RETRY_AFTER", *flags.retryAfter, jsonVal, defaultRetryAfter)
+}
+
+// resolveRateLimit resolves the per-IP rate limit for update endpoints
+func resolveRateLimit(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("RATE_LIMIT", *flags.rateLimit, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.RateLimit
+		}
+		return 0
+	}, 0)
+}
+
+// resolveRateBurst resolves the per-IP rate limiter burst size
+func resolveRateBurst(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("RATE_BURST", *flags.rateBurst, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.RateBurst
+		}
+		return 0
+	}, 0)
+}
+
+// resolveTrustedProxies resolves the reverse proxies trusted to report the client address
+func resolveTrustedProxies(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("TRUSTED_PROXIES", *flags.trustedProxies, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.TrustedProxies
+		}
+		return ""
+	}, "")
+}
+
+// resolveMaxMetricSize resolves the per-metric size limit.
+// The flag defaults to -1 and the JSON field is a pointer so that an explicit 0 can
+// disable the check.
+func resolveMaxMetricSize(flags *configFlags, jsonConfig *JSONConfig) int {
+	if val := os.Getenv("MAX_METRIC_SIZE"); val != "" {
+		size, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatalf("Invalid MAX_METRIC_SIZE: %v", err)
+		}
+		return size
+	}
+	if *flags.maxMetricSize >= 0 {
+		return *flags.maxMetricSize
+	}
+	if jsonConfig != nil && jsonConfig.MaxMetricSize != nil {
+		return *jsonConfig.MaxMetricSize
+	}
+	return models.DefaultMaxMetricSize
+}
+
+// resolveGzipLevel resolves the gzip compression level for responses.
+// Every int is a level to the flag, so it records whether it was given; the range is
+// checked by Validate.
+func resolveGzipLevel(flags *configFlags, jsonConfig *JSONConfig) int {
+	if val := os.Getenv("GZIP_LEVEL"); val != "" {
+		level, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatalf("Invalid GZIP_LEVEL: %v", err)
+		}
+		return level
+	}
+	if flags.gzipLevel.set {
+		return flags.gzipLevel.value
+	}
+	if jsonConfig != nil && jsonConfig.GzipLevel != nil {
+		return *jsonConfig.GzipLevel
+	}
+	return gzip.DefaultCompression
+}
+
+// resolveGzipMinSize resolves the size below which responses are not compressed.
+// The flag defaults to -1 so that an explicit 0 can compress every response.
+func resolveGzipMinSize(flags *configFlags, jsonConfig *JSONConfig) int {
+	if val := os.Getenv("GZIP_MIN_SIZE"); val != "" {
+		size, err := strconv.Atoi(val)
+		if err != nil {
+			log.Fatalf("Invalid GZIP_MIN_SIZE: %v", err)
+		}
+		return size
+	}
+	if *flags.gzipMinSize >= 0 {
+		return *flags.gzipMinSize
+	}
+	if jsonConfig != nil && jsonConfig.GzipMinSize != nil {
+		return *jsonConfig.GzipMinSize
+	}
+	return defaultGzipMinSize
+}
+
+// resolveAdminToken resolves the bearer token for administrative endpoints
+func resolveAdminToken(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("ADMIN_TOKEN", *flags.adminToken, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.AdminToken
+		}
+		return ""
+	}, "")
+}
+
+// resolveEnableAdmin resolves whether destructive admin endpoints are served
+func resolveEnableAdmin(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("ENABLE_ADMIN", *flags.enableAdmin, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.EnableAdmin
+		}
+		return nil
+	}, false)
+}
+
+// resolveDebugRequests resolves the size of the recent requests buffer
+func resolveDebugRequests(flags *configFlags, jsonConfig *JSONConfig) int {
+	return resolveIntWithJSON("DEBUG_REQUESTS", *flags.debugRequests, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.DebugRequests
+		}
+		return 0
+	}, defaultDebugRequests)
+}
+
+// resolveReservedPrefix resolves the metric name prefix reserved for the server's own metrics
+func resolveReservedPrefix(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("RESERVED_PREFIX", *flags.reservedPrefix, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.ReservedPrefix
+		}
+		return ""
+	}, "")
+}
+
+// resolveMetricAllow resolves the regular expression metric names must match
+func resolveMetricAllow(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("METRIC_ALLOW_REGEX", *flags.metricAllow, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.MetricAllow
+		}
+		return ""
+	}, "")
+}
+
+// resolveMetricDeny resolves the regular expression metric names must not match
+func resolveMetricDeny(flags *configFlags, jsonConfig *JSONConfig) string {
+	return resolveStringWithJSON("METRIC_DENY_REGEX", *flags.metricDeny, func() string {
+		if jsonConfig != nil {
+			return jsonConfig.MetricDeny
+		}
+		return ""
+	}, "")
+}
+
+// resolveMaxBodySize resolves the limit for decompressed request bodies
+func resolveMaxBodySize(flags *configFlags, jsonConfig *JSONConfig) int64 {
+	return int64(resolveIntWithJSON("MAX_BODY_SIZE", *flags.maxBodySize, func() int {
+		if jsonConfig != nil {
+			return jsonConfig.MaxBodySize
+		}
+		return 0
+	}, defaultMaxBodySize))
+}
+
+// resolveOpenMetrics resolves whether /metrics negotiates the OpenMetrics format
+func resolveOpenMetrics(flags *configFlags, jsonConfig *JSONConfig) bool {
+	return resolveBoolWithJSON("OPENMETRICS", *flags.openMetrics, func() *bool {
+		if jsonConfig != nil {
+			return jsonConfig.OpenMetrics
+		}
+		return nil
+	}, false)
+}
+
 // resolveFileStoragePath resolves the file storage path
 func resolveFileStoragePath(flags *configFlags, jsonConfig *JSONConfig) string {
 	// Flag has highest priority
@@ -317,21 +1276,6 @@ func resolveStringWithJSON(envVar, flagVal string, jsonGetter func() string, def
 	return def
 }
 
-// resolveInt resolves integer value with priority: env > flag > default
-func resolveInt(envVar string, flagVal, def int) int {
-	if val := os.Getenv(envVar); val != "" {
-		i, err := strconv.Atoi(val)
-		if err != nil {
-			log.Fatalf("Invalid %s: %v", envVar, err)
-		}
-		return i
-	}
-	if flagVal != 0 {
-		return flagVal
-	}
-	return def
-}
-
 // resolveIntWithJSON resolves integer value with priority: env > flag > json > default
 func resolveIntWithJSON(envVar string, flagVal int, jsonGetter func() int, def int) int {
 	if val := os.Getenv(envVar); val != "" {
@@ -1,11 +1,15 @@
 package config
 
 import (
+	"compress/gzip"
 	"encoding/json"
 	"os"
 	"path/filepath"
+	"slices"
 	"testing"
 	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
 )
 
 func TestLoadJSONConfig(t *testing.T) {
@@ -306,7 +310,177 @@ func TestStoreIntervalParsing(t *testing.T) {
 	}
 }
 
+func TestResolveIntervalPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolve  func(*configFlags, *JSONConfig) time.Duration
+		envVar   string
+		flags    func(d time.Duration) *configFlags
+		json     *JSONConfig
+		fallback time.Duration
+	}{
+		{"store", resolveStoreInterval, "STORE_INTERVAL",
+			func(d time.Duration) *configFlags { return &configFlags{storeInterval: &d} },
+			&JSONConfig{StoreInterval: "30s"}, defaultStoreInterval},
+		{"replica", resolveReplicaInterval, "REPLICA_INTERVAL",
+			func(d time.Duration) *configFlags { return &configFlags{replicaInterval: &d} },
+			&JSONConfig{ReplicaInterval: "30s"}, defaultReplicaInterval},
+		{"retry after", resolveRetryAfter, "RETRY_AFTER",
+			func(d time.Duration) *configFlags { return &configFlags{retryAfter: &d} },
+			&JSONConfig{RetryAfter: "30s"}, defaultRetryAfter},
+		{"connection lifetime", resolveDBConnLifetime, "DB_CONN_MAX_LIFETIME",
+			func(d time.Duration) *configFlags { return &configFlags{dbConnLifetime: &d} },
+			&JSONConfig{DBConnLifetime: "30s"}, 0},
+		{"audit flush", resolveAuditFlush, "AUDIT_FLUSH_INTERVAL",
+			func(d time.Duration) *configFlags { return &configFlags{auditFlush: &d} },
+			&JSONConfig{AuditFlush: "30s"}, 0},
+		{"root cache TTL", resolveRootCacheTTL, "ROOT_CACHE_TTL",
+			func(d time.Duration) *configFlags { return &configFlags{rootCacheTTL: &d} },
+			&JSONConfig{RootCacheTTL: "30s"}, defaultRootCacheTTL},
+		{"idempotency TTL", resolveIdempotencyTTL, "IDEMPOTENCY_TTL",
+			func(d time.Duration) *configFlags { return &configFlags{idempotencyTTL: &d} },
+			&JSONConfig{IdempotencyTTL: "30s"}, defaultIdempotencyTTL},
+		{"read timeout", resolveReadTimeout, "READ_TIMEOUT",
+			func(d time.Duration) *configFlags { return &configFlags{readTimeout: &d} },
+			&JSONConfig{ReadTimeout: "30s"}, defaultReadTimeout},
+		{"write timeout", resolveWriteTimeout, "WRITE_TIMEOUT",
+			func(d time.Duration) *configFlags { return &configFlags{writeTimeout: &d} },
+			&JSONConfig{WriteTimeout: "30s"}, defaultWriteTimeout},
+		{"idle timeout", resolveIdleTimeout, "IDLE_TIMEOUT",
+			func(d time.Duration) *configFlags { return &configFlags{idleTimeout: &d} },
+			&JSONConfig{IdleTimeout: "30s"}, defaultIdleTimeout},
+	}
+
+	bogus := &JSONConfig{StoreInterval: "bogus", ReplicaInterval: "bogus", RetryAfter: "bogus", DBConnLifetime: "bogus", AuditFlush: "bogus",
+		RootCacheTTL: "bogus", IdempotencyTTL: "bogus", ReadTimeout: "bogus", WriteTimeout: "bogus", IdleTimeout: "bogus"}
+	zero := &JSONConfig{StoreInterval: "0s", ReplicaInterval: "0s", RetryAfter: "0s", DBConnLifetime: "0s", AuditFlush: "0s",
+		RootCacheTTL: "0s", IdempotencyTTL: "0s", ReadTimeout: "0s", WriteTimeout: "0s", IdleTimeout: "0s"}
+	const unset = -1
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.envVar, "")
+
+			if got := tt.resolve(tt.flags(unset), nil); got != tt.fallback {
+				t.Errorf("Expected default %v, got %v", tt.fallback, got)
+			}
+			if got := tt.resolve(tt.flags(unset), bogus); got != tt.fallback {
+				t.Errorf("Expected invalid JSON value to fall back to %v, got %v", tt.fallback, got)
+			}
+			if got := tt.resolve(tt.flags(unset), zero); got != 0 {
+				t.Errorf("Expected JSON value 0s to be kept, got %v", got)
+			}
+			if got := tt.resolve(tt.flags(unset), tt.json); got != 30*time.Second {
+				t.Errorf("Expected JSON value 30s, got %v", got)
+			}
+			if got := tt.resolve(tt.flags(1500*time.Millisecond), tt.json); got != 1500*time.Millisecond {
+				t.Errorf("Expected flag to override JSON with 1.5s, got %v", got)
+			}
+
+			// Variables that used to take seconds still accept a bare number
+			t.Setenv(tt.envVar, "40")
+			if got := tt.resolve(tt.flags(20*time.Second), tt.json); got != 40*time.Second {
+				t.Errorf("Expected env to override flag with 40s, got %v", got)
+			}
+			t.Setenv(tt.envVar, "1m")
+			if got := tt.resolve(tt.flags(20*time.Second), tt.json); got != time.Minute {
+				t.Errorf("Expected env duration 1m, got %v", got)
+			}
+		})
+	}
+}
+
+func TestDurationFlag(t *testing.T) {
+	var d durationValue
+	for value, want := range map[string]time.Duration{"300": 300 * time.Second, "0": 0, "1m30s": 90 * time.Second, "250ms": 250 * time.Millisecond} {
+		if err := d.Set(value); err != nil {
+			t.Fatalf("Set(%q): %v", value, err)
+		}
+		if time.Duration(d) != want {
+			t.Errorf("Set(%q) = %v, want %v", value, time.Duration(d), want)
+		}
+	}
+	if err := d.Set("soon"); err == nil {
+		t.Error("Expected an error for an invalid duration")
+	}
+}
+
+func TestResolveMaxMetricSize(t *testing.T) {
+	t.Setenv("MAX_METRIC_SIZE", "")
+	unset := -1
+	flags := &configFlags{maxMetricSize: &unset}
+
+	if got := resolveMaxMetricSize(flags, nil); got != models.DefaultMaxMetricSize {
+		t.Errorf("Expected default %d, got %d", models.DefaultMaxMetricSize, got)
+	}
+	if got := resolveMaxMetricSize(flags, &JSONConfig{}); got != models.DefaultMaxMetricSize {
+		t.Errorf("Expected default %d without max_metric_size, got %d", models.DefaultMaxMetricSize, got)
+	}
+	if got := resolveMaxMetricSize(flags, &JSONConfig{MaxMetricSize: intPtr(0)}); got != 0 {
+		t.Errorf("Expected max_metric_size 0 to disable the limit, got %d", got)
+	}
+	if got := resolveMaxMetricSize(flags, &JSONConfig{MaxMetricSize: intPtr(512)}); got != 512 {
+		t.Errorf("Expected JSON value 512, got %d", got)
+	}
+}
+
+func TestResolveGzipLevel(t *testing.T) {
+	t.Setenv("GZIP_LEVEL", "")
+	jsonConfig := &JSONConfig{GzipLevel: intPtr(9)}
+
+	flags := &configFlags{gzipLevel: &optionalInt{}}
+	if got := resolveGzipLevel(flags, nil); got != gzip.DefaultCompression {
+		t.Errorf("Expected default level %d, got %d", gzip.DefaultCompression, got)
+	}
+	if got := resolveGzipLevel(flags, jsonConfig); got != 9 {
+		t.Errorf("Expected JSON level 9, got %d", got)
+	}
+
+	// An explicit -gzip-level=-1 restores the default over the JSON config
+	if err := flags.gzipLevel.Set("-1"); err != nil {
+		t.Fatalf("Set: %v", err)
+	}
+	if got := resolveGzipLevel(flags, jsonConfig); got != gzip.DefaultCompression {
+		t.Errorf("Expected flag level %d, got %d", gzip.DefaultCompression, got)
+	}
+
+	t.Setenv("GZIP_LEVEL", "1")
+	if got := resolveGzipLevel(flags, jsonConfig); got != 1 {
+		t.Errorf("Expected environment level 1, got %d", got)
+	}
+}
+
+// Helper function to create int pointer
+func intPtr(i int) *int {
+	return &i
+}
+
 // Helper function to create bool pointer
 func boolPtr(b bool) *bool {
 	return &b
 }
+
+func TestResolveCryptoKeys(t *testing.T) {
+	jsonConfig := &JSONConfig{CryptoKey: "/keys/old.pem, /keys/new.pem"}
+
+	t.Setenv("CRYPTO_KEY", "")
+	flags := &configFlags{cryptoKeys: &stringList{}}
+	if got := resolveCryptoKeys(flags, nil); got != nil {
+		t.Errorf("Expected no keys by default, got %v", got)
+	}
+	if got := resolveCryptoKeys(flags, jsonConfig); !slices.Equal(got, []string{"/keys/old.pem", "/keys/new.pem"}) {
+		t.Errorf("Expected the JSON keys, got %v", got)
+	}
+
+	// -crypto-key may be given multiple times
+	flags.cryptoKeys.Set("/flag/old.pem")
+	flags.cryptoKeys.Set("/flag/new.pem")
+	if got := resolveCryptoKeys(flags, jsonConfig); !slices.Equal(got, []string{"/flag/old.pem", "/flag/new.pem"}) {
+		t.Errorf("Expected the flag keys, got %v", got)
+	}
+
+	t.Setenv("CRYPTO_KEY", "/env/old.pem,/env/new.pem,")
+	if got := resolveCryptoKeys(flags, jsonConfig); !slices.Equal(got, []string{"/env/old.pem", "/env/new.pem"}) {
+		t.Errorf("Expected the environment keys, got %v", got)
+	}
+}
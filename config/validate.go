@@ -0,0 +1,109 @@
+package config
+
+import (
+	"compress/gzip"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/mutualEvg/metrics-server/internal/utils"
+)
+
+// Validate checks the loaded configuration for mistakes that would otherwise only surface
+// when the servers start, such as unparseable listen addresses, HTTP and gRPC servers
+// configured to listen on the same address, a TLS certificate of the HTTP or gRPC server
+// without its key, an invalid gzip level, a read replica configured to receive writes
+// or an invalid metric name regular expression.
+func (c *Config) Validate() error {
+	if (c.TLSCert == "") != (c.TLSKey == "") {
+		return fmt.Errorf("-tls-cert and -tls-key must be set together")
+	}
+	if (c.GRPCCert == "") != (c.GRPCKey == "") {
+		return fmt.Errorf("-grpc-cert and -grpc-key must be set together")
+	}
+	if c.GRPCCA != "" && c.ReplicaOf == "" {
+		return fmt.Errorf("-grpc-ca requires -replica-of")
+	}
+	if c.ReplicaOf != "" && c.NATSAddress != "" {
+		return fmt.Errorf("-nats cannot be used with -replica-of: replicas do not accept writes")
+	}
+	if c.GzipLevel < gzip.HuffmanOnly || c.GzipLevel > gzip.BestCompression {
+		return fmt.Errorf("-gzip-level must be between %d and %d, got %d", gzip.HuffmanOnly, gzip.BestCompression, c.GzipLevel)
+	}
+	if c.DBErrorPercent < 0 || c.DBErrorPercent > 100 {
+		return fmt.Errorf("-db-error-percent must be between 0 and 100, got %d", c.DBErrorPercent)
+	}
+	if c.RateWindow > 0 && c.RateResolution <= 0 {
+		return fmt.Errorf("-rate-resolution must be positive when -rate-window is set, got %v", c.RateResolution)
+	}
+	if _, err := regexp.Compile(c.MetricAllow); err != nil {
+		return fmt.Errorf("invalid -metric-allow-regex: %w", err)
+	}
+	if _, err := regexp.Compile(c.MetricDeny); err != nil {
+		return fmt.Errorf("invalid -metric-deny-regex: %w", err)
+	}
+
+	if path, ok := c.HTTPSocketPath(); ok {
+		if path == "" {
+			return fmt.Errorf("invalid HTTP address %q: missing socket path", c.ServerAddress)
+		}
+		// A socket cannot collide with the gRPC port
+		if c.GRPCAddress == "" {
+			return nil
+		}
+		if _, _, err := net.SplitHostPort(c.GRPCAddress); err != nil {
+			return fmt.Errorf("invalid gRPC address %q: must be host:port: %w", c.GRPCAddress, err)
+		}
+		return nil
+	}
+
+	httpHost, httpPort, err := net.SplitHostPort(c.HTTPListenAddress())
+	if err != nil {
+		return fmt.Errorf("invalid HTTP address %q: must be host:port or unix:///path: %w", c.ServerAddress, err)
+	}
+
+	if c.GRPCAddress == "" {
+		return nil
+	}
+	grpcHost, grpcPort, err := net.SplitHostPort(c.GRPCAddress)
+	if err != nil {
+		return fmt.Errorf("invalid gRPC address %q: must be host:port: %w", c.GRPCAddress, err)
+	}
+
+	if httpPort == grpcPort && hostsOverlap(httpHost, grpcHost) {
+		return fmt.Errorf("HTTP address %q and gRPC address %q collide: use different ports for -a and -g",
+			c.ServerAddress, c.GRPCAddress)
+	}
+	return nil
+}
+
+// HTTPListenAddress returns the HTTP server address without the optional http:// or https:// scheme
+func (c *Config) HTTPListenAddress() string {
+	addr := strings.TrimPrefix(c.ServerAddress, "http://")
+	return strings.TrimPrefix(addr, "https://")
+}
+
+// HTTPSocketPath returns the path of the Unix socket the HTTP server listens on when
+// ServerAddress is a unix:///path address
+func (c *Config) HTTPSocketPath() (string, bool) {
+	return utils.UnixSocketPath(c.ServerAddress)
+}
+
+// hostsOverlap reports whether listeners on the two hosts would compete for the same port.
+// An empty or unspecified host listens on all interfaces and overlaps with any host.
+func hostsOverlap(a, b string) bool {
+	if isWildcardHost(a) || isWildcardHost(b) {
+		return true
+	}
+	return strings.EqualFold(a, b)
+}
+
+// isWildcardHost reports whether host binds all interfaces
+func isWildcardHost(host string) bool {
+	if host == "" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsUnspecified()
+}
@@ -0,0 +1,150 @@
+package config
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestValidateAddresses(t *testing.T) {
+	tests := []struct {
+		name        string
+		httpAddr    string
+		grpcAddr    string
+		wantErr     bool
+		errContains string
+	}{
+		{"default without gRPC", "http://localhost:8080", "", false, ""},
+		{"different ports", "localhost:8080", "localhost:3200", false, ""},
+		{"different hosts same port", "127.0.0.1:8080", "10.0.0.1:8080", false, ""},
+		{"identical addresses", "localhost:8080", "localhost:8080", true, "collide"},
+		{"identical with scheme", "http://localhost:8080", "localhost:8080", true, "collide"},
+		{"wildcard HTTP host", ":8080", "127.0.0.1:8080", true, "collide"},
+		{"unspecified gRPC host", "localhost:8080", "0.0.0.0:8080", true, "collide"},
+		{"IPv6 identical", "[::1]:8080", "[::1]:8080", true, "collide"},
+		{"HTTP missing port", "localhost", "", true, "invalid HTTP address"},
+		{"gRPC missing port", "localhost:8080", "localhost", true, "invalid gRPC address"},
+		{"Unix socket", "unix:///run/metrics/server.sock", "", false, ""},
+		{"Unix socket with gRPC", "unix:///run/metrics/server.sock", ":8080", false, ""},
+		{"Unix socket without path", "unix://", "", true, "missing socket path"},
+		{"Unix socket with bad gRPC", "unix:///run/metrics/server.sock", "localhost", true, "invalid gRPC address"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{ServerAddress: tt.httpAddr, GRPCAddress: tt.grpcAddr}
+			err := cfg.Validate()
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil && !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Validate() error = %q, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestValidateTLS(t *testing.T) {
+	tests := []struct {
+		name    string
+		cert    string
+		key     string
+		wantErr bool
+	}{
+		{"no TLS", "", "", false},
+		{"certificate and key", "server.crt", "server.key", false},
+		{"certificate only", "server.crt", "", true},
+		{"key only", "", "server.key", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{ServerAddress: "localhost:8080", TLSCert: tt.cert, TLSKey: tt.key}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+		t.Run("gRPC "+tt.name, func(t *testing.T) {
+			cfg := &Config{ServerAddress: "localhost:8080", GRPCAddress: "localhost:3200", GRPCCert: tt.cert, GRPCKey: tt.key}
+			if err := cfg.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateGzipLevel(t *testing.T) {
+	for _, level := range []int{-3, 10} {
+		cfg := &Config{ServerAddress: "localhost:8080", GzipLevel: level}
+		if err := cfg.Validate(); err == nil || !strings.Contains(err.Error(), "-gzip-level") {
+			t.Errorf("Validate() error = %v for level %d, want a -gzip-level error", err, level)
+		}
+	}
+	for _, level := range []int{-2, -1, 0, 9} {
+		cfg := &Config{ServerAddress: "localhost:8080", GzipLevel: level}
+		if err := cfg.Validate(); err != nil {
+			t.Errorf("Validate() error = %v for level %d", err, level)
+		}
+	}
+}
+
+func TestValidateReplica(t *testing.T) {
+	tests := []struct {
+		name        string
+		replicaOf   string
+		ca          string
+		nats        string
+		errContains string // empty for a valid configuration
+	}{
+		{"primary", "", "", "nats://localhost:4222", ""},
+		{"replica", "primary:3200", "", "", ""},
+		{"replica over TLS", "primary:3200", "ca.pem", "", ""},
+		{"CA without replica", "", "ca.pem", "", "-grpc-ca requires -replica-of"},
+		{"replica receiving NATS", "primary:3200", "", "nats://localhost:4222", "-nats cannot be used"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{ServerAddress: "localhost:8080", ReplicaOf: tt.replicaOf, GRPCCA: tt.ca, NATSAddress: tt.nats}
+			err := cfg.Validate()
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestValidateMetricNameRegex(t *testing.T) {
+	tests := []struct {
+		name        string
+		allow       string
+		deny        string
+		errContains string // empty for a valid configuration
+	}{
+		{"no policy", "", "", ""},
+		{"valid expressions", `^[a-z_]+$`, `^debug_`, ""},
+		{"invalid allow", `[a-z`, "", "invalid -metric-allow-regex"},
+		{"invalid deny", "", `(tmp`, "invalid -metric-deny-regex"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &Config{ServerAddress: "localhost:8080", MetricAllow: tt.allow, MetricDeny: tt.deny}
+			err := cfg.Validate()
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Validate() error = %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Validate() error = %v, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
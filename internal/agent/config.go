@@ -1,6 +1,9 @@
 package agent
 
 import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
 	"flag"
 	"log"
@@ -9,15 +12,21 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mutualEvg/metrics-server/internal/deadletter"
+	"github.com/mutualEvg/metrics-server/internal/hash"
+	"github.com/mutualEvg/metrics-server/internal/natsclient"
 	"github.com/mutualEvg/metrics-server/internal/retry"
+	"github.com/mutualEvg/metrics-server/internal/utils"
 )
 
 const (
 	DefaultServerAddress  = "http://localhost:8080"
 	DefaultPollInterval   = 2
 	DefaultReportInterval = 10
-	DefaultBatchSize      = 10 // Default batch size for metrics
-	DefaultRateLimit      = 10 // Default rate limit for concurrent requests
+	DefaultBatchSize      = 10   // Default batch size for metrics
+	DefaultMaxBatchSize   = 1000 // Default most metrics sent in one batch request
+	DefaultRateLimit      = 10   // Default rate limit for concurrent requests
+	DefaultLogTailPattern = "ERROR"
 )
 
 // Config holds all agent configuration
@@ -25,12 +34,38 @@ type Config struct {
 	ServerAddress  string
 	PollInterval   time.Duration
 	ReportInterval time.Duration
+	SystemPoll     time.Duration // How often the HTTP agent polls system metrics (PollInterval unless set)
 	BatchSize      int
+	MaxBatchSize   int // Most metrics sent in one batch request, larger batches are split (0 or less for no limit)
 	RateLimit      int
 	Key            string
+	HashAlgo       string // Signing algorithm, "sha256" or "sha512"
 	CryptoKey      string // Path to public key file for encryption
 	RetryConfig    retry.RetryConfig
 	GRPCAddress    string // gRPC server address (optional)
+	GRPCCA         string // Path to CA certificate for gRPC TLS (optional)
+	NATSAddress    string // NATS server URL, e.g. nats://localhost:4222 (optional)
+	NATSSubject    string // NATS subject metric batches are published to
+	CommonTS       bool   // Stamp all metrics of a report cycle with one timestamp
+	DeadBand       bool   // Suppress zero counter deltas and unchanged gauges
+	ChanMetrics    bool   // Report collector channel depth and drop counts
+	ConfigHash     bool   // Report the config fingerprint as the AgentConfigHash gauge
+	StateFile      string // Path to the file persisting the agent's own counters (optional)
+	InstanceID     string // Prefix for all metric IDs, e.g. "web-01" reports "web-01.Alloc" (optional)
+	CollectDisk    bool   // Report per-device disk I/O byte counts
+	CollectNet     bool   // Report per-interface network byte counts
+	CPUPrime       bool   // Prime CPU sampling at startup and sample over each poll interval
+	RetryMetrics   bool   // Report send attempt, retry and failure counts
+	LastErrorAge   bool   // Report the age of the last send failure as AgentLastErrorAge
+	StatusAddress  string // Address serving the agent's send status at GET /status (optional)
+	TraceMetric    string // ID of a metric whose collection and send stages are logged (optional)
+	LogTailFile    string // Path to a log file whose matching lines are counted as LogTailMatches (optional)
+	LogTailPattern string // Regular expression selecting the counted log lines
+	ValidateOnly   bool   `json:"-"` // Check the config and server connectivity, then exit; not part of the fingerprint
+	Fingerprint    string // SHA256 of the resolved config without secrets and per-instance settings, hex-encoded
+
+	// DeadLetter is the log of metrics that could not be sent (disabled if Path is empty)
+	DeadLetter deadletter.Config
 }
 
 // JSONConfig represents the JSON configuration file structure for agent
@@ -38,8 +73,39 @@ type JSONConfig struct {
 	Address        string `json:"address"`
 	ReportInterval string `json:"report_interval"`
 	PollInterval   string `json:"poll_interval"`
+	SystemPoll     string `json:"system_poll_interval"`
 	CryptoKey      string `json:"crypto_key"`
+	HashAlgo       string `json:"hash_algo"`
 	GRPCAddress    string `json:"grpc_address"`
+	GRPCCA         string `json:"grpc_ca"`
+	NATSAddress    string `json:"nats_address"`
+	NATSSubject    string `json:"nats_subject"`
+	BatchSize      *int   `json:"batch_size"` // pointer so that 0 can disable batching
+	MaxBatchSize   int    `json:"max_batch_size"`
+	RateLimit      int    `json:"rate_limit"`
+	Key            string `json:"key"`
+	CommonTS       *bool  `json:"common_timestamp"` // pointer to distinguish between false and not set
+	DeadBand       *bool  `json:"dead_band"`
+	ChanMetrics    *bool  `json:"channel_metrics"`
+	ConfigHash     *bool  `json:"config_hash"`
+	StateFile      string `json:"state_file"`
+	InstanceID     string `json:"instance_id"`
+	CollectDisk    *bool  `json:"collect_disk"`
+	CollectNet     *bool  `json:"collect_net"`
+	CPUPrime       *bool  `json:"cpu_prime"`
+	RetryMetrics   *bool  `json:"retry_metrics"`
+	LastErrorAge   *bool  `json:"last_error_metric"`
+	StatusAddress  string `json:"status_address"`
+	TraceMetric    string `json:"trace_metric"`
+	LogTailFile    string `json:"logtail_file"`
+	LogTailPattern string `json:"logtail_pattern"`
+
+	// Dead-letter log settings
+	DeadLetterFile     string `json:"dead_letter_file"`
+	DeadLetterMaxSize  int    `json:"dead_letter_max_size"`
+	DeadLetterMaxAge   string `json:"dead_letter_max_age"`
+	DeadLetterKeep     int    `json:"dead_letter_keep"`
+	DeadLetterCompress *bool  `json:"dead_letter_compress"`
 }
 
 // agentFlags holds all command-line flag values for the agent
@@ -47,14 +113,43 @@ type agentFlags struct {
 	address        *string
 	reportInterval *int
 	pollInterval   *int
+	systemPoll     *int
 	batchSize      *int
+	maxBatchSize   *int
 	disableRetry   *bool
 	key            *string
+	hashAlgo       *string
 	cryptoKey      *string
 	rateLimit      *int
 	grpcAddress    *string
+	grpcCA         *string
+	natsAddress    *string
+	natsSubject    *string
+	commonTS       *bool
+	deadBand       *bool
+	chanMetrics    *bool
+	configHash     *bool
+	stateFile      *string
+	instanceID     *string
+	collectDisk    *bool
+	collectNet     *bool
+	cpuPrime       *bool
+	retryMetrics   *bool
+	lastErrorAge   *bool
+	statusAddress  *string
+	traceMetric    *string
+	logTailFile    *string
+	logTailPattern *string
+	validateOnly   *bool
 	configPath     *string
 	configPathLong *string
+
+	// Dead-letter log settings
+	deadLetterFile     *string
+	deadLetterMaxSize  *int
+	deadLetterMaxAge   *int
+	deadLetterKeep     *int
+	deadLetterCompress *bool
 }
 
 // ParseConfig parses command line flags and environment variables
@@ -63,38 +158,105 @@ func ParseConfig() *Config {
 	validateAgentFlags()
 	jsonConfig := loadAgentJSONConfig(resolveAgentConfigPath(flags))
 
+	config := resolveAgentConfig(flags, jsonConfig)
+	if err := config.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+	logAgentConfig(config)
+	return config
+}
+
+// resolveAgentConfig resolves every option from the environment, the flags and the
+// JSON config, in that order of precedence, falling back to the defaults
+func resolveAgentConfig(flags *agentFlags, jsonConfig *JSONConfig) *Config {
 	config := &Config{
 		ServerAddress:  resolveAgentServerAddress(flags, jsonConfig),
 		PollInterval:   resolveAgentPollInterval(flags, jsonConfig),
 		ReportInterval: resolveAgentReportInterval(flags, jsonConfig),
-		BatchSize:      resolveAgentBatchSize(flags),
-		RateLimit:      resolveAgentRateLimit(flags),
-		Key:            resolveAgentKey(flags),
+		BatchSize:      resolveAgentBatchSize(flags, jsonConfig),
+		MaxBatchSize:   resolveAgentMaxBatchSize(flags, jsonConfig),
+		RateLimit:      resolveAgentRateLimit(flags, jsonConfig),
+		Key:            resolveAgentKey(flags, jsonConfig),
+		HashAlgo:       resolveAgentHashAlgo(flags, jsonConfig),
 		CryptoKey:      resolveAgentCryptoKey(flags, jsonConfig),
 		RetryConfig:    resolveAgentRetryConfig(flags),
 		GRPCAddress:    resolveAgentGRPCAddress(flags, jsonConfig),
-	}
-
-	logAgentConfig(config)
+		GRPCCA:         resolveAgentGRPCCA(flags, jsonConfig),
+		NATSAddress:    resolveAgentNATSAddress(flags, jsonConfig),
+		NATSSubject:    resolveAgentNATSSubject(flags, jsonConfig),
+		CommonTS:       resolveAgentCommonTS(flags, jsonConfig),
+		DeadBand:       resolveAgentDeadBand(flags, jsonConfig),
+		ChanMetrics:    resolveAgentChanMetrics(flags, jsonConfig),
+		ConfigHash:     resolveAgentConfigHash(flags, jsonConfig),
+		StateFile:      resolveAgentStateFile(flags, jsonConfig),
+		InstanceID:     resolveAgentInstanceID(flags, jsonConfig),
+		CollectDisk:    resolveAgentCollectDisk(flags, jsonConfig),
+		CollectNet:     resolveAgentCollectNet(flags, jsonConfig),
+		CPUPrime:       resolveAgentCPUPrime(flags, jsonConfig),
+		RetryMetrics:   resolveAgentRetryMetrics(flags, jsonConfig),
+		LastErrorAge:   resolveAgentLastErrorAge(flags, jsonConfig),
+		StatusAddress:  resolveAgentStatusAddress(flags, jsonConfig),
+		TraceMetric:    resolveAgentTraceMetric(flags, jsonConfig),
+		LogTailFile:    resolveAgentLogTailFile(flags, jsonConfig),
+		LogTailPattern: resolveAgentLogTailPattern(flags, jsonConfig),
+		ValidateOnly:   *flags.validateOnly,
+		DeadLetter:     resolveAgentDeadLetter(flags, jsonConfig),
+	}
+	config.SystemPoll = resolveAgentSystemPollInterval(flags, jsonConfig, config.PollInterval)
+	config.Fingerprint = config.computeFingerprint()
 	return config
 }
 
 // parseAgentFlags parses all command-line flags
 func parseAgentFlags() *agentFlags {
+	flags := defineAgentFlags(flag.CommandLine)
+	flag.Parse()
+	return flags
+}
+
+// defineAgentFlags defines the agent's flags on fs
+func defineAgentFlags(fs *flag.FlagSet) *agentFlags {
 	flags := &agentFlags{
-		address:        flag.String("a", "", "HTTP server address (default: http://localhost:8080)"),
-		reportInterval: flag.Int("r", 0, "Report interval in seconds (default: 10)"),
-		pollInterval:   flag.Int("p", 0, "Poll interval in seconds (default: 2)"),
-		batchSize:      flag.Int("b", 0, "Batch size for metrics (default: 10, 0 = disable batching)"),
-		disableRetry:   flag.Bool("disable-retry", false, "Disable retry logic for testing"),
-		key:            flag.String("k", "", "Key for SHA256 signature"),
-		cryptoKey:      flag.String("crypto-key", "", "Path to public key file for encryption"),
-		rateLimit:      flag.Int("l", 0, "Rate limit for concurrent requests (default: 10)"),
-		grpcAddress:    flag.String("g", "", "gRPC server address"),
-		configPath:     flag.String("c", "", "Path to JSON configuration file"),
-		configPathLong: flag.String("config", "", "Path to JSON configuration file"),
+		address:        fs.String("a", "", "HTTP server address or unix:///path/to/socket (default: http://localhost:8080)"),
+		reportInterval: fs.Int("r", 0, "Report interval in seconds (default: 10)"),
+		pollInterval:   fs.Int("p", 0, "Poll interval in seconds (default: 2)"),
+		systemPoll:     fs.Int("system-poll-interval", 0, "Poll interval of the system metrics in seconds, e.g. 15 for expensive gopsutil calls (default: the poll interval)"),
+		batchSize:      fs.Int("b", 0, "Batch size for metrics (default: 10, 0 = disable batching)"),
+		maxBatchSize:   fs.Int("max-batch-size", 0, "Split batches into requests of at most N metrics (default: 1000, -1 for no limit)"),
+		disableRetry:   fs.Bool("disable-retry", false, "Disable retry logic for testing"),
+		key:            fs.String("k", "", "Key for SHA256 signature"),
+		hashAlgo:       fs.String("hash-algo", "", "Signing algorithm: sha256 or sha512 (default: sha256)"),
+		cryptoKey:      fs.String("crypto-key", "", "Path to public key file for encryption"),
+		rateLimit:      fs.Int("l", 0, "Rate limit for concurrent requests (default: 10)"),
+		grpcAddress:    fs.String("g", "", "gRPC server address"),
+		grpcCA:         fs.String("grpc-ca", "", "Path to CA certificate for gRPC TLS"),
+		natsAddress:    fs.String("nats", "", "NATS server URL to publish metrics to instead of HTTP, e.g. nats://localhost:4222"),
+		natsSubject:    fs.String("nats-subject", "", "NATS subject to publish metrics to (default: "+natsclient.DefaultSubject+")"),
+		commonTS:       fs.Bool("common-timestamp", false, "Stamp all metrics of a report cycle with one collection timestamp"),
+		deadBand:       fs.Bool("dead-band", false, "Suppress zero counter deltas and unchanged gauge values"),
+		chanMetrics:    fs.Bool("channel-metrics", false, "Report collector channel depth and drop counts as metrics"),
+		configHash:     fs.Bool("config-hash", false, "Report a fingerprint of the effective config as the AgentConfigHash metric"),
+		stateFile:      fs.String("state-file", "", "Path to a file persisting the agent's own counters across restarts"),
+		instanceID:     fs.String("instance", "", "Instance ID prefixed to all metric IDs, e.g. web-01"),
+		collectDisk:    fs.Bool("collect-disk", true, "Report disk I/O metrics per device (use -collect-disk=false to disable)"),
+		collectNet:     fs.Bool("collect-net", true, "Report network metrics per interface (use -collect-net=false to disable)"),
+		cpuPrime:       fs.Bool("cpu-prime", false, "Prime CPU sampling at startup and measure CPU utilization over each poll interval instead of one second"),
+		retryMetrics:   fs.Bool("retry-metrics", false, "Report send attempts, retries and failures as SendAttempts, SendRetries and SendFailures"),
+		lastErrorAge:   fs.Bool("last-error-metric", false, "Report the seconds since the last send failure as AgentLastErrorAge (-1 if none)"),
+		statusAddress:  fs.String("status-address", "", "Address serving the agent's send status at GET /status, e.g. localhost:9090"),
+		validateOnly:   fs.Bool("validate", false, "Check the config, the crypto key and server connectivity, print a summary and exit"),
+		traceMetric:    fs.String("trace-metric", "", "Log every collection and send stage of the metric with this ID, without the instance prefix"),
+		logTailFile:    fs.String("logtail-file", "", "Path to a log file whose lines matching -logtail-pattern are counted as LogTailMatches"),
+		logTailPattern: fs.String("logtail-pattern", "", "Regular expression selecting the counted log lines (default: ERROR)"),
+		configPath:     fs.String("c", "", "Path to JSON configuration file"),
+		configPathLong: fs.String("config", "", "Path to JSON configuration file"),
+
+		deadLetterFile:     fs.String("dead-letter", "", "Path to a log of metrics that could not be sent after all retries"),
+		deadLetterMaxSize:  fs.Int("dead-letter-max-size", 0, "Rotate the dead-letter log at this size in bytes (default 10MB)"),
+		deadLetterMaxAge:   fs.Int("dead-letter-max-age", 0, "Rotate the dead-letter log after this many seconds (default: no age limit)"),
+		deadLetterKeep:     fs.Int("dead-letter-keep", 0, "Number of rotated dead-letter segments to keep (default 5)"),
+		deadLetterCompress: fs.Bool("dead-letter-compress", false, "Gzip rotated dead-letter segments"),
 	}
-	flag.Parse()
 	return flags
 }
 
@@ -160,7 +322,10 @@ func resolveAgentServerAddress(flags *agentFlags, jsonConfig *JSONConfig) string
 		}
 	}
 
-	// Ensure address has http:// or https:// prefix
+	// Ensure address has http:// or https:// prefix, unless it names a Unix socket
+	if _, ok := utils.UnixSocketPath(address); ok {
+		return address
+	}
 	if !strings.HasPrefix(address, "http://") && !strings.HasPrefix(address, "https://") {
 		address = "http://" + address
 	}
@@ -169,7 +334,7 @@ func resolveAgentServerAddress(flags *agentFlags, jsonConfig *JSONConfig) string
 }
 
 // resolveAgentKey resolves the signature key
-func resolveAgentKey(flags *agentFlags) string {
+func resolveAgentKey(flags *agentFlags, jsonConfig *JSONConfig) string {
 	if key := os.Getenv("KEY"); key != "" {
 		log.Printf("SHA256 signature enabled")
 		return key
@@ -178,9 +343,30 @@ func resolveAgentKey(flags *agentFlags) string {
 		log.Printf("SHA256 signature enabled")
 		return *flags.key
 	}
+	if jsonConfig != nil && jsonConfig.Key != "" {
+		log.Printf("SHA256 signature enabled")
+		return jsonConfig.Key
+	}
 	return ""
 }
 
+// resolveAgentHashAlgo resolves the signing algorithm
+func resolveAgentHashAlgo(flags *agentFlags, jsonConfig *JSONConfig) string {
+	algo := hash.AlgoSHA256
+	if env := os.Getenv("HASH_ALGO"); env != "" {
+		algo = env
+	} else if *flags.hashAlgo != "" {
+		algo = *flags.hashAlgo
+	} else if jsonConfig != nil && jsonConfig.HashAlgo != "" {
+		algo = jsonConfig.HashAlgo
+	}
+
+	if !hash.Supported(algo) {
+		log.Fatalf("Invalid hash algorithm %q: must be %s or %s", algo, hash.AlgoSHA256, hash.AlgoSHA512)
+	}
+	return algo
+}
+
 // resolveAgentCryptoKey resolves the crypto key path
 func resolveAgentCryptoKey(flags *agentFlags, jsonConfig *JSONConfig) string {
 	if cryptoKey := os.Getenv("CRYPTO_KEY"); cryptoKey != "" {
@@ -199,7 +385,7 @@ func resolveAgentCryptoKey(flags *agentFlags, jsonConfig *JSONConfig) string {
 }
 
 // resolveAgentRateLimit resolves the rate limit
-func resolveAgentRateLimit(flags *agentFlags) int {
+func resolveAgentRateLimit(flags *agentFlags, jsonConfig *JSONConfig) int {
 	if rateLimitEnv := os.Getenv("RATE_LIMIT"); rateLimitEnv != "" {
 		val, err := strconv.Atoi(rateLimitEnv)
 		if err != nil {
@@ -210,43 +396,61 @@ func resolveAgentRateLimit(flags *agentFlags) int {
 	if *flags.rateLimit != 0 {
 		return *flags.rateLimit
 	}
+	if jsonConfig != nil && jsonConfig.RateLimit != 0 {
+		return jsonConfig.RateLimit
+	}
 	return DefaultRateLimit
 }
 
 // resolveAgentReportInterval resolves the report interval
 func resolveAgentReportInterval(flags *agentFlags, jsonConfig *JSONConfig) time.Duration {
-	if reportEnv := os.Getenv("REPORT_INTERVAL"); reportEnv != "" {
-		val, err := strconv.Atoi(reportEnv)
-		if err != nil {
-			log.Fatalf("Invalid REPORT_INTERVAL: %v", err)
-		}
-		return time.Duration(val) * time.Second
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.ReportInterval
 	}
-	if *flags.reportInterval != 0 {
-		return time.Duration(*flags.reportInterval) * time.Second
-	}
-	if jsonConfig != nil && jsonConfig.ReportInterval != "" {
-		return parseAgentIntervalFromJSON("report_interval", jsonConfig.ReportInterval)
-	}
-	return time.Duration(DefaultReportInterval) * time.Second
+	return resolveAgentInterval("REPORT_INTERVAL", *flags.reportInterval, "report_interval", jsonVal, DefaultReportInterval)
 }
 
 // resolveAgentPollInterval resolves the poll interval
 func resolveAgentPollInterval(flags *agentFlags, jsonConfig *JSONConfig) time.Duration {
-	if pollEnv := os.Getenv("POLL_INTERVAL"); pollEnv != "" {
-		val, err := strconv.Atoi(pollEnv)
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.PollInterval
+	}
+	return resolveAgentInterval("POLL_INTERVAL", *flags.pollInterval, "poll_interval", jsonVal, DefaultPollInterval)
+}
+
+// resolveAgentSystemPollInterval resolves the system metrics poll interval, which
+// defaults to the poll interval
+func resolveAgentSystemPollInterval(flags *agentFlags, jsonConfig *JSONConfig, pollInterval time.Duration) time.Duration {
+	var jsonVal string
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.SystemPoll
+	}
+	if interval := resolveAgentInterval("SYSTEM_POLL_INTERVAL", *flags.systemPoll, "system_poll_interval", jsonVal, 0); interval != 0 {
+		return interval
+	}
+	return pollInterval
+}
+
+// resolveAgentInterval resolves an interval with priority env > flag > JSON > default.
+// The environment variable and the flag are in seconds, the JSON value is a duration
+// string such as "10s". A zero flag and an empty JSON value are treated as unset.
+func resolveAgentInterval(envVar string, flagSeconds int, jsonName, jsonVal string, defSeconds int) time.Duration {
+	if env := os.Getenv(envVar); env != "" {
+		val, err := strconv.Atoi(env)
 		if err != nil {
-			log.Fatalf("Invalid POLL_INTERVAL: %v", err)
+			log.Fatalf("Invalid %s: %v", envVar, err)
 		}
 		return time.Duration(val) * time.Second
 	}
-	if *flags.pollInterval != 0 {
-		return time.Duration(*flags.pollInterval) * time.Second
+	if flagSeconds != 0 {
+		return time.Duration(flagSeconds) * time.Second
 	}
-	if jsonConfig != nil && jsonConfig.PollInterval != "" {
-		return parseAgentIntervalFromJSON("poll_interval", jsonConfig.PollInterval)
+	if jsonVal != "" {
+		return parseAgentIntervalFromJSON(jsonName, jsonVal)
 	}
-	return time.Duration(DefaultPollInterval) * time.Second
+	return time.Duration(defSeconds) * time.Second
 }
 
 // parseAgentIntervalFromJSON parses a time interval from JSON string
@@ -259,7 +463,7 @@ func parseAgentIntervalFromJSON(name, interval string) time.Duration {
 }
 
 // resolveAgentBatchSize resolves the batch size
-func resolveAgentBatchSize(flags *agentFlags) int {
+func resolveAgentBatchSize(flags *agentFlags, jsonConfig *JSONConfig) int {
 	if batchEnv := os.Getenv("BATCH_SIZE"); batchEnv != "" {
 		val, err := strconv.Atoi(batchEnv)
 		if err != nil {
@@ -270,9 +474,21 @@ func resolveAgentBatchSize(flags *agentFlags) int {
 	if *flags.batchSize != 0 {
 		return *flags.batchSize
 	}
+	if jsonConfig != nil && jsonConfig.BatchSize != nil {
+		return *jsonConfig.BatchSize
+	}
 	return DefaultBatchSize
 }
 
+// resolveAgentMaxBatchSize resolves the most metrics sent in one batch request
+func resolveAgentMaxBatchSize(flags *agentFlags, jsonConfig *JSONConfig) int {
+	jsonVal := 0
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.MaxBatchSize
+	}
+	return resolveAgentInt("MAX_BATCH_SIZE", *flags.maxBatchSize, jsonVal, DefaultMaxBatchSize)
+}
+
 // resolveAgentRetryConfig resolves the retry configuration
 func resolveAgentRetryConfig(flags *agentFlags) retry.RetryConfig {
 	// Check for disabled retry first
@@ -313,6 +529,342 @@ func resolveAgentGRPCAddress(flags *agentFlags, jsonConfig *JSONConfig) string {
 	return ""
 }
 
+// resolveAgentGRPCCA resolves the CA certificate path for gRPC TLS
+func resolveAgentGRPCCA(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if ca := os.Getenv("GRPC_CA"); ca != "" {
+		return ca
+	}
+	if *flags.grpcCA != "" {
+		return *flags.grpcCA
+	}
+	if jsonConfig != nil && jsonConfig.GRPCCA != "" {
+		return jsonConfig.GRPCCA
+	}
+	return ""
+}
+
+// resolveAgentNATSAddress resolves the NATS server URL
+func resolveAgentNATSAddress(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if addr := os.Getenv("NATS_ADDRESS"); addr != "" {
+		return addr
+	}
+	if *flags.natsAddress != "" {
+		return *flags.natsAddress
+	}
+	if jsonConfig != nil {
+		return jsonConfig.NATSAddress
+	}
+	return ""
+}
+
+// resolveAgentNATSSubject resolves the NATS subject metric batches are published to
+func resolveAgentNATSSubject(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if subject := os.Getenv("NATS_SUBJECT"); subject != "" {
+		return subject
+	}
+	if *flags.natsSubject != "" {
+		return *flags.natsSubject
+	}
+	if jsonConfig != nil && jsonConfig.NATSSubject != "" {
+		return jsonConfig.NATSSubject
+	}
+	return natsclient.DefaultSubject
+}
+
+// resolveAgentCommonTS resolves whether metrics share a per-cycle timestamp
+func resolveAgentCommonTS(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.CommonTS
+	}
+	return resolveAgentBool("COMMON_TIMESTAMP", *flags.commonTS, jsonVal)
+}
+
+// resolveAgentDeadBand resolves whether the dead-band filter is enabled
+func resolveAgentDeadBand(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.DeadBand
+	}
+	return resolveAgentBool("DEAD_BAND", *flags.deadBand, jsonVal)
+}
+
+// resolveAgentChanMetrics resolves whether collector channel metrics are reported
+func resolveAgentChanMetrics(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.ChanMetrics
+	}
+	return resolveAgentBool("CHANNEL_METRICS", *flags.chanMetrics, jsonVal)
+}
+
+// resolveAgentConfigHash resolves whether the config fingerprint is reported
+func resolveAgentConfigHash(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.ConfigHash
+	}
+	return resolveAgentBool("CONFIG_HASH", *flags.configHash, jsonVal)
+}
+
+// resolveAgentStateFile resolves the path of the agent state file
+func resolveAgentStateFile(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if path := os.Getenv("STATE_FILE"); path != "" {
+		return path
+	}
+	if *flags.stateFile != "" {
+		return *flags.stateFile
+	}
+	if jsonConfig != nil && jsonConfig.StateFile != "" {
+		return jsonConfig.StateFile
+	}
+	return ""
+}
+
+// resolveAgentInstanceID resolves the instance ID used to prefix metric IDs
+func resolveAgentInstanceID(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if id := os.Getenv("INSTANCE_ID"); id != "" {
+		return id
+	}
+	if *flags.instanceID != "" {
+		return *flags.instanceID
+	}
+	if jsonConfig != nil && jsonConfig.InstanceID != "" {
+		return jsonConfig.InstanceID
+	}
+	return ""
+}
+
+// MetricPrefix returns the prefix prepended to every metric ID: the instance ID
+// followed by a dot, or an empty string if no instance ID is configured
+func (c *Config) MetricPrefix() string {
+	if c.InstanceID == "" {
+		return ""
+	}
+	return c.InstanceID + "."
+}
+
+// computeFingerprint returns the hex-encoded SHA256 of the config with secrets and
+// per-instance settings zeroed, so agents with the same effective settings report the
+// same fingerprint
+func (c *Config) computeFingerprint() string {
+	sanitized := *c
+	sanitized.Key = ""
+	sanitized.Fingerprint = ""
+	sanitized.InstanceID = ""
+	sanitized.StateFile = ""
+
+	// Config contains only plain values, so marshalling cannot fail
+	data, _ := json.Marshal(sanitized)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// FingerprintValue returns the fingerprint encoded as a gauge value: its first
+// 48 bits, which a float64 represents exactly
+func (c *Config) FingerprintValue() float64 {
+	sum, err := hex.DecodeString(c.Fingerprint)
+	if err != nil || len(sum) < 6 {
+		return 0
+	}
+	var buf [8]byte
+	copy(buf[2:], sum[:6])
+	return float64(binary.BigEndian.Uint64(buf[:]))
+}
+
+// resolveAgentBool resolves a boolean option with priority: env > flag > json > false
+func resolveAgentBool(envVar string, flagVal bool, jsonVal *bool) bool {
+	if env := os.Getenv(envVar); env != "" {
+		val, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envVar, err)
+		}
+		return val
+	}
+	if flagVal {
+		return true
+	}
+	if jsonVal != nil {
+		return *jsonVal
+	}
+	return false
+}
+
+// resolveAgentCollectDisk resolves whether disk I/O metrics are reported (enabled by default)
+func resolveAgentCollectDisk(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.CollectDisk
+	}
+	return resolveAgentBoolDefault("COLLECT_DISK", "collect-disk", *flags.collectDisk, jsonVal, true)
+}
+
+// resolveAgentCollectNet resolves whether network metrics are reported (enabled by default)
+func resolveAgentCollectNet(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.CollectNet
+	}
+	return resolveAgentBoolDefault("COLLECT_NET", "collect-net", *flags.collectNet, jsonVal, true)
+}
+
+// resolveAgentCPUPrime resolves whether CPU sampling is primed at startup
+func resolveAgentCPUPrime(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.CPUPrime
+	}
+	return resolveAgentBool("CPU_PRIME", *flags.cpuPrime, jsonVal)
+}
+
+// resolveAgentRetryMetrics resolves whether retry statistics are reported
+func resolveAgentRetryMetrics(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.RetryMetrics
+	}
+	return resolveAgentBool("RETRY_METRICS", *flags.retryMetrics, jsonVal)
+}
+
+// resolveAgentLastErrorAge resolves whether the age of the last send failure is reported
+func resolveAgentLastErrorAge(flags *agentFlags, jsonConfig *JSONConfig) bool {
+	var jsonVal *bool
+	if jsonConfig != nil {
+		jsonVal = jsonConfig.LastErrorAge
+	}
+	return resolveAgentBool("LAST_ERROR_METRIC", *flags.lastErrorAge, jsonVal)
+}
+
+// resolveAgentStatusAddress resolves the address of the agent status endpoint
+func resolveAgentStatusAddress(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if addr := os.Getenv("STATUS_ADDRESS"); addr != "" {
+		return addr
+	}
+	if *flags.statusAddress != "" {
+		return *flags.statusAddress
+	}
+	if jsonConfig != nil {
+		return jsonConfig.StatusAddress
+	}
+	return ""
+}
+
+// resolveAgentTraceMetric resolves the ID of the metric to trace
+func resolveAgentTraceMetric(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if id := os.Getenv("TRACE_METRIC"); id != "" {
+		return id
+	}
+	if *flags.traceMetric != "" {
+		return *flags.traceMetric
+	}
+	if jsonConfig != nil {
+		return jsonConfig.TraceMetric
+	}
+	return ""
+}
+
+// resolveAgentLogTailFile resolves the path of the log file to tail
+func resolveAgentLogTailFile(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if path := os.Getenv("LOGTAIL_FILE"); path != "" {
+		return path
+	}
+	if *flags.logTailFile != "" {
+		return *flags.logTailFile
+	}
+	if jsonConfig != nil {
+		return jsonConfig.LogTailFile
+	}
+	return ""
+}
+
+// resolveAgentLogTailPattern resolves the pattern of the counted log lines
+func resolveAgentLogTailPattern(flags *agentFlags, jsonConfig *JSONConfig) string {
+	if pattern := os.Getenv("LOGTAIL_PATTERN"); pattern != "" {
+		return pattern
+	}
+	if *flags.logTailPattern != "" {
+		return *flags.logTailPattern
+	}
+	if jsonConfig != nil && jsonConfig.LogTailPattern != "" {
+		return jsonConfig.LogTailPattern
+	}
+	return DefaultLogTailPattern
+}
+
+// resolveAgentDeadLetter resolves the location and rotation settings of the dead-letter log
+func resolveAgentDeadLetter(flags *agentFlags, jsonConfig *JSONConfig) deadletter.Config {
+	if jsonConfig == nil {
+		jsonConfig = &JSONConfig{}
+	}
+
+	config := deadletter.Config{
+		Path:       *flags.deadLetterFile,
+		MaxSize:    int64(resolveAgentInt("DEAD_LETTER_MAX_SIZE", *flags.deadLetterMaxSize, jsonConfig.DeadLetterMaxSize, deadletter.DefaultMaxSize)),
+		MaxBackups: resolveAgentInt("DEAD_LETTER_KEEP", *flags.deadLetterKeep, jsonConfig.DeadLetterKeep, deadletter.DefaultMaxBackups),
+		Compress:   resolveAgentBool("DEAD_LETTER_COMPRESS", *flags.deadLetterCompress, jsonConfig.DeadLetterCompress),
+	}
+	if path := os.Getenv("DEAD_LETTER_FILE"); path != "" {
+		config.Path = path
+	} else if config.Path == "" {
+		config.Path = jsonConfig.DeadLetterFile
+	}
+
+	if maxAge := resolveAgentInt("DEAD_LETTER_MAX_AGE", *flags.deadLetterMaxAge, 0, 0); maxAge > 0 {
+		config.MaxAge = time.Duration(maxAge) * time.Second
+	} else if jsonConfig.DeadLetterMaxAge != "" {
+		config.MaxAge = parseAgentIntervalFromJSON("dead_letter_max_age", jsonConfig.DeadLetterMaxAge)
+	}
+	return config
+}
+
+// resolveAgentInt resolves an integer option from env, flag and JSON, where 0 means not set
+func resolveAgentInt(envVar string, flagVal, jsonVal, def int) int {
+	if env := os.Getenv(envVar); env != "" {
+		val, err := strconv.Atoi(env)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envVar, err)
+		}
+		return val
+	}
+	if flagVal != 0 {
+		return flagVal
+	}
+	if jsonVal != 0 {
+		return jsonVal
+	}
+	return def
+}
+
+// resolveAgentBoolDefault resolves a boolean option that may default to true. Unlike
+// resolveAgentBool, the flag only takes precedence over JSON when it was set explicitly.
+func resolveAgentBoolDefault(envVar, flagName string, flagVal bool, jsonVal *bool, def bool) bool {
+	if env := os.Getenv(envVar); env != "" {
+		val, err := strconv.ParseBool(env)
+		if err != nil {
+			log.Fatalf("Invalid %s: %v", envVar, err)
+		}
+		return val
+	}
+	if isFlagSet(flagName) {
+		return flagVal
+	}
+	if jsonVal != nil {
+		return *jsonVal
+	}
+	return def
+}
+
+// isFlagSet reports whether the named flag was given on the command line
+func isFlagSet(name string) bool {
+	set := false
+	flag.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
 // logAgentConfig logs the final configuration
 func logAgentConfig(config *Config) {
 	cryptoStatus := "disabled"
@@ -323,6 +875,10 @@ func logAgentConfig(config *Config) {
 	if config.GRPCAddress != "" {
 		grpcStatus = config.GRPCAddress
 	}
-	log.Printf("Agent starting with server=%s, poll=%v, report=%v, batch_size=%d, rate_limit=%d, crypto=%s, grpc=%s",
-		config.ServerAddress, config.PollInterval, config.ReportInterval, config.BatchSize, config.RateLimit, cryptoStatus, grpcStatus)
+	natsStatus := "disabled"
+	if config.NATSAddress != "" {
+		natsStatus = config.NATSAddress + " " + config.NATSSubject
+	}
+	log.Printf("Agent starting with server=%s, poll=%v, report=%v, batch_size=%d, rate_limit=%d, crypto=%s, grpc=%s, nats=%s",
+		config.ServerAddress, config.PollInterval, config.ReportInterval, config.BatchSize, config.RateLimit, cryptoStatus, grpcStatus, natsStatus)
 }
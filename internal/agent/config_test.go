@@ -0,0 +1,330 @@
+package agent
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+)
+
+func testConfig() Config {
+	return Config{
+		ServerAddress:  DefaultServerAddress,
+		PollInterval:   2 * time.Second,
+		ReportInterval: 10 * time.Second,
+		BatchSize:      DefaultBatchSize,
+		RateLimit:      DefaultRateLimit,
+		RetryConfig:    retry.FastConfig(),
+	}
+}
+
+func TestConfigFingerprint(t *testing.T) {
+	a := testConfig()
+	b := testConfig()
+
+	if a.computeFingerprint() != b.computeFingerprint() {
+		t.Error("Identical configs should have identical fingerprints")
+	}
+
+	b.ReportInterval = 20 * time.Second
+	if a.computeFingerprint() == b.computeFingerprint() {
+		t.Error("Configs with different report intervals should have different fingerprints")
+	}
+
+	c := testConfig()
+	c.DeadBand = true
+	if a.computeFingerprint() == c.computeFingerprint() {
+		t.Error("Configs with different options should have different fingerprints")
+	}
+}
+
+func TestConfigFingerprintIgnoresSecrets(t *testing.T) {
+	a := testConfig()
+	b := testConfig()
+	b.Key = "secret"
+
+	if a.computeFingerprint() != b.computeFingerprint() {
+		t.Error("The signing key should not affect the fingerprint")
+	}
+
+	// A previously computed fingerprint must not feed into the next one
+	b.Fingerprint = b.computeFingerprint()
+	if a.computeFingerprint() != b.computeFingerprint() {
+		t.Error("The stored fingerprint should not affect the fingerprint")
+	}
+}
+
+func TestConfigFingerprintIgnoresInstance(t *testing.T) {
+	a := testConfig()
+	a.InstanceID = "web-01"
+	a.StateFile = "/var/lib/agent/web-01.json"
+	b := testConfig()
+	b.InstanceID = "web-02"
+	b.StateFile = "/var/lib/agent/web-02.json"
+
+	if a.computeFingerprint() != b.computeFingerprint() {
+		t.Error("Instances with the same settings should have identical fingerprints")
+	}
+}
+
+func TestConfigFingerprintValue(t *testing.T) {
+	a := testConfig()
+	a.Fingerprint = a.computeFingerprint()
+	b := testConfig()
+	b.BatchSize = 50
+	b.Fingerprint = b.computeFingerprint()
+
+	va, vb := a.FingerprintValue(), b.FingerprintValue()
+	if va == 0 || va == vb {
+		t.Errorf("Expected distinct non-zero gauge values, got %v and %v", va, vb)
+	}
+	if va >= 1<<48 || va != float64(uint64(va)) {
+		t.Errorf("Expected an exact 48-bit integer, got %v", va)
+	}
+}
+
+func TestConfigMetricPrefix(t *testing.T) {
+	c := testConfig()
+	if c.MetricPrefix() != "" {
+		t.Errorf("Expected empty prefix by default, got %q", c.MetricPrefix())
+	}
+
+	c.InstanceID = "web-01"
+	if c.MetricPrefix() != "web-01." {
+		t.Errorf("Expected prefix web-01., got %q", c.MetricPrefix())
+	}
+}
+
+func TestResolveAgentBoolDefault(t *testing.T) {
+	jsonFalse := false
+
+	// Flags are not set in tests, so JSON and the default apply
+	if !resolveAgentBoolDefault("TEST_COLLECT", "test-collect", true, nil, true) {
+		t.Error("Expected default true without env or JSON")
+	}
+	if resolveAgentBoolDefault("TEST_COLLECT", "test-collect", true, &jsonFalse, true) {
+		t.Error("Expected JSON false to override the flag default")
+	}
+
+	t.Setenv("TEST_COLLECT", "false")
+	if resolveAgentBoolDefault("TEST_COLLECT", "test-collect", true, nil, true) {
+		t.Error("Expected env false to disable the option")
+	}
+}
+
+// unsetAgentFlags returns flags holding their defaults, as if none was given
+func unsetAgentFlags() *agentFlags {
+	return defineAgentFlags(flag.NewFlagSet("agent", flag.ContinueOnError))
+}
+
+func TestResolveAgentConfigFromJSON(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent.json")
+	data := `{
+		"address": "metrics.example:8080",
+		"report_interval": "30s",
+		"poll_interval": "5s",
+		"batch_size": 0,
+		"rate_limit": 4,
+		"key": "secret",
+		"crypto_key": "/etc/agent/public.pem",
+		"hash_algo": "sha512",
+		"grpc_address": "metrics.example:8081",
+		"grpc_ca": "/etc/agent/ca.pem",
+		"nats_address": "nats://edge-broker:4222",
+		"nats_subject": "edge.metrics",
+		"common_timestamp": true,
+		"dead_band": true,
+		"channel_metrics": true,
+		"config_hash": true,
+		"state_file": "/var/lib/agent/state.json",
+		"instance_id": "web-01",
+		"collect_disk": false,
+		"collect_net": false,
+		"cpu_prime": true,
+		"retry_metrics": true,
+		"last_error_metric": true,
+		"status_address": "localhost:9090",
+		"trace_metric": "Alloc",
+		"logtail_file": "/var/log/app.log",
+		"logtail_pattern": "level=error",
+		"max_batch_size": 250,
+		"dead_letter_file": "/var/lib/agent/dead.log",
+		"dead_letter_max_size": 2048,
+		"dead_letter_max_age": "1h",
+		"dead_letter_keep": 3,
+		"dead_letter_compress": true
+	}`
+	if err := os.WriteFile(path, []byte(data), 0o600); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	jsonConfig, err := loadJSONConfig(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	config := resolveAgentConfig(unsetAgentFlags(), jsonConfig)
+
+	checks := []struct {
+		name      string
+		got, want any
+	}{
+		{"ServerAddress", config.ServerAddress, "http://metrics.example:8080"},
+		{"ReportInterval", config.ReportInterval, 30 * time.Second},
+		{"PollInterval", config.PollInterval, 5 * time.Second},
+		{"BatchSize", config.BatchSize, 0},
+		{"RateLimit", config.RateLimit, 4},
+		{"Key", config.Key, "secret"},
+		{"CryptoKey", config.CryptoKey, "/etc/agent/public.pem"},
+		{"HashAlgo", config.HashAlgo, "sha512"},
+		{"GRPCAddress", config.GRPCAddress, "metrics.example:8081"},
+		{"GRPCCA", config.GRPCCA, "/etc/agent/ca.pem"},
+		{"NATSAddress", config.NATSAddress, "nats://edge-broker:4222"},
+		{"NATSSubject", config.NATSSubject, "edge.metrics"},
+		{"CommonTS", config.CommonTS, true},
+		{"DeadBand", config.DeadBand, true},
+		{"ChanMetrics", config.ChanMetrics, true},
+		{"ConfigHash", config.ConfigHash, true},
+		{"StateFile", config.StateFile, "/var/lib/agent/state.json"},
+		{"InstanceID", config.InstanceID, "web-01"},
+		{"CollectDisk", config.CollectDisk, false},
+		{"CollectNet", config.CollectNet, false},
+		{"CPUPrime", config.CPUPrime, true},
+		{"RetryMetrics", config.RetryMetrics, true},
+		{"LastErrorAge", config.LastErrorAge, true},
+		{"StatusAddress", config.StatusAddress, "localhost:9090"},
+		{"TraceMetric", config.TraceMetric, "Alloc"},
+		{"LogTailFile", config.LogTailFile, "/var/log/app.log"},
+		{"LogTailPattern", config.LogTailPattern, "level=error"},
+		{"MaxBatchSize", config.MaxBatchSize, 250},
+		{"DeadLetter.Path", config.DeadLetter.Path, "/var/lib/agent/dead.log"},
+		{"DeadLetter.MaxSize", config.DeadLetter.MaxSize, int64(2048)},
+		{"DeadLetter.MaxAge", config.DeadLetter.MaxAge, time.Hour},
+		{"DeadLetter.MaxBackups", config.DeadLetter.MaxBackups, 3},
+		{"DeadLetter.Compress", config.DeadLetter.Compress, true},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s = %v, want %v", c.name, c.got, c.want)
+		}
+	}
+}
+
+func TestResolveAgentJSONPrecedence(t *testing.T) {
+	batchSize := 50
+	jsonConfig := &JSONConfig{BatchSize: &batchSize, RateLimit: 4, Key: "json-key"}
+
+	// Flags override JSON
+	flags := unsetAgentFlags()
+	*flags.batchSize = 20
+	*flags.rateLimit = 8
+	*flags.key = "flag-key"
+	if got := resolveAgentBatchSize(flags, jsonConfig); got != 20 {
+		t.Errorf("Expected flag batch size 20, got %d", got)
+	}
+	if got := resolveAgentRateLimit(flags, jsonConfig); got != 8 {
+		t.Errorf("Expected flag rate limit 8, got %d", got)
+	}
+	if got := resolveAgentKey(flags, jsonConfig); got != "flag-key" {
+		t.Errorf("Expected flag key, got %q", got)
+	}
+
+	// The environment overrides flags
+	t.Setenv("BATCH_SIZE", "30")
+	t.Setenv("RATE_LIMIT", "2")
+	t.Setenv("KEY", "env-key")
+	if got := resolveAgentBatchSize(flags, jsonConfig); got != 30 {
+		t.Errorf("Expected env batch size 30, got %d", got)
+	}
+	if got := resolveAgentRateLimit(flags, jsonConfig); got != 2 {
+		t.Errorf("Expected env rate limit 2, got %d", got)
+	}
+	if got := resolveAgentKey(flags, jsonConfig); got != "env-key" {
+		t.Errorf("Expected env key, got %q", got)
+	}
+
+	// Defaults apply without any source
+	empty := unsetAgentFlags()
+	t.Setenv("BATCH_SIZE", "")
+	t.Setenv("RATE_LIMIT", "")
+	t.Setenv("KEY", "")
+	if got := resolveAgentBatchSize(empty, nil); got != DefaultBatchSize {
+		t.Errorf("Expected default batch size, got %d", got)
+	}
+	if got := resolveAgentRateLimit(empty, nil); got != DefaultRateLimit {
+		t.Errorf("Expected default rate limit, got %d", got)
+	}
+}
+
+func TestResolveAgentIntervalPrecedence(t *testing.T) {
+	tests := []struct {
+		name     string
+		resolve  func(*agentFlags, *JSONConfig) time.Duration
+		envVar   string
+		flag     func(*agentFlags) *int
+		json     *JSONConfig
+		fallback time.Duration
+	}{
+		{"report", resolveAgentReportInterval, "REPORT_INTERVAL", func(f *agentFlags) *int { return f.reportInterval },
+			&JSONConfig{ReportInterval: "30s"}, DefaultReportInterval * time.Second},
+		{"poll", resolveAgentPollInterval, "POLL_INTERVAL", func(f *agentFlags) *int { return f.pollInterval },
+			&JSONConfig{PollInterval: "30s"}, DefaultPollInterval * time.Second},
+		// The system poll interval falls back to the poll interval
+		{"system poll", func(f *agentFlags, j *JSONConfig) time.Duration {
+			return resolveAgentSystemPollInterval(f, j, 3*time.Second)
+		}, "SYSTEM_POLL_INTERVAL", func(f *agentFlags) *int { return f.systemPoll },
+			&JSONConfig{SystemPoll: "30s"}, 3 * time.Second},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			t.Setenv(tt.envVar, "")
+			flags := unsetAgentFlags()
+
+			if got := tt.resolve(flags, nil); got != tt.fallback {
+				t.Errorf("Expected default %v, got %v", tt.fallback, got)
+			}
+			if got := tt.resolve(flags, tt.json); got != 30*time.Second {
+				t.Errorf("Expected JSON value 30s, got %v", got)
+			}
+
+			*tt.flag(flags) = 20
+			if got := tt.resolve(flags, tt.json); got != 20*time.Second {
+				t.Errorf("Expected flag to override JSON with 20s, got %v", got)
+			}
+
+			t.Setenv(tt.envVar, "40")
+			if got := tt.resolve(flags, tt.json); got != 40*time.Second {
+				t.Errorf("Expected env to override flag with 40s, got %v", got)
+			}
+		})
+	}
+}
+
+func TestResolveAgentServerAddress(t *testing.T) {
+	tests := []struct {
+		address string
+		want    string
+	}{
+		{"", DefaultServerAddress},
+		{"localhost:9090", "http://localhost:9090"},
+		{"http://localhost:9090", "http://localhost:9090"},
+		// HTTPS keeps its port, so the agent sends to the TLS listener
+		{"https://metrics.example.com:8443", "https://metrics.example.com:8443"},
+		{"https://metrics.example.com", "https://metrics.example.com"},
+		{"unix:///run/metrics/server.sock", "unix:///run/metrics/server.sock"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.address, func(t *testing.T) {
+			t.Setenv("ADDRESS", "")
+			flags := unsetAgentFlags()
+			*flags.address = tt.address
+			if got := resolveAgentServerAddress(flags, nil); got != tt.want {
+				t.Errorf("resolveAgentServerAddress(%q) = %q, want %q", tt.address, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,143 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Counter is an agent-internal counter whose value can be persisted across restarts.
+// *atomic.Int64 implements it; use Int64Counter for plain int64 values.
+type Counter interface {
+	Load() int64
+	Store(int64)
+}
+
+// int64Counter adapts an int64 that is updated with sync/atomic functions
+type int64Counter struct {
+	p *int64
+}
+
+func (c int64Counter) Load() int64   { return atomic.LoadInt64(c.p) }
+func (c int64Counter) Store(v int64) { atomic.StoreInt64(c.p, v) }
+
+// Int64Counter returns a Counter for an int64 that is updated with sync/atomic functions
+func Int64Counter(p *int64) Counter {
+	return int64Counter{p: p}
+}
+
+// State persists the agent's own counters, such as PollCount and drop counts,
+// in a small JSON file so they stay monotonic across restarts
+type State struct {
+	path     string
+	mu       sync.Mutex
+	counters map[string]Counter
+	// saved holds values from the state file with no registered counter,
+	// so they survive until a later version registers them again
+	saved map[string]int64
+}
+
+// NewState creates a state persisted at path
+func NewState(path string) *State {
+	return &State{
+		path:     path,
+		counters: make(map[string]Counter),
+		saved:    make(map[string]int64),
+	}
+}
+
+// Register adds a counter to the persisted state under the given name
+func (s *State) Register(name string, counter Counter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.counters[name] = counter
+}
+
+// Load restores the registered counters from the state file.
+// A missing file is not an error, which is fine for the first run.
+func (s *State) Load() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	data, err := os.ReadFile(s.path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to read agent state: %w", err)
+	}
+
+	var values map[string]int64
+	if err := json.Unmarshal(data, &values); err != nil {
+		return fmt.Errorf("failed to parse agent state: %w", err)
+	}
+
+	for name, value := range values {
+		if counter, ok := s.counters[name]; ok {
+			counter.Store(value)
+		} else {
+			s.saved[name] = value
+		}
+	}
+	return nil
+}
+
+// Save writes the current counter values to the state file atomically
+func (s *State) Save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	values := make(map[string]int64, len(s.saved)+len(s.counters))
+	for name, value := range s.saved {
+		values[name] = value
+	}
+	for name, counter := range s.counters {
+		values[name] = counter.Load()
+	}
+
+	data, err := json.MarshalIndent(values, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal agent state: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), filepath.Base(s.path)+".tmp*")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary state file: %w", err)
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write agent state: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write agent state: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), s.path); err != nil {
+		return fmt.Errorf("failed to replace agent state: %w", err)
+	}
+	return nil
+}
+
+// Run saves the state every interval until ctx is cancelled
+func (s *State) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := s.Save(); err != nil {
+				log.Printf("Failed to save agent state: %v", err)
+			}
+		}
+	}
+}
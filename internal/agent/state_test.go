@@ -0,0 +1,90 @@
+package agent
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestStatePersistsCountersAcrossRestart(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-state.json")
+
+	// First run
+	var pollCount int64 = 42
+	var drops atomic.Int64
+	drops.Store(7)
+
+	state := NewState(path)
+	state.Register("PollCount", Int64Counter(&pollCount))
+	state.Register("RuntimeChanDrops", &drops)
+	if err := state.Load(); err != nil {
+		t.Fatalf("Load without a state file should succeed: %v", err)
+	}
+	if pollCount != 42 {
+		t.Fatalf("Load without a state file should not change counters, got %d", pollCount)
+	}
+	if err := state.Save(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	// Simulated restart with fresh counters
+	var restoredPollCount int64
+	var restoredDrops atomic.Int64
+
+	restarted := NewState(path)
+	restarted.Register("PollCount", Int64Counter(&restoredPollCount))
+	restarted.Register("RuntimeChanDrops", &restoredDrops)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+
+	if restoredPollCount != 42 {
+		t.Errorf("Expected PollCount 42 after restart, got %d", restoredPollCount)
+	}
+	if restoredDrops.Load() != 7 {
+		t.Errorf("Expected RuntimeChanDrops 7 after restart, got %d", restoredDrops.Load())
+	}
+}
+
+func TestStateKeepsUnregisteredCounters(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-state.json")
+	if err := os.WriteFile(path, []byte(`{"PollCount": 5, "SendFailures": 3}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var pollCount int64
+	state := NewState(path)
+	state.Register("PollCount", Int64Counter(&pollCount))
+	if err := state.Load(); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+	pollCount++
+	if err := state.Save(); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	var failures atomic.Int64
+	var restoredPollCount int64
+	restarted := NewState(path)
+	restarted.Register("PollCount", Int64Counter(&restoredPollCount))
+	restarted.Register("SendFailures", &failures)
+	if err := restarted.Load(); err != nil {
+		t.Fatalf("Failed to load state: %v", err)
+	}
+
+	if restoredPollCount != 6 || failures.Load() != 3 {
+		t.Errorf("Expected PollCount 6 and SendFailures 3, got %d and %d", restoredPollCount, failures.Load())
+	}
+}
+
+func TestStateLoadInvalidFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "agent-state.json")
+	if err := os.WriteFile(path, []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := NewState(path).Load(); err == nil {
+		t.Error("Expected an error for an invalid state file")
+	}
+}
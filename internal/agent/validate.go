@@ -0,0 +1,96 @@
+package agent
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Validate checks the resolved configuration for values the agent cannot run with and
+// for option combinations that would be silently ignored, such as HTTP-only features
+// enabled together with the gRPC transport. All problems found are returned joined.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.PollInterval <= 0 {
+		errs = append(errs, fmt.Errorf("poll interval must be positive, got %v", c.PollInterval))
+	}
+	if c.SystemPoll < 0 {
+		errs = append(errs, fmt.Errorf("system poll interval must not be negative, got %v", c.SystemPoll))
+	}
+	if c.ReportInterval <= 0 {
+		errs = append(errs, fmt.Errorf("report interval must be positive, got %v", c.ReportInterval))
+	}
+	if c.RateLimit <= 0 {
+		errs = append(errs, fmt.Errorf("rate limit must be positive, got %d", c.RateLimit))
+	}
+	if c.BatchSize < 0 {
+		errs = append(errs, fmt.Errorf("batch size must not be negative, got %d (use 0 to disable batching)", c.BatchSize))
+	}
+
+	if c.LogTailFile != "" {
+		if _, err := regexp.Compile(c.LogTailPattern); err != nil {
+			errs = append(errs, fmt.Errorf("invalid log tail pattern: %w", err))
+		}
+	}
+
+	if c.GRPCAddress != "" {
+		if c.ServerAddress != DefaultServerAddress {
+			errs = append(errs, fmt.Errorf("both HTTP address %q and gRPC address %q are set: use either -a or -g",
+				c.ServerAddress, c.GRPCAddress))
+		}
+		if ignored := c.httpOnlyOptions(); len(ignored) > 0 {
+			errs = append(errs, fmt.Errorf("options %s are only supported by the HTTP agent and have no effect with -g",
+				strings.Join(ignored, ", ")))
+		}
+	}
+
+	if c.NATSAddress != "" {
+		if c.GRPCAddress != "" {
+			errs = append(errs, fmt.Errorf("both gRPC address %q and NATS address %q are set: use either -g or -nats",
+				c.GRPCAddress, c.NATSAddress))
+		} else if c.ServerAddress != DefaultServerAddress {
+			errs = append(errs, fmt.Errorf("both HTTP address %q and NATS address %q are set: use either -a or -nats",
+				c.ServerAddress, c.NATSAddress))
+		}
+		ignored := c.httpOnlyOptions()
+		if c.CryptoKey != "" {
+			ignored = append(ignored, "-crypto-key")
+		}
+		if len(ignored) > 0 {
+			errs = append(errs, fmt.Errorf("options %s are not supported with -nats", strings.Join(ignored, ", ")))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// httpOnlyOptions returns the flags of the enabled options that the gRPC and NATS agents ignore
+func (c *Config) httpOnlyOptions() []string {
+	options := []struct {
+		flag    string
+		enabled bool
+	}{
+		{"-k", c.Key != ""},
+		{"-common-timestamp", c.CommonTS},
+		{"-dead-band", c.DeadBand},
+		{"-channel-metrics", c.ChanMetrics},
+		{"-cpu-prime", c.CPUPrime},
+		{"-retry-metrics", c.RetryMetrics},
+		{"-last-error-metric", c.LastErrorAge},
+		{"-status-address", c.StatusAddress != ""},
+		{"-trace-metric", c.TraceMetric != ""},
+		{"-dead-letter", c.DeadLetter.Path != ""},
+		{"-logtail-file", c.LogTailFile != ""},
+		{"-system-poll-interval", c.SystemPoll != 0 && c.SystemPoll != c.PollInterval},
+	}
+
+	var enabled []string
+	for _, o := range options {
+		if o.enabled {
+			enabled = append(enabled, o.flag)
+		}
+	}
+	return enabled
+}
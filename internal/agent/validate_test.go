@@ -0,0 +1,122 @@
+package agent
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/deadletter"
+)
+
+// validConfig returns a config with the default settings, which passes validation
+func validConfig() *Config {
+	return &Config{
+		ServerAddress:  DefaultServerAddress,
+		PollInterval:   DefaultPollInterval * time.Second,
+		ReportInterval: DefaultReportInterval * time.Second,
+		BatchSize:      DefaultBatchSize,
+		RateLimit:      DefaultRateLimit,
+	}
+}
+
+func TestValidateAgentConfig(t *testing.T) {
+	tests := []struct {
+		name        string
+		modify      func(c *Config)
+		errContains string // empty if the config is valid
+	}{
+		{"defaults", func(c *Config) {}, ""},
+		{"batching disabled", func(c *Config) { c.BatchSize = 0 }, ""},
+		{"gRPC with encryption", func(c *Config) {
+			c.GRPCAddress = "localhost:3200"
+			c.CryptoKey = "/etc/agent/public.pem"
+			c.GRPCCA = "/etc/agent/ca.pem"
+		}, ""},
+		{"zero poll interval", func(c *Config) { c.PollInterval = 0 }, "poll interval must be positive"},
+		{"negative system poll interval", func(c *Config) { c.SystemPoll = -time.Second }, "system poll interval must not be negative"},
+		{"negative report interval", func(c *Config) { c.ReportInterval = -time.Second }, "report interval must be positive"},
+		{"zero rate limit", func(c *Config) { c.RateLimit = 0 }, "rate limit must be positive"},
+		{"negative rate limit", func(c *Config) { c.RateLimit = -1 }, "rate limit must be positive"},
+		{"negative batch size", func(c *Config) { c.BatchSize = -5 }, "batch size must not be negative"},
+		{"log tail", func(c *Config) {
+			c.LogTailFile = "/var/log/app.log"
+			c.LogTailPattern = `level=(error|fatal)`
+		}, ""},
+		{"invalid log tail pattern", func(c *Config) {
+			c.LogTailFile = "/var/log/app.log"
+			c.LogTailPattern = "level=(error"
+		}, "invalid log tail pattern"},
+		{"gRPC and HTTP address", func(c *Config) {
+			c.ServerAddress = "http://metrics.example:8080"
+			c.GRPCAddress = "metrics.example:3200"
+		}, "use either -a or -g"},
+		{"gRPC with signing key", func(c *Config) {
+			c.GRPCAddress = "localhost:3200"
+			c.Key = "secret"
+		}, "options -k are only supported by the HTTP agent"},
+		{"gRPC with dead-letter log", func(c *Config) {
+			c.GRPCAddress = "localhost:3200"
+			c.DeadLetter = deadletter.Config{Path: "/var/lib/agent/dead.log"}
+		}, "options -dead-letter are only supported by the HTTP agent"},
+		{"gRPC with several HTTP-only options", func(c *Config) {
+			c.GRPCAddress = "localhost:3200"
+			c.DeadBand = true
+			c.StatusAddress = "localhost:9090"
+			c.TraceMetric = "Alloc"
+		}, "-dead-band, -status-address, -trace-metric"},
+		{"gRPC with system poll interval", func(c *Config) {
+			c.GRPCAddress = "localhost:3200"
+			c.SystemPoll = 15 * time.Second
+		}, "options -system-poll-interval are only supported by the HTTP agent"},
+		{"NATS", func(c *Config) { c.NATSAddress = "nats://localhost:4222" }, ""},
+		{"NATS and gRPC", func(c *Config) {
+			c.NATSAddress = "nats://localhost:4222"
+			c.GRPCAddress = "localhost:3200"
+		}, "use either -g or -nats"},
+		{"NATS and HTTP address", func(c *Config) {
+			c.NATSAddress = "nats://localhost:4222"
+			c.ServerAddress = "http://metrics.example:8080"
+		}, "use either -a or -nats"},
+		{"NATS with encryption", func(c *Config) {
+			c.NATSAddress = "nats://localhost:4222"
+			c.CryptoKey = "/etc/agent/public.pem"
+		}, "options -crypto-key are not supported with -nats"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := validConfig()
+			tt.modify(c)
+			err := c.Validate()
+			if tt.errContains == "" {
+				if err != nil {
+					t.Fatalf("Validate() unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("Validate() expected error containing %q", tt.errContains)
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Validate() error = %q, want it to contain %q", err, tt.errContains)
+			}
+		})
+	}
+}
+
+func TestValidateAgentConfigReportsAllProblems(t *testing.T) {
+	c := validConfig()
+	c.PollInterval = -time.Second
+	c.RateLimit = 0
+	c.BatchSize = -1
+
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Expected validation to fail")
+	}
+	for _, want := range []string{"poll interval", "rate limit", "batch size"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("Expected error to mention %s, got %q", want, err)
+		}
+	}
+}
@@ -2,14 +2,16 @@
 package audit
 
 import (
-	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
-	"net/http"
+	"io"
 	"os"
 	"sync"
 	"time"
 
+	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/rs/zerolog/log"
 )
 
@@ -23,8 +25,57 @@ type Event struct {
 
 	// IPAddress is the IP address of the incoming request
 	IPAddress string `json:"ip_address"`
+
+	// Changes contains the values written by an update, in the order of Metrics.
+	// It is omitted for events without written values.
+	Changes []MetricChange `json:"changes,omitempty"`
+}
+
+// MetricChange describes a metric written by an update.
+type MetricChange struct {
+	// ID is the name of the metric
+	ID string `json:"id"`
+
+	// MType is the metric type: "gauge" or "counter"
+	MType string `json:"type"`
+
+	// Value is the new value of a gauge
+	Value *float64 `json:"value,omitempty"`
+
+	// Delta is the delta added to a counter
+	Delta *int64 `json:"delta,omitempty"`
+}
+
+// NewChangeEvent creates an event for an update by the client at ipAddress, listing
+// the names of the changed metrics in Metrics.
+func NewChangeEvent(ipAddress string, changes []MetricChange) Event {
+	names := make([]string, len(changes))
+	for i, change := range changes {
+		names[i] = change.ID
+	}
+	return Event{
+		Timestamp: time.Now().Unix(),
+		Metrics:   names,
+		IPAddress: ipAddress,
+		Changes:   changes,
+	}
 }
 
+// ChangesFromMetrics returns the changes written by storing the given metrics.
+func ChangesFromMetrics(metrics []models.Metrics) []MetricChange {
+	changes := make([]MetricChange, len(metrics))
+	for i, m := range metrics {
+		changes[i] = MetricChange{ID: m.ID, MType: m.MType, Value: m.Value, Delta: m.Delta}
+	}
+	return changes
+}
+
+// SyslogTag is the tag of the audit messages written by SyslogAuditor
+const SyslogTag = "metrics-audit"
+
+// ErrSyslogUnsupported is returned by NewSyslogAuditor on platforms without syslog
+var ErrSyslogUnsupported = errors.New("syslog is not supported on this platform")
+
 // Observer defines the interface for audit observers.
 // Observers are notified when an audit event occurs.
 type Observer interface {
@@ -67,6 +118,38 @@ func (s *Subject) Notify(event Event) {
 	}
 }
 
+// contextCloser is implemented by observers whose Close waits for queued events until
+// the context is done, like RemoteAuditor
+type contextCloser interface {
+	Close(ctx context.Context) error
+}
+
+// Close detaches all observers and closes those implementing io.Closer or a Close
+// taking a context, so that buffered and queued events are written out. Observers
+// are closed in the order they were attached; other observers are skipped. A second
+// Close does nothing.
+func (s *Subject) Close(ctx context.Context) error {
+	s.mu.Lock()
+	observers := s.observers
+	s.observers = nil
+	s.mu.Unlock()
+
+	var errs []error
+	for _, observer := range observers {
+		var err error
+		switch closer := observer.(type) {
+		case contextCloser:
+			err = closer.Close(ctx)
+		case io.Closer:
+			err = closer.Close()
+		}
+		if err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
 // HasObservers returns true if there are any observers attached.
 func (s *Subject) HasObservers() bool {
 	s.mu.RLock()
@@ -76,8 +159,10 @@ func (s *Subject) HasObservers() bool {
 
 // FileAuditor writes audit events to a file.
 type FileAuditor struct {
-	filePath string
-	mu       sync.Mutex
+	filePath   string
+	maxBytes   int64 // Size at which the file is rotated (0 disables rotation)
+	maxBackups int   // Number of rotated files kept as <file>.1 to <file>.N
+	mu         sync.Mutex
 }
 
 // NewFileAuditor creates a new file-based audit observer.
@@ -98,6 +183,23 @@ func NewFileAuditor(filePath string) (*FileAuditor, error) {
 	}, nil
 }
 
+// NewFileAuditorWithRotation creates a file-based audit observer that rotates the file
+// before it grows past maxBytes: the file is renamed to <path>.1, older rotated files
+// move up to <path>.2 and so on, and the oldest beyond maxBackups is removed. With
+// maxBackups 0 the full file is discarded. A maxBytes of 0 or less disables rotation.
+func NewFileAuditorWithRotation(path string, maxBytes int64, maxBackups int) (*FileAuditor, error) {
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("max backups cannot be negative")
+	}
+	auditor, err := NewFileAuditor(path)
+	if err != nil {
+		return nil, err
+	}
+	auditor.maxBytes = maxBytes
+	auditor.maxBackups = maxBackups
+	return auditor, nil
+}
+
 // Notify writes the audit event to the file as a JSON line.
 func (f *FileAuditor) Notify(event Event) error {
 	f.mu.Lock()
@@ -108,6 +210,16 @@ func (f *FileAuditor) Notify(event Event) error {
 	if err != nil {
 		return fmt.Errorf("failed to marshal audit event: %w", err)
 	}
+	data = append(data, '\n')
+
+	// Rotate first if the event would take the file past its size limit
+	if f.maxBytes > 0 {
+		if info, err := os.Stat(f.filePath); err == nil && info.Size() > 0 && info.Size()+int64(len(data)) > f.maxBytes {
+			if err := rotateFile(f.filePath, f.maxBackups); err != nil {
+				return fmt.Errorf("failed to rotate audit file: %w", err)
+			}
+		}
+	}
 
 	// Open file in append mode
 	file, err := os.OpenFile(f.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
@@ -117,7 +229,7 @@ func (f *FileAuditor) Notify(event Event) error {
 	defer file.Close()
 
 	// Write JSON line
-	if _, err := file.Write(append(data, '\n')); err != nil {
+	if _, err := file.Write(data); err != nil {
 		return fmt.Errorf("failed to write to audit file: %w", err)
 	}
 
@@ -129,58 +241,31 @@ func (f *FileAuditor) Notify(event Event) error {
 	return nil
 }
 
-// RemoteAuditor sends audit events to a remote server via HTTP POST.
-type RemoteAuditor struct {
-	url        string
-	httpClient *http.Client
-}
-
-// NewRemoteAuditor creates a new remote server audit observer.
-func NewRemoteAuditor(url string) (*RemoteAuditor, error) {
-	if url == "" {
-		return nil, fmt.Errorf("URL cannot be empty")
+// rotateFile shifts the rotated files of path up by one, dropping the oldest beyond
+// maxBackups, and moves the current file to <path>.1. With maxBackups 0 the file is
+// removed. The caller must serialize writes to path.
+func rotateFile(path string, maxBackups int) error {
+	if maxBackups == 0 {
+		return os.Remove(path)
 	}
 
-	return &RemoteAuditor{
-		url: url,
-		httpClient: &http.Client{
-			Timeout: 5 * time.Second,
-		},
-	}, nil
-}
-
-// Notify sends the audit event to the remote server via HTTP POST.
-func (r *RemoteAuditor) Notify(event Event) error {
-	// Marshal event to JSON
-	data, err := json.Marshal(event)
-	if err != nil {
-		return fmt.Errorf("failed to marshal audit event: %w", err)
+	if err := os.Remove(backupPath(path, maxBackups)); err != nil && !os.IsNotExist(err) {
+		return err
 	}
-
-	// Create HTTP request
-	req, err := http.NewRequest("POST", r.url, bytes.NewReader(data))
-	if err != nil {
-		return fmt.Errorf("failed to create audit request: %w", err)
+	for i := maxBackups - 1; i >= 1; i-- {
+		if err := os.Rename(backupPath(path, i), backupPath(path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
 	}
-
-	req.Header.Set("Content-Type", "application/json")
-
-	// Send request
-	resp, err := r.httpClient.Do(req)
-	if err != nil {
-		return fmt.Errorf("failed to send audit event: %w", err)
+	if err := os.Rename(path, backupPath(path, 1)); err != nil {
+		return err
 	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		return fmt.Errorf("remote audit server returned status %d", resp.StatusCode)
-	}
-
-	log.Debug().
-		Str("url", r.url).
-		Int("status", resp.StatusCode).
-		Int("metrics_count", len(event.Metrics)).
-		Msg("Audit event sent to remote server")
 
+	log.Info().Str("file", path).Msg("Audit file rotated")
 	return nil
 }
+
+// backupPath returns the path of the n-th rotated audit file
+func backupPath(path string, n int) string {
+	return fmt.Sprintf("%s.%d", path, n)
+}
@@ -1,12 +1,20 @@
 package audit
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"io"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
+	"sync"
 	"testing"
 	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
 )
 
 func TestNewSubject(t *testing.T) {
@@ -238,6 +246,81 @@ func TestMultipleObservers(t *testing.T) {
 	}
 }
 
+// closingObserver counts how often it is closed
+type closingObserver struct {
+	closes int
+	err    error
+}
+
+func (o *closingObserver) Notify(Event) error { return nil }
+
+func (o *closingObserver) Close() error {
+	o.closes++
+	return o.err
+}
+
+// plainObserver has no Close method
+type plainObserver struct{}
+
+func (plainObserver) Notify(Event) error { return nil }
+
+func TestSubjectClose(t *testing.T) {
+	subject := NewSubject()
+	closer := &closingObserver{}
+	failing := &closingObserver{err: fmt.Errorf("flush failed")}
+	subject.Attach(closer)
+	subject.Attach(plainObserver{})
+	subject.Attach(failing)
+
+	err := subject.Close(context.Background())
+	if err == nil || !strings.Contains(err.Error(), "flush failed") {
+		t.Errorf("Expected the observer's close error, got %v", err)
+	}
+	if closer.closes != 1 || failing.closes != 1 {
+		t.Errorf("Expected each closer to be closed once, got %d and %d", closer.closes, failing.closes)
+	}
+	if subject.HasObservers() {
+		t.Error("Expected Close to detach the observers")
+	}
+
+	if err := subject.Close(context.Background()); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+	if closer.closes != 1 {
+		t.Errorf("Expected a second Close not to close observers again, got %d closes", closer.closes)
+	}
+}
+
+func TestSubjectCloseDrainsRemoteAuditor(t *testing.T) {
+	var mu sync.Mutex
+	var received int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		received++
+		mu.Unlock()
+	}))
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditor(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	subject := NewSubject()
+	subject.Attach(auditor)
+	for i := 0; i < 5; i++ {
+		subject.Notify(Event{Timestamp: int64(i)})
+	}
+
+	if err := subject.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if received != 5 {
+		t.Errorf("Expected the queued events to be sent on Close, got %d of 5", received)
+	}
+}
+
 func TestNewFileAuditorError(t *testing.T) {
 	// Try to create auditor with invalid path
 	_, err := NewFileAuditor("")
@@ -260,3 +343,158 @@ func TestNewRemoteAuditorError(t *testing.T) {
 		t.Error("Expected error for empty URL")
 	}
 }
+
+func TestFileAuditorRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+
+	// Each event line is under 60 bytes, so three fit into one file
+	auditor, err := NewFileAuditorWithRotation(path, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed to create rotating file auditor: %v", err)
+	}
+
+	for i := 0; i < 12; i++ {
+		event := Event{Timestamp: int64(i), Metrics: []string{"cpu_usage"}, IPAddress: "10.0.0.1"}
+		if err := auditor.Notify(event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+
+	// 12 events make four files; the oldest is discarded with two backups
+	for name, want := range map[string][]int64{
+		path:        {9, 10, 11},
+		path + ".1": {6, 7, 8},
+		path + ".2": {3, 4, 5},
+	} {
+		var got []int64
+		for _, event := range readEvents(t, name) {
+			got = append(got, event.Timestamp)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s to hold events %v, got %v", filepath.Base(name), want, got)
+		}
+		if info, err := os.Stat(name); err == nil && info.Size() > 200 {
+			t.Errorf("Expected %s to stay within 200 bytes, got %d", filepath.Base(name), info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third backup, got %v", err)
+	}
+}
+
+func TestFileAuditorRotationConcurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	auditor, err := NewFileAuditorWithRotation(path, 1000, 100)
+	if err != nil {
+		t.Fatalf("Failed to create rotating file auditor: %v", err)
+	}
+
+	const goroutines, events = 10, 20
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				if err := auditor.Notify(Event{Timestamp: int64(i), Metrics: []string{"cpu_usage"}}); err != nil {
+					t.Errorf("Notify failed: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Every event ends up in exactly one file, each a sequence of complete lines
+	files, _ := filepath.Glob(path + "*")
+	total := 0
+	for _, name := range files {
+		total += len(readEvents(t, name))
+	}
+	if total != goroutines*events {
+		t.Errorf("Expected %d events across %d files, got %d", goroutines*events, len(files), total)
+	}
+	if len(files) < 2 {
+		t.Errorf("Expected the file to be rotated, got %v", files)
+	}
+}
+
+func TestNewFileAuditorWithRotationError(t *testing.T) {
+	if _, err := NewFileAuditorWithRotation(filepath.Join(t.TempDir(), "audit.json"), 1024, -1); err == nil {
+		t.Error("Expected error for negative max backups")
+	}
+}
+
+func TestChangeEventSerialization(t *testing.T) {
+	value, delta := 1.5, int64(3)
+	event := NewChangeEvent("10.0.0.1", ChangesFromMetrics([]models.Metrics{
+		{ID: "cpu", MType: "gauge", Value: &value},
+		{ID: "requests", MType: "counter", Delta: &delta},
+	}))
+	if got := strings.Join(event.Metrics, ","); got != "cpu,requests" {
+		t.Errorf("Expected Metrics to list the changed names, got %s", got)
+	}
+
+	// The file and remote auditors write the changes
+	path := filepath.Join(t.TempDir(), "audit.json")
+	fileAuditor, err := NewFileAuditor(path)
+	if err != nil {
+		t.Fatalf("Failed to create file auditor: %v", err)
+	}
+	if err := fileAuditor.Notify(event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	received := make(chan []byte, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		received <- body
+	}))
+	defer server.Close()
+	remoteAuditor, err := NewRemoteAuditor(server.URL)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	defer remoteAuditor.Close(context.Background())
+	remoteAuditor.Notify(event)
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read audit file: %v", err)
+	}
+	var remoteData []byte
+	select {
+	case remoteData = <-received:
+	case <-time.After(time.Second):
+		t.Fatal("Timeout waiting for remote event")
+	}
+
+	for name, line := range map[string][]byte{"file": data, "remote": remoteData} {
+		var decoded Event
+		if err := json.Unmarshal(line, &decoded); err != nil {
+			t.Fatalf("%s: invalid event: %v", name, err)
+		}
+		c := decoded.Changes
+		if len(c) != 2 || c[0].ID != "cpu" || *c[0].Value != 1.5 || c[1].MType != "counter" || *c[1].Delta != 3 {
+			t.Errorf("%s: unexpected changes %+v", name, c)
+		}
+
+		// Consumers of the original format still find their fields
+		var legacy struct {
+			Timestamp int64    `json:"ts"`
+			Metrics   []string `json:"metrics"`
+			IPAddress string   `json:"ip_address"`
+		}
+		if err := json.Unmarshal(line, &legacy); err != nil {
+			t.Fatalf("%s: legacy decoding failed: %v", name, err)
+		}
+		if legacy.Timestamp != event.Timestamp || len(legacy.Metrics) != 2 || legacy.IPAddress != "10.0.0.1" {
+			t.Errorf("%s: unexpected legacy fields %+v", name, legacy)
+		}
+	}
+
+	// Events without changes keep the original format
+	plain, _ := json.Marshal(Event{Timestamp: 1, Metrics: []string{"cpu"}})
+	if strings.Contains(string(plain), "changes") {
+		t.Errorf("Expected changes to be omitted, got %s", plain)
+	}
+}
@@ -0,0 +1,227 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultFlushInterval is how often a BufferedFileAuditor flushes by default
+	DefaultFlushInterval = time.Second
+
+	// DefaultFlushSize is the amount of buffered data a BufferedFileAuditor flushes at by default
+	DefaultFlushSize = 64 * 1024
+)
+
+// ErrAuditorClosed is returned by BufferedFileAuditor.Notify and RemoteAuditor.Notify after Close
+var ErrAuditorClosed = errors.New("auditor is closed")
+
+// BufferedFileAuditor writes audit events to a file like FileAuditor, but keeps the file
+// open and buffers the JSON lines in memory. The buffer is written out every flush
+// interval and whenever it holds at least the flush size, so events are lost if the
+// process dies before the next flush. Close flushes the remaining events.
+type BufferedFileAuditor struct {
+	filePath   string
+	flushSize  int
+	maxBytes   int64 // Size at which the file is rotated (0 disables rotation)
+	maxBackups int   // Number of rotated files kept as <file>.1 to <file>.N
+
+	mu     sync.Mutex
+	file   *os.File
+	writer *bufio.Writer
+	size   int64 // Bytes written to the file plus bytes buffered for it
+	closed bool
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewBufferedFileAuditor creates a buffered file-based audit observer flushing every
+// flushInterval and at flushSize bytes. Zero values select DefaultFlushInterval and
+// DefaultFlushSize.
+func NewBufferedFileAuditor(filePath string, flushInterval time.Duration, flushSize int) (*BufferedFileAuditor, error) {
+	if filePath == "" {
+		return nil, fmt.Errorf("file path cannot be empty")
+	}
+	if flushInterval <= 0 {
+		flushInterval = DefaultFlushInterval
+	}
+	if flushSize <= 0 {
+		flushSize = DefaultFlushSize
+	}
+
+	b := &BufferedFileAuditor{
+		filePath:  filePath,
+		flushSize: flushSize,
+		stop:      make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	if err := b.openLocked(); err != nil {
+		return nil, err
+	}
+	go b.flushLoop(flushInterval)
+	return b, nil
+}
+
+// NewBufferedFileAuditorWithRotation creates a buffered file-based audit observer that
+// rotates the file like NewFileAuditorWithRotation: before a buffered event would take
+// the file past maxBytes, the buffer is flushed and the file rotated. A maxBytes of 0 or
+// less disables rotation.
+func NewBufferedFileAuditorWithRotation(filePath string, flushInterval time.Duration, flushSize int, maxBytes int64, maxBackups int) (*BufferedFileAuditor, error) {
+	if maxBackups < 0 {
+		return nil, fmt.Errorf("max backups cannot be negative")
+	}
+	b, err := NewBufferedFileAuditor(filePath, flushInterval, flushSize)
+	if err != nil {
+		return nil, err
+	}
+	b.mu.Lock()
+	b.maxBytes = maxBytes
+	b.maxBackups = maxBackups
+	b.mu.Unlock()
+	return b, nil
+}
+
+// openLocked opens the audit file for appending and resets the buffer; the caller must
+// hold mu or own the auditor
+func (b *BufferedFileAuditor) openLocked() error {
+	file, err := os.OpenFile(b.filePath, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open audit file: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat audit file: %w", err)
+	}
+
+	b.file = file
+	b.size = info.Size()
+	// Leave room for one more event, so that reaching flushSize triggers our own
+	// flush rather than bufio writing out a partial line
+	b.writer = bufio.NewWriterSize(file, 2*b.flushSize)
+	return nil
+}
+
+// rotateLocked writes out the buffer, rotates the file and opens a new one; the caller
+// must hold mu
+func (b *BufferedFileAuditor) rotateLocked() error {
+	if err := b.flushLocked(); err != nil {
+		return err
+	}
+	if err := b.file.Close(); err != nil {
+		return fmt.Errorf("failed to close audit file: %w", err)
+	}
+	if err := rotateFile(b.filePath, b.maxBackups); err != nil {
+		// Keep appending to the current file rather than losing events
+		if openErr := b.openLocked(); openErr != nil {
+			return errors.Join(err, openErr)
+		}
+		return fmt.Errorf("failed to rotate audit file: %w", err)
+	}
+	return b.openLocked()
+}
+
+// Notify appends the audit event to the buffer as a JSON line
+func (b *BufferedFileAuditor) Notify(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return ErrAuditorClosed
+	}
+	line := int64(len(data)) + 1
+	if b.maxBytes > 0 && b.size > 0 && b.size+line > b.maxBytes {
+		if err := b.rotateLocked(); err != nil {
+			return err
+		}
+	}
+	b.writer.Write(data)
+	if err := b.writer.WriteByte('\n'); err != nil {
+		return fmt.Errorf("failed to write to audit file: %w", err)
+	}
+	b.size += line
+	if b.writer.Buffered() >= b.flushSize {
+		return b.flushLocked()
+	}
+	return nil
+}
+
+// Flush writes the buffered events to the file
+func (b *BufferedFileAuditor) Flush() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return nil
+	}
+	return b.flushLocked()
+}
+
+// Close stops the periodic flushing, writes the remaining events and closes the file
+func (b *BufferedFileAuditor) Close() error {
+	b.mu.Lock()
+	if b.closed {
+		b.mu.Unlock()
+		return nil
+	}
+	b.closed = true
+	b.mu.Unlock()
+
+	close(b.stop)
+	<-b.done
+
+	// Notify and Flush no longer touch the writer once closed is set
+	flushErr := b.flushLocked()
+	closeErr := b.file.Close()
+	return errors.Join(flushErr, closeErr)
+}
+
+// flushLoop flushes the buffer every interval until Close
+func (b *BufferedFileAuditor) flushLoop(interval time.Duration) {
+	defer close(b.done)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-b.stop:
+			return
+		case <-ticker.C:
+			if err := b.Flush(); err != nil {
+				log.Error().Err(err).Str("file", b.filePath).Msg("Failed to flush audit file")
+			}
+		}
+	}
+}
+
+// flushLocked writes the buffered events to the file; the caller must hold mu or
+// have closed the auditor
+func (b *BufferedFileAuditor) flushLocked() error {
+	buffered := b.writer.Buffered()
+	if buffered == 0 {
+		return nil
+	}
+	if err := b.writer.Flush(); err != nil {
+		return fmt.Errorf("failed to write to audit file: %w", err)
+	}
+
+	log.Debug().
+		Str("file", b.filePath).
+		Int("bytes", buffered).
+		Msg("Audit events flushed to file")
+	return nil
+}
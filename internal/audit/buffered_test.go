@@ -0,0 +1,209 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/rs/zerolog"
+)
+
+// readEvents returns the audit events written to the file at path
+func readEvents(t *testing.T, path string) []Event {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("Failed to open audit file: %v", err)
+	}
+	defer file.Close()
+
+	var events []Event
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event Event
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Invalid audit line %q: %v", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+func TestBufferedFileAuditorConcurrentNotify(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	auditor, err := NewBufferedFileAuditor(path, time.Hour, 0)
+	if err != nil {
+		t.Fatalf("Failed to create buffered file auditor: %v", err)
+	}
+
+	const goroutines, events = 20, 50
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < events; i++ {
+				event := Event{Timestamp: int64(i), Metrics: []string{fmt.Sprintf("metric_%d", g)}, IPAddress: "10.0.0.1"}
+				if err := auditor.Notify(event); err != nil {
+					t.Errorf("Notify failed: %v", err)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if err := auditor.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if got := len(readEvents(t, path)); got != goroutines*events {
+		t.Errorf("Expected %d events after Close, got %d", goroutines*events, got)
+	}
+
+	if err := auditor.Notify(Event{}); !errors.Is(err, ErrAuditorClosed) {
+		t.Errorf("Expected ErrAuditorClosed after Close, got %v", err)
+	}
+	if err := auditor.Close(); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+}
+
+func TestBufferedFileAuditorFlushesAtSize(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	auditor, err := NewBufferedFileAuditor(path, time.Hour, 200)
+	if err != nil {
+		t.Fatalf("Failed to create buffered file auditor: %v", err)
+	}
+	defer auditor.Close()
+
+	event := Event{Timestamp: 1, Metrics: []string{"cpu_usage"}, IPAddress: "10.0.0.1"}
+	auditor.Notify(event)
+	if got := len(readEvents(t, path)); got != 0 {
+		t.Fatalf("Expected the event to stay buffered, got %d events in the file", got)
+	}
+
+	// Each event is about 60 bytes, so the fourth one crosses the threshold
+	for i := 0; i < 3; i++ {
+		auditor.Notify(event)
+	}
+	if got := len(readEvents(t, path)); got != 4 {
+		t.Errorf("Expected 4 events flushed at the size threshold, got %d", got)
+	}
+}
+
+func TestBufferedFileAuditorFlushesOnInterval(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+	auditor, err := NewBufferedFileAuditor(path, 10*time.Millisecond, 0)
+	if err != nil {
+		t.Fatalf("Failed to create buffered file auditor: %v", err)
+	}
+	defer auditor.Close()
+
+	auditor.Notify(Event{Timestamp: 1, Metrics: []string{"cpu_usage"}})
+
+	deadline := time.Now().Add(time.Second)
+	for len(readEvents(t, path)) == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the event to be flushed by the interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestBufferedFileAuditorRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.json")
+
+	// Each event line is under 60 bytes, so three fit into one file
+	auditor, err := NewBufferedFileAuditorWithRotation(path, time.Hour, 0, 200, 2)
+	if err != nil {
+		t.Fatalf("Failed to create rotating buffered auditor: %v", err)
+	}
+
+	for i := 0; i < 12; i++ {
+		event := Event{Timestamp: int64(i), Metrics: []string{"cpu_usage"}, IPAddress: "10.0.0.1"}
+		if err := auditor.Notify(event); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+	if err := auditor.Close(); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	// The same files as with the unbuffered auditor
+	for name, want := range map[string][]int64{
+		path:        {9, 10, 11},
+		path + ".1": {6, 7, 8},
+		path + ".2": {3, 4, 5},
+	} {
+		var got []int64
+		for _, event := range readEvents(t, name) {
+			got = append(got, event.Timestamp)
+		}
+		if fmt.Sprint(got) != fmt.Sprint(want) {
+			t.Errorf("Expected %s to hold events %v, got %v", filepath.Base(name), want, got)
+		}
+		if info, err := os.Stat(name); err == nil && info.Size() > 200 {
+			t.Errorf("Expected %s to stay within 200 bytes, got %d", filepath.Base(name), info.Size())
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("Expected no third backup, got %v", err)
+	}
+}
+
+func TestNewBufferedFileAuditorError(t *testing.T) {
+	if _, err := NewBufferedFileAuditor("", 0, 0); err == nil {
+		t.Error("Expected error for empty file path")
+	}
+	if _, err := NewBufferedFileAuditor("/nonexistent/directory/audit.json", 0, 0); err == nil {
+		t.Error("Expected error for invalid file path")
+	}
+}
+
+// benchmarkEvent is a typical audit event of a batch update
+var benchmarkEvent = Event{
+	Timestamp: 1700000000,
+	Metrics:   []string{"Alloc", "HeapAlloc", "PollCount", "RandomValue"},
+	IPAddress: "192.168.1.100",
+}
+
+// quietLogs disables debug logging for the duration of a benchmark
+func quietLogs(b *testing.B) {
+	level := zerolog.GlobalLevel()
+	zerolog.SetGlobalLevel(zerolog.InfoLevel)
+	b.Cleanup(func() { zerolog.SetGlobalLevel(level) })
+}
+
+func BenchmarkFileAuditor(b *testing.B) {
+	quietLogs(b)
+	auditor, err := NewFileAuditor(filepath.Join(b.TempDir(), "audit.json"))
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			auditor.Notify(benchmarkEvent)
+		}
+	})
+}
+
+func BenchmarkBufferedFileAuditor(b *testing.B) {
+	quietLogs(b)
+	auditor, err := NewBufferedFileAuditor(filepath.Join(b.TempDir(), "audit.json"), 0, 0)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer auditor.Close()
+
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			auditor.Notify(benchmarkEvent)
+		}
+	})
+}
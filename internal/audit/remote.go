@@ -0,0 +1,360 @@
+package audit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+	"github.com/rs/zerolog/log"
+)
+
+// DefaultRemoteQueueSize is how many events a RemoteAuditor queues by default
+const DefaultRemoteQueueSize = 1000
+
+// statusError is returned when the remote audit server rejects an event
+type statusError struct {
+	code int
+}
+
+func (e *statusError) Error() string {
+	return fmt.Sprintf("remote audit server returned status %d", e.code)
+}
+
+// retriableStatus reports whether err is a rejection worth retrying: a server error
+// or a rate limit. Other statuses reject the event itself, so it is dropped.
+func retriableStatus(err error) bool {
+	var se *statusError
+	if !errors.As(err, &se) {
+		return false
+	}
+	return se.code >= 500 || se.code == http.StatusTooManyRequests
+}
+
+// RemoteAuditor sends audit events to a remote server via HTTP POST.
+//
+// Notify only queues the event; a background sender delivers the queued events in
+// order, retrying failed deliveries with backoff. When the queue is full the oldest
+// event is dropped to make room for the new one.
+//
+// By default every event is posted on its own as a JSON object. With WithBatching the
+// events are posted as a JSON array of up to the batch size events.
+type RemoteAuditor struct {
+	url           string
+	httpClient    *http.Client
+	queueSize     int
+	retryConfig   retry.RetryConfig
+	batchSize     int
+	flushInterval time.Duration
+
+	mu      sync.Mutex
+	queue   []Event
+	dropped int64
+	closing bool
+
+	// oldest is when the oldest queued event was queued, zero if it is due already
+	oldest time.Time
+
+	wake   chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+// RemoteAuditorOption configures a RemoteAuditor created by NewRemoteAuditor
+type RemoteAuditorOption func(*RemoteAuditor)
+
+// WithBatching posts the events as JSON arrays of up to size events. A batch is sent
+// once size events are queued or flushInterval after its first event was queued,
+// whichever comes first. A flush interval of 0 or less uses DefaultFlushInterval; a
+// size of 1 or less keeps posting single events.
+func WithBatching(size int, flushInterval time.Duration) RemoteAuditorOption {
+	return func(r *RemoteAuditor) {
+		if size < 1 {
+			size = 1
+		}
+		if flushInterval <= 0 {
+			flushInterval = DefaultFlushInterval
+		}
+		r.batchSize = size
+		r.flushInterval = flushInterval
+	}
+}
+
+// NewRemoteAuditor creates a new remote server audit observer queuing up to
+// DefaultRemoteQueueSize events and retrying with retry.DefaultConfig.
+func NewRemoteAuditor(url string, opts ...RemoteAuditorOption) (*RemoteAuditor, error) {
+	return NewRemoteAuditorWithQueue(url, DefaultRemoteQueueSize, retry.DefaultConfig(), opts...)
+}
+
+// NewRemoteAuditorWithQueue creates a remote server audit observer queuing up to
+// queueSize events and retrying each delivery according to retryConfig.
+func NewRemoteAuditorWithQueue(url string, queueSize int, retryConfig retry.RetryConfig, opts ...RemoteAuditorOption) (*RemoteAuditor, error) {
+	if url == "" {
+		return nil, fmt.Errorf("URL cannot be empty")
+	}
+	if queueSize <= 0 {
+		return nil, fmt.Errorf("queue size must be positive, got %d", queueSize)
+	}
+
+	// Server errors are transient for an audit endpoint, unlike for the built-in rules
+	retryConfig.IsRetriable = retriableStatus
+
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &RemoteAuditor{
+		url: url,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+		queueSize:   queueSize,
+		retryConfig: retryConfig,
+		batchSize:   1,
+		wake:        make(chan struct{}, 1),
+		ctx:         ctx,
+		cancel:      cancel,
+		done:        make(chan struct{}),
+	}
+	for _, opt := range opts {
+		opt(r)
+	}
+	go r.sendLoop()
+	return r, nil
+}
+
+// Notify queues the audit event for delivery to the remote server.
+func (r *RemoteAuditor) Notify(event Event) error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		return ErrAuditorClosed
+	}
+	if len(r.queue) >= r.queueSize {
+		r.queue = r.queue[1:]
+		r.dropped++
+		log.Warn().
+			Str("url", r.url).
+			Int("queue_size", r.queueSize).
+			Msg("Audit queue is full, dropping the oldest event")
+	}
+	if len(r.queue) == 0 {
+		r.oldest = time.Now()
+	}
+	r.queue = append(r.queue, event)
+	r.mu.Unlock()
+
+	r.signal()
+	return nil
+}
+
+// QueueDepth returns the number of events waiting to be sent, not counting the one
+// being sent.
+func (r *RemoteAuditor) QueueDepth() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return len(r.queue)
+}
+
+// Dropped returns the number of events dropped because the queue was full.
+func (r *RemoteAuditor) Dropped() int64 {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.dropped
+}
+
+// Close stops accepting events and waits until the queued events are sent or ctx is
+// done. Events still queued when ctx is done are lost.
+func (r *RemoteAuditor) Close(ctx context.Context) error {
+	r.mu.Lock()
+	if r.closing {
+		r.mu.Unlock()
+		<-r.done
+		return nil
+	}
+	r.closing = true
+	r.mu.Unlock()
+	r.signal()
+
+	select {
+	case <-r.done:
+		return nil
+	case <-ctx.Done():
+		r.cancel()
+		<-r.done
+		if lost := r.QueueDepth(); lost > 0 {
+			return fmt.Errorf("%d audit events not sent: %w", lost, ctx.Err())
+		}
+		return ctx.Err()
+	}
+}
+
+// signal wakes the sender without blocking
+func (r *RemoteAuditor) signal() {
+	select {
+	case r.wake <- struct{}{}:
+	default:
+	}
+}
+
+// next takes the oldest queued events once a batch is due: when a full batch is
+// queued, the flush interval has passed since the oldest one was queued, or the
+// auditor is closing. It reports false once the auditor is closing and the queue is
+// empty.
+func (r *RemoteAuditor) next() ([]Event, bool) {
+	for {
+		r.mu.Lock()
+		n := len(r.queue)
+		wait := time.Duration(0)
+		if n > 0 && n < r.batchSize && !r.closing {
+			wait = r.flushInterval - time.Since(r.oldest)
+		}
+		if n > 0 && wait <= 0 {
+			if n > r.batchSize {
+				n = r.batchSize
+			}
+			batch := make([]Event, n)
+			copy(batch, r.queue)
+			r.queue = r.queue[n:]
+			r.oldest = time.Now()
+			r.mu.Unlock()
+			return batch, true
+		}
+		closing := r.closing
+		r.mu.Unlock()
+
+		if closing {
+			return nil, false
+		}
+
+		var timer *time.Timer
+		var timeout <-chan time.Time
+		if wait > 0 {
+			timer = time.NewTimer(wait)
+			timeout = timer.C
+		}
+		select {
+		case <-r.wake:
+		case <-timeout:
+		case <-r.ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if r.ctx.Err() != nil {
+			return nil, false
+		}
+	}
+}
+
+// requeue puts an undelivered batch back at the head of the queue, due at once.
+// Events newer than the batch may have filled the queue in the meantime, in which
+// case the oldest events of the batch are dropped.
+func (r *RemoteAuditor) requeue(batch []Event) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if room := r.queueSize - len(r.queue); room < len(batch) {
+		drop := len(batch) - max(room, 0)
+		r.dropped += int64(drop)
+		batch = batch[drop:]
+		log.Warn().
+			Str("url", r.url).
+			Int("dropped", drop).
+			Msg("Audit queue is full, dropping the oldest events")
+	}
+	r.queue = append(append([]Event(nil), batch...), r.queue...)
+	r.oldest = time.Time{}
+}
+
+// sendLoop delivers the queued events until Close
+func (r *RemoteAuditor) sendLoop() {
+	defer close(r.done)
+
+	for {
+		batch, ok := r.next()
+		if !ok {
+			return
+		}
+
+		err := retry.Do(r.ctx, r.retryConfig, func() error {
+			return r.send(batch)
+		})
+		if err == nil {
+			continue
+		}
+		if r.ctx.Err() != nil {
+			r.requeue(batch)
+			return
+		}
+		if !retriableStatus(err) && !retry.IsRetriable(err) {
+			log.Error().
+				Err(err).
+				Str("url", r.url).
+				Int("events", len(batch)).
+				Msg("Remote audit server rejected the events, dropping them")
+			continue
+		}
+
+		// The server is still unavailable: keep the events and back off before the
+		// next round of attempts
+		r.requeue(batch)
+		select {
+		case <-time.After(r.backoff()):
+		case <-r.ctx.Done():
+			return
+		}
+	}
+}
+
+// backoff returns the wait between rounds of attempts: the longest retry interval
+func (r *RemoteAuditor) backoff() time.Duration {
+	if n := len(r.retryConfig.Intervals); n > 0 {
+		return r.retryConfig.Intervals[n-1]
+	}
+	return time.Second
+}
+
+// send posts the audit events to the remote server: a single object without
+// batching, an array otherwise
+func (r *RemoteAuditor) send(batch []Event) error {
+	var payload any = batch
+	if r.batchSize == 1 {
+		payload = batch[0]
+	}
+
+	// Marshal events to JSON
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	// Create HTTP request
+	req, err := http.NewRequestWithContext(r.ctx, "POST", r.url, bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create audit request: %w", err)
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	// Send request
+	resp, err := r.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send audit event: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return &statusError{code: resp.StatusCode}
+	}
+
+	log.Debug().
+		Str("url", r.url).
+		Int("status", resp.StatusCode).
+		Int("events", len(batch)).
+		Msg("Audit events sent to remote server")
+
+	return nil
+}
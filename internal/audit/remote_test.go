@@ -0,0 +1,333 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+)
+
+// fastRetry retries once after a short wait, so rounds of attempts repeat quickly
+var fastRetry = retry.RetryConfig{
+	MaxAttempts: 2,
+	Intervals:   []time.Duration{10 * time.Millisecond},
+}
+
+// flakyServer accepts audit events after rejecting the first failures requests
+// with the given status
+type flakyServer struct {
+	mu       sync.Mutex
+	failures int
+	status   int
+	attempts int
+	received []Event
+}
+
+func (f *flakyServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.attempts++
+	if f.attempts <= f.failures {
+		w.WriteHeader(f.status)
+		return
+	}
+	var event Event
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	f.received = append(f.received, event)
+}
+
+// events returns the delivered events and the number of requests made
+func (f *flakyServer) events() ([]Event, int) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return append([]Event(nil), f.received...), f.attempts
+}
+
+// waitForEvents waits until the server has received n events
+func waitForEvents(t *testing.T, f *flakyServer, n int) []Event {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		events, _ := f.events()
+		if len(events) >= n {
+			return events
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d delivered events, got %d", n, len(events))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRemoteAuditorRetriesUntilDelivered(t *testing.T) {
+	// Five failures outlast one round of two attempts, so the event is requeued too
+	flaky := &flakyServer{failures: 5, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 10, fastRetry)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	defer auditor.Close(context.Background())
+
+	for i := int64(1); i <= 3; i++ {
+		if err := auditor.Notify(Event{Timestamp: i, Metrics: []string{"cpu_usage"}}); err != nil {
+			t.Fatalf("Notify failed: %v", err)
+		}
+	}
+
+	events := waitForEvents(t, flaky, 3)
+	for i, event := range events {
+		if event.Timestamp != int64(i+1) {
+			t.Errorf("Expected events in order, got timestamp %d at position %d", event.Timestamp, i)
+		}
+	}
+	if _, attempts := flaky.events(); attempts != 8 {
+		t.Errorf("Expected 8 requests (5 rejected, 3 delivered), got %d", attempts)
+	}
+	if depth := auditor.QueueDepth(); depth != 0 {
+		t.Errorf("Expected an empty queue, got depth %d", depth)
+	}
+	if dropped := auditor.Dropped(); dropped != 0 {
+		t.Errorf("Expected no dropped events, got %d", dropped)
+	}
+}
+
+func TestRemoteAuditorDropsOldestWhenFull(t *testing.T) {
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var once sync.Once
+	flaky := &flakyServer{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Hold the first delivery so that the following events pile up in the queue
+		once.Do(func() {
+			close(started)
+			<-release
+		})
+		flaky.ServeHTTP(w, r)
+	}))
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 2, fastRetry)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	defer auditor.Close(context.Background())
+
+	auditor.Notify(Event{Timestamp: 1})
+	<-started
+	for i := int64(2); i <= 4; i++ {
+		auditor.Notify(Event{Timestamp: i})
+	}
+
+	if depth := auditor.QueueDepth(); depth != 2 {
+		t.Errorf("Expected queue depth 2, got %d", depth)
+	}
+	if dropped := auditor.Dropped(); dropped != 1 {
+		t.Errorf("Expected 1 dropped event, got %d", dropped)
+	}
+
+	close(release)
+	events := waitForEvents(t, flaky, 3)
+	want := []int64{1, 3, 4}
+	for i, event := range events {
+		if event.Timestamp != want[i] {
+			t.Errorf("Expected timestamp %d at position %d, got %d", want[i], i, event.Timestamp)
+		}
+	}
+}
+
+func TestRemoteAuditorDropsRejectedEvent(t *testing.T) {
+	flaky := &flakyServer{failures: 1, status: http.StatusBadRequest}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 10, fastRetry)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+
+	auditor.Notify(Event{Timestamp: 1})
+	auditor.Notify(Event{Timestamp: 2})
+	if err := auditor.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+
+	events, attempts := flaky.events()
+	if len(events) != 1 || events[0].Timestamp != 2 {
+		t.Errorf("Expected only the second event to be delivered, got %+v", events)
+	}
+	if attempts != 2 {
+		t.Errorf("Expected the rejected event not to be retried, got %d requests", attempts)
+	}
+}
+
+func TestRemoteAuditorClose(t *testing.T) {
+	flaky := &flakyServer{failures: 1 << 30, status: http.StatusServiceUnavailable}
+	server := httptest.NewServer(flaky)
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 10, fastRetry)
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	auditor.Notify(Event{Timestamp: 1})
+
+	// The server never recovers, so Close gives up when the context expires
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := auditor.Close(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected DeadlineExceeded from Close, got %v", err)
+	}
+
+	if err := auditor.Notify(Event{}); !errors.Is(err, ErrAuditorClosed) {
+		t.Errorf("Expected ErrAuditorClosed after Close, got %v", err)
+	}
+	if err := auditor.Close(context.Background()); err != nil {
+		t.Errorf("Expected a second Close to succeed, got %v", err)
+	}
+}
+
+func TestNewRemoteAuditorWithQueueError(t *testing.T) {
+	if _, err := NewRemoteAuditorWithQueue("http://localhost", 0, fastRetry); err == nil {
+		t.Error("Expected error for a zero queue size")
+	}
+}
+
+// batchServer records the JSON arrays of audit events posted to it
+type batchServer struct {
+	t       *testing.T
+	mu      sync.Mutex
+	batches [][]Event
+}
+
+func (s *batchServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	var batch []Event
+	if err := json.NewDecoder(r.Body).Decode(&batch); err != nil {
+		s.t.Errorf("Expected a JSON array of events: %v", err)
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	s.mu.Lock()
+	s.batches = append(s.batches, batch)
+	s.mu.Unlock()
+}
+
+// sizes returns the number of events in each received batch
+func (s *batchServer) sizes() []int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sizes := make([]int, len(s.batches))
+	for i, batch := range s.batches {
+		sizes[i] = len(batch)
+	}
+	return sizes
+}
+
+func TestRemoteAuditorBatching(t *testing.T) {
+	received := &batchServer{t: t}
+	server := httptest.NewServer(received)
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 100, fastRetry, WithBatching(5, 300*time.Millisecond))
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	defer auditor.Close(context.Background())
+
+	for i := int64(1); i <= 12; i++ {
+		auditor.Notify(Event{Timestamp: i, Metrics: []string{"cpu_usage"}})
+	}
+
+	// The two full batches are sent at once, the remaining two events after the interval
+	deadline := time.Now().Add(150 * time.Millisecond)
+	for len(received.sizes()) < 2 && time.Now().Before(deadline) {
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := received.sizes(); len(sizes) != 2 || sizes[0] != 5 || sizes[1] != 5 {
+		t.Fatalf("Expected two full batches before the flush interval, got sizes %v", sizes)
+	}
+
+	deadline = time.Now().Add(time.Second)
+	for len(received.sizes()) < 3 {
+		if time.Now().After(deadline) {
+			t.Fatal("Expected the partial batch to be sent after the flush interval")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	if sizes := received.sizes(); len(sizes) != 3 || sizes[2] != 2 {
+		t.Errorf("Expected a partial batch of 2 events, got sizes %v", sizes)
+	}
+
+	received.mu.Lock()
+	defer received.mu.Unlock()
+	next := int64(1)
+	for _, batch := range received.batches {
+		for _, event := range batch {
+			if event.Timestamp != next {
+				t.Errorf("Expected timestamp %d, got %d", next, event.Timestamp)
+			}
+			next++
+		}
+	}
+}
+
+func TestRemoteAuditorBatchSentOnClose(t *testing.T) {
+	received := &batchServer{t: t}
+	server := httptest.NewServer(received)
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, 100, fastRetry, WithBatching(10, time.Hour))
+	if err != nil {
+		t.Fatalf("Failed to create remote auditor: %v", err)
+	}
+	auditor.Notify(Event{Timestamp: 1})
+	auditor.Notify(Event{Timestamp: 2})
+
+	if err := auditor.Close(context.Background()); err != nil {
+		t.Fatalf("Close failed: %v", err)
+	}
+	if sizes := received.sizes(); len(sizes) != 1 || sizes[0] != 2 {
+		t.Errorf("Expected the partial batch to be sent on Close, got sizes %v", sizes)
+	}
+}
+
+// benchmarkRemoteAuditor measures queuing and delivering b.N events to a server
+// that accepts everything
+func benchmarkRemoteAuditor(b *testing.B, opts ...RemoteAuditorOption) {
+	quietLogs(b)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer server.Close()
+
+	auditor, err := NewRemoteAuditorWithQueue(server.URL, b.N, fastRetry, opts...)
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		auditor.Notify(benchmarkEvent)
+	}
+	if err := auditor.Close(context.Background()); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkRemoteAuditor(b *testing.B) {
+	benchmarkRemoteAuditor(b)
+}
+
+func BenchmarkRemoteAuditorBatched(b *testing.B) {
+	benchmarkRemoteAuditor(b, WithBatching(100, time.Second))
+}
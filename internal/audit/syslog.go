@@ -0,0 +1,71 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"fmt"
+	"log/syslog"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// SyslogAuditor writes audit events to syslog as JSON messages with the
+// SyslogTag tag, at the informational level of the daemon facility.
+type SyslogAuditor struct {
+	address string
+	writer  *syslog.Writer
+}
+
+// NewSyslogAuditor creates a syslog audit observer. The address is "local" for the
+// local syslog daemon (or journald), or "udp://host:port" or "tcp://host:port" for a
+// remote syslog server.
+func NewSyslogAuditor(address string) (*SyslogAuditor, error) {
+	network, raddr, err := parseSyslogAddress(address)
+	if err != nil {
+		return nil, err
+	}
+
+	writer, err := syslog.Dial(network, raddr, syslog.LOG_INFO|syslog.LOG_DAEMON, SyslogTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to syslog: %w", err)
+	}
+	return &SyslogAuditor{address: address, writer: writer}, nil
+}
+
+// Notify writes the audit event to syslog as a JSON message
+func (s *SyslogAuditor) Notify(event Event) error {
+	data, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit event: %w", err)
+	}
+
+	if err := s.writer.Info(string(data)); err != nil {
+		return fmt.Errorf("failed to write to syslog: %w", err)
+	}
+
+	log.Debug().
+		Str("address", s.address).
+		Int("metrics_count", len(event.Metrics)).
+		Msg("Audit event written to syslog")
+	return nil
+}
+
+// Close closes the connection to the syslog server
+func (s *SyslogAuditor) Close() error {
+	return s.writer.Close()
+}
+
+// parseSyslogAddress splits a syslog address into the network and address arguments
+// of syslog.Dial; both are empty for the local daemon
+func parseSyslogAddress(address string) (network, raddr string, err error) {
+	if address == "local" {
+		return "", "", nil
+	}
+	network, raddr, ok := strings.Cut(address, "://")
+	if !ok || (network != "udp" && network != "tcp") || raddr == "" {
+		return "", "", fmt.Errorf("invalid syslog address %q: expected local, udp://host:port or tcp://host:port", address)
+	}
+	return network, raddr, nil
+}
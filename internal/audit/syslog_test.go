@@ -0,0 +1,72 @@
+//go:build !windows && !plan9
+
+package audit
+
+import (
+	"encoding/json"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSyslogAuditorUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+	defer conn.Close()
+
+	auditor, err := NewSyslogAuditor("udp://" + conn.LocalAddr().String())
+	if err != nil {
+		t.Fatalf("Failed to create syslog auditor: %v", err)
+	}
+	defer auditor.Close()
+
+	value := 1.5
+	event := Event{
+		Timestamp: 1700000000,
+		Metrics:   []string{"cpu"},
+		IPAddress: "10.0.0.1",
+		Changes:   []MetricChange{{ID: "cpu", MType: "gauge", Value: &value}},
+	}
+	if err := auditor.Notify(event); err != nil {
+		t.Fatalf("Notify failed: %v", err)
+	}
+
+	buf := make([]byte, 2048)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("Failed to read syslog message: %v", err)
+	}
+	message := string(buf[:n])
+
+	// <30> is the informational level (6) of the daemon facility (3 << 3)
+	if !strings.HasPrefix(message, "<30>") {
+		t.Errorf("Expected priority <30>, got %q", message)
+	}
+	if !strings.Contains(message, " "+SyslogTag+"[") {
+		t.Errorf("Expected tag %s, got %q", SyslogTag, message)
+	}
+
+	_, payload, ok := strings.Cut(message, "]: ")
+	if !ok {
+		t.Fatalf("Expected a tagged message, got %q", message)
+	}
+	var decoded Event
+	if err := json.Unmarshal([]byte(strings.TrimSpace(payload)), &decoded); err != nil {
+		t.Fatalf("Expected a JSON event, got %q: %v", payload, err)
+	}
+	if decoded.IPAddress != "10.0.0.1" || len(decoded.Changes) != 1 || *decoded.Changes[0].Value != 1.5 {
+		t.Errorf("Unexpected event %+v", decoded)
+	}
+}
+
+func TestNewSyslogAuditorError(t *testing.T) {
+	for _, address := range []string{"", "udp://", "http://localhost:514", "localhost:514"} {
+		if _, err := NewSyslogAuditor(address); err == nil {
+			t.Errorf("Expected error for syslog address %q", address)
+		}
+	}
+}
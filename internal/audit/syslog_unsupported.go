@@ -0,0 +1,21 @@
+//go:build windows || plan9
+
+package audit
+
+// SyslogAuditor is not available on this platform, which has no log/syslog.
+type SyslogAuditor struct{}
+
+// NewSyslogAuditor always fails, since syslog is not supported on this platform
+func NewSyslogAuditor(address string) (*SyslogAuditor, error) {
+	return nil, ErrSyslogUnsupported
+}
+
+// Notify does nothing; a SyslogAuditor cannot be created on this platform
+func (s *SyslogAuditor) Notify(event Event) error {
+	return ErrSyslogUnsupported
+}
+
+// Close does nothing; a SyslogAuditor cannot be created on this platform
+func (s *SyslogAuditor) Close() error {
+	return nil
+}
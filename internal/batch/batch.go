@@ -2,22 +2,63 @@ package batch
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
+	"crypto/rand"
 	"crypto/rsa"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"strconv"
 	"sync"
 	"time"
 
 	"github.com/mutualEvg/metrics-server/internal/crypto"
 	"github.com/mutualEvg/metrics-server/internal/hash"
 	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/pool"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/mutualEvg/metrics-server/internal/utils"
 )
 
+// DefaultBackoff is used when the server signals backpressure without a usable Retry-After header
+const DefaultBackoff = 5 * time.Second
+
+// BackpressureError is returned when the server asks the agent to slow down
+// with a 429 Too Many Requests or 503 Service Unavailable response
+type BackpressureError struct {
+	StatusCode int
+	RetryAfter time.Duration
+}
+
+func (e *BackpressureError) Error() string {
+	return fmt.Sprintf("server returned status %d, retry after %v", e.StatusCode, e.RetryAfter)
+}
+
+// CheckBackpressure returns a BackpressureError if the response carries a backpressure signal
+func CheckBackpressure(resp *http.Response) error {
+	if resp.StatusCode != http.StatusTooManyRequests && resp.StatusCode != http.StatusServiceUnavailable {
+		return nil
+	}
+	return &BackpressureError{
+		StatusCode: resp.StatusCode,
+		RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+	}
+}
+
+// parseRetryAfter parses a Retry-After header given in seconds or as an HTTP date
+func parseRetryAfter(value string) time.Duration {
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if d := time.Until(date); d > 0 {
+			return d
+		}
+	}
+	return DefaultBackoff
+}
+
 // Batch holds a collection of metrics to send as batch
 type Batch struct {
 	metrics []models.Metrics
@@ -76,6 +117,12 @@ func Send(metrics []models.Metrics, serverAddr, key string, retryConfig retry.Re
 
 // SendWithEncryption sends a batch of metrics with optional encryption
 func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, publicKey *rsa.PublicKey, retryConfig retry.RetryConfig) error {
+	return SendWithHashAlgo(metrics, serverAddr, key, hash.AlgoSHA256, publicKey, retryConfig)
+}
+
+// SendWithHashAlgo sends a batch of metrics with optional encryption, signed with
+// the given algorithm ("sha256" or "sha512") if a key is configured
+func SendWithHashAlgo(metrics []models.Metrics, serverAddr, key, hashAlgo string, publicKey *rsa.PublicKey, retryConfig retry.RetryConfig) error {
 	if len(metrics) == 0 {
 		return nil // Don't send empty batches
 	}
@@ -83,6 +130,9 @@ func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, public
 	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
 	defer cancel()
 
+	// Every attempt carries the same key, so the server applies a retried batch only once
+	idempotencyKey := newIdempotencyKey()
+
 	return retry.Do(ctx, retryConfig, func() error {
 		// Marshal to JSON
 		jsonData, err := json.Marshal(metrics)
@@ -91,17 +141,14 @@ func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, public
 		}
 
 		// Compress with gzip
-		var compressedData bytes.Buffer
-		gzipWriter := gzip.NewWriter(&compressedData)
-		if _, err := gzipWriter.Write(jsonData); err != nil {
+		compressedData, err := pool.Gzip(jsonData)
+		if err != nil {
 			return fmt.Errorf("failed to compress data: %w", err)
 		}
-		if err := gzipWriter.Close(); err != nil {
-			return fmt.Errorf("failed to close gzip writer: %w", err)
-		}
 
 		// Prepare body data (may be encrypted)
-		bodyData := compressedData.Bytes()
+		bodyData := compressedData
+		encrypted := false
 
 		// Encrypt if public key is configured
 		if publicKey != nil {
@@ -110,10 +157,11 @@ func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, public
 				return fmt.Errorf("failed to encrypt data: %w", err)
 			}
 			bodyData = encryptedData
+			encrypted = true
 		}
 
 		// Create HTTP request
-		url := fmt.Sprintf("%s/updates/", serverAddr)
+		url := fmt.Sprintf("%s/updates/", utils.HTTPBaseURL(serverAddr))
 		req, err := http.NewRequest("POST", url, bytes.NewReader(bodyData))
 		if err != nil {
 			return fmt.Errorf("failed to create request: %w", err)
@@ -121,29 +169,34 @@ func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, public
 
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Content-Encoding", "gzip")
+		req.Header.Set("Idempotency-Key", idempotencyKey)
 
 		// Add X-Real-IP header with the agent's IP address
 		req.Header.Set("X-Real-IP", utils.GetOutboundIP())
 
-		// Add encryption header if data is encrypted
-		if publicKey != nil {
-			req.Header.Set("X-Encrypted", "true")
+		// The server only decrypts bodies marked as encrypted
+		if encrypted {
+			req.Header.Set(crypto.EncryptedHeader, "true")
 		}
 
 		// Add hash header if key is configured (hash is computed before encryption)
 		if key != "" {
-			hashValue := hash.CalculateHash(compressedData.Bytes(), key)
-			req.Header.Set("HashSHA256", hashValue)
+			hashValue := hash.Calculate(compressedData, key, hashAlgo)
+			req.Header.Set(hash.HeaderName(hashAlgo), hashValue)
 		}
 
 		// Send request
-		client := &http.Client{Timeout: 10 * time.Second}
+		client := utils.NewHTTPClient(serverAddr, 10*time.Second)
 		resp, err := client.Do(req)
 		if err != nil {
 			return fmt.Errorf("failed to send request: %w", err)
 		}
 		defer resp.Body.Close()
 
+		if err := CheckBackpressure(resp); err != nil {
+			return err
+		}
+
 		if resp.StatusCode != http.StatusOK {
 			return fmt.Errorf("server returned status %d", resp.StatusCode)
 		}
@@ -151,3 +204,10 @@ func SendWithEncryption(metrics []models.Metrics, serverAddr, key string, public
 		return nil
 	})
 }
+
+// newIdempotencyKey returns a random key identifying one batch across its retries
+func newIdempotencyKey() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
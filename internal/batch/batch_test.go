@@ -1,7 +1,16 @@
 package batch
 
 import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/retry"
 )
 
 func TestNew(t *testing.T) {
@@ -164,3 +173,112 @@ func TestBatchConcurrency(t *testing.T) {
 		t.Errorf("Expected 100 metrics after concurrent adds, got %d", len(batch))
 	}
 }
+
+func TestSendBackpressure(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "7")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	value := 1.0
+	metrics := []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	err := Send(metrics, server.URL, "", retry.NoRetryConfig())
+
+	var bpErr *BackpressureError
+	if !errors.As(err, &bpErr) {
+		t.Fatalf("Expected BackpressureError, got %v", err)
+	}
+	if bpErr.RetryAfter != 7*time.Second {
+		t.Errorf("Expected RetryAfter 7s, got %v", bpErr.RetryAfter)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	if d := parseRetryAfter("3"); d != 3*time.Second {
+		t.Errorf("Expected 3s, got %v", d)
+	}
+	if d := parseRetryAfter(""); d != DefaultBackoff {
+		t.Errorf("Expected default backoff for missing header, got %v", d)
+	}
+	date := time.Now().Add(time.Minute).UTC().Format(http.TimeFormat)
+	if d := parseRetryAfter(date); d <= 0 || d > time.Minute {
+		t.Errorf("Expected up to 1m for HTTP date, got %v", d)
+	}
+}
+
+func TestSendReusesIdempotencyKey(t *testing.T) {
+	var keys []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		keys = append(keys, r.Header.Get("Idempotency-Key"))
+		if len(keys) == 1 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	delta := int64(1)
+	metrics := []models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}}
+	retryConfig := retry.RetryConfig{
+		MaxAttempts: 2,
+		Intervals:   []time.Duration{time.Millisecond},
+		IsRetriable: func(error) bool { return true },
+	}
+
+	if err := Send(metrics, server.URL, "", retryConfig); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("Expected 2 attempts, got %d", len(keys))
+	}
+	if keys[0] == "" || keys[0] != keys[1] {
+		t.Errorf("Expected the retry to repeat the first attempt's key, got %q", keys)
+	}
+
+	// A new batch gets a new key
+	if err := Send(metrics, server.URL, "", retryConfig); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if keys[2] == keys[0] {
+		t.Error("Expected a new batch to get a new key")
+	}
+}
+
+func TestSendEncryptedHeader(t *testing.T) {
+	privateKey, publicKey, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var header string
+	var body []byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header = r.Header.Get(crypto.EncryptedHeader)
+		body, _ = io.ReadAll(r.Body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	value := 1.0
+	metrics := []models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}}
+
+	if err := SendWithEncryption(metrics, server.URL, "", publicKey, retry.NoRetryConfig()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if header != "true" {
+		t.Errorf("Expected %s: true on an encrypted batch, got %q", crypto.EncryptedHeader, header)
+	}
+	if _, err := crypto.DecryptRSAChunked(body, privateKey); err != nil {
+		t.Errorf("Expected the body to decrypt with the matching key: %v", err)
+	}
+
+	if err := Send(metrics, server.URL, "", retry.NoRetryConfig()); err != nil {
+		t.Fatalf("Send failed: %v", err)
+	}
+	if header != "" {
+		t.Errorf("Expected no %s header on a plain batch, got %q", crypto.EncryptedHeader, header)
+	}
+}
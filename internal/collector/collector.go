@@ -3,11 +3,12 @@ package collector
 import (
 	"context"
 	"crypto/rsa"
+	"errors"
 	"fmt"
 	"log"
 	"math/rand"
-	"os"
 	"runtime"
+	"sync"
 	"sync/atomic"
 	"time"
 
@@ -15,6 +16,7 @@ import (
 	"github.com/shirou/gopsutil/v3/mem"
 
 	"github.com/mutualEvg/metrics-server/internal/batch"
+	"github.com/mutualEvg/metrics-server/internal/hash"
 	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/mutualEvg/metrics-server/internal/worker"
@@ -29,36 +31,109 @@ var runtimeGaugeMetrics = []string{
 	"StackInuse", "StackSys", "Sys", "TotalAlloc",
 }
 
+// minChannelBuffer is the smallest buffer of the runtime and system metric channels
+const minChannelBuffer = 100
+
+// systemBaseMetrics is the number of system metrics reported besides one per CPU
+const systemBaseMetrics = 2 // TotalMemory, FreeMemory
+
+// logicalCPUs returns the number of CPUs reported by per-CPU utilization
+var logicalCPUs = func() int {
+	if n, err := cpu.Counts(true); err == nil && n > 0 {
+		return n
+	}
+	return runtime.NumCPU()
+}
+
+// channelBufferSize returns the buffer size of a channel receiving perPoll metrics
+// every poll: room for two polls, so that one can be queued while the previous one
+// is drained, but at least minChannelBuffer
+func channelBufferSize(perPoll int) int {
+	return max(minChannelBuffer, 2*perPoll)
+}
+
 // Collector handles metric collection and transmission via channels
 type Collector struct {
 	runtimeChan    chan worker.MetricData
 	systemChan     chan worker.MetricData
 	workerPool     *worker.Pool
-	pollInterval   time.Duration
+	runtimePoll    time.Duration // Interval between runtime metric polls
+	systemPoll     time.Duration // Interval between system and source metric polls
 	reportInterval time.Duration
 	batchSize      int
+	maxBatch       int // Most metrics sent in one batch request, larger batches are split (0 for no limit)
 	serverAddr     string
 	key            string
+	hashAlgo       string         // Signing algorithm, "sha256" or "sha512"
 	publicKey      *rsa.PublicKey // Public key for encryption
 	retryConfig    retry.RetryConfig
 	pollCount      *int64
+	commonTS       bool               // Stamp every metric in a report with one collection timestamp
+	deadBand       bool               // Suppress zero counter deltas and unchanged gauges
+	lastGauges     map[string]float64 // Last reported gauge values, guarded by gaugesMu
+	gaugesMu       sync.Mutex         // Guards lastGauges, also updated by worker pool results
+	backoffUntil   atomic.Int64       // Unix nanoseconds until which reporting is paused on server request
+	chanMetrics    bool               // Report channel depth and drop counts as self-metrics
+	runtimeDrops   atomic.Int64       // Runtime metrics dropped because the channel was full
+	systemDrops    atomic.Int64       // System metrics dropped because the channel was full
+	configHash     *float64           // Config fingerprint reported as AgentConfigHash (nil disables)
+	sending        atomic.Bool        // A report is being sent in the background
+	paused         atomic.Bool        // Polls are skipped, see Pause
+	coalesced      atomic.Int64       // Report ticks skipped because the previous send was still in flight
+	idPrefix       string             // Prepended to every metric ID, e.g. "web-01."
+	sourcesMu      sync.Mutex
+	sources        []MetricSource // Additional metric sources polled every system poll interval
+	collectDisk    bool           // Report per-device disk I/O bytes
+	collectNet     bool           // Report per-interface network bytes
+	cpuPrime       bool           // Prime CPU sampling at startup and sample over each system poll interval
+	cpuPercent     func(interval time.Duration, percpu bool) ([]float64, error)
+	cpuLatest      atomic.Pointer[[]float64] // Most recent per-CPU utilization, nil until the first sample
+	retryStats     *retry.Stats              // Send attempt counts reported as self-metrics (nil disables)
+	lastRetry      retry.StatsSnapshot       // Counts at the previous report, owned by the sending goroutine
+	lastErrMu      sync.Mutex
+	lastErr        error          // Most recent send failure, nil after a successful send
+	lastErrAt      time.Time      // Time of the most recent send failure
+	lastErrMetric  bool           // Report the age of the last send failure as AgentLastErrorAge
+	tracer         *worker.Tracer // Logs the journey of a single metric (nil disables)
+
+	// Metrics received since the last report, shared by the forwarding goroutine and Flush
+	bufMu      sync.Mutex
+	runtimeBuf []worker.MetricData
+	systemBuf  []worker.MetricData
 }
 
-// New creates a new metric collector
+// New creates a new metric collector polling runtime and system metrics at the same
+// interval. It is equivalent to NewWithIntervals with pollInterval for both.
 func New(workerPool *worker.Pool, pollInterval, reportInterval time.Duration, batchSize int, serverAddr, key string, retryConfig retry.RetryConfig, pollCount *int64) *Collector {
-	return &Collector{
-		runtimeChan:    make(chan worker.MetricData, 100), // Buffered channel
-		systemChan:     make(chan worker.MetricData, 100), // Buffered channel
+	return NewWithIntervals(workerPool, pollInterval, pollInterval, reportInterval, batchSize, serverAddr, key, retryConfig, pollCount)
+}
+
+// NewWithIntervals creates a new metric collector polling the cheap runtime metrics every
+// runtimePollInterval and the more expensive system metrics, including CPU sampling and
+// registered sources, every systemPollInterval. The channel buffers are sized for the
+// runtime metrics and for the system metrics of the machine's CPU count, so that a poll
+// does not drop metrics on machines with many CPUs; Start grows the system channel for
+// the disk, network and source metrics.
+func NewWithIntervals(workerPool *worker.Pool, runtimePollInterval, systemPollInterval, reportInterval time.Duration, batchSize int, serverAddr, key string, retryConfig retry.RetryConfig, pollCount *int64) *Collector {
+	c := &Collector{
+		runtimeChan:    make(chan worker.MetricData, channelBufferSize(len(runtimeGaugeMetrics)+1)), // +1 for RandomValue
+		systemChan:     make(chan worker.MetricData, channelBufferSize(systemBaseMetrics+logicalCPUs())),
 		workerPool:     workerPool,
-		pollInterval:   pollInterval,
+		runtimePoll:    runtimePollInterval,
+		systemPoll:     systemPollInterval,
 		reportInterval: reportInterval,
 		batchSize:      batchSize,
 		serverAddr:     serverAddr,
 		key:            key,
+		hashAlgo:       hash.AlgoSHA256,
 		publicKey:      nil,
 		retryConfig:    retryConfig,
 		pollCount:      pollCount,
+		cpuPercent:     cpu.Percent,
 	}
+	workerPool.SetBackpressureHandler(c.backOff)
+	workerPool.SetResultHandler(c.handleSendResult)
+	return c
 }
 
 // SetPublicKey sets the public key for encryption
@@ -66,21 +141,143 @@ func (c *Collector) SetPublicKey(publicKey *rsa.PublicKey) {
 	c.publicKey = publicKey
 }
 
-// Start begins metric collection and forwarding
+// SetHashAlgo sets the algorithm used to sign batches, "sha256" (default) or "sha512"
+func (c *Collector) SetHashAlgo(algo string) {
+	c.hashAlgo = algo
+}
+
+// SetCommonTimestamp enables stamping all metrics of a report cycle with a single
+// collection timestamp so the server stores them with identical update times
+func (c *Collector) SetCommonTimestamp(enabled bool) {
+	c.commonTS = enabled
+}
+
+// SetDeadBand enables suppression of counters with a zero delta and gauges
+// whose value is identical to the last reported one
+func (c *Collector) SetDeadBand(enabled bool) {
+	c.deadBand = enabled
+	if enabled && c.lastGauges == nil {
+		c.lastGauges = make(map[string]float64)
+	}
+}
+
+// SetChannelMetrics enables reporting the collection channel depths and drop
+// counts alongside the collected metrics
+func (c *Collector) SetChannelMetrics(enabled bool) {
+	c.chanMetrics = enabled
+}
+
+// SetConfigHash enables reporting the agent's config fingerprint as the
+// AgentConfigHash gauge with every report
+func (c *Collector) SetConfigHash(hash float64) {
+	c.configHash = &hash
+}
+
+// SetInstanceID prefixes every reported metric ID with the instance ID and a dot,
+// e.g. "web-01.Alloc", so that several agents can report to the same server.
+// An empty ID disables the prefix.
+func (c *Collector) SetInstanceID(id string) {
+	c.idPrefix = ""
+	if id != "" {
+		c.idPrefix = id + "."
+	}
+}
+
+// SetCollectDisk enables reporting the DiskReadBytes and DiskWriteBytes gauges per device
+func (c *Collector) SetCollectDisk(enabled bool) {
+	c.collectDisk = enabled
+}
+
+// SetCollectNet enables reporting the NetBytesSent and NetBytesRecv gauges per interface
+func (c *Collector) SetCollectNet(enabled bool) {
+	c.collectNet = enabled
+}
+
+// SetMaxBatchSize splits batches of more than n metrics into several requests of at
+// most n metrics, so that no request exceeds the server's limits. With encryption each
+// request is encrypted on its own. A size of 0 or less disables splitting.
+func (c *Collector) SetMaxBatchSize(n int) {
+	c.maxBatch = n
+}
+
+// SetRetryStats enables reporting the send attempts, retries and failures counted by stats
+// as the SendAttempts, SendRetries and SendFailures counters. stats should be used as the
+// OnAttempt callback of the retry config passed to the worker pool and the collector.
+func (c *Collector) SetRetryStats(stats *retry.Stats) {
+	c.retryStats = stats
+}
+
+// SetTracer sets the tracer logging each stage of a single metric, from collection to
+// acknowledgement. The same tracer is set on the worker pool.
+func (c *Collector) SetTracer(t *worker.Tracer) {
+	c.tracer = t
+	c.workerPool.SetTracer(t)
+}
+
+// SetCPUPriming replaces the one-second CPU samples taken in the background with a
+// non-blocking priming sample at startup. CPU utilization is then measured over each poll
+// interval and first reported on the cycle after startup, so the first report is not
+// based on the meaningless startup sample and no sample is more than one poll old.
+func (c *Collector) SetCPUPriming(enabled bool) {
+	c.cpuPrime = enabled
+}
+
+// Pause stops metric collection until Resume is called, e.g. during a maintenance
+// window, without stopping the collector. Polls are skipped while paused and a poll
+// already in progress collects nothing more once Pause returns. Metrics collected
+// before are still reported, and so are the agent's own metrics.
+func (c *Collector) Pause() {
+	c.paused.Store(true)
+}
+
+// Resume restarts metric collection stopped by Pause with the next poll
+func (c *Collector) Resume() {
+	c.paused.Store(false)
+}
+
+// Paused reports whether metric collection is paused
+func (c *Collector) Paused() bool {
+	return c.paused.Load()
+}
+
+// metricID returns the reported ID of the named metric
+func (c *Collector) metricID(name string) string {
+	return c.idPrefix + name
+}
+
+// SelfCounters returns the collector's internal counters by metric name so their
+// unreported values can be persisted across restarts
+func (c *Collector) SelfCounters() map[string]*atomic.Int64 {
+	return map[string]*atomic.Int64{
+		"RuntimeChanDrops": &c.runtimeDrops,
+		"SystemChanDrops":  &c.systemDrops,
+	}
+}
+
+// Start begins metric collection and forwarding. The system channel is resized first
+// for everything sent through it, since disk and network metrics and sources are
+// configured after New.
 func (c *Collector) Start(ctx context.Context) {
+	if size := channelBufferSize(c.systemMetricsPerPoll()); size > cap(c.systemChan) {
+		c.systemChan = make(chan worker.MetricData, size)
+	}
+
 	// Start runtime metrics collection
 	go c.collectRuntimeMetrics(ctx)
 
 	// Start system metrics collection
 	go c.collectSystemMetrics(ctx)
 
+	// Start registered metric sources collection
+	go c.collectSourceMetrics(ctx)
+
 	// Start metric forwarding to worker pool
 	go c.forwardMetrics(ctx)
 }
 
 // collectRuntimeMetrics collects Go runtime metrics and sends via channel
 func (c *Collector) collectRuntimeMetrics(ctx context.Context) {
-	ticker := time.NewTicker(c.pollInterval)
+	ticker := time.NewTicker(c.runtimePoll)
 	defer ticker.Stop()
 
 	for {
@@ -88,6 +285,10 @@ func (c *Collector) collectRuntimeMetrics(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if c.Paused() {
+				continue
+			}
+
 			var memStats runtime.MemStats
 			runtime.ReadMemStats(&memStats)
 
@@ -151,49 +352,72 @@ func (c *Collector) collectRuntimeMetrics(ctx context.Context) {
 					value = float64(memStats.TotalAlloc)
 				}
 
-				select {
-				case c.runtimeChan <- worker.MetricData{
+				if !c.enqueue(ctx, c.runtimeChan, &c.runtimeDrops, worker.MetricData{
 					Metric: models.Metrics{
-						ID:    metric,
+						ID:    c.metricID(metric),
 						MType: "gauge",
 						Value: &value,
 					},
 					Type: "runtime",
-				}:
-				case <-ctx.Done():
+				}) {
 					return
-				default:
-					// Channel full, skip this metric
-					log.Printf("Runtime channel full, dropping metric: %s", metric)
 				}
 			}
 
 			// Send random metric
 			randomValue := rand.Float64()
-			select {
-			case c.runtimeChan <- worker.MetricData{
+			if !c.enqueue(ctx, c.runtimeChan, &c.runtimeDrops, worker.MetricData{
 				Metric: models.Metrics{
-					ID:    "RandomValue",
+					ID:    c.metricID("RandomValue"),
 					MType: "gauge",
 					Value: &randomValue,
 				},
 				Type: "runtime",
-			}:
-			case <-ctx.Done():
+			}) {
 				return
-			default:
-				log.Printf("Runtime channel full, dropping RandomValue metric")
 			}
 
-		// Increment poll count
-		atomic.AddInt64(c.pollCount, 1)
+			// Increment poll count
+			atomic.AddInt64(c.pollCount, 1)
+		}
+	}
+}
+
+// sampleCPU keeps cpuLatest updated with the per-CPU utilization until ctx is done.
+// Each sample covers one second, unless primed: then each sample covers the time
+// since the previous one and the startup sample is discarded. Samples are taken at
+// most once per system poll interval.
+func (c *Collector) sampleCPU(ctx context.Context) {
+	cpuInterval := time.Second
+	if c.cpuPrime {
+		cpuInterval = 0
+		c.cpuPercent(0, true)
+	}
+
+	for {
+		start := time.Now()
+		// Nothing is reported while paused, so sampling waits as well
+		if !c.Paused() {
+			if percents, err := c.cpuPercent(cpuInterval, true); err == nil {
+				c.cpuLatest.Store(&percents)
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(c.systemPoll - time.Since(start)):
 		}
 	}
 }
 
-// collectSystemMetrics collects system metrics using gopsutil and sends via channel
+// collectSystemMetrics collects system metrics using gopsutil and sends via channel.
+// CPU utilization is sampled in the background, so a poll reports the latest sample
+// without waiting for it.
 func (c *Collector) collectSystemMetrics(ctx context.Context) {
-	ticker := time.NewTicker(c.pollInterval)
+	go c.sampleCPU(ctx)
+
+	ticker := time.NewTicker(c.systemPoll)
 	defer ticker.Stop()
 
 	for {
@@ -201,137 +425,412 @@ func (c *Collector) collectSystemMetrics(ctx context.Context) {
 		case <-ctx.Done():
 			return
 		case <-ticker.C:
+			if c.Paused() {
+				continue
+			}
+
 			// Collect memory metrics
 			if memInfo, err := mem.VirtualMemory(); err == nil {
 				totalMem := float64(memInfo.Total)
 				freeMem := float64(memInfo.Free)
 
-				select {
-				case c.systemChan <- worker.MetricData{
+				if !c.enqueue(ctx, c.systemChan, &c.systemDrops, worker.MetricData{
 					Metric: models.Metrics{
-						ID:    "TotalMemory",
+						ID:    c.metricID("TotalMemory"),
 						MType: "gauge",
 						Value: &totalMem,
 					},
 					Type: "system",
-				}:
-				case <-ctx.Done():
+				}) {
 					return
-				default:
-					log.Printf("System channel full, dropping TotalMemory metric")
 				}
 
-				select {
-				case c.systemChan <- worker.MetricData{
+				if !c.enqueue(ctx, c.systemChan, &c.systemDrops, worker.MetricData{
 					Metric: models.Metrics{
-						ID:    "FreeMemory",
+						ID:    c.metricID("FreeMemory"),
 						MType: "gauge",
 						Value: &freeMem,
 					},
 					Type: "system",
-				}:
-				case <-ctx.Done():
+				}) {
 					return
-				default:
-					log.Printf("System channel full, dropping FreeMemory metric")
 				}
 			}
 
-			// Collect CPU utilization for each CPU
-			if cpuPercents, err := cpu.Percent(time.Second, true); err == nil {
-				for i, percent := range cpuPercents {
+			// Report the latest CPU utilization for each CPU
+			if cpuPercents := c.cpuLatest.Load(); cpuPercents != nil {
+				for i, percent := range *cpuPercents {
 					metricName := fmt.Sprintf("CPUutilization%d", i+1)
 					cpuValue := percent
 
-					select {
-					case c.systemChan <- worker.MetricData{
+					if !c.enqueue(ctx, c.systemChan, &c.systemDrops, worker.MetricData{
 						Metric: models.Metrics{
-							ID:    metricName,
+							ID:    c.metricID(metricName),
 							MType: "gauge",
 							Value: &cpuValue,
 						},
 						Type: "system",
-					}:
-					case <-ctx.Done():
+					}) {
 						return
-					default:
-						log.Printf("System channel full, dropping %s metric", metricName)
 					}
 				}
 			}
+
+			// Collect disk and network counters if enabled
+			var ioMetrics []models.Metrics
+			if c.collectDisk {
+				ioMetrics = append(ioMetrics, DiskMetrics()...)
+			}
+			if c.collectNet {
+				ioMetrics = append(ioMetrics, NetMetrics()...)
+			}
+			for _, metric := range ioMetrics {
+				metric.ID = c.metricID(metric.ID)
+				if !c.enqueue(ctx, c.systemChan, &c.systemDrops, worker.MetricData{
+					Metric: metric,
+					Type:   "system",
+				}) {
+					return
+				}
+			}
 		}
 	}
 }
 
+// systemMetricsPerPoll returns the number of metrics sent through the system channel
+// every system poll: memory and per-CPU metrics, the enabled disk and network metrics
+// and the metrics of the registered sources
+func (c *Collector) systemMetricsPerPoll() int {
+	n := systemBaseMetrics + logicalCPUs()
+	if c.collectDisk {
+		n += len(DiskMetrics())
+	}
+	if c.collectNet {
+		n += len(NetMetrics())
+	}
+	for _, source := range c.registeredSources() {
+		n += sourceMetricsPerPoll(source)
+	}
+	return n
+}
+
+// enqueue sends a metric to the channel without blocking. If the channel is full
+// the metric is dropped and counted; while paused it is discarded. It returns false
+// once ctx is cancelled.
+func (c *Collector) enqueue(ctx context.Context, ch chan worker.MetricData, drops *atomic.Int64, metric worker.MetricData) bool {
+	// A poll that was in progress when the collector was paused is cut short
+	if c.Paused() {
+		return true
+	}
+
+	select {
+	case ch <- metric:
+		c.tracer.Trace(metric.Metric, "collected", nil)
+	case <-ctx.Done():
+		return false
+	default:
+		drops.Add(1)
+		log.Printf("%s channel full, dropping metric: %s", metric.Type, metric.Metric.ID)
+		c.tracer.Trace(metric.Metric, "dropped, "+metric.Type+" channel full", nil)
+	}
+	return true
+}
+
+// selfMetrics returns the enabled metrics about the agent itself
+func (c *Collector) selfMetrics() []models.Metrics {
+	var metrics []models.Metrics
+	if c.chanMetrics {
+		metrics = append(metrics, c.channelMetrics()...)
+	}
+	if c.configHash != nil {
+		hash := *c.configHash
+		metrics = append(metrics, models.Metrics{ID: c.metricID("AgentConfigHash"), MType: "gauge", Value: &hash})
+	}
+	if c.retryStats != nil {
+		metrics = append(metrics, c.retryMetrics()...)
+	}
+	if c.lastErrMetric {
+		metrics = append(metrics, c.lastErrorMetric())
+	}
+	return metrics
+}
+
+// retryMetrics reports the send attempts, retries and failures since the previous report as counters
+func (c *Collector) retryMetrics() []models.Metrics {
+	current := c.retryStats.Snapshot()
+	attempts := current.Attempts - c.lastRetry.Attempts
+	retries := current.Retries - c.lastRetry.Retries
+	failures := current.Failures - c.lastRetry.Failures
+	c.lastRetry = current
+
+	return []models.Metrics{
+		{ID: c.metricID("SendAttempts"), MType: "counter", Delta: &attempts},
+		{ID: c.metricID("SendRetries"), MType: "counter", Delta: &retries},
+		{ID: c.metricID("SendFailures"), MType: "counter", Delta: &failures},
+	}
+}
+
+// channelMetrics reports the depth of the collection channels as gauges and the
+// number of metrics dropped since the previous report as counters
+func (c *Collector) channelMetrics() []models.Metrics {
+	runtimeDepth := float64(len(c.runtimeChan))
+	systemDepth := float64(len(c.systemChan))
+	runtimeDrops := c.runtimeDrops.Swap(0)
+	systemDrops := c.systemDrops.Swap(0)
+
+	return []models.Metrics{
+		{ID: c.metricID("RuntimeChanDepth"), MType: "gauge", Value: &runtimeDepth},
+		{ID: c.metricID("SystemChanDepth"), MType: "gauge", Value: &systemDepth},
+		{ID: c.metricID("RuntimeChanDrops"), MType: "counter", Delta: &runtimeDrops},
+		{ID: c.metricID("SystemChanDrops"), MType: "counter", Delta: &systemDrops},
+	}
+}
+
 // forwardMetrics reads from channels and forwards to worker pool or batch
 func (c *Collector) forwardMetrics(ctx context.Context) {
 	ticker := time.NewTicker(c.reportInterval)
 	defer ticker.Stop()
 
-	var runtimeMetrics []worker.MetricData
-	var systemMetrics []worker.MetricData
-
 	for {
 		select {
 		case <-ctx.Done():
-			// Only send final metrics if not in test mode (when worker pool might be stopping)
-			if os.Getenv("TEST_MODE") != "true" {
-				c.sendCollectedMetrics(runtimeMetrics, systemMetrics)
-			}
+			// Metrics still buffered are sent by Flush
 			return
 
 		case metric := <-c.runtimeChan:
-			runtimeMetrics = append(runtimeMetrics, metric)
+			c.buffer(&c.runtimeBuf, metric)
 
 		case metric := <-c.systemChan:
-			systemMetrics = append(systemMetrics, metric)
+			c.buffer(&c.systemBuf, metric)
 
 		case <-ticker.C:
-			// Send collected metrics
-			c.sendCollectedMetrics(runtimeMetrics, systemMetrics)
+			// Send collected metrics unless the server asked us to back off or the
+			// previous report is still being sent, in which case this tick is coalesced
+			// into the next one: the metrics stay buffered and go out with the next report.
+			if c.backingOff() {
+				log.Printf("Server requested backoff, skipping report")
+			} else if !c.startSend() {
+				c.coalesced.Add(1)
+				log.Printf("Previous report still in flight, coalescing report tick")
+			}
+		}
+	}
+}
+
+// buffer adds a received metric to buf, one of the buffers of the next report
+func (c *Collector) buffer(buf *[]worker.MetricData, metric worker.MetricData) {
+	c.bufMu.Lock()
+	*buf = append(*buf, metric)
+	c.bufMu.Unlock()
+	c.tracer.Trace(metric.Metric, "buffered", nil)
+}
+
+// takeBuffered returns the buffered metrics and empties the buffers
+func (c *Collector) takeBuffered() (runtimeMetrics, systemMetrics []worker.MetricData) {
+	c.bufMu.Lock()
+	defer c.bufMu.Unlock()
+	runtimeMetrics, systemMetrics = c.runtimeBuf, c.systemBuf
+	c.runtimeBuf, c.systemBuf = nil, nil
+	return runtimeMetrics, systemMetrics
+}
+
+// Flush drains the collection channels and sends every buffered metric, waiting for
+// an in-flight report first. It blocks until the metrics are sent or ctx is done and
+// is meant for shutdown, after the context passed to Start is cancelled. Without
+// batching the metrics are handed to the worker pool, whose Stop waits for them.
+func (c *Collector) Flush(ctx context.Context) error {
+	// Take over the single sending slot so reports are sent in order
+	for !c.sending.CompareAndSwap(false, true) {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(10 * time.Millisecond):
+		}
+	}
 
-			// Clear collected metrics
-			runtimeMetrics = runtimeMetrics[:0]
-			systemMetrics = systemMetrics[:0]
+	for drained := false; !drained; {
+		select {
+		case metric := <-c.runtimeChan:
+			c.buffer(&c.runtimeBuf, metric)
+		case metric := <-c.systemChan:
+			c.buffer(&c.systemBuf, metric)
+		default:
+			drained = true
 		}
 	}
+	runtimeMetrics, systemMetrics := c.takeBuffered()
+
+	done := make(chan error, 1)
+	go func() {
+		defer c.sending.Store(false)
+		done <- c.sendCollectedMetrics(runtimeMetrics, systemMetrics)
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// startSend sends the buffered metrics in the background unless a previous send
+// is still in flight, in which case they are left buffered. It reports whether the
+// send was started.
+func (c *Collector) startSend() bool {
+	if !c.sending.CompareAndSwap(false, true) {
+		return false
+	}
+	runtimeMetrics, systemMetrics := c.takeBuffered()
+
+	go func() {
+		defer c.sending.Store(false)
+		c.sendCollectedMetrics(runtimeMetrics, systemMetrics)
+	}()
+	return true
 }
 
-// sendCollectedMetrics sends the collected metrics via worker pool or batch
-func (c *Collector) sendCollectedMetrics(runtimeMetrics, systemMetrics []worker.MetricData) {
+// sendCollectedMetrics sends the collected metrics via worker pool or batch. It returns
+// the error of a failed batch send; individual sends are reported by the worker pool.
+func (c *Collector) sendCollectedMetrics(runtimeMetrics, systemMetrics []worker.MetricData) error {
 	if c.batchSize > 0 {
-		c.sendMetricsBatch(runtimeMetrics, systemMetrics)
-	} else {
-		c.sendMetricsIndividual(runtimeMetrics, systemMetrics)
+		return c.sendMetricsBatch(runtimeMetrics, systemMetrics)
 	}
+	c.sendMetricsIndividual(runtimeMetrics, systemMetrics)
+	return nil
 }
 
 // sendMetricsIndividual sends each metric individually using the worker pool
 func (c *Collector) sendMetricsIndividual(runtimeMetrics, systemMetrics []worker.MetricData) {
 	// Send runtime metrics
 	for _, metric := range runtimeMetrics {
-		c.workerPool.SubmitMetric(metric)
+		if !c.suppress(metric.Metric) {
+			c.workerPool.SubmitMetric(metric)
+		}
 	}
 
 	// Send system metrics
 	for _, metric := range systemMetrics {
-		c.workerPool.SubmitMetric(metric)
+		if !c.suppress(metric.Metric) {
+			c.workerPool.SubmitMetric(metric)
+		}
 	}
 
 	// Send counter metric
 	counter := worker.MetricData{
 		Metric: models.Metrics{
-			ID:    "PollCount",
+			ID:    c.metricID("PollCount"),
 			MType: "counter",
 			Delta: c.pollCount,
 		},
 		Type: "runtime",
 	}
-	c.workerPool.SubmitMetric(counter)
+	if !c.suppress(counter.Metric) {
+		c.workerPool.SubmitMetric(counter)
+	}
+
+	// Send collector self-metrics
+	for _, metric := range c.selfMetrics() {
+		if !c.suppress(metric) {
+			c.workerPool.SubmitMetric(worker.MetricData{Metric: metric, Type: "self"})
+		}
+	}
 }
 
-// sendMetricsBatch sends metrics in batches
-func (c *Collector) sendMetricsBatch(runtimeMetrics, systemMetrics []worker.MetricData) {
+// sendMetricsBatch sends metrics in batches, returning the error of a failed send
+func (c *Collector) sendMetricsBatch(runtimeMetrics, systemMetrics []worker.MetricData) error {
+	metrics := c.buildBatch(runtimeMetrics, systemMetrics)
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	// Send oversized batches in chunks of at most maxBatch metrics
+	chunkSize := len(metrics)
+	if c.maxBatch > 0 && c.maxBatch < chunkSize {
+		chunkSize = c.maxBatch
+	}
+
+	var errs []error
+	for start := 0; start < len(metrics); start += chunkSize {
+		err := c.sendBatchChunk(metrics[start:min(start+chunkSize, len(metrics))])
+		if err == nil {
+			continue
+		}
+		errs = append(errs, err)
+
+		var bpErr *batch.BackpressureError
+		if errors.As(err, &bpErr) {
+			// The remaining chunks would be refused as well
+			break
+		}
+	}
+
+	err := errors.Join(errs...)
+	c.recordSendResult(err)
+	return err
+}
+
+// sendBatchChunk sends metrics as one batch request, falling back to individual
+// sends through the worker pool if the batch fails
+func (c *Collector) sendBatchChunk(metrics []models.Metrics) error {
+	c.traceAll(metrics, "sent in batch", nil)
+	err := batch.SendWithHashAlgo(metrics, c.serverAddr, c.key, c.hashAlgo, c.publicKey, c.retryConfig)
+	if err != nil {
+		c.traceAll(metrics, "batch failed", err)
+	} else {
+		c.traceAll(metrics, "acked", nil)
+	}
+	if err != nil {
+		// The gauges were not delivered, so the next cycle must not suppress them
+		c.forgetGauges(metrics)
+	}
+	var bpErr *batch.BackpressureError
+	if errors.As(err, &bpErr) {
+		// Server is overloaded: do not retry individually, pause reporting instead
+		c.backOff(bpErr.RetryAfter)
+	} else if err != nil {
+		log.Printf("Failed to send batch: %v", err)
+		// Fallback to individual sending via worker pool
+		for _, metric := range metrics {
+			var metricData worker.MetricData
+			if metric.Value != nil {
+				metricData = worker.MetricData{
+					Metric: metric,
+					Type:   "batch_fallback",
+				}
+			} else if metric.Delta != nil {
+				metricData = worker.MetricData{
+					Metric: metric,
+					Type:   "batch_fallback",
+				}
+			}
+			c.workerPool.SubmitMetric(metricData)
+		}
+	} else {
+		log.Printf("Successfully sent batch of %d metrics", len(metrics))
+	}
+	return err
+}
+
+// backOff pauses reporting for at least d, extending any pause already in effect
+func (c *Collector) backOff(d time.Duration) {
+	until := time.Now().Add(d).UnixNano()
+	for {
+		current := c.backoffUntil.Load()
+		if current >= until || c.backoffUntil.CompareAndSwap(current, until) {
+			break
+		}
+	}
+	log.Printf("Server signalled backpressure, pausing reports for %v", d)
+}
+
+// backingOff reports whether reporting is currently paused
+func (c *Collector) backingOff() bool {
+	return time.Now().UnixNano() < c.backoffUntil.Load()
+}
+
+// buildBatch assembles the metrics of one report cycle into a batch
+func (c *Collector) buildBatch(runtimeMetrics, systemMetrics []worker.MetricData) []models.Metrics {
 	batchInstance := batch.New()
 
 	// Add runtime metrics to batch
@@ -341,40 +840,127 @@ func (c *Collector) sendMetricsBatch(runtimeMetrics, systemMetrics []worker.Metr
 		}
 	}
 
-	// Add system metrics to batch
+	// Add system metrics to batch, including counters from registered sources
 	for _, metricData := range systemMetrics {
 		if metricData.Metric.Value != nil {
 			batchInstance.AddGauge(metricData.Metric.ID, *metricData.Metric.Value)
+		} else if metricData.Metric.Delta != nil {
+			batchInstance.AddCounter(metricData.Metric.ID, *metricData.Metric.Delta)
 		}
 	}
 
 	// Add counter metric
-	batchInstance.AddCounter("PollCount", *c.pollCount)
+	batchInstance.AddCounter(c.metricID("PollCount"), *c.pollCount)
 
-	// Get all metrics and send as batch
 	metrics := batchInstance.GetAndClear()
-	if len(metrics) > 0 {
-		if err := batch.SendWithEncryption(metrics, c.serverAddr, c.key, c.publicKey, c.retryConfig); err != nil {
-			log.Printf("Failed to send batch: %v", err)
-			// Fallback to individual sending via worker pool
-			for _, metric := range metrics {
-				var metricData worker.MetricData
-				if metric.Value != nil {
-					metricData = worker.MetricData{
-						Metric: metric,
-						Type:   "batch_fallback",
-					}
-				} else if metric.Delta != nil {
-					metricData = worker.MetricData{
-						Metric: metric,
-						Type:   "batch_fallback",
-					}
-				}
-				c.workerPool.SubmitMetric(metricData)
+
+	// Add collector self-metrics
+	metrics = append(metrics, c.selfMetrics()...)
+
+	// Collapse metrics polled several times since the last report
+	metrics = aggregate(metrics)
+
+	// Drop idle metrics if the dead-band filter is enabled
+	if c.deadBand {
+		filtered := metrics[:0]
+		for _, m := range metrics {
+			if !c.suppress(m) {
+				filtered = append(filtered, m)
 			}
-		} else {
-			log.Printf("Successfully sent batch of %d metrics", len(metrics))
 		}
+		metrics = filtered
+	}
+
+	// Stamp every metric with the same collection instant if configured
+	if c.commonTS && len(metrics) > 0 {
+		ts := time.Now().UnixMilli()
+		for i := range metrics {
+			metrics[i].Timestamp = &ts
+		}
+	}
+
+	return metrics
+}
+
+// aggregate collapses metrics with the same ID and type into one, in the order of
+// their first occurrence: a gauge keeps its last value and a counter the sum of its
+// deltas
+func aggregate(metrics []models.Metrics) []models.Metrics {
+	type key struct{ id, mtype string }
+	index := make(map[key]int, len(metrics))
+	result := metrics[:0]
+	for _, m := range metrics {
+		k := key{m.ID, m.MType}
+		i, seen := index[k]
+		if !seen {
+			index[k] = len(result)
+			result = append(result, m)
+			continue
+		}
+		switch {
+		case m.Value != nil:
+			result[i].Value = m.Value
+		case m.Delta != nil && result[i].Delta != nil:
+			sum := *result[i].Delta + *m.Delta
+			result[i].Delta = &sum
+		}
+	}
+	return result
+}
+
+// suppress reports whether the dead-band filter drops the metric.
+// Gauges that are reported are remembered for comparison with the next cycle
+// until forgetGauges drops them after a failed send.
+// Counters with a nonzero delta are always sent.
+func (c *Collector) suppress(m models.Metrics) bool {
+	if !c.deadBand {
+		return false
+	}
+
+	switch {
+	case m.Delta != nil:
+		if *m.Delta == 0 {
+			c.tracer.Trace(m, "suppressed by dead band", nil)
+			return true
+		}
+	case m.Value != nil:
+		c.gaugesMu.Lock()
+		defer c.gaugesMu.Unlock()
+		if last, ok := c.lastGauges[m.ID]; ok && last == *m.Value {
+			c.tracer.Trace(m, "suppressed by dead band", nil)
+			return true
+		}
+		c.lastGauges[m.ID] = *m.Value
+	}
+	return false
+}
+
+// forgetGauges drops the remembered values of gauges that failed to send, so the
+// dead-band filter sends them again even if they have not changed
+func (c *Collector) forgetGauges(metrics []models.Metrics) {
+	if !c.deadBand {
+		return
+	}
+
+	c.gaugesMu.Lock()
+	defer c.gaugesMu.Unlock()
+	for _, m := range metrics {
+		if m.Value == nil {
+			continue
+		}
+		if last, ok := c.lastGauges[m.ID]; ok && last == *m.Value {
+			delete(c.lastGauges, m.ID)
+		}
+	}
+}
+
+// traceAll traces stage for the traced metric, if it is among metrics
+func (c *Collector) traceAll(metrics []models.Metrics, stage string, err error) {
+	if c.tracer == nil {
+		return
+	}
+	for _, m := range metrics {
+		c.tracer.Trace(m, stage, err)
 	}
 }
 
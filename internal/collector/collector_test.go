@@ -1,11 +1,24 @@
 package collector
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"sync"
 	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/mutualEvg/metrics-server/internal/worker"
 )
@@ -21,8 +34,8 @@ func TestNew(t *testing.T) {
 	var pollCount int64 = 0
 	collector := New(workerPool, 2*time.Second, 10*time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
 
-	if collector.pollInterval != 2*time.Second {
-		t.Errorf("Expected pollInterval 2s, got %v", collector.pollInterval)
+	if collector.runtimePoll != 2*time.Second || collector.systemPoll != 2*time.Second {
+		t.Errorf("Expected both poll intervals 2s, got %v and %v", collector.runtimePoll, collector.systemPoll)
 	}
 
 	if collector.reportInterval != 10*time.Second {
@@ -229,3 +242,1108 @@ func TestCollectorBatchMode(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildBatchCommonTimestamp(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 3
+	collector := New(workerPool, time.Second, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetCommonTimestamp(true)
+
+	v1, v2 := 1.5, 2.5
+	runtimeMetrics := []worker.MetricData{
+		{Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &v1}, Type: "runtime"},
+	}
+	systemMetrics := []worker.MetricData{
+		{Metric: models.Metrics{ID: "TotalMemory", MType: "gauge", Value: &v2}, Type: "system"},
+	}
+
+	metrics := collector.buildBatch(runtimeMetrics, systemMetrics)
+	if len(metrics) != 3 {
+		t.Fatalf("Expected 3 metrics, got %d", len(metrics))
+	}
+
+	first := metrics[0].Timestamp
+	if first == nil {
+		t.Fatal("Expected timestamp to be set")
+	}
+	for _, m := range metrics {
+		if m.Timestamp == nil || *m.Timestamp != *first {
+			t.Errorf("Metric %s does not share the cycle timestamp", m.ID)
+		}
+	}
+
+	// Without the option no timestamp is attached
+	collector.SetCommonTimestamp(false)
+	for _, m := range collector.buildBatch(runtimeMetrics, systemMetrics) {
+		if m.Timestamp != nil {
+			t.Errorf("Expected no timestamp on %s when option is disabled", m.ID)
+		}
+	}
+}
+
+func TestDeadBandSuppressesIdleMetrics(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Second, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetDeadBand(true)
+
+	value := 42.0
+	gauges := []worker.MetricData{
+		{Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, Type: "runtime"},
+	}
+
+	// First report: zero-delta PollCount is suppressed, new gauge is sent
+	metrics := collector.buildBatch(gauges, nil)
+	if len(metrics) != 1 || metrics[0].ID != "Alloc" {
+		t.Fatalf("Expected only Alloc in first report, got %+v", metrics)
+	}
+
+	// Second report: gauge unchanged and counter still zero, nothing is sent
+	if metrics := collector.buildBatch(gauges, nil); len(metrics) != 0 {
+		t.Errorf("Expected idle report to be empty, got %d metrics", len(metrics))
+	}
+
+	// Nonzero counter deltas are always sent
+	atomic.StoreInt64(&pollCount, 5)
+	metrics = collector.buildBatch(gauges, nil)
+	if len(metrics) != 1 || metrics[0].ID != "PollCount" {
+		t.Errorf("Expected only PollCount after activity, got %+v", metrics)
+	}
+
+	zero := int64(0)
+	if !collector.suppress(models.Metrics{ID: "Idle", MType: "counter", Delta: &zero}) {
+		t.Error("Expected zero-delta counter to be suppressed")
+	}
+}
+
+func TestDeadBandResendsGaugesAfterFailedSend(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Second, time.Second, 10, server.URL, "", retryConfig, &pollCount)
+	collector.SetDeadBand(true)
+
+	value := 42.0
+	gauges := []worker.MetricData{
+		{Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, Type: "runtime"},
+	}
+
+	if err := collector.sendMetricsBatch(gauges, nil); err == nil {
+		t.Fatal("Expected the batch to fail")
+	}
+
+	// The unchanged gauge was never delivered, so it is not suppressed
+	if metrics := collector.buildBatch(gauges, nil); len(metrics) != 1 || metrics[0].ID != "Alloc" {
+		t.Errorf("Expected Alloc to be sent again, got %+v", metrics)
+	}
+
+	// A failed individual send is forgotten as well
+	collector.handleSendResult(models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, errors.New("refused"))
+	if metrics := collector.buildBatch(gauges, nil); len(metrics) != 1 {
+		t.Errorf("Expected Alloc to be sent again after a failed individual send, got %+v", metrics)
+	}
+}
+
+func TestDeadBandDisabledByDefault(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Second, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	zero := int64(0)
+	if collector.suppress(models.Metrics{ID: "Idle", MType: "counter", Delta: &zero}) {
+		t.Error("Expected no suppression when dead-band is off")
+	}
+	if metrics := collector.buildBatch(nil, nil); len(metrics) != 1 {
+		t.Errorf("Expected PollCount to be sent, got %d metrics", len(metrics))
+	}
+}
+
+func TestBatchBackpressurePausesReporting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Second, time.Second, 10, server.URL, "", retryConfig, &pollCount)
+
+	if collector.backingOff() {
+		t.Fatal("Collector should not back off before any response")
+	}
+
+	collector.sendMetricsBatch(nil, nil)
+
+	if !collector.backingOff() {
+		t.Error("Expected collector to back off after 429 response")
+	}
+}
+
+func TestIndividualBackpressurePausesReporting(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "30")
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+	workerPool.Start()
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Second, time.Second, 0, server.URL, "", retryConfig, &pollCount)
+
+	collector.sendMetricsIndividual(nil, nil)
+	workerPool.Stop()
+
+	if !collector.backingOff() {
+		t.Error("Expected collector to back off after 429 response")
+	}
+}
+
+func TestChannelDropsCounted(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Second, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetChannelMetrics(true)
+
+	ctx := context.Background()
+	value := 1.0
+	metric := worker.MetricData{Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, Type: "runtime"}
+
+	// Force the runtime channel full
+	for i := 0; i < cap(collector.runtimeChan); i++ {
+		collector.enqueue(ctx, collector.runtimeChan, &collector.runtimeDrops, metric)
+	}
+	if collector.runtimeDrops.Load() != 0 {
+		t.Fatalf("Expected no drops while filling, got %d", collector.runtimeDrops.Load())
+	}
+
+	collector.enqueue(ctx, collector.runtimeChan, &collector.runtimeDrops, metric)
+	collector.enqueue(ctx, collector.runtimeChan, &collector.runtimeDrops, metric)
+	if collector.runtimeDrops.Load() != 2 {
+		t.Errorf("Expected 2 drops, got %d", collector.runtimeDrops.Load())
+	}
+
+	self := make(map[string]models.Metrics)
+	for _, m := range collector.buildBatch(nil, nil) {
+		self[m.ID] = m
+	}
+	if m, ok := self["RuntimeChanDrops"]; !ok || *m.Delta != 2 {
+		t.Errorf("Expected RuntimeChanDrops delta 2, got %+v", m)
+	}
+	if m, ok := self["RuntimeChanDepth"]; !ok || *m.Value != float64(cap(collector.runtimeChan)) {
+		t.Errorf("Expected RuntimeChanDepth %d, got %+v", cap(collector.runtimeChan), m)
+	}
+
+	// Drops are reported as deltas and reset after each report
+	if collector.runtimeDrops.Load() != 0 {
+		t.Errorf("Expected drop counter to reset after report, got %d", collector.runtimeDrops.Load())
+	}
+}
+
+func TestSlowSendCoalescesReportTicks(t *testing.T) {
+	var inFlight, maxInFlight, requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := inFlight.Add(1)
+		defer inFlight.Add(-1)
+		for {
+			m := maxInFlight.Load()
+			if n <= m || maxInFlight.CompareAndSwap(m, n) {
+				break
+			}
+		}
+		requests.Add(1)
+
+		// Sending takes much longer than the report interval
+		time.Sleep(200 * time.Millisecond)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Hour, 20*time.Millisecond, 10, server.URL, "", retryConfig, &pollCount)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.forwardMetrics(ctx)
+		close(done)
+	}()
+
+	time.Sleep(300 * time.Millisecond)
+	requestsBeforeStop := requests.Load()
+	cancel()
+	<-done
+
+	if got := maxInFlight.Load(); got != 1 {
+		t.Errorf("Expected at most one report in flight, got %d", got)
+	}
+	if collector.coalesced.Load() == 0 {
+		t.Error("Expected report ticks to be coalesced while a send was in flight")
+	}
+	// 15 ticks fired, but only the first send and possibly a second one fit in the window
+	if got := requestsBeforeStop; got > 3 {
+		t.Errorf("Expected report ticks not to pile up, got %d requests", got)
+	}
+}
+
+func TestSkippedReportsKeepBufferedMetrics(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Hour, 10*time.Millisecond, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	value := 1.5
+	collector.buffer(&collector.runtimeBuf, worker.MetricData{
+		Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value},
+		Type:   "runtime",
+	})
+
+	// Ticks skipped while backing off leave the metrics buffered
+	collector.backOff(time.Hour)
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		collector.forwardMetrics(ctx)
+		close(done)
+	}()
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	<-done
+
+	// So do ticks coalesced while a send is in flight
+	collector.sending.Store(true)
+	if collector.startSend() {
+		t.Fatal("Expected no send to start while another is in flight")
+	}
+
+	runtimeMetrics, _ := collector.takeBuffered()
+	if len(runtimeMetrics) != 1 || runtimeMetrics[0].Metric.ID != "Alloc" {
+		t.Errorf("Expected the skipped metric to be carried over, got %+v", runtimeMetrics)
+	}
+}
+
+func TestInstanceIDPrefixesMetricIDs(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, 10*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	// No prefix by default
+	if id := collector.metricID("Alloc"); id != "Alloc" {
+		t.Errorf("Expected no prefix by default, got %s", id)
+	}
+
+	collector.SetInstanceID("web-01")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.collectRuntimeMetrics(ctx)
+
+	select {
+	case metric := <-collector.runtimeChan:
+		if !strings.HasPrefix(metric.Metric.ID, "web-01.") {
+			t.Errorf("Expected collected metric ID with prefix web-01., got %s", metric.Metric.ID)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Timed out waiting for a runtime metric")
+	}
+
+	ids := make(map[string]bool)
+	for _, m := range collector.buildBatch(nil, nil) {
+		ids[m.ID] = true
+	}
+	if !ids["web-01.PollCount"] || ids["PollCount"] {
+		t.Errorf("Expected PollCount to be prefixed, got %v", ids)
+	}
+
+	collector.SetInstanceID("")
+	if id := collector.metricID("Alloc"); id != "Alloc" {
+		t.Errorf("Expected empty instance ID to disable the prefix, got %s", id)
+	}
+}
+
+// fakeSource reports a fixed gauge and counter
+type fakeSource struct {
+	calls atomic.Int64
+}
+
+func (s *fakeSource) Collect() []models.Metrics {
+	s.calls.Add(1)
+	value, delta := 42.0, int64(7)
+	return []models.Metrics{
+		{ID: "QueueDepth", MType: "gauge", Value: &value},
+		{ID: "JobsDone", MType: "counter", Delta: &delta},
+	}
+}
+
+func TestRegisteredSourceMetrics(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, 10*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetInstanceID("web-01")
+
+	source := &fakeSource{}
+	collector.RegisterSource(source)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.collectSourceMetrics(ctx)
+
+	var collected []worker.MetricData
+	for len(collected) < 2 {
+		select {
+		case metric := <-collector.systemChan:
+			collected = append(collected, metric)
+		case <-time.After(time.Second):
+			t.Fatalf("Timed out waiting for source metrics, got %d", len(collected))
+		}
+	}
+
+	ids := make(map[string]bool)
+	for _, m := range collected {
+		if m.Type != "source" {
+			t.Errorf("Expected type source, got %s", m.Type)
+		}
+		ids[m.Metric.ID] = true
+	}
+	if !ids["web-01.QueueDepth"] || !ids["web-01.JobsDone"] {
+		t.Errorf("Expected prefixed source metric IDs, got %v", ids)
+	}
+
+	// Counters from sources must survive batching
+	batchIDs := make(map[string]models.Metrics)
+	for _, m := range collector.buildBatch(nil, collected) {
+		batchIDs[m.ID] = m
+	}
+	if m, ok := batchIDs["web-01.JobsDone"]; !ok || m.Delta == nil || *m.Delta != 7 {
+		t.Errorf("Expected counter JobsDone with delta 7 in batch, got %+v", m)
+	}
+	if _, ok := batchIDs["web-01.QueueDepth"]; !ok {
+		t.Error("Expected gauge QueueDepth in batch")
+	}
+}
+
+func TestCollectorDiskAndNetMetrics(t *testing.T) {
+	if len(DiskMetrics()) == 0 || len(NetMetrics()) == 0 {
+		t.Skip("Disk or network counters are not available on this system")
+	}
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 10*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetInstanceID("web-01")
+	collector.SetCollectDisk(true)
+	collector.SetCollectNet(true)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.collectSystemMetrics(ctx)
+
+	seen := make(map[string]bool)
+	timeout := time.After(5 * time.Second)
+	for !(seen["DiskReadBytes_"] && seen["DiskWriteBytes_"] && seen["NetBytesSent_"] && seen["NetBytesRecv_"]) {
+		select {
+		case metric := <-collector.systemChan:
+			for _, prefix := range []string{"DiskReadBytes_", "DiskWriteBytes_", "NetBytesSent_", "NetBytesRecv_"} {
+				if strings.HasPrefix(metric.Metric.ID, "web-01."+prefix) {
+					seen[prefix] = true
+					if metric.Metric.MType != "gauge" || metric.Metric.Value == nil {
+						t.Errorf("Expected gauge with value for %s", metric.Metric.ID)
+					}
+				}
+			}
+		case <-timeout:
+			t.Fatalf("Timed out waiting for disk and network metrics, saw %v", seen)
+		}
+	}
+}
+
+func TestCollectorDiskAndNetMetricsDisabled(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 10*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 1500*time.Millisecond)
+	defer cancel()
+	collector.collectSystemMetrics(ctx)
+
+	for len(collector.systemChan) > 0 {
+		metric := <-collector.systemChan
+		if strings.HasPrefix(metric.Metric.ID, "Disk") || strings.HasPrefix(metric.Metric.ID, "Net") {
+			t.Errorf("Expected no disk or network metrics when disabled, got %s", metric.Metric.ID)
+		}
+	}
+}
+
+func TestCPUPrimingSkipsStartupSample(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 20*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetCPUPriming(true)
+
+	// The startup sample reports zero; later samples measure a real interval
+	var calls atomic.Int64
+	collector.cpuPercent = func(interval time.Duration, percpu bool) ([]float64, error) {
+		if interval != 0 {
+			t.Errorf("Expected non-blocking CPU sampling when primed, got interval %v", interval)
+		}
+		if calls.Add(1) == 1 {
+			return []float64{0}, nil
+		}
+		return []float64{37.5}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go collector.collectSystemMetrics(ctx)
+
+	timeout := time.After(time.Second)
+	for {
+		select {
+		case metric := <-collector.systemChan:
+			if metric.Metric.ID != "CPUutilization1" {
+				continue
+			}
+			if *metric.Metric.Value != 37.5 {
+				t.Errorf("Expected first reported CPU value from a real interval (37.5), got %v", *metric.Metric.Value)
+			}
+			if elapsed := time.Since(start); elapsed >= time.Second {
+				t.Errorf("Expected first CPU report well before one second, took %v", elapsed)
+			}
+			return
+		case <-timeout:
+			t.Fatal("Timed out waiting for a CPU metric")
+		}
+	}
+}
+
+func TestRetryMetricsReportDeltas(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Second, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	if len(collector.selfMetrics()) != 0 {
+		t.Fatal("Expected no retry metrics unless enabled")
+	}
+
+	stats := &retry.Stats{}
+	collector.SetRetryStats(stats)
+	stats.Record(1, errors.New("refused"))
+	stats.Record(2, nil)
+
+	deltas := func() map[string]int64 {
+		result := make(map[string]int64)
+		for _, m := range collector.selfMetrics() {
+			result[m.ID] = *m.Delta
+		}
+		return result
+	}
+
+	first := deltas()
+	if first["SendAttempts"] != 2 || first["SendRetries"] != 1 || first["SendFailures"] != 1 {
+		t.Errorf("Unexpected first report %v", first)
+	}
+
+	stats.Record(1, nil)
+	second := deltas()
+	if second["SendAttempts"] != 1 || second["SendRetries"] != 0 || second["SendFailures"] != 0 {
+		t.Errorf("Expected only the attempt since the previous report, got %v", second)
+	}
+}
+
+func TestLastErrorRecordedAndCleared(t *testing.T) {
+	var fail atomic.Bool
+	fail.Store(true)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if fail.Load() {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Second, time.Second, 10, server.URL, "", retryConfig, &pollCount)
+	collector.SetLastErrorMetric(true)
+
+	if _, err := collector.LastError(); err != nil {
+		t.Fatalf("Expected no error before any send, got %v", err)
+	}
+
+	// Fail the batch; the individual fallback is queued but not sent since the pool is not started
+	before := time.Now()
+	collector.sendMetricsBatch(nil, nil)
+
+	at, err := collector.LastError()
+	if err == nil {
+		t.Fatal("Expected the failed send to be recorded")
+	}
+	if at.Before(before) {
+		t.Errorf("Expected failure time after %v, got %v", before, at)
+	}
+	if age := *collector.lastErrorMetric().Value; age < 0 {
+		t.Errorf("Expected non-negative AgentLastErrorAge after a failure, got %v", age)
+	}
+
+	req := httptest.NewRequest(http.MethodGet, "/status", nil)
+	w := httptest.NewRecorder()
+	collector.StatusHandler()(w, req)
+	if !strings.Contains(w.Body.String(), `"last_error":`) {
+		t.Errorf("Expected status to report the last error, got %s", w.Body.String())
+	}
+
+	fail.Store(false)
+	collector.sendMetricsBatch(nil, nil)
+
+	if at, err := collector.LastError(); err != nil || !at.IsZero() {
+		t.Errorf("Expected the last error to be cleared after a successful send, got %v at %v", err, at)
+	}
+	if age := *collector.lastErrorMetric().Value; age != -1 {
+		t.Errorf("Expected AgentLastErrorAge -1 after a success, got %v", age)
+	}
+}
+
+func TestLastErrorFromWorkerPool(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+	workerPool.Start()
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Second, time.Second, 0, server.URL, "", retryConfig, &pollCount)
+
+	collector.sendMetricsIndividual(nil, nil)
+	workerPool.Stop()
+
+	if _, err := collector.LastError(); err == nil {
+		t.Error("Expected a failed individual send to be recorded")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent writes by the logger
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTraceMetricLogsTargetedMetricOnly(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	var out syncBuffer
+	log.SetOutput(&out)
+	defer log.SetOutput(os.Stderr)
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, 10*time.Millisecond, 50*time.Millisecond, 100, server.URL, "", retryConfig, &pollCount)
+	collector.SetTracer(worker.NewTracer("Alloc"))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	collector.Start(ctx)
+
+	deadline := time.Now().Add(5 * time.Second)
+	for !strings.Contains(out.String(), ": acked at ") && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	cancel()
+
+	var traces []string
+	for _, line := range strings.Split(out.String(), "\n") {
+		if strings.Contains(line, "trace ") {
+			traces = append(traces, line)
+		}
+	}
+	for _, line := range traces {
+		if !strings.Contains(line, "trace gauge Alloc=") {
+			t.Errorf("Expected only Alloc to be traced, got %q", line)
+		}
+	}
+
+	joined := strings.Join(traces, "\n")
+	for _, stage := range []string{": collected at ", ": buffered at ", ": sent in batch at ", ": acked at "} {
+		if !strings.Contains(joined, stage) {
+			t.Errorf("Expected a trace line with stage %q, got:\n%s", stage, joined)
+		}
+	}
+}
+
+func TestNilTracerIsNoop(t *testing.T) {
+	if tracer := worker.NewTracer(""); tracer != nil {
+		t.Fatalf("Expected an empty ID to disable tracing, got %+v", tracer)
+	}
+	var tracer *worker.Tracer
+	tracer.Trace(models.Metrics{ID: "Alloc", MType: "gauge"}, "collected", nil)
+}
+
+func TestFlushSendsBufferedMetrics(t *testing.T) {
+	var mu sync.Mutex
+	received := make(map[string]bool)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		var metrics []models.Metrics
+		if err := json.NewDecoder(body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		for _, m := range metrics {
+			received[m.ID] = true
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Hour, time.Hour, 10, server.URL, "", retryConfig, &pollCount)
+
+	// One metric already buffered by the forwarder, two still in the channels
+	value := 1.5
+	collector.buffer(&collector.runtimeBuf, worker.MetricData{Metric: models.Metrics{ID: "Buffered", MType: "gauge", Value: &value}, Type: "runtime"})
+	collector.runtimeChan <- worker.MetricData{Metric: models.Metrics{ID: "QueuedRuntime", MType: "gauge", Value: &value}, Type: "runtime"}
+	collector.systemChan <- worker.MetricData{Metric: models.Metrics{ID: "QueuedSystem", MType: "gauge", Value: &value}, Type: "system"}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := collector.Flush(ctx); err != nil {
+		t.Fatalf("Flush failed: %v", err)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	for _, id := range []string{"Buffered", "QueuedRuntime", "QueuedSystem", "PollCount"} {
+		if !received[id] {
+			t.Errorf("Expected %s to be sent by Flush, got %v", id, received)
+		}
+	}
+	if len(collector.runtimeChan) != 0 || len(collector.systemChan) != 0 {
+		t.Error("Expected Flush to drain the channels")
+	}
+}
+
+func TestFlushWaitsForInFlightReport(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Hour, time.Hour, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	// A report that never finishes keeps Flush waiting until its context is done
+	collector.sending.Store(true)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := collector.Flush(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("Expected Flush to give up with the context, got %v", err)
+	}
+}
+
+func TestChannelBufferFitsManyCPUs(t *testing.T) {
+	const cpus = 64
+	saved := logicalCPUs
+	logicalCPUs = func() int { return cpus }
+	defer func() { logicalCPUs = saved }()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 50*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetCPUPriming(true)
+	collector.cpuPercent = func(interval time.Duration, percpu bool) ([]float64, error) {
+		return make([]float64, cpus), nil
+	}
+
+	// Two polls of memory and per-CPU metrics fit without draining the channel
+	perPoll := systemBaseMetrics + cpus
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go collector.collectSystemMetrics(ctx)
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(collector.systemChan) < 2*perPoll {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %d queued metrics, got %d", 2*perPoll, len(collector.systemChan))
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	cancel()
+
+	if drops := collector.systemDrops.Load(); drops != 0 {
+		t.Errorf("Expected no dropped system metrics with %d CPUs, got %d", cpus, drops)
+	}
+	if got := cap(collector.runtimeChan); got < len(runtimeGaugeMetrics)+1 {
+		t.Errorf("Expected the runtime channel to fit one poll, got capacity %d", got)
+	}
+}
+
+// bulkSource reports n gauges per poll and tells the collector so
+type bulkSource struct {
+	n int
+}
+
+func (s *bulkSource) Collect() []models.Metrics {
+	metrics := make([]models.Metrics, s.n)
+	for i := range metrics {
+		value := float64(i)
+		metrics[i] = models.Metrics{ID: fmt.Sprintf("Bulk%d", i), MType: "gauge", Value: &value}
+	}
+	return metrics
+}
+
+func (s *bulkSource) MetricsPerPoll() int {
+	return s.n
+}
+
+func TestSystemChannelFitsAllProducers(t *testing.T) {
+	saved := logicalCPUs
+	logicalCPUs = func() int { return 1 }
+	defer func() { logicalCPUs = saved }()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, time.Hour, time.Hour, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.SetCollectDisk(true)
+	collector.SetCollectNet(true)
+	collector.RegisterSource(&bulkSource{n: 300})
+	collector.RegisterSource(&fakeSource{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	collector.Start(ctx)
+
+	// fakeSource does not implement SizedSource and counts as one metric
+	perPoll := systemBaseMetrics + 1 + len(DiskMetrics()) + len(NetMetrics()) + 300 + 1
+	if got := cap(collector.systemChan); got < 2*perPoll {
+		t.Errorf("Expected the system channel to fit two polls of %d metrics, got capacity %d", perPoll, got)
+	}
+}
+
+func TestSystemMetricsKeepPollInterval(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 50*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+
+	// Sampling blocks for the whole interval like cpu.Percent
+	collector.cpuPercent = func(interval time.Duration, percpu bool) ([]float64, error) {
+		time.Sleep(interval)
+		return []float64{12.5}, nil
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	start := time.Now()
+	go collector.collectSystemMetrics(ctx)
+
+	// Memory metrics are reported every poll while the first CPU sample is taken
+	polls := 0
+	timeout := time.After(3 * time.Second)
+	for polls < 5 {
+		select {
+		case metric := <-collector.systemChan:
+			if metric.Metric.ID == "TotalMemory" {
+				polls++
+			}
+		case <-timeout:
+			t.Fatalf("Timed out after %d polls", polls)
+		}
+	}
+	if elapsed := time.Since(start); elapsed >= 500*time.Millisecond {
+		t.Errorf("Expected 5 polls of 50ms well within 500ms, took %v", elapsed)
+	}
+
+	// The CPU sample is reported by the polls after it completes
+	for {
+		select {
+		case metric := <-collector.systemChan:
+			if metric.Metric.ID == "CPUutilization1" {
+				if *metric.Metric.Value != 12.5 {
+					t.Errorf("Expected CPU utilization 12.5, got %v", *metric.Metric.Value)
+				}
+				return
+			}
+		case <-timeout:
+			t.Fatal("Timed out waiting for a CPU metric")
+		}
+	}
+}
+
+func TestSendMetricsBatchAggregatesDuplicates(t *testing.T) {
+	var received []models.Metrics
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		if err := json.NewDecoder(body).Decode(&received); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 3
+	collector := New(workerPool, time.Hour, time.Hour, 10, server.URL, "", retryConfig, &pollCount)
+
+	gauge := func(id string, v float64) worker.MetricData {
+		return worker.MetricData{Metric: models.Metrics{ID: id, MType: "gauge", Value: &v}, Type: "runtime"}
+	}
+	counter := func(id string, d int64) worker.MetricData {
+		return worker.MetricData{Metric: models.Metrics{ID: id, MType: "counter", Delta: &d}, Type: "system"}
+	}
+
+	// Three polls of Alloc and two of a source counter since the last report
+	runtimeMetrics := []worker.MetricData{gauge("Alloc", 1), gauge("HeapAlloc", 10), gauge("Alloc", 2), gauge("Alloc", 3)}
+	systemMetrics := []worker.MetricData{counter("LogTailMatches", 4), gauge("FreeMemory", 7), counter("LogTailMatches", 5)}
+	if err := collector.sendMetricsBatch(runtimeMetrics, systemMetrics); err != nil {
+		t.Fatalf("sendMetricsBatch failed: %v", err)
+	}
+
+	want := []string{"Alloc=3", "HeapAlloc=10", "LogTailMatches+9", "FreeMemory=7", "PollCount+3"}
+	var got []string
+	for _, m := range received {
+		if m.Value != nil {
+			got = append(got, fmt.Sprintf("%s=%g", m.ID, *m.Value))
+		} else {
+			got = append(got, fmt.Sprintf("%s+%d", m.ID, *m.Delta))
+		}
+	}
+	if strings.Join(got, ",") != strings.Join(want, ",") {
+		t.Errorf("Expected batch %v, got %v", want, got)
+	}
+}
+
+func TestSendMetricsBatchSplitsAtMaxBatchSize(t *testing.T) {
+	var mu sync.Mutex
+	var sizes []int
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body := io.Reader(r.Body)
+		if r.Header.Get("Content-Encoding") == "gzip" {
+			gz, err := gzip.NewReader(r.Body)
+			if err != nil {
+				w.WriteHeader(http.StatusBadRequest)
+				return
+			}
+			body = gz
+		}
+		var metrics []models.Metrics
+		if err := json.NewDecoder(body).Decode(&metrics); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		mu.Lock()
+		sizes = append(sizes, len(metrics))
+		mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, server.URL, "", retryConfig)
+
+	var pollCount int64 = 1
+	collector := New(workerPool, time.Hour, time.Hour, 10, server.URL, "", retryConfig, &pollCount)
+	collector.SetMaxBatchSize(100)
+
+	// 249 gauges and PollCount make 250 metrics
+	gauges := make([]worker.MetricData, 249)
+	for i := range gauges {
+		v := float64(i)
+		gauges[i] = worker.MetricData{Metric: models.Metrics{ID: fmt.Sprintf("Gauge%d", i), MType: "gauge", Value: &v}}
+	}
+	if err := collector.sendMetricsBatch(gauges, nil); err != nil {
+		t.Fatalf("sendMetricsBatch failed: %v", err)
+	}
+
+	if got := fmt.Sprint(sizes); got != "[100 100 50]" {
+		t.Errorf("Expected requests of 100, 100 and 50 metrics, got %s", got)
+	}
+}
+
+func TestIndependentPollIntervals(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := NewWithIntervals(workerPool, 10*time.Millisecond, 100*time.Millisecond, time.Second, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.cpuPercent = func(interval time.Duration, percpu bool) ([]float64, error) {
+		return []float64{1}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 450*time.Millisecond)
+	defer cancel()
+	go collector.collectRuntimeMetrics(ctx)
+	go collector.collectSystemMetrics(ctx)
+
+	// Count system polls by their TotalMemory gauge, draining the runtime channel
+	systemPolls := 0
+	for done := false; !done; {
+		select {
+		case metric := <-collector.systemChan:
+			if metric.Metric.ID == "TotalMemory" {
+				systemPolls++
+			}
+		case <-collector.runtimeChan:
+		case <-ctx.Done():
+			done = true
+		}
+	}
+
+	// About 45 runtime and 4 system polls; the bounds leave room for slow schedulers
+	if runtimePolls := atomic.LoadInt64(&pollCount); runtimePolls < 15 {
+		t.Errorf("Expected runtime metrics to be polled every 10ms, got %d polls", runtimePolls)
+	}
+	if systemPolls < 2 || systemPolls > 5 {
+		t.Errorf("Expected system metrics to be polled every 100ms, got %d polls", systemPolls)
+	}
+}
+
+func TestPauseStopsCollection(t *testing.T) {
+	retryConfig := retry.NoRetryConfig()
+	workerPool := worker.NewPool(1, "http://localhost:8080", "", retryConfig)
+
+	var pollCount int64 = 0
+	collector := New(workerPool, 10*time.Millisecond, time.Hour, 10, "http://localhost:8080", "", retryConfig, &pollCount)
+	collector.cpuPercent = func(interval time.Duration, percpu bool) ([]float64, error) {
+		return []float64{1}, nil
+	}
+	collector.RegisterSource(&fakeSource{})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// received counts the metrics emitted by the poll loops, read by the test
+	var received atomic.Int64
+	go func() {
+		for {
+			select {
+			case <-collector.runtimeChan:
+			case <-collector.systemChan:
+			case <-ctx.Done():
+				return
+			}
+			received.Add(1)
+		}
+	}()
+
+	go collector.collectRuntimeMetrics(ctx)
+	go collector.collectSystemMetrics(ctx)
+	go collector.collectSourceMetrics(ctx)
+
+	waitForMetrics := func() {
+		t.Helper()
+		start := received.Load()
+		deadline := time.Now().Add(time.Second)
+		for received.Load() == start {
+			if time.Now().After(deadline) {
+				t.Fatal("Timed out waiting for metrics")
+			}
+			time.Sleep(5 * time.Millisecond)
+		}
+	}
+	waitForMetrics()
+
+	// Pausing concurrently with the ticks must be race-free
+	collector.Pause()
+	if !collector.Paused() {
+		t.Fatal("Expected collector to be paused")
+	}
+	// Let metrics enqueued before Pause returned drain
+	time.Sleep(20 * time.Millisecond)
+	paused := received.Load()
+	time.Sleep(100 * time.Millisecond)
+	if got := received.Load(); got != paused {
+		t.Errorf("Expected no metrics while paused, got %d", got-paused)
+	}
+
+	collector.Resume()
+	if collector.Paused() {
+		t.Fatal("Expected collector to be resumed")
+	}
+	waitForMetrics()
+}
@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"sort"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/shirou/gopsutil/v3/disk"
+	"github.com/shirou/gopsutil/v3/net"
+)
+
+// DiskMetrics returns the DiskReadBytes_<device> and DiskWriteBytes_<device> gauges with
+// the cumulative bytes read and written per block device. It returns nil if the counters
+// cannot be read on this system.
+func DiskMetrics() []models.Metrics {
+	counters, err := disk.IOCounters()
+	if err != nil {
+		return nil
+	}
+
+	devices := make([]string, 0, len(counters))
+	for device := range counters {
+		devices = append(devices, device)
+	}
+	sort.Strings(devices)
+
+	metrics := make([]models.Metrics, 0, 2*len(devices))
+	for _, device := range devices {
+		stat := counters[device]
+		metrics = append(metrics,
+			gaugeMetric("DiskReadBytes_"+device, float64(stat.ReadBytes)),
+			gaugeMetric("DiskWriteBytes_"+device, float64(stat.WriteBytes)),
+		)
+	}
+	return metrics
+}
+
+// NetMetrics returns the NetBytesSent_<interface> and NetBytesRecv_<interface> gauges with
+// the cumulative bytes sent and received per network interface. It returns nil if the
+// counters cannot be read on this system.
+func NetMetrics() []models.Metrics {
+	counters, err := net.IOCounters(true)
+	if err != nil {
+		return nil
+	}
+
+	metrics := make([]models.Metrics, 0, 2*len(counters))
+	for _, stat := range counters {
+		metrics = append(metrics,
+			gaugeMetric("NetBytesSent_"+stat.Name, float64(stat.BytesSent)),
+			gaugeMetric("NetBytesRecv_"+stat.Name, float64(stat.BytesRecv)),
+		)
+	}
+	return metrics
+}
+
+// gaugeMetric builds a gauge metric with the given ID and value
+func gaugeMetric(id string, value float64) models.Metrics {
+	return models.Metrics{ID: id, MType: "gauge", Value: &value}
+}
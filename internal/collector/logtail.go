@@ -0,0 +1,177 @@
+package collector
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"sync"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// LogTailMetric is the counter reporting the lines matched by a LogTailSource
+const LogTailMetric = "LogTailMatches"
+
+// LogTailSource is a MetricSource counting the lines appended to a log file that
+// match a pattern, e.g. ERROR. Lines written before the source was created are not
+// counted. When the file is rotated, the rest of the old file is read before
+// continuing with the new one from its start; a file truncated in place is read
+// again from its start.
+type LogTailSource struct {
+	path    string
+	pattern *regexp.Regexp
+
+	mu      sync.Mutex
+	file    *os.File    // currently tailed file, nil until path exists
+	info    os.FileInfo // identity of file, to detect rotation
+	offset  int64       // bytes of file read so far, including partial
+	partial []byte      // incomplete last line read so far
+}
+
+// NewLogTailSource tails the file at path, counting lines matching pattern. The file
+// need not exist yet; once it appears, it is read from its start.
+func NewLogTailSource(path, pattern string) (*LogTailSource, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid log tail pattern: %w", err)
+	}
+
+	s := &LogTailSource{path: path, pattern: re}
+	if err := s.open(); err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	// Only lines written from now on are counted
+	if s.file != nil {
+		s.offset = s.info.Size()
+		if _, err := s.file.Seek(s.offset, io.SeekStart); err != nil {
+			s.file.Close()
+			return nil, fmt.Errorf("failed to seek %s: %w", path, err)
+		}
+	}
+	return s, nil
+}
+
+// Collect returns the number of matching lines appended since the previous call as
+// the LogTailMatches counter delta
+func (s *LogTailSource) Collect() []models.Metrics {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	matches, err := s.poll()
+	if err != nil {
+		log.Printf("Failed to tail %s: %v", s.path, err)
+	}
+	delta := int64(matches)
+	return []models.Metrics{{ID: LogTailMetric, MType: "counter", Delta: &delta}}
+}
+
+// MetricsPerPoll returns 1, the LogTailMatches counter
+func (s *LogTailSource) MetricsPerPoll() int {
+	return 1
+}
+
+// Close closes the tailed file
+func (s *LogTailSource) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// poll counts the matching lines appended since the last poll, following rotation
+func (s *LogTailSource) poll() (int, error) {
+	if s.file == nil {
+		if err := s.open(); err != nil {
+			if os.IsNotExist(err) {
+				return 0, nil
+			}
+			return 0, err
+		}
+	}
+
+	current, err := os.Stat(s.path)
+	if err != nil && !os.IsNotExist(err) {
+		return 0, err
+	}
+
+	if current != nil && os.SameFile(current, s.info) && current.Size() < s.offset {
+		// Truncated in place: start over
+		if _, err := s.file.Seek(0, io.SeekStart); err != nil {
+			return 0, err
+		}
+		s.offset, s.partial = 0, nil
+	}
+
+	matches, err := s.readLines()
+	if err != nil {
+		return matches, err
+	}
+
+	if current != nil && !os.SameFile(current, s.info) {
+		// Rotated: the old file has been read to its end, continue with the new one
+		s.file.Close()
+		s.file = nil
+		if err := s.open(); err != nil {
+			if os.IsNotExist(err) {
+				return matches, nil
+			}
+			return matches, err
+		}
+		n, err := s.readLines()
+		return matches + n, err
+	}
+	return matches, nil
+}
+
+// open opens the file at path for reading from its start
+func (s *LogTailSource) open() error {
+	file, err := os.Open(s.path)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	s.file, s.info, s.offset, s.partial = file, info, 0, nil
+	return nil
+}
+
+// readLines reads the tailed file to its end and counts the complete lines matching
+// the pattern. An incomplete last line is kept until the rest of it is written.
+func (s *LogTailSource) readLines() (int, error) {
+	matches := 0
+	reader := bufio.NewReader(s.file)
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) > 0 && line[len(line)-1] == '\n' {
+			s.offset += int64(len(line))
+			if len(s.partial) > 0 {
+				line = append(s.partial, line...)
+				s.partial = nil
+			}
+			if s.pattern.Match(bytes.TrimRight(line, "\r\n")) {
+				matches++
+			}
+		} else if len(line) > 0 {
+			s.offset += int64(len(line))
+			s.partial = append(s.partial, line...)
+		}
+
+		if err == io.EOF {
+			return matches, nil
+		}
+		if err != nil {
+			return matches, err
+		}
+	}
+}
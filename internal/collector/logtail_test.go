@@ -0,0 +1,141 @@
+package collector
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// appendLines appends the given text to the file at path
+func appendLines(t *testing.T, path, text string) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		t.Fatalf("open %s: %v", path, err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(text); err != nil {
+		t.Fatalf("write %s: %v", path, err)
+	}
+}
+
+// collectDelta returns the LogTailMatches delta reported by one Collect call
+func collectDelta(t *testing.T, s *LogTailSource) int64 {
+	t.Helper()
+	metrics := s.Collect()
+	if len(metrics) != 1 || metrics[0].ID != LogTailMetric || metrics[0].MType != "counter" || metrics[0].Delta == nil {
+		t.Fatalf("Expected a single %s counter, got %+v", LogTailMetric, metrics)
+	}
+	return *metrics[0].Delta
+}
+
+func TestLogTailSourceCountsMatchesSinceLastPoll(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "ERROR before the agent started\n")
+
+	source, err := NewLogTailSource(path, `\bERROR\b`)
+	if err != nil {
+		t.Fatalf("NewLogTailSource: %v", err)
+	}
+	defer source.Close()
+
+	if delta := collectDelta(t, source); delta != 0 {
+		t.Errorf("Expected lines written before start to be skipped, got delta %d", delta)
+	}
+
+	appendLines(t, path, "INFO started\nERROR one\nERROR two\nWARN almost\n")
+	if delta := collectDelta(t, source); delta != 2 {
+		t.Errorf("Expected delta 2, got %d", delta)
+	}
+	if delta := collectDelta(t, source); delta != 0 {
+		t.Errorf("Expected delta 0 without new lines, got %d", delta)
+	}
+
+	// A line is only counted once it is complete
+	appendLines(t, path, "ERR")
+	if delta := collectDelta(t, source); delta != 0 {
+		t.Errorf("Expected incomplete line to be skipped, got delta %d", delta)
+	}
+	appendLines(t, path, "OR three\n")
+	if delta := collectDelta(t, source); delta != 1 {
+		t.Errorf("Expected completed line to be counted, got delta %d", delta)
+	}
+}
+
+func TestLogTailSourceFollowsRotation(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "app.log")
+	appendLines(t, path, "")
+
+	source, err := NewLogTailSource(path, "ERROR")
+	if err != nil {
+		t.Fatalf("NewLogTailSource: %v", err)
+	}
+	defer source.Close()
+
+	// Lines written just before rotation are still read from the old file
+	appendLines(t, path, "ERROR old\n")
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatalf("rename: %v", err)
+	}
+	appendLines(t, path, "ERROR new\nERROR newer\n")
+
+	if delta := collectDelta(t, source); delta != 3 {
+		t.Errorf("Expected delta 3 across rotation, got %d", delta)
+	}
+
+	appendLines(t, path, "ERROR again\n")
+	if delta := collectDelta(t, source); delta != 1 {
+		t.Errorf("Expected delta 1 after rotation, got %d", delta)
+	}
+}
+
+func TestLogTailSourceTruncation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+	appendLines(t, path, "")
+
+	source, err := NewLogTailSource(path, "ERROR")
+	if err != nil {
+		t.Fatalf("NewLogTailSource: %v", err)
+	}
+	defer source.Close()
+
+	appendLines(t, path, "ERROR one\nERROR two\n")
+	if delta := collectDelta(t, source); delta != 2 {
+		t.Errorf("Expected delta 2, got %d", delta)
+	}
+
+	if err := os.Truncate(path, 0); err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+	appendLines(t, path, "ERROR x\n")
+	if delta := collectDelta(t, source); delta != 1 {
+		t.Errorf("Expected delta 1 after truncation, got %d", delta)
+	}
+}
+
+func TestLogTailSourceMissingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "app.log")
+
+	source, err := NewLogTailSource(path, "ERROR")
+	if err != nil {
+		t.Fatalf("NewLogTailSource: %v", err)
+	}
+	defer source.Close()
+
+	if delta := collectDelta(t, source); delta != 0 {
+		t.Errorf("Expected delta 0 for a missing file, got %d", delta)
+	}
+
+	// A file appearing later is read from its start
+	appendLines(t, path, "ERROR first\n")
+	if delta := collectDelta(t, source); delta != 1 {
+		t.Errorf("Expected delta 1, got %d", delta)
+	}
+}
+
+func TestLogTailSourceInvalidPattern(t *testing.T) {
+	if _, err := NewLogTailSource(filepath.Join(t.TempDir(), "app.log"), "ERROR("); err == nil {
+		t.Error("Expected an error for an invalid pattern")
+	}
+}
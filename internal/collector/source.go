@@ -0,0 +1,76 @@
+package collector
+
+import (
+	"context"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/worker"
+)
+
+// MetricSource provides additional metrics to the collector, for example disk usage
+// or an application-specific counter. Collect is called once per system poll interval from
+// a dedicated goroutine; gauges must set Value and counters must set Delta.
+type MetricSource interface {
+	Collect() []models.Metrics
+}
+
+// SizedSource is implemented by metric sources that know how many metrics Collect
+// returns, so that the system channel can be sized for them. Other sources are counted
+// as one metric per poll.
+type SizedSource interface {
+	MetricSource
+	MetricsPerPoll() int
+}
+
+// sourceMetricsPerPoll returns the number of metrics s is expected to return per poll
+func sourceMetricsPerPoll(s MetricSource) int {
+	if sized, ok := s.(SizedSource); ok {
+		return sized.MetricsPerPoll()
+	}
+	return 1
+}
+
+// RegisterSource adds a metric source polled alongside the built-in runtime and
+// system metrics. Its metrics are reported with the instance ID prefix applied.
+// Sources should be registered before Start.
+func (c *Collector) RegisterSource(s MetricSource) {
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	c.sources = append(c.sources, s)
+}
+
+// registeredSources returns a snapshot of the registered sources
+func (c *Collector) registeredSources() []MetricSource {
+	c.sourcesMu.Lock()
+	defer c.sourcesMu.Unlock()
+	return append([]MetricSource(nil), c.sources...)
+}
+
+// collectSourceMetrics polls the registered sources and sends their metrics via the system channel
+func (c *Collector) collectSourceMetrics(ctx context.Context) {
+	ticker := time.NewTicker(c.systemPoll)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if c.Paused() {
+				continue
+			}
+			for _, source := range c.registeredSources() {
+				for _, metric := range source.Collect() {
+					metric.ID = c.metricID(metric.ID)
+					if !c.enqueue(ctx, c.systemChan, &c.systemDrops, worker.MetricData{
+						Metric: metric,
+						Type:   "source",
+					}) {
+						return
+					}
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package collector
+
+import (
+	"encoding/json"
+	"math"
+	"net/http"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// Status is the agent state served by StatusHandler
+type Status struct {
+	LastError           string     `json:"last_error,omitempty"`
+	LastErrorAt         *time.Time `json:"last_error_at,omitempty"`
+	LastErrorAgeSeconds float64    `json:"last_error_age_seconds"` // -1 if the last send succeeded
+	BackingOff          bool       `json:"backing_off"`
+}
+
+// SetLastErrorMetric enables reporting the age of the last send failure in seconds as the
+// AgentLastErrorAge gauge. The gauge is -1 while the most recent send succeeded.
+func (c *Collector) SetLastErrorMetric(enabled bool) {
+	c.lastErrMetric = enabled
+}
+
+// LastError returns the most recent send failure and when it happened. Both are zero
+// if nothing failed yet or a send succeeded after the failure. It is safe for concurrent use.
+func (c *Collector) LastError() (time.Time, error) {
+	c.lastErrMu.Lock()
+	defer c.lastErrMu.Unlock()
+	return c.lastErrAt, c.lastErr
+}
+
+// handleSendResult is called by the worker pool after each metric send
+func (c *Collector) handleSendResult(metric models.Metrics, err error) {
+	if err != nil {
+		c.forgetGauges([]models.Metrics{metric})
+	}
+	c.recordSendResult(err)
+}
+
+// recordSendResult records err as the last send failure, or clears it if err is nil
+func (c *Collector) recordSendResult(err error) {
+	c.lastErrMu.Lock()
+	defer c.lastErrMu.Unlock()
+	c.lastErr = err
+	if err != nil {
+		c.lastErrAt = time.Now()
+	} else {
+		c.lastErrAt = time.Time{}
+	}
+}
+
+// lastErrorAge returns the seconds since the last send failure, or -1 if there is none
+func (c *Collector) lastErrorAge() float64 {
+	at, err := c.LastError()
+	if err == nil {
+		return -1
+	}
+	return math.Max(time.Since(at).Seconds(), 0)
+}
+
+// lastErrorMetric reports the age of the last send failure as a gauge
+func (c *Collector) lastErrorMetric() models.Metrics {
+	age := c.lastErrorAge()
+	return models.Metrics{ID: c.metricID("AgentLastErrorAge"), MType: "gauge", Value: &age}
+}
+
+// StatusHandler serves the agent's send status as JSON, letting monitoring detect
+// an agent that cannot reach the server without scraping its logs
+func (c *Collector) StatusHandler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		status := Status{
+			LastErrorAgeSeconds: c.lastErrorAge(),
+			BackingOff:          c.backingOff(),
+		}
+		if at, err := c.LastError(); err != nil {
+			status.LastError = err.Error()
+			status.LastErrorAt = &at
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	}
+}
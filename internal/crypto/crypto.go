@@ -17,6 +17,11 @@ const (
 	ChunkLengthSize    = 2 // bytes for chunk length prefix
 )
 
+// EncryptedHeader marks an HTTP request whose body was encrypted with EncryptRSAChunked.
+// Servers decrypt a body only when this header is set to "true"; there is no guessing
+// from the body itself.
+const EncryptedHeader = "X-Encrypted"
+
 // EncryptRSA encrypts data using RSA-OAEP with SHA256
 func EncryptRSA(data []byte, publicKey *rsa.PublicKey) ([]byte, error) {
 	if publicKey == nil {
@@ -129,6 +134,24 @@ func DecryptRSAChunked(ciphertext []byte, privateKey *rsa.PrivateKey) ([]byte, e
 	return result, nil
 }
 
+// DecryptRSAChunkedAny decrypts chunked data with each of the private keys in order and
+// returns the result of the first that succeeds, e.g. to accept data encrypted for either
+// the old or the new key during key rotation. It returns the last error if none does.
+func DecryptRSAChunkedAny(ciphertext []byte, privateKeys []*rsa.PrivateKey) ([]byte, error) {
+	if len(privateKeys) == 0 {
+		return nil, fmt.Errorf("no private key given")
+	}
+
+	var err error
+	for _, privateKey := range privateKeys {
+		var plaintext []byte
+		if plaintext, err = DecryptRSAChunked(ciphertext, privateKey); err == nil {
+			return plaintext, nil
+		}
+	}
+	return nil, err
+}
+
 // calculateMaxChunkSize calculates the maximum chunk size for RSA-OAEP encryption
 func calculateMaxChunkSize(publicKey *rsa.PublicKey) int {
 	return publicKey.Size() - RSAOAEPOverhead
@@ -0,0 +1,258 @@
+// Package deadletter records metrics the agent failed to deliver permanently,
+// so that they can be inspected or replayed after an outage.
+package deadletter
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// Defaults for the rotation settings
+const (
+	DefaultMaxSize    = 10 << 20 // 10MB
+	DefaultMaxBackups = 5
+)
+
+// segmentTimeFormat names rotated segments so that they sort chronologically
+const segmentTimeFormat = "20060102T150405.000000000"
+
+// Config holds the location and rotation settings of the dead-letter log
+type Config struct {
+	// Path is the file receiving failed metrics as JSON lines
+	Path string
+
+	// MaxSize rotates the file before it would exceed this many bytes (0 disables)
+	MaxSize int64
+
+	// MaxAge rotates the file once it has been written to for this long (0 disables)
+	MaxAge time.Duration
+
+	// MaxBackups is the number of rotated segments kept; older ones are deleted (0 keeps all)
+	MaxBackups int
+
+	// Compress gzips rotated segments
+	Compress bool
+}
+
+// Entry is a single line of the dead-letter log
+type Entry struct {
+	// FailedAt is the time the metric was given up on
+	FailedAt time.Time `json:"failed_at"`
+
+	// Metric is the undelivered metric
+	Metric models.Metrics `json:"metric"`
+
+	// Error is the last error returned when sending the metric
+	Error string `json:"error"`
+}
+
+// Writer appends failed metrics to the dead-letter log, rotating it by size and age.
+// It is safe for concurrent use.
+type Writer struct {
+	mu     sync.Mutex
+	config Config
+	file   *os.File
+	size   int64
+	opened time.Time
+	now    func() time.Time
+}
+
+// NewWriter opens the dead-letter log at config.Path for appending
+func NewWriter(config Config) (*Writer, error) {
+	if config.Path == "" {
+		return nil, fmt.Errorf("dead-letter path cannot be empty")
+	}
+
+	w := &Writer{config: config, now: time.Now}
+	if err := w.open(); err != nil {
+		return nil, err
+	}
+	return w, nil
+}
+
+// Write appends a failed metric with the error that caused it to be dropped,
+// rotating the log first if it is too large or too old
+func (w *Writer) Write(metric models.Metrics, sendErr error) error {
+	entry := Entry{FailedAt: w.now(), Metric: metric}
+	if sendErr != nil {
+		entry.Error = sendErr.Error()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead-letter entry: %w", err)
+	}
+	data = append(data, '\n')
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return fmt.Errorf("dead-letter log is closed")
+	}
+	// A failed rotation must not lose the entry, so write it to whichever log is open
+	var rotateErr error
+	if w.shouldRotate(int64(len(data))) {
+		rotateErr = w.rotate()
+		if w.file == nil {
+			return rotateErr
+		}
+	}
+
+	n, err := w.file.Write(data)
+	w.size += int64(n)
+	if err != nil {
+		return errors.Join(rotateErr, fmt.Errorf("failed to write dead-letter entry: %w", err))
+	}
+	return rotateErr
+}
+
+// Close closes the current dead-letter log
+func (w *Writer) Close() error {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.file == nil {
+		return nil
+	}
+	err := w.file.Close()
+	w.file = nil
+	return err
+}
+
+// open opens the log file for appending and records its size
+func (w *Writer) open() error {
+	file, err := os.OpenFile(w.config.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter log: %w", err)
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("failed to stat dead-letter log: %w", err)
+	}
+
+	w.file = file
+	w.size = info.Size()
+	w.opened = w.now()
+	return nil
+}
+
+// shouldRotate reports whether the log must be rotated before writing n more bytes.
+// An empty log is never rotated, so an oversized entry still gets written.
+func (w *Writer) shouldRotate(n int64) bool {
+	if w.size == 0 {
+		return false
+	}
+	if w.config.MaxSize > 0 && w.size+n > w.config.MaxSize {
+		return true
+	}
+	return w.config.MaxAge > 0 && w.now().Sub(w.opened) >= w.config.MaxAge
+}
+
+// rotate moves the current log to a timestamped segment, compresses it if
+// configured, prunes old segments and opens a fresh log
+func (w *Writer) rotate() error {
+	w.file.Close()
+	w.file = nil
+
+	// Reopen even if the rename failed, appending to the old log until the next attempt
+	segment := w.config.Path + "." + w.now().UTC().Format(segmentTimeFormat)
+	renameErr := os.Rename(w.config.Path, segment)
+	if err := w.open(); err != nil {
+		return err
+	}
+	if renameErr != nil {
+		return fmt.Errorf("failed to rotate dead-letter log: %w", renameErr)
+	}
+
+	if w.config.Compress {
+		if err := compressFile(segment); err != nil {
+			return err
+		}
+	}
+	return w.prune()
+}
+
+// prune deletes the oldest rotated segments beyond MaxBackups
+func (w *Writer) prune() error {
+	if w.config.MaxBackups <= 0 {
+		return nil
+	}
+
+	segments, err := Segments(w.config.Path)
+	if err != nil {
+		return err
+	}
+	for len(segments) > w.config.MaxBackups {
+		if err := os.Remove(segments[0]); err != nil {
+			return fmt.Errorf("failed to remove old dead-letter segment: %w", err)
+		}
+		segments = segments[1:]
+	}
+	return nil
+}
+
+// Segments returns the rotated segments of the dead-letter log at path, oldest first
+func Segments(path string) ([]string, error) {
+	matches, err := filepath.Glob(path + ".*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to list dead-letter segments: %w", err)
+	}
+
+	// Skip leftovers of an interrupted compression
+	segments := matches[:0]
+	for _, m := range matches {
+		if !strings.HasSuffix(m, ".tmp") {
+			segments = append(segments, m)
+		}
+	}
+	sort.Strings(segments)
+	return segments, nil
+}
+
+// compressFile replaces path with a gzip-compressed path.gz
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("failed to open dead-letter segment: %w", err)
+	}
+	defer src.Close()
+
+	tmp := path + ".gz.tmp"
+	dst, err := os.Create(tmp)
+	if err != nil {
+		return fmt.Errorf("failed to create compressed segment: %w", err)
+	}
+
+	gz := gzip.NewWriter(dst)
+	if _, err := io.Copy(gz, src); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress dead-letter segment: %w", err)
+	}
+	if err := gz.Close(); err != nil {
+		dst.Close()
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compress dead-letter segment: %w", err)
+	}
+	if err := dst.Close(); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write compressed segment: %w", err)
+	}
+
+	if err := os.Rename(tmp, path+".gz"); err != nil {
+		return fmt.Errorf("failed to rename compressed segment: %w", err)
+	}
+	return os.Remove(path)
+}
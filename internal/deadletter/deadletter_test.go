@@ -0,0 +1,202 @@
+package deadletter
+
+import (
+	"bufio"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+func testMetric(id string) models.Metrics {
+	value := 1.5
+	return models.Metrics{ID: id, MType: "gauge", Value: &value}
+}
+
+// fakeClock returns a clock advancing by one millisecond per call, keeping segment names unique.
+// Its timestamps all marshal to the same length, so every test entry has the same size.
+func fakeClock() func() time.Time {
+	now := time.Date(2026, 1, 1, 0, 0, 0, 123456789, time.UTC)
+	return func() time.Time {
+		now = now.Add(time.Millisecond)
+		return now
+	}
+}
+
+func entrySize(t *testing.T) int64 {
+	t.Helper()
+	w, err := NewWriter(Config{Path: filepath.Join(t.TempDir(), "size.jsonl")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	w.now = fakeClock()
+	if err := w.Write(testMetric("m0"), errors.New("refused")); err != nil {
+		t.Fatal(err)
+	}
+	return w.size
+}
+
+func TestWriteEntries(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	w, err := NewWriter(Config{Path: path})
+	if err != nil {
+		t.Fatalf("NewWriter failed: %v", err)
+	}
+
+	if err := w.Write(testMetric("Alloc"), errors.New("connection refused")); err != nil {
+		t.Fatalf("Write failed: %v", err)
+	}
+	w.Close()
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var entry Entry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		t.Fatalf("Invalid JSON line %q: %v", data, err)
+	}
+	if entry.Metric.ID != "Alloc" || entry.Error != "connection refused" || entry.FailedAt.IsZero() {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+
+	if err := w.Write(testMetric("Alloc"), nil); err == nil {
+		t.Error("Expected an error writing to a closed log")
+	}
+}
+
+func TestRotationAtSizeAndPruning(t *testing.T) {
+	size := entrySize(t)
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+
+	// Room for exactly two entries per segment
+	w, err := NewWriter(Config{Path: path, MaxSize: 2 * size, MaxBackups: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = fakeClock()
+	defer w.Close()
+
+	write := func(id string) {
+		t.Helper()
+		if err := w.Write(testMetric(id), errors.New("refused")); err != nil {
+			t.Fatalf("Write failed: %v", err)
+		}
+	}
+
+	write("m1")
+	write("m2")
+	if segments, _ := Segments(path); len(segments) != 0 {
+		t.Fatalf("Expected no rotation below the size limit, got %v", segments)
+	}
+
+	write("m3")
+	segments, _ := Segments(path)
+	if len(segments) != 1 {
+		t.Fatalf("Expected rotation once the size limit is reached, got %v", segments)
+	}
+	if info, _ := os.Stat(segments[0]); info.Size() != 2*size {
+		t.Errorf("Expected rotated segment of %d bytes, got %d", 2*size, info.Size())
+	}
+
+	// Two more rotations; only the newest two segments are kept
+	for _, id := range []string{"m4", "m5", "m6", "m7"} {
+		write(id)
+	}
+	segments, _ = Segments(path)
+	if len(segments) != 2 {
+		t.Fatalf("Expected 2 retained segments, got %v", segments)
+	}
+	if ids := readIDs(t, segments[0]); strings.Join(ids, ",") != "m3,m4" {
+		t.Errorf("Expected oldest retained segment to hold m3,m4, got %v", ids)
+	}
+	if ids := readIDs(t, path); strings.Join(ids, ",") != "m7" {
+		t.Errorf("Expected current log to hold m7, got %v", ids)
+	}
+}
+
+func TestRotationByAge(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	w, err := NewWriter(Config{Path: path, MaxAge: time.Hour})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+
+	now := time.Now()
+	w.now = func() time.Time { return now }
+
+	w.Write(testMetric("m1"), nil)
+	now = now.Add(30 * time.Minute)
+	w.Write(testMetric("m2"), nil)
+	if segments, _ := Segments(path); len(segments) != 0 {
+		t.Fatalf("Expected no rotation before max age, got %v", segments)
+	}
+
+	now = now.Add(time.Hour)
+	w.Write(testMetric("m3"), nil)
+	if segments, _ := Segments(path); len(segments) != 1 {
+		t.Fatalf("Expected rotation after max age, got %v", segments)
+	}
+}
+
+func TestRotationCompressesSegments(t *testing.T) {
+	size := entrySize(t)
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	w, err := NewWriter(Config{Path: path, MaxSize: size, Compress: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.now = fakeClock()
+	defer w.Close()
+
+	w.Write(testMetric("m1"), errors.New("refused"))
+	w.Write(testMetric("m2"), errors.New("refused"))
+
+	segments, _ := Segments(path)
+	if len(segments) != 1 || !strings.HasSuffix(segments[0], ".gz") {
+		t.Fatalf("Expected one gzip segment, got %v", segments)
+	}
+	if ids := readIDs(t, segments[0]); strings.Join(ids, ",") != "m1" {
+		t.Errorf("Expected compressed segment to hold m1, got %v", ids)
+	}
+}
+
+// readIDs returns the metric IDs of the entries in a plain or gzip-compressed log
+func readIDs(t *testing.T, path string) []string {
+	t.Helper()
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	var scanner *bufio.Scanner
+	if strings.HasSuffix(path, ".gz") {
+		gz, err := gzip.NewReader(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer gz.Close()
+		scanner = bufio.NewScanner(gz)
+	} else {
+		scanner = bufio.NewScanner(file)
+	}
+
+	var ids []string
+	for scanner.Scan() {
+		var entry Entry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("Invalid entry %q: %v", scanner.Text(), err)
+		}
+		ids = append(ids, entry.Metric.ID)
+	}
+	return ids
+}
@@ -0,0 +1,59 @@
+package exporter
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Exemplar links a counter increment to the trace that caused it
+type Exemplar struct {
+	TraceID   string
+	Value     float64
+	Timestamp time.Time
+}
+
+// ExemplarStore keeps the most recent exemplar of each counter. It is safe for concurrent use.
+type ExemplarStore struct {
+	mu        sync.RWMutex
+	exemplars map[string]Exemplar
+}
+
+// NewExemplarStore creates an empty exemplar store
+func NewExemplarStore() *ExemplarStore {
+	return &ExemplarStore{exemplars: make(map[string]Exemplar)}
+}
+
+// Record stores the exemplar for the named counter, replacing any previous one
+func (s *ExemplarStore) Record(name string, e Exemplar) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.exemplars[name] = e
+}
+
+// Get returns the exemplar of the named counter, if any
+func (s *ExemplarStore) Get(name string) (Exemplar, bool) {
+	if s == nil {
+		return Exemplar{}, false
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	e, ok := s.exemplars[name]
+	return e, ok
+}
+
+// TraceID extracts the trace ID from the W3C traceparent header of r.
+// It returns an empty string if the header is missing or malformed.
+func TraceID(r *http.Request) string {
+	// traceparent: version-traceid-parentid-flags, e.g. 00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01
+	parts := strings.Split(r.Header.Get("traceparent"), "-")
+	if len(parts) != 4 || len(parts[1]) != 32 {
+		return ""
+	}
+	traceID := strings.ToLower(parts[1])
+	if strings.Trim(traceID, "0123456789abcdef") != "" || strings.Trim(traceID, "0") == "" {
+		return ""
+	}
+	return traceID
+}
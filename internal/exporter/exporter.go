@@ -0,0 +1,228 @@
+// Package exporter serves stored metrics in the Prometheus text exposition format
+// and, when enabled, in the OpenMetrics format with exemplars.
+package exporter
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+// Content types of the supported exposition formats
+const (
+	ContentTypePrometheus  = "text/plain; version=0.0.4; charset=utf-8"
+	ContentTypeOpenMetrics = "application/openmetrics-text; version=1.0.0; charset=utf-8"
+)
+
+// unitSuffixes are the base units recognized at the end of metric names for # UNIT lines
+var unitSuffixes = []string{"seconds", "bytes", "ratio", "percent", "celsius", "meters", "grams", "volts", "amperes", "joules"}
+
+// Handler returns a handler serving all stored metrics. The Prometheus text format is
+// served by default; if openMetrics is true, clients that accept application/openmetrics-text
+// get the OpenMetrics format, which includes # UNIT lines, exemplars from the store for
+// counters and the # EOF terminator. exemplars may be nil.
+func Handler(s storage.Storage, exemplars *ExemplarStore, openMetrics bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		gauges, counters := storage.WithContext(r.Context(), s).GetAll()
+
+		if openMetrics && acceptsOpenMetrics(r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", ContentTypeOpenMetrics)
+			WriteOpenMetrics(w, gauges, counters, exemplars)
+			return
+		}
+
+		w.Header().Set("Content-Type", ContentTypePrometheus)
+		WritePrometheus(w, gauges, counters)
+	}
+}
+
+// acceptsOpenMetrics reports whether the Accept header lists the OpenMetrics format
+// with a non-zero quality
+func acceptsOpenMetrics(accept string) bool {
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		if strings.TrimSpace(strings.ToLower(mediaType)) != "application/openmetrics-text" {
+			continue
+		}
+		for _, param := range strings.Split(params, ";") {
+			if q, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if v, err := strconv.ParseFloat(q, 64); err == nil && v == 0 {
+					return false
+				}
+			}
+		}
+		return true
+	}
+	return false
+}
+
+// WritePrometheus writes the metrics in the Prometheus text exposition format, sorted by name
+func WritePrometheus(w io.Writer, gauges map[string]float64, counters map[string]int64) {
+	gaugeFamilies, counterFamilies := familyNames(gauges, counters, false)
+	for _, name := range sortedKeys(gauges) {
+		family := gaugeFamilies[name]
+		fmt.Fprintf(w, "# HELP %s Gauge %s\n", family, escapeHelp(name))
+		fmt.Fprintf(w, "# TYPE %s gauge\n", family)
+		fmt.Fprintf(w, "%s %s\n", family, formatFloat(gauges[name]))
+	}
+	for _, name := range sortedKeys(counters) {
+		family := counterFamilies[name]
+		fmt.Fprintf(w, "# HELP %s Counter %s\n", family, escapeHelp(name))
+		fmt.Fprintf(w, "# TYPE %s counter\n", family)
+		fmt.Fprintf(w, "%s %d\n", family, counters[name])
+	}
+}
+
+// WriteOpenMetrics writes the metrics in the OpenMetrics text format, sorted by name.
+// Counter samples carry the exemplar recorded for them, if any.
+func WriteOpenMetrics(w io.Writer, gauges map[string]float64, counters map[string]int64, exemplars *ExemplarStore) {
+	gaugeFamilies, counterFamilies := familyNames(gauges, counters, true)
+	for _, name := range sortedKeys(gauges) {
+		family := gaugeFamilies[name]
+		writeMetadata(w, family, "gauge", "Gauge "+name)
+		fmt.Fprintf(w, "%s %s\n", family, formatFloat(gauges[name]))
+	}
+	for _, name := range sortedKeys(counters) {
+		family := counterFamilies[name]
+		writeMetadata(w, family, "counter", "Counter "+name)
+		fmt.Fprintf(w, "%s_total %d", family, counters[name])
+		if e, ok := exemplars.Get(name); ok {
+			fmt.Fprintf(w, " # {trace_id=\"%s\"} %s %s", escapeLabel(e.TraceID), formatFloat(e.Value),
+				strconv.FormatFloat(float64(e.Timestamp.UnixMilli())/1000, 'f', 3, 64))
+		}
+		fmt.Fprintln(w)
+	}
+	fmt.Fprintln(w, "# EOF")
+}
+
+// writeMetadata writes the # TYPE, # UNIT and # HELP lines of a metric family
+func writeMetadata(w io.Writer, family, metricType, help string) {
+	fmt.Fprintf(w, "# TYPE %s %s\n", family, metricType)
+	if unit := unitOf(family); unit != "" {
+		fmt.Fprintf(w, "# UNIT %s %s\n", family, unit)
+	}
+	fmt.Fprintf(w, "# HELP %s %s\n", family, escapeHelp(help))
+}
+
+// unitOf returns the base unit the family name ends with, or an empty string.
+// OpenMetrics requires the unit to be a suffix of the family name.
+func unitOf(family string) string {
+	for _, unit := range unitSuffixes {
+		if strings.HasSuffix(family, "_"+unit) {
+			return unit
+		}
+	}
+	return ""
+}
+
+// familyNames assigns every gauge and counter a distinct metric family name, since
+// Prometheus rejects an exposition declaring a family twice. A metric keeps its sanitized
+// name unless another metric already claimed it, e.g. a_b for both "a.b" and "a_b"; it
+// then gets the lowest free numeric suffix, e.g. a_b_2. Names that are valid as they are
+// claim their family first. If totalSuffix is set, counter families drop a _total suffix
+// and their samples add it, as OpenMetrics requires, and the sample names are claimed too.
+func familyNames(gauges map[string]float64, counters map[string]int64, totalSuffix bool) (gaugeFamilies, counterFamilies map[string]string) {
+	type metric struct {
+		name     string
+		base     string
+		families map[string]string
+		sample   string // Suffix of the sample name, claimed along with the family
+	}
+
+	var metrics []metric
+	gaugeFamilies = make(map[string]string, len(gauges))
+	for _, name := range sortedKeys(gauges) {
+		metrics = append(metrics, metric{name: name, base: sanitizeName(name), families: gaugeFamilies})
+	}
+	counterFamilies = make(map[string]string, len(counters))
+	for _, name := range sortedKeys(counters) {
+		m := metric{name: name, base: sanitizeName(name), families: counterFamilies}
+		if totalSuffix {
+			m.base = strings.TrimSuffix(m.base, "_total")
+			m.sample = "_total"
+		}
+		metrics = append(metrics, m)
+	}
+
+	taken := make(map[string]bool)
+	free := func(family, sample string) bool {
+		return !taken[family] && !taken[family+sample]
+	}
+	for pass := 0; pass < 2; pass++ {
+		for _, m := range metrics {
+			if valid := m.base+m.sample == m.name; valid != (pass == 0) {
+				continue
+			}
+			family := m.base
+			for i := 2; !free(family, m.sample); i++ {
+				family = fmt.Sprintf("%s_%d", m.base, i)
+			}
+			taken[family] = true
+			taken[family+m.sample] = true
+			m.families[m.name] = family
+		}
+	}
+	return gaugeFamilies, counterFamilies
+}
+
+// sanitizeName converts a stored metric name into a valid Prometheus metric name
+// by replacing invalid characters with underscores
+func sanitizeName(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		switch {
+		case r == '_' || r == ':' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z'):
+			b.WriteRune(r)
+		case r >= '0' && r <= '9':
+			if i == 0 {
+				b.WriteByte('_')
+			}
+			b.WriteRune(r)
+		default:
+			b.WriteByte('_')
+		}
+	}
+	if b.Len() == 0 {
+		return "_"
+	}
+	return b.String()
+}
+
+// formatFloat formats a sample value, spelling out the special values as both formats expect
+func formatFloat(v float64) string {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
+
+// escapeHelp escapes backslashes and newlines in HELP text
+func escapeHelp(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`).Replace(s)
+}
+
+// escapeLabel escapes a label value
+func escapeLabel(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`).Replace(s)
+}
+
+// sortedKeys returns the keys of m in ascending order
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
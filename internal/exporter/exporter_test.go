@@ -0,0 +1,194 @@
+package exporter
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+func newTestStorage() *storage.MemStorage {
+	s := storage.NewMemStorage()
+	s.UpdateGauge("Alloc", 1.5)
+	s.UpdateGauge("latency_seconds", 0.25)
+	s.UpdateCounter("requests", 42)
+	s.UpdateCounter("poll.count", 3)
+	return s
+}
+
+func TestPrometheusFormatByDefault(t *testing.T) {
+	handler := Handler(newTestStorage(), nil, true)
+
+	req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+	rec := httptest.NewRecorder()
+	handler(rec, req)
+
+	if ct := rec.Header().Get("Content-Type"); ct != ContentTypePrometheus {
+		t.Errorf("Expected content type %q, got %q", ContentTypePrometheus, ct)
+	}
+	body := rec.Body.String()
+	for _, want := range []string{
+		"# TYPE Alloc gauge\nAlloc 1.5\n",
+		"# TYPE requests counter\nrequests 42\n",
+		"# TYPE poll_count counter\npoll_count 3\n",
+	} {
+		if !strings.Contains(body, want) {
+			t.Errorf("Expected body to contain %q, got:\n%s", want, body)
+		}
+	}
+	if strings.Contains(body, "# EOF") {
+		t.Error("Prometheus format must not contain the # EOF terminator")
+	}
+}
+
+func TestOpenMetricsNegotiation(t *testing.T) {
+	tests := []struct {
+		name        string
+		accept      string
+		openMetrics bool
+		want        string
+	}{
+		{"enabled and accepted", "application/openmetrics-text; version=1.0.0", true, ContentTypeOpenMetrics},
+		{"enabled among alternatives", "text/plain;q=0.5,application/openmetrics-text;q=0.9", true, ContentTypeOpenMetrics},
+		{"enabled but refused", "application/openmetrics-text;q=0", true, ContentTypePrometheus},
+		{"enabled without accept", "", true, ContentTypePrometheus},
+		{"disabled", "application/openmetrics-text", false, ContentTypePrometheus},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := Handler(newTestStorage(), nil, tt.openMetrics)
+			req := httptest.NewRequest(http.MethodGet, "/metrics", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+
+			if ct := rec.Header().Get("Content-Type"); ct != tt.want {
+				t.Errorf("Expected content type %q, got %q", tt.want, ct)
+			}
+		})
+	}
+}
+
+func TestOpenMetricsOutput(t *testing.T) {
+	exemplars := NewExemplarStore()
+	exemplars.Record("requests", Exemplar{
+		TraceID:   "4bf92f3577b34da6a3ce929d0e0e4736",
+		Value:     2,
+		Timestamp: time.UnixMilli(1700000000123),
+	})
+
+	var b strings.Builder
+	gauges, counters := newTestStorage().GetAll()
+	WriteOpenMetrics(&b, gauges, counters, exemplars)
+	body := b.String()
+
+	want := `# TYPE Alloc gauge
+# HELP Alloc Gauge Alloc
+Alloc 1.5
+# TYPE latency_seconds gauge
+# UNIT latency_seconds seconds
+# HELP latency_seconds Gauge latency_seconds
+latency_seconds 0.25
+# TYPE poll_count counter
+# HELP poll_count Counter poll.count
+poll_count_total 3
+# TYPE requests counter
+# HELP requests Counter requests
+requests_total 42 # {trace_id="4bf92f3577b34da6a3ce929d0e0e4736"} 2 1700000000.123
+# EOF
+`
+	if body != want {
+		t.Errorf("Unexpected OpenMetrics output:\ngot:\n%s\nwant:\n%s", body, want)
+	}
+	if !strings.HasSuffix(body, "# EOF\n") {
+		t.Error("OpenMetrics output must end with the # EOF terminator")
+	}
+}
+
+func TestFamilyNameCollisions(t *testing.T) {
+	gauges := map[string]float64{"a.b": 1, "a_b": 2, "x": 3, "jobs_total": 4}
+	counters := map[string]int64{"x": 5, "jobs": 6}
+
+	var b strings.Builder
+	WritePrometheus(&b, gauges, counters)
+	prometheus := b.String()
+	for _, want := range []string{
+		"# TYPE a_b gauge\na_b 2\n",
+		"# TYPE a_b_2 gauge\na_b_2 1\n",
+		"# TYPE x gauge\nx 3\n",
+		"# TYPE x_2 counter\nx_2 5\n",
+	} {
+		if !strings.Contains(prometheus, want) {
+			t.Errorf("Expected Prometheus output to contain %q, got:\n%s", want, prometheus)
+		}
+	}
+
+	b.Reset()
+	WriteOpenMetrics(&b, gauges, counters, nil)
+	openMetrics := b.String()
+	// The counter's jobs_total sample must not clash with the jobs_total gauge
+	if !strings.Contains(openMetrics, "# TYPE jobs_2 counter\n") || !strings.Contains(openMetrics, "jobs_2_total 6\n") {
+		t.Errorf("Expected the jobs counter to be renamed, got:\n%s", openMetrics)
+	}
+
+	for format, body := range map[string]string{"Prometheus": prometheus, "OpenMetrics": openMetrics} {
+		families := make(map[string]bool)
+		for _, line := range strings.Split(body, "\n") {
+			if family, ok := strings.CutPrefix(line, "# TYPE "); ok {
+				family, _, _ = strings.Cut(family, " ")
+				if families[family] {
+					t.Errorf("%s output declares family %s twice", format, family)
+				}
+				families[family] = true
+			}
+		}
+		if len(families) != len(gauges)+len(counters) {
+			t.Errorf("Expected %d %s families, got %d", len(gauges)+len(counters), format, len(families))
+		}
+	}
+}
+
+func TestSanitizeName(t *testing.T) {
+	tests := map[string]string{
+		"Alloc":        "Alloc",
+		"poll.count":   "poll_count",
+		"web-01.Alloc": "web_01_Alloc",
+		"1xx":          "_1xx",
+		"ns:metric":    "ns:metric",
+		"":             "_",
+	}
+	for in, want := range tests {
+		if got := sanitizeName(in); got != want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", in, got, want)
+		}
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	tests := []struct {
+		header string
+		want   string
+	}{
+		{"00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"00-4BF92F3577B34DA6A3CE929D0E0E4736-00f067aa0ba902b7-01", "4bf92f3577b34da6a3ce929d0e0e4736"},
+		{"00-00000000000000000000000000000000-00f067aa0ba902b7-01", ""},
+		{"00-xyz-00f067aa0ba902b7-01", ""},
+		{"garbage", ""},
+		{"", ""},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodPost, "/update/", nil)
+		if tt.header != "" {
+			req.Header.Set("traceparent", tt.header)
+		}
+		if got := TraceID(req); got != tt.want {
+			t.Errorf("TraceID(%q) = %q, want %q", tt.header, got, tt.want)
+		}
+	}
+}
@@ -2,14 +2,23 @@ package grpcclient
 
 import (
 	"context"
+	"crypto/rsa"
+	"errors"
 	"fmt"
+	"io"
 	"log"
 	"time"
 
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/connectivity"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/keepalive"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/mutualEvg/metrics-server/internal/crypto"
 	"github.com/mutualEvg/metrics-server/internal/models"
 	pb "github.com/mutualEvg/metrics-server/internal/proto"
 	"github.com/mutualEvg/metrics-server/internal/utils"
@@ -17,16 +26,49 @@ import (
 
 // MetricsClient wraps the gRPC client for sending metrics
 type MetricsClient struct {
-	conn   *grpc.ClientConn
-	client pb.MetricsClient
-	realIP string
+	conn      *grpc.ClientConn
+	client    pb.MetricsClient
+	realIP    string
+	publicKey *rsa.PublicKey // Public key for payload encryption (optional)
 }
 
-// NewMetricsClient creates a new gRPC metrics client
-func NewMetricsClient(address string) (*MetricsClient, error) {
-	conn, err := grpc.NewClient(address,
-		grpc.WithTransportCredentials(insecure.NewCredentials()),
-	)
+// NewMetricsClient creates a new gRPC metrics client.
+// Additional dial options can be supplied, e.g. a custom dialer for tests.
+func NewMetricsClient(address string, opts ...grpc.DialOption) (*MetricsClient, error) {
+	return newMetricsClient(address, insecure.NewCredentials(), opts...)
+}
+
+// NewMetricsClientTLS creates a new gRPC metrics client that verifies the
+// server certificate against the CA certificate in caFile
+func NewMetricsClientTLS(address, caFile string, opts ...grpc.DialOption) (*MetricsClient, error) {
+	creds, err := credentials.NewClientTLSFromFile(caFile, "")
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA certificate: %w", err)
+	}
+	return newMetricsClient(address, creds, opts...)
+}
+
+// WithMaxMsgSize returns a dial option raising the largest message the client sends
+// and receives to size bytes, e.g. to fetch the full state of a large server
+func WithMaxMsgSize(size int) grpc.DialOption {
+	return grpc.WithDefaultCallOptions(grpc.MaxCallRecvMsgSize(size), grpc.MaxCallSendMsgSize(size))
+}
+
+// WithKeepalive returns a dial option pinging the server after interval without activity,
+// closing the connection if no answer arrives within timeout. The interval must not be
+// below the server's keepalive enforcement minimum or the server drops the connection.
+func WithKeepalive(interval, timeout time.Duration) grpc.DialOption {
+	return grpc.WithKeepaliveParams(keepalive.ClientParameters{
+		Time:                interval,
+		Timeout:             timeout,
+		PermitWithoutStream: true,
+	})
+}
+
+// newMetricsClient dials the server with the given transport credentials
+func newMetricsClient(address string, creds credentials.TransportCredentials, opts ...grpc.DialOption) (*MetricsClient, error) {
+	opts = append([]grpc.DialOption{grpc.WithTransportCredentials(creds)}, opts...)
+	conn, err := grpc.NewClient(address, opts...)
 	if err != nil {
 		return nil, fmt.Errorf("failed to create gRPC client: %w", err)
 	}
@@ -44,6 +86,40 @@ func NewMetricsClient(address string) (*MetricsClient, error) {
 	}, nil
 }
 
+// SetPublicKey sets the public key used to encrypt metric batches.
+// When nil, metrics are sent in the plaintext metrics field.
+func (c *MetricsClient) SetPublicKey(publicKey *rsa.PublicKey) {
+	c.publicKey = publicKey
+}
+
+// WaitReady connects to the server and waits until the connection is ready, e.g. to
+// check connectivity before sending. It fails if ctx is done first.
+func (c *MetricsClient) WaitReady(ctx context.Context) error {
+	c.conn.Connect()
+	for {
+		state := c.conn.GetState()
+		if state == connectivity.Ready {
+			return nil
+		}
+		if !c.conn.WaitForStateChange(ctx, state) {
+			return fmt.Errorf("connection not ready (%s): %w", state, ctx.Err())
+		}
+	}
+}
+
+// CheckHealth queries the standard health checking service of the server for service,
+// or for the server as a whole when service is empty
+func (c *MetricsClient) CheckHealth(ctx context.Context, service string) (healthpb.HealthCheckResponse_ServingStatus, error) {
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+
+	resp, err := healthpb.NewHealthClient(c.conn).Check(ctx, &healthpb.HealthCheckRequest{Service: service})
+	if err != nil {
+		return healthpb.HealthCheckResponse_UNKNOWN, fmt.Errorf("failed to check health via gRPC: %w", err)
+	}
+	return resp.Status, nil
+}
+
 // Close closes the gRPC connection
 func (c *MetricsClient) Close() error {
 	if c.conn != nil {
@@ -59,39 +135,24 @@ func (c *MetricsClient) SendMetrics(ctx context.Context, metrics []models.Metric
 	}
 
 	// Convert internal metrics to protobuf metrics
-	pbMetrics := make([]*pb.Metric, 0, len(metrics))
-	for _, m := range metrics {
-		pbMetric := &pb.Metric{
-			Id: m.ID,
-		}
-
-		if m.MType == "gauge" && m.Value != nil {
-			pbMetric.Type = pb.Metric_GAUGE
-			pbMetric.Value = *m.Value
-		} else if m.MType == "counter" && m.Delta != nil {
-			pbMetric.Type = pb.Metric_COUNTER
-			pbMetric.Delta = *m.Delta
-		} else {
-			log.Printf("Skipping metric %s with invalid type or value", m.ID)
-			continue
-		}
-
-		pbMetrics = append(pbMetrics, pbMetric)
-	}
+	pbMetrics := toProtoMetrics(metrics)
 
 	// Create request
 	req := &pb.UpdateMetricsRequest{
 		Metrics: pbMetrics,
 	}
 
-	// Add x-real-ip to metadata
-	md := metadata.New(map[string]string{
-		"x-real-ip": c.realIP,
-	})
-	ctx = metadata.NewOutgoingContext(ctx, md)
+	// Encrypt the serialized batch if a public key is configured
+	if c.publicKey != nil {
+		var err error
+		req, err = c.encryptRequest(req)
+		if err != nil {
+			return err
+		}
+	}
 
 	// Send request with timeout
-	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	ctx, cancel := context.WithTimeout(c.outgoingContext(ctx), 10*time.Second)
 	defer cancel()
 
 	_, err := c.client.UpdateMetrics(ctx, req)
@@ -102,3 +163,165 @@ func (c *MetricsClient) SendMetrics(ctx context.Context, metrics []models.Metric
 	log.Printf("Successfully sent %d metrics via gRPC", len(pbMetrics))
 	return nil
 }
+
+// StreamMetrics sends metrics to the gRPC server over a single client stream
+// and returns the number of metrics the server reports as processed
+func (c *MetricsClient) StreamMetrics(ctx context.Context, metrics []models.Metrics) (int64, error) {
+	if len(metrics) == 0 {
+		return 0, nil
+	}
+
+	// Stream messages carry plaintext metrics, so encrypted clients use UpdateMetrics
+	if c.publicKey != nil {
+		if err := c.SendMetrics(ctx, metrics); err != nil {
+			return 0, err
+		}
+		return int64(len(metrics)), nil
+	}
+
+	ctx, cancel := context.WithTimeout(c.outgoingContext(ctx), 10*time.Second)
+	defer cancel()
+
+	stream, err := c.client.StreamMetrics(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open metrics stream: %w", err)
+	}
+
+	for _, m := range toProtoMetrics(metrics) {
+		if err := stream.Send(m); err != nil {
+			// The real error is reported by CloseAndRecv
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return 0, fmt.Errorf("failed to stream metric %s: %w", m.Id, err)
+		}
+	}
+
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		return 0, fmt.Errorf("failed to close metrics stream: %w", err)
+	}
+
+	log.Printf("Successfully streamed %d metrics via gRPC", summary.Processed)
+	return summary.Processed, nil
+}
+
+// GetAllMetrics fetches the full stored state from the server.
+// Counters carry their accumulated total in Delta.
+func (c *MetricsClient) GetAllMetrics(ctx context.Context) ([]models.Metrics, error) {
+	ctx, cancel := context.WithTimeout(c.outgoingContext(ctx), 10*time.Second)
+	defer cancel()
+
+	resp, err := c.client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics via gRPC: %w", err)
+	}
+
+	return fromProtoMetrics(resp.Metrics), nil
+}
+
+// SnapshotLoader is a storage whose contents can be replaced at once, such as the
+// MemStorage of a read replica
+type SnapshotLoader interface {
+	// LoadSnapshot replaces all stored gauges, counters and float counters
+	LoadSnapshot(gauges map[string]float64, counters map[string]int64, floatCounters map[string]float64)
+}
+
+// SyncReplica pulls the full state from the primary server and loads it into store,
+// replacing its contents
+func (c *MetricsClient) SyncReplica(ctx context.Context, store SnapshotLoader) error {
+	metrics, err := c.GetAllMetrics(ctx)
+	if err != nil {
+		return err
+	}
+
+	gauges := make(map[string]float64)
+	counters := make(map[string]int64)
+	floatCounters := make(map[string]float64)
+	for _, m := range metrics {
+		switch m.MType {
+		case models.GaugeType:
+			gauges[m.ID] = *m.Value
+		case models.CounterType:
+			counters[m.ID] = *m.Delta
+		case models.FloatCounterType:
+			floatCounters[m.ID] = *m.Value
+		}
+	}
+
+	store.LoadSnapshot(gauges, counters, floatCounters)
+	return nil
+}
+
+// RunReplicaSync periodically pulls the primary's state into store until ctx is cancelled
+func (c *MetricsClient) RunReplicaSync(ctx context.Context, interval time.Duration, store SnapshotLoader) {
+	if err := c.SyncReplica(ctx, store); err != nil {
+		log.Printf("Initial replica sync failed: %v", err)
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := c.SyncReplica(ctx, store); err != nil {
+				log.Printf("Replica sync failed: %v", err)
+			}
+		}
+	}
+}
+
+// encryptRequest serializes the request and wraps it into the encrypted_payload field
+func (c *MetricsClient) encryptRequest(req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsRequest, error) {
+	data, err := proto.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+
+	encrypted, err := crypto.EncryptRSAChunked(data, c.publicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encrypt metrics: %w", err)
+	}
+
+	return &pb.UpdateMetricsRequest{EncryptedPayload: encrypted}, nil
+}
+
+// outgoingContext attaches the x-real-ip metadata to the context
+func (c *MetricsClient) outgoingContext(ctx context.Context) context.Context {
+	md := metadata.New(map[string]string{
+		"x-real-ip": c.realIP,
+	})
+	return metadata.NewOutgoingContext(ctx, md)
+}
+
+// fromProtoMetrics converts protobuf metrics to internal metrics, skipping invalid ones
+func fromProtoMetrics(pbMetrics []*pb.Metric) []models.Metrics {
+	metrics := make([]models.Metrics, 0, len(pbMetrics))
+	for _, m := range pbMetrics {
+		metric, err := pb.ToModel(m)
+		if err != nil {
+			log.Printf("Skipping metric received from server: %v", err)
+			continue
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics
+}
+
+// toProtoMetrics converts internal metrics to protobuf metrics,
+// skipping entries with an invalid type or missing value
+func toProtoMetrics(metrics []models.Metrics) []*pb.Metric {
+	pbMetrics := make([]*pb.Metric, 0, len(metrics))
+	for _, m := range metrics {
+		pbMetric := pb.FromModel(m)
+		if pbMetric == nil {
+			log.Printf("Skipping metric %s with invalid type or value", m.ID)
+			continue
+		}
+		pbMetrics = append(pbMetrics, pbMetric)
+	}
+	return pbMetrics
+}
@@ -0,0 +1,83 @@
+package grpcserver
+
+import (
+	"context"
+	"log"
+	"strings"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+)
+
+// healthServicePrefix is the method prefix of the standard health checking service,
+// which is exempt from the trusted subnet check so that load balancers can probe it
+var healthServicePrefix = "/" + healthpb.Health_ServiceDesc.ServiceName + "/"
+
+// Pinger is implemented by storages whose backend can become unreachable, such as
+// storage.DBStorage
+type Pinger interface {
+	Ping() error
+}
+
+// HealthServer implements the standard grpc.health.v1.Health service for the server
+// as a whole ("") and the Metrics service. Both report SERVING while the storage is
+// reachable and NOT_SERVING while its ping fails. Probes are answered from the status
+// of the last Update, so unauthenticated callers cannot make the server ping storage.
+type HealthServer struct {
+	*health.Server
+	pinger Pinger // Storage backend to ping (nil always reports SERVING)
+}
+
+// NewHealthServer creates a health server checking pinger, which may be nil for
+// storages that are always reachable
+func NewHealthServer(pinger Pinger) *HealthServer {
+	h := &HealthServer{Server: health.NewServer(), pinger: pinger}
+	h.Update()
+	return h
+}
+
+// RegisterHealthServer registers h on s as the grpc.health.v1.Health service
+func RegisterHealthServer(s grpc.ServiceRegistrar, h *HealthServer) {
+	healthpb.RegisterHealthServer(s, h)
+}
+
+// Update pings the storage and sets the reported status accordingly
+func (h *HealthServer) Update() healthpb.HealthCheckResponse_ServingStatus {
+	status := healthpb.HealthCheckResponse_SERVING
+	if h.pinger != nil {
+		if err := h.pinger.Ping(); err != nil {
+			log.Printf("Storage ping failed, reporting NOT_SERVING: %v", err)
+			status = healthpb.HealthCheckResponse_NOT_SERVING
+		}
+	}
+
+	h.SetServingStatus("", status)
+	h.SetServingStatus(pb.Metrics_ServiceDesc.ServiceName, status)
+	return status
+}
+
+// Run updates the reported status every interval until ctx is cancelled, so that
+// Watch streams learn about storage outages. The status is left NOT_SERVING on return.
+func (h *HealthServer) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			h.Shutdown()
+			return
+		case <-ticker.C:
+			h.Update()
+		}
+	}
+}
+
+// isHealthCheck reports whether method belongs to the health checking service
+func isHealthCheck(method string) bool {
+	return strings.HasPrefix(method, healthServicePrefix)
+}
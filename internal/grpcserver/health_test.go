@@ -0,0 +1,90 @@
+package grpcserver
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+
+	"google.golang.org/grpc"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/test/bufconn"
+
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+)
+
+// fakePinger is a storage backend whose reachability is switched by the test
+type fakePinger struct {
+	down  atomic.Bool
+	pings atomic.Int32
+}
+
+func (p *fakePinger) Ping() error {
+	p.pings.Add(1)
+	if p.down.Load() {
+		return errors.New("connection refused")
+	}
+	return nil
+}
+
+func TestHealthServerFollowsStorage(t *testing.T) {
+	pinger := &fakePinger{}
+
+	lis := bufconn.Listen(bufSize)
+	// Probes carry no x-real-ip, so they must pass the trusted subnet check
+	s := grpc.NewServer(grpc.UnaryInterceptor(TrustedSubnetInterceptor("10.0.0.0/8")))
+	h := NewHealthServer(pinger)
+	RegisterHealthServer(s, h)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	client, err := grpcclient.NewMetricsClient("passthrough:///bufnet", grpc.WithContextDialer(bufDialer(lis)))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	ctx := context.Background()
+	check := func(want healthpb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		for _, service := range []string{"", pb.Metrics_ServiceDesc.ServiceName} {
+			got, err := client.CheckHealth(ctx, service)
+			if err != nil {
+				t.Fatalf("Health check of %q failed: %v", service, err)
+			}
+			if got != want {
+				t.Errorf("Expected %q to report %v, got %v", service, want, got)
+			}
+		}
+	}
+
+	check(healthpb.HealthCheckResponse_SERVING)
+
+	// Probes report the cached status until the next update, e.g. a Run tick
+	pinger.down.Store(true)
+	check(healthpb.HealthCheckResponse_SERVING)
+	h.Update()
+	check(healthpb.HealthCheckResponse_NOT_SERVING)
+
+	pinger.down.Store(false)
+	h.Update()
+	check(healthpb.HealthCheckResponse_SERVING)
+
+	// Only NewHealthServer and the two updates pinged the storage
+	if n := pinger.pings.Load(); n != 3 {
+		t.Errorf("Expected 3 pings, got %d", n)
+	}
+}
+
+func TestHealthServerWithoutPinger(t *testing.T) {
+	h := NewHealthServer(nil)
+
+	resp, err := h.Check(context.Background(), &healthpb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatalf("Health check failed: %v", err)
+	}
+	if resp.Status != healthpb.HealthCheckResponse_SERVING {
+		t.Errorf("Expected SERVING, got %v", resp.Status)
+	}
+}
@@ -0,0 +1,71 @@
+package grpcserver
+
+import (
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/keepalive"
+)
+
+// DefaultMaxMsgSize is the largest message accepted and sent by default, raised from
+// gRPC's 4MB so that large metric batches fit into a single UpdateMetrics request
+const DefaultMaxMsgSize = 16 << 20
+
+// Option configures the transport of the gRPC server serving a MetricsServer, see
+// MetricsServer.ServerOptions
+type Option func(*transportConfig)
+
+// transportConfig holds the transport settings given to NewMetricsServer
+type transportConfig struct {
+	maxRecvMsgSize int                          // Largest received message in bytes (0 keeps the gRPC default)
+	maxSendMsgSize int                          // Largest sent message in bytes (0 keeps the gRPC default)
+	keepalive      *keepalive.ServerParameters  // Pings of idle connections (nil keeps the gRPC default)
+	enforcement    *keepalive.EnforcementPolicy // Limits on client pings (nil keeps the gRPC default)
+}
+
+// WithMaxRecvMsgSize sets the largest message the server receives, in bytes
+func WithMaxRecvMsgSize(size int) Option {
+	return func(c *transportConfig) {
+		c.maxRecvMsgSize = size
+	}
+}
+
+// WithMaxSendMsgSize sets the largest message the server sends, in bytes
+func WithMaxSendMsgSize(size int) Option {
+	return func(c *transportConfig) {
+		c.maxSendMsgSize = size
+	}
+}
+
+// WithKeepalive sets how the server pings idle connections, so that intermediaries
+// do not drop them and dead clients are detected
+func WithKeepalive(params keepalive.ServerParameters) Option {
+	return func(c *transportConfig) {
+		c.keepalive = &params
+	}
+}
+
+// WithKeepaliveEnforcement sets how often clients may ping the server; clients
+// pinging more often are disconnected
+func WithKeepaliveEnforcement(policy keepalive.EnforcementPolicy) Option {
+	return func(c *transportConfig) {
+		c.enforcement = &policy
+	}
+}
+
+// ServerOptions returns the options applying the message size limits and keepalive
+// settings given to NewMetricsServer, to be passed to grpc.NewServer
+func (s *MetricsServer) ServerOptions() []grpc.ServerOption {
+	var opts []grpc.ServerOption
+	if s.transport.maxRecvMsgSize > 0 {
+		opts = append(opts, grpc.MaxRecvMsgSize(s.transport.maxRecvMsgSize))
+	}
+	if s.transport.maxSendMsgSize > 0 {
+		opts = append(opts, grpc.MaxSendMsgSize(s.transport.maxSendMsgSize))
+	}
+	if s.transport.keepalive != nil {
+		opts = append(opts, grpc.KeepaliveParams(*s.transport.keepalive))
+	}
+	if s.transport.enforcement != nil {
+		opts = append(opts, grpc.KeepaliveEnforcementPolicy(*s.transport.enforcement))
+	}
+	return opts
+}
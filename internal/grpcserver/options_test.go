@@ -0,0 +1,87 @@
+package grpcserver
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
+	"github.com/mutualEvg/metrics-server/internal/models"
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+func TestGRPCMaxMsgSize(t *testing.T) {
+	// Every stored metric is logged; keep the test output readable
+	log.SetOutput(io.Discard)
+	defer log.SetOutput(os.Stderr)
+
+	// 2000 metrics with 2500-byte names make a batch of about 5MB
+	metrics := make([]models.Metrics, 0, 2000)
+	for i := 0; i < cap(metrics); i++ {
+		value := float64(i)
+		id := fmt.Sprintf("gauge_%04d_%s", i, strings.Repeat("x", 2490))
+		metrics = append(metrics, models.Metrics{ID: id, MType: "gauge", Value: &value})
+	}
+	req := &pb.UpdateMetricsRequest{}
+	for _, m := range metrics {
+		req.Metrics = append(req.Metrics, pb.FromModel(m))
+	}
+	if size := proto.Size(req); size <= 4<<20 {
+		t.Fatalf("Expected a batch larger than 4MB, got %d bytes", size)
+	}
+
+	tests := []struct {
+		name     string
+		opts     []Option
+		wantCode codes.Code
+	}{
+		{name: "default limit", wantCode: codes.ResourceExhausted},
+		{name: "raised limit", opts: []Option{WithMaxRecvMsgSize(DefaultMaxMsgSize)}, wantCode: codes.OK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := storage.NewMemStorage()
+			metricsServer := NewMetricsServer(store, DefaultConfig(), tt.opts...)
+
+			lis := bufconn.Listen(bufSize)
+			s := grpc.NewServer(metricsServer.ServerOptions()...)
+			pb.RegisterMetricsServer(s, metricsServer)
+			go s.Serve(lis)
+			defer s.Stop()
+
+			client, err := grpcclient.NewMetricsClient("passthrough:///bufnet",
+				grpc.WithContextDialer(bufDialer(lis)),
+				grpcclient.WithMaxMsgSize(DefaultMaxMsgSize),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			defer client.Close()
+
+			err = client.SendMetrics(context.Background(), metrics)
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("Expected %v, got %v", tt.wantCode, err)
+			}
+			if tt.wantCode != codes.OK {
+				return
+			}
+
+			gauges, _ := store.GetAll()
+			if len(gauges) != len(metrics) {
+				t.Errorf("Expected %d stored gauges, got %d", len(metrics), len(gauges))
+			}
+		})
+	}
+}
@@ -2,107 +2,553 @@ package grpcserver
 
 import (
 	"context"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
 	"log"
+	"math"
 	"net"
+	"strings"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 
+	"github.com/mutualEvg/metrics-server/internal/audit"
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/middleware"
+	"github.com/mutualEvg/metrics-server/internal/models"
 	pb "github.com/mutualEvg/metrics-server/internal/proto"
 	"github.com/mutualEvg/metrics-server/storage"
 )
 
+// streamBatchSize is the number of streamed metrics accumulated before they are stored
+const streamBatchSize = 100
+
+// errReadOnly rejects updates sent to a read replica
+var errReadOnly = status.Error(codes.FailedPrecondition, "server is a read-only replica: send updates to the primary")
+
+// Config holds the write policies and optional features of a MetricsServer. The zero
+// value accepts metrics of any size and disables every check and feature; servers start
+// from DefaultConfig.
+type Config struct {
+	MaxMetricSize  int                   // Largest accepted serialized metric in bytes, rejected with ResourceExhausted (0 disables the check)
+	ReservedPrefix string                // Metric name prefix only the server itself may write, rejected with PermissionDenied (empty disables the check)
+	NamePolicy     *storage.NamePolicy   // Policy metric names must satisfy to be written, rejected with InvalidArgument (nil disables the check)
+	MetricTypes    *storage.TypeRegistry // First-seen metric types to enforce, rejected with FailedPrecondition (nil disables the check)
+	LenientFloats  bool                  // Coerce NaN and infinite values to 0 with a warning instead of rejecting them
+	ReadOnly       bool                  // Reject all updates with FailedPrecondition, e.g. on a read replica
+	AuditSubject   *audit.Subject        // Observers notified of the values and client IP of stored metrics (nil disables auditing)
+	CounterRates   *storage.CounterRates // Tracker of counter deltas served by the HTTP /rate/{name} endpoint (nil disables tracking)
+	BuildInfo      *pb.BuildInfo         // Build of the running server returned by GetBuildInfo
+}
+
+// DefaultConfig returns the configuration of a server started without options: metrics
+// are limited to models.DefaultMaxMetricSize
+func DefaultConfig() *Config {
+	return &Config{
+		MaxMetricSize: models.DefaultMaxMetricSize,
+		BuildInfo:     &pb.BuildInfo{},
+	}
+}
+
 // MetricsServer implements the gRPC Metrics service
 type MetricsServer struct {
 	pb.UnimplementedMetricsServer
-	storage storage.Storage
+	storage   storage.Storage
+	cfg       *Config
+	transport transportConfig // Message size limits and keepalive settings, see ServerOptions
 }
 
-// NewMetricsServer creates a new gRPC metrics server
-func NewMetricsServer(storage storage.Storage) *MetricsServer {
-	return &MetricsServer{
+// NewMetricsServer creates a new gRPC metrics server applying the write policies of cfg.
+// The options configure the transport of the gRPC server serving it, as returned by
+// ServerOptions.
+func NewMetricsServer(storage storage.Storage, cfg *Config, opts ...Option) *MetricsServer {
+	s := &MetricsServer{
 		storage: storage,
+		cfg:     cfg,
+	}
+	for _, opt := range opts {
+		opt(&s.transport)
+	}
+	return s
+}
+
+// audit notifies the audit observers of metrics stored for the client of ctx
+func (s *MetricsServer) audit(ctx context.Context, changes []audit.MetricChange) {
+	if s.cfg.AuditSubject == nil || !s.cfg.AuditSubject.HasObservers() || len(changes) == 0 {
+		return
+	}
+	s.cfg.AuditSubject.Notify(audit.NewChangeEvent(clientIP(ctx), changes))
+}
+
+// clientIP returns the client IP of an incoming request: the x-real-ip metadata set by
+// the agent or a proxy if present, otherwise the address of the connection's peer
+func clientIP(ctx context.Context) string {
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if realIPs := md.Get("x-real-ip"); len(realIPs) > 0 && realIPs[0] != "" {
+			return realIPs[0]
+		}
+	}
+	if p, ok := peer.FromContext(ctx); ok && p.Addr != nil {
+		if host, _, err := net.SplitHostPort(p.Addr.String()); err == nil {
+			return host
+		}
+		return p.Addr.String()
+	}
+	return ""
+}
+
+// checkMetricTypes rejects metrics conflicting with their first-seen type
+func (s *MetricsServer) checkMetricTypes(metrics ...models.Metrics) error {
+	if err := s.cfg.MetricTypes.Check(metrics...); err != nil {
+		log.Printf("Rejected metric update: %v", err)
+		return status.Error(codes.FailedPrecondition, err.Error())
+	}
+	return nil
+}
+
+// recordRate records the delta of a stored counter in counterRates, unless the storage
+// caps the number of metrics and dropped the write of a new counter
+func (s *MetricsServer) recordRate(name string, delta int64) {
+	if s.cfg.CounterRates == nil {
+		return
+	}
+	if _, ok := s.storage.(storage.CapacityChecker); ok {
+		if _, ok := s.storage.GetCounter(name); !ok {
+			return
+		}
+	}
+	s.cfg.CounterRates.Add(name, delta)
+}
+
+// checkCapacity rejects metrics the storage has no room for, see storage.WithMaxMetrics
+func (s *MetricsServer) checkCapacity(metrics ...models.Metrics) error {
+	limited, ok := s.storage.(storage.CapacityChecker)
+	if !ok {
+		return nil
+	}
+	if err := limited.CheckCapacity(metrics...); err != nil {
+		return status.Error(codes.ResourceExhausted, err.Error())
+	}
+	return nil
+}
+
+// checkReservedName rejects metrics whose name carries the reserved prefix
+func (s *MetricsServer) checkReservedName(metric *pb.Metric) error {
+	if s.cfg.ReservedPrefix != "" && strings.HasPrefix(metric.Id, s.cfg.ReservedPrefix) {
+		log.Printf("Rejected write to reserved metric %s", metric.Id)
+		return status.Errorf(codes.PermissionDenied, "metric name %s is reserved", metric.Id)
+	}
+	return nil
+}
+
+// checkMetricName rejects metrics whose name violates the name policy
+func (s *MetricsServer) checkMetricName(metric *pb.Metric) error {
+	if err := s.cfg.NamePolicy.Check(metric.Id); err != nil {
+		log.Printf("Rejected metric update: %v", err)
+		return status.Error(codes.InvalidArgument, err.Error())
+	}
+	return nil
+}
+
+// checkFloatValue rejects a gauge with a NaN or infinite value, or sets the value to 0 in lenient mode
+func (s *MetricsServer) checkFloatValue(m *models.Metrics) error {
+	if m.Value == nil || !math.IsNaN(*m.Value) && !math.IsInf(*m.Value, 0) {
+		return nil
 	}
+	if s.cfg.LenientFloats {
+		log.Printf("Coerced non-finite value %v of metric %s to 0", *m.Value, m.ID)
+		*m.Value = 0
+		return nil
+	}
+	return status.Errorf(codes.InvalidArgument, "value of metric %s must be finite", m.ID)
+}
+
+// checkFloatCounter rejects a float counter if the storage does not keep float counters
+func (s *MetricsServer) checkFloatCounter(m models.Metrics) error {
+	if m.MType != models.FloatCounterType {
+		return nil
+	}
+	if _, ok := storage.WithContext(context.Background(), s.storage).(storage.FloatCounters); !ok {
+		return status.Error(codes.InvalidArgument, "float counters are not supported by the storage")
+	}
+	return nil
+}
+
+// checkMetricSize rejects metrics whose serialized size exceeds the configured limit
+func (s *MetricsServer) checkMetricSize(metric *pb.Metric) error {
+	if s.cfg.MaxMetricSize > 0 && proto.Size(metric) > s.cfg.MaxMetricSize {
+		log.Printf("Rejected oversized metric of %d bytes", proto.Size(metric))
+		return status.Errorf(codes.ResourceExhausted, "metric exceeds size limit of %d bytes", s.cfg.MaxMetricSize)
+	}
+	return nil
+}
+
+// TLSServerOption loads a certificate/key pair and returns a server option
+// that enables TLS transport credentials on the gRPC server
+func TLSServerOption(certFile, keyFile string) (grpc.ServerOption, error) {
+	creds, err := credentials.NewServerTLSFromFile(certFile, keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load gRPC TLS credentials: %w", err)
+	}
+	return grpc.Creds(creds), nil
 }
 
 // UpdateMetrics implements the UpdateMetrics RPC method
 func (s *MetricsServer) UpdateMetrics(ctx context.Context, req *pb.UpdateMetricsRequest) (*pb.UpdateMetricsResponse, error) {
+	if s.cfg.ReadOnly {
+		return nil, errReadOnly
+	}
+	// Encrypted payloads are unwrapped by DecryptionInterceptor before reaching here
+	if len(req.EncryptedPayload) > 0 {
+		log.Printf("Received encrypted gRPC payload but decryption is not configured")
+		return nil, status.Error(codes.FailedPrecondition, "encrypted payload is not supported by this server")
+	}
+
 	log.Printf("Received gRPC UpdateMetrics request with %d metrics", len(req.Metrics))
 
+	// Reject the whole request if any single metric is oversized, reserved, not allowed by
+	// the name policy, not finite or of conflicting type
+	metrics := make([]models.Metrics, 0, len(req.Metrics))
+	for _, metric := range req.Metrics {
+		if err := s.checkMetricSize(metric); err != nil {
+			return nil, err
+		}
+		if err := s.checkReservedName(metric); err != nil {
+			return nil, err
+		}
+		if err := s.checkMetricName(metric); err != nil {
+			return nil, err
+		}
+		m, err := fromProtoMetric(metric)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.checkFloatValue(&m); err != nil {
+			return nil, err
+		}
+		if err := s.checkFloatCounter(m); err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, m)
+	}
+	if err := s.checkCapacity(metrics...); err != nil {
+		return nil, err
+	}
+	if err := s.checkMetricTypes(metrics...); err != nil {
+		return nil, err
+	}
+
+	store := storage.WithContext(ctx, s.storage)
+	for _, m := range metrics {
+		switch m.MType {
+		case models.GaugeType:
+			store.UpdateGauge(m.ID, *m.Value)
+			log.Printf("Updated gauge metric: %s = %f", m.ID, *m.Value)
+		case models.CounterType:
+			store.UpdateCounter(m.ID, *m.Delta)
+			s.recordRate(m.ID, *m.Delta)
+			log.Printf("Updated counter metric: %s += %d", m.ID, *m.Delta)
+		case models.FloatCounterType:
+			store.(storage.FloatCounters).UpdateFloatCounter(m.ID, *m.Value)
+			log.Printf("Updated float counter metric: %s += %f", m.ID, *m.Value)
+		}
+	}
+
+	s.cfg.MetricTypes.Record(metrics...)
+
+	s.audit(ctx, audit.ChangesFromMetrics(metrics))
+	return &pb.UpdateMetricsResponse{}, nil
+}
+
+// StreamMetrics implements the client-streaming StreamMetrics RPC method.
+// Received metrics are accumulated and stored in batches; the number of
+// processed metrics is returned when the client closes the stream.
+func (s *MetricsServer) StreamMetrics(stream pb.Metrics_StreamMetricsServer) error {
+	if s.cfg.ReadOnly {
+		return errReadOnly
+	}
+	var processed int64
+	pending := make([]models.Metrics, 0, streamBatchSize)
+
+	for {
+		metric, err := stream.Recv()
+		if errors.Is(err, io.EOF) {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		if err := s.checkMetricSize(metric); err != nil {
+			return err
+		}
+		if err := s.checkReservedName(metric); err != nil {
+			return err
+		}
+		if err := s.checkMetricName(metric); err != nil {
+			return err
+		}
+
+		m, err := fromProtoMetric(metric)
+		if err != nil {
+			return err
+		}
+		if err := s.checkFloatValue(&m); err != nil {
+			return err
+		}
+		if err := s.checkFloatCounter(m); err != nil {
+			return err
+		}
+		pending = append(pending, m)
+
+		if len(pending) >= streamBatchSize {
+			if err := s.storeBatch(stream.Context(), pending); err != nil {
+				return err
+			}
+			processed += int64(len(pending))
+			pending = pending[:0]
+		}
+	}
+
+	if err := s.storeBatch(stream.Context(), pending); err != nil {
+		return err
+	}
+	processed += int64(len(pending))
+
+	log.Printf("Processed %d metrics from gRPC stream", processed)
+	return stream.SendAndClose(&pb.StreamSummary{Processed: processed})
+}
+
+// GetMetrics implements the GetMetrics RPC method.
+// Requested metrics that are not found are omitted from the response.
+func (s *MetricsServer) GetMetrics(ctx context.Context, req *pb.GetMetricsRequest) (*pb.GetMetricsResponse, error) {
+	resp := &pb.GetMetricsResponse{Metrics: make([]*pb.Metric, 0, len(req.Metrics))}
+
+	store := storage.WithContext(ctx, s.storage)
 	for _, metric := range req.Metrics {
 		switch metric.Type {
 		case pb.Metric_GAUGE:
-			s.storage.UpdateGauge(metric.Id, metric.Value)
-			log.Printf("Updated gauge metric: %s = %f", metric.Id, metric.Value)
-
+			if value, ok := store.GetGauge(metric.Id); ok {
+				resp.Metrics = append(resp.Metrics, &pb.Metric{Id: metric.Id, Type: pb.Metric_GAUGE, Value: value})
+			}
 		case pb.Metric_COUNTER:
-			s.storage.UpdateCounter(metric.Id, metric.Delta)
-			log.Printf("Updated counter metric: %s += %d", metric.Id, metric.Delta)
-
+			if delta, ok := store.GetCounter(metric.Id); ok {
+				resp.Metrics = append(resp.Metrics, &pb.Metric{Id: metric.Id, Type: pb.Metric_COUNTER, Delta: delta})
+			}
+		case pb.Metric_FLOAT_COUNTER:
+			if floats, ok := store.(storage.FloatCounters); ok {
+				if value, ok := floats.GetFloatCounter(metric.Id); ok {
+					resp.Metrics = append(resp.Metrics, &pb.Metric{Id: metric.Id, Type: pb.Metric_FLOAT_COUNTER, Value: value})
+				}
+			}
 		default:
-			log.Printf("Unknown metric type for %s", metric.Id)
 			return nil, status.Errorf(codes.InvalidArgument, "unknown metric type")
 		}
 	}
 
-	return &pb.UpdateMetricsResponse{}, nil
+	return resp, nil
 }
 
-// TrustedSubnetInterceptor creates a UnaryInterceptor that validates IP addresses
-// against a trusted subnet (CIDR notation). If trustedSubnet is empty, all requests are allowed.
-func TrustedSubnetInterceptor(trustedSubnet string) grpc.UnaryServerInterceptor {
-	var ipNet *net.IPNet
-	var err error
+// GetAllMetrics implements the GetAllMetrics RPC method, returning the full stored state
+func (s *MetricsServer) GetAllMetrics(ctx context.Context, req *pb.GetAllMetricsRequest) (*pb.GetMetricsResponse, error) {
+	store := storage.WithContext(ctx, s.storage)
+	gauges, counters := store.GetAll()
+	var floatCounters map[string]float64
+	if floats, ok := store.(storage.FloatCounters); ok {
+		floatCounters = floats.GetAllFloatCounters()
+	}
 
-	// Parse the trusted subnet if provided
-	if trustedSubnet != "" {
-		_, ipNet, err = net.ParseCIDR(trustedSubnet)
-		if err != nil {
-			log.Printf("Warning: Invalid trusted subnet CIDR %s: %v. All IPs will be allowed.", trustedSubnet, err)
-			ipNet = nil
-		} else {
-			log.Printf("gRPC trusted subnet configured: %s", trustedSubnet)
+	resp := &pb.GetMetricsResponse{Metrics: make([]*pb.Metric, 0, len(gauges)+len(counters)+len(floatCounters))}
+	for name, value := range gauges {
+		resp.Metrics = append(resp.Metrics, &pb.Metric{Id: name, Type: pb.Metric_GAUGE, Value: value})
+	}
+	for name, delta := range counters {
+		resp.Metrics = append(resp.Metrics, &pb.Metric{Id: name, Type: pb.Metric_COUNTER, Delta: delta})
+	}
+	for name, value := range floatCounters {
+		resp.Metrics = append(resp.Metrics, &pb.Metric{Id: name, Type: pb.Metric_FLOAT_COUNTER, Value: value})
+	}
+
+	return resp, nil
+}
+
+// GetBuildInfo implements the GetBuildInfo RPC method, returning the build of the running server
+func (s *MetricsServer) GetBuildInfo(ctx context.Context, req *pb.GetBuildInfoRequest) (*pb.BuildInfo, error) {
+	if s.cfg.BuildInfo == nil {
+		return &pb.BuildInfo{}, nil
+	}
+	return s.cfg.BuildInfo, nil
+}
+
+// storeBatch writes accumulated metrics to storage, using a single
+// transaction when the storage is backed by a database
+func (s *MetricsServer) storeBatch(ctx context.Context, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	if err := s.checkCapacity(metrics...); err != nil {
+		return err
+	}
+	if err := s.checkMetricTypes(metrics...); err != nil {
+		return err
+	}
+	changes := audit.ChangesFromMetrics(metrics)
+
+	if dbStorage, ok := s.storage.(*storage.DBStorage); ok {
+		if err := dbStorage.UpdateBatchCtx(ctx, metrics); err != nil {
+			log.Printf("Failed to store streamed batch: %v", err)
+			return status.Errorf(codes.Internal, "failed to store metrics")
 		}
+		s.cfg.MetricTypes.Record(metrics...)
+		s.cfg.CounterRates.AddBatch(metrics)
+		s.audit(ctx, changes)
+		return nil
+	}
+
+	store := storage.WithContext(ctx, s.storage)
+	for _, m := range metrics {
+		switch m.MType {
+		case models.GaugeType:
+			store.UpdateGauge(m.ID, *m.Value)
+		case models.CounterType:
+			store.UpdateCounter(m.ID, *m.Delta)
+			s.recordRate(m.ID, *m.Delta)
+		case models.FloatCounterType:
+			store.(storage.FloatCounters).UpdateFloatCounter(m.ID, *m.Value)
+		}
+	}
+	s.cfg.MetricTypes.Record(metrics...)
+	s.audit(ctx, changes)
+	return nil
+}
+
+// fromProtoMetric converts a protobuf metric into the internal representation,
+// rejecting metrics without a name or of an unknown type with InvalidArgument
+func fromProtoMetric(metric *pb.Metric) (models.Metrics, error) {
+	m, err := pb.ToModel(metric)
+	if err != nil {
+		log.Printf("Rejected metric: %v", err)
+		return models.Metrics{}, status.Error(codes.InvalidArgument, err.Error())
 	}
+	return m, nil
+}
 
+// DecryptionInterceptor creates a UnaryInterceptor that decrypts the encrypted_payload
+// of UpdateMetrics requests with the first of the given private keys that succeeds and
+// replaces the request with the decrypted one. Requests without an encrypted payload
+// are passed through.
+func DecryptionInterceptor(privateKeys ...*rsa.PrivateKey) grpc.UnaryServerInterceptor {
 	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
-		// If no trusted subnet is configured, allow all requests
-		if ipNet == nil {
+		updateReq, ok := req.(*pb.UpdateMetricsRequest)
+		if !ok || len(updateReq.EncryptedPayload) == 0 {
 			return handler(ctx, req)
 		}
 
-		// Extract metadata from context
-		md, ok := metadata.FromIncomingContext(ctx)
-		if !ok {
-			log.Printf("gRPC request rejected: no metadata found")
-			return nil, status.Error(codes.PermissionDenied, "no metadata found")
+		decrypted, err := crypto.DecryptRSAChunkedAny(updateReq.EncryptedPayload, privateKeys)
+		if err != nil {
+			log.Printf("Failed to decrypt gRPC payload: %v", err)
+			return nil, status.Error(codes.InvalidArgument, "failed to decrypt payload")
 		}
 
-		// Get X-Real-IP from metadata
-		realIPs := md.Get("x-real-ip")
-		if len(realIPs) == 0 {
-			log.Printf("gRPC request rejected: x-real-ip not found in metadata")
-			return nil, status.Error(codes.PermissionDenied, "x-real-ip not found in metadata")
+		var plainReq pb.UpdateMetricsRequest
+		if err := proto.Unmarshal(decrypted, &plainReq); err != nil {
+			log.Printf("Failed to unmarshal decrypted gRPC payload: %v", err)
+			return nil, status.Error(codes.InvalidArgument, "invalid decrypted payload")
 		}
 
-		realIP := realIPs[0]
+		return handler(ctx, &plainReq)
+	}
+}
 
-		// Parse the IP address
-		ip := net.ParseIP(realIP)
-		if ip == nil {
-			log.Printf("gRPC request rejected: invalid IP address in x-real-ip: %s", realIP)
-			return nil, status.Error(codes.PermissionDenied, "invalid IP address in x-real-ip")
+// TrustedSubnetInterceptor creates a UnaryInterceptor that validates IP addresses
+// against trusted subnets (comma-separated CIDR notation). If trustedSubnet is empty, all requests are allowed.
+// Health checks are always allowed.
+func TrustedSubnetInterceptor(trustedSubnet string) grpc.UnaryServerInterceptor {
+	subnets := parseTrustedSubnet(trustedSubnet)
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if isHealthCheck(info.FullMethod) {
+			return handler(ctx, req)
+		}
+		if err := checkTrustedSubnet(ctx, subnets, trustedSubnet); err != nil {
+			return nil, err
 		}
+		return handler(ctx, req)
+	}
+}
+
+// TrustedSubnetStreamInterceptor creates a StreamInterceptor that applies the same
+// trusted subnet validation as TrustedSubnetInterceptor to streaming RPCs.
+func TrustedSubnetStreamInterceptor(trustedSubnet string) grpc.StreamServerInterceptor {
+	subnets := parseTrustedSubnet(trustedSubnet)
 
-		// Check if IP is in the trusted subnet
-		if !ipNet.Contains(ip) {
-			log.Printf("gRPC request from %s rejected: IP not in trusted subnet %s", realIP, trustedSubnet)
-			return nil, status.Error(codes.PermissionDenied, "IP not in trusted subnet")
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if isHealthCheck(info.FullMethod) {
+			return handler(srv, ss)
+		}
+		if err := checkTrustedSubnet(ss.Context(), subnets, trustedSubnet); err != nil {
+			return err
 		}
+		return handler(srv, ss)
+	}
+}
 
-		log.Printf("gRPC request from %s allowed (in trusted subnet)", realIP)
-		return handler(ctx, req)
+// parseTrustedSubnet parses the comma-separated trusted subnet CIDRs,
+// returning nil if none are configured or valid
+func parseTrustedSubnet(trustedSubnet string) middleware.TrustedSubnets {
+	subnets := middleware.ParseTrustedSubnets(trustedSubnet)
+	if len(subnets) > 0 {
+		log.Printf("gRPC trusted subnet configured: %s", trustedSubnet)
+	} else if trustedSubnet != "" {
+		log.Printf("Warning: No valid trusted subnet in %s. All IPs will be allowed.", trustedSubnet)
 	}
+	return subnets
+}
+
+// checkTrustedSubnet validates the x-real-ip metadata of an incoming request
+func checkTrustedSubnet(ctx context.Context, subnets middleware.TrustedSubnets, trustedSubnet string) error {
+	// If no trusted subnet is configured, allow all requests
+	if len(subnets) == 0 {
+		return nil
+	}
+
+	// Extract metadata from context
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		log.Printf("gRPC request rejected: no metadata found")
+		return status.Error(codes.PermissionDenied, "no metadata found")
+	}
+
+	// Get X-Real-IP from metadata
+	realIPs := md.Get("x-real-ip")
+	if len(realIPs) == 0 {
+		log.Printf("gRPC request rejected: x-real-ip not found in metadata")
+		return status.Error(codes.PermissionDenied, "x-real-ip not found in metadata")
+	}
+
+	realIP := realIPs[0]
+
+	// Parse the IP address
+	ip, ok := middleware.ParseRealIP(realIP)
+	if !ok {
+		log.Printf("gRPC request rejected: invalid IP address in x-real-ip: %s", realIP)
+		return status.Error(codes.PermissionDenied, "invalid IP address in x-real-ip")
+	}
+
+	// Check if IP is in any of the trusted subnets
+	if !subnets.Contains(ip) {
+		log.Printf("gRPC request from %s rejected: IP not in trusted subnet %s", realIP, trustedSubnet)
+		return status.Error(codes.PermissionDenied, "IP not in trusted subnet")
+	}
+
+	log.Printf("gRPC request from %s allowed (in trusted subnet)", realIP)
+	return nil
 }
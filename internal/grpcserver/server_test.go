@@ -2,16 +2,25 @@ package grpcserver
 
 import (
 	"context"
+	"fmt"
+	"math"
 	"net"
+	"strings"
+	"sync"
 	"testing"
 
 	"google.golang.org/grpc"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/credentials/insecure"
 	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
 	"google.golang.org/grpc/status"
 	"google.golang.org/grpc/test/bufconn"
 
+	"github.com/mutualEvg/metrics-server/internal/audit"
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
+	"github.com/mutualEvg/metrics-server/internal/models"
 	pb "github.com/mutualEvg/metrics-server/internal/proto"
 	"github.com/mutualEvg/metrics-server/storage"
 )
@@ -29,7 +38,7 @@ func setupTestServer(t *testing.T, trustedSubnet string) (*grpc.Server, *bufconn
 	}
 	s := grpc.NewServer(opts...)
 
-	metricsServer := NewMetricsServer(store)
+	metricsServer := NewMetricsServer(store, DefaultConfig())
 	pb.RegisterMetricsServer(s, metricsServer)
 
 	go func() {
@@ -244,6 +253,38 @@ func TestGRPCTrustedSubnetInterceptor(t *testing.T) {
 			realIP:        "127.0.0.1",
 			shouldSucceed: true,
 		},
+		{
+			name:          "IP in second of three subnets",
+			trustedSubnet: "10.0.0.0/8,192.168.1.0/24,172.16.0.0/12",
+			realIP:        "192.168.1.100",
+			shouldSucceed: true,
+		},
+		{
+			name:          "IPv6 address in subnet list",
+			trustedSubnet: "10.0.0.0/8,2001:db8::/32",
+			realIP:        "2001:db8::10",
+			shouldSucceed: true,
+		},
+		{
+			name:          "IPv4-mapped IPv6 address in IPv4 subnet",
+			trustedSubnet: "192.168.1.0/24",
+			realIP:        "::ffff:192.168.1.7",
+			shouldSucceed: true,
+		},
+		{
+			name:          "IPv6 address outside IPv6 subnet",
+			trustedSubnet: "2001:db8::/32",
+			realIP:        "2001:db9::1",
+			shouldSucceed: false,
+			expectedCode:  codes.PermissionDenied,
+		},
+		{
+			name:          "IP outside all subnets",
+			trustedSubnet: "10.0.0.0/8,2001:db8::/32",
+			realIP:        "192.168.1.1",
+			shouldSucceed: false,
+			expectedCode:  codes.PermissionDenied,
+		},
 	}
 
 	for _, tt := range tests {
@@ -342,3 +383,700 @@ func TestGRPCInvalidMetricType(t *testing.T) {
 		t.Errorf("Expected InvalidArgument error, got %v", st.Code())
 	}
 }
+
+func TestGRPCMissingMetricIDRejected(t *testing.T) {
+	store := storage.NewMemStorage()
+	server := NewMetricsServer(store, DefaultConfig())
+
+	req := &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "cpu", Type: pb.Metric_GAUGE, Value: 1},
+			{Type: pb.Metric_COUNTER, Delta: 1},
+		},
+	}
+
+	_, err := server.UpdateMetrics(context.Background(), req)
+	if status.Code(err) != codes.InvalidArgument {
+		t.Fatalf("Expected InvalidArgument, got %v", err)
+	}
+	if _, ok := store.GetGauge("cpu"); ok {
+		t.Error("Expected no metric of the rejected request to be stored")
+	}
+}
+
+func TestGRPCFloatCounters(t *testing.T) {
+	store := storage.NewMemStorage()
+	server := NewMetricsServer(store, DefaultConfig())
+
+	req := &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "bytes", Type: pb.Metric_FLOAT_COUNTER, Value: 1.25},
+			{Id: "bytes", Type: pb.Metric_FLOAT_COUNTER, Value: 0.5},
+		},
+	}
+	if _, err := server.UpdateMetrics(context.Background(), req); err != nil {
+		t.Fatalf("UpdateMetrics failed: %v", err)
+	}
+
+	resp, err := server.GetMetrics(context.Background(), &pb.GetMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "bytes", Type: pb.Metric_FLOAT_COUNTER}},
+	})
+	if err != nil {
+		t.Fatalf("GetMetrics failed: %v", err)
+	}
+	if len(resp.Metrics) != 1 || resp.Metrics[0].Value != 1.75 {
+		t.Errorf("Expected float counter bytes=1.75, got %v", resp.Metrics)
+	}
+}
+
+func TestGRPCStreamMetrics(t *testing.T) {
+	s, lis, store := setupTestServer(t, "")
+	defer s.Stop()
+
+	ctx := context.Background()
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+
+	stream, err := client.StreamMetrics(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+
+	// Send more metrics than a single stored batch holds
+	total := streamBatchSize + 50
+	for i := 0; i < total; i++ {
+		if err := stream.Send(&pb.Metric{Id: "streamed_counter", Type: pb.Metric_COUNTER, Delta: 1}); err != nil {
+			t.Fatalf("Failed to send metric: %v", err)
+		}
+	}
+	if err := stream.Send(&pb.Metric{Id: "streamed_gauge", Type: pb.Metric_GAUGE, Value: 7.5}); err != nil {
+		t.Fatalf("Failed to send metric: %v", err)
+	}
+
+	summary, err := stream.CloseAndRecv()
+	if err != nil {
+		t.Fatalf("CloseAndRecv failed: %v", err)
+	}
+
+	if summary.Processed != int64(total+1) {
+		t.Errorf("Expected %d processed metrics, got %d", total+1, summary.Processed)
+	}
+
+	if delta, ok := store.GetCounter("streamed_counter"); !ok || delta != int64(total) {
+		t.Errorf("Expected streamed_counter %d, got %d (exists: %v)", total, delta, ok)
+	}
+	if value, ok := store.GetGauge("streamed_gauge"); !ok || value != 7.5 {
+		t.Errorf("Expected streamed_gauge 7.5, got %f (exists: %v)", value, ok)
+	}
+}
+
+func TestGRPCDecryptionInterceptor(t *testing.T) {
+	privateKey, publicKey, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key pair: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	store := storage.NewMemStorage()
+	s := grpc.NewServer(grpc.UnaryInterceptor(DecryptionInterceptor(privateKey)))
+	pb.RegisterMetricsServer(s, NewMetricsServer(store, DefaultConfig()))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	client, err := grpcclient.NewMetricsClient(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	// Encrypted batch is decrypted and stored
+	client.SetPublicKey(publicKey)
+	value := 3.25
+	delta := int64(4)
+	metrics := []models.Metrics{
+		{ID: "enc_gauge", MType: "gauge", Value: &value},
+		{ID: "enc_counter", MType: "counter", Delta: &delta},
+	}
+	if err := client.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("Encrypted SendMetrics failed: %v", err)
+	}
+	if got, ok := store.GetGauge("enc_gauge"); !ok || got != value {
+		t.Errorf("Expected enc_gauge %f, got %f (exists: %v)", value, got, ok)
+	}
+	if got, ok := store.GetCounter("enc_counter"); !ok || got != delta {
+		t.Errorf("Expected enc_counter %d, got %d (exists: %v)", delta, got, ok)
+	}
+
+	// Plaintext requests still pass through the interceptor
+	client.SetPublicKey(nil)
+	if err := client.SendMetrics(context.Background(), metrics[:1]); err != nil {
+		t.Fatalf("Plaintext SendMetrics failed: %v", err)
+	}
+}
+
+func TestGRPCEncryptedPayloadWithoutKey(t *testing.T) {
+	s, lis, _ := setupTestServer(t, "")
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{EncryptedPayload: []byte("ciphertext")})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+}
+
+func TestGRPCGetMetrics(t *testing.T) {
+	s, lis, store := setupTestServer(t, "")
+	defer s.Stop()
+
+	store.UpdateGauge("cpu", 12.5)
+	store.UpdateCounter("requests", 7)
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+	resp, err := client.GetMetrics(context.Background(), &pb.GetMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "cpu", Type: pb.Metric_GAUGE},
+			{Id: "requests", Type: pb.Metric_COUNTER},
+			{Id: "missing", Type: pb.Metric_GAUGE},
+		},
+	})
+	if err != nil {
+		t.Fatalf("GetMetrics failed: %v", err)
+	}
+	if len(resp.Metrics) != 2 {
+		t.Fatalf("Expected 2 metrics, got %d", len(resp.Metrics))
+	}
+	if resp.Metrics[0].Value != 12.5 {
+		t.Errorf("Expected gauge 12.5, got %f", resp.Metrics[0].Value)
+	}
+	if resp.Metrics[1].Delta != 7 {
+		t.Errorf("Expected counter 7, got %d", resp.Metrics[1].Delta)
+	}
+}
+
+func TestGRPCReplicaSync(t *testing.T) {
+	s, lis, primary := setupTestServer(t, "")
+	defer s.Stop()
+
+	primary.UpdateGauge("cpu", 12.5)
+	primary.UpdateCounter("requests", 7)
+	primary.UpdateCounter("requests", 3)
+	primary.(storage.FloatCounters).UpdateFloatCounter("bytes", 1.5)
+
+	client, err := grpcclient.NewMetricsClient("passthrough:///bufnet", grpc.WithContextDialer(bufDialer(lis)))
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer client.Close()
+
+	replica := storage.NewMemStorage()
+	replica.UpdateGauge("stale", 1)
+	replica.UpdateFloatCounter("stale", 1)
+
+	// Syncing twice must not accumulate counters on the replica
+	for i := 0; i < 2; i++ {
+		if err := client.SyncReplica(context.Background(), replica); err != nil {
+			t.Fatalf("SyncReplica failed: %v", err)
+		}
+	}
+
+	gauges, counters := replica.GetAll()
+	if len(gauges) != 1 || gauges["cpu"] != 12.5 {
+		t.Errorf("Unexpected replica gauges: %v", gauges)
+	}
+	if len(counters) != 1 || counters["requests"] != 10 {
+		t.Errorf("Unexpected replica counters: %v", counters)
+	}
+	if floats := replica.GetAllFloatCounters(); len(floats) != 1 || floats["bytes"] != 1.5 {
+		t.Errorf("Unexpected replica float counters: %v", floats)
+	}
+}
+
+func TestGRPCOversizedMetricRejected(t *testing.T) {
+	s, lis, store := setupTestServer(t, "")
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+	longName := strings.Repeat("x", models.DefaultMaxMetricSize+1)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "ok", Type: pb.Metric_GAUGE, Value: 1},
+			{Id: longName, Type: pb.Metric_GAUGE, Value: 1},
+		},
+	})
+	if status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted, got %v", err)
+	}
+	if _, ok := store.GetGauge("ok"); ok {
+		t.Error("No metric of a rejected request should be stored")
+	}
+
+	stream, err := client.StreamMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.Send(&pb.Metric{Id: longName, Type: pb.Metric_COUNTER, Delta: 1}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.ResourceExhausted {
+		t.Errorf("Expected ResourceExhausted from stream, got %v", err)
+	}
+}
+
+func TestGRPCReservedNameRejected(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	store := storage.NewMemStorage()
+
+	cfg := DefaultConfig()
+	cfg.ReservedPrefix = "_internal_"
+	metricsServer := NewMetricsServer(store, cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "ok", Type: pb.Metric_GAUGE, Value: 1},
+			{Id: "_internal_QueueDepth", Type: pb.Metric_GAUGE, Value: 1},
+		},
+	})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied, got %v", err)
+	}
+	if _, ok := store.GetGauge("ok"); ok {
+		t.Error("No metric of a rejected request should be stored")
+	}
+
+	stream, err := client.StreamMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.Send(&pb.Metric{Id: "_internal_Drops", Type: pb.Metric_COUNTER, Delta: 1}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Expected PermissionDenied from stream, got %v", err)
+	}
+
+	// Internal writes bypass the check
+	store.UpdateGauge("_internal_QueueDepth", 2)
+	if v, ok := store.GetGauge("_internal_QueueDepth"); !ok || v != 2 {
+		t.Errorf("Expected internal write to succeed, got %v (found: %v)", v, ok)
+	}
+}
+
+func TestGRPCMetricNamePolicy(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	store := storage.NewMemStorage()
+
+	policy, err := storage.NewNamePolicy(`^[a-z][a-z0-9_]*$`, `^debug_`)
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.NamePolicy = policy
+	metricsServer := NewMetricsServer(store, cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "heap_alloc", Type: pb.Metric_GAUGE, Value: 1}},
+	})
+	if err != nil {
+		t.Fatalf("Expected allowed name to be stored, got %v", err)
+	}
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "ok", Type: pb.Metric_GAUGE, Value: 1},
+			{Id: "HeapAlloc", Type: pb.Metric_GAUGE, Value: 1},
+		},
+	})
+	if status.Code(err) != codes.InvalidArgument || !strings.Contains(err.Error(), "HeapAlloc does not match") {
+		t.Errorf("Expected InvalidArgument naming HeapAlloc, got %v", err)
+	}
+	if _, ok := store.GetGauge("ok"); ok {
+		t.Error("No metric of a rejected request should be stored")
+	}
+
+	stream, err := client.StreamMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.Send(&pb.Metric{Id: "debug_drops", Type: pb.Metric_COUNTER, Delta: 1}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Expected InvalidArgument from stream, got %v", err)
+	}
+}
+
+func TestGRPCMetricTypeConflictRejected(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	store := storage.NewMemStorage()
+
+	cfg := DefaultConfig()
+	cfg.MetricTypes = storage.NewTypeRegistry()
+	metricsServer := NewMetricsServer(store, cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "Requests", Type: pb.Metric_COUNTER, Delta: 1}},
+	})
+	if err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "Requests", Type: pb.Metric_GAUGE, Value: 1}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition, got %v", err)
+	}
+	if _, ok := store.GetGauge("Requests"); ok {
+		t.Error("Conflicting gauge should not be stored")
+	}
+
+	stream, err := client.StreamMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.Send(&pb.Metric{Id: "Requests", Type: pb.Metric_GAUGE, Value: 2}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition from stream, got %v", err)
+	}
+}
+
+func TestGRPCReadOnlyRejectsUpdates(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	store := storage.NewMemStorage()
+
+	cfg := DefaultConfig()
+	cfg.ReadOnly = true
+	metricsServer := NewMetricsServer(store, cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+
+	_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{{Id: "Load", Type: pb.Metric_GAUGE, Value: 1}},
+	})
+	if status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition from UpdateMetrics, got %v", err)
+	}
+
+	stream, err := client.StreamMetrics(context.Background())
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	stream.Send(&pb.Metric{Id: "Temp", Type: pb.Metric_GAUGE, Value: 1})
+	if _, err := stream.CloseAndRecv(); status.Code(err) != codes.FailedPrecondition {
+		t.Errorf("Expected FailedPrecondition from StreamMetrics, got %v", err)
+	}
+
+	if g, c := store.GetAll(); len(g) != 0 || len(c) != 0 {
+		t.Errorf("Expected nothing stored on a read-only server, got %v, %v", g, c)
+	}
+}
+
+func TestGRPCNonFiniteGaugeRejected(t *testing.T) {
+	for _, lenient := range []bool{false, true} {
+		t.Run(fmt.Sprintf("lenient=%v", lenient), func(t *testing.T) {
+			lis := bufconn.Listen(bufSize)
+			store := storage.NewMemStorage()
+
+			cfg := DefaultConfig()
+			cfg.LenientFloats = lenient
+			metricsServer := NewMetricsServer(store, cfg)
+
+			s := grpc.NewServer()
+			pb.RegisterMetricsServer(s, metricsServer)
+			go s.Serve(lis)
+			defer s.Stop()
+
+			conn, err := grpc.NewClient("passthrough:///bufnet",
+				grpc.WithContextDialer(bufDialer(lis)),
+				grpc.WithTransportCredentials(insecure.NewCredentials()),
+			)
+			if err != nil {
+				t.Fatalf("Failed to create client: %v", err)
+			}
+			defer conn.Close()
+
+			client := pb.NewMetricsClient(conn)
+
+			_, err = client.UpdateMetrics(context.Background(), &pb.UpdateMetricsRequest{
+				Metrics: []*pb.Metric{{Id: "Load", Type: pb.Metric_GAUGE, Value: math.NaN()}},
+			})
+			stream, streamErr := client.StreamMetrics(context.Background())
+			if streamErr != nil {
+				t.Fatalf("Failed to open stream: %v", streamErr)
+			}
+			if err := stream.Send(&pb.Metric{Id: "Temp", Type: pb.Metric_GAUGE, Value: math.Inf(1)}); err != nil {
+				t.Fatalf("Failed to send: %v", err)
+			}
+			_, streamErr = stream.CloseAndRecv()
+
+			if !lenient {
+				if status.Code(err) != codes.InvalidArgument {
+					t.Errorf("Expected InvalidArgument, got %v", err)
+				}
+				if status.Code(streamErr) != codes.InvalidArgument {
+					t.Errorf("Expected InvalidArgument from stream, got %v", streamErr)
+				}
+				if _, ok := store.GetGauge("Load"); ok {
+					t.Error("NaN gauge should not be stored")
+				}
+				return
+			}
+
+			if err != nil || streamErr != nil {
+				t.Fatalf("Expected lenient updates to succeed, got %v and %v", err, streamErr)
+			}
+			for _, name := range []string{"Load", "Temp"} {
+				if v, ok := store.GetGauge(name); !ok || v != 0 {
+					t.Errorf("Expected %s to be coerced to 0, got %v (found %v)", name, v, ok)
+				}
+			}
+		})
+	}
+}
+
+// recordingObserver collects the audit events it is notified of
+type recordingObserver struct {
+	mu     sync.Mutex
+	events []audit.Event
+}
+
+func (o *recordingObserver) Notify(event audit.Event) error {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	o.events = append(o.events, event)
+	return nil
+}
+
+func TestGRPCAuditEvents(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+	store := storage.NewMemStorage()
+
+	observer := &recordingObserver{}
+	subject := audit.NewSubject()
+	subject.Attach(observer)
+
+	cfg := DefaultConfig()
+	cfg.AuditSubject = subject
+	metricsServer := NewMetricsServer(store, cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	client := pb.NewMetricsClient(conn)
+	ctx := metadata.AppendToOutgoingContext(context.Background(), "x-real-ip", "192.168.1.10")
+
+	_, err = client.UpdateMetrics(ctx, &pb.UpdateMetricsRequest{
+		Metrics: []*pb.Metric{
+			{Id: "Alloc", Type: pb.Metric_GAUGE, Value: 1},
+			{Id: "PollCount", Type: pb.Metric_COUNTER, Delta: 1},
+		},
+	})
+	if err != nil {
+		t.Fatalf("UpdateMetrics failed: %v", err)
+	}
+
+	stream, err := client.StreamMetrics(ctx)
+	if err != nil {
+		t.Fatalf("Failed to open stream: %v", err)
+	}
+	if err := stream.Send(&pb.Metric{Id: "Streamed", Type: pb.Metric_GAUGE, Value: 2}); err != nil {
+		t.Fatalf("Failed to send: %v", err)
+	}
+	if _, err := stream.CloseAndRecv(); err != nil {
+		t.Fatalf("Stream failed: %v", err)
+	}
+
+	// Reads are not audited
+	if _, err := client.GetAllMetrics(ctx, &pb.GetAllMetricsRequest{}); err != nil {
+		t.Fatalf("GetAllMetrics failed: %v", err)
+	}
+
+	observer.mu.Lock()
+	defer observer.mu.Unlock()
+	if len(observer.events) != 2 {
+		t.Fatalf("Expected 2 audit events, got %d: %+v", len(observer.events), observer.events)
+	}
+	if got := strings.Join(observer.events[0].Metrics, ","); got != "Alloc,PollCount" {
+		t.Errorf("Expected UpdateMetrics event for Alloc,PollCount, got %s", got)
+	}
+	if got := strings.Join(observer.events[1].Metrics, ","); got != "Streamed" {
+		t.Errorf("Expected StreamMetrics event for Streamed, got %s", got)
+	}
+	if c := observer.events[0].Changes; len(c) != 2 || c[0].Value == nil || *c[0].Value != 1 || c[1].Delta == nil || *c[1].Delta != 1 {
+		t.Errorf("Expected the written values in the UpdateMetrics event, got %+v", c)
+	}
+	if c := observer.events[1].Changes; len(c) != 1 || c[0].Value == nil || *c[0].Value != 2 {
+		t.Errorf("Expected the streamed value in the StreamMetrics event, got %+v", c)
+	}
+	for _, event := range observer.events {
+		if event.IPAddress != "192.168.1.10" {
+			t.Errorf("Expected IP from x-real-ip, got %q", event.IPAddress)
+		}
+		if event.Timestamp == 0 {
+			t.Error("Expected a timestamp")
+		}
+	}
+}
+
+func TestClientIPFallsBackToPeer(t *testing.T) {
+	addr := &net.TCPAddr{IP: net.ParseIP("10.0.0.7"), Port: 51234}
+	ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: addr})
+	if ip := clientIP(ctx); ip != "10.0.0.7" {
+		t.Errorf("Expected peer IP 10.0.0.7, got %q", ip)
+	}
+
+	ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("x-real-ip", "192.168.1.10"))
+	if ip := clientIP(ctx); ip != "192.168.1.10" {
+		t.Errorf("Expected x-real-ip to take precedence, got %q", ip)
+	}
+
+	if ip := clientIP(context.Background()); ip != "" {
+		t.Errorf("Expected no IP without peer info, got %q", ip)
+	}
+}
+
+func TestGRPCGetBuildInfo(t *testing.T) {
+	lis := bufconn.Listen(bufSize)
+
+	cfg := DefaultConfig()
+	cfg.BuildInfo = &pb.BuildInfo{Version: "v1.2.3", Date: "2026-01-02", Commit: "abc123", GoVersion: "go1.24"}
+	metricsServer := NewMetricsServer(storage.NewMemStorage(), cfg)
+
+	s := grpc.NewServer()
+	pb.RegisterMetricsServer(s, metricsServer)
+	go s.Serve(lis)
+	defer s.Stop()
+
+	conn, err := grpc.NewClient("passthrough:///bufnet",
+		grpc.WithContextDialer(bufDialer(lis)),
+		grpc.WithTransportCredentials(insecure.NewCredentials()),
+	)
+	if err != nil {
+		t.Fatalf("Failed to create client: %v", err)
+	}
+	defer conn.Close()
+
+	info, err := pb.NewMetricsClient(conn).GetBuildInfo(context.Background(), &pb.GetBuildInfoRequest{})
+	if err != nil {
+		t.Fatalf("GetBuildInfo failed: %v", err)
+	}
+	if info.Version != "v1.2.3" || info.Date != "2026-01-02" || info.Commit != "abc123" || info.GoVersion != "go1.24" {
+		t.Errorf("Unexpected build info: %v", info)
+	}
+}
@@ -0,0 +1,123 @@
+package grpcserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+
+	"github.com/mutualEvg/metrics-server/internal/grpcclient"
+	"github.com/mutualEvg/metrics-server/internal/models"
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+// writeSelfSignedCert generates a self-signed certificate for 127.0.0.1/localhost
+// and writes the PEM-encoded certificate and key into dir
+func writeSelfSignedCert(t *testing.T, dir string) (certFile, keyFile string) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("Failed to create certificate: %v", err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatalf("Failed to marshal key: %v", err)
+	}
+
+	certFile = filepath.Join(dir, "server.crt")
+	keyFile = filepath.Join(dir, "server.key")
+	if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644); err != nil {
+		t.Fatalf("Failed to write certificate: %v", err)
+	}
+	if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	return certFile, keyFile
+}
+
+func TestGRPCServerTLS(t *testing.T) {
+	certFile, keyFile := writeSelfSignedCert(t, t.TempDir())
+
+	tlsOpt, err := TLSServerOption(certFile, keyFile)
+	if err != nil {
+		t.Fatalf("TLSServerOption failed: %v", err)
+	}
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to listen: %v", err)
+	}
+
+	store := storage.NewMemStorage()
+	s := grpc.NewServer(tlsOpt)
+	pb.RegisterMetricsServer(s, NewMetricsServer(store, DefaultConfig()))
+	go s.Serve(lis)
+	defer s.Stop()
+
+	// A client trusting the CA can send metrics
+	client, err := grpcclient.NewMetricsClientTLS(lis.Addr().String(), certFile)
+	if err != nil {
+		t.Fatalf("Failed to create TLS client: %v", err)
+	}
+	defer client.Close()
+
+	value := 12.5
+	metrics := []models.Metrics{{ID: "tls_gauge", MType: "gauge", Value: &value}}
+	if err := client.SendMetrics(context.Background(), metrics); err != nil {
+		t.Fatalf("SendMetrics over TLS failed: %v", err)
+	}
+	if got, ok := store.GetGauge("tls_gauge"); !ok || got != value {
+		t.Errorf("Expected tls_gauge %f, got %f (exists: %v)", value, got, ok)
+	}
+
+	// A plaintext client is rejected by the TLS server
+	insecureClient, err := grpcclient.NewMetricsClient(lis.Addr().String())
+	if err != nil {
+		t.Fatalf("Failed to create insecure client: %v", err)
+	}
+	defer insecureClient.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := insecureClient.SendMetrics(ctx, metrics); err == nil {
+		t.Error("Expected plaintext client to fail against TLS server")
+	}
+}
+
+func TestTLSServerOptionMissingFiles(t *testing.T) {
+	if _, err := TLSServerOption("/nonexistent/cert.pem", "/nonexistent/key.pem"); err == nil {
+		t.Error("Expected error for missing certificate files")
+	}
+}
@@ -1,29 +1,184 @@
 package handlers
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"io"
+	"math"
 	"net/http"
+	"slices"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 	"github.com/mutualEvg/metrics-server/internal/audit"
+	"github.com/mutualEvg/metrics-server/internal/exporter"
+	"github.com/mutualEvg/metrics-server/internal/middleware"
 	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/pool"
 	"github.com/mutualEvg/metrics-server/storage"
 	"github.com/rs/zerolog/log"
 )
 
 const (
 	// GaugeType represents floating-point metrics that can be set to any value
-	GaugeType = "gauge"
+	GaugeType = models.GaugeType
 	
 	// CounterType represents integer metrics that accumulate values over time
-	CounterType = "counter"
+	CounterType = models.CounterType
+
+	// FloatCounterType represents floating-point metrics that accumulate values over time
+	FloatCounterType = models.FloatCounterType
 )
 
+// Config holds the write policies and optional features shared by the handlers. The
+// zero value accepts metrics of any size and disables every check and feature; servers
+// start from DefaultConfig. Code writing to storage directly is not affected.
+type Config struct {
+	MaxMetricSize      int                       // Largest accepted size of a single metric in bytes (0 disables the check)
+	ReservedPrefix     string                    // Metric name prefix only the server itself may write, rejected with 403 (empty disables the check)
+	NamePolicy         *storage.NamePolicy       // Policy metric names must satisfy to be written, rejected with 400 (nil disables the check)
+	MetricTypes        *storage.TypeRegistry     // First-seen metric types to enforce, rejected with 409 (nil disables the check)
+	LenientFloats      bool                      // Coerce NaN and infinite gauge and float counter values to 0 instead of rejecting them
+	Exemplars          *exporter.ExemplarStore   // Receives the traceparent trace IDs of counter updates (nil disables recording)
+	CounterRates       *storage.CounterRates     // Tracker of counter deltas read back by RateHandler (nil disables tracking)
+	CounterDeltaHeader bool                      // Send the applied delta of counter updates in the X-Counter-Delta header
+	RootCacheTTL       time.Duration             // How long RootHandler serves a built page (0 disables caching)
+	Idempotency        *IdempotencyStore         // Replays the responses of repeated batch updates (nil disables it)
+	TrustedProxies     middleware.TrustedSubnets // Proxies whose X-Real-IP header names the client an Idempotency-Key belongs to
+}
+
+// DefaultConfig returns the configuration of a server started without options: metrics
+// are limited to models.DefaultMaxMetricSize and the metrics page is cached for a second
+func DefaultConfig() *Config {
+	return &Config{
+		MaxMetricSize: models.DefaultMaxMetricSize,
+		RootCacheTTL:  time.Second,
+	}
+}
+
+// metricTooLarge reports whether a metric of the given size exceeds the configured limit
+func (c *Config) metricTooLarge(size int) bool {
+	return c.MaxMetricSize > 0 && size > c.MaxMetricSize
+}
+
+// checkMetricName rejects a metric name violating the name policy, writing the error
+// response and reporting whether the update may proceed
+func (c *Config) checkMetricName(w http.ResponseWriter, name string) bool {
+	if err := c.NamePolicy.Check(name); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+// isReservedName reports whether name is reserved for the server's own metrics
+func (c *Config) isReservedName(name string) bool {
+	return c.ReservedPrefix != "" && strings.HasPrefix(name, c.ReservedPrefix)
+}
+
+// recordExemplar records the trace of r, if any, as the exemplar of a counter update.
+// The OpenMetrics exporter attaches the trace ID and delta to the counter.
+func (c *Config) recordExemplar(r *http.Request, name string, delta int64) {
+	if c.Exemplars == nil {
+		return
+	}
+	if traceID := exporter.TraceID(r); traceID != "" {
+		c.Exemplars.Record(name, exporter.Exemplar{TraceID: traceID, Value: float64(delta), Timestamp: time.Now()})
+	}
+}
+
+// recordBatchExemplars records the trace of r as the exemplar of every counter in an
+// applied batch
+func (c *Config) recordBatchExemplars(r *http.Request, metrics []models.Metrics) {
+	for _, metric := range metrics {
+		if metric.MType == CounterType {
+			c.recordExemplar(r, metric.ID, *metric.Delta)
+		}
+	}
+}
+
+// recordRate records the delta of a counter update in the rate tracker, unless s caps the
+// number of metrics and dropped the write of a new counter
+func (c *Config) recordRate(s storage.Storage, name string, delta int64) {
+	if c.CounterRates == nil {
+		return
+	}
+	if _, ok := s.(storage.CapacityChecker); ok {
+		if _, ok := s.GetCounter(name); !ok {
+			return
+		}
+	}
+	c.CounterRates.Add(name, delta)
+}
+
+// CounterDeltaHeader carries the delta applied by a counter update when enabled
+const CounterDeltaHeader = "X-Counter-Delta"
+
+// setCounterDelta adds the X-Counter-Delta header for an applied counter delta, if enabled
+func (c *Config) setCounterDelta(w http.ResponseWriter, delta int64) {
+	if c.CounterDeltaHeader {
+		w.Header().Set(CounterDeltaHeader, strconv.FormatInt(delta, 10))
+	}
+}
+
+// checkGaugeValue rejects a NaN or infinite gauge or float counter value, writing the error
+// response and reporting whether the update may proceed. Such values cannot be encoded as
+// JSON, so storing them as is would break reads of the metric. In lenient mode the value
+// is set to 0 with a warning.
+func (c *Config) checkGaugeValue(w http.ResponseWriter, name string, value *float64) bool {
+	if !math.IsNaN(*value) && !math.IsInf(*value, 0) {
+		return true
+	}
+	if c.LenientFloats {
+		log.Warn().Str("metric", name).Float64("value", *value).Msg("Coerced non-finite value to 0")
+		*value = 0
+		return true
+	}
+	http.Error(w, "value must be finite", http.StatusBadRequest)
+	return false
+}
+
+// checkMetricTypes rejects metrics conflicting with their first-seen type,
+// writing the error response and reporting whether the update may proceed.
+// The types are recorded by MetricTypes.Record once the metrics are stored.
+func (c *Config) checkMetricTypes(w http.ResponseWriter, metrics ...models.Metrics) bool {
+	if err := c.MetricTypes.Check(metrics...); err != nil {
+		log.Warn().Err(err).Msg("Rejected metric update with conflicting type")
+		http.Error(w, err.Error(), http.StatusConflict)
+		return false
+	}
+	return true
+}
+
+// checkCapacity rejects updates that would add more new metrics than s has room for
+// with 507 Insufficient Storage, reporting whether the update may proceed
+func checkCapacity(w http.ResponseWriter, s storage.Storage, metrics ...models.Metrics) bool {
+	limited, ok := s.(storage.CapacityChecker)
+	if !ok {
+		return true
+	}
+	if err := limited.CheckCapacity(metrics...); err != nil {
+		http.Error(w, err.Error(), http.StatusInsufficientStorage)
+		return false
+	}
+	return true
+}
+
+// floatCounterStore returns the float counters of s, a storage bound to the request context
+// by storage.WithContext, rejecting the update with 400 Bad Request if s does not keep them
+func floatCounterStore(w http.ResponseWriter, s storage.Storage) (storage.FloatCounters, bool) {
+	floats, ok := s.(storage.FloatCounters)
+	if !ok {
+		http.Error(w, "Float counters are not supported by the storage", http.StatusBadRequest)
+	}
+	return floats, ok
+}
+
 // extractIPAddress extracts the client IP address from the request.
 // It checks X-Real-IP and X-Forwarded-For headers first, then falls back to RemoteAddr.
 func extractIPAddress(r *http.Request) string {
@@ -69,15 +224,167 @@ func PingHandler(dbStorage *storage.DBStorage) http.HandlerFunc {
 	}
 }
 
+// RetryStatsHandler serves the attempt, retry and failure counts of database
+// operations as JSON, so that a degrading database shows up before requests fail.
+func RetryStatsHandler(dbStorage *storage.DBStorage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(dbStorage.RetryStats())
+	}
+}
+
+// HealthHandler handles the /healthz readiness endpoint.
+// Memory storage is always healthy, database storage must answer a ping and
+// file-backed storage must be able to write to its storage directory.
+func HealthHandler(mainStorage storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch s := mainStorage.(type) {
+		case *storage.DBStorage:
+			err = s.Ping()
+		case *storage.MemStorage:
+			err = s.CheckFileWritable()
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msg("Health check failed")
+			http.Error(w, "Storage unavailable", http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// ResetHandler handles POST /admin/reset, deleting all stored metrics and the recorded
+// metric types. It is meant for test and staging servers, which route it only when
+// admin endpoints are enabled. Returns 501 if the storage cannot be reset.
+func ResetHandler(s storage.Storage, cfg *Config) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		var err error
+		switch store := s.(type) {
+		case *storage.DBStorage:
+			err = store.ResetCtx(r.Context())
+		case storage.Resetter:
+			err = store.Reset()
+		default:
+			http.Error(w, "Storage does not support reset", http.StatusNotImplemented)
+			return
+		}
+
+		if err != nil {
+			log.Error().Err(err).Msg("Failed to reset storage")
+			http.Error(w, "Failed to reset storage", http.StatusInternalServerError)
+			return
+		}
+		cfg.MetricTypes.Reset()
+		cfg.CounterRates.Reset()
+
+		log.Warn().Str("ip", extractIPAddress(r)).Msg("All metrics were reset")
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte("OK"))
+	}
+}
+
+// CounterRate is the increase of a counter over a recent window
+type CounterRate struct {
+	ID        string  `json:"id"`
+	Window    string  `json:"window"`
+	Delta     int64   `json:"delta"`
+	PerSecond float64 `json:"per_second"`
+}
+
+// RateHandler handles GET /rate/{name}?window=60s, returning the sum of the deltas
+// applied to a counter of s within the window and the resulting rate per second as JSON.
+// The window defaults to, and may not exceed, the window tracked by rates, and may not
+// be shorter than its resolution; it is rounded up to whole buckets of the resolution.
+// Counters stored in s without a tracked delta have a rate of 0.
+func RateHandler(s storage.Storage, rates *storage.CounterRates) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		name := chi.URLParam(r, "name")
+
+		window := rates.Window()
+		if param := r.URL.Query().Get("window"); param != "" {
+			d, err := time.ParseDuration(param)
+			if err != nil || d <= 0 {
+				http.Error(w, "invalid window", http.StatusBadRequest)
+				return
+			}
+			if d > window {
+				http.Error(w, "window exceeds the tracked "+window.String(), http.StatusBadRequest)
+				return
+			}
+			if d < rates.Resolution() {
+				http.Error(w, "window is shorter than the resolution "+rates.Resolution().String(), http.StatusBadRequest)
+				return
+			}
+			window = rates.Span(d)
+		}
+
+		delta, ok := rates.Delta(name, window)
+		if !ok {
+			// Idle counters are forgotten by rates, and none are tracked before the first update
+			if _, ok := storage.WithContext(r.Context(), s).GetCounter(name); !ok {
+				http.Error(w, "not found", http.StatusNotFound)
+				return
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(CounterRate{
+			ID:        name,
+			Window:    window.String(),
+			Delta:     delta,
+			PerSecond: float64(delta) / window.Seconds(),
+		})
+	}
+}
+
+// BuildInfo describes the build of the running server binary
+type BuildInfo struct {
+	Version   string `json:"build_version"`
+	Date      string `json:"build_date"`
+	Commit    string `json:"build_commit"`
+	GoVersion string `json:"go_version"`
+}
+
+// BuildInfoHandler handles GET /debug/build, returning the build of the running server
+// as JSON, so that the deployed version can be confirmed at runtime
+func BuildInfoHandler(info BuildInfo) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(info)
+	}
+}
+
 // UpdateHandler handles legacy URL-based metric updates via POST requests.
 // URL format: /update/{type}/{name}/{value}
 // Supports both "gauge" and "counter" metric types.
-func UpdateHandler(s storage.Storage) http.HandlerFunc {
+func UpdateHandler(s storage.Storage, auditSubject *audit.Subject, cfg *Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
 		typ := chi.URLParam(r, "type")
 		name := chi.URLParam(r, "name")
 		value := chi.URLParam(r, "value")
 
+		if cfg.metricTooLarge(len(typ) + len(name) + len(value)) {
+			http.Error(w, "metric exceeds size limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if cfg.isReservedName(name) {
+			http.Error(w, "metric name is reserved", http.StatusForbidden)
+			return
+		}
+
+		if !cfg.checkMetricName(w, name) {
+			return
+		}
+
+		change := audit.MetricChange{ID: name, MType: typ}
+		metric := models.Metrics{ID: name, MType: typ}
 		switch typ {
 		case GaugeType:
 			v, err := strconv.ParseFloat(value, 64)
@@ -85,14 +392,30 @@ func UpdateHandler(s storage.Storage) http.HandlerFunc {
 				http.Error(w, "invalid gauge value", http.StatusBadRequest)
 				return
 			}
-			s.UpdateGauge(name, v)
+			if !cfg.checkGaugeValue(w, name, &v) {
+				return
+			}
+			if !checkCapacity(w, s, metric) || !cfg.checkMetricTypes(w, metric) {
+				return
+			}
+			store.UpdateGauge(name, v)
+			cfg.MetricTypes.Record(metric)
+			change.Value = &v
 		case CounterType:
 			v, err := strconv.ParseInt(value, 10, 64)
 			if err != nil {
 				http.Error(w, "invalid counter value", http.StatusBadRequest)
 				return
 			}
-			s.UpdateCounter(name, v)
+			if !checkCapacity(w, s, metric) || !cfg.checkMetricTypes(w, metric) {
+				return
+			}
+			store.UpdateCounter(name, v)
+			cfg.MetricTypes.Record(metric)
+			cfg.recordExemplar(r, name, v)
+			cfg.recordRate(s, name, v)
+			cfg.setCounterDelta(w, v)
+			change.Delta = &v
 		default:
 			http.Error(w, "unknown metric type", http.StatusBadRequest)
 			return
@@ -100,6 +423,10 @@ func UpdateHandler(s storage.Storage) http.HandlerFunc {
 
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte("OK"))
+
+		if auditSubject != nil && auditSubject.HasObservers() {
+			auditSubject.Notify(audit.NewChangeEvent(extractIPAddress(r), []audit.MetricChange{change}))
+		}
 	}
 }
 
@@ -108,17 +435,19 @@ func UpdateHandler(s storage.Storage) http.HandlerFunc {
 // Returns the metric value as plain text or 404 if not found.
 func ValueHandler(s storage.Storage) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
 		typ := chi.URLParam(r, "type")
 		name := chi.URLParam(r, "name")
 
 		switch typ {
 		case GaugeType:
-			if v, ok := s.GetGauge(name); ok {
+			if v, ok := store.GetGauge(name); ok {
 				w.Write([]byte(strconv.FormatFloat(v, 'f', -1, 64)))
 				return
 			}
 		case CounterType:
-			if v, ok := s.GetCounter(name); ok {
+			if v, ok := store.GetCounter(name); ok {
 				w.Write([]byte(strconv.FormatInt(v, 10)))
 				return
 			}
@@ -128,102 +457,458 @@ func ValueHandler(s storage.Storage) http.HandlerFunc {
 	}
 }
 
+// metricMeta is the response of MetaHandler. Counters hold their running total in Delta,
+// like in the JSON value API.
+type metricMeta struct {
+	models.Metrics
+
+	// LastUpdated is when the metric was last written in RFC 3339 format, omitted if
+	// the storage does not record it
+	LastUpdated string `json:"last_updated,omitempty"`
+}
+
+// MetaHandler handles GET /value/{type}/{name}/meta, returning the metric as JSON
+// together with when it was last written, so that metrics of stale agents stand out.
+// Returns 404 if the metric is not found.
+func MetaHandler(s storage.Storage) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
+		typ := chi.URLParam(r, "type")
+		name := chi.URLParam(r, "name")
+
+		meta := metricMeta{Metrics: models.Metrics{ID: name, MType: typ}}
+		found := false
+		switch typ {
+		case GaugeType:
+			if v, ok := store.GetGauge(name); ok {
+				meta.Value = &v
+				found = true
+			}
+		case CounterType:
+			if v, ok := store.GetCounter(name); ok {
+				meta.Delta = &v
+				found = true
+			}
+		case FloatCounterType:
+			if floats, ok := store.(storage.FloatCounters); ok {
+				if v, ok := floats.GetFloatCounter(name); ok {
+					meta.Value = &v
+					found = true
+				}
+			}
+		}
+		if !found {
+			http.Error(w, "metric not found", http.StatusNotFound)
+			return
+		}
+
+		if ts, ok := s.(storage.Timestamped); ok {
+			if updated, ok := ts.LastUpdated(typ, name); ok {
+				meta.LastUpdated = updated.UTC().Format(time.RFC3339)
+			}
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(meta)
+	}
+}
+
+// pageBuffers holds the buffers the metrics page is built in
+var pageBuffers = pool.New(func() *bytes.Buffer { return new(bytes.Buffer) })
+
+// rootPageCache is the metrics page last built by a RootHandler
+type rootPageCache struct {
+	mu      sync.Mutex
+	page    []byte
+	version uint64
+	built   time.Time
+}
+
 // RootHandler handles the root endpoint showing all metrics in HTML format.
-// Returns an HTML page listing all gauge and counter metrics.
-func RootHandler(s storage.Storage) http.HandlerFunc {
+// Returns an HTML page listing the gauges, the counters and then the float counters, each
+// sorted by name.
+// Clients preferring application/json over text/html in their Accept header get the
+// same list as a JSON array of metrics instead, streamed as it is read from storages
+// implementing storage.Streamer. The optional query parameters filter
+// (a substring of the metric name), offset and limit select a page of the list; pages
+// with parameters and JSON listings are not cached.
+func RootHandler(s storage.Storage, cfg *Config) http.HandlerFunc {
+	versioned, _ := s.(storage.Versioned)
+	cache := &rootPageCache{}
+
 	return func(w http.ResponseWriter, r *http.Request) {
-		g, c := s.GetAll()
+		store := storage.WithContext(r.Context(), s)
+
+		view, err := parseRootView(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Add("Vary", "Accept")
+		if prefersJSON(r.Header.Get("Accept")) {
+			w.Header().Set("Content-Type", "application/json")
+			if streamer, ok := s.(storage.Streamer); ok {
+				streamRootMetrics(r.Context(), w, streamer, view)
+				return
+			}
+			json.NewEncoder(w).Encode(rootMetrics(store, view))
+			return
+		}
 		w.Header().Set("Content-Type", "text/html")
-		w.Write([]byte("<html><body><h1>Metrics</h1><ul>"))
-		for k, v := range g {
-			fmt.Fprintf(w, "<li>%s (gauge): %f</li>", k, v)
+
+		ttl := cfg.RootCacheTTL
+		if ttl <= 0 || view != (rootView{}) {
+			buf := pageBuffers.Get()
+			defer pageBuffers.Put(buf)
+			writeRootPage(buf, store, view)
+			w.Write(buf.Bytes())
+			return
+		}
+
+		w.Write(cache.get(store, versioned, ttl))
+	}
+}
+
+// rootView selects the metrics listed by RootHandler; the zero value lists all
+type rootView struct {
+	filter string // substring of the listed metric names
+	offset int    // number of matching metrics skipped
+	limit  int    // maximum number of metrics listed (0 for no limit)
+}
+
+// parseRootView reads the filter, offset and limit query parameters of r
+func parseRootView(r *http.Request) (rootView, error) {
+	query := r.URL.Query()
+	view := rootView{filter: query.Get("filter")}
+
+	if val := query.Get("offset"); val != "" {
+		offset, err := strconv.Atoi(val)
+		if err != nil || offset < 0 {
+			return rootView{}, errors.New("offset must be a non-negative integer")
+		}
+		view.offset = offset
+	}
+	if val := query.Get("limit"); val != "" {
+		limit, err := strconv.Atoi(val)
+		if err != nil || limit <= 0 {
+			return rootView{}, errors.New("limit must be a positive integer")
+		}
+		view.limit = limit
+	}
+	return view, nil
+}
+
+// get returns the cached page, rebuilding it if it is older than ttl and, for a
+// versioned storage, a metric has been written since it was built
+func (c *rootPageCache) get(store storage.Storage, versioned storage.Versioned, ttl time.Duration) []byte {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// Read the version before the metrics, so that a concurrent write leads to a rebuild
+	var version uint64
+	if versioned != nil {
+		version = versioned.Version()
+	}
+	if c.page != nil && (time.Since(c.built) < ttl || versioned != nil && version == c.version) {
+		return c.page
+	}
+
+	buf := pageBuffers.Get()
+	defer pageBuffers.Put(buf)
+	writeRootPage(buf, store, rootView{})
+
+	// The buffer goes back to the pool, so the cached page needs its own copy
+	c.page = append(c.page[:0:0], buf.Bytes()...)
+	c.version = version
+	c.built = time.Now()
+	return c.page
+}
+
+// prefersJSON reports whether the Accept header ranks application/json above text/html
+func prefersJSON(accept string) bool {
+	var jsonQ, htmlQ float64
+	for _, part := range strings.Split(accept, ",") {
+		mediaType, params, _ := strings.Cut(part, ";")
+		q := 1.0
+		for _, param := range strings.Split(params, ";") {
+			if val, ok := strings.CutPrefix(strings.TrimSpace(param), "q="); ok {
+				if v, err := strconv.ParseFloat(val, 64); err == nil {
+					q = v
+				}
+			}
+		}
+		switch strings.TrimSpace(strings.ToLower(mediaType)) {
+		case "application/json":
+			jsonQ = q
+		case "text/html":
+			htmlQ = q
+		}
+	}
+	return jsonQ > htmlQ
+}
+
+// rootMetrics returns the metrics of store selected by view, in the order of the HTML page
+func rootMetrics(store storage.Storage, view rootView) []models.Metrics {
+	g, c := store.GetAll()
+	f := allFloatCounters(store)
+	gaugeNames, counterNames, floatNames := view.names(g, c, f)
+
+	metrics := make([]models.Metrics, 0, len(gaugeNames)+len(counterNames)+len(floatNames))
+	for _, k := range gaugeNames {
+		value := g[k]
+		metrics = append(metrics, models.Metrics{ID: k, MType: GaugeType, Value: &value})
+	}
+	for _, k := range counterNames {
+		delta := c[k]
+		metrics = append(metrics, models.Metrics{ID: k, MType: CounterType, Delta: &delta})
+	}
+	for _, k := range floatNames {
+		value := f[k]
+		metrics = append(metrics, models.Metrics{ID: k, MType: FloatCounterType, Value: &value})
+	}
+	return metrics
+}
+
+// allFloatCounters returns the float counters of store, or nil if it does not keep any
+func allFloatCounters(store storage.Storage) map[string]float64 {
+	if floats, ok := store.(storage.FloatCounters); ok {
+		return floats.GetAllFloatCounters()
+	}
+	return nil
+}
+
+// errPageComplete stops streaming once the metrics of a page have been written
+var errPageComplete = errors.New("page complete")
+
+// streamRootMetrics writes the metrics of streamer selected by view to w as a JSON array,
+// encoding each metric as it is read so that memory use does not grow with their number.
+// Once the array has been started a failure can only be logged, leaving it unterminated.
+func streamRootMetrics(ctx context.Context, w http.ResponseWriter, streamer storage.Streamer, view rootView) {
+	enc := json.NewEncoder(w)
+	skip, remaining := view.offset, view.limit
+	started := false
+
+	err := streamer.StreamAll(ctx, func(m models.Metrics) error {
+		if !strings.Contains(m.ID, view.filter) {
+			return nil
+		}
+		if skip > 0 {
+			skip--
+			return nil
+		}
+
+		sep := ","
+		if !started {
+			sep, started = "[", true
 		}
-		for k, v := range c {
-			fmt.Fprintf(w, "<li>%s (counter): %d</li>", k, v)
+		if _, err := io.WriteString(w, sep); err != nil {
+			return err
 		}
-		w.Write([]byte("</ul></body></html>"))
+		if err := enc.Encode(m); err != nil {
+			return err
+		}
+
+		if view.limit > 0 {
+			if remaining--; remaining == 0 {
+				return errPageComplete
+			}
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errPageComplete) {
+		log.Error().Err(err).Msg("Failed to stream metrics")
+		if !started {
+			http.Error(w, "Failed to list metrics", http.StatusInternalServerError)
+		}
+		return
+	}
+
+	if !started {
+		io.WriteString(w, "[")
+	}
+	io.WriteString(w, "]\n")
+}
+
+// names returns the sorted names of the gauges g, counters c and float counters f selected
+// by the view. The lists form one sequence for pagination, gauges first and float counters last.
+func (v rootView) names(g map[string]float64, c map[string]int64, f map[string]float64) (gaugeNames, counterNames, floatNames []string) {
+	gaugeNames = sortedNames(g, v.filter)
+	counterNames = sortedNames(c, v.filter)
+	floatNames = sortedNames(f, v.filter)
+
+	skip, remaining := v.offset, v.limit
+	if remaining == 0 {
+		remaining = len(gaugeNames) + len(counterNames) + len(floatNames)
+	}
+	page := func(names []string) []string {
+		n := min(skip, len(names))
+		names, skip = names[n:], skip-n
+		n = min(remaining, len(names))
+		remaining -= n
+		return names[:n]
+	}
+	return page(gaugeNames), page(counterNames), page(floatNames)
+}
+
+// writeRootPage writes the HTML page listing the metrics of store selected by view to buf
+func writeRootPage(buf *bytes.Buffer, store storage.Storage, view rootView) {
+	g, c := store.GetAll()
+	f := allFloatCounters(store)
+	gaugeNames, counterNames, floatNames := view.names(g, c, f)
+
+	buf.WriteString("<html><body><h1>Metrics</h1><ul>")
+	for _, k := range gaugeNames {
+		buf.WriteString("<li>")
+		buf.WriteString(k)
+		buf.WriteString(" (gauge): ")
+		buf.Write(strconv.AppendFloat(buf.AvailableBuffer(), g[k], 'f', 6, 64))
+		buf.WriteString("</li>")
+	}
+	for _, k := range counterNames {
+		buf.WriteString("<li>")
+		buf.WriteString(k)
+		buf.WriteString(" (counter): ")
+		buf.Write(strconv.AppendInt(buf.AvailableBuffer(), c[k], 10))
+		buf.WriteString("</li>")
 	}
+	for _, k := range floatNames {
+		buf.WriteString("<li>")
+		buf.WriteString(k)
+		buf.WriteString(" (floatcounter): ")
+		buf.Write(strconv.AppendFloat(buf.AvailableBuffer(), f[k], 'f', 6, 64))
+		buf.WriteString("</li>")
+	}
+	buf.WriteString("</ul></body></html>")
+}
+
+// sortedNames returns the keys of metrics containing filter in ascending order
+func sortedNames[V any](metrics map[string]V, filter string) []string {
+	names := make([]string, 0, len(metrics))
+	for name := range metrics {
+		if strings.Contains(name, filter) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return names
 }
 
 // UpdateJSONHandler handles JSON-based metric updates via POST /update/.
 // Accepts a single metric in JSON format and returns the updated metric.
-func UpdateJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.HandlerFunc {
+// Protobuf requests send a pb.Metric and get the updated metric back as one.
+func UpdateJSONHandler(s storage.Storage, auditSubject *audit.Subject, cfg *Config) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Failed to read request body", http.StatusBadRequest)
 			return
 		}
 
-		var metric models.Metrics
-		if err := json.Unmarshal(body, &metric); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		metric, err := decodeMetric(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
-		// Validate required fields
-		if metric.ID == "" || metric.MType == "" {
-			http.Error(w, "ID and MType are required", http.StatusBadRequest)
+		if err := metric.Validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		if cfg.metricTooLarge(metric.Size()) {
+			http.Error(w, "Metric exceeds size limit", http.StatusRequestEntityTooLarge)
+			return
+		}
+
+		if cfg.isReservedName(metric.ID) {
+			http.Error(w, "Metric name is reserved", http.StatusForbidden)
+			return
+		}
+
+		if !cfg.checkMetricName(w, metric.ID) {
 			return
 		}
 
 		switch metric.MType {
 		case GaugeType:
-			if metric.Value == nil {
-				http.Error(w, "Value is required for gauge metrics", http.StatusBadRequest)
+			if !cfg.checkGaugeValue(w, metric.ID, metric.Value) {
 				return
 			}
-			s.UpdateGauge(metric.ID, *metric.Value)
+			if !checkCapacity(w, s, metric) || !cfg.checkMetricTypes(w, metric) {
+				return
+			}
+			store.UpdateGauge(metric.ID, *metric.Value)
+			cfg.MetricTypes.Record(metric)
 			// Return the updated metric
 			response := models.Metrics{
 				ID:    metric.ID,
 				MType: metric.MType,
 				Value: metric.Value,
 			}
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
+			writeMetric(w, r, response)
 
 			// Trigger audit event after successful update
 			if auditSubject != nil && auditSubject.HasObservers() {
-				auditSubject.Notify(audit.Event{
-					Timestamp: time.Now().Unix(),
-					Metrics:   []string{metric.ID},
-					IPAddress: extractIPAddress(r),
-				})
+				auditSubject.Notify(audit.NewChangeEvent(extractIPAddress(r), audit.ChangesFromMetrics([]models.Metrics{metric})))
 			}
 
 		case CounterType:
-			if metric.Delta == nil {
-				http.Error(w, "Delta is required for counter metrics", http.StatusBadRequest)
+			if !checkCapacity(w, s, metric) || !cfg.checkMetricTypes(w, metric) {
 				return
 			}
-			s.UpdateCounter(metric.ID, *metric.Delta)
+			store.UpdateCounter(metric.ID, *metric.Delta)
+			cfg.MetricTypes.Record(metric)
+			cfg.recordExemplar(r, metric.ID, *metric.Delta)
+			cfg.recordRate(s, metric.ID, *metric.Delta)
 			// Get the updated value from storage
-			if updatedValue, ok := s.GetCounter(metric.ID); ok {
+			if updatedValue, ok := store.GetCounter(metric.ID); ok {
 				response := models.Metrics{
 					ID:    metric.ID,
 					MType: metric.MType,
 					Delta: &updatedValue,
 				}
-				w.Header().Set("Content-Type", "application/json")
-				json.NewEncoder(w).Encode(response)
+				cfg.setCounterDelta(w, *metric.Delta)
+				writeMetric(w, r, response)
 
 				// Trigger audit event after successful update
 				if auditSubject != nil && auditSubject.HasObservers() {
-					auditSubject.Notify(audit.Event{
-						Timestamp: time.Now().Unix(),
-						Metrics:   []string{metric.ID},
-						IPAddress: extractIPAddress(r),
-					})
+					auditSubject.Notify(audit.NewChangeEvent(extractIPAddress(r), audit.ChangesFromMetrics([]models.Metrics{metric})))
 				}
 			} else {
 				http.Error(w, "Failed to retrieve updated counter value", http.StatusInternalServerError)
 				return
 			}
 
-		default:
-			http.Error(w, "Unknown metric type", http.StatusBadRequest)
-			return
+		case FloatCounterType:
+			floats, ok := floatCounterStore(w, store)
+			if !ok || !cfg.checkGaugeValue(w, metric.ID, metric.Value) {
+				return
+			}
+			if !checkCapacity(w, s, metric) || !cfg.checkMetricTypes(w, metric) {
+				return
+			}
+			floats.UpdateFloatCounter(metric.ID, *metric.Value)
+			cfg.MetricTypes.Record(metric)
+			// Respond with the accumulated total like counters do
+			updatedValue, ok := floats.GetFloatCounter(metric.ID)
+			if !ok {
+				http.Error(w, "Failed to retrieve updated float counter value", http.StatusInternalServerError)
+				return
+			}
+			writeMetric(w, r, models.Metrics{
+				ID:    metric.ID,
+				MType: metric.MType,
+				Value: &updatedValue,
+			})
+
+			// Trigger audit event after successful update
+			if auditSubject != nil && auditSubject.HasObservers() {
+				auditSubject.Notify(audit.NewChangeEvent(extractIPAddress(r), audit.ChangesFromMetrics([]models.Metrics{metric})))
+			}
 		}
 	}
 }
@@ -232,6 +917,8 @@ func UpdateJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.Hand
 // Accepts a metric ID and type in JSON format and returns the current value.
 func ValueJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Failed to read request body", http.StatusBadRequest)
@@ -252,7 +939,7 @@ func ValueJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.Handl
 
 		switch metric.MType {
 		case GaugeType:
-			if value, ok := s.GetGauge(metric.ID); ok {
+			if value, ok := store.GetGauge(metric.ID); ok {
 				response := models.Metrics{
 					ID:    metric.ID,
 					MType: metric.MType,
@@ -275,7 +962,7 @@ func ValueJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.Handl
 			}
 
 		case CounterType:
-			if value, ok := s.GetCounter(metric.ID); ok {
+			if value, ok := store.GetCounter(metric.ID); ok {
 				response := models.Metrics{
 					ID:    metric.ID,
 					MType: metric.MType,
@@ -297,6 +984,34 @@ func ValueJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.Handl
 				return
 			}
 
+		case FloatCounterType:
+			floats, ok := store.(storage.FloatCounters)
+			if !ok {
+				http.Error(w, "Metric not found", http.StatusNotFound)
+				return
+			}
+			value, ok := floats.GetFloatCounter(metric.ID)
+			if !ok {
+				http.Error(w, "Metric not found", http.StatusNotFound)
+				return
+			}
+			response := models.Metrics{
+				ID:    metric.ID,
+				MType: metric.MType,
+				Value: &value,
+			}
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(response)
+
+			// Trigger audit event after successful retrieval
+			if auditSubject != nil && auditSubject.HasObservers() {
+				auditSubject.Notify(audit.Event{
+					Timestamp: time.Now().Unix(),
+					Metrics:   []string{metric.ID},
+					IPAddress: extractIPAddress(r),
+				})
+			}
+
 		default:
 			http.Error(w, "Unknown metric type", http.StatusBadRequest)
 			return
@@ -304,20 +1019,117 @@ func ValueJSONHandler(s storage.Storage, auditSubject *audit.Subject) http.Handl
 	}
 }
 
+// ValuesBatchHandler handles batch metric retrieval via POST /values/.
+// Accepts an array of metric IDs and types in JSON format and returns the current
+// values of the metrics found, omitting missing ones. Responds with 404 only if
+// none of the requested metrics exist.
+func ValuesBatchHandler(s storage.Storage, auditSubject *audit.Subject) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+
+		var requested []models.Metrics
+		if err := json.Unmarshal(body, &requested); err != nil {
+			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+			return
+		}
+
+		if len(requested) == 0 {
+			http.Error(w, "Empty batch not allowed", http.StatusBadRequest)
+			return
+		}
+
+		response := make([]models.Metrics, 0, len(requested))
+		for _, metric := range requested {
+			// Validate required fields
+			if metric.ID == "" || metric.MType == "" {
+				http.Error(w, "ID and MType are required for all metrics", http.StatusBadRequest)
+				return
+			}
+
+			switch metric.MType {
+			case GaugeType:
+				if value, ok := store.GetGauge(metric.ID); ok {
+					response = append(response, models.Metrics{
+						ID:    metric.ID,
+						MType: metric.MType,
+						Value: &value,
+					})
+				}
+
+			case CounterType:
+				if value, ok := store.GetCounter(metric.ID); ok {
+					response = append(response, models.Metrics{
+						ID:    metric.ID,
+						MType: metric.MType,
+						Delta: &value,
+					})
+				}
+
+			case FloatCounterType:
+				if floats, ok := store.(storage.FloatCounters); ok {
+					if value, ok := floats.GetFloatCounter(metric.ID); ok {
+						response = append(response, models.Metrics{
+							ID:    metric.ID,
+							MType: metric.MType,
+							Value: &value,
+						})
+					}
+				}
+
+			default:
+				http.Error(w, "Unknown metric type: "+metric.MType, http.StatusBadRequest)
+				return
+			}
+		}
+
+		if len(response) == 0 {
+			http.Error(w, "Metrics not found", http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(response)
+
+		// Trigger audit event after successful retrieval
+		if auditSubject != nil && auditSubject.HasObservers() {
+			metricNames := make([]string, len(response))
+			for i, metric := range response {
+				metricNames[i] = metric.ID
+			}
+			auditSubject.Notify(audit.Event{
+				Timestamp: time.Now().Unix(),
+				Metrics:   metricNames,
+				IPAddress: extractIPAddress(r),
+			})
+		}
+	}
+}
+
 // UpdateBatchHandler handles batch metric updates via POST /updates/.
 // Accepts an array of metrics in JSON format and processes them atomically.
+// Protobuf requests send a pb.UpdateMetricsRequest and get a pb.GetMetricsResponse back.
 // Uses database transactions for DBStorage, sequential processing for others.
-func UpdateBatchHandler(s storage.Storage, auditSubject *audit.Subject) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
+// Retries carrying the Idempotency-Key of an already applied batch get its response replayed
+// when an IdempotencyStore is set.
+func UpdateBatchHandler(s storage.Storage, auditSubject *audit.Subject, cfg *Config) http.HandlerFunc {
+	return idempotent(cfg.Idempotency, cfg.TrustedProxies, func(w http.ResponseWriter, r *http.Request) {
+		store := storage.WithContext(r.Context(), s)
+
 		body, err := io.ReadAll(r.Body)
 		if err != nil {
 			http.Error(w, "Failed to read request body", http.StatusBadRequest)
 			return
 		}
 
-		var metrics []models.Metrics
-		if err := json.Unmarshal(body, &metrics); err != nil {
-			http.Error(w, "Invalid JSON", http.StatusBadRequest)
+		metrics, err := decodeBatch(r, body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
 			return
 		}
 
@@ -327,55 +1139,92 @@ func UpdateBatchHandler(s storage.Storage, auditSubject *audit.Subject) http.Han
 			return
 		}
 
+		// Reject the whole batch if any single metric is malformed, oversized, reserved, not
+		// allowed by the name policy or not finite
+		for _, metric := range metrics {
+			if err := metric.Validate(); err != nil {
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if cfg.metricTooLarge(metric.Size()) {
+				http.Error(w, "Metric exceeds size limit", http.StatusRequestEntityTooLarge)
+				return
+			}
+			if cfg.isReservedName(metric.ID) {
+				http.Error(w, "Metric name is reserved", http.StatusForbidden)
+				return
+			}
+			if !cfg.checkMetricName(w, metric.ID) {
+				return
+			}
+			if (metric.MType == GaugeType || metric.MType == FloatCounterType) && !cfg.checkGaugeValue(w, metric.ID, metric.Value) {
+				return
+			}
+		}
+
+		// Float counters need a storage keeping them
+		floats, hasFloats := store.(storage.FloatCounters)
+		for _, metric := range metrics {
+			if metric.MType == FloatCounterType && !hasFloats {
+				http.Error(w, "Float counters are not supported by the storage", http.StatusBadRequest)
+				return
+			}
+		}
+
+		if !checkCapacity(w, s, metrics...) || !cfg.checkMetricTypes(w, metrics...) {
+			return
+		}
+
 		// Check if we have database storage for transaction support
 		if dbStorage, ok := s.(*storage.DBStorage); ok {
 			// Use database transaction for batch processing
-			if err := dbStorage.UpdateBatch(metrics); err != nil {
+			if err := dbStorage.UpdateBatchCtx(r.Context(), metrics); err != nil {
 				log.Error().Err(err).Msg("Failed to process batch update in database")
 				http.Error(w, "Failed to process batch update", http.StatusInternalServerError)
 				return
 			}
-		} else {
-			// For memory/file storage, process sequentially with proper locking
-			for _, metric := range metrics {
-				// Validate required fields
-				if metric.ID == "" || metric.MType == "" {
-					http.Error(w, "ID and MType are required for all metrics", http.StatusBadRequest)
+			cfg.recordBatchExemplars(r, metrics)
+			cfg.MetricTypes.Record(metrics...)
+			cfg.CounterRates.AddBatch(metrics)
+		} else if batchStorage, ok := s.(storage.BatchUpdater); ok {
+			// Memory/file storage applies the whole batch all-or-nothing
+			if err := batchStorage.UpdateBatch(metrics); err != nil {
+				if errors.Is(err, storage.ErrTooManyMetrics) {
+					http.Error(w, err.Error(), http.StatusInsufficientStorage)
 					return
 				}
-
+				log.Error().Err(err).Msg("Failed to process batch update")
+				http.Error(w, "Failed to process batch update", http.StatusInternalServerError)
+				return
+			}
+			cfg.recordBatchExemplars(r, metrics)
+			cfg.MetricTypes.Record(metrics...)
+			cfg.CounterRates.AddBatch(metrics)
+		} else {
+			// Other storages are updated sequentially
+			for _, metric := range metrics {
 				switch metric.MType {
 				case GaugeType:
-					if metric.Value == nil {
-						http.Error(w, "Value is required for gauge metrics", http.StatusBadRequest)
-						return
-					}
-					s.UpdateGauge(metric.ID, *metric.Value)
+					store.UpdateGauge(metric.ID, *metric.Value)
 
 				case CounterType:
-					if metric.Delta == nil {
-						http.Error(w, "Delta is required for counter metrics", http.StatusBadRequest)
-						return
-					}
-					s.UpdateCounter(metric.ID, *metric.Delta)
+					store.UpdateCounter(metric.ID, *metric.Delta)
+					cfg.recordExemplar(r, metric.ID, *metric.Delta)
+					cfg.recordRate(s, metric.ID, *metric.Delta)
 
-				default:
-					http.Error(w, "Unknown metric type: "+metric.MType, http.StatusBadRequest)
-					return
+				case FloatCounterType:
+					floats.UpdateFloatCounter(metric.ID, *metric.Value)
 				}
 			}
+			cfg.MetricTypes.Record(metrics...)
 		}
 
-		// Return success response
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-
 		// Return the processed metrics (optional, for confirmation)
 		response := make([]models.Metrics, 0, len(metrics))
 		for _, metric := range metrics {
 			switch metric.MType {
 			case GaugeType:
-				if value, ok := s.GetGauge(metric.ID); ok {
+				if value, ok := store.GetGauge(metric.ID); ok {
 					response = append(response, models.Metrics{
 						ID:    metric.ID,
 						MType: metric.MType,
@@ -383,31 +1232,29 @@ func UpdateBatchHandler(s storage.Storage, auditSubject *audit.Subject) http.Han
 					})
 				}
 			case CounterType:
-				if value, ok := s.GetCounter(metric.ID); ok {
+				if value, ok := store.GetCounter(metric.ID); ok {
 					response = append(response, models.Metrics{
 						ID:    metric.ID,
 						MType: metric.MType,
 						Delta: &value,
 					})
 				}
+			case FloatCounterType:
+				if value, ok := floats.GetFloatCounter(metric.ID); ok {
+					response = append(response, models.Metrics{
+						ID:    metric.ID,
+						MType: metric.MType,
+						Value: &value,
+					})
+				}
 			}
 		}
 
-		json.NewEncoder(w).Encode(response)
+		writeBatch(w, r, response)
 
 		// Trigger audit event after successful batch update
 		if auditSubject != nil && auditSubject.HasObservers() {
-			// Collect all metric names from the batch
-			metricNames := make([]string, 0, len(metrics))
-			for _, metric := range metrics {
-				metricNames = append(metricNames, metric.ID)
-			}
-
-			auditSubject.Notify(audit.Event{
-				Timestamp: time.Now().Unix(),
-				Metrics:   metricNames,
-				IPAddress: extractIPAddress(r),
-			})
+			auditSubject.Notify(audit.NewChangeEvent(extractIPAddress(r), audit.ChangesFromMetrics(metrics)))
 		}
-	}
+	})
 }
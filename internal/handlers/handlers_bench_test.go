@@ -18,7 +18,7 @@ import (
 // BenchmarkUpdateHandler benchmarks the legacy URL-based update handler
 func BenchmarkUpdateHandler(b *testing.B) {
 	s := storage.NewMemStorage()
-	handler := handlers.UpdateHandler(s)
+	handler := handlers.UpdateHandler(s, nil, handlers.DefaultConfig())
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -58,7 +58,7 @@ func BenchmarkValueHandler(b *testing.B) {
 // BenchmarkUpdateJSONHandler benchmarks the JSON-based update handler
 func BenchmarkUpdateJSONHandler(b *testing.B) {
 	s := storage.NewMemStorage()
-	handler := handlers.UpdateJSONHandler(s, nil)
+	handler := handlers.UpdateJSONHandler(s, nil, handlers.DefaultConfig())
 
 	value := 123.45
 	metric := models.Metrics{
@@ -105,7 +105,7 @@ func BenchmarkValueJSONHandler(b *testing.B) {
 // BenchmarkUpdateBatchHandler benchmarks the batch update handler
 func BenchmarkUpdateBatchHandler(b *testing.B) {
 	s := storage.NewMemStorage()
-	handler := handlers.UpdateBatchHandler(s, nil)
+	handler := handlers.UpdateBatchHandler(s, nil, handlers.DefaultConfig())
 
 	// Create batch of 10 metrics
 	metrics := make([]models.Metrics, 10)
@@ -148,7 +148,27 @@ func BenchmarkRootHandler(b *testing.B) {
 		s.UpdateCounter(fmt.Sprintf("counter_%d", i), int64(i))
 	}
 
-	handler := handlers.RootHandler(s)
+	handler := handlers.RootHandler(s, handlers.DefaultConfig())
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		w := httptest.NewRecorder()
+		handler(w, req)
+	}
+}
+
+// BenchmarkRootHandlerUncached benchmarks the root handler building the page on every request
+func BenchmarkRootHandlerUncached(b *testing.B) {
+	s := storage.NewMemStorage()
+	for i := 0; i < 50; i++ {
+		s.UpdateGauge(fmt.Sprintf("gauge_%d", i), float64(i))
+		s.UpdateCounter(fmt.Sprintf("counter_%d", i), int64(i))
+	}
+
+	cfg := handlers.DefaultConfig()
+	cfg.RootCacheTTL = 0
+	handler := handlers.RootHandler(s, cfg)
 
 	b.ResetTimer()
 	for i := 0; i < b.N; i++ {
@@ -2,20 +2,29 @@ package handlers
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
 	"net/http"
 	"net/http/httptest"
+	"path/filepath"
+	"slices"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/mutualEvg/metrics-server/internal/audit"
+	"github.com/mutualEvg/metrics-server/internal/exporter"
 	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/mutualEvg/metrics-server/storage"
 )
 
 func TestUpdateHandler(t *testing.T) {
 	store := storage.NewMemStorage()
-	handler := UpdateHandler(store)
+	handler := UpdateHandler(store, nil, DefaultConfig())
 
 	tests := []struct {
 		name           string
@@ -143,12 +152,59 @@ func TestValueHandler(t *testing.T) {
 	}
 }
 
+// recordingObserver collects the audit events it is notified of
+type recordingObserver struct {
+	events []audit.Event
+}
+
+func (o *recordingObserver) Notify(event audit.Event) error {
+	o.events = append(o.events, event)
+	return nil
+}
+
+func TestUpdateHandlerAudit(t *testing.T) {
+	observer := &recordingObserver{}
+	subject := audit.NewSubject()
+	subject.Attach(observer)
+
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(storage.NewMemStorage(), subject, DefaultConfig()))
+
+	for _, target := range []string{"/update/gauge/cpu/1.5", "/update/counter/requests/2", "/update/gauge/cpu/invalid"} {
+		req := httptest.NewRequest("POST", target, nil)
+		req.Header.Set("X-Real-IP", "192.168.1.10")
+		r.ServeHTTP(httptest.NewRecorder(), req)
+	}
+
+	// The rejected update is not audited
+	if len(observer.events) != 2 {
+		t.Fatalf("Expected 2 audit events, got %d: %+v", len(observer.events), observer.events)
+	}
+	for i, name := range []string{"cpu", "requests"} {
+		event := observer.events[i]
+		if len(event.Metrics) != 1 || event.Metrics[0] != name {
+			t.Errorf("Expected event for %s, got %v", name, event.Metrics)
+		}
+		if event.IPAddress != "192.168.1.10" {
+			t.Errorf("Expected IP 192.168.1.10, got %q", event.IPAddress)
+		}
+	}
+
+	// The events carry the written values
+	if c := observer.events[0].Changes; len(c) != 1 || c[0].MType != "gauge" || c[0].Value == nil || *c[0].Value != 1.5 {
+		t.Errorf("Expected gauge change to 1.5, got %+v", c)
+	}
+	if c := observer.events[1].Changes; len(c) != 1 || c[0].MType != "counter" || c[0].Delta == nil || *c[0].Delta != 2 {
+		t.Errorf("Expected counter change by 2, got %+v", c)
+	}
+}
+
 func TestRootHandler(t *testing.T) {
 	store := storage.NewMemStorage()
 	store.UpdateGauge("cpu", 45.5)
 	store.UpdateCounter("requests", 123)
 
-	handler := RootHandler(store)
+	handler := RootHandler(store, DefaultConfig())
 
 	req := httptest.NewRequest("GET", "/", nil)
 	w := httptest.NewRecorder()
@@ -169,9 +225,236 @@ func TestRootHandler(t *testing.T) {
 	}
 }
 
+func TestRootHandlerOrderAndPagination(t *testing.T) {
+	store := storage.NewMemStorage()
+	for _, name := range []string{"Sys", "Alloc", "HeapAlloc", "Frees"} {
+		store.UpdateGauge(name, 1)
+	}
+	for _, name := range []string{"PollCount", "AllocCount"} {
+		store.UpdateCounter(name, 1)
+	}
+	store.UpdateFloatCounter("AllocBytes", 0.5)
+	handler := RootHandler(store, DefaultConfig())
+
+	// listed returns the metric names of the page in order
+	listed := func(t *testing.T, target string) []string {
+		t.Helper()
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+		var names []string
+		for _, item := range strings.Split(w.Body.String(), "<li>")[1:] {
+			names = append(names, item[:strings.Index(item, " (")])
+		}
+		return names
+	}
+
+	tests := []struct {
+		target string
+		want   []string
+	}{
+		{"/", []string{"Alloc", "Frees", "HeapAlloc", "Sys", "AllocCount", "PollCount", "AllocBytes"}},
+		{"/?limit=2", []string{"Alloc", "Frees"}},
+		{"/?offset=3&limit=2", []string{"Sys", "AllocCount"}},
+		{"/?offset=5", []string{"PollCount", "AllocBytes"}},
+		{"/?offset=10", nil},
+		{"/?filter=Alloc", []string{"Alloc", "HeapAlloc", "AllocCount", "AllocBytes"}},
+		{"/?filter=Alloc&offset=1&limit=1", []string{"HeapAlloc"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			// Repeated requests list the metrics in the same order
+			for i := 0; i < 3; i++ {
+				if got := listed(t, tt.target); !slices.Equal(got, tt.want) {
+					t.Fatalf("Expected %v, got %v", tt.want, got)
+				}
+			}
+		})
+	}
+
+	for _, target := range []string{"/?limit=0", "/?limit=x", "/?offset=-1"} {
+		w := httptest.NewRecorder()
+		handler(w, httptest.NewRequest("GET", target, nil))
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d for %s, got %d", http.StatusBadRequest, target, w.Code)
+		}
+	}
+}
+
+func TestRootHandlerContentNegotiation(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateGauge("cpu", 45.5)
+	store.UpdateCounter("requests", 123)
+	store.UpdateFloatCounter("bytes", 0.25)
+	handler := RootHandler(store, DefaultConfig())
+
+	tests := []struct {
+		accept      string
+		contentType string
+	}{
+		{"", "text/html"},
+		{"text/html", "text/html"},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", "text/html"},
+		{"application/json", "application/json"},
+		{"text/html;q=0.5, application/json", "application/json"},
+		{"application/json;q=0.5, text/html", "text/html"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.accept, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			if tt.accept != "" {
+				req.Header.Set("Accept", tt.accept)
+			}
+			w := httptest.NewRecorder()
+			handler(w, req)
+
+			if got := w.Header().Get("Content-Type"); got != tt.contentType {
+				t.Fatalf("Expected Content-Type %s, got %s", tt.contentType, got)
+			}
+			if w.Header().Get("Vary") != "Accept" {
+				t.Errorf("Expected Vary: Accept, got %q", w.Header().Get("Vary"))
+			}
+			if tt.contentType == "text/html" {
+				if !strings.HasPrefix(w.Body.String(), "<html>") || !strings.Contains(w.Body.String(), "bytes (floatcounter): 0.250000") {
+					t.Errorf("Expected an HTML page listing the float counter, got %s", w.Body.String())
+				}
+				return
+			}
+
+			var metrics []models.Metrics
+			if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+				t.Fatalf("Expected a JSON array of metrics: %v", err)
+			}
+			if len(metrics) != 3 {
+				t.Fatalf("Expected 3 metrics, got %d", len(metrics))
+			}
+			if m := metrics[0]; m.ID != "cpu" || m.MType != GaugeType || m.Value == nil || *m.Value != 45.5 || m.Delta != nil {
+				t.Errorf("Expected gauge cpu=45.5 first, got %+v", m)
+			}
+			if m := metrics[1]; m.ID != "requests" || m.MType != CounterType || m.Delta == nil || *m.Delta != 123 || m.Value != nil {
+				t.Errorf("Expected counter requests=123 second, got %+v", m)
+			}
+			if m := metrics[2]; m.ID != "bytes" || m.MType != FloatCounterType || m.Value == nil || *m.Value != 0.25 || m.Delta != nil {
+				t.Errorf("Expected float counter bytes=0.25 last, got %+v", m)
+			}
+		})
+	}
+
+	t.Run("paginated", func(t *testing.T) {
+		req := httptest.NewRequest("GET", "/?offset=1", nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, req)
+
+		var metrics []models.Metrics
+		if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+			t.Fatalf("Expected a JSON array of metrics: %v", err)
+		}
+		if len(metrics) != 2 || metrics[0].ID != "requests" || metrics[1].ID != "bytes" {
+			t.Errorf("Expected requests and bytes, got %+v", metrics)
+		}
+	})
+}
+
+// countingStorage counts GetAll calls of the wrapped storage, hiding its Version
+type countingStorage struct {
+	storage.Storage
+	getAll int
+}
+
+func (s *countingStorage) GetAll() (map[string]float64, map[string]int64) {
+	s.getAll++
+	return s.Storage.GetAll()
+}
+
+// versionedCountingStorage is a countingStorage exposing the wrapped storage's Version
+type versionedCountingStorage struct {
+	*countingStorage
+	versioned storage.Versioned
+}
+
+func (s *versionedCountingStorage) Version() uint64 {
+	return s.versioned.Version()
+}
+
+// getRootPage requests the metrics page from handler and returns its body
+func getRootPage(t *testing.T, handler http.HandlerFunc) string {
+	t.Helper()
+	w := httptest.NewRecorder()
+	handler(w, httptest.NewRequest("GET", "/", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	return w.Body.String()
+}
+
+func TestRootHandlerCache(t *testing.T) {
+	const ttl = 50 * time.Millisecond
+	cfg := DefaultConfig()
+	cfg.RootCacheTTL = ttl
+
+	t.Run("versioned storage", func(t *testing.T) {
+		mem := storage.NewMemStorage()
+		mem.UpdateGauge("cpu", 1)
+		store := &versionedCountingStorage{countingStorage: &countingStorage{Storage: mem}, versioned: mem}
+		handler := RootHandler(store, cfg)
+
+		first := getRootPage(t, handler)
+		time.Sleep(2 * ttl)
+		if page := getRootPage(t, handler); page != first || store.getAll != 1 {
+			t.Errorf("Expected the unchanged page to stay cached past the TTL, got %d builds", store.getAll)
+		}
+
+		// The page has been built more than the TTL ago, so a write shows at once
+		mem.UpdateGauge("cpu", 2)
+		if page := getRootPage(t, handler); !strings.Contains(page, "cpu (gauge): 2.000000") || store.getAll != 2 {
+			t.Errorf("Expected the page to be rebuilt after a write, got %d builds: %s", store.getAll, page)
+		}
+
+		// Writes within the TTL of the last build are shown once the TTL has passed
+		mem.UpdateGauge("cpu", 3)
+		if page := getRootPage(t, handler); !strings.Contains(page, "cpu (gauge): 2.000000") {
+			t.Errorf("Expected the page to be served from cache within the TTL, got %s", page)
+		}
+		time.Sleep(2 * ttl)
+		if page := getRootPage(t, handler); !strings.Contains(page, "cpu (gauge): 3.000000") || store.getAll != 3 {
+			t.Errorf("Expected the page to be rebuilt after the TTL, got %d builds: %s", store.getAll, page)
+		}
+	})
+
+	t.Run("unversioned storage", func(t *testing.T) {
+		store := &countingStorage{Storage: storage.NewMemStorage()}
+		handler := RootHandler(store, cfg)
+
+		getRootPage(t, handler)
+		getRootPage(t, handler)
+		if store.getAll != 1 {
+			t.Errorf("Expected one build within the TTL, got %d", store.getAll)
+		}
+		time.Sleep(2 * ttl)
+		getRootPage(t, handler)
+		if store.getAll != 2 {
+			t.Errorf("Expected a rebuild after the TTL, got %d builds", store.getAll)
+		}
+	})
+
+	t.Run("disabled", func(t *testing.T) {
+		store := &countingStorage{Storage: storage.NewMemStorage()}
+		handler := RootHandler(store, &Config{})
+
+		getRootPage(t, handler)
+		getRootPage(t, handler)
+		if store.getAll != 2 {
+			t.Errorf("Expected every request to build the page, got %d builds", store.getAll)
+		}
+	})
+}
+
 func TestUpdateJSONHandler(t *testing.T) {
 	store := storage.NewMemStorage()
-	handler := UpdateJSONHandler(store, nil)
+	handler := UpdateJSONHandler(store, nil, DefaultConfig())
 
 	tests := []struct {
 		name           string
@@ -357,7 +640,7 @@ func TestValueJSONHandler(t *testing.T) {
 
 func TestUpdateBatchHandler(t *testing.T) {
 	store := storage.NewMemStorage()
-	handler := UpdateBatchHandler(store, nil)
+	handler := UpdateBatchHandler(store, nil, DefaultConfig())
 
 	tests := []struct {
 		name           string
@@ -405,6 +688,18 @@ func TestUpdateBatchHandler(t *testing.T) {
 			expectedStatus: http.StatusBadRequest,
 			expectError:    true,
 		},
+		{
+			name: "misspelled metric type in batch",
+			metrics: []models.Metrics{
+				{
+					ID:    "cpu_usage",
+					MType: "guage",
+					Value: func() *float64 { v := 75.5; return &v }(),
+				},
+			},
+			expectedStatus: http.StatusBadRequest,
+			expectError:    true,
+		},
 	}
 
 	for _, tt := range tests {
@@ -426,3 +721,1015 @@ func TestUpdateBatchHandler(t *testing.T) {
 		})
 	}
 }
+
+func TestUpdateBatchHandlerAllOrNothing(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateCounter("PollCount", 1)
+
+	body := `[{"id":"Alloc","type":"gauge","value":1.5},{"id":"PollCount","type":"counter","delta":5},{"id":"Bad","type":"gauge"}]`
+	req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+
+	UpdateBatchHandler(store, nil, DefaultConfig())(w, req)
+
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+	}
+	if _, ok := store.GetGauge("Alloc"); ok {
+		t.Error("Expected no gauge of the rejected batch to be stored")
+	}
+	if v, _ := store.GetCounter("PollCount"); v != 1 {
+		t.Errorf("Expected PollCount to stay 1, got %d", v)
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	writable := storage.NewMemStorage()
+	writable.SetFileManager(storage.NewFileManager(filepath.Join(t.TempDir(), "metrics.json"), writable), false)
+
+	missingDir := storage.NewMemStorage()
+	missingDir.SetFileManager(storage.NewFileManager(filepath.Join(t.TempDir(), "missing", "metrics.json"), missingDir), false)
+
+	tests := []struct {
+		name           string
+		storage        storage.Storage
+		expectedStatus int
+	}{
+		{name: "Memory storage", storage: storage.NewMemStorage(), expectedStatus: http.StatusOK},
+		{name: "Writable file storage", storage: writable, expectedStatus: http.StatusOK},
+		{name: "Unwritable file storage", storage: missingDir, expectedStatus: http.StatusServiceUnavailable},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/healthz", nil)
+			w := httptest.NewRecorder()
+
+			HealthHandler(tt.storage)(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+		})
+	}
+}
+
+func TestOversizedMetricRejected(t *testing.T) {
+	store := storage.NewMemStorage()
+	longName := strings.Repeat("x", models.DefaultMaxMetricSize+1)
+
+	t.Run("JSON update", func(t *testing.T) {
+		body := `{"id":"` + longName + `","type":"gauge","value":1}`
+		req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateJSONHandler(store, nil, DefaultConfig())(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+		}
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		body := `[{"id":"ok","type":"gauge","value":1},{"id":"` + longName + `","type":"counter","delta":1}]`
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateBatchHandler(store, nil, DefaultConfig())(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+		}
+		if _, ok := store.GetGauge("ok"); ok {
+			t.Error("No metric of a rejected batch should be stored")
+		}
+	})
+
+	t.Run("URL update", func(t *testing.T) {
+		r := chi.NewRouter()
+		r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, DefaultConfig()))
+
+		req := httptest.NewRequest("POST", "/update/gauge/"+longName+"/1", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusRequestEntityTooLarge {
+			t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, w.Code)
+		}
+	})
+
+	t.Run("Limit disabled", func(t *testing.T) {
+		cfg := DefaultConfig()
+		cfg.MaxMetricSize = 0
+
+		body := `{"id":"` + longName + `","type":"gauge","value":1}`
+		req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateJSONHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d with limit disabled, got %d", http.StatusOK, w.Code)
+		}
+	})
+}
+
+func TestReservedNameRejected(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.ReservedPrefix = "_internal_"
+
+	store := storage.NewMemStorage()
+
+	t.Run("JSON update", func(t *testing.T) {
+		body := `{"id":"_internal_QueueDepth","type":"gauge","value":1}`
+		req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateJSONHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		body := `[{"id":"ok","type":"gauge","value":1},{"id":"_internal_Drops","type":"counter","delta":1}]`
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateBatchHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+		if _, ok := store.GetGauge("ok"); ok {
+			t.Error("No metric of a rejected batch should be stored")
+		}
+	})
+
+	t.Run("URL update", func(t *testing.T) {
+		r := chi.NewRouter()
+		r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+
+		req := httptest.NewRequest("POST", "/update/counter/_internal_Drops/1", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Expected status %d, got %d", http.StatusForbidden, w.Code)
+		}
+	})
+
+	t.Run("Internal write", func(t *testing.T) {
+		// The server's own code writes to storage directly and is not restricted
+		store.UpdateGauge("_internal_QueueDepth", 3)
+
+		r := chi.NewRouter()
+		r.Get("/value/{type}/{name}", ValueHandler(store))
+
+		req := httptest.NewRequest("GET", "/value/gauge/_internal_QueueDepth", nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+
+		if w.Code != http.StatusOK || w.Body.String() != "3" {
+			t.Errorf("Expected internal metric to be readable, got %d %q", w.Code, w.Body.String())
+		}
+	})
+
+	t.Run("Unreserved name", func(t *testing.T) {
+		body := `{"id":"internal_but_not_prefixed","type":"gauge","value":1}`
+		req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateJSONHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	})
+}
+
+func TestMetricNamePolicy(t *testing.T) {
+	policy, err := storage.NewNamePolicy(`^[a-z][a-z0-9_]*$`, `^debug_`)
+	if err != nil {
+		t.Fatalf("Failed to create policy: %v", err)
+	}
+	cfg := DefaultConfig()
+	cfg.NamePolicy = policy
+
+	store := storage.NewMemStorage()
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	r.Post("/update/", UpdateJSONHandler(store, nil, cfg))
+	r.Post("/updates/", UpdateBatchHandler(store, nil, cfg))
+
+	tests := []struct {
+		name        string
+		path        string
+		body        string
+		wantStatus  int
+		errContains string
+	}{
+		{"URL update allowed", "/update/gauge/heap_alloc/1", "", http.StatusOK, ""},
+		{"URL update not matching allowlist", "/update/gauge/HeapAlloc/1", "", http.StatusBadRequest, "HeapAlloc does not match"},
+		{"JSON update allowed", "/update/", `{"id":"poll_count","type":"counter","delta":1}`, http.StatusOK, ""},
+		{"JSON update matching denylist", "/update/", `{"id":"debug_trace","type":"gauge","value":1}`, http.StatusBadRequest, "debug_trace matches the denied pattern"},
+		{"batch allowed", "/updates/", `[{"id":"free_mem","type":"gauge","value":1}]`, http.StatusOK, ""},
+		{"batch with one disallowed name", "/updates/", `[{"id":"total_mem","type":"gauge","value":1},{"id":"user-42","type":"counter","delta":1}]`, http.StatusBadRequest, "user-42 does not match"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if !strings.Contains(w.Body.String(), tt.errContains) {
+				t.Errorf("Expected response containing %q, got %q", tt.errContains, w.Body.String())
+			}
+		})
+	}
+
+	if _, ok := store.GetGauge("total_mem"); ok {
+		t.Error("No metric of a rejected batch should be stored")
+	}
+}
+
+func TestMetricTypeConflictRejected(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MetricTypes = storage.NewTypeRegistry()
+
+	store := storage.NewMemStorage()
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+
+	post := func(path string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	if code := post("/update/gauge/Load/1.5"); code != http.StatusOK {
+		t.Fatalf("Expected first write to succeed, got %d", code)
+	}
+
+	t.Run("URL update", func(t *testing.T) {
+		if code := post("/update/counter/Load/1"); code != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, code)
+		}
+		if _, ok := store.GetCounter("Load"); ok {
+			t.Error("Conflicting counter should not be stored")
+		}
+	})
+
+	t.Run("JSON update", func(t *testing.T) {
+		body := `{"id":"Load","type":"counter","delta":1}`
+		req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateJSONHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+		}
+	})
+
+	t.Run("Batch update", func(t *testing.T) {
+		body := `[{"id":"Fresh","type":"gauge","value":1},{"id":"Load","type":"counter","delta":1}]`
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		w := httptest.NewRecorder()
+
+		UpdateBatchHandler(store, nil, cfg)(w, req)
+
+		if w.Code != http.StatusConflict {
+			t.Errorf("Expected status %d, got %d", http.StatusConflict, w.Code)
+		}
+		if _, ok := store.GetGauge("Fresh"); ok {
+			t.Error("No metric of a rejected batch should be stored")
+		}
+		// Fresh was not recorded, so it may still become a counter
+		if code := post("/update/counter/Fresh/1"); code != http.StatusOK {
+			t.Errorf("Expected Fresh to be accepted as counter, got %d", code)
+		}
+	})
+
+	t.Run("Same type", func(t *testing.T) {
+		if code := post("/update/gauge/Load/2"); code != http.StatusOK {
+			t.Errorf("Expected status %d, got %d", http.StatusOK, code)
+		}
+	})
+}
+
+// failingBatchStorage is a memory storage whose batch updates always fail
+type failingBatchStorage struct {
+	*storage.MemStorage
+}
+
+func (s failingBatchStorage) UpdateBatch([]models.Metrics) error {
+	return errors.New("disk full")
+}
+
+func TestFailedWriteDoesNotRecordType(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MetricTypes = storage.NewTypeRegistry()
+
+	body := `[{"id":"Load","type":"gauge","value":1}]`
+	req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+	w := httptest.NewRecorder()
+	UpdateBatchHandler(failingBatchStorage{storage.NewMemStorage()}, nil, cfg)(w, req)
+	if w.Code != http.StatusInternalServerError {
+		t.Fatalf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+	}
+
+	// The failed batch stored nothing, so Load may still become a counter
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(storage.NewMemStorage(), nil, cfg))
+	req = httptest.NewRequest("POST", "/update/counter/Load/1", nil)
+	w = httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+}
+
+func TestCounterDeltaHeader(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.CounterDeltaHeader = true
+
+	store := storage.NewMemStorage()
+	store.UpdateCounter("Requests", 10)
+
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	r.Post("/update/", UpdateJSONHandler(store, nil, cfg))
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		header string
+	}{
+		{name: "URL counter", path: "/update/counter/Requests/5", header: "5"},
+		{name: "JSON counter", path: "/update/", body: `{"id":"Requests","type":"counter","delta":7}`, header: "7"},
+		{name: "URL gauge", path: "/update/gauge/Load/1.5"},
+		{name: "JSON gauge", path: "/update/", body: `{"id":"Load","type":"gauge","value":2.5}`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", tt.path, strings.NewReader(tt.body))
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, req)
+
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+			if got := w.Header().Get(CounterDeltaHeader); got != tt.header {
+				t.Errorf("Expected %s %q, got %q", CounterDeltaHeader, tt.header, got)
+			}
+		})
+	}
+
+	// Both counter updates were applied on top of the initial value
+	if v, _ := store.GetCounter("Requests"); v != 22 {
+		t.Errorf("Expected total 22, got %d", v)
+	}
+
+	cfg.CounterDeltaHeader = false
+	req := httptest.NewRequest("POST", "/update/counter/Requests/1", nil)
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, req)
+	if got := w.Header().Get(CounterDeltaHeader); got != "" {
+		t.Errorf("Expected no %s header when disabled, got %q", CounterDeltaHeader, got)
+	}
+}
+
+func TestCounterUpdateRecordsExemplar(t *testing.T) {
+	exemplars := exporter.NewExemplarStore()
+	cfg := DefaultConfig()
+	cfg.Exemplars = exemplars
+
+	store := storage.NewMemStorage()
+
+	body := `{"id":"requests","type":"counter","delta":5}`
+	req := httptest.NewRequest("POST", "/update/", strings.NewReader(body))
+	req.Header.Set("traceparent", "00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01")
+	w := httptest.NewRecorder()
+
+	UpdateJSONHandler(store, nil, cfg)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	e, ok := exemplars.Get("requests")
+	if !ok {
+		t.Fatal("Expected an exemplar for the counter update")
+	}
+	if e.TraceID != "4bf92f3577b34da6a3ce929d0e0e4736" || e.Value != 5 {
+		t.Errorf("Unexpected exemplar %+v", e)
+	}
+
+	// Updates without a trace leave no exemplar
+	router := chi.NewRouter()
+	router.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	req = httptest.NewRequest("POST", "/update/counter/untraced/1", nil)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if _, ok := exemplars.Get("untraced"); ok {
+		t.Error("Expected no exemplar for an update without traceparent")
+	}
+}
+
+func TestValuesBatchHandler(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateGauge("Alloc", 1.5)
+	store.UpdateCounter("PollCount", 7)
+	handler := ValuesBatchHandler(store, nil)
+
+	tests := []struct {
+		name           string
+		body           string
+		expectedStatus int
+		expectedIDs    []string
+	}{
+		{
+			name:           "all found",
+			body:           `[{"id":"Alloc","type":"gauge"},{"id":"PollCount","type":"counter"}]`,
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"Alloc", "PollCount"},
+		},
+		{
+			name:           "partial results omit missing metrics",
+			body:           `[{"id":"Alloc","type":"gauge"},{"id":"Missing","type":"gauge"},{"id":"Alloc","type":"counter"}]`,
+			expectedStatus: http.StatusOK,
+			expectedIDs:    []string{"Alloc"},
+		},
+		{
+			name:           "none found",
+			body:           `[{"id":"Missing","type":"gauge"},{"id":"Other","type":"counter"}]`,
+			expectedStatus: http.StatusNotFound,
+		},
+		{
+			name:           "empty batch",
+			body:           `[]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "missing type",
+			body:           `[{"id":"Alloc"}]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "unknown type",
+			body:           `[{"id":"Alloc","type":"histogram"}]`,
+			expectedStatus: http.StatusBadRequest,
+		},
+		{
+			name:           "invalid JSON",
+			body:           `{"id":"Alloc","type":"gauge"}`,
+			expectedStatus: http.StatusBadRequest,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/values/", strings.NewReader(tt.body))
+			req.Header.Set("Content-Type", "application/json")
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != tt.expectedStatus {
+				t.Fatalf("Expected status %d, got %d", tt.expectedStatus, w.Code)
+			}
+			if tt.expectedStatus != http.StatusOK {
+				return
+			}
+
+			var response []models.Metrics
+			if err := json.NewDecoder(w.Body).Decode(&response); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if len(response) != len(tt.expectedIDs) {
+				t.Fatalf("Expected %d metrics, got %d", len(tt.expectedIDs), len(response))
+			}
+			for i, metric := range response {
+				if metric.ID != tt.expectedIDs[i] {
+					t.Errorf("Expected metric %s, got %s", tt.expectedIDs[i], metric.ID)
+				}
+				switch metric.MType {
+				case GaugeType:
+					if metric.Value == nil || *metric.Value != 1.5 {
+						t.Errorf("Expected gauge %s with value 1.5", metric.ID)
+					}
+				case CounterType:
+					if metric.Delta == nil || *metric.Delta != 7 {
+						t.Errorf("Expected counter %s with delta 7", metric.ID)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestMetaHandler(t *testing.T) {
+	before := time.Now().Add(-time.Second)
+	store := storage.NewMemStorage()
+	store.UpdateGauge("cpu_usage", 75.5)
+	store.UpdateCounter("requests", 100)
+	store.UpdateCounter("requests", 20)
+	store.UpdateFloatCounter("energy", 1.25)
+	store.UpdateFloatCounter("energy", 0.5)
+
+	router := chi.NewRouter()
+	router.Get("/value/{type}/{name}/meta", MetaHandler(store))
+
+	tests := []struct {
+		url       string
+		wantValue float64
+		wantDelta int64
+	}{
+		{"/value/gauge/cpu_usage/meta", 75.5, 0},
+		{"/value/counter/requests/meta", 0, 120},
+		{"/value/floatcounter/energy/meta", 1.75, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, tt.url, nil))
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != "application/json" {
+				t.Errorf("Expected JSON content type, got %q", ct)
+			}
+
+			var meta metricMeta
+			if err := json.NewDecoder(w.Body).Decode(&meta); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if meta.MType != "counter" && (meta.Value == nil || *meta.Value != tt.wantValue) {
+				t.Errorf("Expected value %v, got %v", tt.wantValue, meta.Value)
+			}
+			if meta.MType == "counter" && (meta.Delta == nil || *meta.Delta != tt.wantDelta) {
+				t.Errorf("Expected running total %d, got %v", tt.wantDelta, meta.Delta)
+			}
+
+			updated, err := time.Parse(time.RFC3339, meta.LastUpdated)
+			if err != nil {
+				t.Fatalf("Expected an RFC 3339 last_updated, got %q: %v", meta.LastUpdated, err)
+			}
+			if updated.Before(before.Truncate(time.Second)) || updated.After(time.Now()) {
+				t.Errorf("Expected last_updated around now, got %v", updated)
+			}
+		})
+	}
+
+	for _, url := range []string{"/value/gauge/nonexistent/meta", "/value/unknown/cpu_usage/meta"} {
+		w := httptest.NewRecorder()
+		router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, url, nil))
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status 404 for %s, got %d", url, w.Code)
+		}
+	}
+}
+
+func TestNonFiniteGaugeRejected(t *testing.T) {
+	store := storage.NewMemStorage()
+	cfg := DefaultConfig()
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	r.Post("/update/", UpdateJSONHandler(store, nil, cfg))
+	r.Post("/updates/", UpdateBatchHandler(store, nil, cfg))
+
+	post := func(path, body string) int {
+		req := httptest.NewRequest("POST", path, strings.NewReader(body))
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	tests := []struct {
+		name string
+		path string
+		body string
+	}{
+		{name: "URL NaN", path: "/update/gauge/Load/NaN"},
+		{name: "URL Inf", path: "/update/gauge/Load/Inf"},
+		{name: "URL +Inf", path: "/update/gauge/Load/+Inf"},
+		{name: "URL -Inf", path: "/update/gauge/Load/-Inf"},
+		{name: "URL overflow", path: "/update/gauge/Load/1e999"},
+		{name: "JSON NaN", path: "/update/", body: `{"id":"Load","type":"gauge","value":NaN}`},
+		{name: "JSON overflow", path: "/update/", body: `{"id":"Load","type":"gauge","value":1e999}`},
+		{name: "Batch NaN", path: "/updates/", body: `[{"id":"Fresh","type":"gauge","value":1},{"id":"Load","type":"gauge","value":NaN}]`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if code := post(tt.path, tt.body); code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, code)
+			}
+			if _, ok := store.GetGauge("Load"); ok {
+				t.Error("Non-finite gauge should not be stored")
+			}
+			if _, ok := store.GetGauge("Fresh"); ok {
+				t.Error("No metric of a rejected batch should be stored")
+			}
+		})
+	}
+
+	t.Run("Lenient", func(t *testing.T) {
+		cfg.LenientFloats = true
+		defer func() { cfg.LenientFloats = false }()
+
+		if code := post("/update/gauge/Load/-Inf", ""); code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+		}
+		if v, ok := store.GetGauge("Load"); !ok || v != 0 {
+			t.Errorf("Expected non-finite gauge stored as 0, got %v (found %v)", v, ok)
+		}
+	})
+}
+
+func TestCheckGaugeValue(t *testing.T) {
+	for _, v := range []float64{math.NaN(), math.Inf(1), math.Inf(-1)} {
+		value := v
+		if DefaultConfig().checkGaugeValue(httptest.NewRecorder(), "Load", &value) {
+			t.Errorf("Expected %v to be rejected", v)
+		}
+
+		lenient := &Config{LenientFloats: true}
+		if !lenient.checkGaugeValue(httptest.NewRecorder(), "Load", &value) || value != 0 {
+			t.Errorf("Expected %v to be coerced to 0 in lenient mode, got %v", v, value)
+		}
+	}
+
+	value := 1.5
+	if !DefaultConfig().checkGaugeValue(httptest.NewRecorder(), "Load", &value) || value != 1.5 {
+		t.Errorf("Expected finite value to pass unchanged, got %v", value)
+	}
+}
+
+func TestBuildInfoHandler(t *testing.T) {
+	info := BuildInfo{Version: "v1.2.3", Date: "2026-01-02", Commit: "abc123", GoVersion: "go1.24"}
+
+	req := httptest.NewRequest("GET", "/debug/build", nil)
+	w := httptest.NewRecorder()
+	BuildInfoHandler(info)(w, req)
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+	}
+	if got := w.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected Content-Type application/json, got %q", got)
+	}
+
+	var fields map[string]string
+	if err := json.NewDecoder(w.Body).Decode(&fields); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	want := map[string]string{
+		"build_version": "v1.2.3",
+		"build_date":    "2026-01-02",
+		"build_commit":  "abc123",
+		"go_version":    "go1.24",
+	}
+	for name, value := range want {
+		if fields[name] != value {
+			t.Errorf("Expected %s %q, got %q", name, value, fields[name])
+		}
+	}
+}
+
+func TestResetHandler(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.MetricTypes = storage.NewTypeRegistry()
+
+	store := storage.NewMemStorage()
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	r.Post("/admin/reset", ResetHandler(store, cfg))
+
+	post := func(path string) int {
+		req := httptest.NewRequest("POST", path, nil)
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, req)
+		return w.Code
+	}
+
+	post("/update/gauge/Alloc/1.5")
+	post("/update/counter/PollCount/3")
+
+	if code := post("/admin/reset"); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+
+	gauges, counters := store.GetAll()
+	if len(gauges) != 0 || len(counters) != 0 {
+		t.Errorf("Expected empty storage after reset, got %v and %v", gauges, counters)
+	}
+
+	// The recorded types were forgotten as well
+	if code := post("/update/counter/Alloc/1"); code != http.StatusOK {
+		t.Errorf("Expected a reset metric to accept a new type, got %d", code)
+	}
+}
+
+// unresettableStorage is a storage without a Reset method
+type unresettableStorage struct {
+	storage.Storage
+}
+
+func TestResetHandlerUnsupported(t *testing.T) {
+	req := httptest.NewRequest("POST", "/admin/reset", nil)
+	w := httptest.NewRecorder()
+	ResetHandler(unresettableStorage{storage.NewMemStorage()}, DefaultConfig())(w, req)
+
+	if w.Code != http.StatusNotImplemented {
+		t.Errorf("Expected status %d, got %d", http.StatusNotImplemented, w.Code)
+	}
+}
+
+func TestRateHandler(t *testing.T) {
+	rates := storage.NewCounterRates(time.Minute, time.Second)
+	cfg := DefaultConfig()
+	cfg.CounterRates = rates
+
+	store := storage.NewMemStorage()
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, cfg))
+	r.Post("/updates/", UpdateBatchHandler(store, nil, cfg))
+	r.Get("/rate/{name}", RateHandler(store, rates))
+
+	for _, path := range []string{"/update/counter/Requests/3", "/update/counter/Requests/2", "/update/gauge/Alloc/1.5"} {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", path, nil))
+	}
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest("POST", "/updates/", strings.NewReader(`[{"id":"Requests","type":"counter","delta":4}]`)))
+	// Written before tracking started, e.g. restored from a file
+	store.UpdateCounter("Untracked", 7)
+
+	tests := []struct {
+		name       string
+		path       string
+		wantStatus int
+		wantDelta  int64
+		wantWindow string
+	}{
+		{"explicit window", "/rate/Requests?window=60s", http.StatusOK, 9, "1m0s"},
+		{"default window", "/rate/Requests", http.StatusOK, 9, "1m0s"},
+		{"rounded window", "/rate/Requests?window=1500ms", http.StatusOK, 9, "2s"},
+		{"untracked counter", "/rate/Untracked", http.StatusOK, 0, "1m0s"},
+		{"gauge", "/rate/Alloc", http.StatusNotFound, 0, ""},
+		{"unknown counter", "/rate/Missing", http.StatusNotFound, 0, ""},
+		{"invalid window", "/rate/Requests?window=soon", http.StatusBadRequest, 0, ""},
+		{"negative window", "/rate/Requests?window=-1s", http.StatusBadRequest, 0, ""},
+		{"window too large", "/rate/Requests?window=2m", http.StatusBadRequest, 0, ""},
+		{"window below resolution", "/rate/Requests?window=500ms", http.StatusBadRequest, 0, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r.ServeHTTP(w, httptest.NewRequest("GET", tt.path, nil))
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d: %s", tt.wantStatus, w.Code, w.Body.String())
+			}
+			if tt.wantStatus != http.StatusOK {
+				return
+			}
+
+			var rate CounterRate
+			if err := json.NewDecoder(w.Body).Decode(&rate); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if rate.Delta != tt.wantDelta || rate.Window != tt.wantWindow {
+				t.Errorf("Unexpected rate %+v", rate)
+			}
+			window, _ := time.ParseDuration(tt.wantWindow)
+			if want := float64(tt.wantDelta) / window.Seconds(); rate.PerSecond != want {
+				t.Errorf("Expected %v per second, got %v", want, rate.PerSecond)
+			}
+		})
+	}
+}
+
+func TestMaxMetrics(t *testing.T) {
+	store := storage.NewMemStorage(storage.WithMaxMetrics(2))
+	r := chi.NewRouter()
+	r.Post("/update/{type}/{name}/{value}", UpdateHandler(store, nil, DefaultConfig()))
+	r.Post("/update/", UpdateJSONHandler(store, nil, DefaultConfig()))
+	r.Post("/updates/", UpdateBatchHandler(store, nil, DefaultConfig()))
+
+	post := func(path, body string) int {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest("POST", path, strings.NewReader(body)))
+		return w.Code
+	}
+
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+	}{
+		{"first metric", "/update/gauge/Alloc/1.5", "", http.StatusOK},
+		{"second metric", "/update/counter/PollCount/1", "", http.StatusOK},
+		{"new gauge", "/update/gauge/Frees/1", "", http.StatusInsufficientStorage},
+		{"new counter", "/update/counter/Requests/1", "", http.StatusInsufficientStorage},
+		{"new JSON metric", "/update/", `{"id":"Requests","type":"counter","delta":1}`, http.StatusInsufficientStorage},
+		{"batch with a new metric", "/updates/", `[{"id":"PollCount","type":"counter","delta":1},{"id":"Requests","type":"counter","delta":1}]`, http.StatusInsufficientStorage},
+		{"existing gauge", "/update/gauge/Alloc/2.5", "", http.StatusOK},
+		{"existing JSON counter", "/update/", `{"id":"PollCount","type":"counter","delta":2}`, http.StatusOK},
+		{"batch of existing metrics", "/updates/", `[{"id":"PollCount","type":"counter","delta":3}]`, http.StatusOK},
+	}
+	for _, tt := range tests {
+		if code := post(tt.path, tt.body); code != tt.wantStatus {
+			t.Errorf("%s: expected status %d, got %d", tt.name, tt.wantStatus, code)
+		}
+	}
+
+	gauges, counters := store.GetAll()
+	if len(gauges) != 1 || len(counters) != 1 {
+		t.Errorf("Expected only the first two metrics to be stored, got %v and %v", gauges, counters)
+	}
+	if counters["PollCount"] != 6 {
+		t.Errorf("Expected PollCount 6, got %d", counters["PollCount"])
+	}
+}
+
+// streamingStorage generates gauges for StreamAll without holding them in memory. Its
+// other methods are not implemented, so RootHandler must not fall back to GetAll.
+type streamingStorage struct {
+	storage.Storage
+	gauges int
+	err    error // returned after the gauges, or instead of them if gauges is 0
+	read   int
+}
+
+func (s *streamingStorage) StreamAll(ctx context.Context, fn func(models.Metrics) error) error {
+	for i := range s.gauges {
+		s.read++
+		value := float64(i)
+		if err := fn(models.Metrics{ID: fmt.Sprintf("gauge_%06d", i), MType: GaugeType, Value: &value}); err != nil {
+			return err
+		}
+	}
+	return s.err
+}
+
+func TestRootHandlerStreamsJSON(t *testing.T) {
+	get := func(t *testing.T, store storage.Storage, target string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("GET", target, nil)
+		req.Header.Set("Accept", "application/json")
+		w := httptest.NewRecorder()
+		RootHandler(store, DefaultConfig())(w, req)
+		return w
+	}
+
+	t.Run("large dataset", func(t *testing.T) {
+		const count = 100000
+		store := &streamingStorage{gauges: count}
+		w := get(t, store, "/")
+
+		var metrics []models.Metrics
+		if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+			t.Fatalf("Expected a JSON array of metrics: %v", err)
+		}
+		if len(metrics) != count {
+			t.Fatalf("Expected %d metrics, got %d", count, len(metrics))
+		}
+		if m := metrics[count-1]; m.ID != fmt.Sprintf("gauge_%06d", count-1) || *m.Value != count-1 {
+			t.Errorf("Expected the metrics in streamed order, got %+v last", m)
+		}
+	})
+
+	t.Run("paginated", func(t *testing.T) {
+		store := &streamingStorage{gauges: 1000}
+		w := get(t, store, "/?filter=gauge_0001&offset=2&limit=3")
+
+		var metrics []models.Metrics
+		if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+			t.Fatalf("Expected a JSON array of metrics: %v", err)
+		}
+		var names []string
+		for _, m := range metrics {
+			names = append(names, m.ID)
+		}
+		if want := []string{"gauge_000102", "gauge_000103", "gauge_000104"}; !slices.Equal(names, want) {
+			t.Errorf("Expected %v, got %v", want, names)
+		}
+		if store.read != 105 {
+			t.Errorf("Expected streaming to stop after the page, read %d metrics", store.read)
+		}
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		w := get(t, &streamingStorage{}, "/")
+		if strings.TrimSpace(w.Body.String()) != "[]" {
+			t.Errorf("Expected an empty array, got %q", w.Body.String())
+		}
+	})
+
+	t.Run("failure before the first metric", func(t *testing.T) {
+		w := get(t, &streamingStorage{err: errors.New("connection lost")}, "/")
+		if w.Code != http.StatusInternalServerError {
+			t.Errorf("Expected status %d, got %d", http.StatusInternalServerError, w.Code)
+		}
+	})
+
+	t.Run("failure while streaming", func(t *testing.T) {
+		w := get(t, &streamingStorage{gauges: 10, err: errors.New("connection lost")}, "/")
+		var metrics []models.Metrics
+		if err := json.Unmarshal(w.Body.Bytes(), &metrics); err == nil {
+			t.Error("Expected an interrupted listing to be left unterminated")
+		}
+	})
+}
+
+func TestFloatCounters(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateCounter("Bytes", 7)
+
+	post := func(t *testing.T, handler http.HandlerFunc, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest("POST", "/", strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+	decode := func(t *testing.T, w *httptest.ResponseRecorder, v any) {
+		t.Helper()
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d: %s", http.StatusOK, w.Code, w.Body.String())
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), v); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+	}
+
+	// Each update responds with the accumulated total
+	update := UpdateJSONHandler(store, nil, DefaultConfig())
+	var m models.Metrics
+	decode(t, post(t, update, `{"id":"Bytes","type":"floatcounter","value":1.25}`), &m)
+	decode(t, post(t, update, `{"id":"Bytes","type":"floatcounter","value":2.5}`), &m)
+	if m.MType != FloatCounterType || m.Value == nil || *m.Value != 3.75 || m.Delta != nil {
+		t.Errorf("Expected total 3.75, got %+v", m)
+	}
+
+	var batch []models.Metrics
+	decode(t, post(t, UpdateBatchHandler(store, nil, DefaultConfig()),
+		`[{"id":"Bytes","type":"floatcounter","value":0.25},{"id":"Bytes","type":"counter","delta":3}]`), &batch)
+	if len(batch) != 2 || *batch[0].Value != 4 || *batch[1].Delta != 10 {
+		t.Errorf("Expected float counter 4 and counter 10, got %+v", batch)
+	}
+
+	decode(t, post(t, ValueJSONHandler(store, nil), `{"id":"Bytes","type":"floatcounter"}`), &m)
+	if *m.Value != 4 {
+		t.Errorf("Expected float counter value 4, got %v", *m.Value)
+	}
+	// The integer counter of the same name keeps counting on its own
+	decode(t, post(t, ValueJSONHandler(store, nil), `{"id":"Bytes","type":"counter"}`), &m)
+	if *m.Delta != 10 {
+		t.Errorf("Expected counter delta 10, got %d", *m.Delta)
+	}
+
+	t.Run("missing value", func(t *testing.T) {
+		w := post(t, update, `{"id":"Bytes","type":"floatcounter","delta":1}`)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+		}
+	})
+
+	t.Run("not found", func(t *testing.T) {
+		w := post(t, ValueJSONHandler(store, nil), `{"id":"Missing","type":"floatcounter"}`)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("Expected status %d, got %d", http.StatusNotFound, w.Code)
+		}
+	})
+
+	t.Run("unsupported storage", func(t *testing.T) {
+		plain := unresettableStorage{storage.NewMemStorage()}
+		for _, tc := range []struct {
+			handler http.HandlerFunc
+			body    string
+		}{
+			{UpdateJSONHandler(plain, nil, DefaultConfig()), `{"id":"Bytes","type":"floatcounter","value":1}`},
+			{UpdateBatchHandler(plain, nil, DefaultConfig()), `[{"id":"Bytes","type":"floatcounter","value":1}]`},
+		} {
+			if w := post(t, tc.handler, tc.body); w.Code != http.StatusBadRequest {
+				t.Errorf("Expected status %d, got %d", http.StatusBadRequest, w.Code)
+			}
+		}
+	})
+}
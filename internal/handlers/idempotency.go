@@ -0,0 +1,193 @@
+package handlers
+
+import (
+	"bytes"
+	"container/list"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/middleware"
+)
+
+// IdempotencyKeyHeader identifies a batch update, so that retries of it are applied only once
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// maxReplayedBody is the largest response body kept for replaying; larger responses
+// are replayed with their status only
+const maxReplayedBody = 4 << 10
+
+// IdempotencyStore remembers the responses of recent batch updates by the client
+// address and Idempotency-Key, so that clients cannot replay each other's responses.
+// The address is the connection's peer unless that is a trusted proxy.
+// It holds at most a fixed number of keys, evicting the least recently used one
+// first, and forgets a key once its TTL has passed.
+type IdempotencyStore struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	entries  map[string]*list.Element
+	order    *list.List // of *idempotencyEntry, most recently used first
+}
+
+// idempotencyEntry tracks the first request made with a key
+type idempotencyEntry struct {
+	key     string
+	expires time.Time
+	done    chan struct{}     // closed once the first request has finished
+	resp    *recordedResponse // nil if the first request did not succeed
+}
+
+// recordedResponse is a response kept for replaying to retries
+type recordedResponse struct {
+	status      int
+	contentType string // empty if the body was not kept
+	body        []byte // at most maxReplayedBody bytes
+}
+
+// NewIdempotencyStore creates a store remembering up to capacity keys for ttl each
+func NewIdempotencyStore(capacity int, ttl time.Duration) *IdempotencyStore {
+	return &IdempotencyStore{
+		capacity: max(capacity, 1),
+		ttl:      ttl,
+		entries:  make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+// reserve returns the entry of a key seen within its TTL, or registers a new pending
+// entry for it, reporting whether the entry was created
+func (s *IdempotencyStore) reserve(key string) (*idempotencyEntry, bool) {
+	now := time.Now()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if el, ok := s.entries[key]; ok {
+		entry := el.Value.(*idempotencyEntry)
+		if now.Before(entry.expires) {
+			s.order.MoveToFront(el)
+			return entry, false
+		}
+		s.remove(el)
+	}
+
+	entry := &idempotencyEntry{key: key, expires: now.Add(s.ttl), done: make(chan struct{})}
+	s.entries[key] = s.order.PushFront(entry)
+	for s.order.Len() > s.capacity {
+		s.remove(s.order.Back())
+	}
+	return entry, true
+}
+
+// complete records the response of the first request with a key and releases the
+// requests waiting for it. Keys of failed requests are forgotten, so that a retry
+// is applied again.
+func (s *IdempotencyStore) complete(entry *idempotencyEntry, resp *recordedResponse) {
+	s.mu.Lock()
+	entry.resp = resp
+	entry.expires = time.Now().Add(s.ttl)
+	if el, ok := s.entries[entry.key]; ok && resp == nil && el.Value == entry {
+		s.remove(el)
+	}
+	s.mu.Unlock()
+
+	close(entry.done)
+}
+
+// remove drops an element from the store; the caller must hold s.mu
+func (s *IdempotencyStore) remove(el *list.Element) {
+	s.order.Remove(el)
+	delete(s.entries, el.Value.(*idempotencyEntry).key)
+}
+
+// idempotent wraps a handler so that requests with an already seen Idempotency-Key
+// are answered with the response recorded in store instead of being handled again. A
+// repeat arriving while the first request is still in progress waits for it. Keys are
+// scoped to the client address, taken from X-Real-IP only when the peer is one of
+// trustedProxies. A nil store disables the check.
+func idempotent(store *IdempotencyStore, trustedProxies middleware.TrustedSubnets, next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(IdempotencyKeyHeader)
+		if store == nil || key == "" {
+			next(w, r)
+			return
+		}
+		key = middleware.PeerIP(r, trustedProxies) + " " + key
+
+		for {
+			entry, created := store.reserve(key)
+			if created {
+				handleRecorded(store, entry, next, w, r)
+				return
+			}
+
+			select {
+			case <-entry.done:
+			case <-r.Context().Done():
+				http.Error(w, "request canceled", http.StatusServiceUnavailable)
+				return
+			}
+			if entry.resp != nil {
+				replayResponse(w, entry.resp)
+				return
+			}
+			// The first request failed, so this one may apply the update
+		}
+	}
+}
+
+// handleRecorded runs next for the first request with a key, recording the status of a
+// successful response in the store, and its body if it is at most maxReplayedBody bytes
+func handleRecorded(store *IdempotencyStore, entry *idempotencyEntry, next http.HandlerFunc, w http.ResponseWriter, r *http.Request) {
+	rec := &responseRecorder{ResponseWriter: w, status: http.StatusOK}
+	var resp *recordedResponse
+	defer func() { store.complete(entry, resp) }()
+
+	next(rec, r)
+
+	if rec.status == http.StatusOK {
+		resp = &recordedResponse{status: rec.status}
+		if !rec.overflow {
+			resp.contentType = w.Header().Get("Content-Type")
+			resp.body = bytes.Clone(rec.body.Bytes())
+		}
+	}
+}
+
+// replayResponse writes a recorded response
+func replayResponse(w http.ResponseWriter, resp *recordedResponse) {
+	if resp.contentType != "" {
+		w.Header().Set("Content-Type", resp.contentType)
+	}
+	w.WriteHeader(resp.status)
+	w.Write(resp.body)
+}
+
+// responseRecorder passes a response through while keeping a copy of its status and of
+// a body of at most maxReplayedBody bytes
+type responseRecorder struct {
+	http.ResponseWriter
+	status   int
+	body     bytes.Buffer
+	overflow bool // the body exceeded maxReplayedBody and is not kept
+}
+
+// WriteHeader records the status code
+func (r *responseRecorder) WriteHeader(status int) {
+	r.status = status
+	r.ResponseWriter.WriteHeader(status)
+}
+
+// Write records the body until it exceeds maxReplayedBody
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if !r.overflow {
+		if r.body.Len()+len(b) > maxReplayedBody {
+			r.overflow = true
+			r.body = bytes.Buffer{}
+		} else {
+			r.body.Write(b)
+		}
+	}
+	return r.ResponseWriter.Write(b)
+}
@@ -0,0 +1,235 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/middleware"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+func TestUpdateBatchHandlerIdempotencyKey(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	post := func(key, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		if key != "" {
+			req.Header.Set(IdempotencyKeyHeader, key)
+		}
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	body := `[{"id":"Requests","type":"counter","delta":5}]`
+	first := post("batch-1", body)
+	if first.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, first.Code)
+	}
+
+	replay := post("batch-1", body)
+	if replay.Code != http.StatusOK {
+		t.Fatalf("Expected replayed status %d, got %d", http.StatusOK, replay.Code)
+	}
+	if replay.Body.String() != first.Body.String() {
+		t.Errorf("Expected replayed body %q, got %q", first.Body.String(), replay.Body.String())
+	}
+	if got := replay.Header().Get("Content-Type"); got != "application/json" {
+		t.Errorf("Expected replayed Content-Type application/json, got %q", got)
+	}
+	if v, _ := store.GetCounter("Requests"); v != 5 {
+		t.Errorf("Expected the keyed batch to be applied once, got total %d", v)
+	}
+
+	post("batch-2", body)
+	post("", body)
+	if v, _ := store.GetCounter("Requests"); v != 15 {
+		t.Errorf("Expected new and unkeyed batches to be applied, got total %d", v)
+	}
+}
+
+func TestUpdateBatchHandlerIdempotencyKeyFailure(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	post := func(body string) int {
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "batch-1")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w.Code
+	}
+
+	if code := post(`[`); code != http.StatusBadRequest {
+		t.Fatalf("Expected status %d, got %d", http.StatusBadRequest, code)
+	}
+	// A failed request is not remembered, so a retry with the same key is applied
+	if code := post(`[{"id":"Requests","type":"counter","delta":5}]`); code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, code)
+	}
+	if v, _ := store.GetCounter("Requests"); v != 5 {
+		t.Errorf("Expected total 5, got %d", v)
+	}
+}
+
+func TestUpdateBatchHandlerIdempotencyKeyConcurrent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	var wg sync.WaitGroup
+	for range 20 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			req := httptest.NewRequest("POST", "/updates/", strings.NewReader(`[{"id":"Requests","type":"counter","delta":1}]`))
+			req.Header.Set(IdempotencyKeyHeader, "batch-1")
+			w := httptest.NewRecorder()
+			handler(w, req)
+			if w.Code != http.StatusOK {
+				t.Errorf("Expected status %d, got %d", http.StatusOK, w.Code)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, _ := store.GetCounter("Requests"); v != 1 {
+		t.Errorf("Expected concurrent repeats to be applied once, got total %d", v)
+	}
+}
+
+func TestUpdateBatchHandlerIdempotencyKeyPerClient(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(`[{"id":"Requests","type":"counter","delta":1}]`))
+		req.Header.Set(IdempotencyKeyHeader, "batch-1")
+		req.RemoteAddr = client + ":1234"
+		// Without trusted proxies the header cannot move a request to another client
+		req.Header.Set("X-Real-IP", "10.0.0.9")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+
+	// The key is applied once per client
+	if v, _ := store.GetCounter("Requests"); v != 2 {
+		t.Errorf("Expected total 2, got %d", v)
+	}
+}
+
+func TestUpdateBatchHandlerIdempotencyKeyTrustedProxy(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+	cfg.TrustedProxies = middleware.ParseTrustedSubnets("192.168.0.0/24")
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	for _, client := range []string{"10.0.0.1", "10.0.0.2", "10.0.0.1"} {
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(`[{"id":"Requests","type":"counter","delta":1}]`))
+		req.Header.Set(IdempotencyKeyHeader, "batch-1")
+		req.RemoteAddr = "192.168.0.10:1234"
+		req.Header.Set("X-Real-IP", client)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("Expected status %d, got %d", http.StatusOK, w.Code)
+		}
+	}
+
+	// Behind a trusted proxy the key is applied once per forwarded client
+	if v, _ := store.GetCounter("Requests"); v != 2 {
+		t.Errorf("Expected total 2, got %d", v)
+	}
+}
+
+func TestUpdateBatchHandlerIdempotencyKeyLargeResponse(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Idempotency = NewIdempotencyStore(10, time.Minute)
+
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, cfg)
+
+	metrics := make([]string, 200)
+	for i := range metrics {
+		metrics[i] = fmt.Sprintf(`{"id":"Gauge%d","type":"gauge","value":%d}`, i, i)
+	}
+	body := "[" + strings.Join(metrics, ",") + "]"
+
+	post := func() *httptest.ResponseRecorder {
+		req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+		req.Header.Set(IdempotencyKeyHeader, "batch-1")
+		w := httptest.NewRecorder()
+		handler(w, req)
+		return w
+	}
+
+	if first := post(); first.Code != http.StatusOK || first.Body.Len() <= maxReplayedBody {
+		t.Fatalf("Expected status %d with a body over %d bytes, got %d with %d bytes", http.StatusOK, maxReplayedBody, first.Code, first.Body.Len())
+	}
+	store.UpdateGauge("Gauge0", -1)
+
+	// The body is too large to be kept, so only the status is replayed
+	replay := post()
+	if replay.Code != http.StatusOK {
+		t.Errorf("Expected replayed status %d, got %d", http.StatusOK, replay.Code)
+	}
+	if replay.Body.Len() != 0 {
+		t.Errorf("Expected an empty replayed body, got %d bytes", replay.Body.Len())
+	}
+	if v, _ := store.GetGauge("Gauge0"); v != -1 {
+		t.Errorf("Expected the batch not to be applied again, got Gauge0 = %v", v)
+	}
+}
+
+func TestIdempotencyStore(t *testing.T) {
+	t.Run("evicts least recently used", func(t *testing.T) {
+		s := NewIdempotencyStore(2, time.Minute)
+		for _, key := range []string{"a", "b"} {
+			entry, _ := s.reserve(key)
+			s.complete(entry, &recordedResponse{status: http.StatusOK})
+		}
+		s.reserve("a") // a is now more recently used than b
+		entry, _ := s.reserve("c")
+		s.complete(entry, &recordedResponse{status: http.StatusOK})
+
+		if _, created := s.reserve("a"); created {
+			t.Error("Expected recently used key a to be kept")
+		}
+		if _, created := s.reserve("b"); !created {
+			t.Error("Expected least recently used key b to be evicted")
+		}
+	})
+
+	t.Run("expires keys", func(t *testing.T) {
+		s := NewIdempotencyStore(2, 10*time.Millisecond)
+		entry, _ := s.reserve("a")
+		s.complete(entry, &recordedResponse{status: http.StatusOK})
+
+		time.Sleep(20 * time.Millisecond)
+		if _, created := s.reserve("a"); !created {
+			t.Error("Expected expired key to be forgotten")
+		}
+	})
+}
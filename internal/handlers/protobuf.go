@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"mime"
+	"net/http"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+)
+
+// ProtobufContentType is the media type of update requests and responses encoded as
+// the protobuf messages of the gRPC API
+const ProtobufContentType = "application/x-protobuf"
+
+// isProtobuf reports whether the body of r is protobuf-encoded
+func isProtobuf(r *http.Request) bool {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	return err == nil && mediaType == ProtobufContentType
+}
+
+// decodeMetric decodes a single metric from a JSON or, for protobuf requests, a
+// pb.Metric body
+func decodeMetric(r *http.Request, body []byte) (models.Metrics, error) {
+	if !isProtobuf(r) {
+		var metric models.Metrics
+		if err := json.Unmarshal(body, &metric); err != nil {
+			return models.Metrics{}, errors.New("Invalid JSON")
+		}
+		return metric, nil
+	}
+
+	var metric pb.Metric
+	if err := proto.Unmarshal(body, &metric); err != nil {
+		return models.Metrics{}, errors.New("Invalid protobuf")
+	}
+	return metricFromProto(&metric)
+}
+
+// decodeBatch decodes a list of metrics from a JSON array or, for protobuf requests, a
+// pb.UpdateMetricsRequest body
+func decodeBatch(r *http.Request, body []byte) ([]models.Metrics, error) {
+	if !isProtobuf(r) {
+		var metrics []models.Metrics
+		if err := json.Unmarshal(body, &metrics); err != nil {
+			return nil, errors.New("Invalid JSON")
+		}
+		return metrics, nil
+	}
+
+	var req pb.UpdateMetricsRequest
+	if err := proto.Unmarshal(body, &req); err != nil {
+		return nil, errors.New("Invalid protobuf")
+	}
+	if len(req.EncryptedPayload) > 0 {
+		return nil, errors.New("encrypted_payload is only supported over gRPC")
+	}
+
+	metrics := make([]models.Metrics, 0, len(req.Metrics))
+	for _, m := range req.Metrics {
+		metric, err := metricFromProto(m)
+		if err != nil {
+			return nil, err
+		}
+		metrics = append(metrics, metric)
+	}
+	return metrics, nil
+}
+
+// writeMetric writes an updated metric in the encoding of the request
+func writeMetric(w http.ResponseWriter, r *http.Request, metric models.Metrics) {
+	if !isProtobuf(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metric)
+		return
+	}
+	writeProto(w, metricToProto(metric))
+}
+
+// writeBatch writes the updated metrics of a batch in the encoding of the request,
+// as a pb.GetMetricsResponse for protobuf
+func writeBatch(w http.ResponseWriter, r *http.Request, metrics []models.Metrics) {
+	if !isProtobuf(r) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(metrics)
+		return
+	}
+
+	resp := &pb.GetMetricsResponse{Metrics: make([]*pb.Metric, 0, len(metrics))}
+	for _, metric := range metrics {
+		resp.Metrics = append(resp.Metrics, metricToProto(metric))
+	}
+	writeProto(w, resp)
+}
+
+// writeProto writes a protobuf response
+func writeProto(w http.ResponseWriter, msg proto.Message) {
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", ProtobufContentType)
+	w.Write(data)
+}
+
+// metricFromProto converts a protobuf metric, rejecting unnamed metrics and unknown types
+func metricFromProto(m *pb.Metric) (models.Metrics, error) {
+	metric, err := pb.ToModel(m)
+	if err != nil {
+		return models.Metrics{}, fmt.Errorf("Invalid metric: %w", err)
+	}
+	return metric, nil
+}
+
+// metricToProto converts an updated metric, which carries the value of its type
+func metricToProto(m models.Metrics) *pb.Metric {
+	if metric := pb.FromModel(m); metric != nil {
+		return metric
+	}
+	return &pb.Metric{Id: m.ID}
+}
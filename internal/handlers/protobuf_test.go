@@ -0,0 +1,121 @@
+package handlers
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	pb "github.com/mutualEvg/metrics-server/internal/proto"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+func postProto(t *testing.T, handler http.HandlerFunc, path string, msg proto.Message) *httptest.ResponseRecorder {
+	t.Helper()
+	data, err := proto.Marshal(msg)
+	if err != nil {
+		t.Fatalf("Failed to marshal request: %v", err)
+	}
+	req := httptest.NewRequest("POST", path, bytes.NewReader(data))
+	req.Header.Set("Content-Type", ProtobufContentType)
+	w := httptest.NewRecorder()
+	handler(w, req)
+	return w
+}
+
+func TestUpdateJSONHandlerProtobuf(t *testing.T) {
+	store := storage.NewMemStorage()
+	store.UpdateCounter("requests", 5)
+	handler := UpdateJSONHandler(store, nil, DefaultConfig())
+
+	tests := []struct {
+		name   string
+		metric *pb.Metric
+		want   *pb.Metric
+	}{
+		{
+			name:   "gauge",
+			metric: &pb.Metric{Id: "cpu_usage", Type: pb.Metric_GAUGE, Value: 75.5},
+			want:   &pb.Metric{Id: "cpu_usage", Type: pb.Metric_GAUGE, Value: 75.5},
+		},
+		{
+			name:   "counter returns the accumulated value",
+			metric: &pb.Metric{Id: "requests", Type: pb.Metric_COUNTER, Delta: 10},
+			want:   &pb.Metric{Id: "requests", Type: pb.Metric_COUNTER, Delta: 15},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := postProto(t, handler, "/update/", tt.metric)
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+			}
+			if ct := w.Header().Get("Content-Type"); ct != ProtobufContentType {
+				t.Errorf("Expected Content-Type %s, got %q", ProtobufContentType, ct)
+			}
+
+			var got pb.Metric
+			if err := proto.Unmarshal(w.Body.Bytes(), &got); err != nil {
+				t.Fatalf("Failed to decode response: %v", err)
+			}
+			if !proto.Equal(&got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, &got)
+			}
+		})
+	}
+
+	t.Run("invalid body", func(t *testing.T) {
+		req := httptest.NewRequest("POST", "/update/", bytes.NewReader([]byte{0xff, 0xff}))
+		req.Header.Set("Content-Type", ProtobufContentType)
+		w := httptest.NewRecorder()
+		handler(w, req)
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
+
+func TestUpdateBatchHandlerProtobuf(t *testing.T) {
+	store := storage.NewMemStorage()
+	handler := UpdateBatchHandler(store, nil, DefaultConfig())
+
+	w := postProto(t, handler, "/updates/", &pb.UpdateMetricsRequest{Metrics: []*pb.Metric{
+		{Id: "cpu_usage", Type: pb.Metric_GAUGE, Value: 75.5},
+		{Id: "requests", Type: pb.Metric_COUNTER, Delta: 100},
+		{Id: "requests", Type: pb.Metric_COUNTER, Delta: 1},
+	}})
+	if w.Code != http.StatusOK {
+		t.Fatalf("Expected status 200, got %d: %s", w.Code, w.Body.String())
+	}
+	if ct := w.Header().Get("Content-Type"); ct != ProtobufContentType {
+		t.Errorf("Expected Content-Type %s, got %q", ProtobufContentType, ct)
+	}
+
+	var resp pb.GetMetricsResponse
+	if err := proto.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(resp.Metrics) != 3 {
+		t.Fatalf("Expected 3 metrics in response, got %d", len(resp.Metrics))
+	}
+	if got := resp.Metrics[2]; got.Type != pb.Metric_COUNTER || got.Delta != 101 {
+		t.Errorf("Expected counter requests=101, got %v", got)
+	}
+
+	if v, ok := store.GetGauge("cpu_usage"); !ok || v != 75.5 {
+		t.Errorf("Expected gauge cpu_usage=75.5, got %v (found %v)", v, ok)
+	}
+	if v, ok := store.GetCounter("requests"); !ok || v != 101 {
+		t.Errorf("Expected counter requests=101, got %v (found %v)", v, ok)
+	}
+
+	t.Run("encrypted payload rejected", func(t *testing.T) {
+		w := postProto(t, handler, "/updates/", &pb.UpdateMetricsRequest{EncryptedPayload: []byte("secret")})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("Expected status 400, got %d", w.Code)
+		}
+	})
+}
@@ -3,29 +3,80 @@ package hash
 import (
 	"crypto/hmac"
 	"crypto/sha256"
+	"crypto/sha512"
 	"encoding/hex"
+	stdhash "hash"
 	"io"
 )
 
-// CalculateHash calculates SHA256 HMAC hash of data with the given key
-func CalculateHash(data []byte, key string) string {
-	if key == "" {
+// Supported signing algorithms
+const (
+	AlgoSHA256 = "sha256"
+	AlgoSHA512 = "sha512"
+)
+
+// Header names carrying the signature for each algorithm
+const (
+	HeaderSHA256 = "HashSHA256"
+	HeaderSHA512 = "HashSHA512"
+)
+
+// Supported reports whether algo is a known signing algorithm.
+// An empty algo means the default, sha256.
+func Supported(algo string) bool {
+	return newHash(algo) != nil
+}
+
+// HeaderName returns the header that carries signatures made with algo
+func HeaderName(algo string) string {
+	if algo == AlgoSHA512 {
+		return HeaderSHA512
+	}
+	return HeaderSHA256
+}
+
+// newHash returns the constructor of the hash function for algo, or nil if unknown
+func newHash(algo string) func() stdhash.Hash {
+	switch algo {
+	case "", AlgoSHA256:
+		return sha256.New
+	case AlgoSHA512:
+		return sha512.New
+	}
+	return nil
+}
+
+// Calculate calculates the HMAC of data with the given key using algo ("sha256" or "sha512").
+// It returns an empty string if the key is empty or the algorithm is unknown.
+func Calculate(data []byte, key, algo string) string {
+	newFunc := newHash(algo)
+	if key == "" || newFunc == nil {
 		return ""
 	}
 
-	h := hmac.New(sha256.New, []byte(key))
+	h := hmac.New(newFunc, []byte(key))
 	h.Write(data)
 	return hex.EncodeToString(h.Sum(nil))
 }
 
-// VerifyHash verifies that the provided hash matches the calculated hash of data with key
-func VerifyHash(data []byte, key, providedHash string) bool {
+// Verify verifies that the provided hash matches the HMAC of data with key using algo
+func Verify(data []byte, key, providedHash, algo string) bool {
 	if key == "" || providedHash == "" {
 		return key == "" && providedHash == "" // Both should be empty if no key
 	}
 
-	calculatedHash := CalculateHash(data, key)
-	return providedHash == calculatedHash
+	calculatedHash := Calculate(data, key, algo)
+	return calculatedHash != "" && hmac.Equal([]byte(providedHash), []byte(calculatedHash))
+}
+
+// CalculateHash calculates SHA256 HMAC hash of data with the given key
+func CalculateHash(data []byte, key string) string {
+	return Calculate(data, key, AlgoSHA256)
+}
+
+// VerifyHash verifies that the provided hash matches the calculated hash of data with key
+func VerifyHash(data []byte, key, providedHash string) bool {
+	return Verify(data, key, providedHash, AlgoSHA256)
 }
 
 // HashReader reads all data from reader and returns data + hash
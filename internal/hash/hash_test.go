@@ -144,3 +144,54 @@ func TestHashConsistency(t *testing.T) {
 		t.Error("Hash verification failed for hash3")
 	}
 }
+
+func TestCalculateAlgorithms(t *testing.T) {
+	data := []byte("test data")
+
+	sha256Hash := Calculate(data, "secret", AlgoSHA256)
+	if sha256Hash != CalculateHash(data, "secret") {
+		t.Error("sha256 should match CalculateHash")
+	}
+	if Calculate(data, "secret", "") != sha256Hash {
+		t.Error("An empty algorithm should default to sha256")
+	}
+
+	sha512Hash := Calculate(data, "secret", AlgoSHA512)
+	if len(sha512Hash) != 128 {
+		t.Errorf("Expected a 128 character sha512 hash, got %d characters", len(sha512Hash))
+	}
+
+	if Calculate(data, "secret", "md5") != "" {
+		t.Error("An unknown algorithm should produce an empty hash")
+	}
+}
+
+func TestVerifyAlgorithms(t *testing.T) {
+	data := []byte("test data")
+	sha512Hash := Calculate(data, "secret", AlgoSHA512)
+
+	if !Verify(data, "secret", sha512Hash, AlgoSHA512) {
+		t.Error("Expected sha512 hash to verify")
+	}
+	if Verify(data, "secret", sha512Hash, AlgoSHA256) {
+		t.Error("A sha512 hash should not verify as sha256")
+	}
+	if Verify(data, "other", sha512Hash, AlgoSHA512) {
+		t.Error("A hash made with another key should not verify")
+	}
+	if Verify(data, "secret", sha512Hash, "md5") {
+		t.Error("An unknown algorithm should never verify")
+	}
+}
+
+func TestHeaderName(t *testing.T) {
+	if HeaderName(AlgoSHA256) != "HashSHA256" || HeaderName("") != "HashSHA256" {
+		t.Error("Expected HashSHA256 header for sha256")
+	}
+	if HeaderName(AlgoSHA512) != "HashSHA512" {
+		t.Error("Expected HashSHA512 header for sha512")
+	}
+	if !Supported(AlgoSHA512) || Supported("md5") {
+		t.Error("Unexpected result from Supported")
+	}
+}
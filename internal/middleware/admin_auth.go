@@ -0,0 +1,31 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"strings"
+)
+
+// AdminAuth returns middleware that protects administrative endpoints with a
+// bearer token. Requests must carry "Authorization: Bearer <token>".
+// If token is empty, administrative endpoints are disabled and respond 404 Not Found.
+func AdminAuth(token string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if token == "" {
+				http.NotFound(w, r)
+				return
+			}
+
+			provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if !ok || subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+				log.Printf("Admin request to %s from %s rejected: invalid token", r.URL.Path, ClientIP(r))
+				http.Error(w, "Unauthorized", http.StatusUnauthorized)
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestAdminAuth(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		token          string
+		authorization  string
+		expectedStatus int
+	}{
+		{"valid token", "secret", "Bearer secret", http.StatusOK},
+		{"wrong token", "secret", "Bearer other", http.StatusUnauthorized},
+		{"missing header", "secret", "", http.StatusUnauthorized},
+		{"wrong scheme", "secret", "Basic secret", http.StatusUnauthorized},
+		{"disabled", "", "Bearer ", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/debug/requests", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+
+			AdminAuth(tt.token)(handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+)
+
+// Backpressure returns middleware that limits the number of requests handled concurrently.
+// Requests over the limit are rejected with 429 Too Many Requests and a Retry-After header
+// so that clients can slow down. The limit is shared by all handlers the middleware wraps.
+// If maxInFlight is 0 or less, all requests are allowed.
+func Backpressure(maxInFlight int, retryAfter time.Duration) func(http.Handler) http.Handler {
+	retryAfterSeconds := strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+	slots := make(chan struct{}, max(maxInFlight, 0))
+
+	return func(next http.Handler) http.Handler {
+		if maxInFlight <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+				next.ServeHTTP(w, r)
+			default:
+				log.Printf("Request %s %s rejected: %d requests in flight", r.Method, r.URL.Path, maxInFlight)
+				w.Header().Set("Retry-After", retryAfterSeconds)
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+			}
+		})
+	}
+}
+
+// errorRateWindow is the interval over which ErrorRateBackpressure measures failures
+const errorRateWindow = 10 * time.Second
+
+// errorRateMinAttempts is the fewest attempts within a window for its failures to count,
+// so that a single failed operation of an idle server does not trigger backpressure
+const errorRateMinAttempts = 10
+
+// errorRate measures the percentage of failed operations counted by a stats source
+// over consecutive windows
+type errorRate struct {
+	mu      sync.Mutex
+	stats   func() retry.StatsSnapshot
+	start   retry.StatsSnapshot // Counts at the start of the current window
+	started time.Time
+	percent int64 // Failure percentage of the last complete window
+	now     func() time.Time
+}
+
+// newErrorRate starts measuring the failures counted by stats, reading the time from now
+func newErrorRate(stats func() retry.StatsSnapshot, now func() time.Time) *errorRate {
+	return &errorRate{stats: stats, start: stats(), started: now(), now: now}
+}
+
+// current returns the failure percentage of the last complete window
+func (e *errorRate) current() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	now := e.now()
+	if now.Sub(e.started) < errorRateWindow {
+		return e.percent
+	}
+
+	snapshot := e.stats()
+	e.percent = 0
+	if attempts := snapshot.Attempts - e.start.Attempts; attempts >= errorRateMinAttempts {
+		e.percent = (snapshot.Failures - e.start.Failures) * 100 / attempts
+	}
+	e.start, e.started = snapshot, now
+	return e.percent
+}
+
+// ErrorRateBackpressure returns middleware that rejects requests with 503 Service Unavailable
+// and a Retry-After header while more than maxPercent of the operations counted by stats,
+// such as the database operations of a DBStorage, failed within the last 10 seconds.
+// Windows with fewer than 10 operations do not count. If maxPercent is 0 or less, all
+// requests are allowed.
+func ErrorRateBackpressure(stats func() retry.StatsSnapshot, maxPercent int, retryAfter time.Duration) func(http.Handler) http.Handler {
+	if maxPercent <= 0 {
+		return func(next http.Handler) http.Handler { return next }
+	}
+	return errorRateBackpressure(newErrorRate(stats, time.Now), maxPercent, retryAfter)
+}
+
+// errorRateBackpressure implements ErrorRateBackpressure for the failures measured by rate
+func errorRateBackpressure(rate *errorRate, maxPercent int, retryAfter time.Duration) func(http.Handler) http.Handler {
+	retryAfterSeconds := strconv.Itoa(int((retryAfter + time.Second - 1) / time.Second))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if percent := rate.current(); percent > int64(maxPercent) {
+				log.Printf("Request %s %s rejected: %d%% of storage operations failed", r.Method, r.URL.Path, percent)
+				w.Header().Set("Retry-After", retryAfterSeconds)
+				http.Error(w, "Service Unavailable", http.StatusServiceUnavailable)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+)
+
+func TestBackpressure_RejectsOverLimit(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+		w.WriteHeader(http.StatusOK)
+	})
+
+	limited := Backpressure(1, 1500*time.Millisecond)(handler)
+
+	// Occupy the only slot
+	done := make(chan struct{})
+	go func() {
+		limited.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/update/", nil))
+		close(done)
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest("POST", "/update/", nil))
+
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "2" {
+		t.Errorf("Expected Retry-After 2, got %q", got)
+	}
+
+	close(release)
+	<-done
+
+	// Slot is free again
+	go func() { <-entered }()
+	rec = httptest.NewRecorder()
+	limited.ServeHTTP(rec, httptest.NewRequest("POST", "/update/", nil))
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d after slot was released, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestBackpressure_Disabled(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	rec := httptest.NewRecorder()
+	Backpressure(0, time.Second)(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestErrorRateBackpressure(t *testing.T) {
+	var snapshot retry.StatsSnapshot
+	now := time.Unix(1_700_000_000, 0)
+	rate := newErrorRate(func() retry.StatsSnapshot { return snapshot }, func() time.Time { return now })
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	limited := errorRateBackpressure(rate, 50, 3*time.Second)(handler)
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, httptest.NewRequest("POST", "/updates/", nil))
+		return rec
+	}
+
+	// 60% of the operations fail, but the window has not completed yet
+	snapshot = retry.StatsSnapshot{Attempts: 20, Failures: 12}
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d within the first window, got %d", http.StatusOK, rec.Code)
+	}
+
+	now = now.Add(errorRateWindow)
+	rec := serve()
+	if rec.Code != http.StatusServiceUnavailable {
+		t.Fatalf("Expected status %d over the error rate, got %d", http.StatusServiceUnavailable, rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "3" {
+		t.Errorf("Expected Retry-After 3, got %q", got)
+	}
+
+	// Too few operations in the next window to judge the database
+	snapshot = retry.StatsSnapshot{Attempts: 25, Failures: 17}
+	now = now.Add(errorRateWindow)
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d for a window with few operations, got %d", http.StatusOK, rec.Code)
+	}
+
+	// A healthy window below the limit
+	snapshot = retry.StatsSnapshot{Attempts: 45, Failures: 19}
+	now = now.Add(errorRateWindow)
+	if rec := serve(); rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d under the error rate, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestBackpressure_SharedAcrossHandlers(t *testing.T) {
+	release := make(chan struct{})
+	entered := make(chan struct{})
+	blocking := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		entered <- struct{}{}
+		<-release
+	})
+	other := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	backpressure := Backpressure(1, time.Second)
+	first, second := backpressure(blocking), backpressure(other)
+
+	done := make(chan struct{})
+	go func() {
+		first.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("POST", "/update/", nil))
+		close(done)
+	}()
+	<-entered
+
+	rec := httptest.NewRecorder()
+	second.ServeHTTP(rec, httptest.NewRequest("POST", "/updates/", nil))
+	if rec.Code != http.StatusTooManyRequests {
+		t.Errorf("Expected the limit to cover both handlers, got status %d", rec.Code)
+	}
+
+	close(release)
+	<-done
+}
@@ -10,12 +10,22 @@ import (
 	"github.com/mutualEvg/metrics-server/internal/crypto"
 )
 
-// DecryptionMiddleware creates a middleware that decrypts encrypted request bodies
+// DecryptionMiddleware creates a middleware that decrypts encrypted request bodies.
+// A body is decrypted only when the request carries the "X-Encrypted: true" header;
+// any other request passes through untouched. A request claiming encryption whose
+// body cannot be decrypted is rejected with 400 Bad Request.
 func DecryptionMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Handler {
+	return DecryptionMiddlewareMulti([]*rsa.PrivateKey{privateKey})
+}
+
+// DecryptionMiddlewareMulti is like DecryptionMiddleware but tries each of the private
+// keys in order until one decrypts the body, so that during key rotation agents may
+// encrypt for either the old or the new public key
+func DecryptionMiddlewareMulti(privateKeys []*rsa.PrivateKey) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-			// Check if request is encrypted
-			if r.Header.Get("X-Encrypted") != "true" {
+			// Only the header marks a request as encrypted
+			if r.Header.Get(crypto.EncryptedHeader) != "true" {
 				// Not encrypted, pass through
 				next.ServeHTTP(w, r)
 				return
@@ -31,7 +41,7 @@ func DecryptionMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Ha
 			r.Body.Close()
 
 			// Decrypt the body
-			decryptedBody, err := crypto.DecryptRSAChunked(encryptedBody, privateKey)
+			decryptedBody, err := crypto.DecryptRSAChunkedAny(encryptedBody, privateKeys)
 			if err != nil {
 				log.Printf("Failed to decrypt body: %v", err)
 				http.Error(w, "Failed to decrypt request", http.StatusBadRequest)
@@ -43,7 +53,7 @@ func DecryptionMiddleware(privateKey *rsa.PrivateKey) func(http.Handler) http.Ha
 			r.ContentLength = int64(len(decryptedBody))
 
 			// Remove the encryption header since body is now decrypted
-			r.Header.Del("X-Encrypted")
+			r.Header.Del(crypto.EncryptedHeader)
 
 			next.ServeHTTP(w, r)
 		})
@@ -0,0 +1,138 @@
+package middleware
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/mutualEvg/metrics-server/internal/crypto"
+)
+
+func TestDecryptionMiddlewareMulti(t *testing.T) {
+	keyA, _, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key A: %v", err)
+	}
+	keyB, _, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key B: %v", err)
+	}
+	unknown, _, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate unknown key: %v", err)
+	}
+
+	handler := DecryptionMiddlewareMulti([]*rsa.PrivateKey{keyA, keyB})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	}))
+
+	payload := []byte(`[{"id":"Alloc","type":"gauge","value":1.5}]`)
+	encryptFor := func(key *rsa.PrivateKey) []byte {
+		t.Helper()
+		encrypted, err := crypto.EncryptRSAChunked(payload, &key.PublicKey)
+		if err != nil {
+			t.Fatalf("Failed to encrypt: %v", err)
+		}
+		return encrypted
+	}
+
+	tests := []struct {
+		name       string
+		body       []byte
+		encrypted  bool
+		wantStatus int
+	}{
+		{"encrypted for key A", encryptFor(keyA), true, http.StatusOK},
+		{"encrypted for key B", encryptFor(keyB), true, http.StatusOK},
+		{"encrypted for an unknown key", encryptFor(unknown), true, http.StatusBadRequest},
+		{"unencrypted", payload, false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest("POST", "/updates/", bytes.NewReader(tt.body))
+			if tt.encrypted {
+				req.Header.Set("X-Encrypted", "true")
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantStatus == http.StatusOK && !bytes.Equal(w.Body.Bytes(), payload) {
+				t.Errorf("Expected the handler to receive %s, got %s", payload, w.Body.Bytes())
+			}
+		})
+	}
+}
+
+func TestDecryptionMiddleware(t *testing.T) {
+	key, _, err := crypto.GenerateKeyPair(2048)
+	if err != nil {
+		t.Fatalf("Failed to generate key: %v", err)
+	}
+
+	var received []byte
+	var headerSeen bool
+	handler := DecryptionMiddleware(key)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		received, _ = io.ReadAll(r.Body)
+		_, headerSeen = r.Header[crypto.EncryptedHeader]
+	}))
+
+	payload := []byte(`{"id":"Alloc","type":"gauge","value":1.5}`)
+	encrypted, err := crypto.EncryptRSAChunked(payload, &key.PublicKey)
+	if err != nil {
+		t.Fatalf("Failed to encrypt: %v", err)
+	}
+	corrupt := append([]byte(nil), encrypted...)
+	corrupt[len(corrupt)-1] ^= 0xff
+
+	tests := []struct {
+		name       string
+		body       []byte
+		header     string // value of X-Encrypted, empty to omit it
+		wantStatus int
+		wantBody   []byte
+	}{
+		{"encrypted with header", encrypted, "true", http.StatusOK, payload},
+		{"plain without header", payload, "", http.StatusOK, payload},
+		// Binary bodies, e.g. gzip, must not be mistaken for ciphertext
+		{"binary without header", encrypted, "", http.StatusOK, encrypted},
+		{"header other than true", payload, "false", http.StatusOK, payload},
+		{"header with corrupt body", corrupt, "true", http.StatusBadRequest, nil},
+		{"header with plain body", payload, "true", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			received, headerSeen = nil, false
+			req := httptest.NewRequest("POST", "/update/", bytes.NewReader(tt.body))
+			if tt.header != "" {
+				req.Header.Set(crypto.EncryptedHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, req)
+
+			if w.Code != tt.wantStatus {
+				t.Fatalf("Expected status %d, got %d", tt.wantStatus, w.Code)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if received != nil {
+					t.Error("Expected the handler not to be called")
+				}
+				return
+			}
+			if !bytes.Equal(received, tt.wantBody) {
+				t.Errorf("Expected the handler to receive %q, got %q", tt.wantBody, received)
+			}
+			if tt.header == "true" && headerSeen {
+				t.Error("Expected the encryption header to be removed after decryption")
+			}
+		})
+	}
+}
@@ -1,91 +1,303 @@
 package middleware
 
 import (
+	"bytes"
 	"compress/gzip"
+	"fmt"
+	"io"
 	"net/http"
+	"strconv"
 	"strings"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
+)
+
+// Supported content encodings
+const (
+	encodingZstd   = "zstd"
+	encodingBrotli = "br"
+	encodingGzip   = "gzip"
 )
 
-// GzipMiddleware handles gzip compression and decompression
+// DefaultMaxBodySize is the default limit for the decompressed size of a request body
+const DefaultMaxBodySize = 10 << 20 // 10MB
+
+// DefaultMinCompressSize is the suggested size below which responses are sent uncompressed:
+// a body fitting into one TCP segment gains little from compression, and tiny bodies even
+// grow larger than the original
+const DefaultMinCompressSize = 1400
+
+// responseEncodings lists the response encodings in order of preference
+var responseEncodings = []string{encodingZstd, encodingBrotli, encodingGzip}
+
+// GzipMiddleware handles compression and decompression using the default gzip compression level.
+// Despite its name it also negotiates zstd and brotli, see GzipMiddlewareLevel.
 func GzipMiddleware(next http.Handler) http.Handler {
+	return GzipMiddlewareLevel(gzip.DefaultCompression)(next)
+}
+
+// GzipMiddlewareMinSize returns compression middleware like GzipMiddleware that sends
+// responses smaller than minSize bytes uncompressed, see GzipMiddlewareWithMinSize
+func GzipMiddlewareMinSize(minSize int) func(http.Handler) http.Handler {
+	return GzipMiddlewareWithMinSize(gzip.DefaultCompression, DefaultMaxBodySize, minSize)
+}
+
+// GzipMiddlewareLevel returns compression middleware. Request bodies encoded with gzip, zstd
+// or br are decompressed. Responses are compressed with the encoding negotiated from the
+// Accept-Encoding header, preferring zstd, then brotli, then gzip; gzip uses the given level,
+// from gzip.HuffmanOnly to gzip.BestCompression. It panics if the level is invalid.
+func GzipMiddlewareLevel(level int) func(http.Handler) http.Handler {
+	return GzipMiddlewareWithLimit(level, DefaultMaxBodySize)
+}
+
+// GzipMiddlewareWithLimit is like GzipMiddlewareLevel, but additionally limits the decompressed
+// size of request bodies to maxBodySize bytes. Larger bodies are rejected with
+// 413 Request Entity Too Large, which protects against decompression bombs.
+// A maxBodySize of 0 or less uses DefaultMaxBodySize.
+func GzipMiddlewareWithLimit(level int, maxBodySize int64) func(http.Handler) http.Handler {
+	return GzipMiddlewareWithMinSize(level, maxBodySize, 0)
+}
+
+// GzipMiddlewareWithMinSize is like GzipMiddlewareWithLimit, but sends responses with bodies
+// smaller than minSize bytes uncompressed. A response whose Content-Length is set is
+// compressed or not right away; otherwise the body is buffered until minSize bytes have
+// been written or the handler returns. A minSize of 0 or less compresses every response.
+func GzipMiddlewareWithMinSize(level int, maxBodySize int64, minSize int) func(http.Handler) http.Handler {
+	if _, err := gzip.NewWriterLevel(io.Discard, level); err != nil {
+		panic(fmt.Sprintf("middleware: invalid gzip compression level %d", level))
+	}
+	if maxBodySize <= 0 {
+		maxBodySize = DefaultMaxBodySize
+	}
+
+	return func(next http.Handler) http.Handler {
+		return compressHandler(next, level, maxBodySize, minSize)
+	}
+}
+
+// compressHandler decompresses request bodies of up to maxBodySize bytes and compresses
+// responses of at least minSize bytes with the negotiated encoding, using the given level for gzip
+func compressHandler(next http.Handler, level int, maxBodySize int64, minSize int) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		// Handle decompression of incoming requests
-		if r.Header.Get("Content-Encoding") == "gzip" {
-			gz, err := gzip.NewReader(r.Body)
+		if encoding := r.Header.Get("Content-Encoding"); encoding != "" {
+			body, err := newDecoder(r.Body, encoding)
 			if err != nil {
-				http.Error(w, "Invalid gzip data", http.StatusBadRequest)
+				http.Error(w, fmt.Sprintf("Invalid %s data", encoding), http.StatusBadRequest)
 				return
 			}
-			defer gz.Close()
-			r.Body = gz
+			if body != nil {
+				// Read one byte past the limit to detect oversized bodies
+				data, err := io.ReadAll(io.LimitReader(body, maxBodySize+1))
+				body.Close()
+				if err != nil {
+					http.Error(w, fmt.Sprintf("Invalid %s data", encoding), http.StatusBadRequest)
+					return
+				}
+				if int64(len(data)) > maxBodySize {
+					http.Error(w, "Decompressed request body too large", http.StatusRequestEntityTooLarge)
+					return
+				}
+				r.Body = io.NopCloser(bytes.NewReader(data))
+			}
 		}
 
-		// Check if client accepts gzip encoding for response compression
-		if !strings.Contains(r.Header.Get("Accept-Encoding"), "gzip") {
+		// Pick a response encoding the client accepts
+		encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" {
 			next.ServeHTTP(w, r)
 			return
 		}
 
 		// Wrap the response writer to handle compression
-		gzw := &gzipResponseWriter{
+		cw := &compressResponseWriter{
 			ResponseWriter: w,
 			request:        r,
+			encoding:       encoding,
+			level:          level,
+			minSize:        minSize,
 		}
-		defer gzw.Close()
+		defer cw.Close()
 
-		next.ServeHTTP(gzw, r)
+		next.ServeHTTP(cw, r)
 	})
 }
 
-// gzipResponseWriter wraps http.ResponseWriter to provide gzip compression
-type gzipResponseWriter struct {
+// newDecoder returns a reader that decompresses body according to the Content-Encoding.
+// It returns nil for encodings it does not handle, leaving the body untouched.
+func newDecoder(body io.Reader, encoding string) (io.ReadCloser, error) {
+	switch encoding {
+	case encodingGzip:
+		return gzip.NewReader(body)
+	case encodingZstd:
+		dec, err := zstd.NewReader(body, zstd.WithDecoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+		return dec.IOReadCloser(), nil
+	case encodingBrotli:
+		return io.NopCloser(brotli.NewReader(body)), nil
+	}
+	return nil, nil
+}
+
+// negotiateEncoding selects the response encoding from an Accept-Encoding header.
+// Encodings listed with q=0 are refused; "*" accepts any encoding not listed explicitly.
+// It returns an empty string if the response should not be compressed.
+func negotiateEncoding(acceptEncoding string) string {
+	if acceptEncoding == "" {
+		return ""
+	}
+
+	accepted := make(map[string]bool)
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		name, params, _ := strings.Cut(part, ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		if name == "" {
+			continue
+		}
+
+		q := 1.0
+		if param, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if v, err := strconv.ParseFloat(param, 64); err == nil {
+				q = v
+			}
+		}
+		accepted[name] = q > 0
+	}
+
+	for _, encoding := range responseEncodings {
+		if ok, listed := accepted[encoding]; listed {
+			if ok {
+				return encoding
+			}
+			continue
+		}
+		if accepted["*"] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressResponseWriter wraps http.ResponseWriter to compress the response with the
+// negotiated encoding. While the size of a compressible body is not yet known to reach
+// minSize, the status and body are held back in buffer.
+type compressResponseWriter struct {
 	http.ResponseWriter
 	request       *http.Request
-	gzipWriter    *gzip.Writer
+	writer        io.WriteCloser
+	encoding      string
+	level         int
+	minSize       int
 	headerWritten bool
+	buffering     bool
+	status        int
+	buffer        []byte
 }
 
-func (grw *gzipResponseWriter) WriteHeader(statusCode int) {
-	if grw.headerWritten {
+func (cw *compressResponseWriter) WriteHeader(statusCode int) {
+	if cw.headerWritten {
 		return
 	}
-	grw.headerWritten = true
+	cw.headerWritten = true
 
-	// Check if we should compress based on content type
-	contentType := grw.Header().Get("Content-Type")
-	if grw.shouldCompress(contentType) {
-		grw.Header().Set("Content-Encoding", "gzip")
-		grw.Header().Del("Content-Length") // Remove content-length as it will change
-		grw.gzipWriter = gzip.NewWriter(grw.ResponseWriter)
+	// Check if we should compress based on content type. A body the handler already
+	// encoded itself, e.g. pre-compressed content, is passed through as is.
+	contentType := cw.Header().Get("Content-Type")
+	if cw.Header().Get("Content-Encoding") != "" || !cw.shouldCompress(contentType) {
+		cw.ResponseWriter.WriteHeader(statusCode)
+		return
 	}
 
-	grw.ResponseWriter.WriteHeader(statusCode)
+	if cw.minSize > 0 {
+		if length := cw.Header().Get("Content-Length"); length != "" {
+			// The size is known upfront, so nothing needs to be buffered
+			if n, err := strconv.Atoi(length); err == nil && n < cw.minSize {
+				cw.ResponseWriter.WriteHeader(statusCode)
+				return
+			}
+		} else {
+			cw.buffering = true
+			cw.status = statusCode
+			return
+		}
+	}
+	cw.startCompression(statusCode)
 }
 
-func (grw *gzipResponseWriter) Write(data []byte) (int, error) {
-	if !grw.headerWritten {
+// startCompression writes the response header announcing the encoding and compresses
+// the rest of the body
+func (cw *compressResponseWriter) startCompression(statusCode int) {
+	cw.Header().Set("Content-Encoding", cw.encoding)
+	cw.Header().Del("Content-Length") // Remove content-length as it will change
+	cw.writer = newEncoder(cw.ResponseWriter, cw.encoding, cw.level)
+	cw.ResponseWriter.WriteHeader(statusCode)
+}
+
+// newEncoder returns a writer compressing to w with the given encoding
+func newEncoder(w io.Writer, encoding string, level int) io.WriteCloser {
+	switch encoding {
+	case encodingZstd:
+		// Without options NewWriter cannot fail
+		enc, _ := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		return enc
+	case encodingBrotli:
+		return brotli.NewWriter(w)
+	default:
+		// The level was validated when the middleware was created
+		gz, _ := gzip.NewWriterLevel(w, level)
+		return gz
+	}
+}
+
+func (cw *compressResponseWriter) Write(data []byte) (int, error) {
+	if !cw.headerWritten {
 		// Set content type if not already set
-		if grw.Header().Get("Content-Type") == "" {
-			grw.Header().Set("Content-Type", http.DetectContentType(data))
+		if cw.Header().Get("Content-Type") == "" {
+			cw.Header().Set("Content-Type", http.DetectContentType(data))
+		}
+		cw.WriteHeader(http.StatusOK)
+	}
+
+	if cw.buffering {
+		cw.buffer = append(cw.buffer, data...)
+		if len(cw.buffer) < cw.minSize {
+			return len(data), nil
+		}
+		// The body is large enough to be worth compressing
+		cw.buffering = false
+		cw.startCompression(cw.status)
+		if _, err := cw.writer.Write(cw.buffer); err != nil {
+			return 0, err
 		}
-		grw.WriteHeader(http.StatusOK)
+		cw.buffer = nil
+		return len(data), nil
 	}
 
-	if grw.gzipWriter != nil {
-		return grw.gzipWriter.Write(data)
+	if cw.writer != nil {
+		return cw.writer.Write(data)
 	}
-	return grw.ResponseWriter.Write(data)
+	return cw.ResponseWriter.Write(data)
 }
 
-func (grw *gzipResponseWriter) Close() error {
-	if grw.gzipWriter != nil {
-		return grw.gzipWriter.Close()
+// Close finishes the response, sending a body that stayed below minSize uncompressed
+func (cw *compressResponseWriter) Close() error {
+	if cw.buffering {
+		cw.buffering = false
+		cw.ResponseWriter.WriteHeader(cw.status)
+		_, err := cw.ResponseWriter.Write(cw.buffer)
+		return err
+	}
+	if cw.writer != nil {
+		return cw.writer.Close()
 	}
 	return nil
 }
 
 // shouldCompress determines if the content type should be compressed
-func (grw *gzipResponseWriter) shouldCompress(contentType string) bool {
+func (cw *compressResponseWriter) shouldCompress(contentType string) bool {
 	compressibleTypes := []string{
 		"application/json",
 		"text/html",
@@ -6,7 +6,13 @@ import (
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/klauspost/compress/zstd"
 )
 
 func TestGzipMiddleware_Compression(t *testing.T) {
@@ -157,3 +163,380 @@ func TestGzipMiddleware_NonCompressibleContent(t *testing.T) {
 		t.Error("Expected no compression for binary content")
 	}
 }
+
+func TestGzipMiddlewareLevel(t *testing.T) {
+	payload := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1.5}`, 200)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(payload))
+	})
+
+	sizes := make(map[int]int)
+	for _, level := range []int{gzip.BestSpeed, gzip.DefaultCompression, gzip.BestCompression} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+
+		GzipMiddlewareLevel(level)(handler).ServeHTTP(rec, req)
+
+		if rec.Header().Get("Content-Encoding") != "gzip" {
+			t.Fatalf("Level %d: expected Content-Encoding: gzip", level)
+		}
+		sizes[level] = rec.Body.Len()
+
+		gz, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("Level %d: failed to create gzip reader: %v", level, err)
+		}
+		decompressed, err := io.ReadAll(gz)
+		if err != nil {
+			t.Fatalf("Level %d: failed to decompress: %v", level, err)
+		}
+		if string(decompressed) != payload {
+			t.Errorf("Level %d: decompressed payload mismatch", level)
+		}
+	}
+
+	if sizes[gzip.BestCompression] > sizes[gzip.BestSpeed] {
+		t.Errorf("Expected BestCompression (%d bytes) to be no larger than BestSpeed (%d bytes)",
+			sizes[gzip.BestCompression], sizes[gzip.BestSpeed])
+	}
+}
+
+func TestGzipMiddlewareLevel_Invalid(t *testing.T) {
+	defer func() {
+		if recover() == nil {
+			t.Error("Expected panic for invalid compression level")
+		}
+	}()
+
+	GzipMiddlewareLevel(42)
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		expected       string
+	}{
+		{"", ""},
+		{"identity", ""},
+		{"gzip", "gzip"},
+		{"gzip, deflate", "gzip"},
+		{"gzip, br", "br"},
+		{"gzip, br, zstd", "zstd"},
+		{"zstd;q=0, br;q=0.5, gzip", "br"},
+		{"br;q=0, gzip;q=0", ""},
+		{"*", "zstd"},
+		{"*, zstd;q=0", "br"},
+		{"GZIP", "gzip"},
+	}
+
+	for _, tt := range tests {
+		if got := negotiateEncoding(tt.acceptEncoding); got != tt.expected {
+			t.Errorf("negotiateEncoding(%q) = %q, expected %q", tt.acceptEncoding, got, tt.expected)
+		}
+	}
+}
+
+func TestCompressMiddleware_ResponseEncodings(t *testing.T) {
+	body := strings.Repeat(`{"id":"Alloc","type":"gauge","value":123.45}`, 20)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(body))
+	})
+
+	tests := []struct {
+		encoding   string
+		decompress func(io.Reader) (io.Reader, error)
+	}{
+		{"zstd", func(r io.Reader) (io.Reader, error) { return zstd.NewReader(r) }},
+		{"br", func(r io.Reader) (io.Reader, error) { return brotli.NewReader(r), nil }},
+		{"gzip", func(r io.Reader) (io.Reader, error) { return gzip.NewReader(r) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", tt.encoding)
+			rec := httptest.NewRecorder()
+
+			GzipMiddleware(handler).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.encoding {
+				t.Fatalf("Expected Content-Encoding %s, got %q", tt.encoding, got)
+			}
+
+			r, err := tt.decompress(rec.Body)
+			if err != nil {
+				t.Fatalf("Failed to create %s reader: %v", tt.encoding, err)
+			}
+			decompressed, err := io.ReadAll(r)
+			if err != nil {
+				t.Fatalf("Failed to decompress: %v", err)
+			}
+			if string(decompressed) != body {
+				t.Errorf("Decompressed body does not match")
+			}
+		})
+	}
+}
+
+func TestCompressMiddleware_RequestEncodings(t *testing.T) {
+	testData := `{"test": "compressed data"}`
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write(body)
+	})
+
+	tests := []struct {
+		encoding string
+		compress func(io.Writer) io.WriteCloser
+	}{
+		{"zstd", func(w io.Writer) io.WriteCloser {
+			enc, _ := zstd.NewWriter(w)
+			return enc
+		}},
+		{"br", func(w io.Writer) io.WriteCloser { return brotli.NewWriter(w) }},
+		{"gzip", func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.encoding, func(t *testing.T) {
+			var compressed bytes.Buffer
+			w := tt.compress(&compressed)
+			w.Write([]byte(testData))
+			w.Close()
+
+			req := httptest.NewRequest("POST", "/", &compressed)
+			req.Header.Set("Content-Encoding", tt.encoding)
+			req.Header.Set("Content-Type", "application/json")
+			rec := httptest.NewRecorder()
+
+			GzipMiddleware(handler).ServeHTTP(rec, req)
+
+			if rec.Body.String() != testData {
+				t.Errorf("Expected %s, got %s", testData, rec.Body.String())
+			}
+		})
+	}
+}
+
+func TestCompressMiddleware_InvalidRequestData(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, err := io.ReadAll(r.Body); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+		}
+	})
+
+	for _, encoding := range []string{"gzip", "zstd"} {
+		req := httptest.NewRequest("POST", "/", strings.NewReader("not compressed"))
+		req.Header.Set("Content-Encoding", encoding)
+		rec := httptest.NewRecorder()
+
+		GzipMiddleware(handler).ServeHTTP(rec, req)
+
+		if rec.Code != http.StatusBadRequest {
+			t.Errorf("%s: expected status %d, got %d", encoding, http.StatusBadRequest, rec.Code)
+		}
+	}
+}
+
+func TestGzipMiddlewareWithLimit_DecompressionBomb(t *testing.T) {
+	handlerCalled := false
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handlerCalled = true
+		io.Copy(io.Discard, r.Body)
+	})
+
+	const limit = 1024
+
+	// A few hundred bytes of gzip that expand to 1MB of zeros
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(make([]byte, 1<<20))
+	gz.Close()
+
+	req := httptest.NewRequest("POST", "/updates/", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	GzipMiddlewareWithLimit(gzip.DefaultCompression, limit)(handler).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusRequestEntityTooLarge {
+		t.Errorf("Expected status %d, got %d", http.StatusRequestEntityTooLarge, rec.Code)
+	}
+	if handlerCalled {
+		t.Error("Handler should not be called for an oversized body")
+	}
+}
+
+func TestGzipMiddlewareWithLimit_AtLimit(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		w.Write([]byte(strconv.Itoa(len(body))))
+	})
+
+	const limit = 1024
+
+	var compressed bytes.Buffer
+	gz := gzip.NewWriter(&compressed)
+	gz.Write(make([]byte, limit))
+	gz.Close()
+
+	req := httptest.NewRequest("POST", "/updates/", &compressed)
+	req.Header.Set("Content-Encoding", "gzip")
+	rec := httptest.NewRecorder()
+
+	GzipMiddlewareWithLimit(gzip.DefaultCompression, limit)(handler).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if rec.Body.String() != strconv.Itoa(limit) {
+		t.Errorf("Expected handler to read %d bytes, got %s", limit, rec.Body.String())
+	}
+}
+
+func TestGzipMiddleware_PreCompressed(t *testing.T) {
+	payload := strings.Repeat(`{"id":"Alloc","type":"gauge","value":1.5}`, 100)
+	var precompressed bytes.Buffer
+	gz := gzip.NewWriter(&precompressed)
+	gz.Write([]byte(payload))
+	gz.Close()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Encoding", "gzip")
+		w.Write(precompressed.Bytes())
+	})
+
+	for _, minSize := range []int{0, DefaultMinCompressSize} {
+		req := httptest.NewRequest("GET", "/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+		rec := httptest.NewRecorder()
+
+		GzipMiddlewareWithMinSize(gzip.DefaultCompression, 0, minSize)(handler).ServeHTTP(rec, req)
+
+		if got := rec.Header().Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("minSize %d: expected the handler's Content-Encoding gzip, got %q", minSize, got)
+		}
+		// Decompressing once must yield the payload, i.e. it was not compressed twice
+		gr, err := gzip.NewReader(rec.Body)
+		if err != nil {
+			t.Fatalf("minSize %d: failed to create gzip reader: %v", minSize, err)
+		}
+		decompressed, err := io.ReadAll(gr)
+		if err != nil {
+			t.Fatalf("minSize %d: failed to decompress: %v", minSize, err)
+		}
+		if string(decompressed) != payload {
+			t.Errorf("minSize %d: expected the pre-compressed body to be passed through", minSize)
+		}
+	}
+}
+
+func TestGzipMiddlewareWithMinSize(t *testing.T) {
+	tests := []struct {
+		name         string
+		chunks       []string
+		status       int
+		wantEncoding string
+	}{
+		{"tiny body", []string{`{"id":"Alloc","type":"gauge","value":1.5}`}, http.StatusOK, ""},
+		{"tiny error", []string{"metric not found"}, http.StatusNotFound, ""},
+		{"empty body", nil, http.StatusNoContent, ""},
+		{"large body", []string{strings.Repeat("a", DefaultMinCompressSize)}, http.StatusOK, "gzip"},
+		{"large body in small writes", slices.Repeat([]string{strings.Repeat("a", 100)}, 20), http.StatusCreated, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(tt.status)
+				for _, chunk := range tt.chunks {
+					w.Write([]byte(chunk))
+				}
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+
+			GzipMiddlewareWithMinSize(gzip.DefaultCompression, 0, DefaultMinCompressSize)(handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.status {
+				t.Errorf("Expected status %d, got %d", tt.status, rec.Code)
+			}
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Expected Content-Encoding %q, got %q", tt.wantEncoding, got)
+			}
+
+			body := io.Reader(rec.Body)
+			if tt.wantEncoding == "gzip" {
+				gr, err := gzip.NewReader(rec.Body)
+				if err != nil {
+					t.Fatalf("Failed to create gzip reader: %v", err)
+				}
+				body = gr
+			}
+			got, err := io.ReadAll(body)
+			if err != nil {
+				t.Fatalf("Failed to read body: %v", err)
+			}
+			if want := strings.Join(tt.chunks, ""); string(got) != want {
+				t.Errorf("Expected body of %d bytes, got %d", len(want), len(got))
+			}
+		})
+	}
+}
+
+func TestGzipMiddlewareMinSize_ContentLength(t *testing.T) {
+	tests := []struct {
+		name         string
+		size         int
+		wantEncoding string
+	}{
+		{"below threshold", DefaultMinCompressSize - 1, ""},
+		{"at threshold", DefaultMinCompressSize, "gzip"},
+		{"above threshold", 4 * DefaultMinCompressSize, "gzip"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := strings.Repeat("a", tt.size)
+			handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", "application/json")
+				w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+				w.Write([]byte(body))
+			})
+
+			req := httptest.NewRequest("GET", "/", nil)
+			req.Header.Set("Accept-Encoding", "gzip")
+			rec := httptest.NewRecorder()
+
+			GzipMiddlewareMinSize(DefaultMinCompressSize)(handler).ServeHTTP(rec, req)
+
+			if got := rec.Header().Get("Content-Encoding"); got != tt.wantEncoding {
+				t.Fatalf("Expected Content-Encoding %q, got %q", tt.wantEncoding, got)
+			}
+			if tt.wantEncoding == "" {
+				if rec.Body.String() != body || rec.Header().Get("Content-Length") != strconv.Itoa(tt.size) {
+					t.Error("Expected the body and Content-Length to be passed through")
+				}
+				return
+			}
+			if rec.Header().Get("Content-Length") != "" {
+				t.Error("Expected Content-Length to be removed from a compressed response")
+			}
+			gr, err := gzip.NewReader(rec.Body)
+			if err != nil {
+				t.Fatalf("Failed to create gzip reader: %v", err)
+			}
+			if got, _ := io.ReadAll(gr); string(got) != body {
+				t.Errorf("Decompressed body does not match")
+			}
+		})
+	}
+}
@@ -9,8 +9,24 @@ import (
 	"github.com/rs/zerolog/log"
 )
 
-// HashVerification returns middleware that verifies SHA256 hash signatures
+// HashVerification returns middleware that verifies SHA256 hash signatures.
+// Requests without a HashSHA256 header are passed through unverified.
 func HashVerification(key string) func(http.Handler) http.Handler {
+	return HashVerificationAlgo(key, hash.AlgoSHA256, false)
+}
+
+// HashVerificationStrict is like HashVerification, but rejects requests with a body
+// that carry no HashSHA256 header with 400 Bad Request
+func HashVerificationStrict(key string) func(http.Handler) http.Handler {
+	return HashVerificationAlgo(key, hash.AlgoSHA256, true)
+}
+
+// HashVerificationAlgo returns middleware that verifies HMAC signatures made with algo
+// ("sha256" or "sha512"), read from the header hash.HeaderName(algo) returns.
+// In strict mode requests with a body but without a signature are rejected.
+func HashVerificationAlgo(key, algo string, strict bool) func(http.Handler) http.Handler {
+	header := hash.HeaderName(algo)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// If no key is configured, skip hash verification
@@ -26,12 +42,20 @@ func HashVerification(key string) func(http.Handler) http.Handler {
 			}
 
 			// Get the provided hash from header
-			providedHash := r.Header.Get("HashSHA256")
+			providedHash := r.Header.Get(header)
 
 			// If no hash is provided, allow the request to pass through
 			// This allows test clients to work without hashes while
 			// still verifying hashes when they are provided (like from agent)
 			if providedHash == "" {
+				if strict {
+					log.Warn().
+						Str("method", r.Method).
+						Str("url", r.URL.Path).
+						Msg("Rejected unsigned request")
+					http.Error(w, "Missing "+header+" header", http.StatusBadRequest)
+					return
+				}
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -48,7 +72,7 @@ func HashVerification(key string) func(http.Handler) http.Handler {
 			r.Body = io.NopCloser(bytes.NewReader(body))
 
 			// Verify the hash
-			if !hash.VerifyHash(body, key, providedHash) {
+			if !hash.Verify(body, key, providedHash, algo) {
 				log.Warn().
 					Str("provided_hash", providedHash).
 					Str("method", r.Method).
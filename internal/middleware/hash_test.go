@@ -0,0 +1,102 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/mutualEvg/metrics-server/internal/hash"
+)
+
+func TestHashVerificationStrict(t *testing.T) {
+	const key = "secret"
+	body := `[{"id":"Alloc","type":"gauge","value":1}]`
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		hash           string
+		strict         bool
+		expectedStatus int
+	}{
+		{"strict missing header", "", true, http.StatusBadRequest},
+		{"strict wrong hash", hash.CalculateHash([]byte(body), "other"), true, http.StatusBadRequest},
+		{"strict correct hash", hash.CalculateHash([]byte(body), key), true, http.StatusOK},
+		{"lenient missing header", "", false, http.StatusOK},
+		{"lenient wrong hash", hash.CalculateHash([]byte(body), "other"), false, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			mw := HashVerification(key)
+			if tt.strict {
+				mw = HashVerificationStrict(key)
+			}
+
+			req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+			if tt.hash != "" {
+				req.Header.Set("HashSHA256", tt.hash)
+			}
+			rec := httptest.NewRecorder()
+
+			mw(handler).ServeHTTP(rec, req)
+
+			if rec.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rec.Code)
+			}
+		})
+	}
+}
+
+func TestHashVerificationStrict_NoBody(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// Requests without a body have nothing to sign
+	rec := httptest.NewRecorder()
+	HashVerificationStrict("secret")(handler).ServeHTTP(rec, httptest.NewRequest("GET", "/", nil))
+
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestHashVerificationAlgo_SHA512(t *testing.T) {
+	const key = "secret"
+	body := `[{"id":"Alloc","type":"gauge","value":1}]`
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"ok":true}`))
+	})
+	mw := HashVerificationAlgo(key, hash.AlgoSHA512, true)
+	signed := ResponseHashAlgo(key, hash.AlgoSHA512)(mw(handler))
+
+	// A sha512 signature in the HashSHA512 header is accepted and the response is signed
+	req := httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+	req.Header.Set("HashSHA512", hash.Calculate([]byte(body), key, hash.AlgoSHA512))
+	rec := httptest.NewRecorder()
+	signed.ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusOK {
+		t.Fatalf("Expected status %d, got %d", http.StatusOK, rec.Code)
+	}
+	if got := rec.Header().Get("HashSHA512"); !hash.Verify(rec.Body.Bytes(), key, got, hash.AlgoSHA512) {
+		t.Errorf("Expected a valid HashSHA512 response header, got %q", got)
+	}
+
+	// A sha256 signature is not accepted in sha512 mode
+	req = httptest.NewRequest("POST", "/updates/", strings.NewReader(body))
+	req.Header.Set("HashSHA256", hash.CalculateHash([]byte(body), key))
+	rec = httptest.NewRecorder()
+	mw(handler).ServeHTTP(rec, req)
+
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected status %d for a sha256 signature, got %d", http.StatusBadRequest, rec.Code)
+	}
+}
@@ -3,9 +3,11 @@ package middleware_test
 import (
 	"bytes"
 	"compress/gzip"
+	"fmt"
 	"io"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"strings"
 	"testing"
 
@@ -71,6 +73,41 @@ func BenchmarkGzipMiddlewareLargePayload(b *testing.B) {
 	}
 }
 
+// BenchmarkGzipMiddlewareLevels compares compression levels on small and large payloads
+func BenchmarkGzipMiddlewareLevels(b *testing.B) {
+	payloads := map[string]string{
+		"Small": strings.Repeat("Hello, World! This is test data for compression benchmarking. ", 100),     // ~6KB
+		"Large": strings.Repeat("Large payload data for compression testing with gzip middleware. ", 1000), // ~60KB
+	}
+	levels := map[string]int{
+		"BestSpeed":          gzip.BestSpeed,
+		"DefaultCompression": gzip.DefaultCompression,
+		"BestCompression":    gzip.BestCompression,
+	}
+
+	for payloadName, data := range payloads {
+		for levelName, level := range levels {
+			b.Run(payloadName+"/"+levelName, func(b *testing.B) {
+				handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.Header().Set("Content-Type", "text/plain")
+					w.Write([]byte(data))
+				})
+
+				gzipHandler := middleware.GzipMiddlewareLevel(level)(handler)
+
+				b.ResetTimer()
+				for i := 0; i < b.N; i++ {
+					req := httptest.NewRequest("GET", "/", nil)
+					req.Header.Set("Accept-Encoding", "gzip")
+
+					w := httptest.NewRecorder()
+					gzipHandler.ServeHTTP(w, req)
+				}
+			})
+		}
+	}
+}
+
 // BenchmarkGzipMiddlewareWithoutCompression benchmarks without gzip compression
 func BenchmarkGzipMiddlewareWithoutCompression(b *testing.B) {
 	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -213,3 +250,29 @@ func BenchmarkMiddlewareChain(b *testing.B) {
 		chainedHandler.ServeHTTP(w, req)
 	}
 }
+
+// BenchmarkGzipMiddlewareMinSize compares a small JSON response, like a single-metric read,
+// compressed unconditionally and sent uncompressed below the default minimum size
+func BenchmarkGzipMiddlewareMinSize(b *testing.B) {
+	body := []byte(`{"id":"Alloc","type":"gauge","value":123.45}`)
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Content-Length", strconv.Itoa(len(body)))
+		w.Write(body)
+	})
+
+	for _, minSize := range []int{0, middleware.DefaultMinCompressSize} {
+		b.Run(fmt.Sprintf("MinSize%d", minSize), func(b *testing.B) {
+			gzipHandler := middleware.GzipMiddlewareMinSize(minSize)(handler)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				req := httptest.NewRequest("GET", "/", nil)
+				req.Header.Set("Accept-Encoding", "gzip")
+
+				w := httptest.NewRecorder()
+				gzipHandler.ServeHTTP(w, req)
+			}
+		})
+	}
+}
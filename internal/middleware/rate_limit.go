@@ -0,0 +1,129 @@
+package middleware
+
+import (
+	"log"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	// rateLimiterIdleTTL is how long an idle client's token bucket is kept
+	rateLimiterIdleTTL = 3 * time.Minute
+	// rateLimiterSweepInterval is how often idle token buckets are removed
+	rateLimiterSweepInterval = time.Minute
+	// rateLimiterMaxClients is the most clients tracked with their own token bucket.
+	// Further clients share a single overflow bucket until idle ones are swept.
+	rateLimiterMaxClients = 10000
+)
+
+// clientLimiter is a token bucket for a single client IP
+type clientLimiter struct {
+	limiter  *rate.Limiter
+	lastSeen time.Time
+}
+
+// ipRateLimiter keeps one token bucket per client IP
+type ipRateLimiter struct {
+	mu         sync.Mutex
+	clients    map[string]*clientLimiter
+	overflow   *rate.Limiter // Shared by clients beyond maxClients
+	maxClients int
+	limit      rate.Limit
+	burst      int
+	lastSweep  time.Time
+}
+
+// allow reports whether a request from ip may proceed
+func (l *ipRateLimiter) allow(ip string) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(l.lastSweep) > rateLimiterSweepInterval {
+		for key, client := range l.clients {
+			if now.Sub(client.lastSeen) > rateLimiterIdleTTL {
+				delete(l.clients, key)
+			}
+		}
+		l.lastSweep = now
+	}
+
+	client, ok := l.clients[ip]
+	if !ok {
+		if len(l.clients) >= l.maxClients {
+			return l.overflow.Allow()
+		}
+		client = &clientLimiter{limiter: rate.NewLimiter(l.limit, l.burst)}
+		l.clients[ip] = client
+	}
+	client.lastSeen = now
+
+	return client.limiter.Allow()
+}
+
+// RateLimitMiddleware returns middleware that limits each client IP to rps requests
+// per second with the given burst. The client is identified by the connection address;
+// the X-Real-IP header is only believed from peers within trustedProxies, since any
+// other client could evade its limit by changing the header. Requests over the limit
+// get 429 Too Many Requests. If rps is 0 or less, all requests are allowed.
+func RateLimitMiddleware(rps int, burst int, trustedProxies TrustedSubnets) func(http.Handler) http.Handler {
+	if burst <= 0 {
+		burst = rps
+	}
+
+	limiter := &ipRateLimiter{
+		clients:    make(map[string]*clientLimiter),
+		overflow:   rate.NewLimiter(rate.Limit(rps), burst),
+		maxClients: rateLimiterMaxClients,
+		limit:      rate.Limit(rps),
+		burst:      burst,
+		lastSweep:  time.Now(),
+	}
+
+	return func(next http.Handler) http.Handler {
+		if rps <= 0 {
+			return next
+		}
+
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ip := PeerIP(r, trustedProxies)
+			if !limiter.allow(ip) {
+				log.Printf("Request from %s rejected: rate limit of %d rps exceeded", ip, rps)
+				w.Header().Set("Retry-After", "1")
+				http.Error(w, "Too Many Requests", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// ClientIP returns the X-Real-IP header or the host part of the remote address
+func ClientIP(r *http.Request) string {
+	if realIP := r.Header.Get("X-Real-IP"); realIP != "" {
+		return realIP
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// PeerIP returns the address a request came from: the host part of the remote
+// address, or the X-Real-IP header when the peer is one of the trusted proxies
+func PeerIP(r *http.Request, trustedProxies TrustedSubnets) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if peer, ok := ParseRealIP(host); ok && trustedProxies.Contains(peer) {
+		if realIP, ok := ParseRealIP(r.Header.Get("X-Real-IP")); ok {
+			return realIP.Unmap().String()
+		}
+	}
+	return host
+}
@@ -0,0 +1,128 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+func TestRateLimitMiddleware(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// 1 rps with a burst of 5: the first 5 requests pass, the rest are rejected
+	limited := RateLimitMiddleware(1, 5, nil)(handler)
+
+	const total = 20
+	var ok, rejected int
+	for i := 0; i < total; i++ {
+		req := httptest.NewRequest("POST", "/update/", nil)
+		req.RemoteAddr = "10.0.0.1:40000"
+		rec := httptest.NewRecorder()
+
+		limited.ServeHTTP(rec, req)
+
+		switch rec.Code {
+		case http.StatusOK:
+			ok++
+		case http.StatusTooManyRequests:
+			rejected++
+		default:
+			t.Fatalf("Unexpected status %d", rec.Code)
+		}
+	}
+
+	if ok != 5 {
+		t.Errorf("Expected 5 allowed requests, got %d", ok)
+	}
+	if rejected != total-5 {
+		t.Errorf("Expected %d rejected requests, got %d", total-5, rejected)
+	}
+
+	// Another client has its own bucket
+	req := httptest.NewRequest("POST", "/update/", nil)
+	req.RemoteAddr = "10.0.0.2:40000"
+	rec := httptest.NewRecorder()
+	limited.ServeHTTP(rec, req)
+	if rec.Code != http.StatusOK {
+		t.Errorf("Expected status %d for a different client, got %d", http.StatusOK, rec.Code)
+	}
+}
+
+func TestRateLimitMiddleware_Disabled(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	limited := RateLimitMiddleware(0, 0, nil)(handler)
+
+	for i := 0; i < 100; i++ {
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, httptest.NewRequest("POST", "/update/", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("Expected status %d with rate limiting disabled, got %d", http.StatusOK, rec.Code)
+		}
+	}
+}
+
+func TestRateLimitMiddleware_XRealIP(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+	limited := RateLimitMiddleware(1, 1, ParseTrustedSubnets("192.168.0.0/24"))(handler)
+
+	send := func(remoteAddr, realIP string) int {
+		req := httptest.NewRequest("POST", "/update/", nil)
+		req.RemoteAddr = remoteAddr
+		req.Header.Set("X-Real-IP", realIP)
+		rec := httptest.NewRecorder()
+		limited.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	// A direct client cannot escape its limit by rotating X-Real-IP
+	if code := send("10.0.0.1:40000", "172.16.0.1"); code != http.StatusOK {
+		t.Fatalf("Expected the first request to pass, got %d", code)
+	}
+	if code := send("10.0.0.1:40000", "172.16.0.2"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected a forged X-Real-IP to share the connection's bucket, got %d", code)
+	}
+
+	// Behind a trusted proxy every forwarded client has its own bucket
+	if code := send("192.168.0.10:40000", "172.16.0.1"); code != http.StatusOK {
+		t.Errorf("Expected the first proxied client to pass, got %d", code)
+	}
+	if code := send("192.168.0.10:40000", "172.16.0.2"); code != http.StatusOK {
+		t.Errorf("Expected the second proxied client to pass, got %d", code)
+	}
+	if code := send("192.168.0.10:40000", "172.16.0.1"); code != http.StatusTooManyRequests {
+		t.Errorf("Expected the first proxied client to be limited, got %d", code)
+	}
+}
+
+func TestRateLimiterMaxClients(t *testing.T) {
+	limiter := &ipRateLimiter{
+		clients:    make(map[string]*clientLimiter),
+		overflow:   rate.NewLimiter(1, 1),
+		maxClients: 2,
+		limit:      1,
+		burst:      1,
+		lastSweep:  time.Now(),
+	}
+
+	limiter.allow("10.0.0.1")
+	limiter.allow("10.0.0.2")
+	if !limiter.allow("10.0.0.3") {
+		t.Error("Expected the first client beyond the cap to use the overflow bucket")
+	}
+	if limiter.allow("10.0.0.4") {
+		t.Error("Expected clients beyond the cap to share the overflow bucket")
+	}
+	if len(limiter.clients) != 2 {
+		t.Errorf("Expected 2 tracked clients, got %d", len(limiter.clients))
+	}
+}
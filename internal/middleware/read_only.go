@@ -0,0 +1,19 @@
+package middleware
+
+import (
+	"net/http"
+)
+
+// ReadOnly returns middleware that rejects writes with 403 Forbidden when enabled, e.g.
+// on a read replica whose state is replaced by the primary's on every sync. When not
+// enabled, requests pass through unchanged.
+func ReadOnly(enabled bool) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		if !enabled {
+			return next
+		}
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			http.Error(w, "Read-only replica: send updates to the primary server", http.StatusForbidden)
+		})
+	}
+}
@@ -0,0 +1,32 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestReadOnly(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name     string
+		enabled  bool
+		wantCode int
+	}{
+		{"replica rejects writes", true, http.StatusForbidden},
+		{"primary accepts writes", false, http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			ReadOnly(tt.enabled)(handler).ServeHTTP(rec, httptest.NewRequest("POST", "/update/", nil))
+			if rec.Code != tt.wantCode {
+				t.Errorf("Expected status %d, got %d", tt.wantCode, rec.Code)
+			}
+		})
+	}
+}
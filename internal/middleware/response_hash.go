@@ -26,6 +26,14 @@ func (rw *responseHashWriter) WriteHeader(statusCode int) {
 
 // ResponseHash returns middleware that adds SHA256 hash to response headers
 func ResponseHash(key string) func(http.Handler) http.Handler {
+	return ResponseHashAlgo(key, hash.AlgoSHA256)
+}
+
+// ResponseHashAlgo returns middleware that signs responses with an HMAC using algo,
+// in the header hash.HeaderName(algo) returns
+func ResponseHashAlgo(key, algo string) func(http.Handler) http.Handler {
+	header := hash.HeaderName(algo)
+
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// If no key is configured, skip hash generation
@@ -47,8 +55,8 @@ func ResponseHash(key string) func(http.Handler) http.Handler {
 			// Calculate hash of the response body
 			responseData := rw.buffer.Bytes()
 			if len(responseData) > 0 {
-				responseHash := hash.CalculateHash(responseData, key)
-				w.Header().Set("HashSHA256", responseHash)
+				responseHash := hash.Calculate(responseData, key, algo)
+				w.Header().Set(header, responseHash)
 			}
 
 			// Write the actual response
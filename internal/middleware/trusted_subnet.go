@@ -2,32 +2,73 @@ package middleware
 
 import (
 	"log"
-	"net"
 	"net/http"
+	"net/netip"
+	"strings"
 )
 
-// TrustedSubnetMiddleware validates that the X-Real-IP header contains an IP
-// that belongs to the trusted subnet (CIDR notation).
-// If trustedSubnet is empty, all requests are allowed.
-func TrustedSubnetMiddleware(trustedSubnet string) func(http.Handler) http.Handler {
-	var ipNet *net.IPNet
-	var err error
+// TrustedSubnets is a list of CIDR ranges that requests are allowed to come from.
+// Both IPv4 and IPv6 prefixes are supported.
+type TrustedSubnets []netip.Prefix
+
+// ParseTrustedSubnets parses a comma-separated list of CIDRs.
+// Invalid entries are skipped with a warning. A nil result means no restriction.
+func ParseTrustedSubnets(trustedSubnets string) TrustedSubnets {
+	var subnets TrustedSubnets
+	for _, cidr := range strings.Split(trustedSubnets, ",") {
+		cidr = strings.TrimSpace(cidr)
+		if cidr == "" {
+			continue
+		}
 
-	// Parse the trusted subnet if provided
-	if trustedSubnet != "" {
-		_, ipNet, err = net.ParseCIDR(trustedSubnet)
+		prefix, err := netip.ParsePrefix(cidr)
 		if err != nil {
-			log.Printf("Warning: Invalid trusted subnet CIDR %s: %v. All IPs will be allowed.", trustedSubnet, err)
-			ipNet = nil
-		} else {
-			log.Printf("Trusted subnet configured: %s", trustedSubnet)
+			log.Printf("Warning: Invalid trusted subnet CIDR %s: %v. Entry ignored.", cidr, err)
+			continue
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+	return subnets
+}
+
+// Contains reports whether ip belongs to any of the subnets.
+// IPv4-mapped IPv6 addresses are matched against IPv4 prefixes.
+func (s TrustedSubnets) Contains(ip netip.Addr) bool {
+	ip = ip.Unmap()
+	for _, prefix := range s {
+		if prefix.Contains(ip) {
+			return true
 		}
 	}
+	return false
+}
+
+// ParseRealIP parses an X-Real-IP value. IPv6 addresses may be enclosed
+// in brackets and carry a zone, which is ignored for subnet matching.
+func ParseRealIP(realIP string) (netip.Addr, bool) {
+	realIP = strings.TrimSuffix(strings.TrimPrefix(strings.TrimSpace(realIP), "["), "]")
+	ip, err := netip.ParseAddr(realIP)
+	if err != nil {
+		return netip.Addr{}, false
+	}
+	return ip.WithZone(""), true
+}
+
+// TrustedSubnetMiddleware validates that the X-Real-IP header contains an IP
+// that belongs to one of the trusted subnets (comma-separated CIDR notation).
+// If trustedSubnet is empty or contains no valid CIDR, all requests are allowed.
+func TrustedSubnetMiddleware(trustedSubnet string) func(http.Handler) http.Handler {
+	subnets := ParseTrustedSubnets(trustedSubnet)
+	if len(subnets) > 0 {
+		log.Printf("Trusted subnet configured: %s", trustedSubnet)
+	} else if trustedSubnet != "" {
+		log.Printf("Warning: No valid trusted subnet in %s. All IPs will be allowed.", trustedSubnet)
+	}
 
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			// If no trusted subnet is configured, allow all requests
-			if ipNet == nil {
+			if len(subnets) == 0 {
 				next.ServeHTTP(w, r)
 				return
 			}
@@ -41,15 +82,15 @@ func TrustedSubnetMiddleware(trustedSubnet string) func(http.Handler) http.Handl
 			}
 
 			// Parse the IP address
-			ip := net.ParseIP(realIP)
-			if ip == nil {
+			ip, ok := ParseRealIP(realIP)
+			if !ok {
 				log.Printf("Request rejected: Invalid IP address in X-Real-IP header: %s", realIP)
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
 			}
 
-			// Check if IP is in the trusted subnet
-			if !ipNet.Contains(ip) {
+			// Check if IP is in any of the trusted subnets
+			if !subnets.Contains(ip) {
 				log.Printf("Request from %s rejected: IP not in trusted subnet %s", realIP, trustedSubnet)
 				http.Error(w, "Forbidden", http.StatusForbidden)
 				return
@@ -91,6 +91,36 @@ func TestTrustedSubnetMiddleware(t *testing.T) {
 			realIP:         "192.168.1.101",
 			expectedStatus: http.StatusForbidden,
 		},
+		{
+			name:           "IP in second of three subnets",
+			trustedSubnet:  "10.0.0.0/8, 192.168.1.0/24,172.16.0.0/12",
+			realIP:         "192.168.1.20",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IP outside all of three subnets",
+			trustedSubnet:  "10.0.0.0/8,192.168.1.0/24,172.16.0.0/12",
+			realIP:         "192.168.2.20",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "IPv6 address in mixed subnet list",
+			trustedSubnet:  "10.0.0.0/8,2001:db8::/32,fd00::/8",
+			realIP:         "fd12:3456::1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv6 address outside mixed subnet list",
+			trustedSubnet:  "10.0.0.0/8,2001:db8::/32",
+			realIP:         "2001:db9::1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "Invalid entry in list is ignored",
+			trustedSubnet:  "invalid-cidr,192.168.1.0/24",
+			realIP:         "10.0.0.1",
+			expectedStatus: http.StatusForbidden,
+		},
 	}
 
 	for _, tt := range tests {
@@ -147,3 +177,81 @@ func TestTrustedSubnetMiddleware_InvalidCIDR(t *testing.T) {
 		t.Errorf("Expected status %d for invalid CIDR, got %d", http.StatusOK, rr.Code)
 	}
 }
+
+func TestTrustedSubnetMiddleware_DualStack(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	tests := []struct {
+		name           string
+		trustedSubnet  string
+		realIP         string
+		expectedStatus int
+	}{
+		{
+			name:           "IPv4-mapped IPv6 address in IPv4 subnet",
+			trustedSubnet:  "192.168.1.0/24",
+			realIP:         "::ffff:192.168.1.10",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Bracketed IPv6 address",
+			trustedSubnet:  "2001:db8::/32",
+			realIP:         "[2001:db8::42]",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Link-local IPv6 address with zone",
+			trustedSubnet:  "fe80::/10",
+			realIP:         "fe80::1%eth0",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Non-canonical IPv6 CIDR is masked",
+			trustedSubnet:  "2001:db8::1/32",
+			realIP:         "2001:db8:ffff::1",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "IPv6 address against IPv4-only subnets",
+			trustedSubnet:  "10.0.0.0/8,192.168.0.0/16",
+			realIP:         "2001:db8::1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "IPv4 address against IPv6-only subnets",
+			trustedSubnet:  "2001:db8::/32,fd00::/8",
+			realIP:         "10.0.0.1",
+			expectedStatus: http.StatusForbidden,
+		},
+		{
+			name:           "IPv4 address in mixed list",
+			trustedSubnet:  "2001:db8::/32,10.0.0.0/8",
+			realIP:         "10.1.2.3",
+			expectedStatus: http.StatusOK,
+		},
+		{
+			name:           "Malformed IPv6 address",
+			trustedSubnet:  "2001:db8::/32",
+			realIP:         "2001:db8:::1",
+			expectedStatus: http.StatusForbidden,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			wrappedHandler := TrustedSubnetMiddleware(tt.trustedSubnet)(handler)
+
+			req := httptest.NewRequest("POST", "/update/", nil)
+			req.Header.Set("X-Real-IP", tt.realIP)
+			rr := httptest.NewRecorder()
+
+			wrappedHandler.ServeHTTP(rr, req)
+
+			if rr.Code != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d", tt.expectedStatus, rr.Code)
+			}
+		})
+	}
+}
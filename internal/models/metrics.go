@@ -1,6 +1,32 @@
 // Package models defines data structures for the metrics server API.
 package models
 
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// DefaultMaxMetricSize is the default limit in bytes for a single serialized metric.
+// It is generous enough for any legitimate metric name.
+const DefaultMaxMetricSize = 4096
+
+const (
+	// GaugeType is the MType of floating-point metrics set to their latest value
+	GaugeType = "gauge"
+
+	// CounterType is the MType of integer metrics accumulating their deltas
+	CounterType = "counter"
+
+	// FloatCounterType is the MType of floating-point metrics accumulating their deltas,
+	// e.g. byte totals reported as floats. The delta and the total are carried in Value.
+	FloatCounterType = "floatcounter"
+)
+
+// ErrInvalidMetric is returned by Validate for a metric without a name, of an unknown
+// type or missing the value of its type
+var ErrInvalidMetric = errors.New("invalid metric")
+
 // Metrics represents the structure for JSON API communication with the metrics server.
 // It supports both gauge (floating-point) and counter (integer) metric types.
 // Only one of Delta or Value should be set depending on the metric type.
@@ -8,16 +34,61 @@ type Metrics struct {
 	// ID is the unique name/identifier of the metric
 	ID string `json:"id"`
 
-	// MType specifies the metric type: "gauge" or "counter"
+	// MType specifies the metric type: GaugeType, CounterType or FloatCounterType
 	MType string `json:"type"`
 
 	// Delta contains the value for counter metrics (integer)
 	// This field is omitted from JSON if nil
 	Delta *int64 `json:"delta,omitempty"`
 
-	// Value contains the value for gauge metrics (floating-point)
+	// Value contains the value for gauge metrics and the delta or total for float counters
 	// This field is omitted from JSON if nil
 	Value *float64 `json:"value,omitempty"`
+
+	// Timestamp is the optional collection time as a Unix timestamp in milliseconds.
+	// When set, the server stores it instead of its own receive time.
+	// This field is omitted from JSON if nil
+	Timestamp *int64 `json:"timestamp,omitempty"`
+}
+
+// Size returns the approximate size of the metric in bytes when serialized,
+// counting its name, type and value representation
+func (m Metrics) Size() int {
+	size := len(m.ID) + len(m.MType)
+	if m.Delta != nil {
+		size += len(strconv.FormatInt(*m.Delta, 10))
+	}
+	if m.Value != nil {
+		size += len(strconv.FormatFloat(*m.Value, 'g', -1, 64))
+	}
+	return size
+}
+
+// Validate checks that the metric has an ID and a known type and that the pointer of
+// its type is set: Value for gauges and float counters, Delta for counters. Errors wrap
+// ErrInvalidMetric.
+func (m Metrics) Validate() error {
+	if m.ID == "" || m.MType == "" {
+		return fmt.Errorf("%w: ID and MType are required", ErrInvalidMetric)
+	}
+
+	switch m.MType {
+	case GaugeType:
+		if m.Value == nil {
+			return fmt.Errorf("%w: value is required for gauge metric %s", ErrInvalidMetric, m.ID)
+		}
+	case CounterType:
+		if m.Delta == nil {
+			return fmt.Errorf("%w: delta is required for counter metric %s", ErrInvalidMetric, m.ID)
+		}
+	case FloatCounterType:
+		if m.Value == nil {
+			return fmt.Errorf("%w: value is required for floatcounter metric %s", ErrInvalidMetric, m.ID)
+		}
+	default:
+		return fmt.Errorf("%w: unknown metric type %s", ErrInvalidMetric, m.MType)
+	}
+	return nil
 }
 
 // generate:reset
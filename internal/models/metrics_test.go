@@ -0,0 +1,54 @@
+package models
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestMetrics_Validate(t *testing.T) {
+	value, delta := 1.5, int64(2)
+
+	tests := []struct {
+		name        string
+		metric      Metrics
+		errContains string // empty for a valid metric
+	}{
+		{"gauge", Metrics{ID: "cpu", MType: GaugeType, Value: &value}, ""},
+		{"counter", Metrics{ID: "requests", MType: CounterType, Delta: &delta}, ""},
+		{"gauge with extra delta", Metrics{ID: "cpu", MType: GaugeType, Value: &value, Delta: &delta}, ""},
+		{"counter with extra value", Metrics{ID: "requests", MType: CounterType, Delta: &delta, Value: &value}, ""},
+		{"float counter", Metrics{ID: "bytes", MType: FloatCounterType, Value: &value}, ""},
+		{"float counter without value", Metrics{ID: "bytes", MType: FloatCounterType}, "value is required for floatcounter"},
+		{"float counter with only delta", Metrics{ID: "bytes", MType: FloatCounterType, Delta: &delta}, "value is required for floatcounter"},
+		{"gauge without value", Metrics{ID: "cpu", MType: GaugeType}, "value is required"},
+		{"gauge with only delta", Metrics{ID: "cpu", MType: GaugeType, Delta: &delta}, "value is required"},
+		{"counter without delta", Metrics{ID: "requests", MType: CounterType}, "delta is required"},
+		{"counter with only value", Metrics{ID: "requests", MType: CounterType, Value: &value}, "delta is required"},
+		{"missing ID", Metrics{MType: GaugeType, Value: &value}, "ID and MType are required"},
+		{"missing type", Metrics{ID: "cpu", Value: &value}, "ID and MType are required"},
+		{"empty metric", Metrics{}, "ID and MType are required"},
+		{"misspelled type", Metrics{ID: "cpu", MType: "guage", Value: &value}, "unknown metric type guage"},
+		{"capitalized type", Metrics{ID: "cpu", MType: "Gauge", Value: &value}, "unknown metric type Gauge"},
+		{"unknown type", Metrics{ID: "latency", MType: "histogram", Value: &value, Delta: &delta}, "unknown metric type histogram"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.metric.Validate()
+			if tt.errContains == "" {
+				if err != nil {
+					t.Errorf("Expected valid metric, got %v", err)
+				}
+				return
+			}
+
+			if !errors.Is(err, ErrInvalidMetric) {
+				t.Fatalf("Expected ErrInvalidMetric, got %v", err)
+			}
+			if !strings.Contains(err.Error(), tt.errContains) {
+				t.Errorf("Expected error containing %q, got %q", tt.errContains, err)
+			}
+		})
+	}
+}
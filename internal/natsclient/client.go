@@ -0,0 +1,67 @@
+package natsclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// DefaultSubject is the subject metric batches are published to unless configured otherwise
+const DefaultSubject = "metrics.updates"
+
+// MetricsClient publishes metric batches as JSON arrays to a NATS subject
+type MetricsClient struct {
+	conn    *nats.Conn
+	subject string
+}
+
+// NewMetricsClient connects to the NATS server at url, e.g. nats://localhost:4222,
+// and publishes to subject. The connection reconnects on its own, buffering
+// publishes while disconnected. Additional options, e.g. credentials, can be supplied.
+func NewMetricsClient(url, subject string, opts ...nats.Option) (*MetricsClient, error) {
+	opts = append([]nats.Option{nats.Name("metrics-agent"), nats.MaxReconnects(-1)}, opts...)
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	log.Printf("NATS client connected to %s, publishing to %s", conn.ConnectedUrl(), subject)
+	return &MetricsClient{conn: conn, subject: subject}, nil
+}
+
+// Close drains pending publishes and closes the NATS connection
+func (c *MetricsClient) Close() error {
+	if c.conn != nil {
+		return c.conn.Drain()
+	}
+	return nil
+}
+
+// SendMetrics publishes a batch of metrics and waits until the NATS server has
+// received it, or ctx is done
+func (c *MetricsClient) SendMetrics(ctx context.Context, metrics []models.Metrics) error {
+	if len(metrics) == 0 {
+		return nil
+	}
+
+	data, err := json.Marshal(metrics)
+	if err != nil {
+		return fmt.Errorf("failed to marshal metrics: %w", err)
+	}
+	if err := c.conn.Publish(c.subject, data); err != nil {
+		return fmt.Errorf("failed to publish metrics: %w", err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	if err := c.conn.FlushWithContext(ctx); err != nil {
+		return fmt.Errorf("failed to flush metrics to NATS: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,3 @@
+// Package natsclient provides a NATS transport for metrics: a client publishing
+// metric batches to a subject and a subscriber storing the batches it receives.
+package natsclient
@@ -0,0 +1,251 @@
+package natsclient
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/nats-io/nats.go"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+// fakeBroker speaks just enough of the NATS protocol to relay PUB to SUB
+type fakeBroker struct {
+	ln net.Listener
+
+	mu   sync.Mutex
+	subs map[string][]subscription // subject -> subscriptions
+}
+
+type subscription struct {
+	conn net.Conn
+	sid  string
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	t.Helper()
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listen: %v", err)
+	}
+	b := &fakeBroker{ln: ln, subs: make(map[string][]subscription)}
+	t.Cleanup(func() { ln.Close() })
+	go b.serve()
+	return b
+}
+
+func (b *fakeBroker) URL() string {
+	return "nats://" + b.ln.Addr().String()
+}
+
+func (b *fakeBroker) serve() {
+	for {
+		conn, err := b.ln.Accept()
+		if err != nil {
+			return
+		}
+		go b.handle(conn)
+	}
+}
+
+func (b *fakeBroker) handle(conn net.Conn) {
+	defer conn.Close()
+	var writeMu sync.Mutex
+	write := func(c net.Conn, s string) {
+		writeMu.Lock()
+		defer writeMu.Unlock()
+		io.WriteString(c, s)
+	}
+	write(conn, `INFO {"server_id":"fake","version":"2.10.0","proto":1,"max_payload":1048576,"headers":false}`+"\r\n")
+
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+		switch strings.ToUpper(fields[0]) {
+		case "PING":
+			write(conn, "PONG\r\n")
+		case "SUB":
+			// SUB <subject> [queue] <sid>
+			b.mu.Lock()
+			b.subs[fields[1]] = append(b.subs[fields[1]], subscription{conn: conn, sid: fields[len(fields)-1]})
+			b.mu.Unlock()
+		case "PUB":
+			// PUB <subject> [reply-to] <size>
+			size, _ := strconv.Atoi(fields[len(fields)-1])
+			payload := make([]byte, size+2)
+			if _, err := io.ReadFull(r, payload); err != nil {
+				return
+			}
+			b.mu.Lock()
+			subs := b.subs[fields[1]]
+			b.mu.Unlock()
+			for _, s := range subs {
+				write(s.conn, fmt.Sprintf("MSG %s %s %d\r\n%s", fields[1], s.sid, size, payload))
+			}
+		}
+	}
+}
+
+func float64Ptr(v float64) *float64 { return &v }
+func int64Ptr(v int64) *int64       { return &v }
+
+// waitFor polls cond until it holds or a second passes
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(time.Second)
+	for !cond() {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+// startSubscriber starts a subscriber storing to s, calling configure with it first if not nil
+func startSubscriber(t *testing.T, broker *fakeBroker, s storage.Storage, configure func(*Subscriber)) {
+	t.Helper()
+	sub, err := NewSubscriber(broker.URL(), DefaultSubject, "", s, nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("NewSubscriber: %v", err)
+	}
+	t.Cleanup(func() { sub.Close() })
+	if configure != nil {
+		configure(sub)
+	}
+	if err := sub.Start(); err != nil {
+		t.Fatalf("Start: %v", err)
+	}
+}
+
+func TestSendMetricsIsStoredBySubscriber(t *testing.T) {
+	broker := newFakeBroker(t)
+	memStorage := storage.NewMemStorage()
+
+	startSubscriber(t, broker, memStorage, nil)
+
+	client, err := NewMetricsClient(broker.URL(), DefaultSubject, nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("NewMetricsClient: %v", err)
+	}
+	defer client.Close()
+
+	err = client.SendMetrics(context.Background(), []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: float64Ptr(1.5)},
+		{ID: "PollCount", MType: "counter", Delta: int64Ptr(3)},
+	})
+	if err != nil {
+		t.Fatalf("SendMetrics: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		_, ok := memStorage.GetCounter("PollCount")
+		return ok
+	})
+	if v, ok := memStorage.GetGauge("Alloc"); !ok || v != 1.5 {
+		t.Errorf("gauge Alloc = %v, %v; want 1.5, true", v, ok)
+	}
+	if v, _ := memStorage.GetCounter("PollCount"); v != 3 {
+		t.Errorf("counter PollCount = %d, want 3", v)
+	}
+}
+
+func TestSubscriberDropsInvalidBatch(t *testing.T) {
+	broker := newFakeBroker(t)
+	memStorage := storage.NewMemStorage()
+
+	startSubscriber(t, broker, memStorage, nil)
+
+	client, err := NewMetricsClient(broker.URL(), DefaultSubject, nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("NewMetricsClient: %v", err)
+	}
+	defer client.Close()
+
+	// The counter has no delta, so the valid gauge before it must not be stored either
+	err = client.SendMetrics(context.Background(), []models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: float64Ptr(1.5)},
+		{ID: "PollCount", MType: "counter"},
+	})
+	if err != nil {
+		t.Fatalf("SendMetrics: %v", err)
+	}
+	// A valid batch sent afterwards shows the invalid one has been handled
+	err = client.SendMetrics(context.Background(), []models.Metrics{
+		{ID: "Marker", MType: "gauge", Value: float64Ptr(1)},
+	})
+	if err != nil {
+		t.Fatalf("SendMetrics: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		_, ok := memStorage.GetGauge("Marker")
+		return ok
+	})
+	if _, ok := memStorage.GetGauge("Alloc"); ok {
+		t.Error("gauge from an invalid batch was stored")
+	}
+}
+
+func TestSubscriberAppliesWriteGuards(t *testing.T) {
+	broker := newFakeBroker(t)
+	memStorage := storage.NewMemStorage()
+	policy, err := storage.NewNamePolicy("", `^_`)
+	if err != nil {
+		t.Fatalf("NewNamePolicy: %v", err)
+	}
+	startSubscriber(t, broker, memStorage, func(sub *Subscriber) {
+		sub.SetMaxMetricSize(64)
+		sub.SetReservedPrefix("server_")
+		sub.SetNamePolicy(policy)
+	})
+
+	client, err := NewMetricsClient(broker.URL(), DefaultSubject, nats.NoReconnect())
+	if err != nil {
+		t.Fatalf("NewMetricsClient: %v", err)
+	}
+	defer client.Close()
+
+	rejected := map[string]models.Metrics{
+		"reserved":    {ID: "server_uptime", MType: "gauge", Value: float64Ptr(1)},
+		"name policy": {ID: "_hidden", MType: "gauge", Value: float64Ptr(1)},
+		"oversized":   {ID: strings.Repeat("x", 100), MType: "gauge", Value: float64Ptr(1)},
+	}
+	for _, m := range rejected {
+		if err := client.SendMetrics(context.Background(), []models.Metrics{m}); err != nil {
+			t.Fatalf("SendMetrics: %v", err)
+		}
+	}
+	// Float counters are accepted like over HTTP; being last, this shows the others were handled
+	err = client.SendMetrics(context.Background(), []models.Metrics{
+		{ID: "Energy", MType: "floatcounter", Value: float64Ptr(0.5)},
+	})
+	if err != nil {
+		t.Fatalf("SendMetrics: %v", err)
+	}
+
+	waitFor(t, func() bool {
+		_, ok := memStorage.GetFloatCounter("Energy")
+		return ok
+	})
+	for reason, m := range rejected {
+		if _, ok := memStorage.GetGauge(m.ID); ok {
+			t.Errorf("%s gauge %s was stored", reason, m.ID)
+		}
+	}
+}
@@ -0,0 +1,193 @@
+package natsclient
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"strings"
+
+	"github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/storage"
+)
+
+// Subscriber stores the metric batches published to a NATS subject by MetricsClient
+type Subscriber struct {
+	conn           *nats.Conn
+	sub            *nats.Subscription
+	subject        string
+	queue          string
+	storage        storage.Storage
+	maxMetricSize  int                   // Largest accepted metric in bytes (0 disables the check)
+	reservedPrefix string                // Metric name prefix only the server itself may write (empty disables the check)
+	namePolicy     *storage.NamePolicy   // Policy metric names must satisfy to be written (nil disables the check)
+	lenientFloats  bool                  // Coerce non-finite values to 0 instead of dropping the batch
+	metricTypes    *storage.TypeRegistry // First-seen metric types to enforce (nil disables the check)
+	rates          *storage.CounterRates // Tracker of recent counter deltas (nil disables tracking)
+}
+
+// NewSubscriber connects to the NATS server at url to store the batches published to
+// subject in s once Start is called. Subscribers with the same queue group share the
+// batches, so several servers can consume one subject; an empty queue group delivers
+// every batch to each.
+func NewSubscriber(url, subject, queue string, s storage.Storage, opts ...nats.Option) (*Subscriber, error) {
+	opts = append([]nats.Option{nats.Name("metrics-server"), nats.MaxReconnects(-1)}, opts...)
+	conn, err := nats.Connect(url, opts...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %w", err)
+	}
+
+	return &Subscriber{
+		conn:          conn,
+		subject:       subject,
+		queue:         queue,
+		storage:       s,
+		maxMetricSize: models.DefaultMaxMetricSize,
+	}, nil
+}
+
+// Start subscribes to the subject and begins storing batches. The Set methods must be
+// called before, so that no batch is stored without the configured checks.
+func (s *Subscriber) Start() error {
+	var err error
+	s.sub, err = s.conn.QueueSubscribe(s.subject, s.queue, s.handle)
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", s.subject, err)
+	}
+	// Make sure the subscription is registered before returning
+	if err := s.conn.Flush(); err != nil {
+		return fmt.Errorf("failed to subscribe to %s: %w", s.subject, err)
+	}
+
+	log.Info().Str("url", s.conn.ConnectedUrl()).Str("subject", s.subject).Msg("NATS subscriber started")
+	return nil
+}
+
+// SetMaxMetricSize drops batches with a metric larger than size bytes (0 or less disables the check)
+func (s *Subscriber) SetMaxMetricSize(size int) {
+	s.maxMetricSize = size
+}
+
+// SetReservedPrefix drops batches writing a metric whose name has the prefix reserved
+// for the server's own metrics (empty disables the check)
+func (s *Subscriber) SetReservedPrefix(prefix string) {
+	s.reservedPrefix = prefix
+}
+
+// SetNamePolicy drops batches with a metric name violating policy (nil disables the check)
+func (s *Subscriber) SetNamePolicy(policy *storage.NamePolicy) {
+	s.namePolicy = policy
+}
+
+// SetLenientFloats stores NaN and infinite gauge and float counter values as 0 instead
+// of dropping the batch
+func (s *Subscriber) SetLenientFloats(enabled bool) {
+	s.lenientFloats = enabled
+}
+
+// SetTypeRegistry rejects batches changing the type a metric was first seen with (nil disables)
+func (s *Subscriber) SetTypeRegistry(r *storage.TypeRegistry) {
+	s.metricTypes = r
+}
+
+// SetCounterRates records the deltas of stored counters in rates (nil disables tracking)
+func (s *Subscriber) SetCounterRates(rates *storage.CounterRates) {
+	s.rates = rates
+}
+
+// Close stops consuming after the batches already received are stored and closes the connection
+func (s *Subscriber) Close() error {
+	return s.conn.Drain()
+}
+
+// handle stores one published batch. A batch with a metric that the HTTP and gRPC
+// APIs would reject is dropped as a whole, since there is nobody to report the error
+// to but the log.
+func (s *Subscriber) handle(msg *nats.Msg) {
+	var metrics []models.Metrics
+	if err := json.Unmarshal(msg.Data, &metrics); err != nil {
+		log.Warn().Err(err).Str("subject", msg.Subject).Msg("Dropping NATS batch with invalid JSON")
+		return
+	}
+	for i := range metrics {
+		if err := s.check(&metrics[i]); err != nil {
+			log.Warn().Err(err).Str("subject", msg.Subject).Msg("Dropping NATS batch")
+			return
+		}
+	}
+	if limited, ok := s.storage.(storage.CapacityChecker); ok {
+		if err := limited.CheckCapacity(metrics...); err != nil {
+			log.Warn().Err(err).Str("subject", msg.Subject).Msg("Dropping NATS batch")
+			return
+		}
+	}
+	if err := s.metricTypes.Check(metrics...); err != nil {
+		log.Warn().Err(err).Str("subject", msg.Subject).Msg("Dropping NATS batch")
+		return
+	}
+
+	if err := s.store(metrics); err != nil {
+		log.Error().Err(err).Int("metrics", len(metrics)).Msg("Failed to store NATS batch")
+		return
+	}
+	s.metricTypes.Record(metrics...)
+	s.rates.AddBatch(metrics)
+}
+
+// errNoFloatCounters is returned for float counters sent to a storage not keeping them
+var errNoFloatCounters = errors.New("float counters are not supported by the storage")
+
+// store writes metrics to the storage, as a single batch if it supports that
+func (s *Subscriber) store(metrics []models.Metrics) error {
+	if batchStorage, ok := s.storage.(storage.BatchUpdater); ok {
+		return batchStorage.UpdateBatch(metrics)
+	}
+
+	floats, hasFloats := s.storage.(storage.FloatCounters)
+	for _, m := range metrics {
+		if m.MType == models.FloatCounterType && !hasFloats {
+			return errNoFloatCounters
+		}
+	}
+	for _, m := range metrics {
+		switch m.MType {
+		case models.GaugeType:
+			s.storage.UpdateGauge(m.ID, *m.Value)
+		case models.CounterType:
+			s.storage.UpdateCounter(m.ID, *m.Delta)
+		case models.FloatCounterType:
+			floats.UpdateFloatCounter(m.ID, *m.Value)
+		}
+	}
+	return nil
+}
+
+// check returns an error unless m is a valid metric within the size limit, outside the
+// reserved prefix, allowed by the name policy and finite. In lenient mode a non-finite
+// value is set to 0 instead.
+func (s *Subscriber) check(m *models.Metrics) error {
+	if err := m.Validate(); err != nil {
+		return err
+	}
+	if s.maxMetricSize > 0 && m.Size() > s.maxMetricSize {
+		return fmt.Errorf("metric %s exceeds size limit of %d bytes", m.ID, s.maxMetricSize)
+	}
+	if s.reservedPrefix != "" && strings.HasPrefix(m.ID, s.reservedPrefix) {
+		return fmt.Errorf("metric name %s is reserved", m.ID)
+	}
+	if err := s.namePolicy.Check(m.ID); err != nil {
+		return err
+	}
+	if m.MType == models.CounterType || !math.IsNaN(*m.Value) && !math.IsInf(*m.Value, 0) {
+		return nil
+	}
+	if !s.lenientFloats {
+		return fmt.Errorf("value of metric %s must be finite", m.ID)
+	}
+	log.Warn().Str("metric", m.ID).Float64("value", *m.Value).Msg("Coerced non-finite value to 0")
+	*m.Value = 0
+	return nil
+}
@@ -0,0 +1,48 @@
+package pool
+
+import (
+	"bytes"
+	"compress/gzip"
+)
+
+// maxPooledGzipBuffer is the largest compressed output whose buffer is kept for reuse,
+// so that one oversized payload does not pin its memory in the pool
+const maxPooledGzipBuffer = 1 << 20
+
+// gzipBuffer is a gzip writer together with the buffer it compresses into
+type gzipBuffer struct {
+	buf bytes.Buffer
+	gz  *gzip.Writer
+}
+
+// Reset empties the buffer and prepares the writer for a new stream into it
+func (g *gzipBuffer) Reset() {
+	g.buf.Reset()
+	g.gz.Reset(&g.buf)
+}
+
+var gzipBuffers = New(func() *gzipBuffer {
+	g := &gzipBuffer{}
+	g.gz = gzip.NewWriter(&g.buf)
+	return g
+})
+
+// Gzip compresses data at the default compression level, like a fresh gzip.Writer,
+// but reuses pooled writers and buffers. The returned slice is owned by the caller.
+func Gzip(data []byte) ([]byte, error) {
+	g := gzipBuffers.Get()
+	if _, err := g.gz.Write(data); err != nil {
+		gzipBuffers.Put(g)
+		return nil, err
+	}
+	if err := g.gz.Close(); err != nil {
+		gzipBuffers.Put(g)
+		return nil, err
+	}
+
+	compressed := bytes.Clone(g.buf.Bytes())
+	if g.buf.Cap() <= maxPooledGzipBuffer {
+		gzipBuffers.Put(g)
+	}
+	return compressed, nil
+}
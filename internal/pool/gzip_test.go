@@ -0,0 +1,112 @@
+package pool
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// gunzip decompresses data or fails the test
+func gunzip(t testing.TB, data []byte) []byte {
+	t.Helper()
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("Invalid gzip data: %v", err)
+	}
+	out, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatalf("Failed to decompress: %v", err)
+	}
+	return out
+}
+
+// gzipNew compresses data with a fresh writer, as the senders did before pooling
+func gzipNew(data []byte) ([]byte, error) {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	if _, err := gz.Write(data); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// batchPayload is the JSON of a typical agent batch
+var batchPayload = func() []byte {
+	var sb strings.Builder
+	sb.WriteString("[")
+	for i := 0; i < 40; i++ {
+		if i > 0 {
+			sb.WriteString(",")
+		}
+		fmt.Fprintf(&sb, `{"id":"Metric%d","type":"gauge","value":%d.25}`, i, i*1000)
+	}
+	sb.WriteString("]")
+	return []byte(sb.String())
+}()
+
+func TestGzipMatchesFreshWriter(t *testing.T) {
+	for i := 0; i < 3; i++ {
+		pooled, err := Gzip(batchPayload)
+		if err != nil {
+			t.Fatalf("Gzip failed: %v", err)
+		}
+		fresh, err := gzipNew(batchPayload)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(pooled, fresh) {
+			t.Errorf("Pass %d: expected the output of a fresh gzip writer", i)
+		}
+	}
+}
+
+func TestGzipConcurrent(t *testing.T) {
+	var wg sync.WaitGroup
+	results := make([][]byte, 50)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			compressed, err := Gzip([]byte(fmt.Sprintf("payload %d %s", i, batchPayload)))
+			if err != nil {
+				t.Errorf("Gzip failed: %v", err)
+				return
+			}
+			results[i] = compressed
+		}(i)
+	}
+	wg.Wait()
+
+	// Returned slices stay intact while the buffers are reused
+	for i, compressed := range results {
+		want := fmt.Sprintf("payload %d %s", i, batchPayload)
+		if got := string(gunzip(t, compressed)); got != want {
+			t.Errorf("Result %d corrupted: got %.30q", i, got)
+		}
+	}
+}
+
+func BenchmarkGzip_Pooled(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := Gzip(batchPayload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGzip_NoReuse(b *testing.B) {
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		if _, err := gzipNew(batchPayload); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
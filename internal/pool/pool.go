@@ -1,7 +1,10 @@
 // Package pool provides a generic object pool for types with Reset() method.
 package pool
 
-import "sync"
+import (
+	"sync"
+	"sync/atomic"
+)
 
 // Resetable is an interface that defines types that can be reset to their initial state.
 type Resetable interface {
@@ -14,6 +17,26 @@ type Resetable interface {
 type Pool[T Resetable] struct {
 	pool sync.Pool
 	new  func() T
+
+	// stats is nil unless the pool was created by NewWithStats
+	stats *poolStats
+
+	// free holds the retained objects of a pool created by NewBounded, in place of
+	// the sync.Pool
+	free chan T
+}
+
+// poolStats counts Get calls and the objects constructed to serve them
+type poolStats struct {
+	gets   atomic.Uint64
+	misses atomic.Uint64
+}
+
+// Stats reports how many Get calls reused a pooled object (Hits) and how many
+// constructed a new one (Misses).
+type Stats struct {
+	Hits   uint64
+	Misses uint64
 }
 
 // New creates and returns a pointer to a new Pool for type T.
@@ -30,19 +53,73 @@ func New[T Resetable](newFunc func() T) *Pool[T] {
 	}
 }
 
+// NewWithStats is like New but counts pool hits and misses, reported by Stats().
+// Pools created by New skip the counting entirely.
+func NewWithStats[T Resetable](newFunc func() T) *Pool[T] {
+	p := New(newFunc)
+	p.stats = &poolStats{}
+	// Wrap the factory so that every construction is counted as a miss
+	p.pool.New = func() interface{} {
+		p.stats.misses.Add(1)
+		return newFunc()
+	}
+	return p
+}
+
+// NewBounded creates a Pool that retains at most maxItems objects. Unlike a
+// sync.Pool, which can grow without bound between garbage collections, it keeps
+// the memory held by large objects predictable. Objects returned by Put while the
+// pool is full are discarded; a maxItems of 0 or less retains nothing.
+func NewBounded[T Resetable](maxItems int, newFunc func() T) *Pool[T] {
+	return &Pool[T]{
+		new:  newFunc,
+		free: make(chan T, max(maxItems, 0)),
+	}
+}
+
 // Get retrieves an object from the pool.
 // If the pool is empty, a new object is created using the factory function
 // provided to New().
 func (p *Pool[T]) Get() T {
+	if p.free != nil {
+		select {
+		case obj := <-p.free:
+			return obj
+		default:
+			return p.new()
+		}
+	}
+	if p.stats == nil {
+		return p.pool.Get().(T)
+	}
+	// Counted before the construction it may cause, so gets never lag behind misses
+	p.stats.gets.Add(1)
 	return p.pool.Get().(T)
 }
 
+// Stats returns the hit and miss counts of a pool created by NewWithStats, and
+// zero counts for any other pool.
+func (p *Pool[T]) Stats() Stats {
+	if p.stats == nil {
+		return Stats{}
+	}
+	misses := p.stats.misses.Load()
+	return Stats{Hits: p.stats.gets.Load() - misses, Misses: misses}
+}
+
 // Put returns an object to the pool.
 // Before adding the object to the pool, its Reset() method is called
 // to ensure the object is in a clean state for reuse.
 func (p *Pool[T]) Put(obj T) {
 	// Reset the object before returning it to the pool
 	obj.Reset()
+	if p.free != nil {
+		select {
+		case p.free <- obj:
+		default:
+		}
+		return
+	}
 	p.pool.Put(obj)
 }
 
@@ -30,6 +30,27 @@ func BenchmarkPool_GetPut(b *testing.B) {
 	}
 }
 
+func BenchmarkPool_GetPutWithStats(b *testing.B) {
+	p := NewWithStats(func() *TestStruct {
+		return &TestStruct{
+			Tags: make([]string, 0, 10),
+			Data: make(map[string]int),
+		}
+	})
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		obj := p.Get()
+		obj.Counter = i
+		obj.Name = "benchmark"
+		obj.Tags = append(obj.Tags, "tag1", "tag2", "tag3")
+		obj.Data["key"] = i
+		benchResult = obj.Counter
+		benchResultStr = obj.Name
+		p.Put(obj)
+	}
+}
+
 func BenchmarkPool_NoReuse(b *testing.B) {
 	// Benchmark without pool (creating new objects each time)
 	b.ResetTimer()
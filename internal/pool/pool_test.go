@@ -2,6 +2,7 @@ package pool
 
 import (
 	"sync"
+	"sync/atomic"
 	"testing"
 )
 
@@ -260,3 +261,118 @@ func TestPool_CapacityPreservation(t *testing.T) {
 	}
 }
 
+
+func TestPool_Stats(t *testing.T) {
+	p := NewWithStats(func() *TestStruct {
+		return &TestStruct{Data: make(map[string]int)}
+	})
+
+	// An empty pool constructs every object
+	objs := []*TestStruct{p.Get(), p.Get(), p.Get()}
+	if stats := p.Stats(); stats.Hits != 0 || stats.Misses != 3 {
+		t.Fatalf("Expected 0 hits and 3 misses, got %+v", stats)
+	}
+	for _, obj := range objs {
+		p.Put(obj)
+	}
+
+	// sync.Pool may drop objects at any time (and randomly does under the race
+	// detector), so only the totals are exact
+	const rounds = 100
+	for i := 0; i < rounds; i++ {
+		p.Put(p.Get())
+	}
+	stats := p.Stats()
+	if stats.Hits+stats.Misses != rounds+3 {
+		t.Errorf("Expected %d counted gets, got %+v", rounds+3, stats)
+	}
+	if stats.Hits == 0 {
+		t.Errorf("Expected returned objects to be reused, got %+v", stats)
+	}
+}
+
+func TestPool_StatsDisabled(t *testing.T) {
+	p := New(func() *TestStruct { return &TestStruct{} })
+	p.Put(p.Get())
+	p.Get()
+
+	if stats := p.Stats(); stats != (Stats{}) {
+		t.Errorf("Expected zero stats for a pool created by New, got %+v", stats)
+	}
+}
+
+func TestPool_Bounded(t *testing.T) {
+	created := 0
+	p := NewBounded(3, func() *TestStruct {
+		created++
+		return &TestStruct{Data: make(map[string]int)}
+	})
+
+	objs := make([]*TestStruct, 5)
+	for i := range objs {
+		objs[i] = p.Get()
+		objs[i].Counter = i + 1
+	}
+	for _, obj := range objs {
+		p.Put(obj)
+	}
+
+	if len(p.free) != 3 {
+		t.Fatalf("Expected the pool to retain 3 objects, got %d", len(p.free))
+	}
+	for i := 0; i < 3; i++ {
+		if obj := p.Get(); obj.Counter != 0 {
+			t.Errorf("Expected a reset object, got Counter %d", obj.Counter)
+		}
+	}
+	if created != 5 {
+		t.Errorf("Expected retained objects to be reused, got %d constructions", created)
+	}
+
+	// The pool is empty again, so the next Get constructs
+	p.Get()
+	if created != 6 {
+		t.Errorf("Expected a construction from the empty pool, got %d constructions", created)
+	}
+}
+
+func TestPool_BoundedConcurrent(t *testing.T) {
+	const maxItems = 4
+	var created atomic.Int64
+	p := NewBounded(maxItems, func() *TestStruct {
+		created.Add(1)
+		return &TestStruct{Data: make(map[string]int)}
+	})
+
+	const goroutines = 50
+	const iterations = 1000
+
+	var wg sync.WaitGroup
+	wg.Add(goroutines)
+	for i := 0; i < goroutines; i++ {
+		go func(id int) {
+			defer wg.Done()
+			for j := 0; j < iterations; j++ {
+				obj := p.Get()
+				// An object handed to two goroutines at once would not be reset here
+				if obj.Counter != 0 {
+					t.Errorf("Got an object still in use by goroutine %d", obj.Counter-1)
+				}
+				obj.Counter = id + 1
+				obj.Data["key"] = id
+				p.Put(obj)
+
+				if n := len(p.free); n > maxItems {
+					t.Errorf("Pool retains %d objects, more than %d", n, maxItems)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	// Every object was put back, so the pool is as full as the objects allow
+	want := min(int(created.Load()), maxItems)
+	if len(p.free) != want {
+		t.Errorf("Expected %d retained objects after %d constructions, got %d", want, created.Load(), len(p.free))
+	}
+}
@@ -0,0 +1,57 @@
+package proto
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+var (
+	// ErrMissingID is returned by ToModel for a metric without a name
+	ErrMissingID = errors.New("metric id is required")
+	// ErrUnknownType is returned by ToModel for a metric type outside the Metric_Type enum
+	ErrUnknownType = errors.New("unknown metric type")
+)
+
+// ToModel converts a protobuf metric into the internal representation. The scalar value
+// of the metric's type is copied into Value for gauges and float counters and Delta for
+// counters.
+func ToModel(m *Metric) (models.Metrics, error) {
+	if m.GetId() == "" {
+		return models.Metrics{}, ErrMissingID
+	}
+
+	switch m.Type {
+	case Metric_GAUGE:
+		value := m.Value
+		return models.Metrics{ID: m.Id, MType: models.GaugeType, Value: &value}, nil
+	case Metric_COUNTER:
+		delta := m.Delta
+		return models.Metrics{ID: m.Id, MType: models.CounterType, Delta: &delta}, nil
+	case Metric_FLOAT_COUNTER:
+		value := m.Value
+		return models.Metrics{ID: m.Id, MType: models.FloatCounterType, Value: &value}, nil
+	default:
+		return models.Metrics{}, fmt.Errorf("%w %d for %s", ErrUnknownType, m.Type, m.Id)
+	}
+}
+
+// FromModel converts an internal metric into its protobuf representation. It returns nil
+// for a metric failing models.Metrics.Validate.
+func FromModel(m models.Metrics) *Metric {
+	if m.Validate() != nil {
+		return nil
+	}
+
+	switch m.MType {
+	case models.CounterType:
+		return &Metric{Id: m.ID, Type: Metric_COUNTER, Delta: *m.Delta}
+	case models.GaugeType:
+		return &Metric{Id: m.ID, Type: Metric_GAUGE, Value: *m.Value}
+	case models.FloatCounterType:
+		return &Metric{Id: m.ID, Type: Metric_FLOAT_COUNTER, Value: *m.Value}
+	default:
+		return nil
+	}
+}
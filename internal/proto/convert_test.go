@@ -0,0 +1,105 @@
+package proto
+
+import (
+	"errors"
+	"testing"
+
+	"google.golang.org/protobuf/proto"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+func TestToModel(t *testing.T) {
+	tests := []struct {
+		name    string
+		metric  *Metric
+		wantErr error
+	}{
+		{name: "gauge", metric: &Metric{Id: "cpu", Type: Metric_GAUGE, Value: 1.5}},
+		{name: "counter", metric: &Metric{Id: "requests", Type: Metric_COUNTER, Delta: 3}},
+		{name: "float counter", metric: &Metric{Id: "bytes", Type: Metric_FLOAT_COUNTER, Value: 0.5}},
+		{name: "missing id", metric: &Metric{Type: Metric_GAUGE, Value: 1}, wantErr: ErrMissingID},
+		{name: "nil metric", metric: nil, wantErr: ErrMissingID},
+		{name: "unknown type", metric: &Metric{Id: "cpu", Type: Metric_MType(7)}, wantErr: ErrUnknownType},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m, err := ToModel(tt.metric)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+
+			if m.ID != tt.metric.Id {
+				t.Errorf("Expected ID %s, got %s", tt.metric.Id, m.ID)
+			}
+			switch tt.metric.Type {
+			case Metric_GAUGE:
+				if m.MType != "gauge" || m.Value == nil || *m.Value != tt.metric.Value || m.Delta != nil {
+					t.Errorf("Expected gauge %v, got %+v", tt.metric.Value, m)
+				}
+			case Metric_COUNTER:
+				if m.MType != "counter" || m.Delta == nil || *m.Delta != tt.metric.Delta || m.Value != nil {
+					t.Errorf("Expected counter %v, got %+v", tt.metric.Delta, m)
+				}
+			case Metric_FLOAT_COUNTER:
+				if m.MType != "floatcounter" || m.Value == nil || *m.Value != tt.metric.Value || m.Delta != nil {
+					t.Errorf("Expected float counter %v, got %+v", tt.metric.Value, m)
+				}
+			}
+
+			// Converting back yields the original message
+			if back := FromModel(m); !proto.Equal(back, tt.metric) {
+				t.Errorf("Expected round trip to give %v, got %v", tt.metric, back)
+			}
+		})
+	}
+}
+
+func TestToModelDoesNotAlias(t *testing.T) {
+	metric := &Metric{Id: "cpu", Type: Metric_GAUGE, Value: 1}
+	m, err := ToModel(metric)
+	if err != nil {
+		t.Fatal(err)
+	}
+	metric.Value = 2
+	if *m.Value != 1 {
+		t.Errorf("Expected converted value to stay 1, got %v", *m.Value)
+	}
+}
+
+func TestFromModel(t *testing.T) {
+	value, delta := 2.5, int64(4)
+
+	tests := []struct {
+		name   string
+		metric models.Metrics
+		want   *Metric
+	}{
+		{name: "gauge", metric: models.Metrics{ID: "cpu", MType: "gauge", Value: &value}, want: &Metric{Id: "cpu", Type: Metric_GAUGE, Value: 2.5}},
+		{name: "counter", metric: models.Metrics{ID: "requests", MType: "counter", Delta: &delta}, want: &Metric{Id: "requests", Type: Metric_COUNTER, Delta: 4}},
+		{name: "gauge without value", metric: models.Metrics{ID: "cpu", MType: "gauge", Delta: &delta}},
+		{name: "counter without delta", metric: models.Metrics{ID: "requests", MType: "counter", Value: &value}},
+		{name: "missing id", metric: models.Metrics{MType: "gauge", Value: &value}},
+		{name: "unknown type", metric: models.Metrics{ID: "cpu", MType: "histogram", Value: &value}},
+		{name: "float counter", metric: models.Metrics{ID: "bytes", MType: models.FloatCounterType, Value: &value}, want: &Metric{Id: "bytes", Type: Metric_FLOAT_COUNTER, Value: 2.5}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FromModel(tt.metric)
+			if tt.want == nil {
+				if got != nil {
+					t.Errorf("Expected nil, got %v", got)
+				}
+				return
+			}
+			if !proto.Equal(got, tt.want) {
+				t.Errorf("Expected %v, got %v", tt.want, got)
+			}
+		})
+	}
+}
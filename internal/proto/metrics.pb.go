@@ -25,8 +25,9 @@ const (
 type Metric_MType int32
 
 const (
-	Metric_GAUGE   Metric_MType = 0
-	Metric_COUNTER Metric_MType = 1
+	Metric_GAUGE         Metric_MType = 0
+	Metric_COUNTER       Metric_MType = 1
+	Metric_FLOAT_COUNTER Metric_MType = 2
 )
 
 // Enum value maps for Metric_MType.
@@ -34,10 +35,12 @@ var (
 	Metric_MType_name = map[int32]string{
 		0: "GAUGE",
 		1: "COUNTER",
+		2: "FLOAT_COUNTER",
 	}
 	Metric_MType_value = map[string]int32{
-		"GAUGE":   0,
-		"COUNTER": 1,
+		"GAUGE":         0,
+		"COUNTER":       1,
+		"FLOAT_COUNTER": 2,
 	}
 )
 
@@ -75,7 +78,7 @@ type Metric struct {
 	Type  Metric_MType           `protobuf:"varint,2,opt,name=type,proto3,enum=metrics.Metric_MType" json:"type,omitempty"` // metric type
 	// delta field for counter metrics
 	Delta int64 `protobuf:"varint,3,opt,name=delta,proto3" json:"delta,omitempty"`
-	// value field for gauge metrics
+	// value field for gauge and float counter metrics
 	Value         float64 `protobuf:"fixed64,4,opt,name=value,proto3" json:"value,omitempty"`
 	unknownFields protoimpl.UnknownFields
 	sizeCache     protoimpl.SizeCache
@@ -141,10 +144,13 @@ func (x *Metric) GetValue() float64 {
 
 // UpdateMetricsRequest contains a list of metrics to update
 type UpdateMetricsRequest struct {
-	state         protoimpl.MessageState `protogen:"open.v1"`
-	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
-	unknownFields protoimpl.UnknownFields
-	sizeCache     protoimpl.SizeCache
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Metrics []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	// encrypted_payload holds an RSA-encrypted serialized UpdateMetricsRequest
+	// It is used instead of metrics when the client has a public key configured
+	EncryptedPayload []byte `protobuf:"bytes,2,opt,name=encrypted_payload,json=encryptedPayload,proto3" json:"encrypted_payload,omitempty"`
+	unknownFields    protoimpl.UnknownFields
+	sizeCache        protoimpl.SizeCache
 }
 
 func (x *UpdateMetricsRequest) Reset() {
@@ -184,6 +190,13 @@ func (x *UpdateMetricsRequest) GetMetrics() []*Metric {
 	return nil
 }
 
+func (x *UpdateMetricsRequest) GetEncryptedPayload() []byte {
+	if x != nil {
+		return x.EncryptedPayload
+	}
+	return nil
+}
+
 // UpdateMetricsResponse is an empty response confirming successful update
 type UpdateMetricsResponse struct {
 	state         protoimpl.MessageState `protogen:"open.v1"`
@@ -221,24 +234,324 @@ func (*UpdateMetricsResponse) Descriptor() ([]byte, []int) {
 	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{2}
 }
 
+// StreamSummary is returned when a metrics stream is closed by the client
+type StreamSummary struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Processed     int64                  `protobuf:"varint,1,opt,name=processed,proto3" json:"processed,omitempty"` // number of metrics stored from the stream
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *StreamSummary) Reset() {
+	*x = StreamSummary{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[3]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *StreamSummary) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*StreamSummary) ProtoMessage() {}
+
+func (x *StreamSummary) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[3]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use StreamSummary.ProtoReflect.Descriptor instead.
+func (*StreamSummary) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{3}
+}
+
+func (x *StreamSummary) GetProcessed() int64 {
+	if x != nil {
+		return x.Processed
+	}
+	return 0
+}
+
+// GetMetricsRequest lists the metrics to read; only id and type are used
+type GetMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricsRequest) Reset() {
+	*x = GetMetricsRequest{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[4]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricsRequest) ProtoMessage() {}
+
+func (x *GetMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[4]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricsRequest.ProtoReflect.Descriptor instead.
+func (*GetMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{4}
+}
+
+func (x *GetMetricsRequest) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// GetAllMetricsRequest requests the full stored state
+type GetAllMetricsRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetAllMetricsRequest) Reset() {
+	*x = GetAllMetricsRequest{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[5]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetAllMetricsRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetAllMetricsRequest) ProtoMessage() {}
+
+func (x *GetAllMetricsRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[5]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetAllMetricsRequest.ProtoReflect.Descriptor instead.
+func (*GetAllMetricsRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{5}
+}
+
+// GetMetricsResponse contains the current values of the requested metrics
+// Counters carry their accumulated total in the delta field
+type GetMetricsResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Metrics       []*Metric              `protobuf:"bytes,1,rep,name=metrics,proto3" json:"metrics,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetMetricsResponse) Reset() {
+	*x = GetMetricsResponse{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[6]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetMetricsResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetMetricsResponse) ProtoMessage() {}
+
+func (x *GetMetricsResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[6]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetMetricsResponse.ProtoReflect.Descriptor instead.
+func (*GetMetricsResponse) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{6}
+}
+
+func (x *GetMetricsResponse) GetMetrics() []*Metric {
+	if x != nil {
+		return x.Metrics
+	}
+	return nil
+}
+
+// GetBuildInfoRequest requests the build information of the server
+type GetBuildInfoRequest struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *GetBuildInfoRequest) Reset() {
+	*x = GetBuildInfoRequest{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[7]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *GetBuildInfoRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*GetBuildInfoRequest) ProtoMessage() {}
+
+func (x *GetBuildInfoRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[7]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use GetBuildInfoRequest.ProtoReflect.Descriptor instead.
+func (*GetBuildInfoRequest) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{7}
+}
+
+// BuildInfo describes the build of the running server binary
+type BuildInfo struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	Version       string                 `protobuf:"bytes,1,opt,name=version,proto3" json:"version,omitempty"`
+	Date          string                 `protobuf:"bytes,2,opt,name=date,proto3" json:"date,omitempty"`
+	Commit        string                 `protobuf:"bytes,3,opt,name=commit,proto3" json:"commit,omitempty"`
+	GoVersion     string                 `protobuf:"bytes,4,opt,name=go_version,json=goVersion,proto3" json:"go_version,omitempty"` // Go version the binary was built with
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *BuildInfo) Reset() {
+	*x = BuildInfo{}
+	mi := &file_internal_proto_metrics_proto_msgTypes[8]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *BuildInfo) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*BuildInfo) ProtoMessage() {}
+
+func (x *BuildInfo) ProtoReflect() protoreflect.Message {
+	mi := &file_internal_proto_metrics_proto_msgTypes[8]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use BuildInfo.ProtoReflect.Descriptor instead.
+func (*BuildInfo) Descriptor() ([]byte, []int) {
+	return file_internal_proto_metrics_proto_rawDescGZIP(), []int{8}
+}
+
+func (x *BuildInfo) GetVersion() string {
+	if x != nil {
+		return x.Version
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetDate() string {
+	if x != nil {
+		return x.Date
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetCommit() string {
+	if x != nil {
+		return x.Commit
+	}
+	return ""
+}
+
+func (x *BuildInfo) GetGoVersion() string {
+	if x != nil {
+		return x.GoVersion
+	}
+	return ""
+}
+
 var File_internal_proto_metrics_proto protoreflect.FileDescriptor
 
 const file_internal_proto_metrics_proto_rawDesc = "" +
 	"\n" +
-	"\x1cinternal/proto/metrics.proto\x12\ametrics\"\x90\x01\n" +
+	"\x1cinternal/proto/metrics.proto\x12\ametrics\"\xa3\x01\n" +
 	"\x06Metric\x12\x0e\n" +
 	"\x02id\x18\x01 \x01(\tR\x02id\x12)\n" +
 	"\x04type\x18\x02 \x01(\x0e2\x15.metrics.Metric.MTypeR\x04type\x12\x14\n" +
 	"\x05delta\x18\x03 \x01(\x03R\x05delta\x12\x14\n" +
-	"\x05value\x18\x04 \x01(\x01R\x05value\"\x1f\n" +
+	"\x05value\x18\x04 \x01(\x01R\x05value\"2\n" +
 	"\x05MType\x12\t\n" +
 	"\x05GAUGE\x10\x00\x12\v\n" +
-	"\aCOUNTER\x10\x01\"A\n" +
+	"\aCOUNTER\x10\x01\x12\x11\n" +
+	"\rFLOAT_COUNTER\x10\x02\"n\n" +
 	"\x14UpdateMetricsRequest\x12)\n" +
-	"\ametrics\x18\x01 \x03(\v2\x0f.metrics.MetricR\ametrics\"\x17\n" +
-	"\x15UpdateMetricsResponse2Y\n" +
+	"\ametrics\x18\x01 \x03(\v2\x0f.metrics.MetricR\ametrics\x12+\n" +
+	"\x11encrypted_payload\x18\x02 \x01(\fR\x10encryptedPayload\"\x17\n" +
+	"\x15UpdateMetricsResponse\"-\n" +
+	"\rStreamSummary\x12\x1c\n" +
+	"\tprocessed\x18\x01 \x01(\x03R\tprocessed\">\n" +
+	"\x11GetMetricsRequest\x12)\n" +
+	"\ametrics\x18\x01 \x03(\v2\x0f.metrics.MetricR\ametrics\"\x16\n" +
+	"\x14GetAllMetricsRequest\"?\n" +
+	"\x12GetMetricsResponse\x12)\n" +
+	"\ametrics\x18\x01 \x03(\v2\x0f.metrics.MetricR\ametrics\"\x15\n" +
+	"\x13GetBuildInfoRequest\"p\n" +
+	"\tBuildInfo\x12\x18\n" +
+	"\aversion\x18\x01 \x01(\tR\aversion\x12\x12\n" +
+	"\x04date\x18\x02 \x01(\tR\x04date\x12\x16\n" +
+	"\x06commit\x18\x03 \x01(\tR\x06commit\x12\x1d\n" +
+	"\n" +
+	"go_version\x18\x04 \x01(\tR\tgoVersion2\xeb\x02\n" +
 	"\aMetrics\x12N\n" +
-	"\rUpdateMetrics\x12\x1d.metrics.UpdateMetricsRequest\x1a\x1e.metrics.UpdateMetricsResponseB4Z2github.com/mutualEvg/metrics-server/internal/protob\x06proto3"
+	"\rUpdateMetrics\x12\x1d.metrics.UpdateMetricsRequest\x1a\x1e.metrics.UpdateMetricsResponse\x12:\n" +
+	"\rStreamMetrics\x12\x0f.metrics.Metric\x1a\x16.metrics.StreamSummary(\x01\x12E\n" +
+	"\n" +
+	"GetMetrics\x12\x1a.metrics.GetMetricsRequest\x1a\x1b.metrics.GetMetricsResponse\x12K\n" +
+	"\rGetAllMetrics\x12\x1d.metrics.GetAllMetricsRequest\x1a\x1b.metrics.GetMetricsResponse\x12@\n" +
+	"\fGetBuildInfo\x12\x1c.metrics.GetBuildInfoRequest\x1a\x12.metrics.BuildInfoB4Z2github.com/mutualEvg/metrics-server/internal/protob\x06proto3"
 
 var (
 	file_internal_proto_metrics_proto_rawDescOnce sync.Once
@@ -253,23 +566,39 @@ func file_internal_proto_metrics_proto_rawDescGZIP() []byte {
 }
 
 var file_internal_proto_metrics_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
-var file_internal_proto_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 3)
+var file_internal_proto_metrics_proto_msgTypes = make([]protoimpl.MessageInfo, 9)
 var file_internal_proto_metrics_proto_goTypes = []any{
 	(Metric_MType)(0),             // 0: metrics.Metric.MType
 	(*Metric)(nil),                // 1: metrics.Metric
 	(*UpdateMetricsRequest)(nil),  // 2: metrics.UpdateMetricsRequest
 	(*UpdateMetricsResponse)(nil), // 3: metrics.UpdateMetricsResponse
+	(*StreamSummary)(nil),         // 4: metrics.StreamSummary
+	(*GetMetricsRequest)(nil),     // 5: metrics.GetMetricsRequest
+	(*GetAllMetricsRequest)(nil),  // 6: metrics.GetAllMetricsRequest
+	(*GetMetricsResponse)(nil),    // 7: metrics.GetMetricsResponse
+	(*GetBuildInfoRequest)(nil),   // 8: metrics.GetBuildInfoRequest
+	(*BuildInfo)(nil),             // 9: metrics.BuildInfo
 }
 var file_internal_proto_metrics_proto_depIdxs = []int32{
 	0, // 0: metrics.Metric.type:type_name -> metrics.Metric.MType
 	1, // 1: metrics.UpdateMetricsRequest.metrics:type_name -> metrics.Metric
-	2, // 2: metrics.Metrics.UpdateMetrics:input_type -> metrics.UpdateMetricsRequest
-	3, // 3: metrics.Metrics.UpdateMetrics:output_type -> metrics.UpdateMetricsResponse
-	3, // [3:4] is the sub-list for method output_type
-	2, // [2:3] is the sub-list for method input_type
-	2, // [2:2] is the sub-list for extension type_name
-	2, // [2:2] is the sub-list for extension extendee
-	0, // [0:2] is the sub-list for field type_name
+	1, // 2: metrics.GetMetricsRequest.metrics:type_name -> metrics.Metric
+	1, // 3: metrics.GetMetricsResponse.metrics:type_name -> metrics.Metric
+	2, // 4: metrics.Metrics.UpdateMetrics:input_type -> metrics.UpdateMetricsRequest
+	1, // 5: metrics.Metrics.StreamMetrics:input_type -> metrics.Metric
+	5, // 6: metrics.Metrics.GetMetrics:input_type -> metrics.GetMetricsRequest
+	6, // 7: metrics.Metrics.GetAllMetrics:input_type -> metrics.GetAllMetricsRequest
+	8, // 8: metrics.Metrics.GetBuildInfo:input_type -> metrics.GetBuildInfoRequest
+	3, // 9: metrics.Metrics.UpdateMetrics:output_type -> metrics.UpdateMetricsResponse
+	4, // 10: metrics.Metrics.StreamMetrics:output_type -> metrics.StreamSummary
+	7, // 11: metrics.Metrics.GetMetrics:output_type -> metrics.GetMetricsResponse
+	7, // 12: metrics.Metrics.GetAllMetrics:output_type -> metrics.GetMetricsResponse
+	9, // 13: metrics.Metrics.GetBuildInfo:output_type -> metrics.BuildInfo
+	9, // [9:14] is the sub-list for method output_type
+	4, // [4:9] is the sub-list for method input_type
+	4, // [4:4] is the sub-list for extension type_name
+	4, // [4:4] is the sub-list for extension extendee
+	0, // [0:4] is the sub-list for field type_name
 }
 
 func init() { file_internal_proto_metrics_proto_init() }
@@ -283,7 +612,7 @@ func file_internal_proto_metrics_proto_init() {
 			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
 			RawDescriptor: unsafe.Slice(unsafe.StringData(file_internal_proto_metrics_proto_rawDesc), len(file_internal_proto_metrics_proto_rawDesc)),
 			NumEnums:      1,
-			NumMessages:   3,
+			NumMessages:   9,
 			NumExtensions: 0,
 			NumServices:   1,
 		},
@@ -20,6 +20,10 @@ const _ = grpc.SupportPackageIsVersion9
 
 const (
 	Metrics_UpdateMetrics_FullMethodName = "/metrics.Metrics/UpdateMetrics"
+	Metrics_StreamMetrics_FullMethodName = "/metrics.Metrics/StreamMetrics"
+	Metrics_GetMetrics_FullMethodName    = "/metrics.Metrics/GetMetrics"
+	Metrics_GetAllMetrics_FullMethodName = "/metrics.Metrics/GetAllMetrics"
+	Metrics_GetBuildInfo_FullMethodName  = "/metrics.Metrics/GetBuildInfo"
 )
 
 // MetricsClient is the client API for Metrics service.
@@ -31,6 +35,16 @@ type MetricsClient interface {
 	// UpdateMetrics updates metrics on the server
 	// This method is suitable for sending both single metrics and batches
 	UpdateMetrics(ctx context.Context, in *UpdateMetricsRequest, opts ...grpc.CallOption) (*UpdateMetricsResponse, error)
+	// StreamMetrics receives a client-side stream of metrics and stores them in batches
+	// The number of processed metrics is returned once the client closes the stream
+	StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, StreamSummary], error)
+	// GetMetrics returns the current values of the requested metrics
+	// Metrics that are not found are omitted from the response
+	GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error)
+	// GetAllMetrics returns the full stored state, used by read replicas
+	GetAllMetrics(ctx context.Context, in *GetAllMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error)
+	// GetBuildInfo returns the version, date and commit the server was built from
+	GetBuildInfo(ctx context.Context, in *GetBuildInfoRequest, opts ...grpc.CallOption) (*BuildInfo, error)
 }
 
 type metricsClient struct {
@@ -51,6 +65,49 @@ func (c *metricsClient) UpdateMetrics(ctx context.Context, in *UpdateMetricsRequ
 	return out, nil
 }
 
+func (c *metricsClient) StreamMetrics(ctx context.Context, opts ...grpc.CallOption) (grpc.ClientStreamingClient[Metric, StreamSummary], error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	stream, err := c.cc.NewStream(ctx, &Metrics_ServiceDesc.Streams[0], Metrics_StreamMetrics_FullMethodName, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &grpc.GenericClientStream[Metric, StreamSummary]{ClientStream: stream}
+	return x, nil
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Metrics_StreamMetricsClient = grpc.ClientStreamingClient[Metric, StreamSummary]
+
+func (c *metricsClient) GetMetrics(ctx context.Context, in *GetMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetricsResponse)
+	err := c.cc.Invoke(ctx, Metrics_GetMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) GetAllMetrics(ctx context.Context, in *GetAllMetricsRequest, opts ...grpc.CallOption) (*GetMetricsResponse, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(GetMetricsResponse)
+	err := c.cc.Invoke(ctx, Metrics_GetAllMetrics_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *metricsClient) GetBuildInfo(ctx context.Context, in *GetBuildInfoRequest, opts ...grpc.CallOption) (*BuildInfo, error) {
+	cOpts := append([]grpc.CallOption{grpc.StaticMethod()}, opts...)
+	out := new(BuildInfo)
+	err := c.cc.Invoke(ctx, Metrics_GetBuildInfo_FullMethodName, in, out, cOpts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
 // MetricsServer is the server API for Metrics service.
 // All implementations must embed UnimplementedMetricsServer
 // for forward compatibility.
@@ -60,6 +117,16 @@ type MetricsServer interface {
 	// UpdateMetrics updates metrics on the server
 	// This method is suitable for sending both single metrics and batches
 	UpdateMetrics(context.Context, *UpdateMetricsRequest) (*UpdateMetricsResponse, error)
+	// StreamMetrics receives a client-side stream of metrics and stores them in batches
+	// The number of processed metrics is returned once the client closes the stream
+	StreamMetrics(grpc.ClientStreamingServer[Metric, StreamSummary]) error
+	// GetMetrics returns the current values of the requested metrics
+	// Metrics that are not found are omitted from the response
+	GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error)
+	// GetAllMetrics returns the full stored state, used by read replicas
+	GetAllMetrics(context.Context, *GetAllMetricsRequest) (*GetMetricsResponse, error)
+	// GetBuildInfo returns the version, date and commit the server was built from
+	GetBuildInfo(context.Context, *GetBuildInfoRequest) (*BuildInfo, error)
 	mustEmbedUnimplementedMetricsServer()
 }
 
@@ -73,6 +140,18 @@ type UnimplementedMetricsServer struct{}
 func (UnimplementedMetricsServer) UpdateMetrics(context.Context, *UpdateMetricsRequest) (*UpdateMetricsResponse, error) {
 	return nil, status.Errorf(codes.Unimplemented, "method UpdateMetrics not implemented")
 }
+func (UnimplementedMetricsServer) StreamMetrics(grpc.ClientStreamingServer[Metric, StreamSummary]) error {
+	return status.Errorf(codes.Unimplemented, "method StreamMetrics not implemented")
+}
+func (UnimplementedMetricsServer) GetMetrics(context.Context, *GetMetricsRequest) (*GetMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetMetrics not implemented")
+}
+func (UnimplementedMetricsServer) GetAllMetrics(context.Context, *GetAllMetricsRequest) (*GetMetricsResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetAllMetrics not implemented")
+}
+func (UnimplementedMetricsServer) GetBuildInfo(context.Context, *GetBuildInfoRequest) (*BuildInfo, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetBuildInfo not implemented")
+}
 func (UnimplementedMetricsServer) mustEmbedUnimplementedMetricsServer() {}
 func (UnimplementedMetricsServer) testEmbeddedByValue()                 {}
 
@@ -112,6 +191,67 @@ func _Metrics_UpdateMetrics_Handler(srv interface{}, ctx context.Context, dec fu
 	return interceptor(ctx, in, info, handler)
 }
 
+func _Metrics_StreamMetrics_Handler(srv interface{}, stream grpc.ServerStream) error {
+	return srv.(MetricsServer).StreamMetrics(&grpc.GenericServerStream[Metric, StreamSummary]{ServerStream: stream})
+}
+
+// This type alias is provided for backwards compatibility with existing code that references the prior non-generic stream type by name.
+type Metrics_StreamMetricsServer = grpc.ClientStreamingServer[Metric, StreamSummary]
+
+func _Metrics_GetMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetMetrics(ctx, req.(*GetMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_GetAllMetrics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetAllMetricsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetAllMetrics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetAllMetrics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetAllMetrics(ctx, req.(*GetAllMetricsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Metrics_GetBuildInfo_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetBuildInfoRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(MetricsServer).GetBuildInfo(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Metrics_GetBuildInfo_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(MetricsServer).GetBuildInfo(ctx, req.(*GetBuildInfoRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
 // Metrics_ServiceDesc is the grpc.ServiceDesc for Metrics service.
 // It's only intended for direct use with grpc.RegisterService,
 // and not to be introspected or modified (even as a copy)
@@ -123,7 +263,25 @@ var Metrics_ServiceDesc = grpc.ServiceDesc{
 			MethodName: "UpdateMetrics",
 			Handler:    _Metrics_UpdateMetrics_Handler,
 		},
+		{
+			MethodName: "GetMetrics",
+			Handler:    _Metrics_GetMetrics_Handler,
+		},
+		{
+			MethodName: "GetAllMetrics",
+			Handler:    _Metrics_GetAllMetrics_Handler,
+		},
+		{
+			MethodName: "GetBuildInfo",
+			Handler:    _Metrics_GetBuildInfo_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamMetrics",
+			Handler:       _Metrics_StreamMetrics_Handler,
+			ClientStreams: true,
+		},
 	},
-	Streams:  []grpc.StreamDesc{},
 	Metadata: "internal/proto/metrics.proto",
 }
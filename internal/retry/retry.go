@@ -6,6 +6,7 @@ import (
 	"errors"
 	"net"
 	"net/url"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -18,6 +19,19 @@ import (
 type RetryConfig struct {
 	MaxAttempts int
 	Intervals   []time.Duration
+
+	// OnAttempt, if set, is called after every attempt with its 1-based number and
+	// the error it returned, nil on success. It lets callers count attempts and retries.
+	OnAttempt func(attempt int, err error) `json:"-"`
+
+	// IsRetriable, if set, is consulted in addition to the built-in IsRetriable: an
+	// error is retried if either reports it as retriable. It lets callers retry the
+	// transient errors of their own backends.
+	IsRetriable func(err error) bool `json:"-"`
+
+	// OnRetry, if set, is called before each wait for a retry with the 1-based number
+	// of the attempt that failed, its error and the delay before the next attempt.
+	OnRetry func(attempt int, err error, nextDelay time.Duration) `json:"-"`
 }
 
 // DefaultConfig returns the default retry configuration
@@ -59,6 +73,10 @@ func Do(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 				intervalIndex = len(config.Intervals) - 1
 			}
 
+			if config.OnRetry != nil {
+				config.OnRetry(attempt, lastErr, config.Intervals[intervalIndex])
+			}
+
 			select {
 			case <-ctx.Done():
 				return ctx.Err()
@@ -73,6 +91,9 @@ func Do(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 		}
 
 		err := fn()
+		if config.OnAttempt != nil {
+			config.OnAttempt(attempt+1, err)
+		}
 		if err == nil {
 			if attempt > 0 {
 				log.Info().
@@ -85,7 +106,7 @@ func Do(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 		lastErr = err
 
 		// Check if error is retriable
-		if !IsRetriable(err) {
+		if !config.retriable(err) {
 			log.Debug().
 				Err(err).
 				Int("attempt", attempt+1).
@@ -108,6 +129,54 @@ func Do(ctx context.Context, config RetryConfig, fn RetryableFunc) error {
 	return lastErr
 }
 
+// Stats counts the attempts made by Do. Its Record method can be used as
+// RetryConfig.OnAttempt; it is safe for concurrent use.
+type Stats struct {
+	attempts atomic.Int64
+	retries  atomic.Int64
+	failures atomic.Int64
+}
+
+// StatsSnapshot is a point-in-time copy of Stats
+type StatsSnapshot struct {
+	// Attempts is the total number of calls of the retried functions
+	Attempts int64 `json:"attempts"`
+
+	// Retries is the number of attempts after the first one of an operation
+	Retries int64 `json:"retries"`
+
+	// Failures is the number of attempts that returned an error
+	Failures int64 `json:"failures"`
+}
+
+// Record counts one attempt, matching the signature of RetryConfig.OnAttempt
+func (s *Stats) Record(attempt int, err error) {
+	s.attempts.Add(1)
+	if attempt > 1 {
+		s.retries.Add(1)
+	}
+	if err != nil {
+		s.failures.Add(1)
+	}
+}
+
+// Snapshot returns the current counts
+func (s *Stats) Snapshot() StatsSnapshot {
+	return StatsSnapshot{
+		Attempts: s.attempts.Load(),
+		Retries:  s.retries.Load(),
+		Failures: s.failures.Load(),
+	}
+}
+
+// retriable reports whether err is retriable by the built-in rules or the config's predicate
+func (c RetryConfig) retriable(err error) bool {
+	if IsRetriable(err) {
+		return true
+	}
+	return err != nil && c.IsRetriable != nil && c.IsRetriable(err)
+}
+
 // IsRetriable determines if an error can be retried
 func IsRetriable(err error) bool {
 	if err == nil {
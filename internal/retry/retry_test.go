@@ -3,6 +3,7 @@ package retry
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net"
 	"syscall"
 	"testing"
@@ -82,6 +83,161 @@ func TestRetryLogic(t *testing.T) {
 	})
 }
 
+func TestOnAttemptCallback(t *testing.T) {
+	var calls []int
+	var failed int
+	config := RetryConfig{
+		MaxAttempts: 4,
+		Intervals:   []time.Duration{time.Millisecond},
+		OnAttempt: func(attempt int, err error) {
+			calls = append(calls, attempt)
+			if err != nil {
+				failed++
+			}
+		},
+	}
+
+	attempts := 0
+	err := Do(context.Background(), config, func() error {
+		attempts++
+		if attempts <= 2 {
+			return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("Expected no error, got %v", err)
+	}
+	if len(calls) != 3 {
+		t.Fatalf("Expected callback to fire 3 times, got %d", len(calls))
+	}
+	for i, attempt := range calls {
+		if attempt != i+1 {
+			t.Errorf("Expected attempt %d, got %d", i+1, attempt)
+		}
+	}
+	if failed != 2 {
+		t.Errorf("Expected 2 failed attempts, got %d", failed)
+	}
+
+	// A nil callback is a no-op
+	config.OnAttempt = nil
+	if err := Do(context.Background(), config, func() error { return nil }); err != nil {
+		t.Errorf("Expected no error without callback, got %v", err)
+	}
+}
+
+func TestOnRetryCallback(t *testing.T) {
+	type retryCall struct {
+		attempt int
+		err     error
+		delay   time.Duration
+	}
+	var calls []retryCall
+	errConn := &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+	config := RetryConfig{
+		MaxAttempts: 4,
+		Intervals:   []time.Duration{time.Millisecond, 2 * time.Millisecond},
+		OnRetry: func(attempt int, err error, nextDelay time.Duration) {
+			calls = append(calls, retryCall{attempt, err, nextDelay})
+		},
+	}
+
+	// Every attempt fails: three retries, the last one reusing the last interval
+	err := Do(context.Background(), config, func() error { return errConn })
+	if err == nil {
+		t.Fatal("Expected the last error")
+	}
+	want := []retryCall{
+		{1, errConn, time.Millisecond},
+		{2, errConn, 2 * time.Millisecond},
+		{3, errConn, 2 * time.Millisecond},
+	}
+	if len(calls) != len(want) {
+		t.Fatalf("Expected %d retries, got %d", len(want), len(calls))
+	}
+	for i, c := range calls {
+		if c != want[i] {
+			t.Errorf("Retry %d: expected %+v, got %+v", i, want[i], c)
+		}
+	}
+
+	// No hook call for an immediate success or a non-retriable error
+	calls = nil
+	Do(context.Background(), config, func() error { return nil })
+	Do(context.Background(), config, func() error { return errors.New("permanent") })
+	if len(calls) != 0 {
+		t.Errorf("Expected no retries, got %d", len(calls))
+	}
+
+	// A nil hook is a no-op
+	config.OnRetry = nil
+	if err := Do(context.Background(), config, func() error { return nil }); err != nil {
+		t.Errorf("Expected no error without hook, got %v", err)
+	}
+}
+
+func TestCustomRetriablePredicate(t *testing.T) {
+	errTransient := errors.New("backend busy")
+	errPermanent := errors.New("backend corrupt")
+
+	config := RetryConfig{
+		MaxAttempts: 3,
+		Intervals:   []time.Duration{time.Millisecond},
+	}
+	run := func(failWith error) int {
+		attempts := 0
+		Do(context.Background(), config, func() error {
+			attempts++
+			return failWith
+		})
+		return attempts
+	}
+
+	// Without a predicate the sentinel is not retried, as before
+	if attempts := run(errTransient); attempts != 1 {
+		t.Errorf("Expected 1 attempt without predicate, got %d", attempts)
+	}
+
+	config.IsRetriable = func(err error) bool { return errors.Is(err, errTransient) }
+	if attempts := run(fmt.Errorf("write metric: %w", errTransient)); attempts != 3 {
+		t.Errorf("Expected the wrapped sentinel to be retried 3 times, got %d", attempts)
+	}
+	if attempts := run(errPermanent); attempts != 1 {
+		t.Errorf("Expected other errors not to be retried, got %d attempts", attempts)
+	}
+
+	// Built-in retriable errors are still retried
+	if attempts := run(&net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}); attempts != 3 {
+		t.Errorf("Expected built-in retriable errors to be retried 3 times, got %d", attempts)
+	}
+}
+
+func TestStats(t *testing.T) {
+	stats := &Stats{}
+	config := RetryConfig{
+		MaxAttempts: 3,
+		Intervals:   []time.Duration{time.Millisecond},
+		OnAttempt:   stats.Record,
+	}
+
+	attempts := 0
+	Do(context.Background(), config, func() error {
+		attempts++
+		if attempts <= 2 {
+			return &net.OpError{Op: "dial", Net: "tcp", Err: syscall.ECONNREFUSED}
+		}
+		return nil
+	})
+	Do(context.Background(), config, func() error { return nil })
+
+	want := StatsSnapshot{Attempts: 4, Retries: 2, Failures: 2}
+	if got := stats.Snapshot(); got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+}
+
 func TestIsRetriable(t *testing.T) {
 	tests := []struct {
 		name     string
@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// DefaultRequestLogSize is the number of requests kept by default
+const DefaultRequestLogSize = 100
+
+// RequestRecord describes a single handled request
+type RequestRecord struct {
+	Time     time.Time `json:"time"`
+	Method   string    `json:"method"`
+	Path     string    `json:"path"`
+	Status   int       `json:"status"`
+	ClientIP string    `json:"client_ip"`
+}
+
+// RequestLog is a bounded ring buffer of the most recently handled requests.
+// Once full, each new record overwrites the oldest one. It is safe for concurrent use.
+type RequestLog struct {
+	mu      sync.Mutex
+	records []RequestRecord
+	next    int  // index the next record is written to
+	full    bool // whether the buffer has wrapped around
+}
+
+// NewRequestLog creates a request log holding up to size records.
+// A size of 0 or less falls back to DefaultRequestLogSize.
+func NewRequestLog(size int) *RequestLog {
+	if size <= 0 {
+		size = DefaultRequestLogSize
+	}
+	return &RequestLog{
+		records: make([]RequestRecord, size),
+	}
+}
+
+// Add stores a record, evicting the oldest one if the log is full
+func (l *RequestLog) Add(record RequestRecord) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.records[l.next] = record
+	l.next++
+	if l.next == len(l.records) {
+		l.next = 0
+		l.full = true
+	}
+}
+
+// Recent returns a copy of the stored records, oldest first
+func (l *RequestLog) Recent() []RequestRecord {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.full {
+		return append([]RequestRecord(nil), l.records[:l.next]...)
+	}
+
+	recent := make([]RequestRecord, 0, len(l.records))
+	recent = append(recent, l.records[l.next:]...)
+	return append(recent, l.records[:l.next]...)
+}
+
+// Handler serves the recent requests as JSON
+func (l *RequestLog) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(l.Recent())
+	}
+}
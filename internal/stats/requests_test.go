@@ -0,0 +1,75 @@
+package stats
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestRequestLogBounded(t *testing.T) {
+	l := NewRequestLog(3)
+
+	if got := l.Recent(); len(got) != 0 {
+		t.Fatalf("Expected empty log, got %d records", len(got))
+	}
+
+	for i := 1; i <= 5; i++ {
+		l.Add(RequestRecord{
+			Time:     time.Now(),
+			Method:   "POST",
+			Path:     fmt.Sprintf("/update/counter/c/%d", i),
+			Status:   http.StatusOK,
+			ClientIP: "10.0.0.1",
+		})
+	}
+
+	recent := l.Recent()
+	if len(recent) != 3 {
+		t.Fatalf("Expected 3 records, got %d", len(recent))
+	}
+	for i, want := range []string{"/update/counter/c/3", "/update/counter/c/4", "/update/counter/c/5"} {
+		if recent[i].Path != want {
+			t.Errorf("Record %d: expected path %s, got %s", i, want, recent[i].Path)
+		}
+	}
+}
+
+func TestRequestLogPartial(t *testing.T) {
+	l := NewRequestLog(0)
+	l.Add(RequestRecord{Method: "GET", Path: "/", Status: http.StatusOK})
+	l.Add(RequestRecord{Method: "GET", Path: "/value/gauge/missing", Status: http.StatusNotFound})
+
+	recent := l.Recent()
+	if len(recent) != 2 {
+		t.Fatalf("Expected 2 records, got %d", len(recent))
+	}
+	if recent[0].Path != "/" || recent[1].Status != http.StatusNotFound {
+		t.Errorf("Unexpected records: %+v", recent)
+	}
+	if len(l.records) != DefaultRequestLogSize {
+		t.Errorf("Expected default capacity %d, got %d", DefaultRequestLogSize, len(l.records))
+	}
+}
+
+func TestRequestLogHandler(t *testing.T) {
+	l := NewRequestLog(10)
+	l.Add(RequestRecord{Method: "POST", Path: "/updates/", Status: http.StatusBadRequest, ClientIP: "192.168.1.5"})
+
+	rec := httptest.NewRecorder()
+	l.Handler()(rec, httptest.NewRequest("GET", "/debug/requests", nil))
+
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	var records []RequestRecord
+	if err := json.Unmarshal(rec.Body.Bytes(), &records); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(records) != 1 || records[0].ClientIP != "192.168.1.5" || records[0].Status != http.StatusBadRequest {
+		t.Errorf("Unexpected records: %+v", records)
+	}
+}
@@ -0,0 +1,132 @@
+// Package stats collects in-memory request statistics about the server itself.
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// LatencyBuckets are the upper bounds of the latency histogram buckets.
+// Requests slower than the last bound are counted in an overflow bucket.
+var LatencyBuckets = []time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+}
+
+// routeStats holds the counters of a single route. All fields are updated atomically.
+type routeStats struct {
+	requests   atomic.Int64
+	errors     atomic.Int64
+	totalNanos atomic.Int64
+	buckets    []atomic.Int64 // len(LatencyBuckets)+1, the last one for overflow
+}
+
+// Collector aggregates request counts, error counts and latencies per route template.
+// It is safe for concurrent use; recording a request for a known route does not allocate.
+type Collector struct {
+	mu     sync.RWMutex
+	routes map[string]*routeStats
+}
+
+// RouteSnapshot is a point-in-time copy of the statistics of one route.
+type RouteSnapshot struct {
+	// Requests is the total number of handled requests
+	Requests int64 `json:"requests"`
+
+	// Errors is the number of requests answered with a 4xx or 5xx status
+	Errors int64 `json:"errors"`
+
+	// AvgLatencyMs is the mean request latency in milliseconds
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+
+	// Histogram maps bucket upper bounds (e.g. "5ms", "+Inf") to request counts
+	Histogram map[string]int64 `json:"histogram"`
+}
+
+// New creates an empty stats collector
+func New() *Collector {
+	return &Collector{
+		routes: make(map[string]*routeStats),
+	}
+}
+
+// Record adds a handled request to the statistics of the given route
+func (c *Collector) Record(route string, status int, duration time.Duration) {
+	rs := c.route(route)
+
+	rs.requests.Add(1)
+	if status >= http.StatusBadRequest {
+		rs.errors.Add(1)
+	}
+	rs.totalNanos.Add(int64(duration))
+
+	bucket := len(LatencyBuckets)
+	for i, bound := range LatencyBuckets {
+		if duration <= bound {
+			bucket = i
+			break
+		}
+	}
+	rs.buckets[bucket].Add(1)
+}
+
+// route returns the stats of a route, creating them on first use
+func (c *Collector) route(route string) *routeStats {
+	c.mu.RLock()
+	rs, ok := c.routes[route]
+	c.mu.RUnlock()
+	if ok {
+		return rs
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if rs, ok = c.routes[route]; !ok {
+		rs = &routeStats{buckets: make([]atomic.Int64, len(LatencyBuckets)+1)}
+		c.routes[route] = rs
+	}
+	return rs
+}
+
+// Snapshot returns a copy of the statistics of all routes
+func (c *Collector) Snapshot() map[string]RouteSnapshot {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	snapshot := make(map[string]RouteSnapshot, len(c.routes))
+	for route, rs := range c.routes {
+		s := RouteSnapshot{
+			Requests:  rs.requests.Load(),
+			Errors:    rs.errors.Load(),
+			Histogram: make(map[string]int64, len(rs.buckets)),
+		}
+		if s.Requests > 0 {
+			s.AvgLatencyMs = float64(rs.totalNanos.Load()) / float64(s.Requests) / float64(time.Millisecond)
+		}
+		for i := range rs.buckets {
+			label := "+Inf"
+			if i < len(LatencyBuckets) {
+				label = LatencyBuckets[i].String()
+			}
+			s.Histogram[label] = rs.buckets[i].Load()
+		}
+		snapshot[route] = s
+	}
+	return snapshot
+}
+
+// Handler serves the current statistics as JSON
+func (c *Collector) Handler() http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(c.Snapshot())
+	}
+}
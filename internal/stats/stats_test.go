@@ -0,0 +1,91 @@
+package stats
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestCollectorRecord(t *testing.T) {
+	c := New()
+
+	c.Record("/update/", http.StatusOK, 2*time.Millisecond)
+	c.Record("/update/", http.StatusBadRequest, 20*time.Millisecond)
+	c.Record("/update/", http.StatusOK, 2*time.Second)
+	c.Record("/", http.StatusOK, time.Microsecond)
+
+	snapshot := c.Snapshot()
+
+	update, ok := snapshot["/update/"]
+	if !ok {
+		t.Fatal("Expected stats for /update/")
+	}
+	if update.Requests != 3 {
+		t.Errorf("Expected 3 requests, got %d", update.Requests)
+	}
+	if update.Errors != 1 {
+		t.Errorf("Expected 1 error, got %d", update.Errors)
+	}
+	if update.Histogram["5ms"] != 1 || update.Histogram["50ms"] != 1 || update.Histogram["+Inf"] != 1 {
+		t.Errorf("Unexpected histogram: %v", update.Histogram)
+	}
+
+	if root := snapshot["/"]; root.Requests != 1 || root.Histogram["1ms"] != 1 {
+		t.Errorf("Unexpected stats for /: %+v", root)
+	}
+}
+
+func TestCollectorConcurrent(t *testing.T) {
+	c := New()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 100; j++ {
+				c.Record("/updates/", http.StatusOK, time.Millisecond)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if got := c.Snapshot()["/updates/"].Requests; got != 1000 {
+		t.Errorf("Expected 1000 requests, got %d", got)
+	}
+}
+
+func TestCollectorRecordNoAlloc(t *testing.T) {
+	c := New()
+	c.Record("/update/", http.StatusOK, time.Millisecond)
+
+	allocs := testing.AllocsPerRun(100, func() {
+		c.Record("/update/", http.StatusOK, time.Millisecond)
+	})
+	if allocs != 0 {
+		t.Errorf("Expected no allocations for a known route, got %v", allocs)
+	}
+}
+
+func TestCollectorHandler(t *testing.T) {
+	c := New()
+	c.Record("/value/{type}/{name}", http.StatusNotFound, time.Millisecond)
+
+	rec := httptest.NewRecorder()
+	c.Handler()(rec, httptest.NewRequest("GET", "/debug/stats", nil))
+
+	if rec.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("Expected JSON content type, got %s", rec.Header().Get("Content-Type"))
+	}
+
+	var snapshot map[string]RouteSnapshot
+	if err := json.Unmarshal(rec.Body.Bytes(), &snapshot); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if s := snapshot["/value/{type}/{name}"]; s.Requests != 1 || s.Errors != 1 {
+		t.Errorf("Unexpected stats: %+v", s)
+	}
+}
@@ -116,3 +116,24 @@ func BenchmarkMixedOperations(b *testing.B) {
 		}
 	})
 }
+
+// BenchmarkUpdateCounterContention compares the plain and sharded counter
+// implementations with many goroutines incrementing the same counter
+func BenchmarkUpdateCounterContention(b *testing.B) {
+	benchmarks := map[string]*storage.MemStorage{
+		"Unsharded": storage.NewMemStorage(),
+		"Sharded":   storage.NewMemStorage(storage.WithShardedCounters(0)),
+	}
+
+	for name, s := range benchmarks {
+		b.Run(name, func(b *testing.B) {
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					s.UpdateCounter("hot_counter", 1)
+				}
+			})
+		})
+	}
+}
@@ -2,16 +2,27 @@ package utils
 
 import "net"
 
-// GetOutboundIP gets the preferred outbound IP address of this machine
+// outboundTargets are public DNS servers used to discover the outbound interface,
+// IPv4 first so dual-stack hosts keep reporting their IPv4 address
+var outboundTargets = []string{"8.8.8.8:80", "[2001:4860:4860::8888]:80"}
+
+// GetOutboundIP gets the preferred outbound IP address of this machine.
+// On IPv6-only hosts the IPv6 address of the outbound interface is returned.
 func GetOutboundIP() string {
-	// Try to get the outbound IP by connecting to a public DNS server
-	// This doesn't actually send any data, just establishes which interface would be used
-	conn, err := net.Dial("udp", "8.8.8.8:80")
-	if err != nil {
-		return "127.0.0.1" // Fallback to localhost
-	}
-	defer conn.Close()
+	return outboundIP(outboundTargets...)
+}
 
-	localAddr := conn.LocalAddr().(*net.UDPAddr)
-	return localAddr.IP.String()
+// outboundIP returns the local address of the first target that can be dialed
+func outboundIP(targets ...string) string {
+	for _, target := range targets {
+		// Dialing UDP doesn't actually send any data, just establishes which interface would be used
+		conn, err := net.Dial("udp", target)
+		if err != nil {
+			continue
+		}
+		localAddr := conn.LocalAddr().(*net.UDPAddr)
+		conn.Close()
+		return localAddr.IP.String()
+	}
+	return "127.0.0.1" // Fallback to localhost
 }
@@ -24,3 +24,33 @@ func TestGetOutboundIP(t *testing.T) {
 		t.Errorf("GetOutboundIP returned unexpected IP format: %s", ip)
 	}
 }
+
+func TestOutboundIP(t *testing.T) {
+	tests := []struct {
+		name    string
+		targets []string
+		want    string
+		needV6  bool
+	}{
+		{name: "IPv4 loopback", targets: []string{"127.0.0.1:80"}, want: "127.0.0.1"},
+		{name: "IPv6 loopback", targets: []string{"[::1]:80"}, want: "::1", needV6: true},
+		{name: "Falls through to IPv6", targets: []string{"invalid:address:80", "[::1]:80"}, want: "::1", needV6: true},
+		{name: "No reachable target", targets: []string{"invalid:address:80"}, want: "127.0.0.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.needV6 {
+				conn, err := net.Dial("udp", "[::1]:80")
+				if err != nil {
+					t.Skip("IPv6 loopback not available")
+				}
+				conn.Close()
+			}
+
+			if got := outboundIP(tt.targets...); got != tt.want {
+				t.Errorf("Expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+}
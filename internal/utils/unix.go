@@ -0,0 +1,65 @@
+package utils
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UnixScheme prefixes server addresses that name a Unix domain socket,
+// as in unix:///run/metrics/server.sock
+const UnixScheme = "unix://"
+
+// unixBaseURL is the URL prefix of requests sent over a socket. The host only
+// fills the Host header, the connection always goes to the socket.
+const unixBaseURL = "http://unix"
+
+// unixTransports caches one transport per socket path, so that clients created per
+// request still share their idle connections
+var unixTransports sync.Map
+
+// UnixSocketPath returns the socket path of a unix:// address and whether addr is one
+func UnixSocketPath(addr string) (string, bool) {
+	if !strings.HasPrefix(addr, UnixScheme) {
+		return "", false
+	}
+	return strings.TrimPrefix(addr, UnixScheme), true
+}
+
+// HTTPBaseURL returns the URL prefix of requests to the server at serverAddr:
+// the address itself, or a placeholder http URL for a Unix socket.
+func HTTPBaseURL(serverAddr string) string {
+	if _, ok := UnixSocketPath(serverAddr); ok {
+		return unixBaseURL
+	}
+	return serverAddr
+}
+
+// NewHTTPClient returns an HTTP client with the given timeout for the server at
+// serverAddr. For a unix:// address the client dials the socket whatever the
+// request URL; otherwise it uses the default transport.
+func NewHTTPClient(serverAddr string, timeout time.Duration) *http.Client {
+	client := &http.Client{Timeout: timeout}
+	if path, ok := UnixSocketPath(serverAddr); ok {
+		client.Transport = unixTransport(path)
+	}
+	return client
+}
+
+// unixTransport returns the shared transport dialing the socket at path
+func unixTransport(path string) *http.Transport {
+	if transport, ok := unixTransports.Load(path); ok {
+		return transport.(*http.Transport)
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = func(ctx context.Context, _, _ string) (net.Conn, error) {
+		var d net.Dialer
+		return d.DialContext(ctx, "unix", path)
+	}
+	actual, _ := unixTransports.LoadOrStore(path, transport)
+	return actual.(*http.Transport)
+}
@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestUnixSocketPath(t *testing.T) {
+	tests := []struct {
+		addr     string
+		wantPath string
+		wantOK   bool
+	}{
+		{"unix:///run/metrics/server.sock", "/run/metrics/server.sock", true},
+		{"unix://server.sock", "server.sock", true},
+		{"http://localhost:8080", "", false},
+		{"localhost:8080", "", false},
+	}
+
+	for _, tt := range tests {
+		path, ok := UnixSocketPath(tt.addr)
+		if path != tt.wantPath || ok != tt.wantOK {
+			t.Errorf("UnixSocketPath(%q) = %q, %v; want %q, %v", tt.addr, path, ok, tt.wantPath, tt.wantOK)
+		}
+	}
+
+	if base := HTTPBaseURL("http://localhost:8080"); base != "http://localhost:8080" {
+		t.Errorf("Expected a TCP address to be its own base URL, got %q", base)
+	}
+	if base := HTTPBaseURL("unix:///tmp/server.sock"); base != unixBaseURL {
+		t.Errorf("Expected %q as the base URL of a socket, got %q", unixBaseURL, base)
+	}
+}
+
+func TestNewHTTPClientDialsSocket(t *testing.T) {
+	socket := filepath.Join(t.TempDir(), "server.sock")
+	listener, err := net.Listen("unix", socket)
+	if err != nil {
+		t.Fatalf("Failed to listen on socket: %v", err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		io.WriteString(w, r.URL.Path)
+	})}
+	go server.Serve(listener)
+	defer server.Close()
+
+	addr := UnixScheme + socket
+	client := NewHTTPClient(addr, time.Second)
+	resp, err := client.Get(HTTPBaseURL(addr) + "/value/gauge/cpu")
+	if err != nil {
+		t.Fatalf("Request over the socket failed: %v", err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if string(body) != "/value/gauge/cpu" {
+		t.Errorf("Expected the server to receive the request path, got %q", body)
+	}
+
+	if NewHTTPClient(addr, time.Second).Transport != client.Transport {
+		t.Error("Expected clients for the same socket to share a transport")
+	}
+}
@@ -2,19 +2,22 @@ package worker
 
 import (
 	"bytes"
-	"compress/gzip"
 	"context"
 	"crypto/rsa"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
 	"sync"
 	"time"
 
+	"github.com/mutualEvg/metrics-server/internal/batch"
 	"github.com/mutualEvg/metrics-server/internal/crypto"
+	"github.com/mutualEvg/metrics-server/internal/deadletter"
 	"github.com/mutualEvg/metrics-server/internal/hash"
 	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/mutualEvg/metrics-server/internal/pool"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/mutualEvg/metrics-server/internal/utils"
 )
@@ -32,9 +35,18 @@ type Pool struct {
 	rateLimit   int
 	httpClient  *http.Client
 	serverAddr  string
-	key         string         // Key for SHA256 signature
+	key         string         // Key for HMAC signature
+	hashAlgo    string         // Signing algorithm, "sha256" or "sha512"
 	publicKey   *rsa.PublicKey // Public key for encryption
 	retryConfig retry.RetryConfig
+	// onBackpressure is called when the server asks the agent to slow down
+	onBackpressure func(retryAfter time.Duration)
+	// deadLetter records metrics that could not be sent after all retries (nil disables)
+	deadLetter *deadletter.Writer
+	// onResult is called after each metric send with the final error, nil on success
+	onResult func(metric models.Metrics, err error)
+	// tracer logs the send stages of a single metric (nil disables)
+	tracer *Tracer
 }
 
 // NewPool creates a new worker pool
@@ -42,9 +54,10 @@ func NewPool(rateLimit int, serverAddr, key string, retryConfig retry.RetryConfi
 	return &Pool{
 		jobs:        make(chan MetricData, rateLimit*10), // Buffer to handle burst metrics
 		rateLimit:   rateLimit,
-		httpClient:  &http.Client{Timeout: 10 * time.Second},
+		httpClient:  utils.NewHTTPClient(serverAddr, 10*time.Second),
 		serverAddr:  serverAddr,
 		key:         key,
+		hashAlgo:    hash.AlgoSHA256,
 		publicKey:   nil,
 		retryConfig: retryConfig,
 	}
@@ -58,6 +71,34 @@ func (p *Pool) SetPublicKey(publicKey *rsa.PublicKey) {
 	}
 }
 
+// SetHashAlgo sets the signing algorithm, "sha256" (default) or "sha512"
+func (p *Pool) SetHashAlgo(algo string) {
+	p.hashAlgo = algo
+}
+
+// SetBackpressureHandler sets the callback invoked when the server responds with a
+// backpressure signal. It may be called concurrently from several workers.
+func (p *Pool) SetBackpressureHandler(handler func(retryAfter time.Duration)) {
+	p.onBackpressure = handler
+}
+
+// SetResultHandler sets the callback invoked after each metric send with the metric and
+// nil on success or the error remaining after all retries. It may be called concurrently
+// from several workers.
+func (p *Pool) SetResultHandler(handler func(metric models.Metrics, err error)) {
+	p.onResult = handler
+}
+
+// SetTracer sets the tracer logging the send stages of a single metric
+func (p *Pool) SetTracer(t *Tracer) {
+	p.tracer = t
+}
+
+// SetDeadLetter sets the log receiving metrics that could not be sent after all retries
+func (p *Pool) SetDeadLetter(w *deadletter.Writer) {
+	p.deadLetter = w
+}
+
 // Start initializes the worker pool
 func (p *Pool) Start() {
 	for i := 0; i < p.rateLimit; i++ {
@@ -85,9 +126,10 @@ func (p *Pool) SubmitMetric(metric MetricData) {
 
 	select {
 	case p.jobs <- metric:
-		// Metric submitted successfully
+		p.tracer.Trace(metric.Metric, "queued for worker pool", nil)
 	default:
 		log.Printf("Worker pool queue full, dropping metric: %s", metric.Metric.ID)
+		p.tracer.Trace(metric.Metric, "dropped, worker pool queue full", nil)
 	}
 }
 
@@ -109,25 +151,22 @@ func (p *Pool) sendMetric(metricData MetricData) {
 	defer cancel()
 
 	err := retry.Do(ctx, p.retryConfig, func() error {
+		p.tracer.Trace(metricData.Metric, "sent", nil)
+
 		jsonData, err := json.Marshal(metricData.Metric)
 		if err != nil {
 			return fmt.Errorf("failed to marshal JSON: %w", err)
 		}
 
 		// Compress the JSON data
-		var compressedData bytes.Buffer
-		gzipWriter := gzip.NewWriter(&compressedData)
-		_, err = gzipWriter.Write(jsonData)
+		compressedData, err := pool.Gzip(jsonData)
 		if err != nil {
 			return fmt.Errorf("failed to compress data: %w", err)
 		}
-		err = gzipWriter.Close()
-		if err != nil {
-			return fmt.Errorf("failed to close gzip writer: %w", err)
-		}
 
 		// Prepare body data (may be encrypted)
-		bodyData := compressedData.Bytes()
+		bodyData := compressedData
+		encrypted := false
 
 		// Encrypt if public key is configured
 		if p.publicKey != nil {
@@ -136,9 +175,10 @@ func (p *Pool) sendMetric(metricData MetricData) {
 				return fmt.Errorf("failed to encrypt data: %w", err)
 			}
 			bodyData = encryptedData
+			encrypted = true
 		}
 
-		url := fmt.Sprintf("%s/update/", p.serverAddr)
+		url := fmt.Sprintf("%s/update/", utils.HTTPBaseURL(p.serverAddr))
 		req, err := http.NewRequest(http.MethodPost, url, bytes.NewReader(bodyData))
 		if err != nil {
 			return fmt.Errorf("failed to create request: %w", err)
@@ -151,15 +191,15 @@ func (p *Pool) sendMetric(metricData MetricData) {
 		// Add X-Real-IP header with the agent's IP address
 		req.Header.Set("X-Real-IP", utils.GetOutboundIP())
 
-		// Add encryption header if data is encrypted
-		if p.publicKey != nil {
-			req.Header.Set("X-Encrypted", "true")
+		// The server only decrypts bodies marked as encrypted
+		if encrypted {
+			req.Header.Set(crypto.EncryptedHeader, "true")
 		}
 
 		// Add hash header if key is configured (hash is computed before encryption)
 		if p.key != "" {
-			hashValue := hash.CalculateHash(compressedData.Bytes(), p.key)
-			req.Header.Set("HashSHA256", hashValue)
+			hashValue := hash.Calculate(compressedData, p.key, p.hashAlgo)
+			req.Header.Set(hash.HeaderName(p.hashAlgo), hashValue)
 		}
 
 		resp, err := p.httpClient.Do(req)
@@ -168,6 +208,10 @@ func (p *Pool) sendMetric(metricData MetricData) {
 		}
 		defer resp.Body.Close()
 
+		if err := batch.CheckBackpressure(resp); err != nil {
+			return err
+		}
+
 		if resp.StatusCode != http.StatusOK {
 			return fmt.Errorf("server returned non-OK status: %s", resp.Status)
 		}
@@ -175,7 +219,27 @@ func (p *Pool) sendMetric(metricData MetricData) {
 		return nil
 	})
 
+	var bpErr *batch.BackpressureError
+	if errors.As(err, &bpErr) && p.onBackpressure != nil {
+		p.onBackpressure(bpErr.RetryAfter)
+	}
+
+	if p.onResult != nil {
+		p.onResult(metricData.Metric, err)
+	}
+
+	if err != nil {
+		p.tracer.Trace(metricData.Metric, "failed", err)
+	} else {
+		p.tracer.Trace(metricData.Metric, "acked", nil)
+	}
+
 	if err != nil {
 		log.Printf("Failed to send %s metric %s after retries: %v", metricData.Type, metricData.Metric.ID, err)
+		if p.deadLetter != nil {
+			if dlErr := p.deadLetter.Write(metricData.Metric, err); dlErr != nil {
+				log.Printf("Failed to write metric %s to dead-letter log: %v", metricData.Metric.ID, dlErr)
+			}
+		}
 	}
 }
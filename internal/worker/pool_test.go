@@ -3,10 +3,14 @@ package worker
 import (
 	"net/http"
 	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"testing"
 	"time"
 
+	"github.com/mutualEvg/metrics-server/internal/deadletter"
 	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 )
@@ -285,3 +289,31 @@ processLoop:
 
 	t.Logf("Processed %d/%d metrics (some may have been dropped due to queue capacity)", finalCount, submittedCount)
 }
+
+func TestFailedMetricWrittenToDeadLetter(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "dead-letter.jsonl")
+	deadLetter, err := deadletter.NewWriter(deadletter.Config{Path: path})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer deadLetter.Close()
+
+	pool := NewPool(1, server.URL, "", retry.NoRetryConfig())
+	pool.SetDeadLetter(deadLetter)
+
+	value := 42.0
+	pool.sendMetric(MetricData{Metric: models.Metrics{ID: "Alloc", MType: "gauge", Value: &value}, Type: "runtime"})
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(data), `"id":"Alloc"`) || !strings.Contains(string(data), "500") {
+		t.Errorf("Expected the failed metric and error in the dead-letter log, got %q", data)
+	}
+}
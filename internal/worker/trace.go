@@ -0,0 +1,47 @@
+package worker
+
+import (
+	"log"
+	"strconv"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// Tracer logs each stage of a single metric's journey through the agent, e.g.
+// collected, buffered, sent and acked, to debug why that metric does not arrive.
+// Other metrics are ignored, so logs are not flooded. A nil Tracer traces nothing.
+type Tracer struct {
+	id     string
+	logger *log.Logger
+}
+
+// NewTracer creates a tracer for the metric with the given ID, including any instance
+// prefix. It returns nil, which disables tracing, if id is empty.
+func NewTracer(id string) *Tracer {
+	if id == "" {
+		return nil
+	}
+	return &Tracer{id: id, logger: log.Default()}
+}
+
+// Trace logs that metric reached stage, with the error if the stage failed
+func (t *Tracer) Trace(metric models.Metrics, stage string, err error) {
+	if t == nil || metric.ID != t.id {
+		return
+	}
+
+	value := "<nil>"
+	switch {
+	case metric.Value != nil:
+		value = strconv.FormatFloat(*metric.Value, 'g', -1, 64)
+	case metric.Delta != nil:
+		value = strconv.FormatInt(*metric.Delta, 10)
+	}
+
+	if err != nil {
+		t.logger.Printf("trace %s %s=%s: %s at %s: %v", metric.MType, metric.ID, value, stage, time.Now().Format(time.RFC3339Nano), err)
+		return
+	}
+	t.logger.Printf("trace %s %s=%s: %s at %s", metric.MType, metric.ID, value, stage, time.Now().Format(time.RFC3339Nano))
+}
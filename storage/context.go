@@ -0,0 +1,94 @@
+package storage
+
+import "context"
+
+// StorageCtx is implemented by storages whose operations honour a context, so that
+// request cancellation and deadlines propagate to the backend. DBStorage implements it;
+// in-memory storages complete immediately and do not need to.
+type StorageCtx interface {
+	// UpdateGaugeCtx sets the value of a gauge metric
+	UpdateGaugeCtx(ctx context.Context, name string, value float64)
+
+	// UpdateCounterCtx adds the delta value to a counter metric
+	UpdateCounterCtx(ctx context.Context, name string, value int64)
+
+	// GetGaugeCtx retrieves a gauge metric value. Returns value and true if found, false otherwise.
+	GetGaugeCtx(ctx context.Context, name string) (float64, bool)
+
+	// GetCounterCtx retrieves a counter metric value. Returns value and true if found, false otherwise.
+	GetCounterCtx(ctx context.Context, name string) (int64, bool)
+
+	// GetAllCtx returns all gauge and counter metrics as separate maps
+	GetAllCtx(ctx context.Context) (map[string]float64, map[string]int64)
+}
+
+// FloatCountersCtx is implemented by storages keeping float counters whose operations
+// honour a context, see StorageCtx
+type FloatCountersCtx interface {
+	// UpdateFloatCounterCtx adds the delta value to a float counter
+	UpdateFloatCounterCtx(ctx context.Context, name string, value float64)
+
+	// GetFloatCounterCtx retrieves a float counter total. Returns value and true if found, false otherwise.
+	GetFloatCounterCtx(ctx context.Context, name string) (float64, bool)
+
+	// GetAllFloatCountersCtx returns the totals of all float counters
+	GetAllFloatCountersCtx(ctx context.Context) map[string]float64
+}
+
+// WithContext returns a Storage whose operations use ctx, typically the context of an
+// incoming request. If s does not implement StorageCtx, s itself is returned. The result
+// implements FloatCounters if s implements both StorageCtx and FloatCountersCtx.
+func WithContext(ctx context.Context, s Storage) Storage {
+	sc, ok := s.(StorageCtx)
+	if !ok {
+		return s
+	}
+	if fc, ok := s.(FloatCountersCtx); ok {
+		return &ctxFloatStorage{ctxStorage: ctxStorage{ctx: ctx, storage: sc}, floats: fc}
+	}
+	return &ctxStorage{ctx: ctx, storage: sc}
+}
+
+// ctxStorage adapts a StorageCtx bound to a context to the Storage interface
+type ctxStorage struct {
+	ctx     context.Context
+	storage StorageCtx
+}
+
+func (cs *ctxStorage) UpdateGauge(name string, value float64) {
+	cs.storage.UpdateGaugeCtx(cs.ctx, name, value)
+}
+
+func (cs *ctxStorage) UpdateCounter(name string, value int64) {
+	cs.storage.UpdateCounterCtx(cs.ctx, name, value)
+}
+
+func (cs *ctxStorage) GetGauge(name string) (float64, bool) {
+	return cs.storage.GetGaugeCtx(cs.ctx, name)
+}
+
+func (cs *ctxStorage) GetCounter(name string) (int64, bool) {
+	return cs.storage.GetCounterCtx(cs.ctx, name)
+}
+
+func (cs *ctxStorage) GetAll() (map[string]float64, map[string]int64) {
+	return cs.storage.GetAllCtx(cs.ctx)
+}
+
+// ctxFloatStorage is a ctxStorage that also adapts FloatCountersCtx to FloatCounters
+type ctxFloatStorage struct {
+	ctxStorage
+	floats FloatCountersCtx
+}
+
+func (cs *ctxFloatStorage) UpdateFloatCounter(name string, value float64) {
+	cs.floats.UpdateFloatCounterCtx(cs.ctx, name, value)
+}
+
+func (cs *ctxFloatStorage) GetFloatCounter(name string) (float64, bool) {
+	return cs.floats.GetFloatCounterCtx(cs.ctx, name)
+}
+
+func (cs *ctxFloatStorage) GetAllFloatCounters() map[string]float64 {
+	return cs.floats.GetAllFloatCountersCtx(cs.ctx)
+}
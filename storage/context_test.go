@@ -0,0 +1,128 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+type ctxKey struct{}
+
+// recordingCtxStorage is a StorageCtx that remembers the contexts it was called with
+type recordingCtxStorage struct {
+	*MemStorage
+	contexts []context.Context
+}
+
+func (rs *recordingCtxStorage) UpdateGaugeCtx(ctx context.Context, name string, value float64) {
+	rs.contexts = append(rs.contexts, ctx)
+	rs.UpdateGauge(name, value)
+}
+
+func (rs *recordingCtxStorage) UpdateCounterCtx(ctx context.Context, name string, value int64) {
+	rs.contexts = append(rs.contexts, ctx)
+	rs.UpdateCounter(name, value)
+}
+
+func (rs *recordingCtxStorage) GetGaugeCtx(ctx context.Context, name string) (float64, bool) {
+	rs.contexts = append(rs.contexts, ctx)
+	return rs.GetGauge(name)
+}
+
+func (rs *recordingCtxStorage) GetCounterCtx(ctx context.Context, name string) (int64, bool) {
+	rs.contexts = append(rs.contexts, ctx)
+	return rs.GetCounter(name)
+}
+
+func (rs *recordingCtxStorage) GetAllCtx(ctx context.Context) (map[string]float64, map[string]int64) {
+	rs.contexts = append(rs.contexts, ctx)
+	return rs.GetAll()
+}
+
+// recordingFloatCtxStorage is a recordingCtxStorage that also keeps float counters
+type recordingFloatCtxStorage struct {
+	recordingCtxStorage
+}
+
+func (rs *recordingFloatCtxStorage) UpdateFloatCounterCtx(ctx context.Context, name string, value float64) {
+	rs.contexts = append(rs.contexts, ctx)
+	rs.UpdateFloatCounter(name, value)
+}
+
+func (rs *recordingFloatCtxStorage) GetFloatCounterCtx(ctx context.Context, name string) (float64, bool) {
+	rs.contexts = append(rs.contexts, ctx)
+	return rs.GetFloatCounter(name)
+}
+
+func (rs *recordingFloatCtxStorage) GetAllFloatCountersCtx(ctx context.Context) map[string]float64 {
+	rs.contexts = append(rs.contexts, ctx)
+	return rs.GetAllFloatCounters()
+}
+
+func TestWithContextUsesContextMethods(t *testing.T) {
+	rs := &recordingCtxStorage{MemStorage: NewMemStorage()}
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+
+	s := WithContext(ctx, rs)
+	s.UpdateGauge("Alloc", 1.5)
+	s.UpdateCounter("PollCount", 2)
+	if v, ok := s.GetGauge("Alloc"); !ok || v != 1.5 {
+		t.Errorf("Expected gauge 1.5, got %v, %v", v, ok)
+	}
+	if v, ok := s.GetCounter("PollCount"); !ok || v != 2 {
+		t.Errorf("Expected counter 2, got %v, %v", v, ok)
+	}
+	if g, c := s.GetAll(); len(g) != 1 || len(c) != 1 {
+		t.Errorf("Expected one gauge and one counter, got %v, %v", g, c)
+	}
+
+	if len(rs.contexts) != 5 {
+		t.Fatalf("Expected 5 context-aware calls, got %d", len(rs.contexts))
+	}
+	for i, got := range rs.contexts {
+		if got.Value(ctxKey{}) != "request" {
+			t.Errorf("Call %d did not receive the bound context", i)
+		}
+	}
+}
+
+func TestWithContextFloatCounters(t *testing.T) {
+	ctx := context.WithValue(context.Background(), ctxKey{}, "request")
+
+	if _, ok := WithContext(ctx, &recordingCtxStorage{MemStorage: NewMemStorage()}).(FloatCounters); ok {
+		t.Error("Expected no float counters for a storage without context-aware float counters")
+	}
+
+	rs := &recordingFloatCtxStorage{recordingCtxStorage{MemStorage: NewMemStorage()}}
+	floats, ok := WithContext(ctx, rs).(FloatCounters)
+	if !ok {
+		t.Fatal("Expected float counters for a storage with context-aware float counters")
+	}
+	floats.UpdateFloatCounter("Energy", 0.5)
+	if v, ok := floats.GetFloatCounter("Energy"); !ok || v != 0.5 {
+		t.Errorf("Expected float counter 0.5, got %v, %v", v, ok)
+	}
+	if all := floats.GetAllFloatCounters(); len(all) != 1 || all["Energy"] != 0.5 {
+		t.Errorf("Expected one float counter, got %v", all)
+	}
+
+	if len(rs.contexts) != 3 {
+		t.Fatalf("Expected 3 context-aware calls, got %d", len(rs.contexts))
+	}
+	for i, got := range rs.contexts {
+		if got.Value(ctxKey{}) != "request" {
+			t.Errorf("Call %d did not receive the bound context", i)
+		}
+	}
+}
+
+func TestWithContextFallsBackToStorage(t *testing.T) {
+	ms := NewMemStorage()
+	if s := WithContext(context.Background(), ms); s != Storage(ms) {
+		t.Errorf("Expected storage without context support to be returned unchanged, got %T", s)
+	}
+}
+
+func TestDBStorageImplementsStorageCtx(t *testing.T) {
+	var _ StorageCtx = (*DBStorage)(nil)
+	var _ FloatCountersCtx = (*DBStorage)(nil)
+}
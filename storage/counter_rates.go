@@ -0,0 +1,152 @@
+package storage
+
+import (
+	"sync"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// CounterRates keeps the deltas applied to each counter during a recent window, so that
+// the increase of a counter over that window can be read without diffing successive
+// scrapes. Deltas are summed into buckets of a fixed resolution held in a ring buffer
+// per counter, so memory grows with the number of counters updated within the window
+// times window/resolution. Counters without a delta in the window are forgotten.
+// It is safe for concurrent use; a nil CounterRates records nothing.
+type CounterRates struct {
+	mu         sync.Mutex
+	resolution time.Duration
+	buckets    int
+	rings      map[string]*rateRing
+	nextSweep  int64 // Bucket number at which idle rings are next evicted
+	now        func() time.Time
+}
+
+// rateRing holds the deltas of one counter, indexed by bucket number modulo its length
+type rateRing struct {
+	epochs []int64 // Bucket number each slot currently holds
+	deltas []int64
+	last   int64 // Bucket number of the latest delta
+}
+
+// NewCounterRates creates a tracker covering window in buckets of resolution.
+// The window is rounded up to a whole number of buckets.
+func NewCounterRates(window, resolution time.Duration) *CounterRates {
+	resolution = max(resolution, time.Millisecond)
+	buckets := int((window + resolution - 1) / resolution)
+	return &CounterRates{
+		resolution: resolution,
+		buckets:    max(buckets, 1),
+		rings:      make(map[string]*rateRing),
+		now:        time.Now,
+	}
+}
+
+// Window returns the longest window rates can be computed over
+func (c *CounterRates) Window() time.Duration {
+	return time.Duration(c.buckets) * c.resolution
+}
+
+// Resolution returns the granularity of the recorded deltas
+func (c *CounterRates) Resolution() time.Duration {
+	return c.resolution
+}
+
+// Add records a delta applied to the named counter
+func (c *CounterRates) Add(name string, delta int64) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ring, ok := c.rings[name]
+	if !ok {
+		ring = &rateRing{epochs: make([]int64, c.buckets), deltas: make([]int64, c.buckets)}
+		for i := range ring.epochs {
+			ring.epochs[i] = -1
+		}
+		c.rings[name] = ring
+	}
+
+	epoch := c.epoch()
+	slot := int(epoch % int64(c.buckets))
+	if ring.epochs[slot] != epoch {
+		ring.epochs[slot] = epoch
+		ring.deltas[slot] = 0
+	}
+	ring.deltas[slot] += delta
+	ring.last = epoch
+
+	if epoch >= c.nextSweep {
+		c.evictIdleLocked(epoch)
+	}
+}
+
+// evictIdleLocked forgets the counters without a delta within the window, at most once
+// per window so that the sweep costs amortized constant time per Add; the caller must hold c.mu
+func (c *CounterRates) evictIdleLocked(epoch int64) {
+	for name, ring := range c.rings {
+		if ring.last <= epoch-int64(c.buckets) {
+			delete(c.rings, name)
+		}
+	}
+	c.nextSweep = epoch + int64(c.buckets)
+}
+
+// AddBatch records the deltas of the counters among metrics
+func (c *CounterRates) AddBatch(metrics []models.Metrics) {
+	if c == nil {
+		return
+	}
+	for _, m := range metrics {
+		if m.MType == models.CounterType && m.Delta != nil {
+			c.Add(m.ID, *m.Delta)
+		}
+	}
+}
+
+// Span returns the window deltas are summed over for a requested window: the window
+// rounded up to whole buckets and capped at Window
+func (c *CounterRates) Span(window time.Duration) time.Duration {
+	n := (window + c.resolution - 1) / c.resolution
+	return min(max(n, 1), time.Duration(c.buckets)) * c.resolution
+}
+
+// Delta returns the sum of the deltas applied to the named counter within Span(window).
+// The result reports false if no delta of the counter was recorded, or the counter has
+// been forgotten for lack of deltas within the tracked window.
+func (c *CounterRates) Delta(name string, window time.Duration) (int64, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ring, ok := c.rings[name]
+	if !ok {
+		return 0, false
+	}
+
+	oldest := c.epoch() - int64(c.Span(window)/c.resolution)
+
+	var sum int64
+	for i, epoch := range ring.epochs {
+		if epoch > oldest {
+			sum += ring.deltas[i]
+		}
+	}
+	return sum, true
+}
+
+// Reset forgets all recorded deltas, e.g. after the stored metrics were deleted
+func (c *CounterRates) Reset() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.rings = make(map[string]*rateRing)
+}
+
+// epoch returns the number of the current bucket; the caller must hold c.mu
+func (c *CounterRates) epoch() int64 {
+	return c.now().UnixNano() / int64(c.resolution)
+}
@@ -0,0 +1,135 @@
+package storage
+
+import (
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// newTestCounterRates creates a tracker driven by the returned clock
+func newTestCounterRates(window, resolution time.Duration) (*CounterRates, *time.Time) {
+	now := time.Unix(1_700_000_000, 0)
+	c := NewCounterRates(window, resolution)
+	c.now = func() time.Time { return now }
+	return c, &now
+}
+
+func TestCounterRatesSlidingWindow(t *testing.T) {
+	c, now := newTestCounterRates(time.Minute, 10*time.Second)
+
+	if _, ok := c.Delta("Requests", time.Minute); ok {
+		t.Error("Expected an unknown counter to be reported as missing")
+	}
+
+	// One increment of 5 every 10 seconds for two minutes
+	for range 12 {
+		c.Add("Requests", 5)
+		*now = now.Add(10 * time.Second)
+	}
+	*now = now.Add(-time.Second) // Still within the bucket of the last increment
+
+	tests := []struct {
+		window time.Duration
+		want   int64
+	}{
+		{time.Minute, 30},
+		{30 * time.Second, 15},
+		{25 * time.Second, 15}, // Rounded up to whole buckets
+		{time.Second, 5},
+		{time.Hour, 30}, // Capped at the tracked window
+	}
+	for _, tt := range tests {
+		if got, ok := c.Delta("Requests", tt.window); !ok || got != tt.want {
+			t.Errorf("Delta over %v = %d (found %v), want %d", tt.window, got, ok, tt.want)
+		}
+	}
+
+	// Without further increments the deltas age out of the window
+	*now = now.Add(time.Minute)
+	if got, ok := c.Delta("Requests", time.Minute); !ok || got != 0 {
+		t.Errorf("Expected delta 0 once the window has passed, got %d (found %v)", got, ok)
+	}
+}
+
+func TestCounterRatesSameBucket(t *testing.T) {
+	c, now := newTestCounterRates(time.Minute, 10*time.Second)
+
+	delta, value := int64(3), 1.5
+	c.Add("Requests", 2)
+	c.AddBatch([]models.Metrics{
+		{ID: "Requests", MType: "counter", Delta: &delta},
+		{ID: "Alloc", MType: "gauge", Value: &value},
+	})
+	if got, _ := c.Delta("Requests", 10*time.Second); got != 5 {
+		t.Errorf("Expected deltas of one bucket to be summed to 5, got %d", got)
+	}
+	if _, ok := c.Delta("Alloc", time.Minute); ok {
+		t.Error("Expected gauges not to be tracked")
+	}
+
+	// The slot is reused once the ring wraps around
+	*now = now.Add(time.Minute)
+	c.Add("Requests", 1)
+	if got, _ := c.Delta("Requests", time.Minute); got != 1 {
+		t.Errorf("Expected the wrapped slot to be cleared, got %d", got)
+	}
+}
+
+func TestCounterRatesReset(t *testing.T) {
+	c, _ := newTestCounterRates(time.Minute, time.Second)
+	c.Add("Requests", 1)
+	c.Reset()
+	if _, ok := c.Delta("Requests", time.Minute); ok {
+		t.Error("Expected reset to forget the counter")
+	}
+
+	var disabled *CounterRates
+	disabled.Add("Requests", 1)
+	disabled.Reset()
+}
+
+func TestNewCounterRatesWindow(t *testing.T) {
+	c := NewCounterRates(65*time.Second, 10*time.Second)
+	if got := c.Window(); got != 70*time.Second {
+		t.Errorf("Expected the window to be rounded up to 70s, got %v", got)
+	}
+}
+
+func TestCounterRatesSpan(t *testing.T) {
+	c := NewCounterRates(time.Minute, 10*time.Second)
+
+	tests := []struct {
+		window, want time.Duration
+	}{
+		{time.Second, 10 * time.Second},
+		{25 * time.Second, 30 * time.Second},
+		{time.Minute, time.Minute},
+		{time.Hour, time.Minute},
+	}
+	for _, tt := range tests {
+		if got := c.Span(tt.window); got != tt.want {
+			t.Errorf("Span(%v) = %v, want %v", tt.window, got, tt.want)
+		}
+	}
+}
+
+func TestCounterRatesEvictsIdleCounters(t *testing.T) {
+	c, now := newTestCounterRates(time.Minute, 10*time.Second)
+
+	c.Add("Idle", 1)
+	for range 7 {
+		*now = now.Add(10 * time.Second)
+		c.Add("Busy", 1)
+	}
+
+	if _, ok := c.Delta("Idle", time.Minute); ok {
+		t.Error("Expected a counter without deltas in the window to be forgotten")
+	}
+	if got, ok := c.Delta("Busy", time.Minute); !ok || got != 6 {
+		t.Errorf("Expected the busy counter to be kept with delta 6, got %d (found %v)", got, ok)
+	}
+	if n := len(c.rings); n != 1 {
+		t.Errorf("Expected 1 tracked counter, got %d", n)
+	}
+}
@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+	"time"
+)
+
+// PoolSettings configures the connection pool of a DBStorage. Zero values keep the
+// database/sql defaults: unlimited open connections, 2 idle connections and no
+// maximum connection lifetime.
+type PoolSettings struct {
+	// MaxOpenConns limits the connections in use plus idle, making bursts wait for a
+	// free connection instead of exhausting the server's connection slots
+	MaxOpenConns int
+
+	// MaxIdleConns is the number of connections kept open between bursts
+	MaxIdleConns int
+
+	// ConnMaxLifetime closes connections after this age, e.g. to rebalance behind a proxy
+	ConnMaxLifetime time.Duration
+}
+
+// poolConfigurer is the part of *sql.DB the pool settings are applied to
+type poolConfigurer interface {
+	SetMaxOpenConns(n int)
+	SetMaxIdleConns(n int)
+	SetConnMaxLifetime(d time.Duration)
+}
+
+// WithPoolSettings applies the given connection pool settings right after connecting
+func WithPoolSettings(p PoolSettings) DBStorageOption {
+	return func(ds *DBStorage) {
+		ds.pool = p
+	}
+}
+
+// validate returns an error if any setting is negative
+func (p PoolSettings) validate() error {
+	if p.MaxOpenConns < 0 || p.MaxIdleConns < 0 || p.ConnMaxLifetime < 0 {
+		return fmt.Errorf("invalid database pool settings %+v: values must not be negative", p)
+	}
+	return nil
+}
+
+// apply sets the non-zero settings on db
+func (p PoolSettings) apply(db poolConfigurer) {
+	if p.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(p.MaxOpenConns)
+	}
+	if p.MaxIdleConns > 0 {
+		db.SetMaxIdleConns(p.MaxIdleConns)
+	}
+	if p.ConnMaxLifetime > 0 {
+		db.SetConnMaxLifetime(p.ConnMaxLifetime)
+	}
+}
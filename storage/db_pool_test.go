@@ -0,0 +1,60 @@
+package storage
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// recordingPool records the pool settings applied to it
+type recordingPool struct {
+	maxOpen, maxIdle int
+	lifetime         time.Duration
+	calls            int
+}
+
+func (p *recordingPool) SetMaxOpenConns(n int)              { p.maxOpen = n; p.calls++ }
+func (p *recordingPool) SetMaxIdleConns(n int)              { p.maxIdle = n; p.calls++ }
+func (p *recordingPool) SetConnMaxLifetime(d time.Duration) { p.lifetime = d; p.calls++ }
+
+func TestPoolSettingsApply(t *testing.T) {
+	// Zero values keep the database/sql defaults
+	var untouched recordingPool
+	PoolSettings{}.apply(&untouched)
+	if untouched.calls != 0 {
+		t.Errorf("Expected no settings applied for zero values, got %d calls", untouched.calls)
+	}
+
+	var pool recordingPool
+	PoolSettings{MaxOpenConns: 20, MaxIdleConns: 5, ConnMaxLifetime: 30 * time.Minute}.apply(&pool)
+	if pool.maxOpen != 20 || pool.maxIdle != 5 || pool.lifetime != 30*time.Minute {
+		t.Errorf("Expected 20 open, 5 idle, 30m lifetime, got %+v", pool)
+	}
+
+	// The settings reach a real *sql.DB; sqlx.Open does not connect
+	db, err := sqlx.Open("postgres", "postgres://localhost/metrics?sslmode=disable")
+	if err != nil {
+		t.Fatalf("Failed to open database handle: %v", err)
+	}
+	defer db.Close()
+	PoolSettings{MaxOpenConns: 7}.apply(db.DB)
+	if got := db.Stats().MaxOpenConnections; got != 7 {
+		t.Errorf("Expected MaxOpenConnections 7, got %d", got)
+	}
+}
+
+func TestNewDBStorageRejectsNegativePoolSettings(t *testing.T) {
+	_, err := NewDBStorage("postgres://localhost/metrics", WithPoolSettings(PoolSettings{MaxOpenConns: -1}))
+	if err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("Expected negative pool settings to be rejected before connecting, got %v", err)
+	}
+}
+
+func TestPoolSettingsAppliedOnConnect(t *testing.T) {
+	ds := newTestDBStorage(t, WithPoolSettings(PoolSettings{MaxOpenConns: 3}))
+	if got := ds.db.Stats().MaxOpenConnections; got != 3 {
+		t.Errorf("Expected MaxOpenConnections 3, got %d", got)
+	}
+}
@@ -5,6 +5,8 @@ import (
 	"context"
 	"database/sql"
 	"fmt"
+	"regexp"
+	"strings"
 	"time"
 
 	"github.com/jmoiron/sqlx"
@@ -12,28 +14,95 @@ import (
 	"github.com/mutualEvg/metrics-server/internal/models"
 	"github.com/mutualEvg/metrics-server/internal/retry"
 	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/errgroup"
+)
+
+// Deadlines of database operations. Request contexts usually carry none, so the Ctx
+// methods apply them on top of the caller's context to keep a hung database from
+// pinning the calling goroutines.
+const (
+	queryTimeout = 5 * time.Second  // single-metric reads and writes
+	scanTimeout  = 10 * time.Second // whole-table reads and resets
+	batchTimeout = 15 * time.Second // batch transactions
 )
 
 type DBStorage struct {
-	db          *sqlx.DB
-	retryConfig retry.RetryConfig
+	db                 *sqlx.DB
+	retryConfig        retry.RetryConfig
+	retryStats         *retry.Stats
+	schema             string // Schema holding the tables, empty for the search path default
+	gaugesTable        string // Qualified name of the gauges table
+	countersTable      string // Qualified name of the counters table
+	floatCountersTable string // Qualified name of the float counters table
+	partitions         int    // Number of hash partitions per metric table, 0 for plain tables
+	pool               PoolSettings
+}
+
+// DBStorageOption configures a DBStorage created by NewDBStorage
+type DBStorageOption func(*DBStorage)
+
+// identifierPattern matches the unquoted PostgreSQL identifiers accepted as schema names.
+// Schema names are interpolated into queries, so anything else is rejected.
+var identifierPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]{0,62}$`)
+
+// WithSchema qualifies the metric tables with the given schema, e.g. "metrics_srv" stores
+// gauges in metrics_srv.gauges. The schema is created if it does not exist. An empty
+// schema keeps the unqualified table names.
+func WithSchema(schema string) DBStorageOption {
+	return func(ds *DBStorage) {
+		ds.schema = schema
+	}
+}
+
+// ValidateSchemaName returns an error if schema cannot be used as a schema name.
+// An empty name is valid and selects the unqualified table names.
+func ValidateSchemaName(schema string) error {
+	if schema != "" && !identifierPattern.MatchString(schema) {
+		return fmt.Errorf("invalid database schema %q: must start with a letter or underscore and contain only letters, digits and underscores (max 63)", schema)
+	}
+	return nil
 }
 
 // NewDBStorage creates a new database storage instance
-func NewDBStorage(dsn string) (*DBStorage, error) {
+func NewDBStorage(dsn string, opts ...DBStorageOption) (*DBStorage, error) {
+	return NewDBStorageContext(context.Background(), dsn, opts...)
+}
+
+// NewDBStorageContext is like NewDBStorage but gives up connecting and migrating
+// as soon as ctx is done, e.g. when the server is shut down during startup
+func NewDBStorageContext(ctx context.Context, dsn string, opts ...DBStorageOption) (*DBStorage, error) {
 	storage := &DBStorage{
 		retryConfig: retry.DefaultConfig(),
+		retryStats:  &retry.Stats{},
+	}
+	storage.retryConfig.OnAttempt = storage.retryStats.Record
+	for _, opt := range opts {
+		opt(storage)
 	}
 
+	if err := ValidateSchemaName(storage.schema); err != nil {
+		return nil, err
+	}
+	if err := validatePartitions(storage.partitions); err != nil {
+		return nil, err
+	}
+	if err := storage.pool.validate(); err != nil {
+		return nil, err
+	}
+	storage.gaugesTable = storage.qualify("gauges")
+	storage.countersTable = storage.qualify("counters")
+	storage.floatCountersTable = storage.qualify("float_counters")
+
 	// Connect to database with retry logic
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	connectCtx, cancel := context.WithTimeout(ctx, 30*time.Second)
 	defer cancel()
 
-	err := retry.Do(ctx, storage.retryConfig, func() error {
-		db, err := sqlx.Connect("postgres", dsn)
+	err := retry.Do(connectCtx, storage.retryConfig, func() error {
+		db, err := connectContext(connectCtx, dsn)
 		if err != nil {
 			return fmt.Errorf("failed to connect to database: %w", err)
 		}
+		storage.pool.apply(db.DB)
 		storage.db = db
 		return nil
 	})
@@ -42,64 +111,79 @@ func NewDBStorage(dsn string) (*DBStorage, error) {
 		return nil, err
 	}
 
-	// Create tables if they don't exist
-	if err := storage.createTables(); err != nil {
+	// Bring the schema up to date
+	if err := storage.migrate(ctx); err != nil {
 		storage.db.Close()
-		return nil, fmt.Errorf("failed to create tables: %w", err)
+		return nil, fmt.Errorf("failed to migrate database: %w", err)
 	}
 
 	log.Info().Msg("Database storage initialized successfully")
 	return storage, nil
 }
 
-// createTables creates the necessary tables for storing metrics
-func (ds *DBStorage) createTables() error {
-	queries := []string{
-		`CREATE TABLE IF NOT EXISTS gauges (
-			name VARCHAR(255) PRIMARY KEY,
-			value DOUBLE PRECISION NOT NULL,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
-		`CREATE TABLE IF NOT EXISTS counters (
-			name VARCHAR(255) PRIMARY KEY,
-			value BIGINT NOT NULL,
-			updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
-		)`,
+// connectContext opens and pings a connection like sqlx.ConnectContext, but returns as
+// soon as ctx is done: lib/pq honors ctx only while dialing, not during the startup
+// handshake, so a server that accepts but never answers would block until TCP gives up.
+// An abandoned attempt closes its connection if it eventually succeeds.
+func connectContext(ctx context.Context, dsn string) (*sqlx.DB, error) {
+	type result struct {
+		db  *sqlx.DB
+		err error
+	}
+	done := make(chan result, 1)
+	go func() {
+		db, err := sqlx.ConnectContext(ctx, "postgres", dsn)
+		done <- result{db, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.db, r.err
+	case <-ctx.Done():
+		go func() {
+			if r := <-done; r.db != nil {
+				r.db.Close()
+			}
+		}()
+		return nil, ctx.Err()
 	}
+}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// RetryStats returns the counts of database operation attempts, including connection attempts
+func (ds *DBStorage) RetryStats() retry.StatsSnapshot {
+	return ds.retryStats.Snapshot()
+}
 
-	for _, query := range queries {
-		err := retry.Do(ctx, ds.retryConfig, func() error {
-			_, err := ds.db.Exec(query)
-			return err
-		})
-		if err != nil {
-			return fmt.Errorf("failed to execute query %s: %w", query, err)
-		}
+// qualify returns the table name prefixed with the configured schema, if any
+func (ds *DBStorage) qualify(table string) string {
+	if ds.schema == "" {
+		return table
 	}
-
-	return nil
+	return ds.schema + "." + table
 }
 
 // UpdateGauge updates or inserts a gauge metric
 func (ds *DBStorage) UpdateGauge(name string, value float64) {
+	ds.UpdateGaugeCtx(context.Background(), name, value)
+}
+
+// UpdateGaugeCtx updates or inserts a gauge metric, aborting when ctx is done
+func (ds *DBStorage) UpdateGaugeCtx(ctx context.Context, name string, value float64) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	if ds.db == nil {
 		log.Error().Str("name", name).Float64("value", value).Msg("Database connection is nil, cannot update gauge")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
-	query := `INSERT INTO gauges (name, value, updated_at) 
+	query := `INSERT INTO ` + ds.gaugesTable + ` (name, value, updated_at) 
 			  VALUES ($1, $2, CURRENT_TIMESTAMP) 
 			  ON CONFLICT (name) 
-			  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP`
+			  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = NULL`
 
 	err := retry.Do(ctx, ds.retryConfig, func() error {
-		_, err := ds.db.Exec(query, name, value)
+		_, err := ds.db.ExecContext(ctx, query, name, value)
 		return err
 	})
 
@@ -113,30 +197,35 @@ func (ds *DBStorage) UpdateGauge(name string, value float64) {
 
 // UpdateCounter updates or inserts a counter metric (adds to existing value)
 func (ds *DBStorage) UpdateCounter(name string, value int64) {
+	ds.UpdateCounterCtx(context.Background(), name, value)
+}
+
+// UpdateCounterCtx adds value to a counter metric, aborting when ctx is done
+func (ds *DBStorage) UpdateCounterCtx(ctx context.Context, name string, value int64) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	if ds.db == nil {
 		log.Error().Str("name", name).Int64("value", value).Msg("Database connection is nil, cannot update counter")
 		return
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	err := retry.Do(ctx, ds.retryConfig, func() error {
 		// First try to get existing value
 		var currentValue int64
-		err := ds.db.Get(&currentValue, "SELECT value FROM counters WHERE name = $1", name)
+		err := ds.db.GetContext(ctx, &currentValue, "SELECT value FROM "+ds.countersTable+" WHERE name = $1", name)
 		if err != nil && err != sql.ErrNoRows {
 			return fmt.Errorf("failed to get counter from database: %w", err)
 		}
 
 		newValue := currentValue + value
 
-		query := `INSERT INTO counters (name, value, updated_at) 
+		query := `INSERT INTO ` + ds.countersTable + ` (name, value, updated_at) 
 				  VALUES ($1, $2, CURRENT_TIMESTAMP) 
 				  ON CONFLICT (name) 
-				  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP`
+				  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = NULL`
 
-		_, err = ds.db.Exec(query, name, newValue)
+		_, err = ds.db.ExecContext(ctx, query, name, newValue)
 		return err
 	})
 
@@ -148,19 +237,58 @@ func (ds *DBStorage) UpdateCounter(name string, value int64) {
 	log.Debug().Str("name", name).Int64("value", value).Msg("Updated counter in database")
 }
 
+// UpdateFloatCounter adds value to a float counter metric
+func (ds *DBStorage) UpdateFloatCounter(name string, value float64) {
+	ds.UpdateFloatCounterCtx(context.Background(), name, value)
+}
+
+// UpdateFloatCounterCtx adds value to a float counter metric, aborting when ctx is done.
+// The sum is computed by the upsert, so concurrent updates are not lost.
+func (ds *DBStorage) UpdateFloatCounterCtx(ctx context.Context, name string, value float64) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		log.Error().Str("name", name).Float64("value", value).Msg("Database connection is nil, cannot update float counter")
+		return
+	}
+
+	query := `INSERT INTO ` + ds.floatCountersTable + ` AS t (name, value, updated_at) 
+			  VALUES ($1, $2, CURRENT_TIMESTAMP) 
+			  ON CONFLICT (name) 
+			  DO UPDATE SET value = t.value + EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = NULL`
+
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		_, err := ds.db.ExecContext(ctx, query, name, value)
+		return err
+	})
+
+	if err != nil {
+		log.Error().Err(err).Str("name", name).Float64("value", value).Msg("Failed to update float counter in database after retries")
+		return
+	}
+
+	log.Debug().Str("name", name).Float64("value", value).Msg("Updated float counter in database")
+}
+
 // GetGauge retrieves a gauge metric
 func (ds *DBStorage) GetGauge(name string) (float64, bool) {
+	return ds.GetGaugeCtx(context.Background(), name)
+}
+
+// GetGaugeCtx retrieves a gauge metric, aborting when ctx is done
+func (ds *DBStorage) GetGaugeCtx(ctx context.Context, name string) (float64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	if ds.db == nil {
 		log.Error().Str("name", name).Msg("Database connection is nil, cannot get gauge")
 		return 0, false
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	var value float64
 	err := retry.Do(ctx, ds.retryConfig, func() error {
-		return ds.db.Get(&value, "SELECT value FROM gauges WHERE name = $1", name)
+		return ds.db.GetContext(ctx, &value, "SELECT value FROM "+ds.gaugesTable+" WHERE name = $1", name)
 	})
 
 	if err != nil {
@@ -176,17 +304,22 @@ func (ds *DBStorage) GetGauge(name string) (float64, bool) {
 
 // GetCounter retrieves a counter metric
 func (ds *DBStorage) GetCounter(name string) (int64, bool) {
+	return ds.GetCounterCtx(context.Background(), name)
+}
+
+// GetCounterCtx retrieves a counter metric, aborting when ctx is done
+func (ds *DBStorage) GetCounterCtx(ctx context.Context, name string) (int64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
 	if ds.db == nil {
 		log.Error().Str("name", name).Msg("Database connection is nil, cannot get counter")
 		return 0, false
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
-
 	var value int64
 	err := retry.Do(ctx, ds.retryConfig, func() error {
-		return ds.db.Get(&value, "SELECT value FROM counters WHERE name = $1", name)
+		return ds.db.GetContext(ctx, &value, "SELECT value FROM "+ds.countersTable+" WHERE name = $1", name)
 	})
 
 	if err != nil {
@@ -200,22 +333,168 @@ func (ds *DBStorage) GetCounter(name string) (int64, bool) {
 	return value, true
 }
 
+// GetFloatCounter retrieves a float counter metric
+func (ds *DBStorage) GetFloatCounter(name string) (float64, bool) {
+	return ds.GetFloatCounterCtx(context.Background(), name)
+}
+
+// GetFloatCounterCtx retrieves a float counter metric, aborting when ctx is done
+func (ds *DBStorage) GetFloatCounterCtx(ctx context.Context, name string) (float64, bool) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		log.Error().Str("name", name).Msg("Database connection is nil, cannot get float counter")
+		return 0, false
+	}
+
+	var value float64
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		return ds.db.GetContext(ctx, &value, "SELECT value FROM "+ds.floatCountersTable+" WHERE name = $1", name)
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return 0, false
+		}
+		log.Error().Err(err).Str("name", name).Msg("Failed to get float counter from database after retries")
+		return 0, false
+	}
+
+	return value, true
+}
+
+// GetAllFloatCounters retrieves all float counters
+func (ds *DBStorage) GetAllFloatCounters() map[string]float64 {
+	return ds.GetAllFloatCountersCtx(context.Background())
+}
+
+// GetAllFloatCountersCtx retrieves all float counters, aborting when ctx is done
+func (ds *DBStorage) GetAllFloatCountersCtx(ctx context.Context) map[string]float64 {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		log.Error().Msg("Database connection is nil, cannot get all float counters")
+		return make(map[string]float64)
+	}
+
+	floatCounters := make(map[string]float64)
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		clear(floatCounters)
+		rows, err := ds.db.QueryContext(ctx, "SELECT name, value FROM "+ds.floatCountersTable)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var name string
+			var value float64
+			if err := rows.Scan(&name, &value); err != nil {
+				log.Error().Err(err).Msg("Failed to scan float counter row")
+				continue
+			}
+			floatCounters[name] = value
+		}
+
+		return rows.Err()
+	})
+
+	if err != nil {
+		log.Error().Err(err).Msg("Failed to get float counters from database after retries")
+		return make(map[string]float64)
+	}
+	return floatCounters
+}
+
+// LastUpdated returns the time a metric was last written
+func (ds *DBStorage) LastUpdated(mtype, name string) (time.Time, bool) {
+	return ds.LastUpdatedCtx(context.Background(), mtype, name)
+}
+
+// LastUpdatedCtx returns the time a metric was last written, aborting when ctx is done.
+// For batch updates that supplied one, this is the agent's collection time.
+func (ds *DBStorage) LastUpdatedCtx(ctx context.Context, mtype, name string) (time.Time, bool) {
+	ctx, cancel := context.WithTimeout(ctx, queryTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		log.Error().Str("name", name).Msg("Database connection is nil, cannot get update time")
+		return time.Time{}, false
+	}
+
+	var table string
+	switch mtype {
+	case models.GaugeType:
+		table = ds.gaugesTable
+	case models.CounterType:
+		table = ds.countersTable
+	case models.FloatCounterType:
+		table = ds.floatCountersTable
+	default:
+		return time.Time{}, false
+	}
+
+	// The ::timestamptz cast interprets the column in the session time zone like CURRENT_TIMESTAMP does
+	var updatedAt time.Time
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		return ds.db.GetContext(ctx, &updatedAt, "SELECT COALESCE(collected_at, updated_at)::timestamptz FROM "+table+" WHERE name = $1", name)
+	})
+
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, false
+		}
+		log.Error().Err(err).Str("name", name).Msg("Failed to get update time from database after retries")
+		return time.Time{}, false
+	}
+
+	return updatedAt, true
+}
+
 // GetAll retrieves all metrics
 func (ds *DBStorage) GetAll() (map[string]float64, map[string]int64) {
-	gauges := make(map[string]float64)
-	counters := make(map[string]int64)
+	return ds.GetAllCtx(context.Background())
+}
+
+// GetAllCtx retrieves all metrics, aborting when ctx is done. Gauges and counters are
+// queried concurrently on separate connections, so each reflects its table at a slightly
+// different moment, as it would when queried one after the other.
+func (ds *DBStorage) GetAllCtx(ctx context.Context) (map[string]float64, map[string]int64) {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
 
 	if ds.db == nil {
 		log.Error().Msg("Database connection is nil, cannot get all metrics")
-		return gauges, counters
+		return make(map[string]float64), make(map[string]int64)
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+	var gauges map[string]float64
+	var counters map[string]int64
+	g, gctx := errgroup.WithContext(ctx)
+	g.Go(func() error {
+		var err error
+		gauges, err = ds.queryGauges(gctx)
+		return err
+	})
+	g.Go(func() error {
+		var err error
+		counters, err = ds.queryCounters(gctx)
+		return err
+	})
+	g.Wait()
+
+	return gauges, counters
+}
 
-	// Get all gauges with retry
+// queryGauges retrieves all gauges with retry. On failure the error is logged and
+// returned together with an empty map.
+func (ds *DBStorage) queryGauges(ctx context.Context) (map[string]float64, error) {
+	gauges := make(map[string]float64)
 	err := retry.Do(ctx, ds.retryConfig, func() error {
-		rows, err := ds.db.Query("SELECT name, value FROM gauges")
+		clear(gauges)
+		rows, err := ds.db.QueryContext(ctx, "SELECT name, value FROM "+ds.gaugesTable)
 		if err != nil {
 			return err
 		}
@@ -236,12 +515,18 @@ func (ds *DBStorage) GetAll() (map[string]float64, map[string]int64) {
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get gauges from database after retries")
-		return gauges, counters
+		return make(map[string]float64), err
 	}
+	return gauges, nil
+}
 
-	// Get all counters with retry
-	err = retry.Do(ctx, ds.retryConfig, func() error {
-		rows, err := ds.db.Query("SELECT name, value FROM counters")
+// queryCounters retrieves all counters with retry. On failure the error is logged and
+// returned together with an empty map.
+func (ds *DBStorage) queryCounters(ctx context.Context) (map[string]int64, error) {
+	counters := make(map[string]int64)
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		clear(counters)
+		rows, err := ds.db.QueryContext(ctx, "SELECT name, value FROM "+ds.countersTable)
 		if err != nil {
 			return err
 		}
@@ -262,9 +547,133 @@ func (ds *DBStorage) GetAll() (map[string]float64, map[string]int64) {
 
 	if err != nil {
 		log.Error().Err(err).Msg("Failed to get counters from database after retries")
+		return make(map[string]int64), err
 	}
+	return counters, nil
+}
 
-	return gauges, counters
+// GetUpdatedSince returns all metrics modified after ts, e.g. for an incremental sync
+func (ds *DBStorage) GetUpdatedSince(ts time.Time) ([]models.Metrics, error) {
+	return ds.GetUpdatedSinceCtx(context.Background(), ts)
+}
+
+// StreamAll calls fn with every metric as it is read from the database, the gauges, the
+// counters and then the float counters, each sorted by name in byte order, so that memory
+// use does not grow with the number of metrics. Since metrics already passed to fn cannot
+// be taken back, failed queries are not retried once the first row has been read.
+func (ds *DBStorage) StreamAll(ctx context.Context, fn func(models.Metrics) error) error {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		return fmt.Errorf("database connection is not initialized")
+	}
+
+	query := `SELECT 0 AS kind, 'gauge' AS type, name, value, NULL::BIGINT AS delta FROM ` + ds.gaugesTable + `
+			  UNION ALL
+			  SELECT 1, 'counter', name, NULL, value FROM ` + ds.countersTable + `
+			  UNION ALL
+			  SELECT 2, 'floatcounter', name, value, NULL FROM ` + ds.floatCountersTable + `
+			  ORDER BY kind, name COLLATE "C"`
+
+	var rows *sql.Rows
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		var err error
+		rows, err = ds.db.QueryContext(ctx, query)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("failed to stream metrics: %w", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			metric models.Metrics
+			kind   int
+			value  sql.NullFloat64
+			delta  sql.NullInt64
+		)
+		if err := rows.Scan(&kind, &metric.MType, &metric.ID, &value, &delta); err != nil {
+			return fmt.Errorf("failed to scan metric row: %w", err)
+		}
+		if value.Valid {
+			metric.Value = &value.Float64
+		}
+		if delta.Valid {
+			metric.Delta = &delta.Int64
+		}
+		if err := fn(metric); err != nil {
+			return err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("failed to stream metrics: %w", err)
+	}
+	return nil
+}
+
+// GetUpdatedSinceCtx returns all metrics whose updated_at is after ts, ordered by
+// updated_at. Each metric carries its updated_at as Timestamp, so the newest one can
+// serve as ts of the next call. Counters hold their total value in Delta and float
+// counters in Value. updated_at is always the server's write time, so an agent
+// with a skewed clock cannot move the cursor.
+func (ds *DBStorage) GetUpdatedSinceCtx(ctx context.Context, ts time.Time) ([]models.Metrics, error) {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		return nil, fmt.Errorf("database connection is not initialized")
+	}
+
+	// The ::timestamptz casts interpret updated_at in the session time zone like CURRENT_TIMESTAMP does
+	query := `SELECT 'gauge' AS type, name, value, NULL::BIGINT AS delta, updated_at::timestamptz AS updated_at
+			  FROM ` + ds.gaugesTable + ` WHERE updated_at > $1::timestamptz
+			  UNION ALL
+			  SELECT 'counter', name, NULL, value, updated_at::timestamptz
+			  FROM ` + ds.countersTable + ` WHERE updated_at > $1::timestamptz
+			  UNION ALL
+			  SELECT 'floatcounter', name, value, NULL, updated_at::timestamptz
+			  FROM ` + ds.floatCountersTable + ` WHERE updated_at > $1::timestamptz
+			  ORDER BY updated_at, name`
+
+	var metrics []models.Metrics
+	err := retry.Do(ctx, ds.retryConfig, func() error {
+		metrics = nil
+		rows, err := ds.db.QueryContext(ctx, query, ts)
+		if err != nil {
+			return err
+		}
+		defer rows.Close()
+
+		for rows.Next() {
+			var (
+				metric    models.Metrics
+				value     sql.NullFloat64
+				delta     sql.NullInt64
+				updatedAt time.Time
+			)
+			if err := rows.Scan(&metric.MType, &metric.ID, &value, &delta, &updatedAt); err != nil {
+				return fmt.Errorf("failed to scan metric row: %w", err)
+			}
+			if value.Valid {
+				metric.Value = &value.Float64
+			}
+			if delta.Valid {
+				metric.Delta = &delta.Int64
+			}
+			millis := updatedAt.UnixMilli()
+			metric.Timestamp = &millis
+			metrics = append(metrics, metric)
+		}
+
+		return rows.Err()
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to get metrics updated since %s: %w", ts.Format(time.RFC3339), err)
+	}
+
+	return metrics, nil
 }
 
 // Ping checks the database connection
@@ -273,7 +682,7 @@ func (ds *DBStorage) Ping() error {
 		return fmt.Errorf("database connection is not initialized")
 	}
 
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), queryTimeout)
 	defer cancel()
 
 	return retry.Do(ctx, ds.retryConfig, func() error {
@@ -281,6 +690,29 @@ func (ds *DBStorage) Ping() error {
 	})
 }
 
+// Reset deletes all gauges, counters and float counters
+func (ds *DBStorage) Reset() error {
+	return ds.ResetCtx(context.Background())
+}
+
+// ResetCtx deletes all gauges, counters and float counters, aborting when ctx is done. All
+// tables are truncated in one statement, so readers never see only some of them emptied.
+func (ds *DBStorage) ResetCtx(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, scanTimeout)
+	defer cancel()
+
+	if ds.db == nil {
+		return fmt.Errorf("database connection is nil")
+	}
+
+	return retry.Do(ctx, ds.retryConfig, func() error {
+		if _, err := ds.db.ExecContext(ctx, "TRUNCATE "+strings.Join(ds.metricTables(), ", ")); err != nil {
+			return fmt.Errorf("failed to truncate metric tables: %w", err)
+		}
+		return nil
+	})
+}
+
 // Close closes the database connection
 func (ds *DBStorage) Close() error {
 	if ds.db != nil {
@@ -291,16 +723,28 @@ func (ds *DBStorage) Close() error {
 
 // UpdateBatch processes multiple metrics in a single database transaction
 func (ds *DBStorage) UpdateBatch(metrics []models.Metrics) error {
+	return ds.UpdateBatchCtx(context.Background(), metrics)
+}
+
+// UpdateBatchCtx processes multiple metrics in a single database transaction,
+// rolling it back when ctx is done. The whole batch is validated first, so a malformed
+// metric fails it with an error wrapping ErrInvalidMetric before anything is written.
+func (ds *DBStorage) UpdateBatchCtx(ctx context.Context, metrics []models.Metrics) error {
+	ctx, cancel := context.WithTimeout(ctx, batchTimeout)
+	defer cancel()
+
 	if ds.db == nil {
 		return fmt.Errorf("database connection is nil")
 	}
-
-	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Second)
-	defer cancel()
+	for _, metric := range metrics {
+		if err := metric.Validate(); err != nil {
+			return err
+		}
+	}
 
 	return retry.Do(ctx, ds.retryConfig, func() error {
 		// Start a transaction
-		tx, err := ds.db.Beginx()
+		tx, err := ds.db.BeginTxx(ctx, nil)
 		if err != nil {
 			return fmt.Errorf("failed to begin transaction: %w", err)
 		}
@@ -308,51 +752,45 @@ func (ds *DBStorage) UpdateBatch(metrics []models.Metrics) error {
 
 		// Process each metric in the transaction
 		for _, metric := range metrics {
-			// Validate required fields
-			if metric.ID == "" || metric.MType == "" {
-				return fmt.Errorf("metric ID and type are required")
-			}
-
 			switch metric.MType {
-			case "gauge":
-				if metric.Value == nil {
-					return fmt.Errorf("gauge value is required for metric %s", metric.ID)
-				}
-
-				query := `INSERT INTO gauges (name, value, updated_at) 
-						  VALUES ($1, $2, CURRENT_TIMESTAMP) 
+			case models.GaugeType:
+				query := `INSERT INTO ` + ds.gaugesTable + ` (name, value, updated_at, collected_at) 
+						  VALUES ($1, $2, CURRENT_TIMESTAMP, $3::timestamptz) 
 						  ON CONFLICT (name) 
-						  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP`
+						  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = EXCLUDED.collected_at`
 
-				if _, err := tx.Exec(query, metric.ID, *metric.Value); err != nil {
+				if _, err := tx.ExecContext(ctx, query, metric.ID, *metric.Value, collectedAt(metric)); err != nil {
 					return fmt.Errorf("failed to update gauge %s: %w", metric.ID, err)
 				}
 
-			case "counter":
-				if metric.Delta == nil {
-					return fmt.Errorf("counter delta is required for metric %s", metric.ID)
-				}
-
+			case models.CounterType:
 				// Get current value within transaction
 				var currentValue int64
-				err := tx.Get(&currentValue, "SELECT value FROM counters WHERE name = $1", metric.ID)
+				err := tx.GetContext(ctx, &currentValue, "SELECT value FROM "+ds.countersTable+" WHERE name = $1", metric.ID)
 				if err != nil && err != sql.ErrNoRows {
 					return fmt.Errorf("failed to get current counter value for %s: %w", metric.ID, err)
 				}
 
 				newValue := currentValue + *metric.Delta
 
-				query := `INSERT INTO counters (name, value, updated_at) 
-						  VALUES ($1, $2, CURRENT_TIMESTAMP) 
+				query := `INSERT INTO ` + ds.countersTable + ` (name, value, updated_at, collected_at) 
+						  VALUES ($1, $2, CURRENT_TIMESTAMP, $3::timestamptz) 
 						  ON CONFLICT (name) 
-						  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP`
+						  DO UPDATE SET value = EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = EXCLUDED.collected_at`
 
-				if _, err := tx.Exec(query, metric.ID, newValue); err != nil {
+				if _, err := tx.ExecContext(ctx, query, metric.ID, newValue, collectedAt(metric)); err != nil {
 					return fmt.Errorf("failed to update counter %s: %w", metric.ID, err)
 				}
 
-			default:
-				return fmt.Errorf("unknown metric type: %s", metric.MType)
+			case models.FloatCounterType:
+				query := `INSERT INTO ` + ds.floatCountersTable + ` AS t (name, value, updated_at, collected_at) 
+						  VALUES ($1, $2, CURRENT_TIMESTAMP, $3::timestamptz) 
+						  ON CONFLICT (name) 
+						  DO UPDATE SET value = t.value + EXCLUDED.value, updated_at = CURRENT_TIMESTAMP, collected_at = EXCLUDED.collected_at`
+
+				if _, err := tx.ExecContext(ctx, query, metric.ID, *metric.Value, collectedAt(metric)); err != nil {
+					return fmt.Errorf("failed to update float counter %s: %w", metric.ID, err)
+				}
 			}
 		}
 
@@ -364,3 +802,12 @@ func (ds *DBStorage) UpdateBatch(metrics []models.Metrics) error {
 		return nil
 	})
 }
+
+// collectedAt returns the agent-supplied collection time of a metric,
+// or a NULL value when the agent did not send one
+func collectedAt(metric models.Metrics) sql.NullTime {
+	if metric.Timestamp == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: time.UnixMilli(*metric.Timestamp), Valid: true}
+}
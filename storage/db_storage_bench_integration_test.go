@@ -0,0 +1,51 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// BenchmarkDBStorageGetAll compares querying gauges and counters one after the other with
+// the concurrent queries of GetAllCtx. It requires a PostgreSQL database given by
+// TEST_DATABASE_DSN, seeded with 5000 metrics of each type.
+func BenchmarkDBStorageGetAll(b *testing.B) {
+	ds := newTestDBStorage(b)
+
+	const seeded = 5000
+	metrics := make([]models.Metrics, 0, 2*seeded)
+	for i := 0; i < seeded; i++ {
+		value, delta := float64(i), int64(i)
+		metrics = append(metrics,
+			models.Metrics{ID: fmt.Sprintf("gauge_%d", i), MType: "gauge", Value: &value},
+			models.Metrics{ID: fmt.Sprintf("counter_%d", i), MType: "counter", Delta: &delta},
+		)
+	}
+	if err := ds.UpdateBatch(metrics); err != nil {
+		b.Fatalf("Failed to seed metrics: %v", err)
+	}
+	ctx := context.Background()
+
+	b.Run("Sequential", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if _, err := ds.queryGauges(ctx); err != nil {
+				b.Fatal(err)
+			}
+			if _, err := ds.queryCounters(ctx); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("Parallel", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			if gauges, counters := ds.GetAllCtx(ctx); len(gauges) != seeded || len(counters) != seeded {
+				b.Fatalf("Expected %d metrics of each type, got %d gauges and %d counters", seeded, len(gauges), len(counters))
+			}
+		}
+	})
+}
@@ -3,7 +3,10 @@ package storage
 import (
 	"context"
 	"encoding/json"
+	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 	"sync"
 	"time"
 
@@ -13,8 +16,9 @@ import (
 
 // FileStorage represents the data structure for JSON serialization
 type FileStorage struct {
-	Gauges   map[string]float64 `json:"gauges"`
-	Counters map[string]int64   `json:"counters"`
+	Gauges        map[string]float64 `json:"gauges"`
+	Counters      map[string]int64   `json:"counters"`
+	FloatCounters map[string]float64 `json:"float_counters,omitempty"`
 }
 
 // FileManager handles file operations for metrics storage
@@ -23,6 +27,7 @@ type FileManager struct {
 	storage     Storage
 	mu          sync.RWMutex
 	retryConfig retry.RetryConfig
+	split       bool // Persist gauges and counters in separate files
 }
 
 // NewFileManager creates a new file manager
@@ -34,40 +39,54 @@ func NewFileManager(filePath string, storage Storage) *FileManager {
 	}
 }
 
-// SaveToFile saves the current metrics to file
-func (fm *FileManager) SaveToFile() error {
+// SetSplit enables persisting gauges and counters in two separate files
+// derived from the configured path, e.g. metrics.gauges.json and metrics.counters.json.
+// The single combined file is used by default.
+func (fm *FileManager) SetSplit(split bool) {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
+	fm.split = split
+}
 
-	gauges, counters := fm.storage.GetAll()
-
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
-	defer cancel()
+// SplitPaths returns the gauge and counter file paths used in split mode
+func (fm *FileManager) SplitPaths() (gaugesPath, countersPath string) {
+	base := strings.TrimSuffix(fm.filePath, filepath.Ext(fm.filePath))
+	return base + ".gauges.json", base + ".counters.json"
+}
 
-	return retry.Do(ctx, fm.retryConfig, func() error {
-		data := FileStorage{
-			Gauges:   gauges,
-			Counters: counters,
-		}
+// floatCountersPath returns the float counter file path used in split mode. The file
+// is only created once float counters are stored.
+func (fm *FileManager) floatCountersPath() string {
+	return strings.TrimSuffix(fm.filePath, filepath.Ext(fm.filePath)) + ".floatcounters.json"
+}
 
-		jsonData, err := json.MarshalIndent(data, "", "  ")
-		if err != nil {
-			return err
-		}
+// journalPath returns the path of the journal listing the files of a split save that
+// is being moved into place, see writeSnapshot
+func (fm *FileManager) journalPath() string {
+	return strings.TrimSuffix(fm.filePath, filepath.Ext(fm.filePath)) + ".journal.json"
+}
 
-		// Write to temporary file first, then rename for atomic operation
-		tempFile := fm.filePath + ".tmp"
-		err = os.WriteFile(tempFile, jsonData, 0644)
-		if err != nil {
-			return err
-		}
+// SaveToFile saves the current metrics to file
+func (fm *FileManager) SaveToFile() error {
+	if ms, ok := fm.storage.(*MemStorage); ok {
+		ms.mu.RLock()
+		gauges, counters := ms.getAllInternal()
+		floatCounters := ms.getFloatCountersInternal()
+		ms.mu.RUnlock()
+		return fm.saveData(gauges, counters, floatCounters)
+	}
 
-		return os.Rename(tempFile, fm.filePath)
-	})
+	gauges, counters := fm.storage.GetAll()
+	return fm.SaveToFileWithData(gauges, counters)
 }
 
 // SaveToFileWithData saves the provided data to file (used to avoid deadlocks)
 func (fm *FileManager) SaveToFileWithData(gauges map[string]float64, counters map[string]int64) error {
+	return fm.saveData(gauges, counters, nil)
+}
+
+// saveData saves the provided gauges, counters and float counters to file
+func (fm *FileManager) saveData(gauges map[string]float64, counters map[string]int64, floatCounters map[string]float64) error {
 	fm.mu.Lock()
 	defer fm.mu.Unlock()
 
@@ -75,48 +94,155 @@ func (fm *FileManager) SaveToFileWithData(gauges map[string]float64, counters ma
 	defer cancel()
 
 	return retry.Do(ctx, fm.retryConfig, func() error {
-		data := FileStorage{
-			Gauges:   gauges,
-			Counters: counters,
+		if fm.split {
+			gaugesPath, countersPath := fm.SplitPaths()
+			files := map[string]any{
+				gaugesPath:   gauges,
+				countersPath: counters,
+			}
+			// Once created, the float counters file is part of every snapshot, so that
+			// emptied float counters are saved as well
+			if _, err := os.Stat(fm.floatCountersPath()); len(floatCounters) > 0 || err == nil {
+				files[fm.floatCountersPath()] = floatCounters
+			}
+			return writeSnapshot(fm.journalPath(), files)
+		}
+
+		return writeSnapshot(fm.journalPath(), map[string]any{
+			fm.filePath: FileStorage{
+				Gauges:        gauges,
+				Counters:      counters,
+				FloatCounters: floatCounters,
+			},
+		})
+	})
+}
+
+// writeSnapshot replaces files with the JSON encoding of their data. Every file is
+// written to a temporary path first, so a failed write leaves the previous files intact.
+// Renaming several files into place is not atomic, though: a crash in between would
+// leave files of different snapshots behind. The temporary paths are therefore recorded
+// in a journal before the first rename and the journal is removed after the last one;
+// recoverSnapshot completes the renames of a journal left behind by a crash.
+func writeSnapshot(journal string, files map[string]any) error {
+	tempFiles := make(map[string]string, len(files))
+	defer func() {
+		for _, tempFile := range tempFiles {
+			os.Remove(tempFile)
 		}
+	}()
 
+	for path, data := range files {
 		jsonData, err := json.MarshalIndent(data, "", "  ")
 		if err != nil {
 			return err
 		}
 
-		// Write to temporary file first, then rename for atomic operation
-		tempFile := fm.filePath + ".tmp"
-		err = os.WriteFile(tempFile, jsonData, 0644)
+		tempFile := path + ".tmp"
+		if err := writeFileSynced(tempFile, jsonData); err != nil {
+			return err
+		}
+		tempFiles[path] = tempFile
+	}
+
+	// A single rename is atomic on its own
+	if len(tempFiles) > 1 {
+		journalData, err := json.Marshal(tempFiles)
 		if err != nil {
 			return err
 		}
+		if err := writeFileSynced(journal+".tmp", journalData); err != nil {
+			return err
+		}
+		if err := os.Rename(journal+".tmp", journal); err != nil {
+			os.Remove(journal + ".tmp")
+			return err
+		}
+	}
 
-		return os.Rename(tempFile, fm.filePath)
-	})
+	for path, tempFile := range tempFiles {
+		if err := os.Rename(tempFile, path); err != nil {
+			return err
+		}
+		delete(tempFiles, path)
+	}
+
+	if err := os.Remove(journal); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
 }
 
-// LoadFromFile loads metrics from file into storage
+// recoverSnapshot completes a save interrupted while its files were renamed into place,
+// so that the files loaded afterwards all belong to the same snapshot
+func recoverSnapshot(journal string) error {
+	data, err := os.ReadFile(journal)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var tempFiles map[string]string
+	if err := json.Unmarshal(data, &tempFiles); err != nil {
+		return fmt.Errorf("invalid save journal %s: %w", journal, err)
+	}
+	for path, tempFile := range tempFiles {
+		if err := os.Rename(tempFile, path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	log.Warn().Str("journal", journal).Msg("Completed an interrupted save of the metrics files")
+	return os.Remove(journal)
+}
+
+// writeFileSynced writes data to path and flushes it to disk before returning, so that
+// files renamed into place after a crash are complete
+func writeFileSynced(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := file.Write(data); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Sync(); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// LoadFromFile loads metrics from file into storage, first completing a save that was
+// interrupted by a crash
 func (fm *FileManager) LoadFromFile(storage Storage) error {
-	fm.mu.RLock()
-	defer fm.mu.RUnlock()
+	fm.mu.Lock()
+	defer fm.mu.Unlock()
 
 	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer cancel()
 
 	return retry.Do(ctx, fm.retryConfig, func() error {
-		data, err := os.ReadFile(fm.filePath)
-		if err != nil {
-			if os.IsNotExist(err) {
-				// File doesn't exist, which is fine for first run
-				return nil
-			}
+		if err := recoverSnapshot(fm.journalPath()); err != nil {
 			return err
 		}
 
 		var fileData FileStorage
-		err = json.Unmarshal(data, &fileData)
-		if err != nil {
+
+		if fm.split {
+			gaugesPath, countersPath := fm.SplitPaths()
+			if err := readJSONFile(gaugesPath, &fileData.Gauges); err != nil {
+				return err
+			}
+			if err := readJSONFile(countersPath, &fileData.Counters); err != nil {
+				return err
+			}
+			if err := readJSONFile(fm.floatCountersPath(), &fileData.FloatCounters); err != nil {
+				return err
+			}
+		} else if err := readJSONFile(fm.filePath, &fileData); err != nil {
 			return err
 		}
 
@@ -130,9 +256,14 @@ func (fm *FileManager) LoadFromFile(storage Storage) error {
 			// For counters, we set the value directly rather than adding
 			// since we're restoring the exact state
 			if memStorage, ok := storage.(*MemStorage); ok {
-				memStorage.mu.Lock()
-				memStorage.counters[name] = value
-				memStorage.mu.Unlock()
+				memStorage.setCounter(name, value)
+			}
+		}
+
+		// Load float counters, set directly like counters
+		for name, value := range fileData.FloatCounters {
+			if memStorage, ok := storage.(*MemStorage); ok {
+				memStorage.setFloatCounter(name, value)
 			}
 		}
 
@@ -140,15 +271,48 @@ func (fm *FileManager) LoadFromFile(storage Storage) error {
 	})
 }
 
+// readJSONFile decodes a JSON file into v. A missing file is not an error,
+// which is fine for the first run.
+func readJSONFile(path string, v any) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
 // FileExists checks if the storage file exists
 func (fm *FileManager) FileExists() bool {
 	fm.mu.RLock()
 	defer fm.mu.RUnlock()
 
+	if fm.split {
+		gaugesPath, countersPath := fm.SplitPaths()
+		_, gaugesErr := os.Stat(gaugesPath)
+		_, countersErr := os.Stat(countersPath)
+		return !os.IsNotExist(gaugesErr) || !os.IsNotExist(countersErr)
+	}
+
 	_, err := os.Stat(fm.filePath)
 	return !os.IsNotExist(err)
 }
 
+// CheckWritable verifies that the directory of the storage file accepts new files
+func (fm *FileManager) CheckWritable() error {
+	fm.mu.RLock()
+	defer fm.mu.RUnlock()
+
+	probe, err := os.CreateTemp(filepath.Dir(fm.filePath), ".healthcheck-*")
+	if err != nil {
+		return err
+	}
+	probe.Close()
+	return os.Remove(probe.Name())
+}
+
 // PeriodicSaver handles periodic saving of metrics
 type PeriodicSaver struct {
 	fileManager *FileManager
@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
@@ -232,3 +233,172 @@ func TestFileStorage_JSONFormat(t *testing.T) {
 		}
 	}
 }
+
+func TestFileManager_SplitSaveAndLoad(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "metrics.json")
+
+	storage := NewMemStorage()
+	fileManager := NewFileManager(filePath, storage)
+	fileManager.SetSplit(true)
+
+	storage.UpdateGauge("test_gauge", 123.45)
+	storage.UpdateCounter("test_counter", 42)
+	storage.UpdateCounter("test_counter", 8)
+
+	if err := fileManager.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save to file: %v", err)
+	}
+
+	gaugesPath, countersPath := fileManager.SplitPaths()
+	if gaugesPath != filepath.Join(tempDir, "metrics.gauges.json") || countersPath != filepath.Join(tempDir, "metrics.counters.json") {
+		t.Errorf("Unexpected split paths: %s, %s", gaugesPath, countersPath)
+	}
+
+	// Only the split files are written, without leftover temporary files
+	entries, err := os.ReadDir(tempDir)
+	if err != nil {
+		t.Fatalf("Failed to read dir: %v", err)
+	}
+	if len(entries) != 2 {
+		t.Errorf("Expected 2 files, got %d", len(entries))
+	}
+
+	gaugeData, err := os.ReadFile(gaugesPath)
+	if err != nil {
+		t.Fatalf("Failed to read gauges file: %v", err)
+	}
+	if strings.Contains(string(gaugeData), "test_counter") {
+		t.Error("Gauges file should not contain counters")
+	}
+
+	// Load into fresh storage; counters must be restored, not accumulated twice
+	newStorage := NewMemStorage()
+	if err := fileManager.LoadFromFile(newStorage); err != nil {
+		t.Fatalf("Failed to load from file: %v", err)
+	}
+
+	if gauge, ok := newStorage.GetGauge("test_gauge"); !ok || gauge != 123.45 {
+		t.Errorf("Expected gauge value 123.45, got %f", gauge)
+	}
+	if counter, ok := newStorage.GetCounter("test_counter"); !ok || counter != 50 {
+		t.Errorf("Expected counter value 50, got %d", counter)
+	}
+}
+
+func TestFileManager_SplitLoadPartial(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "metrics.json")
+
+	storage := NewMemStorage()
+	fileManager := NewFileManager(filePath, storage)
+	fileManager.SetSplit(true)
+
+	if fileManager.FileExists() {
+		t.Error("No split files should exist yet")
+	}
+
+	// Only the counters file is present
+	_, countersPath := fileManager.SplitPaths()
+	if err := os.WriteFile(countersPath, []byte(`{"PollCount": 7}`), 0644); err != nil {
+		t.Fatalf("Failed to write counters file: %v", err)
+	}
+
+	if !fileManager.FileExists() {
+		t.Error("FileExists should report a partial split state")
+	}
+	if err := fileManager.LoadFromFile(storage); err != nil {
+		t.Fatalf("Failed to load from file: %v", err)
+	}
+	if counter, ok := storage.GetCounter("PollCount"); !ok || counter != 7 {
+		t.Errorf("Expected counter value 7, got %d", counter)
+	}
+}
+
+func TestFileManager_SplitRecoversInterruptedSave(t *testing.T) {
+	tempDir := t.TempDir()
+	filePath := filepath.Join(tempDir, "metrics.json")
+
+	storage := NewMemStorage()
+	fileManager := NewFileManager(filePath, storage)
+	fileManager.SetSplit(true)
+
+	storage.UpdateGauge("Alloc", 1)
+	storage.UpdateCounter("PollCount", 1)
+	if err := fileManager.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save to file: %v", err)
+	}
+
+	// The next save crashed after renaming the counters file, but not the gauges file
+	gaugesPath, countersPath := fileManager.SplitPaths()
+	if err := os.WriteFile(gaugesPath+".tmp", []byte(`{"Alloc": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(countersPath, []byte(`{"PollCount": 2}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	journal := fmt.Sprintf(`{%q: %q, %q: %q}`, gaugesPath, gaugesPath+".tmp", countersPath, countersPath+".tmp")
+	if err := os.WriteFile(fileManager.journalPath(), []byte(journal), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewMemStorage()
+	if err := fileManager.LoadFromFile(restored); err != nil {
+		t.Fatalf("Failed to load from file: %v", err)
+	}
+	if gauge, _ := restored.GetGauge("Alloc"); gauge != 2 {
+		t.Errorf("Expected the gauge of the interrupted save, got %v", gauge)
+	}
+	if counter, _ := restored.GetCounter("PollCount"); counter != 2 {
+		t.Errorf("Expected the counter of the interrupted save, got %d", counter)
+	}
+	for _, path := range []string{fileManager.journalPath(), gaugesPath + ".tmp"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("Expected %s to be removed by the recovery, got %v", filepath.Base(path), err)
+		}
+	}
+}
+
+func TestFileManager_FloatCounters(t *testing.T) {
+	for _, split := range []bool{false, true} {
+		t.Run(fmt.Sprintf("split=%v", split), func(t *testing.T) {
+			tempDir := t.TempDir()
+			filePath := filepath.Join(tempDir, "metrics.json")
+
+			storage := NewMemStorage()
+			fileManager := NewFileManager(filePath, storage)
+			fileManager.SetSplit(split)
+
+			storage.UpdateFloatCounter("Bytes", 1.5)
+			storage.UpdateFloatCounter("Bytes", 2.25)
+			if err := fileManager.SaveToFile(); err != nil {
+				t.Fatalf("Failed to save to file: %v", err)
+			}
+
+			// Totals are restored, not accumulated onto existing values
+			newStorage := NewMemStorage()
+			newStorage.UpdateFloatCounter("Bytes", 100)
+			if err := fileManager.LoadFromFile(newStorage); err != nil {
+				t.Fatalf("Failed to load from file: %v", err)
+			}
+			if v, ok := newStorage.GetFloatCounter("Bytes"); !ok || v != 3.75 {
+				t.Errorf("Expected float counter 3.75, got %v %v", v, ok)
+			}
+
+			// Emptied float counters are not restored either
+			if err := storage.Reset(); err != nil {
+				t.Fatalf("Reset failed: %v", err)
+			}
+			if err := fileManager.SaveToFile(); err != nil {
+				t.Fatalf("Failed to save to file: %v", err)
+			}
+			emptied := NewMemStorage()
+			if err := fileManager.LoadFromFile(emptied); err != nil {
+				t.Fatalf("Failed to load from file: %v", err)
+			}
+			if _, ok := emptied.GetFloatCounter("Bytes"); ok {
+				t.Error("Expected no float counter after saving an emptied storage")
+			}
+		})
+	}
+}
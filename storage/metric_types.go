@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// ErrTypeConflict is returned when a metric is written with a type other than the one it was first seen with
+var ErrTypeConflict = errors.New("metric type conflict")
+
+// TypeRegistry remembers the first-seen type of each metric name so that a metric
+// reported as a gauge cannot later be written as a counter, or vice versa.
+// It is safe for concurrent use; a nil registry accepts every write.
+type TypeRegistry struct {
+	mu    sync.Mutex
+	types map[string]string
+}
+
+// NewTypeRegistry creates an empty type registry
+func NewTypeRegistry() *TypeRegistry {
+	return &TypeRegistry{types: make(map[string]string)}
+}
+
+// Seed records the types of the metrics already held by s, e.g. after restoring from
+// a file or database. A name stored both as a gauge and a counter is recorded as a gauge.
+func (r *TypeRegistry) Seed(s Storage) {
+	gauges, counters := s.GetAll()
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for name := range counters {
+		r.types[name] = models.CounterType
+	}
+	for name := range gauges {
+		r.types[name] = models.GaugeType
+	}
+}
+
+// Reset forgets all recorded types, e.g. after the stored metrics were deleted
+func (r *TypeRegistry) Reset() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.types = make(map[string]string)
+}
+
+// Check verifies that none of metrics conflicts with the recorded type of its name,
+// nor with another metric of the same name among metrics, returning an error wrapping
+// ErrTypeConflict if one does. Nothing is recorded; call Record once the metrics are
+// stored, so that a rejected write does not pin the type of a name. Metrics of unknown
+// type are ignored.
+func (r *TypeRegistry) Check(metrics ...models.Metrics) error {
+	if r == nil {
+		return nil
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	seen := make(map[string]string, len(metrics))
+	for _, m := range metrics {
+		if !knownType(m.MType) {
+			continue
+		}
+		known, ok := seen[m.ID]
+		if !ok {
+			known, ok = r.types[m.ID]
+		}
+		if ok && known != m.MType {
+			return fmt.Errorf("%w: %s is a %s, not a %s", ErrTypeConflict, m.ID, known, m.MType)
+		}
+		seen[m.ID] = m.MType
+	}
+	return nil
+}
+
+// Record records the types of the previously unseen names among metrics, which have
+// passed Check and been stored. Names already recorded keep their first-seen type.
+func (r *TypeRegistry) Record(metrics ...models.Metrics) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, m := range metrics {
+		if _, ok := r.types[m.ID]; !ok && knownType(m.MType) {
+			r.types[m.ID] = m.MType
+		}
+	}
+}
+
+// knownType reports whether typ is a metric type the registry enforces
+func knownType(typ string) bool {
+	return typ == models.GaugeType || typ == models.CounterType || typ == models.FloatCounterType
+}
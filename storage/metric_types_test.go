@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+func TestTypeRegistryCheck(t *testing.T) {
+	r := NewTypeRegistry()
+
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "gauge"}); err != nil {
+		t.Fatalf("First write failed: %v", err)
+	}
+	r.Record(models.Metrics{ID: "Alloc", MType: "gauge"})
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "gauge"}); err != nil {
+		t.Errorf("Same type should be accepted, got %v", err)
+	}
+
+	err := r.Check(models.Metrics{ID: "Alloc", MType: "counter"})
+	if !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("Expected ErrTypeConflict, got %v", err)
+	}
+
+	// A conflict within a single batch is caught and nothing is recorded
+	err = r.Check(
+		models.Metrics{ID: "PollCount", MType: "counter"},
+		models.Metrics{ID: "PollCount", MType: "gauge"},
+	)
+	if !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("Expected ErrTypeConflict for mixed batch, got %v", err)
+	}
+	if err := r.Check(models.Metrics{ID: "PollCount", MType: "gauge"}); err != nil {
+		t.Errorf("Rejected batch should not record types, got %v", err)
+	}
+}
+
+func TestTypeRegistryRecordsOnlyStoredWrites(t *testing.T) {
+	r := NewTypeRegistry()
+
+	// A write passing the check but failing to store must not pin the type
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "counter"}); err != nil {
+		t.Fatalf("Check failed: %v", err)
+	}
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "gauge"}); err != nil {
+		t.Errorf("Unrecorded write should not pin the type, got %v", err)
+	}
+
+	// Once recorded, the first stored type wins over later records
+	r.Record(models.Metrics{ID: "Alloc", MType: "gauge"})
+	r.Record(models.Metrics{ID: "Alloc", MType: "counter"})
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "counter"}); !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("Expected ErrTypeConflict after recording a gauge, got %v", err)
+	}
+}
+
+func TestTypeRegistrySeed(t *testing.T) {
+	s := NewMemStorage()
+	s.UpdateGauge("Alloc", 1)
+	s.UpdateCounter("PollCount", 1)
+
+	r := NewTypeRegistry()
+	r.Seed(s)
+
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "counter"}); !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("Expected seeded gauge to conflict with counter, got %v", err)
+	}
+	if err := r.Check(models.Metrics{ID: "PollCount", MType: "gauge"}); !errors.Is(err, ErrTypeConflict) {
+		t.Errorf("Expected seeded counter to conflict with gauge, got %v", err)
+	}
+}
+
+func TestNilTypeRegistry(t *testing.T) {
+	var r *TypeRegistry
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "gauge"}); err != nil {
+		t.Errorf("Nil registry should accept every write, got %v", err)
+	}
+	r.Record(models.Metrics{ID: "Alloc", MType: "gauge"})
+}
+
+func TestTypeRegistryReset(t *testing.T) {
+	r := NewTypeRegistry()
+	r.Record(models.Metrics{ID: "Alloc", MType: "gauge"})
+
+	r.Reset()
+	if err := r.Check(models.Metrics{ID: "Alloc", MType: "counter"}); err != nil {
+		t.Errorf("Expected a forgotten name to accept a new type, got %v", err)
+	}
+
+	var nilRegistry *TypeRegistry
+	nilRegistry.Reset()
+}
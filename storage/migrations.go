@@ -0,0 +1,190 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/retry"
+	"github.com/rs/zerolog/log"
+)
+
+// migration is a versioned schema change. Its statements may refer to the metric
+// tables as {gauges}, {counters} and {float_counters}, which are replaced by their qualified names,
+// and to {partition_by}, which is replaced by the partitioning clause of the tables.
+type migration struct {
+	version     int
+	description string
+	statements  []string
+	// build returns statements depending on the storage options (optional)
+	build func(ds *DBStorage) []string
+}
+
+// migrations lists all schema changes in the order they are applied. Applied versions
+// are recorded in the schema_migrations table, so entries must never be edited or
+// reordered once released; add a new version instead.
+var migrations = []migration{
+	{
+		version:     1,
+		description: "create gauges and counters tables",
+		// IF NOT EXISTS adopts tables created before migrations were introduced.
+		// {partition_by} is empty unless hash partitioning is enabled.
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS {gauges} (
+				name VARCHAR(255) PRIMARY KEY,
+				value DOUBLE PRECISION NOT NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			){partition_by}`,
+			`CREATE TABLE IF NOT EXISTS {counters} (
+				name VARCHAR(255) PRIMARY KEY,
+				value BIGINT NOT NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			){partition_by}`,
+		},
+	},
+	{
+		version:     2,
+		description: "index updated_at for incremental queries",
+		statements: []string{
+			`CREATE INDEX IF NOT EXISTS gauges_updated_at_idx ON {gauges} (updated_at)`,
+			`CREATE INDEX IF NOT EXISTS counters_updated_at_idx ON {counters} (updated_at)`,
+		},
+	},
+	{
+		version:     3,
+		description: "create hash partitions of gauges and counters",
+		// Nothing to do for unpartitioned tables
+		build: partitionStatements,
+	},
+	{
+		version:     4,
+		description: "create float counters table",
+		statements: []string{
+			`CREATE TABLE IF NOT EXISTS {float_counters} (
+				name VARCHAR(255) PRIMARY KEY,
+				value DOUBLE PRECISION NOT NULL,
+				updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+			){partition_by}`,
+			`CREATE INDEX IF NOT EXISTS float_counters_updated_at_idx ON {float_counters} (updated_at)`,
+		},
+		build: floatCounterPartitionStatements,
+	},
+	{
+		version:     5,
+		description: "store the agent collection time apart from updated_at",
+		statements: []string{
+			`ALTER TABLE {gauges} ADD COLUMN IF NOT EXISTS collected_at TIMESTAMP`,
+			`ALTER TABLE {counters} ADD COLUMN IF NOT EXISTS collected_at TIMESTAMP`,
+			`ALTER TABLE {float_counters} ADD COLUMN IF NOT EXISTS collected_at TIMESTAMP`,
+		},
+	},
+}
+
+// migrate creates the schema and the schema_migrations table if needed and applies
+// every migration that has not been recorded yet. Each migration runs in its own
+// transaction together with the insert recording its version, so a failed migration
+// leaves no partial changes and is retried on the next start.
+func (ds *DBStorage) migrate(ctx context.Context) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	var queries []string
+	if ds.schema != "" {
+		queries = append(queries, "CREATE SCHEMA IF NOT EXISTS "+ds.schema)
+	}
+	queries = append(queries, `CREATE TABLE IF NOT EXISTS `+ds.migrationsTable()+` (
+			version INTEGER PRIMARY KEY,
+			description TEXT NOT NULL,
+			applied_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+		)`)
+
+	for _, query := range queries {
+		err := retry.Do(ctx, ds.retryConfig, func() error {
+			_, err := ds.db.ExecContext(ctx, query)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("failed to execute query %s: %w", query, err)
+		}
+	}
+
+	if err := ds.checkPartitioning(ctx); err != nil {
+		return err
+	}
+
+	for _, m := range migrations {
+		var applied bool
+		err := retry.Do(ctx, ds.retryConfig, func() error {
+			var err error
+			applied, err = ds.applyMigration(ctx, m)
+			return err
+		})
+		if err != nil {
+			return fmt.Errorf("migration %d (%s): %w", m.version, m.description, err)
+		}
+		if applied {
+			log.Info().Int("version", m.version).Str("description", m.description).Msg("Applied database migration")
+		}
+	}
+
+	return ds.verifyPartitions(ctx)
+}
+
+// applyMigration applies m unless its version is already recorded, reporting whether it ran
+func (ds *DBStorage) applyMigration(ctx context.Context, m migration) (bool, error) {
+	tx, err := ds.db.BeginTxx(ctx, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// Serialize concurrent server starts so each migration runs exactly once
+	if _, err := tx.ExecContext(ctx, "LOCK TABLE "+ds.migrationsTable()+" IN EXCLUSIVE MODE"); err != nil {
+		return false, fmt.Errorf("failed to lock migrations table: %w", err)
+	}
+
+	var done bool
+	err = tx.GetContext(ctx, &done, "SELECT EXISTS (SELECT 1 FROM "+ds.migrationsTable()+" WHERE version = $1)", m.version)
+	if err != nil {
+		return false, fmt.Errorf("failed to check migration version: %w", err)
+	}
+	if done {
+		return false, nil
+	}
+
+	statements := m.statements
+	if m.build != nil {
+		statements = append(statements, m.build(ds)...)
+	}
+
+	replacer := strings.NewReplacer("{gauges}", ds.gaugesTable, "{counters}", ds.countersTable,
+		"{float_counters}", ds.floatCountersTable, "{partition_by}", ds.partitionBy())
+	for _, stmt := range statements {
+		if _, err := tx.ExecContext(ctx, replacer.Replace(stmt)); err != nil {
+			return false, fmt.Errorf("failed to execute migration: %w", err)
+		}
+	}
+
+	_, err = tx.ExecContext(ctx, "INSERT INTO "+ds.migrationsTable()+" (version, description) VALUES ($1, $2)", m.version, m.description)
+	if err != nil {
+		return false, fmt.Errorf("failed to record migration: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return false, fmt.Errorf("failed to commit migration: %w", err)
+	}
+	return true, nil
+}
+
+// appliedMigrations returns the recorded migration versions in ascending order
+func (ds *DBStorage) appliedMigrations(ctx context.Context) ([]int, error) {
+	var versions []int
+	err := ds.db.SelectContext(ctx, &versions, "SELECT version FROM "+ds.migrationsTable()+" ORDER BY version")
+	return versions, err
+}
+
+// migrationsTable returns the qualified name of the table recording applied migrations
+func (ds *DBStorage) migrationsTable() string {
+	return ds.qualify("schema_migrations")
+}
@@ -0,0 +1,50 @@
+package storage
+
+import (
+	"context"
+	"testing"
+)
+
+// TestMigrationVersions checks that migration versions start at 1 and increase by one
+func TestMigrationVersions(t *testing.T) {
+	for i, m := range migrations {
+		if m.version != i+1 {
+			t.Errorf("Migration %d has version %d, want %d", i, m.version, i+1)
+		}
+		if m.description == "" || (len(m.statements) == 0 && m.build == nil) {
+			t.Errorf("Migration %d must have a description and statements", m.version)
+		}
+	}
+}
+
+// TestMigrationsIdempotent runs the migrations against an empty schema and then again.
+// It requires a PostgreSQL database given by TEST_DATABASE_DSN and is skipped otherwise.
+func TestMigrationsIdempotent(t *testing.T) {
+	ds := newTestDBStorage(t)
+
+	ctx := context.Background()
+	versions, err := ds.appliedMigrations(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read applied migrations: %v", err)
+	}
+	if len(versions) != len(migrations) {
+		t.Fatalf("Expected %d applied migrations, got %v", len(migrations), versions)
+	}
+
+	ds.UpdateGauge("Alloc", 1.5)
+
+	// A second run must neither fail nor reapply anything
+	if err := ds.migrate(context.Background()); err != nil {
+		t.Fatalf("Second migration run failed: %v", err)
+	}
+	again, err := ds.appliedMigrations(ctx)
+	if err != nil {
+		t.Fatalf("Failed to read applied migrations: %v", err)
+	}
+	if len(again) != len(versions) {
+		t.Errorf("Expected %d applied migrations after rerun, got %v", len(versions), again)
+	}
+	if v, ok := ds.GetGauge("Alloc"); !ok || v != 1.5 {
+		t.Errorf("Expected data to survive rerun, got %v %v", v, ok)
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+)
+
+// ErrNameNotAllowed is returned when a metric name violates the configured NamePolicy
+var ErrNameNotAllowed = errors.New("metric name not allowed")
+
+// NamePolicy restricts the metric names clients may write, e.g. to prevent cardinality
+// blowups or to enforce naming conventions. A name must match the allow expression, if
+// set, and must not match the deny expression, if set. Expressions are unanchored, so
+// use ^ and $ to match whole names. A nil policy accepts every name.
+type NamePolicy struct {
+	allow *regexp.Regexp
+	deny  *regexp.Regexp
+}
+
+// NewNamePolicy compiles the allow and deny expressions, either of which may be empty.
+// It returns a nil policy accepting every name if both are empty.
+func NewNamePolicy(allow, deny string) (*NamePolicy, error) {
+	if allow == "" && deny == "" {
+		return nil, nil
+	}
+
+	p := &NamePolicy{}
+	var err error
+	if allow != "" {
+		if p.allow, err = regexp.Compile(allow); err != nil {
+			return nil, fmt.Errorf("invalid metric allow regex: %w", err)
+		}
+	}
+	if deny != "" {
+		if p.deny, err = regexp.Compile(deny); err != nil {
+			return nil, fmt.Errorf("invalid metric deny regex: %w", err)
+		}
+	}
+	return p, nil
+}
+
+// Check returns an error wrapping ErrNameNotAllowed if name violates the policy
+func (p *NamePolicy) Check(name string) error {
+	if p == nil {
+		return nil
+	}
+	if p.allow != nil && !p.allow.MatchString(name) {
+		return fmt.Errorf("%w: %s does not match %s", ErrNameNotAllowed, name, p.allow)
+	}
+	if p.deny != nil && p.deny.MatchString(name) {
+		return fmt.Errorf("%w: %s matches the denied pattern %s", ErrNameNotAllowed, name, p.deny)
+	}
+	return nil
+}
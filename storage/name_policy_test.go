@@ -0,0 +1,63 @@
+package storage
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestNamePolicyCheck(t *testing.T) {
+	policy, err := NewNamePolicy(`^[a-z][a-z0-9_]*$`, `^debug_`)
+	if err != nil {
+		t.Fatalf("NewNamePolicy failed: %v", err)
+	}
+
+	tests := []struct {
+		name        string
+		errContains string // empty for an allowed name
+	}{
+		{"http_requests_total", ""},
+		{"alloc", ""},
+		{"Alloc", "does not match"},
+		{"user-id-1234", "does not match"},
+		{"debug_trace", "matches the denied pattern"},
+	}
+	for _, tt := range tests {
+		err := policy.Check(tt.name)
+		if tt.errContains == "" {
+			if err != nil {
+				t.Errorf("Expected %s to be allowed, got %v", tt.name, err)
+			}
+			continue
+		}
+		if !errors.Is(err, ErrNameNotAllowed) || !strings.Contains(err.Error(), tt.errContains) {
+			t.Errorf("Expected %s to be rejected with %q, got %v", tt.name, tt.errContains, err)
+		}
+	}
+}
+
+func TestNewNamePolicy(t *testing.T) {
+	policy, err := NewNamePolicy("", "")
+	if err != nil || policy != nil {
+		t.Fatalf("Expected no policy without expressions, got %v %v", policy, err)
+	}
+	// A nil policy accepts every name
+	if err := policy.Check("Anything Goes"); err != nil {
+		t.Errorf("Expected nil policy to accept names, got %v", err)
+	}
+
+	denyOnly, err := NewNamePolicy("", "tmp")
+	if err != nil {
+		t.Fatalf("NewNamePolicy failed: %v", err)
+	}
+	if denyOnly.Check("Alloc") != nil || denyOnly.Check("tmp_value") == nil {
+		t.Error("Expected a deny-only policy to reject only matching names")
+	}
+
+	if _, err := NewNamePolicy("[a-z", ""); err == nil || !strings.Contains(err.Error(), "allow regex") {
+		t.Errorf("Expected invalid allow regex error, got %v", err)
+	}
+	if _, err := NewNamePolicy("", "(unclosed"); err == nil || !strings.Contains(err.Error(), "deny regex") {
+		t.Errorf("Expected invalid deny regex error, got %v", err)
+	}
+}
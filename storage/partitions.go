@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+)
+
+// MaxHashPartitions is the largest accepted number of hash partitions per table
+const MaxHashPartitions = 1024
+
+// WithHashPartitions creates the metric tables with Postgres declarative
+// partitioning by a hash of the metric name, split into n partitions named e.g.
+// counters_p0 .. counters_p<n-1>. Rows of hot metrics then lock within different
+// partitions, spreading write contention. Queries address the parent tables and work
+// unchanged. Partitioning only applies when the tables are created: an existing
+// unpartitioned database is rejected, as is a partition count differing from the one
+// the tables were created with. A count of 0 keeps plain tables.
+func WithHashPartitions(n int) DBStorageOption {
+	return func(ds *DBStorage) {
+		ds.partitions = n
+	}
+}
+
+// validatePartitions returns an error if n cannot be used as the number of hash partitions
+func validatePartitions(n int) error {
+	if n < 0 || n > MaxHashPartitions {
+		return fmt.Errorf("invalid number of hash partitions %d: must be between 0 and %d", n, MaxHashPartitions)
+	}
+	return nil
+}
+
+// partitionBy returns the partitioning clause appended to CREATE TABLE of the metric tables
+func (ds *DBStorage) partitionBy() string {
+	if ds.partitions == 0 {
+		return ""
+	}
+	return " PARTITION BY HASH (name)"
+}
+
+// metricTables returns the qualified names of all metric tables
+func (ds *DBStorage) metricTables() []string {
+	return []string{ds.gaugesTable, ds.countersTable, ds.floatCountersTable}
+}
+
+// partitionTable returns the qualified name of the i-th partition of table
+func partitionTable(table string, i int) string {
+	return fmt.Sprintf("%s_p%d", table, i)
+}
+
+// partitionStatements returns the statements creating the hash partitions of the gauges
+// and counters tables
+func partitionStatements(ds *DBStorage) []string {
+	return tablePartitionStatements(ds, ds.gaugesTable, ds.countersTable)
+}
+
+// floatCounterPartitionStatements returns the statements creating the hash partitions of
+// the float counters table
+func floatCounterPartitionStatements(ds *DBStorage) []string {
+	return tablePartitionStatements(ds, ds.floatCountersTable)
+}
+
+// tablePartitionStatements returns the statements creating the hash partitions of tables
+func tablePartitionStatements(ds *DBStorage, tables ...string) []string {
+	var statements []string
+	for _, table := range tables {
+		for i := 0; i < ds.partitions; i++ {
+			statements = append(statements, fmt.Sprintf(
+				"CREATE TABLE IF NOT EXISTS %s PARTITION OF %s FOR VALUES WITH (MODULUS %d, REMAINDER %d)",
+				partitionTable(table, i), table, ds.partitions, i))
+		}
+	}
+	return statements
+}
+
+// checkPartitioning rejects enabling partitioning for metric tables that already exist
+// unpartitioned, since Postgres cannot partition an existing table in place
+func (ds *DBStorage) checkPartitioning(ctx context.Context) error {
+	if ds.partitions == 0 {
+		return nil
+	}
+
+	for _, table := range ds.metricTables() {
+		// The scalar subquery yields one NULL row for a table that does not exist yet
+		var kind *string
+		err := ds.db.GetContext(ctx, &kind, "SELECT (SELECT relkind::text FROM pg_class WHERE oid = to_regclass($1))", table)
+		if err != nil {
+			return fmt.Errorf("failed to inspect table %s: %w", table, err)
+		}
+		if kind != nil && *kind != "p" {
+			return fmt.Errorf("table %s already exists without partitioning; hash partitions can only be enabled for a new database or schema", table)
+		}
+	}
+	return nil
+}
+
+// verifyPartitions checks that partitioned metric tables have the configured number of partitions
+func (ds *DBStorage) verifyPartitions(ctx context.Context) error {
+	if ds.partitions == 0 {
+		return nil
+	}
+
+	for _, table := range ds.metricTables() {
+		var count int
+		err := ds.db.GetContext(ctx, &count, "SELECT count(*) FROM pg_inherits WHERE inhparent = to_regclass($1)", table)
+		if err != nil {
+			return fmt.Errorf("failed to count partitions of %s: %w", table, err)
+		}
+		if count != ds.partitions {
+			return fmt.Errorf("table %s has %d partitions, but %d are configured; the partition count cannot be changed", table, count, ds.partitions)
+		}
+	}
+	return nil
+}
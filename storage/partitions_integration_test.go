@@ -0,0 +1,109 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+// TestHashPartitionedStorage requires a PostgreSQL database given by TEST_DATABASE_DSN
+func TestHashPartitionedStorage(t *testing.T) {
+	const partitions = 4
+	ds := newTestDBStorage(t, WithHashPartitions(partitions))
+	ctx := context.Background()
+
+	start := time.Now().Add(-time.Minute)
+	for i := 0; i < 40; i++ {
+		ds.UpdateCounter(fmt.Sprintf("counter_%d", i), int64(i))
+		ds.UpdateCounter(fmt.Sprintf("counter_%d", i), 1) // upsert across the partition
+		ds.UpdateGauge(fmt.Sprintf("gauge_%d", i), float64(i))
+	}
+	delta := int64(5)
+	if err := ds.UpdateBatch([]models.Metrics{{ID: "counter_0", MType: "counter", Delta: &delta}}); err != nil {
+		t.Fatalf("Batch update failed: %v", err)
+	}
+
+	// Every row lands in the partition its name hashes to, and the rows are spread out
+	for _, table := range []string{ds.gaugesTable, ds.countersTable} {
+		used := 0
+		total := 0
+		for i := 0; i < partitions; i++ {
+			var rows, misplaced int
+			partition := partitionTable(table, i)
+			query := fmt.Sprintf(`SELECT count(*), count(*) FILTER (WHERE NOT satisfies_hash_partition($1::regclass, %d, %d, name))
+				FROM %s`, partitions, i, partition)
+			if err := ds.db.QueryRowContext(ctx, query, table).Scan(&rows, &misplaced); err != nil {
+				t.Fatalf("Failed to inspect %s: %v", partition, err)
+			}
+			if misplaced != 0 {
+				t.Errorf("%s holds %d rows of other partitions", partition, misplaced)
+			}
+			if rows > 0 {
+				used++
+			}
+			total += rows
+		}
+		if total != 40 {
+			t.Errorf("Expected 40 rows in the partitions of %s, got %d", table, total)
+		}
+		if used < 2 {
+			t.Errorf("Expected rows of %s spread over several partitions, got %d", table, used)
+		}
+	}
+
+	// Reads through the parent tables aggregate all partitions
+	gauges, counters := ds.GetAll()
+	if len(gauges) != 40 || len(counters) != 40 {
+		t.Errorf("Expected 40 gauges and counters, got %d and %d", len(gauges), len(counters))
+	}
+	if v, ok := ds.GetCounter("counter_7"); !ok || v != 8 {
+		t.Errorf("Expected counter_7 = 8, got %d", v)
+	}
+	if v, ok := ds.GetCounter("counter_0"); !ok || v != 6 {
+		t.Errorf("Expected counter_0 = 6, got %d", v)
+	}
+	updated, err := ds.GetUpdatedSince(start)
+	if err != nil || len(updated) != 80 {
+		t.Errorf("Expected 80 recently updated metrics, got %d (%v)", len(updated), err)
+	}
+
+	// Reopening with the same count succeeds, a different count is rejected
+	if err := ds.migrate(context.Background()); err != nil {
+		t.Errorf("Rerunning migrations failed: %v", err)
+	}
+	ds.partitions = partitions * 2
+	if err := ds.migrate(context.Background()); err == nil {
+		t.Error("Expected a changed partition count to be rejected")
+	}
+}
+
+// TestHashPartitionsOnFreshSchema requires a PostgreSQL database given by TEST_DATABASE_DSN
+func TestHashPartitionsOnFreshSchema(t *testing.T) {
+	ds := newTestDBStorage(t, WithHashPartitions(2))
+
+	// None of the tables existed before, so the pre-check must pass and create them partitioned
+	for _, table := range ds.metricTables() {
+		var kind string
+		if err := ds.db.Get(&kind, "SELECT relkind::text FROM pg_class WHERE oid = to_regclass($1)", table); err != nil {
+			t.Fatalf("Failed to inspect %s: %v", table, err)
+		}
+		if kind != "p" {
+			t.Errorf("Expected %s to be partitioned, got relkind %q", table, kind)
+		}
+	}
+}
+
+// TestHashPartitionsRejectedForPlainTables requires a PostgreSQL database given by TEST_DATABASE_DSN
+func TestHashPartitionsRejectedForPlainTables(t *testing.T) {
+	ds := newTestDBStorage(t)
+
+	ds.partitions = 4
+	if err := ds.migrate(context.Background()); err == nil {
+		t.Error("Expected partitioning of existing plain tables to be rejected")
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"math/rand/v2"
+	"sync"
+	"time"
+)
+
+// counterShard is one partition of a sharded counter set.
+// It is padded to a cache line so that neighbouring shards do not share one.
+type counterShard struct {
+	mu       sync.Mutex
+	counters map[string]int64
+	updated  map[string]time.Time // when each counter was last added to on this shard
+	_        [40]byte
+}
+
+// shardedCounters spreads counter increments over several independently locked
+// shards. Each increment lands on a random shard, so concurrent updates of the
+// same hot counter rarely contend; reads sum the value over all shards.
+type shardedCounters struct {
+	shards []counterShard
+}
+
+// newShardedCounters creates a counter set with n shards
+func newShardedCounters(n int) *shardedCounters {
+	sc := &shardedCounters{shards: make([]counterShard, n)}
+	for i := range sc.shards {
+		sc.shards[i].counters = make(map[string]int64)
+		sc.shards[i].updated = make(map[string]time.Time)
+	}
+	return sc
+}
+
+// add adds delta to the named counter
+func (sc *shardedCounters) add(name string, delta int64) {
+	now := time.Now()
+	shard := &sc.shards[rand.IntN(len(sc.shards))]
+	shard.mu.Lock()
+	shard.counters[name] += delta
+	shard.updated[name] = now
+	shard.mu.Unlock()
+}
+
+// lastUpdated returns the latest time the named counter was written on any shard
+func (sc *shardedCounters) lastUpdated(name string) (time.Time, bool) {
+	var latest time.Time
+	var found bool
+	for i := range sc.shards {
+		shard := &sc.shards[i]
+		shard.mu.Lock()
+		if t, ok := shard.updated[name]; ok {
+			if t.After(latest) {
+				latest = t
+			}
+			found = true
+		}
+		shard.mu.Unlock()
+	}
+	return latest, found
+}
+
+// get returns the value of the named counter summed over all shards
+func (sc *shardedCounters) get(name string) (int64, bool) {
+	var total int64
+	var found bool
+	for i := range sc.shards {
+		shard := &sc.shards[i]
+		shard.mu.Lock()
+		if v, ok := shard.counters[name]; ok {
+			total += v
+			found = true
+		}
+		shard.mu.Unlock()
+	}
+	return total, found
+}
+
+// snapshot returns the values of all counters summed over all shards
+func (sc *shardedCounters) snapshot() map[string]int64 {
+	result := make(map[string]int64)
+	for i := range sc.shards {
+		shard := &sc.shards[i]
+		shard.mu.Lock()
+		for name, v := range shard.counters {
+			result[name] += v
+		}
+		shard.mu.Unlock()
+	}
+	return result
+}
+
+// set replaces the value of the named counter
+func (sc *shardedCounters) set(name string, value int64) {
+	sc.lockAll()
+	defer sc.unlockAll()
+
+	for i := range sc.shards {
+		delete(sc.shards[i].counters, name)
+		delete(sc.shards[i].updated, name)
+	}
+	sc.shards[0].counters[name] = value
+	sc.shards[0].updated[name] = time.Now()
+}
+
+// reset replaces all counters with the given values, written at the given time
+func (sc *shardedCounters) reset(counters map[string]int64, at time.Time) {
+	sc.lockAll()
+	defer sc.unlockAll()
+
+	for i := range sc.shards {
+		sc.shards[i].counters = make(map[string]int64)
+		sc.shards[i].updated = make(map[string]time.Time)
+	}
+	for name, v := range counters {
+		sc.shards[0].counters[name] = v
+		sc.shards[0].updated[name] = at
+	}
+}
+
+// addAll adds all deltas at the given time while holding every shard lock, so readers
+// see either none or all of them
+func (sc *shardedCounters) addAll(deltas map[string]int64, at time.Time) {
+	sc.lockAll()
+	defer sc.unlockAll()
+
+	for name, delta := range deltas {
+		sc.shards[0].counters[name] += delta
+		sc.shards[0].updated[name] = at
+	}
+}
+
+// lockAll locks every shard, always in the same order
+func (sc *shardedCounters) lockAll() {
+	for i := range sc.shards {
+		sc.shards[i].mu.Lock()
+	}
+}
+
+// unlockAll unlocks every shard
+func (sc *shardedCounters) unlockAll() {
+	for i := range sc.shards {
+		sc.shards[i].mu.Unlock()
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"path/filepath"
+	"sync"
+	"testing"
+)
+
+func TestShardedCounters_ConcurrentSum(t *testing.T) {
+	ms := NewMemStorage(WithShardedCounters(8))
+
+	const goroutines = 16
+	const increments = 1000
+
+	var wg sync.WaitGroup
+	for i := 0; i < goroutines; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < increments; j++ {
+				ms.UpdateCounter("hot", 1)
+				ms.UpdateCounter("cold", 2)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if v, ok := ms.GetCounter("hot"); !ok || v != goroutines*increments {
+		t.Errorf("Expected hot = %d, got %d (found: %v)", goroutines*increments, v, ok)
+	}
+
+	_, counters := ms.GetAll()
+	if counters["cold"] != 2*goroutines*increments {
+		t.Errorf("Expected cold = %d, got %d", 2*goroutines*increments, counters["cold"])
+	}
+
+	if _, ok := ms.GetCounter("missing"); ok {
+		t.Error("Expected missing counter not to be found")
+	}
+}
+
+func TestShardedCounters_LoadSnapshot(t *testing.T) {
+	ms := NewMemStorage(WithShardedCounters(0))
+	for i := 0; i < 100; i++ {
+		ms.UpdateCounter("requests", 1)
+	}
+
+	ms.LoadSnapshot(map[string]float64{"temp": 1.5}, map[string]int64{"requests": 7}, nil)
+
+	if v, _ := ms.GetCounter("requests"); v != 7 {
+		t.Errorf("Expected requests = 7 after snapshot, got %d", v)
+	}
+
+	ms.UpdateCounter("requests", 3)
+	if v, _ := ms.GetCounter("requests"); v != 10 {
+		t.Errorf("Expected requests = 10, got %d", v)
+	}
+}
+
+func TestShardedCounters_FileRestore(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "metrics.json")
+	original := NewMemStorage()
+	original.UpdateCounter("requests", 42)
+	fm := NewFileManager(filePath, original)
+	if err := fm.SaveToFile(); err != nil {
+		t.Fatalf("Failed to save: %v", err)
+	}
+
+	restored := NewMemStorage(WithShardedCounters(4))
+	for i := 0; i < 10; i++ {
+		restored.UpdateCounter("requests", 1)
+	}
+	if err := fm.LoadFromFile(restored); err != nil {
+		t.Fatalf("Failed to load: %v", err)
+	}
+
+	if v, _ := restored.GetCounter("requests"); v != 42 {
+		t.Errorf("Expected restored requests = 42, got %d", v)
+	}
+}
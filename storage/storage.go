@@ -1,7 +1,18 @@
 // storage/storage.go
 package storage
 
-import "sync"
+import (
+	"context"
+	"errors"
+	"fmt"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+	"github.com/rs/zerolog/log"
+)
 
 // Storage defines the interface for metrics storage operations.
 // It supports both gauge (floating-point) and counter (integer) metrics.
@@ -22,6 +33,69 @@ type Storage interface {
 	GetAll() (map[string]float64, map[string]int64)
 }
 
+// BatchUpdater is implemented by storages that apply a batch of metrics atomically:
+// either every metric of the batch is applied or, if UpdateBatch returns an error, none is.
+type BatchUpdater interface {
+	UpdateBatch(metrics []models.Metrics) error
+}
+
+// Versioned is implemented by storages that count their modifications, so that data
+// derived from the stored metrics can be cached until the version changes
+type Versioned interface {
+	// Version returns a number that changes whenever a metric is written
+	Version() uint64
+}
+
+// Timestamped is implemented by storages that record when each metric was last written
+type Timestamped interface {
+	// LastUpdated returns when the metric of type mtype was last written, and false if
+	// the metric is not stored
+	LastUpdated(mtype, name string) (time.Time, bool)
+}
+
+// Resetter is implemented by storages that can delete all stored metrics at once
+type Resetter interface {
+	// Reset deletes all gauges and counters
+	Reset() error
+}
+
+// Streamer is implemented by storages that can list all metrics without loading them
+// into memory at once
+type Streamer interface {
+	// StreamAll calls fn with every metric, the gauges, the counters and then the float
+	// counters, each sorted by name; counters hold their total value in Delta and float
+	// counters in Value. It stops at the first error returned by fn and returns it.
+	StreamAll(ctx context.Context, fn func(models.Metrics) error) error
+}
+
+// FloatCounters is implemented by storages that keep float counters, metrics of
+// models.FloatCounterType accumulating float64 deltas. They are stored apart from the
+// integer counters, so a float counter and a counter of the same name are distinct.
+type FloatCounters interface {
+	// UpdateFloatCounter adds the delta value to a float counter
+	UpdateFloatCounter(name string, value float64)
+
+	// GetFloatCounter retrieves a float counter total. Returns value and true if found, false otherwise.
+	GetFloatCounter(name string) (float64, bool)
+
+	// GetAllFloatCounters returns the totals of all float counters
+	GetAllFloatCounters() map[string]float64
+}
+
+// CapacityChecker is implemented by storages that cap the number of distinct metrics
+type CapacityChecker interface {
+	// CheckCapacity returns an error wrapping ErrTooManyMetrics if storing metrics would
+	// add more new metrics than the storage has room for
+	CheckCapacity(metrics ...models.Metrics) error
+}
+
+// ErrTooManyMetrics is returned when storing a new metric would exceed the cap on distinct metrics
+var ErrTooManyMetrics = errors.New("too many distinct metrics")
+
+// ErrInvalidMetric is returned by MemStorage.UpdateBatch for a malformed metric in the batch,
+// see models.Metrics.Validate
+var ErrInvalidMetric = models.ErrInvalidMetric
+
 // MemStorage is an in-memory implementation of the Storage interface.
 // It stores metrics in memory with optional file persistence support.
 // All operations are thread-safe using read-write mutexes.
@@ -31,15 +105,74 @@ type MemStorage struct {
 	mu          sync.RWMutex
 	fileManager *FileManager
 	syncSave    bool
+
+	// version counts writes, see Version
+	version atomic.Uint64
+
+	// gaugesUpdated and countersUpdated record when each metric was last written, see
+	// LastUpdated. Sharded counters keep their timestamps in the shards instead.
+	gaugesUpdated   map[string]time.Time
+	countersUpdated map[string]time.Time
+
+	// floatCounters holds the float counters, which are never sharded
+	floatCounters        map[string]float64
+	floatCountersUpdated map[string]time.Time
+
+	// sharded holds the counters instead of the counters map when sharding is enabled
+	sharded *shardedCounters
+
+	// maxMetrics caps the number of distinct gauges plus counters (0 disables the cap),
+	// see WithMaxMetrics. shardedCount counts the sharded counters while it is enabled,
+	// and capacityWarned is when dropping a metric over the cap was last logged.
+	maxMetrics     int
+	shardedCount   int
+	capacityWarned time.Time
+}
+
+// MemStorageOption configures a MemStorage created by NewMemStorage
+type MemStorageOption func(*MemStorage)
+
+// WithShardedCounters spreads counters over the given number of independently
+// locked shards, which reduces lock contention when many goroutines increment
+// the same counters. Reads sum over all shards and are therefore slower.
+// A shard count of 0 or less uses GOMAXPROCS.
+func WithShardedCounters(shards int) MemStorageOption {
+	return func(ms *MemStorage) {
+		if shards <= 0 {
+			shards = runtime.GOMAXPROCS(0)
+		}
+		ms.sharded = newShardedCounters(shards)
+	}
+}
+
+// WithMaxMetrics caps the number of distinct gauges, counters and float counters at n, so that a
+// client sending ever new metric names cannot exhaust the server's memory. Once the
+// cap is reached, writes creating a new metric are dropped with a logged warning and
+// UpdateBatch returns ErrTooManyMetrics, while stored metrics can still be updated.
+// A gauge and a counter of the same name count as two metrics. Sharded counters are
+// updated under the storage-wide lock while the cap is enabled. 0 or less disables it.
+func WithMaxMetrics(n int) MemStorageOption {
+	return func(ms *MemStorage) {
+		ms.maxMetrics = max(n, 0)
+	}
 }
 
 // NewMemStorage creates a new in-memory storage instance.
 // Maps are pre-allocated with capacity of 50 for better performance.
-func NewMemStorage() *MemStorage {
-	return &MemStorage{
-		gauges:   make(map[string]float64, 50), // Pre-allocate capacity for better performance
-		counters: make(map[string]int64, 50),   // Pre-allocate capacity for better performance
+func NewMemStorage(opts ...MemStorageOption) *MemStorage {
+	ms := &MemStorage{
+		gauges:          make(map[string]float64, 50), // Pre-allocate capacity for better performance
+		counters:        make(map[string]int64, 50),   // Pre-allocate capacity for better performance
+		gaugesUpdated:   make(map[string]time.Time, 50),
+		countersUpdated: make(map[string]time.Time, 50),
+
+		floatCounters:        make(map[string]float64),
+		floatCountersUpdated: make(map[string]time.Time),
+	}
+	for _, opt := range opts {
+		opt(ms)
 	}
+	return ms
 }
 
 // SetFileManager sets the file manager for this storage
@@ -48,9 +181,25 @@ func (ms *MemStorage) SetFileManager(fm *FileManager, syncSave bool) {
 	ms.syncSave = syncSave
 }
 
+// CheckFileWritable verifies that the configured storage file can be written.
+// It returns nil when no file persistence is configured.
+func (ms *MemStorage) CheckFileWritable() error {
+	if ms.fileManager == nil {
+		return nil
+	}
+	return ms.fileManager.CheckWritable()
+}
+
 func (ms *MemStorage) UpdateGauge(name string, value float64) {
+	now := time.Now()
 	ms.mu.Lock()
+	if !ms.admitLocked(models.GaugeType, name) {
+		ms.mu.Unlock()
+		return
+	}
 	ms.gauges[name] = value
+	ms.gaugesUpdated[name] = now
+	ms.version.Add(1)
 
 	// Save synchronously if configured
 	if ms.syncSave && ms.fileManager != nil {
@@ -61,8 +210,20 @@ func (ms *MemStorage) UpdateGauge(name string, value float64) {
 }
 
 func (ms *MemStorage) UpdateCounter(name string, value int64) {
+	if ms.sharded != nil && ms.maxMetrics == 0 && !(ms.syncSave && ms.fileManager != nil) {
+		// Shards have their own locks, so the storage-wide lock is not needed
+		ms.sharded.add(name, value)
+		ms.version.Add(1)
+		return
+	}
+
 	ms.mu.Lock()
-	ms.counters[name] += value
+	if !ms.admitLocked(models.CounterType, name) {
+		ms.mu.Unlock()
+		return
+	}
+	ms.addCounterInternal(name, value)
+	ms.version.Add(1)
 
 	// Save synchronously if configured
 	if ms.syncSave && ms.fileManager != nil {
@@ -72,6 +233,41 @@ func (ms *MemStorage) UpdateCounter(name string, value int64) {
 	ms.mu.Unlock()
 }
 
+// UpdateFloatCounter adds value to the float counter name
+func (ms *MemStorage) UpdateFloatCounter(name string, value float64) {
+	now := time.Now()
+	ms.mu.Lock()
+	if !ms.admitLocked(models.FloatCounterType, name) {
+		ms.mu.Unlock()
+		return
+	}
+	ms.floatCounters[name] += value
+	ms.floatCountersUpdated[name] = now
+	ms.version.Add(1)
+
+	// Save synchronously if configured
+	if ms.syncSave && ms.fileManager != nil {
+		// Use internal method to avoid deadlock
+		ms.saveToFileInternal()
+	}
+	ms.mu.Unlock()
+}
+
+// GetFloatCounter returns the total of the float counter name
+func (ms *MemStorage) GetFloatCounter(name string) (float64, bool) {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	val, ok := ms.floatCounters[name]
+	return val, ok
+}
+
+// GetAllFloatCounters returns a copy of all float counter totals
+func (ms *MemStorage) GetAllFloatCounters() map[string]float64 {
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.getFloatCountersInternal()
+}
+
 func (ms *MemStorage) GetGauge(name string) (float64, bool) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
@@ -80,6 +276,10 @@ func (ms *MemStorage) GetGauge(name string) (float64, bool) {
 }
 
 func (ms *MemStorage) GetCounter(name string) (int64, bool) {
+	if ms.sharded != nil {
+		return ms.sharded.get(name)
+	}
+
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 	val, ok := ms.counters[name]
@@ -90,17 +290,237 @@ func (ms *MemStorage) GetAll() (map[string]float64, map[string]int64) {
 	ms.mu.RLock()
 	defer ms.mu.RUnlock()
 
-	// Pre-allocate maps with known capacity to avoid map growth
-	gCopy := make(map[string]float64, len(ms.gauges))
-	cCopy := make(map[string]int64, len(ms.counters))
+	return ms.getAllInternal()
+}
 
-	for k, v := range ms.gauges {
-		gCopy[k] = v
+// UpdateBatch validates all metrics and then applies them under a single lock, so a
+// malformed metric anywhere in the batch leaves the storage unchanged. Gauges are set
+// and counters and float counters are incremented in batch order. Errors wrap ErrInvalidMetric, or
+// ErrTooManyMetrics if the batch would exceed the cap set by WithMaxMetrics.
+func (ms *MemStorage) UpdateBatch(metrics []models.Metrics) error {
+	for _, metric := range metrics {
+		if err := metric.Validate(); err != nil {
+			return err
+		}
 	}
-	for k, v := range ms.counters {
-		cCopy[k] = v
+
+	now := time.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if err := ms.checkCapacityLocked(metrics); err != nil {
+		ms.warnCapacityLocked(err)
+		return err
 	}
-	return gCopy, cCopy
+
+	deltas := make(map[string]int64)
+	for _, metric := range metrics {
+		switch metric.MType {
+		case models.GaugeType:
+			ms.gauges[metric.ID] = *metric.Value
+			ms.gaugesUpdated[metric.ID] = now
+		case models.CounterType:
+			deltas[metric.ID] += *metric.Delta
+		case models.FloatCounterType:
+			ms.floatCounters[metric.ID] += *metric.Value
+			ms.floatCountersUpdated[metric.ID] = now
+		}
+	}
+
+	if ms.sharded != nil {
+		if ms.maxMetrics > 0 {
+			for name := range deltas {
+				if _, ok := ms.sharded.get(name); !ok {
+					ms.shardedCount++
+				}
+			}
+		}
+		ms.sharded.addAll(deltas, now)
+	} else {
+		for name, delta := range deltas {
+			ms.counters[name] += delta
+			ms.countersUpdated[name] = now
+		}
+	}
+	ms.version.Add(1)
+
+	if ms.syncSave && ms.fileManager != nil {
+		ms.saveToFileInternal()
+	}
+	return nil
+}
+
+// LoadSnapshot replaces the stored state with the given gauges, counters and float
+// counters. Counter values are set as-is rather than accumulated, which makes it
+// suitable for restoring state pulled from another server.
+func (ms *MemStorage) LoadSnapshot(gauges map[string]float64, counters map[string]int64, floatCounters map[string]float64) {
+	now := time.Now()
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.gauges = make(map[string]float64, len(gauges))
+	ms.gaugesUpdated = make(map[string]time.Time, len(gauges))
+	for k, v := range gauges {
+		ms.gauges[k] = v
+		ms.gaugesUpdated[k] = now
+	}
+	if ms.sharded != nil {
+		ms.sharded.reset(counters, now)
+		ms.shardedCount = len(counters)
+	} else {
+		ms.counters = make(map[string]int64, len(counters))
+		ms.countersUpdated = make(map[string]time.Time, len(counters))
+		for k, v := range counters {
+			ms.counters[k] = v
+			ms.countersUpdated[k] = now
+		}
+	}
+	ms.floatCounters = make(map[string]float64, len(floatCounters))
+	ms.floatCountersUpdated = make(map[string]time.Time, len(floatCounters))
+	for k, v := range floatCounters {
+		ms.floatCounters[k] = v
+		ms.floatCountersUpdated[k] = now
+	}
+	ms.version.Add(1)
+
+	if ms.syncSave && ms.fileManager != nil {
+		ms.saveToFileInternal()
+	}
+}
+
+// Reset deletes all gauges, counters and float counters. With synchronous file
+// persistence the emptied state is saved right away.
+func (ms *MemStorage) Reset() error {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	ms.gauges = make(map[string]float64, 50)
+	ms.gaugesUpdated = make(map[string]time.Time, 50)
+	ms.floatCounters = make(map[string]float64)
+	ms.floatCountersUpdated = make(map[string]time.Time)
+	if ms.sharded != nil {
+		ms.sharded.reset(nil, time.Time{})
+		ms.shardedCount = 0
+	} else {
+		ms.counters = make(map[string]int64, 50)
+		ms.countersUpdated = make(map[string]time.Time, 50)
+	}
+	ms.version.Add(1)
+
+	if ms.syncSave && ms.fileManager != nil {
+		ms.saveToFileInternal()
+	}
+	return nil
+}
+
+// CheckCapacity returns an error wrapping ErrTooManyMetrics if storing metrics would
+// exceed the cap set by WithMaxMetrics. Since other writes may happen in between, a
+// following update can still be dropped.
+func (ms *MemStorage) CheckCapacity(metrics ...models.Metrics) error {
+	if ms.maxMetrics == 0 {
+		return nil
+	}
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	return ms.checkCapacityLocked(metrics)
+}
+
+// checkCapacityLocked returns an error if storing metrics would exceed the cap on
+// distinct metrics; the caller must hold ms.mu
+func (ms *MemStorage) checkCapacityLocked(metrics []models.Metrics) error {
+	if ms.maxMetrics == 0 {
+		return nil
+	}
+
+	added := make(map[[2]string]struct{})
+	for _, m := range metrics {
+		if !ms.hasMetricLocked(m.MType, m.ID) {
+			added[[2]string{m.MType, m.ID}] = struct{}{}
+		}
+	}
+	if len(added) > 0 && ms.countLocked()+len(added) > ms.maxMetrics {
+		return fmt.Errorf("%w: storing %d new metrics would exceed the limit of %d", ErrTooManyMetrics, len(added), ms.maxMetrics)
+	}
+	return nil
+}
+
+// admitLocked reports whether a metric may be written: stored metrics always may, new
+// ones only while the cap set by WithMaxMetrics is not reached. The caller must hold
+// ms.mu for writing and store the metric if it is admitted.
+func (ms *MemStorage) admitLocked(mtype, name string) bool {
+	if ms.maxMetrics == 0 || ms.hasMetricLocked(mtype, name) {
+		return true
+	}
+	if ms.countLocked() >= ms.maxMetrics {
+		ms.warnCapacityLocked(fmt.Errorf("%w: dropping new %s %s, the limit is %d", ErrTooManyMetrics, mtype, name, ms.maxMetrics))
+		return false
+	}
+	if mtype == models.CounterType && ms.sharded != nil {
+		ms.shardedCount++
+	}
+	return true
+}
+
+// hasMetricLocked reports whether a metric is stored; the caller must hold ms.mu
+func (ms *MemStorage) hasMetricLocked(mtype, name string) bool {
+	var ok bool
+	switch {
+	case mtype == models.GaugeType:
+		_, ok = ms.gauges[name]
+	case mtype == models.FloatCounterType:
+		_, ok = ms.floatCounters[name]
+	case ms.sharded != nil:
+		_, ok = ms.sharded.get(name)
+	default:
+		_, ok = ms.counters[name]
+	}
+	return ok
+}
+
+// countLocked returns the number of stored metrics; the caller must hold ms.mu
+func (ms *MemStorage) countLocked() int {
+	if ms.sharded != nil {
+		return len(ms.gauges) + len(ms.floatCounters) + ms.shardedCount
+	}
+	return len(ms.gauges) + len(ms.floatCounters) + len(ms.counters)
+}
+
+// warnCapacityLocked logs a metric dropped over the cap, at most once a minute so that
+// a flood of new names does not flood the log as well; the caller must hold ms.mu
+func (ms *MemStorage) warnCapacityLocked(err error) {
+	now := time.Now()
+	if now.Sub(ms.capacityWarned) < time.Minute {
+		return
+	}
+	ms.capacityWarned = now
+	log.Warn().Err(err).Msg("Metric limit reached, new metrics are rejected")
+}
+
+// LastUpdated returns when the metric was last written to this storage. Metrics
+// restored by LoadSnapshot count as written at the time they were loaded.
+func (ms *MemStorage) LastUpdated(mtype, name string) (time.Time, bool) {
+	if mtype == models.CounterType && ms.sharded != nil {
+		return ms.sharded.lastUpdated(name)
+	}
+
+	ms.mu.RLock()
+	defer ms.mu.RUnlock()
+	var t time.Time
+	var ok bool
+	switch mtype {
+	case models.GaugeType:
+		t, ok = ms.gaugesUpdated[name]
+	case models.CounterType:
+		t, ok = ms.countersUpdated[name]
+	case models.FloatCounterType:
+		t, ok = ms.floatCountersUpdated[name]
+	}
+	return t, ok
+}
+
+// Version returns the number of writes to the storage so far
+func (ms *MemStorage) Version() uint64 {
+	return ms.version.Load()
 }
 
 // getAllInternal returns copies of all metrics without acquiring locks
@@ -108,23 +528,76 @@ func (ms *MemStorage) GetAll() (map[string]float64, map[string]int64) {
 func (ms *MemStorage) getAllInternal() (map[string]float64, map[string]int64) {
 	// Pre-allocate maps with known capacity to avoid map growth
 	gCopy := make(map[string]float64, len(ms.gauges))
-	cCopy := make(map[string]int64, len(ms.counters))
-
 	for k, v := range ms.gauges {
 		gCopy[k] = v
 	}
+
+	if ms.sharded != nil {
+		return gCopy, ms.sharded.snapshot()
+	}
+
+	cCopy := make(map[string]int64, len(ms.counters))
 	for k, v := range ms.counters {
 		cCopy[k] = v
 	}
 	return gCopy, cCopy
 }
 
+// addCounterInternal adds to a counter without acquiring the storage lock
+func (ms *MemStorage) addCounterInternal(name string, value int64) {
+	if ms.sharded != nil {
+		ms.sharded.add(name, value)
+		return
+	}
+	ms.counters[name] += value
+	ms.countersUpdated[name] = time.Now()
+}
+
+// getFloatCountersInternal returns a copy of the float counters without acquiring locks
+func (ms *MemStorage) getFloatCountersInternal() map[string]float64 {
+	fCopy := make(map[string]float64, len(ms.floatCounters))
+	for k, v := range ms.floatCounters {
+		fCopy[k] = v
+	}
+	return fCopy
+}
+
+// setFloatCounter sets a float counter to value rather than adding to it
+func (ms *MemStorage) setFloatCounter(name string, value float64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ms.admitLocked(models.FloatCounterType, name) {
+		return
+	}
+	ms.version.Add(1)
+	ms.floatCounters[name] = value
+	ms.floatCountersUpdated[name] = time.Now()
+}
+
+// setCounter sets a counter to value rather than adding to it
+func (ms *MemStorage) setCounter(name string, value int64) {
+	ms.mu.Lock()
+	defer ms.mu.Unlock()
+
+	if !ms.admitLocked(models.CounterType, name) {
+		return
+	}
+	ms.version.Add(1)
+	if ms.sharded != nil {
+		ms.sharded.set(name, value)
+		return
+	}
+	ms.counters[name] = value
+	ms.countersUpdated[name] = time.Now()
+}
+
 // saveToFileInternal saves to file without acquiring locks
 // This method assumes the caller already holds the appropriate locks
 func (ms *MemStorage) saveToFileInternal() {
 	if ms.fileManager != nil {
 		gauges, counters := ms.getAllInternal()
-		ms.fileManager.SaveToFileWithData(gauges, counters)
+		ms.fileManager.saveData(gauges, counters, ms.getFloatCountersInternal())
 	}
 }
 
@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/mutualEvg/metrics-server/internal/models"
+)
+
+func TestMemStorage_UpdateBatch(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []MemStorageOption
+	}{
+		{name: "plain"},
+		{name: "sharded", opts: []MemStorageOption{WithShardedCounters(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMemStorage(tc.opts...)
+			ms.UpdateCounter("PollCount", 1)
+
+			value, delta := 1.5, int64(2)
+			err := ms.UpdateBatch([]models.Metrics{
+				{ID: "Alloc", MType: "gauge", Value: &value},
+				{ID: "PollCount", MType: "counter", Delta: &delta},
+				{ID: "PollCount", MType: "counter", Delta: &delta},
+			})
+			if err != nil {
+				t.Fatalf("UpdateBatch failed: %v", err)
+			}
+			if v, _ := ms.GetGauge("Alloc"); v != 1.5 {
+				t.Errorf("Expected Alloc 1.5, got %v", v)
+			}
+			if v, _ := ms.GetCounter("PollCount"); v != 5 {
+				t.Errorf("Expected PollCount 5, got %d", v)
+			}
+		})
+	}
+}
+
+func TestMemStorage_UpdateBatchNoPartialMutation(t *testing.T) {
+	ms := NewMemStorage()
+	ms.UpdateGauge("Alloc", 1)
+	ms.UpdateCounter("PollCount", 1)
+
+	value, delta := 2.0, int64(10)
+	err := ms.UpdateBatch([]models.Metrics{
+		{ID: "Alloc", MType: "gauge", Value: &value},
+		{ID: "PollCount", MType: "counter", Delta: &delta},
+		{ID: "Fresh", MType: "gauge", Value: &value},
+		{ID: "Broken", MType: "counter"}, // missing delta
+	})
+	if !errors.Is(err, ErrInvalidMetric) {
+		t.Fatalf("Expected ErrInvalidMetric, got %v", err)
+	}
+
+	if v, _ := ms.GetGauge("Alloc"); v != 1 {
+		t.Errorf("Expected Alloc to stay 1, got %v", v)
+	}
+	if v, _ := ms.GetCounter("PollCount"); v != 1 {
+		t.Errorf("Expected PollCount to stay 1, got %d", v)
+	}
+	if _, ok := ms.GetGauge("Fresh"); ok {
+		t.Error("Expected Fresh not to be stored")
+	}
+}
+
+func TestMemStorage_Version(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []MemStorageOption
+	}{
+		{name: "plain"},
+		{name: "sharded", opts: []MemStorageOption{WithShardedCounters(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMemStorage(tc.opts...)
+			last := ms.Version()
+
+			value, delta := 1.5, int64(2)
+			writes := map[string]func(){
+				"UpdateGauge":   func() { ms.UpdateGauge("Alloc", 1) },
+				"UpdateCounter": func() { ms.UpdateCounter("PollCount", 1) },
+				"UpdateBatch": func() {
+					ms.UpdateBatch([]models.Metrics{
+						{ID: "Alloc", MType: "gauge", Value: &value},
+						{ID: "PollCount", MType: "counter", Delta: &delta},
+					})
+				},
+				"LoadSnapshot": func() { ms.LoadSnapshot(map[string]float64{"Alloc": 2}, nil, nil) },
+			}
+			for name, write := range writes {
+				write()
+				if v := ms.Version(); v == last {
+					t.Errorf("Expected %s to change the version", name)
+				} else {
+					last = v
+				}
+			}
+
+			ms.GetAll()
+			ms.GetGauge("Alloc")
+			if v := ms.Version(); v != last {
+				t.Errorf("Expected reads to keep the version, got %d, want %d", v, last)
+			}
+
+			// A rejected batch changes nothing
+			if err := ms.UpdateBatch([]models.Metrics{{ID: "Broken", MType: "counter"}}); err == nil {
+				t.Fatal("Expected the batch to be rejected")
+			}
+			if v := ms.Version(); v != last {
+				t.Errorf("Expected a rejected batch to keep the version, got %d, want %d", v, last)
+			}
+		})
+	}
+}
+
+func TestMemStorage_LastUpdated(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []MemStorageOption
+	}{
+		{name: "plain"},
+		{name: "sharded", opts: []MemStorageOption{WithShardedCounters(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMemStorage(tc.opts...)
+			if _, ok := ms.LastUpdated("gauge", "Alloc"); ok {
+				t.Error("Expected no timestamp for a metric never written")
+			}
+
+			// Each write moves the timestamp of the written metric forward
+			var last time.Time
+			value, delta := 1.5, int64(2)
+			writes := []struct {
+				name  string
+				mtype string
+				id    string
+				write func()
+			}{
+				{"UpdateGauge", "gauge", "Alloc", func() { ms.UpdateGauge("Alloc", 1) }},
+				{"UpdateCounter", "counter", "PollCount", func() { ms.UpdateCounter("PollCount", 1) }},
+				{"UpdateBatch gauge", "gauge", "Alloc", func() {
+					ms.UpdateBatch([]models.Metrics{{ID: "Alloc", MType: "gauge", Value: &value}})
+				}},
+				{"UpdateBatch counter", "counter", "PollCount", func() {
+					ms.UpdateBatch([]models.Metrics{{ID: "PollCount", MType: "counter", Delta: &delta}})
+				}},
+				{"setCounter", "counter", "PollCount", func() { ms.setCounter("PollCount", 7) }},
+				{"LoadSnapshot", "counter", "Restored", func() {
+					ms.LoadSnapshot(nil, map[string]int64{"Restored": 3}, nil)
+				}},
+			}
+			for _, w := range writes {
+				time.Sleep(time.Millisecond)
+				w.write()
+				updated, ok := ms.LastUpdated(w.mtype, w.id)
+				if !ok || !updated.After(last) {
+					t.Errorf("Expected %s to record a newer timestamp than %v, got %v (found %v)", w.name, last, updated, ok)
+				}
+				last = updated
+			}
+
+			if _, ok := ms.LastUpdated("gauge", "Alloc"); ok {
+				t.Error("Expected LoadSnapshot to drop timestamps of metrics it replaced")
+			}
+			if _, ok := ms.LastUpdated("histogram", "Restored"); ok {
+				t.Error("Expected no timestamp for an unknown type")
+			}
+		})
+	}
+}
+
+func TestMemStorage_Reset(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []MemStorageOption
+	}{
+		{name: "plain"},
+		{name: "sharded", opts: []MemStorageOption{WithShardedCounters(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMemStorage(tc.opts...)
+			ms.UpdateGauge("Alloc", 1.5)
+			ms.UpdateCounter("PollCount", 3)
+			version := ms.Version()
+
+			if err := ms.Reset(); err != nil {
+				t.Fatalf("Reset failed: %v", err)
+			}
+
+			gauges, counters := ms.GetAll()
+			if len(gauges) != 0 || len(counters) != 0 {
+				t.Errorf("Expected empty storage after reset, got %v and %v", gauges, counters)
+			}
+			if _, ok := ms.LastUpdated("counter", "PollCount"); ok {
+				t.Error("Expected no timestamp for a deleted metric")
+			}
+			if ms.Version() == version {
+				t.Error("Expected reset to change the version")
+			}
+
+			// Counters start from zero again
+			ms.UpdateCounter("PollCount", 2)
+			if v, _ := ms.GetCounter("PollCount"); v != 2 {
+				t.Errorf("Expected counter 2 after reset, got %d", v)
+			}
+		})
+	}
+}
+
+func TestMemStorage_MaxMetrics(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		opts []MemStorageOption
+	}{
+		{name: "plain", opts: []MemStorageOption{WithMaxMetrics(3)}},
+		{name: "sharded", opts: []MemStorageOption{WithMaxMetrics(3), WithShardedCounters(4)}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ms := NewMemStorage(tc.opts...)
+
+			// Fill up to the cap; a gauge and a counter of the same name count twice
+			ms.UpdateGauge("Alloc", 1.5)
+			ms.UpdateCounter("Alloc", 1)
+			ms.UpdateCounter("PollCount", 1)
+
+			ms.UpdateGauge("NewGauge", 1)
+			ms.UpdateCounter("NewCounter", 1)
+			if _, ok := ms.GetGauge("NewGauge"); ok {
+				t.Error("Expected a new gauge over the cap to be dropped")
+			}
+			if _, ok := ms.GetCounter("NewCounter"); ok {
+				t.Error("Expected a new counter over the cap to be dropped")
+			}
+
+			// Stored metrics can still be updated
+			ms.UpdateGauge("Alloc", 2.5)
+			ms.UpdateCounter("PollCount", 2)
+			if v, _ := ms.GetGauge("Alloc"); v != 2.5 {
+				t.Errorf("Expected the stored gauge to be updated to 2.5, got %v", v)
+			}
+			if v, _ := ms.GetCounter("PollCount"); v != 3 {
+				t.Errorf("Expected the stored counter to be updated to 3, got %d", v)
+			}
+
+			delta := int64(1)
+			existing := models.Metrics{ID: "PollCount", MType: "counter", Delta: &delta}
+			added := models.Metrics{ID: "NewCounter", MType: "counter", Delta: &delta}
+			if err := ms.CheckCapacity(existing); err != nil {
+				t.Errorf("Expected a stored metric to fit, got %v", err)
+			}
+			if err := ms.CheckCapacity(existing, added); !errors.Is(err, ErrTooManyMetrics) {
+				t.Errorf("Expected ErrTooManyMetrics from CheckCapacity, got %v", err)
+			}
+			if err := ms.UpdateBatch([]models.Metrics{existing, added}); !errors.Is(err, ErrTooManyMetrics) {
+				t.Errorf("Expected ErrTooManyMetrics from UpdateBatch, got %v", err)
+			}
+			if v, _ := ms.GetCounter("PollCount"); v != 3 {
+				t.Errorf("Expected a rejected batch to leave the storage unchanged, got %d", v)
+			}
+
+			// Deleting the metrics makes room again
+			ms.Reset()
+			if err := ms.UpdateBatch([]models.Metrics{added}); err != nil {
+				t.Errorf("Expected a new metric to fit after reset, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMemStorage_FloatCounters(t *testing.T) {
+	ms := NewMemStorage()
+	ms.UpdateCounter("Bytes", 7)
+
+	ms.UpdateFloatCounter("Bytes", 1.25)
+	ms.UpdateFloatCounter("Bytes", 2.5)
+
+	value := 0.25
+	if err := ms.UpdateBatch([]models.Metrics{{ID: "Bytes", MType: models.FloatCounterType, Value: &value}}); err != nil {
+		t.Fatalf("UpdateBatch failed: %v", err)
+	}
+
+	if v, ok := ms.GetFloatCounter("Bytes"); !ok || v != 4 {
+		t.Errorf("Expected float counter Bytes 4, got %v %v", v, ok)
+	}
+	// The integer counter of the same name is unaffected
+	if v, _ := ms.GetCounter("Bytes"); v != 7 {
+		t.Errorf("Expected counter Bytes to stay 7, got %d", v)
+	}
+	if _, ok := ms.LastUpdated(models.FloatCounterType, "Bytes"); !ok {
+		t.Error("Expected an update time for the float counter")
+	}
+	if _, ok := ms.GetFloatCounter("Missing"); ok {
+		t.Error("Expected Missing not to be found")
+	}
+	if all := ms.GetAllFloatCounters(); len(all) != 1 || all["Bytes"] != 4 {
+		t.Errorf("Expected only Bytes 4 among all float counters, got %v", all)
+	}
+
+	// A snapshot replaces the float counters as well
+	snapshot := NewMemStorage()
+	snapshot.UpdateFloatCounter("Stale", 1)
+	snapshot.LoadSnapshot(nil, nil, map[string]float64{"Bytes": 9.5})
+	if all := snapshot.GetAllFloatCounters(); len(all) != 1 || all["Bytes"] != 9.5 {
+		t.Errorf("Expected the snapshot's float counters, got %v", all)
+	}
+
+	// Float counters count towards the metric cap
+	limited := NewMemStorage(WithMaxMetrics(1))
+	limited.UpdateFloatCounter("Bytes", 1)
+	limited.UpdateFloatCounter("Other", 1)
+	if _, ok := limited.GetFloatCounter("Other"); ok {
+		t.Error("Expected Other to be dropped over the cap")
+	}
+
+	if err := ms.Reset(); err != nil {
+		t.Fatalf("Reset failed: %v", err)
+	}
+	if _, ok := ms.GetFloatCounter("Bytes"); ok {
+		t.Error("Expected Reset to delete float counters")
+	}
+}